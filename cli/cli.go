@@ -0,0 +1,128 @@
+// Package cli runs the administration commands of the binary, e.g. one-api channel list,
+// against the admin API of a gateway, so it is managed from scripts and CI. The gateway and
+// the access token of an admin are given by --server and --token, or by ONE_API_SERVER and
+// ONE_API_TOKEN.
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, out io.Writer) error
+}
+
+var commands = map[string]map[string]command{
+	"channel": {
+		"list": {"channel list [--json]", channelList},
+		"add":  {"channel add --name <name> --type <type> --key <key> --models <models> [--base-url <url>] [--group <group>] [--priority <n>] [--weight <n>] [--model-mapping <json>]", channelAdd},
+		"test": {"channel test <id> [--model <model>]", channelTest},
+	},
+	"token": {
+		"create": {"token create --name <name> [--quota <quota> | --unlimited] [--expires <duration>] [--models <models>]", tokenCreate},
+	},
+	"usage": {
+		"report": {"usage report [--since <time>] [--until <time>] [--user <username>] [--token-name <name>] [--model <model>] [--channel <id>] [--json]", usageReport},
+	},
+	"cache": {
+		"clear": {"cache clear [--type all|exact|semantic]", cacheClear},
+	},
+	"config": {
+		"export": {"config export [--format yaml|json] [--output <file>]", configExport},
+		"apply":  {"config apply <file|-> [--dry-run]", configApply},
+	},
+}
+
+// IsCommand tells whether the first argument of the binary is an administration command
+func IsCommand(name string) bool {
+	_, ok := commands[name]
+	return ok
+}
+
+// Usage returns the usage of the administration commands
+func Usage() string {
+	var lines []string
+	for _, subcommands := range commands {
+		for _, cmd := range subcommands {
+			lines = append(lines, "  one-api "+cmd.usage)
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// Run runs an administration command and returns the exit code of the binary
+func Run(args []string) int {
+	subcommands := commands[args[0]]
+	if len(args) < 2 || subcommands[args[1]].run == nil {
+		fmt.Fprintf(os.Stderr, "usage:\n%s\n", Usage())
+		return 2
+	}
+	cmd := subcommands[args[1]]
+	if err := cmd.run(args[2:], os.Stdout); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		if _, ok := err.(usageError); ok {
+			fmt.Fprintf(os.Stderr, "%s\nusage: one-api %s\n", err.Error(), cmd.usage)
+			return 2
+		}
+		fmt.Fprintln(os.Stderr, "error: "+err.Error())
+		return 1
+	}
+	return 0
+}
+
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// flags are the flags of a command, with those of the gateway
+type flags struct {
+	*flag.FlagSet
+	server *string
+	token  *string
+}
+
+func newFlags(name string) *flags {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	server := os.Getenv("ONE_API_SERVER")
+	if server == "" {
+		server = "http://localhost:3000"
+	}
+	return &flags{
+		FlagSet: set,
+		server:  set.String("server", server, "URL of the gateway"),
+		token:   set.String("token", os.Getenv("ONE_API_TOKEN"), "access token of an admin"),
+	}
+}
+
+// parse parses the arguments, the flags may follow the positional arguments
+func (f *flags) parse(args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := f.Parse(args); err != nil {
+			if err == flag.ErrHelp {
+				return nil, err
+			}
+			return nil, usageError(err.Error())
+		}
+		if f.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, f.Arg(0))
+		args = f.Args()[1:]
+	}
+}
+
+func (f *flags) client() *client {
+	return newClient(*f.server, *f.token)
+}
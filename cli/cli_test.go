@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelListReadsAllPages(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch r.URL.Query().Get("p") {
+		case "0":
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"id":2,"name":"azure","type":3,"status":1,"group":"default","models":"gpt-4o"}]}`))
+		case "1":
+			_, _ = w.Write([]byte(`{"success":true,"data":[{"id":1,"name":"openai","type":1,"status":3,"group":"vip","models":"gpt-4o-mini"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"success":true,"data":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := channelList([]string{"--server", server.URL, "--token", "secret"}, &out)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "azure")
+	assert.Contains(t, out.String(), "auto-disabled")
+}
+
+func TestCallReturnsAPIErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"success":false,"message":"no permission"}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	err := tokenCreate([]string{"--name", "ci", "--server", server.URL}, &out)
+	assert.EqualError(t, err, "no permission")
+	err = tokenCreate([]string{"--server", server.URL}, &out)
+	assert.IsType(t, usageError(""), err)
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	since, err := parseTime("7d", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, -7), since)
+	since, err = parseTime("90m", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-90*time.Minute), since)
+	since, err = parseTime("2024-05-01", now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local), since)
+	_, err = parseTime("yesterday", now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,105 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client calls the admin API of a gateway with the access token of a user
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+func newClient(server string, token string) *client {
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// apiResponse is the envelope of the admin API responses
+type apiResponse struct {
+	Success bool            `json:"success"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// raw sends a request and returns the body of its response
+func (c *client) raw(method string, path string, query url.Values, contentType string, body io.Reader) ([]byte, error) {
+	target := c.server + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var response apiResponse
+		if json.Unmarshal(data, &response) == nil && response.Message != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, response.Message)
+		}
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// call sends a request with a JSON body, if any, and decodes the data of its response into
+// result, if any. The whole response is returned for the endpoints answering beside the data.
+func (c *client) call(method string, path string, query url.Values, body any, result any) (*apiResponse, error) {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+	data, err := c.raw(method, path, query, contentType, reader)
+	if err != nil {
+		return nil, err
+	}
+	var response apiResponse
+	if err = json.Unmarshal(data, &response); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	if !response.Success {
+		if response.Message == "" {
+			response.Message = "the request failed"
+		}
+		return &response, errors.New(response.Message)
+	}
+	if result != nil && len(response.Data) > 0 {
+		if err = json.Unmarshal(response.Data, result); err != nil {
+			return &response, fmt.Errorf("invalid response: %w", err)
+		}
+	}
+	return &response, nil
+}
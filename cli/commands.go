@@ -0,0 +1,359 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func printJSON(out io.Writer, v any) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func channelStatusName(status int) string {
+	switch status {
+	case model.ChannelStatusEnabled:
+		return "enabled"
+	case model.ChannelStatusManuallyDisabled:
+		return "disabled"
+	case model.ChannelStatusAutoDisabled:
+		return "auto-disabled"
+	default:
+		return "unknown"
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+func channelList(args []string, out io.Writer) error {
+	f := newFlags("channel list")
+	asJSON := f.Bool("json", false, "print the channels as JSON")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	c := f.client()
+	channels := make([]model.Channel, 0)
+	for p := 0; ; p++ {
+		var page []model.Channel
+		if _, err := c.call(http.MethodGet, "/api/channel/", url.Values{"p": {strconv.Itoa(p)}}, nil, &page); err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break
+		}
+		channels = append(channels, page...)
+	}
+	if *asJSON {
+		return printJSON(out, channels)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTYPE\tSTATUS\tGROUP\tMODELS\tPRIORITY\tRESPONSE_TIME")
+	for _, channel := range channels {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\t%s\t%d\t%dms\n", channel.Id, channel.Name, channel.Type,
+			channelStatusName(channel.Status), channel.Group, truncate(channel.Models, 40),
+			channel.GetPriority(), channel.ResponseTime)
+	}
+	return w.Flush()
+}
+
+func channelAdd(args []string, out io.Writer) error {
+	f := newFlags("channel add")
+	name := f.String("name", "", "name of the channel")
+	channelType := f.Int("type", 0, "type of the channel, e.g. 1 for OpenAI")
+	key := f.String("key", "", "key of the channel, one channel is added per line")
+	baseURL := f.String("base-url", "", "base URL of the upstream")
+	models := f.String("models", "", "comma separated models of the channel")
+	group := f.String("group", "default", "comma separated groups of the channel")
+	priority := f.Int64("priority", 0, "priority of the channel")
+	weight := f.Uint("weight", 0, "weight of the channel")
+	modelMapping := f.String("model-mapping", "", "model mapping of the channel, as JSON")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	if *name == "" || *channelType == 0 || *key == "" || *models == "" {
+		return usageError("--name, --type, --key and --models are required")
+	}
+	channel := model.Channel{
+		Type:         *channelType,
+		Key:          *key,
+		Name:         *name,
+		Weight:       weight,
+		BaseURL:      baseURL,
+		Models:       *models,
+		Group:        *group,
+		ModelMapping: modelMapping,
+		Priority:     priority,
+	}
+	if _, err := f.client().call(http.MethodPost, "/api/channel/", nil, channel, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "channel %s added\n", *name)
+	return nil
+}
+
+func channelTest(args []string, out io.Writer) error {
+	f := newFlags("channel test")
+	modelName := f.String("model", "", "model to test, the test model of the channel by default")
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("the id of the channel is required")
+	}
+	if _, err = strconv.Atoi(positional[0]); err != nil {
+		return usageError("invalid channel id " + positional[0])
+	}
+	query := url.Values{}
+	if *modelName != "" {
+		query.Set("model", *modelName)
+	}
+	data, err := f.client().raw(http.MethodGet, "/api/channel/test/"+positional[0], query, "", nil)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Success   bool    `json:"success"`
+		Message   string  `json:"message"`
+		Time      float64 `json:"time"`
+		ModelName string  `json:"modelName"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("channel test failed: %s", result.Message)
+	}
+	fmt.Fprintf(out, "channel %s passed with %s in %.2fs\n", positional[0], result.ModelName, result.Time)
+	return nil
+}
+
+func tokenCreate(args []string, out io.Writer) error {
+	f := newFlags("token create")
+	name := f.String("name", "", "name of the token")
+	quota := f.Int64("quota", 0, "quota of the token")
+	unlimited := f.Bool("unlimited", false, "give the token an unlimited quota")
+	expires := f.Duration("expires", 0, "time until the token expires, e.g. 720h, never by default")
+	models := f.String("models", "", "comma separated models the token may use, all by default")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	if *name == "" {
+		return usageError("--name is required")
+	}
+	token := model.Token{
+		Name:           *name,
+		ExpiredTime:    -1,
+		RemainQuota:    *quota,
+		UnlimitedQuota: *unlimited,
+		Models:         models,
+	}
+	if *expires > 0 {
+		token.ExpiredTime = time.Now().Add(*expires).Unix()
+	}
+	var created model.Token
+	if _, err := f.client().call(http.MethodPost, "/api/token/", nil, token, &created); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "sk-"+created.Key)
+	return nil
+}
+
+// parseTime parses a time given as a duration before now, e.g. 24h or 7d, or as a date
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, use a duration such as 24h or 7d, or a date such as 2006-01-02", s)
+}
+
+func usageReport(args []string, out io.Writer) error {
+	f := newFlags("usage report")
+	since := f.String("since", "24h", "start of the report, a duration before now or a date")
+	until := f.String("until", "", "end of the report, a duration before now or a date, now by default")
+	username := f.String("user", "", "username to report on")
+	tokenName := f.String("token-name", "", "token to report on")
+	modelName := f.String("model", "", "model to report on")
+	channel := f.Int("channel", 0, "channel to report on")
+	asJSON := f.Bool("json", false, "print the report as JSON")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	now := time.Now()
+	start, err := parseTime(*since, now)
+	if err != nil {
+		return usageError(err.Error())
+	}
+	end := now
+	if *until != "" {
+		if end, err = parseTime(*until, now); err != nil {
+			return usageError(err.Error())
+		}
+	}
+	query := url.Values{
+		"type":            {strconv.Itoa(model.LogTypeConsume)},
+		"start_timestamp": {strconv.FormatInt(start.Unix(), 10)},
+		"end_timestamp":   {strconv.FormatInt(end.Unix(), 10)},
+		"username":        {*username},
+		"token_name":      {*tokenName},
+		"model_name":      {*modelName},
+	}
+	if *channel != 0 {
+		query.Set("channel", strconv.Itoa(*channel))
+	}
+	var stat struct {
+		Quota           int64 `json:"quota"`
+		CachedTokens    int64 `json:"cached_tokens"`
+		CacheSavedQuota int64 `json:"cache_saved_quota"`
+	}
+	if _, err = f.client().call(http.MethodGet, "/api/log/stat", query, nil, &stat); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(out, stat)
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "period\t%s - %s\n", start.Format("2006-01-02 15:04:05"), end.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "quota used\t%d\n", stat.Quota)
+	fmt.Fprintf(w, "cached tokens\t%d\n", stat.CachedTokens)
+	fmt.Fprintf(w, "quota saved by cache\t%d\n", stat.CacheSavedQuota)
+	return w.Flush()
+}
+
+func cacheClear(args []string, out io.Writer) error {
+	f := newFlags("cache clear")
+	cacheType := f.String("type", "all", "cache to clear, all, exact or semantic")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	switch *cacheType {
+	case "all", "exact", "semantic":
+	default:
+		return usageError("invalid cache type " + *cacheType)
+	}
+	data, err := f.client().raw(http.MethodPost, "/api/cache/clear", nil, "application/json",
+		strings.NewReader(fmt.Sprintf(`{"type":%q}`, *cacheType)))
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Success      bool   `json:"success"`
+		Message      string `json:"message"`
+		Cleared      int64  `json:"cleared"`
+		ExactCleared int64  `json:"exact_cleared"`
+	}
+	if err = json.Unmarshal(data, &result); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("failed to clear the cache: %s", result.Message)
+	}
+	fmt.Fprintf(out, "%d cache entries cleared\n", result.Cleared+result.ExactCleared)
+	return nil
+}
+
+func configExport(args []string, out io.Writer) error {
+	f := newFlags("config export")
+	format := f.String("format", "yaml", "format of the config, yaml or json")
+	output := f.String("output", "", "file to write the config to, stdout by default")
+	if _, err := f.parse(args); err != nil {
+		return err
+	}
+	if *format != "yaml" && *format != "json" {
+		return usageError("invalid format " + *format)
+	}
+	data, err := f.client().raw(http.MethodGet, "/api/config/export", url.Values{"format": {*format}}, "", nil)
+	if err != nil {
+		return err
+	}
+	// the export fails with a JSON message instead of the file
+	var response apiResponse
+	if json.Unmarshal(data, &response) == nil && !response.Success && response.Message != "" {
+		return fmt.Errorf("failed to export the config: %s", response.Message)
+	}
+	if *output != "" {
+		return os.WriteFile(*output, data, 0600)
+	}
+	_, err = out.Write(data)
+	return err
+}
+
+func configApply(args []string, out io.Writer) error {
+	f := newFlags("config apply")
+	dryRun := f.Bool("dry-run", false, "print the changes without making them")
+	positional, err := f.parse(args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError("the config file is required")
+	}
+	var data []byte
+	if positional[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(positional[0])
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read the config: %w", err)
+	}
+	query := url.Values{}
+	if *dryRun {
+		query.Set("dry_run", "true")
+	}
+	body, err := f.client().raw(http.MethodPost, "/api/config/apply", query, "application/yaml", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	var response apiResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if !response.Success {
+		return fmt.Errorf("failed to apply the config: %s", response.Message)
+	}
+	var changes []model.ConfigChange
+	if err = json.Unmarshal(response.Data, &changes); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	for _, change := range changes {
+		line := fmt.Sprintf("%s %s %s", change.Action, change.Kind, change.Name)
+		if len(change.Fields) > 0 {
+			line += " (" + strings.Join(change.Fields, ", ") + ")"
+		}
+		fmt.Fprintln(out, line)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "no changes")
+	} else if *dryRun {
+		fmt.Fprintf(out, "%d changes planned, none made\n", len(changes))
+	}
+	return nil
+}
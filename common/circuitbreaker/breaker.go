@@ -397,6 +397,9 @@ func (m *BreakerManager) Stats() map[string]map[string]interface{} {
 // Global channel circuit breaker manager
 var channelBreakerManager *BreakerManager
 
+// OnChannelStateChange is called when a channel circuit breaker changes state
+var OnChannelStateChange func(name string, from State, to State)
+
 // GetChannelBreakerManager returns the global channel circuit breaker manager
 func GetChannelBreakerManager() *BreakerManager {
 	if channelBreakerManager == nil {
@@ -406,8 +409,9 @@ func GetChannelBreakerManager() *BreakerManager {
 			s.Timeout = 30 * time.Second
 			s.SuccessThreshold = 2
 			s.OnStateChange = func(name string, from State, to State) {
-				// Log state changes
-				// Can be enhanced to send alerts
+				if OnChannelStateChange != nil {
+					OnChannelStateChange(name, from, to)
+				}
 			}
 			return s
 		})
@@ -0,0 +1,89 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// BreakerState is the persisted state of a circuit breaker
+type BreakerState struct {
+	State           State     `json:"state"`
+	Counts          Counts    `json:"counts"`
+	LastStateChange time.Time `json:"last_state_change"`
+	LastFailure     time.Time `json:"last_failure"`
+}
+
+func (cb *CircuitBreaker) snapshot() BreakerState {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+	return BreakerState{
+		State:           cb.State(),
+		Counts:          cb.counts,
+		LastStateChange: cb.lastStateChange,
+		LastFailure:     cb.lastFailure,
+	}
+}
+
+// restore applies a persisted state, an open breaker stays open until its
+// timeout counted from the persisted state change
+func (cb *CircuitBreaker) restore(state BreakerState) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if state.State == StateHalfOpen {
+		state.State = StateOpen
+	}
+	atomic.StoreInt32(&cb.state, int32(state.State))
+	atomic.StoreInt32(&cb.halfOpenCount, 0)
+	cb.counts = state.Counts
+	cb.lastStateChange = state.LastStateChange
+	cb.lastFailure = state.LastFailure
+}
+
+// Snapshot returns the state of the breakers which are not closed
+func (m *BreakerManager) Snapshot() map[string]BreakerState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	states := make(map[string]BreakerState)
+	for name, cb := range m.breakers {
+		if cb.State() != StateClosed {
+			states[name] = cb.snapshot()
+		}
+	}
+	return states
+}
+
+// Restore applies the states of a snapshot
+func (m *BreakerManager) Restore(states map[string]BreakerState) {
+	for name, state := range states {
+		m.Get(name).restore(state)
+	}
+}
+
+// SaveState writes the snapshot to path
+func (m *BreakerManager) SaveState(path string) error {
+	data, err := json.Marshal(m.Snapshot())
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// LoadState restores the snapshot written by SaveState
+func (m *BreakerManager) LoadState(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var states map[string]BreakerState
+	if err = json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	m.Restore(states)
+	return nil
+}
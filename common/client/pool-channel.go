@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// TLSOptions configures mutual TLS and private CAs for a channel, values are PEM encoded
+type TLSOptions struct {
+	ClientCert         string `json:"client_cert,omitempty"`
+	ClientKey          string `json:"client_key,omitempty"` // encrypted at rest in the channel config
+	CABundle           string `json:"ca_bundle,omitempty"`  // replaces the system roots when set
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty"`
+}
+
+// ChannelOptions are the channel settings that need a transport of their own
+type ChannelOptions struct {
+	Proxy        string
+	TLS          *TLSOptions
+	LocalAddress string // source IP or interface name of outbound connections
+}
+
+// dedicated reports whether the options can't be shared with other channels
+func (o ChannelOptions) dedicated() bool {
+	return o.TLS != nil
+}
+
+func (o *TLSOptions) fingerprint() string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%t", o.ClientCert, o.ClientKey, o.CABundle, o.InsecureSkipVerify)))
+	return hex.EncodeToString(hash[:4])
+}
+
+// tlsConfig builds the client TLS config, nil options give the default one
+func (o *TLSOptions) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if o == nil {
+		return tlsConfig, nil
+	}
+	tlsConfig.InsecureSkipVerify = o.InsecureSkipVerify
+	if o.CABundle != "" {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(o.CABundle)) {
+			return nil, errors.New("ca bundle contains no valid certificate")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	if o.ClientCert != "" || o.ClientKey != "" {
+		certificate, err := tls.X509KeyPair([]byte(o.ClientCert), []byte(o.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// ValidateTLSOptions checks that the certificates and key of a channel can be loaded
+func ValidateTLSOptions(options *TLSOptions) error {
+	_, err := options.tlsConfig()
+	return err
+}
+
+// resolveLocalAddress returns the source IP of an IP literal or the first IP of an interface
+func resolveLocalAddress(localAddress string) (net.IP, error) {
+	if ip := net.ParseIP(localAddress); ip != nil {
+		return ip, nil
+	}
+	iface, err := net.InterfaceByName(localAddress)
+	if err != nil {
+		return nil, fmt.Errorf("local address must be an IP or an interface name: %w", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no usable address", localAddress)
+}
+
+// ValidateLocalAddress checks that outbound connections can be bound to localAddress
+func ValidateLocalAddress(localAddress string) error {
+	if localAddress == "" {
+		return nil
+	}
+	_, err := resolveLocalAddress(localAddress)
+	return err
+}
+
+// bindLocalAddress makes the dialer use the source address of the channel. The
+// dialed family is restricted to the one of the source address, otherwise Happy
+// Eyeballs would race connections that can never be established.
+func bindLocalAddress(dialer *net.Dialer, localAddress string, dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if localAddress == "" {
+		return dial
+	}
+	ip, err := resolveLocalAddress(localAddress)
+	if err != nil {
+		return func(context.Context, string, string) (net.Conn, error) {
+			return nil, fmt.Errorf("bind local address %s failed: %w", localAddress, err)
+		}
+	}
+	dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	family := "tcp6"
+	if ip.To4() != nil {
+		family = "tcp4"
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			network = family
+		}
+		return dial(ctx, network, addr)
+	}
+}
@@ -0,0 +1,205 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// PoolSettings overrides part of a ProviderConfig, nil fields keep the base value.
+// Durations are in seconds.
+type PoolSettings struct {
+	MaxIdleConns        *int    `json:"max_idle_conns,omitempty"`
+	MaxIdleConnsPerHost *int    `json:"max_idle_conns_per_host,omitempty"`
+	MaxConnsPerHost     *int    `json:"max_conns_per_host,omitempty"`
+	IdleConnTimeout     *int    `json:"idle_conn_timeout,omitempty"`
+	ResponseTimeout     *int    `json:"response_timeout,omitempty"`
+	TLSHandshakeTimeout *int    `json:"tls_handshake_timeout,omitempty"`
+	KeepAlive           *int    `json:"keep_alive,omitempty"`
+	DisableKeepAlives   *bool   `json:"disable_keep_alives,omitempty"`
+	DialTimeout         *int    `json:"dial_timeout,omitempty"`
+	StreamIdleTimeout   *int    `json:"stream_idle_timeout,omitempty"`
+	MaxDuration         *int    `json:"max_duration,omitempty"`
+	EnableHTTP3         *bool   `json:"enable_http3,omitempty"`
+	WarmConns           *int    `json:"warm_conns,omitempty"`
+	WarmURL             *string `json:"warm_url,omitempty"`
+	WarmInterval        *int    `json:"warm_interval,omitempty"`
+}
+
+// PoolConfig holds the runtime overrides, stored as the ConnectionPoolConfig option
+type PoolConfig struct {
+	Providers map[string]PoolSettings `json:"providers"`
+	Channels  map[string]PoolSettings `json:"channels"` // keyed by channel id
+}
+
+var poolConfigLock sync.RWMutex
+var poolConfig = PoolConfig{
+	Providers: map[string]PoolSettings{},
+	Channels:  map[string]PoolSettings{},
+}
+
+func (s PoolSettings) validate() error {
+	for name, value := range map[string]*int{
+		"max_idle_conns":          s.MaxIdleConns,
+		"max_idle_conns_per_host": s.MaxIdleConnsPerHost,
+		"max_conns_per_host":      s.MaxConnsPerHost,
+		"idle_conn_timeout":       s.IdleConnTimeout,
+		"response_timeout":        s.ResponseTimeout,
+		"tls_handshake_timeout":   s.TLSHandshakeTimeout,
+		"keep_alive":              s.KeepAlive,
+		"dial_timeout":            s.DialTimeout,
+		"stream_idle_timeout":     s.StreamIdleTimeout,
+		"max_duration":            s.MaxDuration,
+		"warm_conns":              s.WarmConns,
+		"warm_interval":           s.WarmInterval,
+	} {
+		if value != nil && *value < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	if s.WarmURL != nil && *s.WarmURL != "" {
+		warmURL, err := url.Parse(*s.WarmURL)
+		if err != nil || (warmURL.Scheme != "http" && warmURL.Scheme != "https") || warmURL.Host == "" {
+			return errors.New("warm_url must be an http or https url")
+		}
+	}
+	return nil
+}
+
+func (s PoolSettings) apply(cfg ProviderConfig) ProviderConfig {
+	if s.MaxIdleConns != nil {
+		cfg.MaxIdleConns = *s.MaxIdleConns
+	}
+	if s.MaxIdleConnsPerHost != nil {
+		cfg.MaxIdleConnsPerHost = *s.MaxIdleConnsPerHost
+	}
+	if s.MaxConnsPerHost != nil {
+		cfg.MaxConnsPerHost = *s.MaxConnsPerHost
+	}
+	if s.IdleConnTimeout != nil {
+		cfg.IdleConnTimeout = time.Duration(*s.IdleConnTimeout) * time.Second
+	}
+	if s.ResponseTimeout != nil {
+		cfg.ResponseTimeout = time.Duration(*s.ResponseTimeout) * time.Second
+	}
+	if s.TLSHandshakeTimeout != nil {
+		cfg.TLSHandshakeTimeout = time.Duration(*s.TLSHandshakeTimeout) * time.Second
+	}
+	if s.KeepAlive != nil {
+		cfg.KeepAlive = time.Duration(*s.KeepAlive) * time.Second
+	}
+	if s.DisableKeepAlives != nil {
+		cfg.DisableKeepAlives = *s.DisableKeepAlives
+	}
+	if s.DialTimeout != nil {
+		cfg.DialTimeout = time.Duration(*s.DialTimeout) * time.Second
+	}
+	if s.StreamIdleTimeout != nil {
+		cfg.StreamIdleTimeout = time.Duration(*s.StreamIdleTimeout) * time.Second
+	}
+	if s.MaxDuration != nil {
+		cfg.MaxDuration = time.Duration(*s.MaxDuration) * time.Second
+	}
+	if s.EnableHTTP3 != nil {
+		cfg.EnableHTTP3 = *s.EnableHTTP3
+	}
+	if s.WarmConns != nil {
+		cfg.WarmConns = *s.WarmConns
+	}
+	if s.WarmURL != nil {
+		cfg.WarmURL = *s.WarmURL
+	}
+	if s.WarmInterval != nil {
+		cfg.WarmInterval = time.Duration(*s.WarmInterval) * time.Second
+	}
+	return cfg
+}
+
+// effectiveConfig merges the built-in provider config with the runtime overrides,
+// a channel id of 0 means no channel override
+func effectiveConfig(providerName string, channelId int) ProviderConfig {
+	cfg, ok := providerConfigs[providerName]
+	if !ok {
+		cfg = DefaultProviderConfig(providerName)
+	}
+	poolConfigLock.RLock()
+	defer poolConfigLock.RUnlock()
+	if settings, ok := poolConfig.Providers[providerName]; ok {
+		cfg = settings.apply(cfg)
+	}
+	if channelId != 0 {
+		if settings, ok := poolConfig.Channels[strconv.Itoa(channelId)]; ok {
+			cfg = settings.apply(cfg)
+		}
+	}
+	return cfg
+}
+
+// hasChannelOverride reports whether the channel needs a dedicated pool
+func hasChannelOverride(channelId int) bool {
+	poolConfigLock.RLock()
+	defer poolConfigLock.RUnlock()
+	_, ok := poolConfig.Channels[strconv.Itoa(channelId)]
+	return ok
+}
+
+func GetPoolConfig() PoolConfig {
+	poolConfigLock.RLock()
+	defer poolConfigLock.RUnlock()
+	return poolConfig
+}
+
+func PoolConfig2JSONString() string {
+	jsonBytes, err := json.Marshal(GetPoolConfig())
+	if err != nil {
+		logger.SysError("error marshalling pool config: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ValidatePoolConfigJSONString parses the pool config without applying it
+func ValidatePoolConfigJSONString(jsonStr string) (*PoolConfig, error) {
+	newConfig := &PoolConfig{}
+	if err := json.Unmarshal([]byte(jsonStr), newConfig); err != nil {
+		return nil, err
+	}
+	if newConfig.Providers == nil {
+		newConfig.Providers = map[string]PoolSettings{}
+	}
+	if newConfig.Channels == nil {
+		newConfig.Channels = map[string]PoolSettings{}
+	}
+	for name, settings := range newConfig.Providers {
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("provider %s: %w", name, err)
+		}
+	}
+	for id, settings := range newConfig.Channels {
+		if _, err := strconv.Atoi(id); err != nil {
+			return nil, errors.New("channel key must be a channel id: " + id)
+		}
+		if err := settings.validate(); err != nil {
+			return nil, fmt.Errorf("channel %s: %w", id, err)
+		}
+	}
+	return newConfig, nil
+}
+
+// UpdatePoolConfigByJSONString applies new overrides and rebuilds the affected pools
+func UpdatePoolConfigByJSONString(jsonStr string) error {
+	newConfig, err := ValidatePoolConfigJSONString(jsonStr)
+	if err != nil {
+		return err
+	}
+	poolConfigLock.Lock()
+	poolConfig = *newConfig
+	poolConfigLock.Unlock()
+	GetPoolManager().Reload()
+	return nil
+}
@@ -0,0 +1,246 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+type dnsCacheEntry struct {
+	ips     []net.IP
+	err     error
+	expires time.Time
+}
+
+// dnsCache resolves upstream hosts once per TTL for all pools,
+// failed lookups are cached for a shorter negative TTL
+type dnsCache struct {
+	resolver *net.Resolver
+	group    singleflight.Group
+
+	mu      sync.RWMutex
+	entries map[string]*dnsCacheEntry
+
+	hits         atomic.Int64
+	misses       atomic.Int64
+	negativeHits atomic.Int64
+	lookups      phaseStats
+}
+
+var upstreamDNSCache = &dnsCache{
+	resolver: net.DefaultResolver,
+	entries:  make(map[string]*dnsCacheEntry),
+}
+
+func (d *dnsCache) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	d.mu.RLock()
+	entry, ok := d.entries[host]
+	d.mu.RUnlock()
+	if ok && time.Now().Before(entry.expires) {
+		if entry.err != nil {
+			d.negativeHits.Add(1)
+			return nil, entry.err
+		}
+		d.hits.Add(1)
+		return entry.ips, nil
+	}
+	d.misses.Add(1)
+
+	// the lookup is shared by concurrent dials, so it must not be cancelled by one of them
+	result, err, _ := d.group.Do(host, func() (interface{}, error) {
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		start := time.Now()
+		lookupCtx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+		defer cancel()
+		addrs, err := d.resolver.LookupIPAddr(lookupCtx, host)
+		d.lookups.observe(start)
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		if trace != nil && trace.DNSDone != nil {
+			netAddrs := make([]net.IPAddr, len(addrs))
+			copy(netAddrs, addrs)
+			trace.DNSDone(httptrace.DNSDoneInfo{Addrs: netAddrs, Err: err})
+		}
+
+		entry := &dnsCacheEntry{ips: ips, err: err}
+		ttl := time.Duration(config.DNSCacheTTL) * time.Second
+		if err != nil {
+			ttl = time.Duration(config.DNSNegativeCacheTTL) * time.Second
+		}
+		if ttl > 0 {
+			entry.expires = time.Now().Add(ttl)
+			d.mu.Lock()
+			d.entries[host] = entry
+			d.mu.Unlock()
+		}
+		return ips, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]net.IP), nil
+}
+
+// DNSCacheStats is the snapshot of the upstream DNS cache
+type DNSCacheStats struct {
+	Hits         int64      `json:"hits"`
+	Misses       int64      `json:"misses"`
+	NegativeHits int64      `json:"negative_hits"`
+	Lookups      PhaseStats `json:"lookups"`
+	Entries      int        `json:"entries"`
+}
+
+func GetDNSCacheStats() DNSCacheStats {
+	upstreamDNSCache.mu.RLock()
+	entries := len(upstreamDNSCache.entries)
+	upstreamDNSCache.mu.RUnlock()
+	return DNSCacheStats{
+		Hits:         upstreamDNSCache.hits.Load(),
+		Misses:       upstreamDNSCache.misses.Load(),
+		NegativeHits: upstreamDNSCache.negativeHits.Load(),
+		Lookups:      upstreamDNSCache.lookups.snapshot(),
+		Entries:      entries,
+	}
+}
+
+// cachedDialContext resolves the host through the DNS cache and dials the
+// addresses with Happy Eyeballs, IP literals are dialed directly
+func cachedDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil || config.DNSCacheTTL <= 0 {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := upstreamDNSCache.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		ips = filterIPs(network, ips)
+		if len(ips) == 0 {
+			return nil, &net.DNSError{Err: "no suitable address found", Name: host}
+		}
+		return dialHappyEyeballs(ctx, dialer, network, ips, port, time.Duration(config.HappyEyeballsDelay)*time.Millisecond)
+	}
+}
+
+func filterIPs(network string, ips []net.IP) []net.IP {
+	if network != "tcp4" && network != "tcp6" {
+		return ips
+	}
+	filtered := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if (ip.To4() != nil) == (network == "tcp4") {
+			filtered = append(filtered, ip)
+		}
+	}
+	return filtered
+}
+
+// partitionIPs splits addresses into the family of the first one and the other family
+func partitionIPs(ips []net.IP) (primaries []net.IP, fallbacks []net.IP) {
+	primaryIsIPv4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		if (ip.To4() != nil) == primaryIsIPv4 {
+			primaries = append(primaries, ip)
+		} else {
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	return primaries, fallbacks
+}
+
+func dialSerial(ctx context.Context, dialer *net.Dialer, network string, ips []net.IP, port string) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = errors.New("no address to dial")
+	}
+	return nil, firstErr
+}
+
+// dialHappyEyeballs races the other address family after delay (RFC 8305),
+// so a broken IPv6 route costs at most delay instead of a full dial timeout
+func dialHappyEyeballs(ctx context.Context, dialer *net.Dialer, network string, ips []net.IP, port string, delay time.Duration) (net.Conn, error) {
+	primaries, fallbacks := partitionIPs(ips)
+	if len(fallbacks) == 0 || delay <= 0 {
+		return dialSerial(ctx, dialer, network, ips, port)
+	}
+
+	type dialResult struct {
+		conn net.Conn
+		err  error
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, 2)
+	dial := func(ips []net.IP) {
+		conn, err := dialSerial(ctx, dialer, network, ips, port)
+		results <- dialResult{conn: conn, err: err}
+	}
+
+	go dial(primaries)
+	pending := 1
+	fallbackStarted := false
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go dial(fallbacks)
+			}
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// the losing dial may still succeed, its connection is not needed
+				for i := 0; i < pending; i++ {
+					go func() {
+						if loser := <-results; loser.conn != nil {
+							_ = loser.conn.Close()
+						}
+					}()
+				}
+				return result.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if !fallbackStarted {
+				fallbackStarted = true
+				pending++
+				go dial(fallbacks)
+				continue
+			}
+			if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build http3
+
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// Building with -tags http3 requires github.com/quic-go/quic-go in go.mod
+func init() {
+	newHTTP3RoundTripper = func(tlsConfig *tls.Config) http.RoundTripper {
+		return &http3.Transport{
+			TLSClientConfig: tlsConfig.Clone(),
+			QUICConfig: &quic.Config{
+				KeepAlivePeriod: 15 * time.Second,
+			},
+		}
+	}
+}
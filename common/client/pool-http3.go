@@ -0,0 +1,101 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// newHTTP3RoundTripper is set when built with the http3 tag, see pool-http3-quic.go
+var newHTTP3RoundTripper func(tlsConfig *tls.Config) http.RoundTripper
+
+// http3BrokenDuration is how long a host that failed over HTTP/3 is served by HTTP/2
+const http3BrokenDuration = 5 * time.Minute
+
+var http3UnavailableOnce sync.Once
+
+// useHTTP3 reports whether a pool should try HTTP/3, QUIC cannot go through HTTP proxies
+func (m *ConnectionPoolManager) useHTTP3(cfg ProviderConfig, proxy string) bool {
+	if !cfg.EnableHTTP3 || !config.HTTP3Enabled {
+		return false
+	}
+	if newHTTP3RoundTripper == nil {
+		http3UnavailableOnce.Do(func() {
+			logger.SysError("HTTP/3 is enabled but this binary is built without the http3 tag, using HTTP/2")
+		})
+		return false
+	}
+	if proxy == DirectProxy {
+		return true
+	}
+	return proxy == "" && m.proxy == nil
+}
+
+// http3FallbackTransport sends requests over HTTP/3 and falls back to HTTP/2
+// when QUIC fails, the host then stays on HTTP/2 for http3BrokenDuration
+type http3FallbackTransport struct {
+	http3    http.RoundTripper
+	fallback http.RoundTripper
+
+	mu          sync.Mutex
+	brokenUntil map[string]time.Time
+}
+
+func newHTTP3FallbackTransport(tlsConfig *tls.Config, fallback http.RoundTripper) *http3FallbackTransport {
+	return &http3FallbackTransport{
+		http3:       newHTTP3RoundTripper(tlsConfig),
+		fallback:    fallback,
+		brokenUntil: make(map[string]time.Time),
+	}
+}
+
+func (t *http3FallbackTransport) isBroken(host string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	until, ok := t.brokenUntil[host]
+	if ok && time.Now().After(until) {
+		delete(t.brokenUntil, host)
+		return false
+	}
+	return ok
+}
+
+func (t *http3FallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// the body can only be replayed on HTTP/2 when it can be rewound
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if req.URL.Scheme != "https" || !replayable || t.isBroken(req.URL.Host) {
+		return t.fallback.RoundTrip(req)
+	}
+	resp, err := t.http3.RoundTrip(req)
+	if err == nil {
+		return resp, nil
+	}
+	if req.Context().Err() != nil {
+		return nil, err
+	}
+	logger.SysLogf("HTTP/3 request to %s failed, falling back to HTTP/2: %s", req.URL.Host, err.Error())
+	t.mu.Lock()
+	t.brokenUntil[req.URL.Host] = time.Now().Add(http3BrokenDuration)
+	t.mu.Unlock()
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return t.fallback.RoundTrip(req)
+}
+
+func (t *http3FallbackTransport) CloseIdleConnections() {
+	for _, roundTripper := range []http.RoundTripper{t.http3, t.fallback} {
+		if closer, ok := roundTripper.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+}
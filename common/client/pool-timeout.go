@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+const defaultDialTimeout = 30 * time.Second
+
+func dialTimeout(cfg ProviderConfig) time.Duration {
+	if cfg.DialTimeout > 0 {
+		return cfg.DialTimeout
+	}
+	return defaultDialTimeout
+}
+
+func streamIdleTimeout(cfg ProviderConfig) time.Duration {
+	if cfg.StreamIdleTimeout > 0 {
+		return cfg.StreamIdleTimeout
+	}
+	return time.Duration(config.RelayStreamIdleTimeout) * time.Second
+}
+
+// maxDuration falls back to RELAY_TIMEOUT, which used to be the client timeout
+func maxDuration(cfg ProviderConfig) time.Duration {
+	if cfg.MaxDuration > 0 {
+		return cfg.MaxDuration
+	}
+	return time.Duration(config.RelayTimeout) * time.Second
+}
+
+// timeoutTransport bounds each request with its own context: the whole request is
+// limited by maxDuration, and the body is aborted when the upstream stops sending
+// for streamIdleTimeout, so long but healthy streams are never cut
+type timeoutTransport struct {
+	next              http.RoundTripper
+	streamIdleTimeout time.Duration
+	maxDuration       time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if t.maxDuration > 0 {
+		ctx, cancel = context.WithTimeout(req.Context(), t.maxDuration)
+	} else {
+		ctx, cancel = context.WithCancel(req.Context())
+	}
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	body := &timeoutBody{ReadCloser: resp.Body, ctx: ctx, cancel: cancel, idleTimeout: t.streamIdleTimeout}
+	if t.streamIdleTimeout > 0 {
+		body.idleTimer = time.AfterFunc(t.streamIdleTimeout, func() {
+			body.idleExpired.Store(true)
+			cancel()
+		})
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+func (t *timeoutTransport) CloseIdleConnections() {
+	if closer, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// timeoutBody releases the request context once the body is closed
+type timeoutBody struct {
+	io.ReadCloser
+	ctx         context.Context
+	cancel      context.CancelFunc
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+	idleExpired atomic.Bool
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if b.idleTimer != nil && n > 0 {
+		b.idleTimer.Reset(b.idleTimeout)
+	}
+	if err != nil && err != io.EOF {
+		if b.idleExpired.Load() {
+			return n, fmt.Errorf("upstream sent nothing for %s: %w", b.idleTimeout, err)
+		}
+		if b.ctx.Err() == context.DeadlineExceeded {
+			return n, fmt.Errorf("upstream request exceeded its max duration: %w", err)
+		}
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	if b.idleTimer != nil {
+		b.idleTimer.Stop()
+	}
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,284 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// phaseStats accumulates the durations of one connection setup phase
+type phaseStats struct {
+	count   atomic.Int64
+	totalNs atomic.Int64
+}
+
+func (p *phaseStats) observe(start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	p.count.Add(1)
+	p.totalNs.Add(int64(time.Since(start)))
+}
+
+// PhaseStats is the snapshot of a connection setup phase
+type PhaseStats struct {
+	Count        int64   `json:"count"`
+	TotalSeconds float64 `json:"total_seconds"`
+	AvgMs        float64 `json:"avg_ms"`
+}
+
+func (p *phaseStats) snapshot() PhaseStats {
+	count := p.count.Load()
+	total := time.Duration(p.totalNs.Load())
+	stats := PhaseStats{Count: count, TotalSeconds: total.Seconds()}
+	if count > 0 {
+		stats.AvgMs = float64(total) / float64(time.Millisecond) / float64(count)
+	}
+	return stats
+}
+
+type hostConnStats struct {
+	open   atomic.Int64 // dialed connections not closed yet
+	active atomic.Int64 // requests in flight
+}
+
+// poolTrace collects httptrace statistics for a pool, it survives pool rebuilds
+type poolTrace struct {
+	newConns    atomic.Int64
+	reusedConns atomic.Int64
+	dns         phaseStats
+	connect     phaseStats
+	tls         phaseStats
+
+	warmPings    atomic.Int64 // pre-warming requests, see pool-warmer.go
+	warmFailures atomic.Int64
+	warmConns    atomic.Int64 // connections opened by pre-warming
+	warmHits     atomic.Int64 // relay requests served by a connection opened by pre-warming
+
+	hostsLock sync.Mutex
+	hosts     map[string]*hostConnStats
+}
+
+func newPoolTrace() *poolTrace {
+	return &poolTrace{hosts: make(map[string]*hostConnStats)}
+}
+
+func (t *poolTrace) host(addr string) *hostConnStats {
+	t.hostsLock.Lock()
+	defer t.hostsLock.Unlock()
+	stats, ok := t.hosts[addr]
+	if !ok {
+		stats = &hostConnStats{}
+		t.hosts[addr] = stats
+	}
+	return stats
+}
+
+// clientTrace returns the hooks recording a single request, the start times
+// are local to the request so concurrent requests do not interfere.
+// Dual-stack dials may connect to several addresses in parallel.
+// Pre-warming requests are left out of the connection reuse counts.
+func (t *poolTrace) clientTrace(warm bool) *httptrace.ClientTrace {
+	var dnsStart, tlsStart time.Time
+	var connectLock sync.Mutex
+	connectStarts := make(map[string]time.Time)
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if warm {
+				return
+			}
+			if info.Reused && takeWarmConn(info.Conn) {
+				t.warmHits.Add(1)
+			}
+			if info.Reused {
+				t.reusedConns.Add(1)
+			} else {
+				t.newConns.Add(1)
+			}
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.dns.observe(dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			connectLock.Lock()
+			connectStarts[network+addr] = time.Now()
+			connectLock.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			connectLock.Lock()
+			start := connectStarts[network+addr]
+			connectLock.Unlock()
+			if err == nil {
+				t.connect.observe(start)
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				t.tls.observe(tlsStart)
+			}
+		},
+	}
+}
+
+// dialContext wraps dial to count the open connections per dialed address
+func (t *poolTrace) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		stats := t.host(addr)
+		stats.open.Add(1)
+		tracked := &trackedConn{Conn: conn, stats: stats}
+		if isWarmRequest(ctx) {
+			tracked.warm.Store(true)
+			t.warmConns.Add(1)
+		}
+		return tracked, nil
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	stats  *hostConnStats
+	closed atomic.Bool
+	warm   atomic.Bool // opened by pre-warming and not used by a relay request yet
+}
+
+// takeWarmConn reports whether conn was opened by pre-warming and is used for the first time
+func takeWarmConn(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tracked, ok := conn.(*trackedConn)
+	return ok && tracked.warm.CompareAndSwap(true, false)
+}
+
+func (c *trackedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		c.stats.open.Add(-1)
+	}
+	return c.Conn.Close()
+}
+
+// tracingTransport instruments every request of a pool with httptrace
+type tracingTransport struct {
+	*http.Transport
+	trace *poolTrace
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.trace.clientTrace(isWarmRequest(req.Context()))))
+	stats := t.trace.host(canonicalAddr(req))
+	stats.active.Add(1)
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		stats.active.Add(-1)
+		return nil, err
+	}
+	resp.Body = &trackedBody{ReadCloser: resp.Body, stats: stats}
+	return resp, nil
+}
+
+// trackedBody ends the in-flight accounting of a request once its body is closed
+type trackedBody struct {
+	io.ReadCloser
+	stats  *hostConnStats
+	closed atomic.Bool
+}
+
+func (b *trackedBody) Close() error {
+	if b.closed.CompareAndSwap(false, true) {
+		b.stats.active.Add(-1)
+	}
+	return b.ReadCloser.Close()
+}
+
+func canonicalAddr(req *http.Request) string {
+	if req.URL.Port() != "" {
+		return req.URL.Host
+	}
+	port := "80"
+	if req.URL.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(req.URL.Hostname(), port)
+}
+
+// HostConnStats is the snapshot of the connections to one address. Idle is
+// derived from open and active connections, so it is approximate for HTTP/2
+// where several requests share a connection.
+type HostConnStats struct {
+	Open   int64 `json:"open"`
+	Active int64 `json:"active"`
+	Idle   int64 `json:"idle"`
+}
+
+// WarmStats is the snapshot of the pre-warming of a pool. The saved time is
+// estimated from the average connect and TLS durations of the pool.
+type WarmStats struct {
+	Requests     int64   `json:"requests"`
+	Failures     int64   `json:"failures"`
+	Connections  int64   `json:"connections"`
+	Hits         int64   `json:"hits"`
+	SavedSeconds float64 `json:"saved_seconds"`
+}
+
+// PoolTraceStats is the snapshot of the httptrace statistics of a pool
+type PoolTraceStats struct {
+	NewConnections    int64                    `json:"new_connections"`
+	ReusedConnections int64                    `json:"reused_connections"`
+	ReuseRatio        float64                  `json:"reuse_ratio"`
+	DNS               PhaseStats               `json:"dns"`
+	Connect           PhaseStats               `json:"connect"`
+	TLS               PhaseStats               `json:"tls"`
+	Warm              WarmStats                `json:"warm"`
+	Hosts             map[string]HostConnStats `json:"hosts"`
+}
+
+func (t *poolTrace) snapshot() PoolTraceStats {
+	stats := PoolTraceStats{
+		NewConnections:    t.newConns.Load(),
+		ReusedConnections: t.reusedConns.Load(),
+		DNS:               t.dns.snapshot(),
+		Connect:           t.connect.snapshot(),
+		TLS:               t.tls.snapshot(),
+		Hosts:             make(map[string]HostConnStats),
+	}
+	if total := stats.NewConnections + stats.ReusedConnections; total > 0 {
+		stats.ReuseRatio = float64(stats.ReusedConnections) / float64(total)
+	}
+	stats.Warm = WarmStats{
+		Requests:    t.warmPings.Load(),
+		Failures:    t.warmFailures.Load(),
+		Connections: t.warmConns.Load(),
+		Hits:        t.warmHits.Load(),
+	}
+	stats.Warm.SavedSeconds = float64(stats.Warm.Hits) * (stats.Connect.AvgMs + stats.TLS.AvgMs) / 1000
+	t.hostsLock.Lock()
+	defer t.hostsLock.Unlock()
+	for addr, host := range t.hosts {
+		open, active := host.open.Load(), host.active.Load()
+		if open == 0 && active == 0 {
+			continue
+		}
+		idle := open - active
+		if idle < 0 {
+			idle = 0
+		}
+		stats.Hosts[addr] = HostConnStats{Open: open, Active: active, Idle: idle}
+	}
+	return stats
+}
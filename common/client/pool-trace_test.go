@@ -0,0 +1,66 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoolTraceCountsReusedConnections(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	trace := newPoolTrace()
+	httpClient := (&ConnectionPoolManager{}).createClient(DefaultProviderConfig("test"), ChannelOptions{Proxy: DirectProxy}, trace)
+	for i := 0; i < 3; i++ {
+		resp, err := httpClient.Get(server.URL)
+		assert.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	stats := trace.snapshot()
+	assert.Equal(t, int64(1), stats.NewConnections)
+	assert.Equal(t, int64(2), stats.ReusedConnections)
+	assert.Equal(t, int64(1), stats.Connect.Count)
+	host := stats.Hosts[server.Listener.Addr().String()]
+	assert.Equal(t, int64(1), host.Open)
+	assert.Equal(t, int64(0), host.Active)
+	assert.Equal(t, int64(1), host.Idle)
+}
+
+func TestPoolTraceCountsWarmConnectionHits(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	cfg := DefaultProviderConfig("test")
+	cfg.WarmConns = 1
+	cfg.WarmURL = server.URL
+	trace := newPoolTrace()
+	pooled := &pooledClient{
+		client: (&ConnectionPoolManager{}).createClient(cfg, ChannelOptions{Proxy: DirectProxy}, trace),
+		cfg:    cfg,
+		trace:  trace,
+	}
+	pooled.warm()
+	for i := 0; i < 2; i++ {
+		resp, err := pooled.client.Get(server.URL)
+		assert.NoError(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+	}
+
+	stats := trace.snapshot()
+	assert.Equal(t, int64(1), stats.Warm.Requests)
+	assert.Equal(t, int64(1), stats.Warm.Connections)
+	assert.Equal(t, int64(1), stats.Warm.Hits)
+	assert.Equal(t, int64(0), stats.NewConnections)
+	assert.Equal(t, int64(2), stats.ReusedConnections)
+}
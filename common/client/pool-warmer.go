@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	defaultWarmInterval = 30 * time.Second
+	warmCheckInterval   = 5 * time.Second
+	warmRequestTimeout  = 10 * time.Second
+)
+
+type warmRequestKey struct{}
+
+func isWarmRequest(ctx context.Context) bool {
+	warm, _ := ctx.Value(warmRequestKey{}).(bool)
+	return warm
+}
+
+func warmEnabled(cfg ProviderConfig) bool {
+	return cfg.WarmConns > 0 && cfg.WarmURL != "" && !cfg.DisableKeepAlives
+}
+
+func warmInterval(cfg ProviderConfig) time.Duration {
+	if cfg.WarmInterval > 0 {
+		return cfg.WarmInterval
+	}
+	return defaultWarmInterval
+}
+
+// IsWarmedChannelType reports whether the provider of a channel type keeps warm
+// connections, its relay requests should then use the provider pool
+func IsWarmedChannelType(channelType int) bool {
+	return warmEnabled(effectiveConfig(ProviderNameFromChannelType(channelType), 0))
+}
+
+// runWarmer periodically warms the pools of providers with pre-warming enabled
+func (m *ConnectionPoolManager) runWarmer() {
+	ticker := time.NewTicker(warmCheckInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.warmPools()
+	}
+}
+
+func (m *ConnectionPoolManager) warmPools() {
+	now := time.Now()
+	var due []*pooledClient
+	m.mu.RLock()
+	for _, pooled := range m.pools {
+		if !warmEnabled(pooled.cfg) {
+			continue
+		}
+		if now.Sub(time.Unix(0, pooled.lastWarm.Load())) < warmInterval(pooled.cfg) {
+			continue
+		}
+		pooled.lastWarm.Store(now.UnixNano())
+		due = append(due, pooled)
+	}
+	m.mu.RUnlock()
+	for _, pooled := range due {
+		go pooled.warm()
+	}
+}
+
+// warm sends WarmConns concurrent HEAD requests to the warm url. They reuse the
+// idle connections, keeping them alive, and open new ones up to WarmConns.
+// Over HTTP/2 the requests share a single connection.
+func (p *pooledClient) warm() {
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var firstErr error
+	for i := 0; i < p.cfg.WarmConns; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.ping(); err != nil {
+				lock.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// only log state changes, a failing upstream is pinged every interval
+	if firstErr != nil && p.warmFailing.CompareAndSwap(false, true) {
+		logger.SysError("connection pre-warming failed for " + p.name() + ": " + firstErr.Error())
+	} else if firstErr == nil && p.warmFailing.CompareAndSwap(true, false) {
+		logger.SysLog("connection pre-warming recovered for " + p.name())
+	}
+}
+
+func (p *pooledClient) ping() error {
+	ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), warmRequestKey{}, true), warmRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.cfg.WarmURL, nil)
+	if err != nil {
+		return err
+	}
+	p.trace.warmPings.Add(1)
+	resp, err := p.client.Do(req)
+	if err != nil {
+		p.trace.warmFailures.Add(1)
+		return err
+	}
+	// any status will do, the connection is what matters
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
@@ -1,11 +1,13 @@
 package client
 
 import (
-	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/songquanpeng/one-api/common/config"
@@ -19,10 +21,19 @@ type ProviderConfig struct {
 	MaxIdleConnsPerHost int
 	MaxConnsPerHost    int
 	IdleConnTimeout    time.Duration
-	ResponseTimeout    time.Duration
+	ResponseTimeout    time.Duration // until the response headers arrive, the body is not limited by it
 	TLSHandshakeTimeout time.Duration
 	KeepAlive          time.Duration
 	DisableKeepAlives  bool
+	// Zero values below fall back to the defaults in pool-timeout.go
+	DialTimeout        time.Duration
+	StreamIdleTimeout  time.Duration // longest gap between two reads of the response body
+	MaxDuration        time.Duration // whole request including the body, 0 means unlimited
+	EnableHTTP3        bool          // experimental, also needs HTTP3_ENABLED and the http3 build tag
+	// Pre-warming, see pool-warmer.go
+	WarmConns          int           // connections kept warm, 0 disables pre-warming
+	WarmURL            string        // cheap endpoint requested with HEAD to open and keep the connections
+	WarmInterval       time.Duration // between two warm rounds, 0 means defaultWarmInterval
 }
 
 // DefaultProviderConfig returns default config for unknown providers
@@ -132,9 +143,79 @@ var providerConfigs = map[string]ProviderConfig{
 	},
 }
 
-// ConnectionPoolManager manages per-provider HTTP connection pools
+// pooledClient is a client together with the config it was built from
+type pooledClient struct {
+	client       *http.Client
+	cfg          ProviderConfig
+	providerName string
+	channelId    int
+	options      ChannelOptions
+	trace        *poolTrace
+	lastWarm     atomic.Int64 // unix nanoseconds of the last pre-warming round
+	warmFailing  atomic.Bool
+}
+
+// DirectProxy in a channel config bypasses the global relay proxy
+const DirectProxy = "direct"
+
+// ValidateProxyURL checks a channel proxy, empty means the global relay proxy
+func ValidateProxyURL(proxy string) error {
+	if proxy == "" || proxy == DirectProxy {
+		return nil
+	}
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy url: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return errors.New("proxy url has no host")
+	}
+	return nil
+}
+
+// poolKey identifies a pool by provider (or channel with a dedicated pool) and channel options
+func poolKey(providerName string, channelId int, options ChannelOptions) string {
+	key := providerName
+	if channelId != 0 {
+		key = fmt.Sprintf("channel:%d", channelId)
+	}
+	if options.Proxy != "" {
+		key += "|" + options.Proxy
+	}
+	if options.TLS != nil {
+		key += "|tls:" + options.TLS.fingerprint()
+	}
+	if options.LocalAddress != "" {
+		key += "|local:" + options.LocalAddress
+	}
+	return key
+}
+
+// name is the pool key without secrets, used in logs and stats
+func (p *pooledClient) name() string {
+	options := p.options
+	options.Proxy = redactedProxy(options.Proxy)
+	return poolKey(p.providerName, p.channelId, options)
+}
+
+// redactedProxy hides the proxy credentials in logs and stats
+func redactedProxy(proxy string) string {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return ""
+	}
+	return proxyURL.Redacted()
+}
+
+// ConnectionPoolManager manages per-provider HTTP connection pools,
+// channels with their own overrides get a dedicated pool
 type ConnectionPoolManager struct {
-	pools  map[string]*http.Client
+	pools  map[string]*pooledClient
 	mu     sync.RWMutex
 	proxy  *url.URL
 }
@@ -148,7 +229,7 @@ var (
 func GetPoolManager() *ConnectionPoolManager {
 	poolManagerOnce.Do(func() {
 		poolManager = &ConnectionPoolManager{
-			pools: make(map[string]*http.Client),
+			pools: make(map[string]*pooledClient),
 		}
 		
 		// Parse proxy if configured
@@ -161,9 +242,10 @@ func GetPoolManager() *ConnectionPoolManager {
 		
 		// Pre-initialize pools for known providers
 		for name := range providerConfigs {
-			poolManager.getOrCreatePool(name)
+			poolManager.getOrCreatePool(name, 0, ChannelOptions{})
 		}
 		
+		go poolManager.runWarmer()
 		logger.SysLog("Connection pool manager initialized")
 	})
 	return poolManager
@@ -171,77 +253,152 @@ func GetPoolManager() *ConnectionPoolManager {
 
 // GetClient returns a configured HTTP client for the given provider
 func (m *ConnectionPoolManager) GetClient(providerName string) *http.Client {
-	return m.getOrCreatePool(providerName)
+	return m.getOrCreatePool(providerName, 0, ChannelOptions{})
+}
+
+// GetChannelClient returns the dedicated client of a channel if it has overrides or TLS
+// settings, otherwise the client shared by every channel of the provider using the same proxy
+func (m *ConnectionPoolManager) GetChannelClient(providerName string, channelId int, options ChannelOptions) *http.Client {
+	if channelId != 0 && !hasChannelOverride(channelId) && !options.dedicated() {
+		channelId = 0
+	}
+	return m.getOrCreatePool(providerName, channelId, options)
 }
 
-// getOrCreatePool gets or creates a connection pool for a provider
-func (m *ConnectionPoolManager) getOrCreatePool(providerName string) *http.Client {
+// getOrCreatePool gets or creates the connection pool for a provider, channel and options
+func (m *ConnectionPoolManager) getOrCreatePool(providerName string, channelId int, options ChannelOptions) *http.Client {
+	key := poolKey(providerName, channelId, options)
 	m.mu.RLock()
-	client, exists := m.pools[providerName]
+	pooled, exists := m.pools[key]
 	m.mu.RUnlock()
 	
 	if exists {
-		return client
+		return pooled.client
 	}
 	
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
 	// Double-check
-	if client, exists = m.pools[providerName]; exists {
-		return client
+	if pooled, exists = m.pools[key]; exists {
+		return pooled.client
 	}
 	
 	// Create new pool
-	cfg, ok := providerConfigs[providerName]
-	if !ok {
-		cfg = DefaultProviderConfig(providerName)
+	cfg := effectiveConfig(providerName, channelId)
+	trace := newPoolTrace()
+	pooled = &pooledClient{
+		client:       m.createClient(cfg, options, trace),
+		cfg:          cfg,
+		providerName: providerName,
+		channelId:    channelId,
+		options:      options,
+		trace:        trace,
 	}
+	m.pools[key] = pooled
 	
-	client = m.createClient(cfg)
-	m.pools[providerName] = client
-	
-	logger.SysLogf("Created connection pool for provider: %s", providerName)
+	logger.SysLogf("Created connection pool: %s", pooled.name())
 	
-	return client
+	return pooled.client
 }
 
-// createClient creates an HTTP client with the given configuration
-func (m *ConnectionPoolManager) createClient(cfg ProviderConfig) *http.Client {
+// Reload rebuilds the pools whose effective config changed. Requests in flight keep
+// using the old client, which is drained and has its idle connections closed.
+func (m *ConnectionPoolManager) Reload() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, pooled := range m.pools {
+		if pooled.channelId != 0 && !hasChannelOverride(pooled.channelId) && !pooled.options.dedicated() {
+			delete(m.pools, key)
+			go drainClient(pooled)
+			logger.SysLogf("Removed connection pool: %s", pooled.name())
+			continue
+		}
+		cfg := effectiveConfig(pooled.providerName, pooled.channelId)
+		if cfg == pooled.cfg {
+			continue
+		}
+		m.pools[key] = &pooledClient{
+			client:       m.createClient(cfg, pooled.options, pooled.trace),
+			cfg:          cfg,
+			providerName: pooled.providerName,
+			channelId:    pooled.channelId,
+			options:      pooled.options,
+			trace:        pooled.trace,
+		}
+		go drainClient(pooled)
+		logger.SysLogf("Rebuilt connection pool: %s", pooled.name())
+	}
+}
+
+// drainClient closes idle connections now, and again once in-flight requests
+// had time to finish and return their connections to the old pool
+func drainClient(pooled *pooledClient) {
+	pooled.client.CloseIdleConnections()
+	grace := maxDuration(pooled.cfg)
+	if grace == 0 {
+		grace = pooled.cfg.ResponseTimeout + streamIdleTimeout(pooled.cfg)
+	}
+	time.Sleep(grace + pooled.cfg.IdleConnTimeout/2)
+	pooled.client.CloseIdleConnections()
+}
+
+// createClient creates an HTTP client with the given configuration, instrumented with trace
+func (m *ConnectionPoolManager) createClient(cfg ProviderConfig, options ChannelOptions, trace *poolTrace) *http.Client {
+	tlsConfig, err := options.TLS.tlsConfig()
+	if err != nil {
+		logger.SysError("invalid channel tls config, using the default one: " + err.Error())
+		tlsConfig, _ = (*TLSOptions)(nil).tlsConfig()
+	}
+	
 	dialer := &net.Dialer{
-		Timeout:   30 * time.Second,
+		Timeout:   dialTimeout(cfg),
 		KeepAlive: cfg.KeepAlive,
 	}
 	
 	transport := &http.Transport{
-		Proxy:                 m.getProxyFunc(),
-		DialContext:           dialer.DialContext,
+		Proxy:                 m.getProxyFunc(options.Proxy),
+		DialContext:           trace.dialContext(bindLocalAddress(dialer, options.LocalAddress, cachedDialContext(dialer))),
 		ForceAttemptHTTP2:     true,
 		MaxIdleConns:          cfg.MaxIdleConns,
 		MaxIdleConnsPerHost:   cfg.MaxIdleConnsPerHost,
 		MaxConnsPerHost:       cfg.MaxConnsPerHost,
 		IdleConnTimeout:       cfg.IdleConnTimeout,
 		TLSHandshakeTimeout:   cfg.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.ResponseTimeout,
 		ExpectContinueTimeout: 1 * time.Second,
 		DisableKeepAlives:     cfg.DisableKeepAlives,
-		TLSClientConfig: &tls.Config{
-			MinVersion: tls.VersionTLS12,
-		},
+		TLSClientConfig:       tlsConfig,
 	}
 	
-	timeout := cfg.ResponseTimeout
-	if config.RelayTimeout > 0 {
-		timeout = time.Duration(config.RelayTimeout) * time.Second
+	var next http.RoundTripper = &tracingTransport{Transport: transport, trace: trace}
+	if m.useHTTP3(cfg, options.Proxy) {
+		next = newHTTP3FallbackTransport(transport.TLSClientConfig, next)
 	}
 	
+	// No client timeout, it would also cut long streaming responses,
+	// the body is limited per request by timeoutTransport instead
 	return &http.Client{
-		Transport: transport,
-		Timeout:   timeout,
+		Transport: &timeoutTransport{
+			next:              next,
+			streamIdleTimeout: streamIdleTimeout(cfg),
+			maxDuration:       maxDuration(cfg),
+		},
 	}
 }
 
-// getProxyFunc returns the proxy function if configured
-func (m *ConnectionPoolManager) getProxyFunc() func(*http.Request) (*url.URL, error) {
+// getProxyFunc returns the proxy function for a channel proxy, falling back to
+// the global relay proxy when the channel has none
+func (m *ConnectionPoolManager) getProxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	if proxy == DirectProxy {
+		return nil
+	}
+	if proxy != "" {
+		if proxyURL, err := url.Parse(proxy); err == nil {
+			return http.ProxyURL(proxyURL)
+		}
+		logger.SysError("invalid channel proxy, using the relay proxy: " + redactedProxy(proxy))
+	}
 	if m.proxy != nil {
 		return http.ProxyURL(m.proxy)
 	}
@@ -254,29 +411,43 @@ func (m *ConnectionPoolManager) GetStats() map[string]map[string]interface{} {
 	defer m.mu.RUnlock()
 	
 	stats := make(map[string]map[string]interface{})
-	for name := range m.pools {
-		cfg, ok := providerConfigs[name]
-		if !ok {
-			cfg = DefaultProviderConfig(name)
-		}
-		stats[name] = map[string]interface{}{
+	for _, pooled := range m.pools {
+		cfg := pooled.cfg
+		stats[pooled.name()] = map[string]interface{}{
 			"max_idle_conns":        cfg.MaxIdleConns,
 			"max_idle_conns_per_host": cfg.MaxIdleConnsPerHost,
 			"max_conns_per_host":    cfg.MaxConnsPerHost,
 			"idle_conn_timeout":     cfg.IdleConnTimeout.String(),
 			"response_timeout":      cfg.ResponseTimeout.String(),
+			"dial_timeout":          dialTimeout(cfg).String(),
+			"stream_idle_timeout":   streamIdleTimeout(cfg).String(),
+			"max_duration":          maxDuration(cfg).String(),
+			"http3":                 m.useHTTP3(cfg, pooled.options.Proxy),
+			"warm_conns":            cfg.WarmConns,
 		}
 	}
 	return stats
 }
 
+// GetTraceStats returns the httptrace statistics of all connection pools
+func (m *ConnectionPoolManager) GetTraceStats() map[string]PoolTraceStats {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stats := make(map[string]PoolTraceStats)
+	for _, pooled := range m.pools {
+		stats[pooled.name()] = pooled.trace.snapshot()
+	}
+	return stats
+}
+
 // CloseIdleConnections closes idle connections for all pools
 func (m *ConnectionPoolManager) CloseIdleConnections() {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 	
-	for _, client := range m.pools {
-		client.CloseIdleConnections()
+	for _, pooled := range m.pools {
+		pooled.client.CloseIdleConnections()
 	}
 }
 
@@ -319,8 +490,9 @@ func ProviderNameFromChannelType(channelType int) string {
 	}
 }
 
-// GetClientForChannel returns the appropriate HTTP client for a channel type
-func GetClientForChannel(channelType int) *http.Client {
+// GetClientForChannel returns the appropriate HTTP client for a channel,
+// honouring the channel's own pool overrides, outbound proxy and TLS settings
+func GetClientForChannel(channelType int, channelId int, options ChannelOptions) *http.Client {
 	providerName := ProviderNameFromChannelType(channelType)
-	return GetProviderClient(providerName)
+	return GetPoolManager().GetChannelClient(providerName, channelId, options)
 }
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+// RoundTripperFunc adapts a function to http.RoundTripper
+type RoundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// RoundTripperMiddleware wraps an outbound round tripper with extra behaviour
+type RoundTripperMiddleware func(next http.RoundTripper) http.RoundTripper
+
+// Chain wraps base with middlewares, the first middleware sees the request first
+func Chain(base http.RoundTripper, middlewares ...RoundTripperMiddleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i] != nil {
+			base = middlewares[i](base)
+		}
+	}
+	return base
+}
+
+// WithMiddlewares returns a client sharing the connections of httpClient with middlewares applied
+func WithMiddlewares(httpClient *http.Client, middlewares ...RoundTripperMiddleware) *http.Client {
+	if len(middlewares) == 0 {
+		return httpClient
+	}
+	wrapped := *httpClient
+	wrapped.Transport = Chain(httpClient.Transport, middlewares...)
+	return &wrapped
+}
+
+// WithHeaders sets headers on every request, overriding the ones set by the adaptor
+func WithHeaders(headers map[string]string) RoundTripperMiddleware {
+	if len(headers) == 0 {
+		return nil
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			for key, value := range headers {
+				if http.CanonicalHeaderKey(key) == "Host" {
+					req.Host = value
+					continue
+				}
+				req.Header.Set(key, value)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// RequestSigner signs a request right before it is sent, e.g. AWS SigV4 or a GCP access token
+type RequestSigner interface {
+	Sign(req *http.Request) error
+}
+
+// RequestSignerFunc adapts a function to RequestSigner
+type RequestSignerFunc func(req *http.Request) error
+
+func (f RequestSignerFunc) Sign(req *http.Request) error {
+	return f(req)
+}
+
+// WithSigner signs every request, it must run after any middleware changing headers
+func WithSigner(signer RequestSigner) RoundTripperMiddleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			req = req.Clone(req.Context())
+			if err := signer.Sign(req); err != nil {
+				return nil, fmt.Errorf("sign request failed: %w", err)
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// BearerTokenSigner sets the token returned by getToken, e.g. a cached GCP access token
+func BearerTokenSigner(getToken func(ctx context.Context) (string, error)) RequestSigner {
+	return RequestSignerFunc(func(req *http.Request) error {
+		token, err := getToken(req.Context())
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// AWSSigV4Signer signs requests for an AWS service with static credentials
+func AWSSigV4Signer(accessKey string, secretKey string, region string, service string) RequestSigner {
+	signer := v4.NewSigner()
+	credentials := aws.Credentials{AccessKeyID: accessKey, SecretAccessKey: secretKey}
+	return RequestSignerFunc(func(req *http.Request) error {
+		hash := sha256.New()
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return errors.New("request body cannot be read twice")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(hash, body)
+			_ = body.Close()
+			if err != nil {
+				return err
+			}
+		}
+		payloadHash := hex.EncodeToString(hash.Sum(nil))
+		return signer.SignHTTP(req.Context(), credentials, req, payloadHash, service, region, time.Now())
+	})
+}
+
+// isIdempotent reports whether a request can be sent again safely
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// isConnectionError reports whether the request failed before the upstream could process it
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF)
+}
+
+// WithRetry retries idempotent requests failing with a connection error,
+// waiting backoff, then twice as long, between attempts
+func WithRetry(maxRetries int, backoff time.Duration) RoundTripperMiddleware {
+	if maxRetries <= 0 {
+		return nil
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+			resp, err := next.RoundTrip(req)
+			delay := backoff
+			for attempt := 0; attempt < maxRetries && err != nil; attempt++ {
+				if !replayable || !isIdempotent(req) || !isConnectionError(err) {
+					break
+				}
+				select {
+				case <-req.Context().Done():
+					return nil, err
+				case <-time.After(delay):
+				}
+				delay *= 2
+				retry := req.Clone(req.Context())
+				if req.GetBody != nil {
+					if retry.Body, err = req.GetBody(); err != nil {
+						return nil, err
+					}
+				}
+				resp, err = next.RoundTrip(retry)
+			}
+			return resp, err
+		})
+	}
+}
+
+// ErrBodyTooLarge is returned when a request or response exceeds its size limit
+var ErrBodyTooLarge = errors.New("body exceeds the size limit")
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, ErrBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// WithRequestSizeLimit rejects request bodies larger than maxBytes
+func WithRequestSizeLimit(maxBytes int64) RoundTripperMiddleware {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.ContentLength > maxBytes {
+				return nil, fmt.Errorf("request %w of %d bytes", ErrBodyTooLarge, maxBytes)
+			}
+			if req.Body != nil && req.Body != http.NoBody && req.ContentLength < 0 {
+				req = req.Clone(req.Context())
+				req.Body = &limitedBody{ReadCloser: req.Body, remaining: maxBytes}
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
+
+// WithResponseSizeLimit fails reading response bodies larger than maxBytes
+func WithResponseSizeLimit(maxBytes int64) RoundTripperMiddleware {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			if resp.ContentLength > maxBytes {
+				_ = resp.Body.Close()
+				return nil, fmt.Errorf("response %w of %d bytes", ErrBodyTooLarge, maxBytes)
+			}
+			resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: maxBytes}
+			return resp, nil
+		})
+	}
+}
@@ -20,12 +20,26 @@ var TopUpLink = ""
 var ChatLink = ""
 var QuotaPerUnit = 500 * 1000.0 // $0.002 / 1K tokens
 var DisplayInCurrencyEnabled = true
+
+// Currency is the currency the quota is displayed in, see common/currency
+var Currency = env.String("CURRENCY", "USD")
 var DisplayTokenStatEnabled = true
 
 // Any options with "Secret", "Token" in its key won't be return by GetOptions
 
 var SessionSecret = uuid.New().String()
 
+// EncryptionKey protects secrets stored in the database such as channel client keys
+var EncryptionKey = os.Getenv("ENCRYPTION_KEY")
+
+// EncryptionKeyFile holds the encryption key when ENCRYPTION_KEY is not set, such as a secret
+// mounted from a KMS or a secret manager
+var EncryptionKeyFile = os.Getenv("ENCRYPTION_KEY_FILE")
+
+// EncryptionPreviousKeys are the comma separated encryption keys replaced by EncryptionKey,
+// which still decrypt the secrets until they are encrypted again
+var EncryptionPreviousKeys = os.Getenv("ENCRYPTION_PREVIOUS_KEYS")
+
 var OptionMap map[string]string
 var OptionMapRWMutex sync.RWMutex
 
@@ -37,6 +51,7 @@ var PasswordRegisterEnabled = true
 var EmailVerificationEnabled = false
 var GitHubOAuthEnabled = false
 var OidcEnabled = false
+var OidcProvisioningEnabled = false // create the users signing in with OIDC even when registration is closed
 var WeChatAuthEnabled = false
 var TurnstileCheckEnabled = false
 var RegisterEnabled = true
@@ -58,6 +73,7 @@ var DebugEnabled = strings.ToLower(os.Getenv("DEBUG")) == "true"
 var DebugSQLEnabled = strings.ToLower(os.Getenv("DEBUG_SQL")) == "true"
 var MemoryCacheEnabled = strings.ToLower(os.Getenv("MEMORY_CACHE_ENABLED")) == "true"
 var AutoModelEnabled = strings.ToLower(os.Getenv("AUTO_MODEL_ENABLED")) == "true"
+var SelectionStrategy = env.String("SELECTION_STRATEGY", "balanced") // default strategy of the smart channel selection
 
 var LogConsumeEnabled = true
 
@@ -93,10 +109,20 @@ var TurnstileSecretKey = ""
 var QuotaForNewUser int64 = 0
 var QuotaForInviter int64 = 0
 var QuotaForInvitee int64 = 0
+
+// PromoQuotaValidDays is how long the quota of the promotions, the signup bonus, the referral
+// credits and the promotional redemption codes, can be spent, 0 is forever
+var PromoQuotaValidDays = 0
+
+// PromoSignupIPDailyLimit is how many signups of an IP a day get the signup bonus and the
+// referral credits, 0 is no limit
+var PromoSignupIPDailyLimit = 0
+
 var ChannelDisableThreshold = 5.0
 var AutomaticDisableChannelEnabled = false
 var AutomaticEnableChannelEnabled = false
 var QuotaRemindThreshold int64 = 1000
+var WebhookSpendThreshold int64 = 0
 var PreConsumedQuota int64 = 500
 var ApproximateTokenEnabled = false
 var RetryTimes = 0
@@ -160,11 +186,117 @@ var requestInterval, _ = strconv.Atoi(os.Getenv("POLLING_INTERVAL"))
 var RequestInterval = time.Duration(requestInterval) * time.Second
 
 var SyncFrequency = env.Int("SYNC_FREQUENCY", 10*60) // unit is second
+var SettingsSyncFrequency = env.Int("SETTINGS_SYNC_FREQUENCY", 10) // unit is second
+var CanaryCheckFrequency = env.Int("CANARY_CHECK_FREQUENCY", 60)   // unit is second
 
 var BatchUpdateEnabled = false
 var BatchUpdateInterval = env.Int("BATCH_UPDATE_INTERVAL", 5)
-
-var RelayTimeout = env.Int("RELAY_TIMEOUT", 0) // unit is second
+var BatchUpdateStore = env.String("BATCH_UPDATE_STORE", "memory") // memory or redis, redis keeps the pending updates on a crash
+
+// Failed log batches are retried, then spilled to disk and replayed once the database recovers
+var LogBatchRetryTimes = env.Int("LOG_BATCH_RETRY_TIMES", 3)
+var LogSpillDir = env.String("LOG_SPILL_DIR", "")        // defaults to log-spill in the log directory
+var LogSpillMaxSize = env.Int("LOG_SPILL_MAX_SIZE", 512) // unit is MB, 0 disables spilling
+
+// Log sinks receive the logs in addition to, or instead of, the SQL log table
+var LogSinks = env.String("LOG_SINKS", "") // comma separated: clickhouse, kafka, loki, file
+var LogSQLEnabled = env.Bool("LOG_SQL_ENABLED", true)
+var LogSinkClickHouseURL = env.String("LOG_SINK_CLICKHOUSE_URL", "")
+var LogSinkClickHouseTable = env.String("LOG_SINK_CLICKHOUSE_TABLE", "logs")
+var LogSinkKafkaRestURL = env.String("LOG_SINK_KAFKA_REST_URL", "")
+var LogSinkKafkaTopic = env.String("LOG_SINK_KAFKA_TOPIC", "one-api-logs")
+var LogSinkLokiURL = env.String("LOG_SINK_LOKI_URL", "")
+var LogSinkFileDir = env.String("LOG_SINK_FILE_DIR", "")        // defaults to the log directory
+var LogSinkFileMaxSize = env.Int("LOG_SINK_FILE_MAX_SIZE", 100) // unit is MB
+var LogSinkFileMaxBackups = env.Int("LOG_SINK_FILE_MAX_BACKUPS", 10)
+
+// Logs older than LogRetentionDays are archived, then deleted, by a daily job
+var LogRetentionDays = env.Int("LOG_RETENTION_DAYS", 0) // 0 keeps the logs forever
+var LogArchiveEnabled = env.Bool("LOG_ARCHIVE_ENABLED", true)
+var LogArchiveDir = env.String("LOG_ARCHIVE_DIR", "") // defaults to log-archive in the log directory
+var LogArchiveS3Endpoint = env.String("LOG_ARCHIVE_S3_ENDPOINT", "")
+var LogArchiveS3Region = env.String("LOG_ARCHIVE_S3_REGION", "us-east-1")
+var LogArchiveS3AccessKey = env.String("LOG_ARCHIVE_S3_ACCESS_KEY", "")
+var LogArchiveS3SecretKey = env.String("LOG_ARCHIVE_S3_SECRET_KEY", "")
+var LogPartitionEnabled = env.Bool("LOG_PARTITION_ENABLED", false) // maintain monthly partitions of a partitioned logs table
+
+// UsageRollupEnabled counts the usage per hour, user, model and channel for the dashboards
+var UsageRollupEnabled = env.Bool("USAGE_ROLLUP_ENABLED", false)
+
+// Bodies captured by the debug capture rules are cut beyond DebugCaptureMaxBodySize
+var DebugCaptureMaxBodySize = env.Int("DEBUG_CAPTURE_MAX_BODY_SIZE", 64) // unit is KB
+var DebugCaptureRetention = env.Int("DEBUG_CAPTURE_RETENTION", 72)       // unit is hour
+
+// RequestTraceEnabled saves the decision timeline of every relay request, kept for RequestTraceRetention
+var RequestTraceEnabled = env.Bool("REQUEST_TRACE_ENABLED", false)
+var RequestTraceRetention = env.Int("REQUEST_TRACE_RETENTION", 72) // unit is hour
+
+// Responses of the responses API stored for retrieval and previous_response_id are deleted after ResponseStoreRetention
+var ResponseStoreRetention = env.Int("RESPONSE_STORE_RETENTION", 720) // unit is hour
+
+// Batches of the batch API run BatchConcurrency requests at a time, at most BatchMaxRunning batches per node
+var BatchConcurrency = env.Int("BATCH_CONCURRENCY", 4)
+var BatchMaxRunning = env.Int("BATCH_MAX_RUNNING", 2)
+
+// Deferred requests are relayed DeferredConcurrency at a time per node, during DeferredWindow
+// ("01:00-06:00" in local time, empty for any time) when the node is idle, or when a discounted
+// channel of their model is healthy, and at the latest DeferredMaxDelay after they are queued.
+// They are billed at DeferredPriceRatio of the price.
+var DeferredConcurrency = env.Int("DEFERRED_CONCURRENCY", 4)
+var DeferredWindow = env.String("DEFERRED_WINDOW", "")
+var DeferredMaxDelay = env.Int("DEFERRED_MAX_DELAY", 24) // unit is hour
+var DeferredPriceRatio = env.Float64("DEFERRED_PRICE_RATIO", 0.5)
+
+// Traffic mirrors send at most TrafficMirrorMaxInFlight shadow requests at a time per node, the
+// requests mirrored beyond are dropped
+var TrafficMirrorMaxInFlight = env.Int("TRAFFIC_MIRROR_MAX_IN_FLIGHT", 64)
+
+// The faults of the chaos rules are only injected into the upstream requests with ChaosEnabled
+var ChaosEnabled = env.Bool("CHAOS_ENABLED", false)
+
+// Files of the files API are kept on the local disk or in an S3 compatible bucket
+var FileStorage = env.String("FILE_STORAGE", "local") // local or s3
+var FileStorageDir = env.String("FILE_STORAGE_DIR", "files")
+var FileStorageS3Endpoint = env.String("FILE_STORAGE_S3_ENDPOINT", "") // path-style, bucket included
+var FileStorageS3Region = env.String("FILE_STORAGE_S3_REGION", "us-east-1")
+var FileStorageS3AccessKey = env.String("FILE_STORAGE_S3_ACCESS_KEY", "")
+var FileStorageS3SecretKey = env.String("FILE_STORAGE_S3_SECRET_KEY", "")
+var FileMaxSize = env.Int("FILE_MAX_SIZE", 100)           // unit is MB
+var FileStorageQuota = env.Int("FILE_STORAGE_QUOTA", 1024) // unit is MB, per user, 0 is unlimited
+var FileRetention = env.Int("FILE_RETENTION", 0)           // unit is hour, 0 keeps the files until deleted
+
+// A structured output failing its JSON schema is repaired once, by StructuredOutputFallbackModel if set
+// or else by the model which made it
+var StructuredOutputFallbackModel = env.String("STRUCTURED_OUTPUT_FALLBACK_MODEL", "")
+
+// The moderation classifier call of a moderation policy gives up after ModerationTimeout and lets the request through
+var ModerationTimeout = env.Int("MODERATION_TIMEOUT", 10) // unit is second
+
+// On SIGTERM in-flight requests get ShutdownDrainTimeout to finish, then the subsystems ShutdownTimeout to stop
+var ShutdownDrainTimeout = env.Int("SHUTDOWN_DRAIN_TIMEOUT", 30) // unit is second
+var ShutdownTimeout = env.Int("SHUTDOWN_TIMEOUT", 15)            // unit is second
+
+var RelayTimeout = env.Int("RELAY_TIMEOUT", 0)                             // unit is second
+var RelayStreamIdleTimeout = env.Int("RELAY_STREAM_IDLE_TIMEOUT", 120)     // unit is second, 0 disables
+var RelayConnectionRetryTimes = env.Int("RELAY_CONNECTION_RETRY_TIMES", 2) // idempotent upstream requests only
+
+// ParamTranslationEnabled translates the OpenAI parameters of the requests for the provider of their channel
+var ParamTranslationEnabled = env.Bool("PARAM_TRANSLATION_ENABLED", true)
+
+// The adaptive timeouts give each model of each channel AdaptiveTimeoutMultiplier times its p99
+// response latency to answer, within AdaptiveTimeoutMin and AdaptiveTimeoutMax
+var AdaptiveTimeoutEnabled = env.Bool("ADAPTIVE_TIMEOUT_ENABLED", false)
+var AdaptiveTimeoutMultiplier = env.Float64("ADAPTIVE_TIMEOUT_MULTIPLIER", 1.5)
+var AdaptiveTimeoutMin = env.Int("ADAPTIVE_TIMEOUT_MIN", 10)  // unit is second
+var AdaptiveTimeoutMax = env.Int("ADAPTIVE_TIMEOUT_MAX", 300) // unit is second, 0 is unbounded
+
+// Upstream DNS cache of the connection pools, a TTL of 0 disables the cache
+var DNSCacheTTL = env.Int("DNS_CACHE_TTL", 60)                 // unit is second
+var DNSNegativeCacheTTL = env.Int("DNS_NEGATIVE_CACHE_TTL", 5) // unit is second
+var HappyEyeballsDelay = env.Int("HAPPY_EYEBALLS_DELAY", 300)  // unit is millisecond
+
+// HTTP3Enabled allows providers with enable_http3 in the pool config to use QUIC
+var HTTP3Enabled = env.Bool("HTTP3_ENABLED", false)
 
 var GeminiSafetySetting = env.String("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
 
@@ -192,10 +324,30 @@ var (
 
 	CriticalRateLimitNum            = 20
 	CriticalRateLimitDuration int64 = 20 * 60
+
+	// Relay limits are enforced together by the composite limiter,
+	// a value of 0 disables the corresponding dimension
+	RelayIPRateLimitNum               = env.Int("RELAY_IP_RATE_LIMIT", 0)
+	RelayIPRateLimitDuration    int64 = int64(env.Int("RELAY_IP_RATE_LIMIT_DURATION", 60))
+	RelayTokenRateLimitNum            = env.Int("RELAY_TOKEN_RATE_LIMIT", 0)
+	RelayTokenRateLimitDuration int64 = int64(env.Int("RELAY_TOKEN_RATE_LIMIT_DURATION", 60))
+	RelayUserRateLimitNum             = env.Int("RELAY_USER_RATE_LIMIT", 0)
+	RelayUserRateLimitDuration  int64 = int64(env.Int("RELAY_USER_RATE_LIMIT_DURATION", 60))
 )
 
 var RateLimitKeyExpirationDuration = 20 * time.Minute
 
+// RateLimitAlgorithm is the default limiter algorithm, "sliding_window" or "gcra"
+var RateLimitAlgorithm = env.String("RATE_LIMIT_ALGORITHM", "sliding_window")
+
+// RateLimitAlgorithmOverrides selects the algorithm per limiter mark, e.g. "GA:gcra,RL:sliding_window"
+var RateLimitAlgorithmOverrides = env.String("RATE_LIMIT_ALGORITHM_OVERRIDES", "")
+
+// RelayTokenTPMLimit and RelayUserTPMLimit are the tokens per minute of each token and user on
+// the chat completions, debited with the prompt tokens and settled with the usage, 0 is unlimited
+var RelayTokenTPMLimit = env.Int("RELAY_TOKEN_TPM_LIMIT", 0)
+var RelayUserTPMLimit = env.Int("RELAY_USER_TPM_LIMIT", 0)
+
 var EnableMetric = env.Bool("ENABLE_METRIC", false)
 var MetricQueueSize = env.Int("METRIC_QUEUE_SIZE", 10)
 var MetricSuccessRateThreshold = env.Float64("METRIC_SUCCESS_RATE_THRESHOLD", 0.8)
@@ -214,5 +366,98 @@ var RelayProxy = env.String("RELAY_PROXY", "")
 var UserContentRequestProxy = env.String("USER_CONTENT_REQUEST_PROXY", "")
 var UserContentRequestTimeout = env.Int("USER_CONTENT_REQUEST_TIMEOUT", 30)
 
+// VisionPreprocessEnabled fetches the images of the chat completions before they are relayed,
+// at most VisionMaxImages of them of VisionMaxImageSize each, to downscale and inline them for the provider
+var VisionPreprocessEnabled = env.Bool("VISION_PREPROCESS_ENABLED", false)
+var VisionMaxImages = env.Int("VISION_MAX_IMAGES", 10)
+var VisionMaxImageSize = env.Int("VISION_MAX_IMAGE_SIZE", 20) // unit is MB
+
+// RequestCoalescingEnabled relays once the identical text requests arriving at the same time
+// on a node, the others are sent the response of the first one
+var RequestCoalescingEnabled = env.Bool("REQUEST_COALESCING_ENABLED", false)
+
 var EnforceIncludeUsage = env.Bool("ENFORCE_INCLUDE_USAGE", false)
 var TestPrompt = env.String("TEST_PROMPT", "Output only your specific model name with no additional text.")
+
+// TokenizerVocabURL is a mirror of the tiktoken encoding files, e.g. https://example.com/encodings
+var TokenizerVocabURL = env.String("TOKENIZER_VOCAB_URL", "")
+
+// IdempotencyTTL is how long the response of a relay request with an Idempotency-Key is replayed (seconds)
+var IdempotencyTTL = env.Int("IDEMPOTENCY_TTL", 3600)
+
+// WebhookRetryTimes is how many times a failed webhook delivery is retried, with an exponential backoff
+var WebhookRetryTimes = env.Int("WEBHOOK_RETRY_TIMES", 5)
+
+// Load shedding rejects relay requests with 429 while the process is over one of its thresholds,
+// the requests of the groups in LOAD_SHEDDING_PRIORITY_GROUPS only when it is far over
+var LoadSheddingEnabled = env.Bool("LOAD_SHEDDING_ENABLED", false)
+var LoadSheddingCPUThreshold = env.Float64("LOAD_SHEDDING_CPU_THRESHOLD", 90)  // percent of the CPUs the process can use
+var LoadSheddingMemoryThreshold = env.Int("LOAD_SHEDDING_MEMORY_THRESHOLD", 0) // MB, 0 is 90% of GOMEMLIMIT when it is set
+var LoadSheddingGoroutineThreshold = env.Int("LOAD_SHEDDING_GOROUTINE_THRESHOLD", 20000)
+var LoadSheddingPriorityGroups = env.String("LOAD_SHEDDING_PRIORITY_GROUPS", "")
+var LoadSheddingRetryAfter = env.Int("LOAD_SHEDDING_RETRY_AFTER", 5) // unit is second
+
+// The relay admits RelayMaxConcurrency requests at a time per node, 0 for no limit, the others
+// wait in the order of the priority of their token at most RelayQueueTimeout
+var RelayMaxConcurrency = env.Int("RELAY_MAX_CONCURRENCY", 0)
+var RelayQueueTimeout = env.Int("RELAY_QUEUE_TIMEOUT", 30) // unit is second
+
+// The retries of each priority are capped to a ratio of its requests
+var RetryBudgetInteractive = env.Float64("RETRY_BUDGET_INTERACTIVE", 0.5)
+var RetryBudgetStandard = env.Float64("RETRY_BUDGET_STANDARD", 0.2)
+var RetryBudgetBatch = env.Float64("RETRY_BUDGET_BATCH", 0.1)
+
+// MaxChannelThrottle caps how long a channel is skipped after a 429 with a Retry-After header (seconds)
+var MaxChannelThrottle = env.Int("MAX_CHANNEL_THROTTLE", 600)
+
+// PreConsumeReconcileAfter is when the quota pre-consumed by a request never billed, because its
+// process crashed, is returned (seconds), it must be longer than the longest relay request
+var PreConsumeReconcileAfter = env.Int("PRE_CONSUME_RECONCILE_AFTER", 3600)
+
+// ContextTrimPolicy is what is done to the oldest turns of a chat completion over the context
+// window of its model: trim removes them, summarize replaces them with a summary by
+// ContextSummaryModel, empty relays the request as it is
+var ContextTrimPolicy = env.String("CONTEXT_TRIM_POLICY", "")
+var ContextSummaryModel = env.String("CONTEXT_SUMMARY_MODEL", "gpt-4o-mini")
+var ContextSummaryTimeout = env.Int("CONTEXT_SUMMARY_TIMEOUT", 30) // unit is second
+
+// ContextLengthCheckEnabled rejects the requests whose prompt and max tokens are over the
+// context window of their model before they are relayed
+var ContextLengthCheckEnabled = env.Bool("CONTEXT_LENGTH_CHECK_ENABLED", true)
+
+// MaxEmulatedChoices is the largest n of the chat completions emulated with a request per
+// choice on the channels generating a single choice
+var MaxEmulatedChoices = env.Int("MAX_EMULATED_CHOICES", 8)
+
+// PluginTimeout is how long a plugin script runs before it is stopped and skipped
+var PluginTimeout = env.Int("PLUGIN_TIMEOUT", 100) // unit is millisecond
+
+// GrpcPort is the port of the gRPC admin and relay services, 0 disables them, and
+// GrpcRelayEnabled exposes the relay service besides the admin one
+var GrpcPort = env.Int("GRPC_PORT", 0)
+var GrpcRelayEnabled = env.Bool("GRPC_RELAY_ENABLED", false)
+
+// TokenKeyPrefix is the prefix of the keys of the new tokens, sk-oneapi_..., so leaked keys
+// are recognized by secret scanners; empty issues keys without prefix nor checksum
+var TokenKeyPrefix = env.String("TOKEN_KEY_PREFIX", "oneapi")
+
+// TokenRotationGracePeriod is how long the old key of a rotated token keeps working by
+// default (seconds)
+var TokenRotationGracePeriod = env.Int("TOKEN_ROTATION_GRACE_PERIOD", 86400)
+
+// TokenSignatureTolerance is how far the timestamp of a signed request may be from the time of
+// the gateway (seconds), the nonces are kept twice as long
+var TokenSignatureTolerance = env.Int("TOKEN_SIGNATURE_TOLERANCE", 300)
+
+// Payments top up the quota of the users: with Stripe Checkout when PaymentStripeSecretKey is
+// set, and with a generic provider called at PaymentWebhookURL when it is set. The callbacks
+// of a provider are signed with its webhook secret.
+var PaymentStripeSecretKey = env.String("PAYMENT_STRIPE_SECRET_KEY", "")
+var PaymentStripeWebhookSecret = env.String("PAYMENT_STRIPE_WEBHOOK_SECRET", "")
+var PaymentWebhookURL = env.String("PAYMENT_WEBHOOK_URL", "")
+var PaymentWebhookSecret = env.String("PAYMENT_WEBHOOK_SECRET", "")
+var PaymentMinAmount = env.Float64("PAYMENT_MIN_AMOUNT", 1) // in the currency of the user
+
+// StatementEmailEnabled emails the users, and the owners of the organizations, their
+// statement of the last month on the first day of every month
+var StatementEmailEnabled = env.Bool("STATEMENT_EMAIL_ENABLED", false)
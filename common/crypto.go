@@ -1,6 +1,20 @@
 package common
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
 
 func Password2Hash(password string) (string, error) {
 	passwordBytes := []byte(password)
@@ -12,3 +26,304 @@ func ValidatePasswordAndHash(password string, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+const encryptedPrefix = "enc:"
+
+// envelopePrefix marks values sealed with a data key of their own, which is sealed with
+// the master key: enc:v2:<master key id>:<sealed data key>:<sealed value>
+const envelopePrefix = encryptedPrefix + "v2:"
+
+type masterKey struct {
+	id  string
+	key []byte
+}
+
+func newMasterKey(secret string) masterKey {
+	key := sha256.Sum256([]byte(secret))
+	id := sha256.Sum256(key[:])
+	return masterKey{id: hex.EncodeToString(id[:4]), key: key[:]}
+}
+
+// masterKeys returns the current master key followed by the previous ones, which are only
+// used to decrypt
+func masterKeys() ([]masterKey, error) {
+	if config.EncryptionKey == "" {
+		return nil, errors.New("ENCRYPTION_KEY is not set")
+	}
+	keys := []masterKey{newMasterKey(config.EncryptionKey)}
+	for _, secret := range strings.Split(config.EncryptionPreviousKeys, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			keys = append(keys, newMasterKey(secret))
+		}
+	}
+	return keys, nil
+}
+
+// EncryptionEnabled reports whether a master key is set to encrypt secrets
+func EncryptionEnabled() bool {
+	return config.EncryptionKey != ""
+}
+
+// IsEncrypted reports whether value was produced by EncryptString
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// envelope is a value sealed by EncryptString
+type envelope struct {
+	keyId   string
+	dataKey []byte // sealed with the master key
+	value   []byte // sealed with the data key
+}
+
+func parseEnvelope(value string) (*envelope, error) {
+	parts := strings.Split(strings.TrimPrefix(value, envelopePrefix), ":")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed encrypted value")
+	}
+	dataKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	return &envelope{keyId: parts[0], dataKey: dataKey, value: sealed}, nil
+}
+
+func (e *envelope) String() string {
+	return envelopePrefix + e.keyId + ":" + base64.StdEncoding.EncodeToString(e.dataKey) + ":" + base64.StdEncoding.EncodeToString(e.value)
+}
+
+// openDataKey returns the data key of the envelope, sealed with the master key it names
+func (e *envelope) openDataKey(keys []masterKey) ([]byte, error) {
+	for _, key := range keys {
+		if key.id == e.keyId {
+			return open(key.key, e.dataKey)
+		}
+	}
+	return nil, fmt.Errorf("master key %s is neither ENCRYPTION_KEY nor one of ENCRYPTION_PREVIOUS_KEYS", e.keyId)
+}
+
+// EncryptString seals a secret stored in the database with AES-GCM, under a random data
+// key which is sealed with the master key
+func EncryptString(plaintext string) (string, error) {
+	keys, err := masterKeys()
+	if err != nil {
+		return "", err
+	}
+	dataKey := make([]byte, 32)
+	if _, err = rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealed, err := seal(dataKey, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	sealedDataKey, err := seal(keys[0].key, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return (&envelope{keyId: keys[0].id, dataKey: sealedDataKey, value: sealed}).String(), nil
+}
+
+// DecryptString opens a value sealed by EncryptString with the current or a previous
+// master key, other values are returned as is
+func DecryptString(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	keys, err := masterKeys()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(value, envelopePrefix) {
+		// sealed directly with the master key by earlier versions
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+		if err != nil {
+			return "", err
+		}
+		for _, key := range keys {
+			if plaintext, err := open(key.key, sealed); err == nil {
+				return string(plaintext), nil
+			}
+		}
+		return "", errors.New("no master key decrypts the value")
+	}
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := e.openDataKey(keys)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, e.value)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// ReencryptString returns a value sealed with the current master key: plaintext and values
+// of earlier versions are encrypted, and the data key of values sealed with a previous master
+// key is sealed again, the value itself is kept
+func ReencryptString(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	if !strings.HasPrefix(value, envelopePrefix) {
+		plaintext, err := DecryptString(value)
+		if err != nil {
+			return "", err
+		}
+		return EncryptString(plaintext)
+	}
+	keys, err := masterKeys()
+	if err != nil {
+		return "", err
+	}
+	e, err := parseEnvelope(value)
+	if err != nil {
+		return "", err
+	}
+	if e.keyId == keys[0].id {
+		return value, nil
+	}
+	dataKey, err := e.openDataKey(keys)
+	if err != nil {
+		return "", err
+	}
+	if e.dataKey, err = seal(keys[0].key, dataKey); err != nil {
+		return "", err
+	}
+	e.keyId = keys[0].id
+	return e.String(), nil
+}
+
+// exportPrefix marks the secrets of an export sealed with its ExportKey
+const exportPrefix = "exp:"
+
+// ExportKey is the data key sealing the secrets of an export, it is itself sealed with a key
+// derived from a passphrase so that the export can be imported by a gateway with another
+// master key
+type ExportKey struct {
+	Salt    string `json:"salt"`
+	DataKey string `json:"data_key"` // sealed with the key derived from the passphrase
+	key     []byte
+}
+
+func passphraseKey(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("the passphrase is empty")
+	}
+	return scrypt.Key([]byte(passphrase), salt, 32768, 8, 1, 32)
+}
+
+// NewExportKey generates the data key of an export, sealed with a passphrase
+func NewExportKey(passphrase string) (*ExportKey, error) {
+	salt := make([]byte, 16)
+	key := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	sealingKey, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(sealingKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &ExportKey{
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		DataKey: base64.StdEncoding.EncodeToString(sealed),
+		key:     key,
+	}, nil
+}
+
+// Open opens the data key of an export with its passphrase
+func (k *ExportKey) Open(passphrase string) error {
+	salt, err := base64.StdEncoding.DecodeString(k.Salt)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(k.DataKey)
+	if err != nil {
+		return err
+	}
+	sealingKey, err := passphraseKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	if k.key, err = open(sealingKey, sealed); err != nil {
+		return errors.New("wrong passphrase")
+	}
+	return nil
+}
+
+// Seal seals a secret of the export
+func (k *ExportKey) Seal(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	sealed, err := seal(k.key, []byte(plaintext))
+	if err != nil {
+		return "", err
+	}
+	return exportPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unseal returns a secret sealed by Seal, other values are returned as is
+func (k *ExportKey) Unseal(value string) (string, error) {
+	if !strings.HasPrefix(value, exportPrefix) {
+		return value, nil
+	}
+	if k.key == nil {
+		return "", errors.New("the export key is not open")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, exportPrefix))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(k.key, sealed)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
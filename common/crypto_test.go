@@ -0,0 +1,62 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestEncryptionKeyRotation(t *testing.T) {
+	defer func(key, previousKeys string) {
+		config.EncryptionKey, config.EncryptionPreviousKeys = key, previousKeys
+	}(config.EncryptionKey, config.EncryptionPreviousKeys)
+
+	config.EncryptionKey, config.EncryptionPreviousKeys = "old", ""
+	encrypted, err := EncryptString("sk-secret")
+	assert.Nil(t, err)
+	assert.True(t, IsEncrypted(encrypted))
+
+	config.EncryptionKey = "new"
+	_, err = DecryptString(encrypted)
+	assert.NotNil(t, err)
+
+	config.EncryptionPreviousKeys = "old"
+	plaintext, err := DecryptString(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-secret", plaintext)
+
+	reencrypted, err := ReencryptString(encrypted)
+	assert.Nil(t, err)
+	assert.NotEqual(t, encrypted, reencrypted)
+	again, err := ReencryptString(reencrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, reencrypted, again)
+
+	config.EncryptionPreviousKeys = ""
+	plaintext, err = DecryptString(reencrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-secret", plaintext)
+}
+
+func TestExportKey(t *testing.T) {
+	exportKey, err := NewExportKey("passphrase")
+	assert.Nil(t, err)
+	sealed, err := exportKey.Seal("sk-secret")
+	assert.Nil(t, err)
+	assert.NotContains(t, sealed, "sk-secret")
+
+	imported := ExportKey{Salt: exportKey.Salt, DataKey: exportKey.DataKey}
+	_, err = imported.Unseal(sealed)
+	assert.NotNil(t, err)
+	assert.NotNil(t, imported.Open("wrong"))
+	assert.Nil(t, imported.Open("passphrase"))
+	plaintext, err := imported.Unseal(sealed)
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-secret", plaintext)
+
+	plaintext, err = imported.Unseal("sk-plain")
+	assert.Nil(t, err)
+	assert.Equal(t, "sk-plain", plaintext)
+}
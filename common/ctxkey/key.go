@@ -10,6 +10,7 @@ const (
 	ChannelId         = "channel_id"
 	SpecificChannelId = "specific_channel_id"
 	RequestModel      = "request_model"
+	AliasedModel      = "aliased_model" // the model requested, when an alias or an experiment redirected it to RequestModel
 	ConvertedRequest  = "converted_request"
 	OriginalModel     = "original_model"
 	Group             = "group"
@@ -22,10 +23,28 @@ const (
 	AvailableChannels  = "available_channels"   // Added for tracking channel count
 	SelectionScore     = "selection_score"      // Added for tracking selection score
 	ChannelName       = "channel_name"
+	ChannelTags       = "channel_tags"
 	TokenId           = "token_id"
 	TokenName         = "token_name"
+	TokenKey          = "token_key"      // the key of the token validated by TokenAuth
+	SignedRequest     = "signed_request" // the request as the client signed it, before it was converted
+	OrganizationId    = "organization_id"
+	TenantId          = "tenant_id"
+	StructuredOutput  = "structured_output"
+	Moderation        = "moderation"
+	MaxStreamDuration = "max_stream_duration"
 	BaseURL           = "base_url"
 	AvailableModels   = "available_models"
 	KeyRequestBody    = "key_request_body"
 	SystemPrompt      = "system_prompt"
+	PromptTemplateId  = "prompt_template_id"
+	PostProcessors    = "post_processors"
+	ExperimentId      = "experiment_id"
+	ExperimentArm     = "experiment_arm"
+	SelectionStrategy = "selection_strategy" // channel selection strategy of the experiment arm of the request
+	SSOLogin          = "sso_login"          // the user signs in with OIDC
+	PartialCompletion = "partial_completion" // the stream ended before the completion
+	RemainingRequests = "remaining_requests" // the requests left by the tightest rate limit of the request
+	EndpointLimits    = "endpoint_limits"    // the endpoint rate limits the request was counted against, by id
+	Priority          = "priority"           // the priority class of the token, see common/priority
 )
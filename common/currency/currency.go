@@ -0,0 +1,205 @@
+package currency
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// The ratios and the quota are priced in USD, QuotaPerUnit being the quota of a dollar. The
+// amounts are displayed in the currency of the deployment, or of the group of the user,
+// converted with the exchange rates.
+
+const USD = "USD"
+
+var lock sync.RWMutex
+
+// exchangeRates are the units of each currency a dollar is worth
+var exchangeRates = map[string]float64{
+	USD:   1,
+	"CNY": 7.2,
+	"EUR": 0.92,
+	"VND": 25000,
+}
+
+// groupCurrency is the currency of each group, the groups not in it use config.Currency
+var groupCurrency = map[string]string{}
+
+var symbols = map[string]string{
+	USD:   "＄",
+	"CNY": "￥",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"VND": "₫",
+}
+
+// IsCode tells whether a currency code looks like an ISO 4217 one, e.g. EUR
+func IsCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+func ExchangeRates2JSONString() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	jsonBytes, err := json.Marshal(exchangeRates)
+	if err != nil {
+		logger.SysError("error marshalling exchange rates: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateExchangeRatesByJSONString(jsonStr string) error {
+	rates := make(map[string]float64)
+	if err := json.Unmarshal([]byte(jsonStr), &rates); err != nil {
+		return err
+	}
+	for code, rate := range rates {
+		if !IsCode(code) {
+			return fmt.Errorf("invalid currency code %s", code)
+		}
+		if rate <= 0 {
+			return fmt.Errorf("currency %s: the exchange rate must be positive", code)
+		}
+	}
+	if rate, ok := rates[USD]; ok && rate != 1 {
+		return fmt.Errorf("the exchange rate of USD must be 1")
+	}
+	rates[USD] = 1
+	lock.Lock()
+	exchangeRates = rates
+	lock.Unlock()
+	return nil
+}
+
+func GroupCurrency2JSONString() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	jsonBytes, err := json.Marshal(groupCurrency)
+	if err != nil {
+		logger.SysError("error marshalling group currency: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupCurrencyByJSONString(jsonStr string) error {
+	currencies := make(map[string]string)
+	if err := json.Unmarshal([]byte(jsonStr), &currencies); err != nil {
+		return err
+	}
+	for group, code := range currencies {
+		if !IsCode(code) {
+			return fmt.Errorf("group %s: invalid currency code %s", group, code)
+		}
+	}
+	lock.Lock()
+	groupCurrency = currencies
+	lock.Unlock()
+	return nil
+}
+
+// ExchangeRates returns a copy of the exchange rates
+func ExchangeRates() map[string]float64 {
+	lock.RLock()
+	defer lock.RUnlock()
+	rates := make(map[string]float64, len(exchangeRates))
+	for code, rate := range exchangeRates {
+		rates[code] = rate
+	}
+	return rates
+}
+
+// Rate returns the exchange rate of a currency, false if it has none
+func Rate(code string) (float64, bool) {
+	lock.RLock()
+	defer lock.RUnlock()
+	rate, ok := exchangeRates[code]
+	return rate, ok
+}
+
+// Default returns the currency of the deployment, USD when it has no exchange rate
+func Default() string {
+	if _, ok := Rate(config.Currency); !ok {
+		return USD
+	}
+	return config.Currency
+}
+
+// OfGroup returns the currency the amounts of a group are displayed in
+func OfGroup(group string) string {
+	lock.RLock()
+	code, ok := groupCurrency[group]
+	lock.RUnlock()
+	if _, hasRate := Rate(code); !ok || !hasRate {
+		return Default()
+	}
+	return code
+}
+
+// FromQuota converts quota to an amount of a currency
+func FromQuota(quota int64, code string) float64 {
+	return FromUSD(float64(quota)/config.QuotaPerUnit, code)
+}
+
+// ToQuota converts an amount of a currency to quota
+func ToQuota(amount float64, code string) int64 {
+	rate, ok := Rate(code)
+	if !ok {
+		rate = 1
+	}
+	return int64(amount / rate * config.QuotaPerUnit)
+}
+
+// FromUSD converts an amount in USD to a currency
+func FromUSD(amount float64, code string) float64 {
+	rate, ok := Rate(code)
+	if !ok {
+		return amount
+	}
+	return amount * rate
+}
+
+// precision is the decimals an amount is displayed with: 6 for a dollar, fewer for the
+// currencies with smaller units
+func precision(code string) int {
+	rate, ok := Rate(code)
+	if !ok || rate <= 1 {
+		return 6
+	}
+	if decimals := 6 - int(math.Log10(rate)); decimals > 0 {
+		return decimals
+	}
+	return 0
+}
+
+// FormatAmount displays an amount with the decimals of its currency, without symbol
+func FormatAmount(amount float64, code string) string {
+	return strconv.FormatFloat(amount, 'f', precision(code), 64)
+}
+
+// Format displays an amount with the symbol or the code of its currency
+func Format(amount float64, code string) string {
+	value := FormatAmount(amount, code)
+	if symbol, ok := symbols[code]; ok {
+		return symbol + value
+	}
+	return value + " " + code
+}
+
+// FormatQuota displays quota in a currency
+func FormatQuota(quota int64, code string) string {
+	return Format(FromQuota(quota, code), code)
+}
@@ -0,0 +1,43 @@
+package currency
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestFormatQuota(t *testing.T) {
+	config.QuotaPerUnit = 500000
+	assert.Equal(t, "＄0.002000", FormatQuota(1000, USD))
+	assert.Equal(t, "₫50.00", FormatQuota(1000, "VND"))
+	assert.Equal(t, "€0.001840", FormatQuota(1000, "EUR"))
+}
+
+func TestExchangeRates(t *testing.T) {
+	defer func() {
+		_ = UpdateExchangeRatesByJSONString(`{"USD":1,"CNY":7.2,"EUR":0.92,"VND":25000}`)
+		_ = UpdateGroupCurrencyByJSONString(`{}`)
+		config.Currency = USD
+	}()
+	assert.Error(t, UpdateExchangeRatesByJSONString(`{"USD":2}`))
+	assert.Error(t, UpdateExchangeRatesByJSONString(`{"eur":0.9}`))
+	assert.Error(t, UpdateExchangeRatesByJSONString(`{"EUR":0}`))
+	assert.NoError(t, UpdateExchangeRatesByJSONString(`{"CHF":0.8}`))
+	rate, ok := Rate(USD)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, rate)
+	assert.Equal(t, "1.600000 CHF", Format(FromUSD(2, "CHF"), "CHF"))
+
+	assert.Error(t, UpdateGroupCurrencyByJSONString(`{"vip":"Swiss franc"}`))
+	assert.NoError(t, UpdateGroupCurrencyByJSONString(`{"vip":"CHF","eu":"EUR"}`))
+	config.Currency = "CHF"
+	assert.Equal(t, "CHF", Default())
+	assert.Equal(t, "CHF", OfGroup("vip"))
+	// EUR has no exchange rate anymore
+	assert.Equal(t, "CHF", OfGroup("eu"))
+	config.Currency = "JPY"
+	assert.Equal(t, USD, Default())
+	assert.Equal(t, USD, OfGroup("default"))
+}
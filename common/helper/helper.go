@@ -109,6 +109,20 @@ func GenRequestID() string {
 	return GetTimeString() + random.GetRandomNumberString(8)
 }
 
+// IsValidRequestID reports whether a request id sent by a client can be used as is,
+// it ends up in headers and log lines so only a safe charset is accepted
+func IsValidRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' || r == ':') {
+			return false
+		}
+	}
+	return true
+}
+
 func SetRequestID(ctx context.Context, id string) context.Context {
 	return context.WithValue(ctx, RequestIdKey, id)
 }
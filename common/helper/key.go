@@ -2,4 +2,6 @@ package helper
 
 const (
 	RequestIdKey = "X-Oneapi-Request-Id"
+	// InboundRequestIdHeader carries a request id chosen by the client
+	InboundRequestIdHeader = "X-Request-Id"
 )
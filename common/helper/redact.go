@@ -0,0 +1,24 @@
+package helper
+
+import "regexp"
+
+const redacted = "[REDACTED]"
+
+var (
+	// JSON string values of the fields usually holding credentials
+	secretFieldPattern = regexp.MustCompile(`(?i)("(?:api[_-]?key|key|access[_-]?token|refresh[_-]?token|id[_-]?token|token|secret|client[_-]?secret|secret[_-]?key|private[_-]?key|password|authorization|x-api-key|credentials?)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	// credentials embedded anywhere in a text
+	secretValuePattern = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9._~+/=-]+|\b(?:sk|pk|rk|ak)-[A-Za-z0-9_-]{16,}|\bAIza[0-9A-Za-z_-]{35}\b|\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)
+)
+
+// RedactSecrets masks the API keys, tokens and passwords found in a request or
+// response body, JSON or not
+func RedactSecrets(text string) string {
+	text = secretFieldPattern.ReplaceAllString(text, `$1"`+redacted+`"`)
+	return secretValuePattern.ReplaceAllStringFunc(text, func(match string) string {
+		if prefix := secretValuePattern.FindStringSubmatch(match)[1]; prefix != "" {
+			return prefix + redacted
+		}
+		return redacted
+	})
+}
@@ -2,6 +2,9 @@ package helper
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,3 +21,35 @@ func GetTimeString() string {
 func CalcElapsedTime(start time.Time) int64 {
 	return time.Now().Sub(start).Milliseconds()
 }
+
+// ParseRetryAfter returns the wait of a Retry-After header, which is either seconds or an
+// HTTP date, false when the header is missing or invalid
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := date.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// FormatRateLimitReset formats the wait of the x-ratelimit-reset-* headers like the OpenAI
+// API does, e.g. 20ms, 1.5s or 6m0s
+func FormatRateLimitReset(wait time.Duration) string {
+	if wait <= 0 {
+		return "0s"
+	}
+	return wait.Round(time.Millisecond).String()
+}
@@ -0,0 +1,36 @@
+package helper
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	Convey("TestParseRetryAfter", t, func() {
+		now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+		wait, ok := ParseRetryAfter("30", now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 30*time.Second)
+		wait, ok = ParseRetryAfter("Mon, 01 Jan 2024 12:01:00 GMT", now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, time.Minute)
+		wait, ok = ParseRetryAfter("Mon, 01 Jan 2024 11:00:00 GMT", now)
+		So(ok, ShouldBeTrue)
+		So(wait, ShouldEqual, 0)
+		for _, value := range []string{"", "-1", "soon"} {
+			_, ok = ParseRetryAfter(value, now)
+			So(ok, ShouldBeFalse)
+		}
+	})
+}
+
+func TestFormatRateLimitReset(t *testing.T) {
+	Convey("TestFormatRateLimitReset", t, func() {
+		So(FormatRateLimitReset(20*time.Millisecond+300*time.Microsecond), ShouldEqual, "20ms")
+		So(FormatRateLimitReset(1500*time.Millisecond), ShouldEqual, "1.5s")
+		So(FormatRateLimitReset(6*time.Minute), ShouldEqual, "6m0s")
+		So(FormatRateLimitReset(-time.Second), ShouldEqual, "0s")
+	})
+}
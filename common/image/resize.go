@@ -0,0 +1,94 @@
+package image
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"github.com/songquanpeng/one-api/common/client"
+)
+
+const jpegQuality = 85
+
+// Fetch returns the content and the mime type of an image, from a data url or downloaded, at
+// most maxBytes of it
+func Fetch(url string, maxBytes int64) ([]byte, string, error) {
+	if matches := dataURLPattern.FindStringSubmatch(url); len(matches) == 3 {
+		if int64(base64.StdEncoding.DecodedLen(len(matches[2]))) > maxBytes {
+			return nil, "", fmt.Errorf("image is larger than %d bytes", maxBytes)
+		}
+		data, err := base64.StdEncoding.DecodeString(matches[2])
+		return data, "image/" + matches[1], err
+	}
+	resp, err := client.UserContentRequestHTTPClient.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("fetching image: status code %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", fmt.Errorf("image is larger than %d bytes", maxBytes)
+	}
+	mimeType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(data)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, "", errors.New("url is not an image")
+	}
+	return data, mimeType, nil
+}
+
+// Downscale shrinks an image so that its longest side is at most maxDimension, keeping its
+// aspect ratio, and tells whether it did
+func Downscale(src image.Image, maxDimension int) (image.Image, bool) {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if maxDimension <= 0 || (width <= maxDimension && height <= maxDimension) {
+		return src, false
+	}
+	if width >= height {
+		height = max1(height * maxDimension / width)
+		width = maxDimension
+	} else {
+		width = max1(width * maxDimension / height)
+		height = maxDimension
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Over, nil)
+	return dst, true
+}
+
+func max1(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// Encode encodes an image as a png when it was one, to keep its transparency, as a jpeg
+// otherwise, and returns its mime type
+func Encode(img image.Image, format string) ([]byte, string, error) {
+	var buffer bytes.Buffer
+	if format == "png" {
+		err := png.Encode(&buffer, img)
+		return buffer.Bytes(), "image/png", err
+	}
+	err := jpeg.Encode(&buffer, img, &jpeg.Options{Quality: jpegQuality})
+	return buffer.Bytes(), "image/jpeg", err
+}
@@ -0,0 +1,37 @@
+package image_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	img "github.com/songquanpeng/one-api/common/image"
+)
+
+func TestDownscale(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4000, 1000))
+	resized, ok := img.Downscale(src, 2000)
+	assert.True(t, ok)
+	assert.Equal(t, 2000, resized.Bounds().Dx())
+	assert.Equal(t, 500, resized.Bounds().Dy())
+
+	_, ok = img.Downscale(src, 4000)
+	assert.False(t, ok)
+}
+
+func TestFetchDataURL(t *testing.T) {
+	data, mimeType, err := img.Encode(image.NewRGBA(image.Rect(0, 0, 10, 10)), "png")
+	assert.NoError(t, err)
+	url := "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+
+	fetched, fetchedType, err := img.Fetch(url, 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, mimeType, fetchedType)
+	assert.True(t, bytes.Equal(data, fetched))
+
+	_, _, err = img.Fetch(url, 10)
+	assert.Error(t, err)
+}
@@ -4,24 +4,32 @@ import (
 	"flag"
 	"fmt"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/currency"
 	"github.com/songquanpeng/one-api/common/logger"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
-	Port         = flag.Int("port", 3000, "the listening port")
-	PrintVersion = flag.Bool("version", false, "print version and exit")
-	PrintHelp    = flag.Bool("help", false, "print help and exit")
-	LogDir       = flag.String("log-dir", "./logs", "specify the log directory")
+	Port            = flag.Int("port", 3000, "the listening port")
+	PrintVersion    = flag.Bool("version", false, "print version and exit")
+	PrintHelp       = flag.Bool("help", false, "print help and exit")
+	LogDir          = flag.String("log-dir", "./logs", "specify the log directory")
+	EncryptChannels = flag.Bool("encrypt-channels", false, "encrypt the channel secrets with ENCRYPTION_KEY, including those encrypted with a previous key, and exit")
+	ExportConfig    = flag.String("export-config", "", "export the gateway config to a YAML file, or JSON for a .json file, and exit")
+	ApplyConfig     = flag.String("apply-config", "", "apply the gateway config of a YAML or JSON file, - for stdin, and exit")
+	DryRun          = flag.Bool("dry-run", false, "with --apply-config, print the changes without making them")
 )
 
 func printHelp() {
 	fmt.Println("One API " + Version + " - All in one API service for OpenAI API.")
 	fmt.Println("Copyright (C) 2023 JustSong. All rights reserved.")
 	fmt.Println("GitHub: https://github.com/songquanpeng/one-api")
-	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--version] [--help]")
+	fmt.Println("Usage: one-api [--port <port>] [--log-dir <log directory>] [--encrypt-channels] [--export-config <file>] [--apply-config <file> [--dry-run]] [--version] [--help]")
+	fmt.Println("       one-api serve [<flags>]")
+	fmt.Println("       one-api channel|token|usage|cache|config <command> [--server <url>] [--token <access token>]")
 }
 
 func Init() {
@@ -44,6 +52,20 @@ func Init() {
 			config.SessionSecret = os.Getenv("SESSION_SECRET")
 		}
 	}
+	if config.EncryptionKey == "" && config.EncryptionKeyFile != "" {
+		key, err := os.ReadFile(config.EncryptionKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.EncryptionKey = strings.TrimSpace(string(key))
+	}
+	if len(config.TokenKeyPrefix) > 16 || strings.Trim(config.TokenKeyPrefix, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789") != "" {
+		log.Fatal("TOKEN_KEY_PREFIX must be at most 16 letters and digits")
+	}
+	config.Currency = strings.ToUpper(config.Currency)
+	if !currency.IsCode(config.Currency) {
+		log.Fatal("CURRENCY must be a currency code such as USD")
+	}
 	if os.Getenv("SQLITE_PATH") != "" {
 		SQLitePath = os.Getenv("SQLITE_PATH")
 	}
@@ -0,0 +1,326 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// The validation covers the keywords used by structured outputs: types, enum and const,
+// object properties, arrays, string and number bounds, the combinations and the local $ref.
+// Formats are not checked.
+
+const maxErrors = 10
+
+type validator struct {
+	root   map[string]any
+	errors []string
+}
+
+// Validate checks a value decoded from JSON against a JSON schema, it returns the errors
+// found, each prefixed with the path of the value, or nil if the value is valid
+func Validate(schema map[string]any, value any) []string {
+	v := &validator{root: schema}
+	v.validate(schema, value, "$", 0)
+	return v.errors
+}
+
+// ValidateJSON is Validate for a JSON text
+func ValidateJSON(schema map[string]any, data string) []string {
+	var value any
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		return []string{"$: not valid JSON: " + err.Error()}
+	}
+	return Validate(schema, value)
+}
+
+func (v *validator) fail(path string, format string, args ...any) {
+	if len(v.errors) < maxErrors {
+		v.errors = append(v.errors, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// valid tells whether a value matches a schema without recording the errors, for anyOf,
+// oneOf and not
+func (v *validator) valid(schema any, value any, depth int) bool {
+	sub := &validator{root: v.root}
+	sub.validate(schema, value, "$", depth)
+	return len(sub.errors) == 0
+}
+
+func (v *validator) resolve(ref string) (any, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	var node any = v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := node.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if node, ok = object[token]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func (v *validator) validate(schemaValue any, value any, path string, depth int) {
+	if depth > 64 {
+		v.fail(path, "schema is nested too deeply")
+		return
+	}
+	if allowed, ok := schemaValue.(bool); ok {
+		if !allowed {
+			v.fail(path, "no value is allowed")
+		}
+		return
+	}
+	schema, ok := schemaValue.(map[string]any)
+	if !ok {
+		return
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, found := v.resolve(ref)
+		if !found {
+			v.fail(path, "unresolvable $ref %s", ref)
+			return
+		}
+		v.validate(target, value, path, depth+1)
+	}
+	if types, ok := schema["type"]; ok && !matchesType(types, value) {
+		v.fail(path, "expected %s, got %s", typeNames(types), typeOf(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, candidate := range enum {
+			if equal(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.fail(path, "value is not one of the enum values")
+		}
+	}
+	if constant, ok := schema["const"]; ok && !equal(constant, value) {
+		v.fail(path, "value is not the const value")
+	}
+	for _, sub := range asList(schema["allOf"]) {
+		v.validate(sub, value, path, depth+1)
+	}
+	if anyOf := asList(schema["anyOf"]); len(anyOf) > 0 {
+		matched := false
+		for _, sub := range anyOf {
+			if v.valid(sub, value, depth+1) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "value matches none of anyOf")
+		}
+	}
+	if oneOf := asList(schema["oneOf"]); len(oneOf) > 0 {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.valid(sub, value, depth+1) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			v.fail(path, "value matches %d of oneOf instead of exactly one", matches)
+		}
+	}
+	if not, ok := schema["not"]; ok && v.valid(not, value, depth+1) {
+		v.fail(path, "value matches not")
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		v.validateObject(schema, value, path, depth)
+	case []any:
+		v.validateArray(schema, value, path, depth)
+	case string:
+		v.validateString(schema, value, path)
+	case float64:
+		v.validateNumber(schema, value, path)
+	}
+}
+
+func (v *validator) validateObject(schema map[string]any, object map[string]any, path string, depth int) {
+	for _, name := range asList(schema["required"]) {
+		if name, ok := name.(string); ok {
+			if _, present := object[name]; !present {
+				v.fail(path, "missing required property %q", name)
+			}
+		}
+	}
+	if minimum, ok := asNumber(schema["minProperties"]); ok && float64(len(object)) < minimum {
+		v.fail(path, "has fewer than %v properties", minimum)
+	}
+	if maximum, ok := asNumber(schema["maxProperties"]); ok && float64(len(object)) > maximum {
+		v.fail(path, "has more than %v properties", maximum)
+	}
+	properties, _ := schema["properties"].(map[string]any)
+	additional, hasAdditional := schema["additionalProperties"]
+	// sorted for stable errors
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := path + "." + name
+		if property, ok := properties[name]; ok {
+			v.validate(property, object[name], propertyPath, depth+1)
+			continue
+		}
+		if hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.fail(path, "unexpected property %q", name)
+				continue
+			}
+			v.validate(additional, object[name], propertyPath, depth+1)
+		}
+	}
+}
+
+func (v *validator) validateArray(schema map[string]any, array []any, path string, depth int) {
+	if minimum, ok := asNumber(schema["minItems"]); ok && float64(len(array)) < minimum {
+		v.fail(path, "has fewer than %v items", minimum)
+	}
+	if maximum, ok := asNumber(schema["maxItems"]); ok && float64(len(array)) > maximum {
+		v.fail(path, "has more than %v items", maximum)
+	}
+	prefixItems := asList(schema["prefixItems"])
+	for i, item := range array {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		if i < len(prefixItems) {
+			v.validate(prefixItems[i], item, itemPath, depth+1)
+		} else if items, ok := schema["items"]; ok {
+			v.validate(items, item, itemPath, depth+1)
+		}
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if equal(array[i], array[j]) {
+					v.fail(path, "items %d and %d are equal", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (v *validator) validateString(schema map[string]any, s string, path string) {
+	length := float64(utf8.RuneCountInString(s))
+	if minimum, ok := asNumber(schema["minLength"]); ok && length < minimum {
+		v.fail(path, "is shorter than %v characters", minimum)
+	}
+	if maximum, ok := asNumber(schema["maxLength"]); ok && length > maximum {
+		v.fail(path, "is longer than %v characters", maximum)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err == nil && !re.MatchString(s) {
+			v.fail(path, "does not match the pattern %s", pattern)
+		}
+	}
+}
+
+func (v *validator) validateNumber(schema map[string]any, n float64, path string) {
+	if minimum, ok := asNumber(schema["minimum"]); ok && n < minimum {
+		v.fail(path, "is less than %v", minimum)
+	}
+	if maximum, ok := asNumber(schema["maximum"]); ok && n > maximum {
+		v.fail(path, "is greater than %v", maximum)
+	}
+	if minimum, ok := asNumber(schema["exclusiveMinimum"]); ok && n <= minimum {
+		v.fail(path, "is not greater than %v", minimum)
+	}
+	if maximum, ok := asNumber(schema["exclusiveMaximum"]); ok && n >= maximum {
+		v.fail(path, "is not less than %v", maximum)
+	}
+	if multiple, ok := asNumber(schema["multipleOf"]); ok && multiple > 0 {
+		if quotient := n / multiple; math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			v.fail(path, "is not a multiple of %v", multiple)
+		}
+	}
+}
+
+func asList(value any) []any {
+	list, _ := value.([]any)
+	return list
+}
+
+func asNumber(value any) (float64, bool) {
+	n, ok := value.(float64)
+	return n, ok
+}
+
+func typeOf(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
+
+func matchesType(types any, value any) bool {
+	actual := typeOf(value)
+	for _, name := range asTypeNames(types) {
+		if name == actual || (name == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+func asTypeNames(types any) []string {
+	switch types := types.(type) {
+	case string:
+		return []string{types}
+	case []any:
+		names := make([]string, 0, len(types))
+		for _, name := range types {
+			if name, ok := name.(string); ok {
+				names = append(names, name)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
+func typeNames(types any) string {
+	return strings.Join(asTypeNames(types), " or ")
+}
+
+// equal compares values decoded from JSON, whose numbers are all float64
+func equal(a any, b any) bool {
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,35 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": ["integer", "null"], "minimum": 0},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2},
+		"kind": {"enum": ["a", "b"]}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"tag": {"type": "string", "pattern": "^[a-z]+$"}}
+}`
+
+func TestValidateJSON(t *testing.T) {
+	Convey("TestValidateJSON", t, func() {
+		var schema map[string]any
+		So(json.Unmarshal([]byte(testSchema), &schema), ShouldBeNil)
+		So(ValidateJSON(schema, `{"name":"x","age":3,"tags":["go"],"kind":"a"}`), ShouldBeEmpty)
+		So(ValidateJSON(schema, `{"name":"x","age":null}`), ShouldBeEmpty)
+		So(ValidateJSON(schema, `{"name":"x","age":3.5}`), ShouldResemble, []string{"$.age: expected integer or null, got number"})
+		So(ValidateJSON(schema, `{"age":1,"extra":true}`), ShouldResemble, []string{`$: missing required property "name"`, `$: unexpected property "extra"`})
+		So(ValidateJSON(schema, `{"name":"x","age":1,"tags":["Go","a","b"]}`), ShouldResemble, []string{"$.tags: has more than 2 items", "$.tags[0]: does not match the pattern ^[a-z]+$"})
+		So(ValidateJSON(schema, `{"name":"","age":-1,"kind":"c"}`), ShouldResemble, []string{"$.age: is less than 0", "$.kind: value is not one of the enum values", "$.name: is shorter than 1 characters"})
+		So(ValidateJSON(schema, "```json\n{}\n```"), ShouldHaveLength, 1)
+	})
+}
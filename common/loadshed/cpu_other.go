@@ -0,0 +1,10 @@
+//go:build !unix
+
+package loadshed
+
+import "time"
+
+// processCPUTime is not supported, the CPU threshold is not checked
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
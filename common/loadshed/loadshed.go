@@ -0,0 +1,203 @@
+package loadshed
+
+import (
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Priority of a request, the low priority requests are shed first
+type Priority int
+
+const (
+	PriorityBatch Priority = iota // shed as soon as the process nears its thresholds
+	PriorityLow
+	PriorityHigh
+)
+
+// Level is how far the process is over its thresholds
+type Level int32
+
+const (
+	LevelNormal     Level = iota
+	LevelOverloaded       // the low priority requests are shed
+	LevelCritical         // all the requests are shed
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelOverloaded:
+		return "overloaded"
+	case LevelCritical:
+		return "critical"
+	default:
+		return "normal"
+	}
+}
+
+const (
+	sampleInterval = time.Second
+	// batchPressure is the pressure from which the batch requests are shed, before the process
+	// is overloaded
+	batchPressure = 0.8
+	// criticalPressure is the pressure from which the high priority requests are shed too
+	criticalPressure = 1.25
+	// recoveryPressure is the pressure under which the shedding stops, lower than 1 so the
+	// level does not flap around the thresholds
+	recoveryPressure = 0.9
+)
+
+// Settings are the thresholds of the protector, a threshold of 0 is not checked
+type Settings struct {
+	CPUPercent  float64 // percent of the CPUs the process can use
+	MemoryBytes uint64
+	Goroutines  int
+}
+
+// Stats are the last sample of the resources and the requests shed since the start
+type Stats struct {
+	Level         Level
+	Pressure      float64
+	CPUPercent    float64
+	MemoryBytes   uint64
+	Goroutines    int
+	ShedBatch     int64
+	ShedLow       int64
+	ShedHigh      int64
+	CPUSupported  bool
+	MemoryLimited bool
+}
+
+var (
+	settings  Settings
+	level     atomic.Int32
+	pressure  atomic.Uint64 // math.Float64bits of the pressure of the last sample
+	shedBatch atomic.Int64
+	shedLow   atomic.Int64
+	shedHigh  atomic.Int64
+
+	statsLock sync.RWMutex
+	lastStats Stats
+
+	startOnce sync.Once
+)
+
+// DefaultMemoryThreshold is 90% of GOMEMLIMIT, 0 when no limit is set
+func DefaultMemoryThreshold() uint64 {
+	limit := debug.SetMemoryLimit(-1)
+	if limit <= 0 || limit == math.MaxInt64 {
+		return 0
+	}
+	return uint64(limit) / 10 * 9
+}
+
+// Start samples the resources of the process every second and updates the level
+func Start(s Settings) {
+	startOnce.Do(func() {
+		settings = s
+		go run()
+	})
+}
+
+func run() {
+	lastCPU, cpuSupported := processCPUTime()
+	lastTime := time.Now()
+	for {
+		time.Sleep(sampleInterval)
+		cpu, _ := processCPUTime()
+		now := time.Now()
+		cpuPercent := 0.0
+		if cpuSupported {
+			wall := now.Sub(lastTime)
+			cpuPercent = float64(cpu-lastCPU) / float64(wall) / float64(runtime.GOMAXPROCS(0)) * 100
+		}
+		lastCPU, lastTime = cpu, now
+		sample(cpuPercent, cpuSupported)
+	}
+}
+
+func sample(cpuPercent float64, cpuSupported bool) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats := Stats{
+		CPUPercent:    cpuPercent,
+		MemoryBytes:   memStats.Sys - memStats.HeapReleased,
+		Goroutines:    runtime.NumGoroutine(),
+		CPUSupported:  cpuSupported,
+		MemoryLimited: settings.MemoryBytes > 0,
+	}
+	if cpuSupported && settings.CPUPercent > 0 {
+		stats.Pressure = math.Max(stats.Pressure, stats.CPUPercent/settings.CPUPercent)
+	}
+	if settings.MemoryBytes > 0 {
+		stats.Pressure = math.Max(stats.Pressure, float64(stats.MemoryBytes)/float64(settings.MemoryBytes))
+	}
+	if settings.Goroutines > 0 {
+		stats.Pressure = math.Max(stats.Pressure, float64(stats.Goroutines)/float64(settings.Goroutines))
+	}
+	stats.Level = nextLevel(Level(level.Load()), stats.Pressure)
+	level.Store(int32(stats.Level))
+	pressure.Store(math.Float64bits(stats.Pressure))
+	statsLock.Lock()
+	lastStats = stats
+	statsLock.Unlock()
+}
+
+func nextLevel(current Level, pressure float64) Level {
+	switch {
+	case pressure >= criticalPressure:
+		return LevelCritical
+	case pressure >= 1:
+		return LevelOverloaded
+	case pressure >= recoveryPressure && current != LevelNormal:
+		// keep shedding the low priority requests until the pressure is well under the thresholds
+		return LevelOverloaded
+	default:
+		return LevelNormal
+	}
+}
+
+// CurrentLevel returns the level of the last sample
+func CurrentLevel() Level {
+	return Level(level.Load())
+}
+
+// ShouldShed tells whether a request of a priority is rejected at the current level, and
+// counts it when it is
+func ShouldShed(priority Priority) bool {
+	switch CurrentLevel() {
+	case LevelCritical:
+	case LevelOverloaded:
+		if priority == PriorityHigh {
+			return false
+		}
+	default:
+		if priority != PriorityBatch || math.Float64frombits(pressure.Load()) < batchPressure {
+			return false
+		}
+	}
+	switch priority {
+	case PriorityBatch:
+		shedBatch.Add(1)
+	case PriorityLow:
+		shedLow.Add(1)
+	default:
+		shedHigh.Add(1)
+	}
+	return true
+}
+
+// GetStats returns the last sample and the shed counts
+func GetStats() Stats {
+	statsLock.RLock()
+	stats := lastStats
+	statsLock.RUnlock()
+	stats.Level = CurrentLevel()
+	stats.ShedBatch = shedBatch.Load()
+	stats.ShedLow = shedLow.Load()
+	stats.ShedHigh = shedHigh.Load()
+	return stats
+}
@@ -0,0 +1,70 @@
+package loadshed
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextLevel(t *testing.T) {
+	assert.Equal(t, LevelNormal, nextLevel(LevelNormal, 0.95))
+	assert.Equal(t, LevelOverloaded, nextLevel(LevelNormal, 1))
+	assert.Equal(t, LevelCritical, nextLevel(LevelOverloaded, 1.3))
+	// keeps shedding until the pressure is under the recovery pressure
+	assert.Equal(t, LevelOverloaded, nextLevel(LevelCritical, 0.95))
+	assert.Equal(t, LevelNormal, nextLevel(LevelOverloaded, 0.5))
+}
+
+func TestShouldShed(t *testing.T) {
+	defer level.Store(int32(LevelNormal))
+	before := GetStats()
+
+	level.Store(int32(LevelNormal))
+	assert.False(t, ShouldShed(PriorityLow))
+
+	level.Store(int32(LevelOverloaded))
+	assert.True(t, ShouldShed(PriorityLow))
+	assert.False(t, ShouldShed(PriorityHigh))
+
+	level.Store(int32(LevelCritical))
+	assert.True(t, ShouldShed(PriorityHigh))
+
+	after := GetStats()
+	assert.Equal(t, before.ShedLow+1, after.ShedLow)
+	assert.Equal(t, before.ShedHigh+1, after.ShedHigh)
+}
+
+func TestShouldShedBatch(t *testing.T) {
+	defer func() {
+		level.Store(int32(LevelNormal))
+		pressure.Store(0)
+	}()
+	before := GetStats()
+
+	// the batch requests are shed before the process is overloaded
+	level.Store(int32(LevelNormal))
+	pressure.Store(math.Float64bits(0.5))
+	assert.False(t, ShouldShed(PriorityBatch))
+	pressure.Store(math.Float64bits(0.85))
+	assert.True(t, ShouldShed(PriorityBatch))
+	assert.False(t, ShouldShed(PriorityLow))
+
+	level.Store(int32(LevelOverloaded))
+	assert.True(t, ShouldShed(PriorityBatch))
+	assert.False(t, ShouldShed(PriorityHigh))
+
+	assert.Equal(t, before.ShedBatch+2, GetStats().ShedBatch)
+}
+
+func TestSample(t *testing.T) {
+	settings = Settings{Goroutines: 1}
+	defer func() {
+		settings = Settings{}
+		level.Store(int32(LevelNormal))
+	}()
+	sample(0, false)
+	stats := GetStats()
+	assert.Greater(t, stats.Goroutines, 1)
+	assert.Equal(t, LevelCritical, stats.Level)
+}
@@ -18,7 +18,48 @@ func shouldAuth() bool {
 	return config.SMTPAccount != "" || config.SMTPToken != ""
 }
 
+// Attachment is a file attached to an email
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
 func SendEmail(subject string, receiver string, content string) error {
+	return SendEmailWithAttachments(subject, receiver, content, nil)
+}
+
+// mailBody returns the headers describing the content and the body of an email, a
+// multipart one when files are attached
+func mailBody(content string, attachments []Attachment) (string, error) {
+	if len(attachments) == 0 {
+		return fmt.Sprintf("Content-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", content), nil
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	boundary := fmt.Sprintf("%x", buf)
+	var body strings.Builder
+	body.WriteString(fmt.Sprintf("MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n", boundary))
+	body.WriteString(fmt.Sprintf("--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", boundary, content))
+	for _, attachment := range attachments {
+		body.WriteString(fmt.Sprintf("--%s\r\nContent-Type: %s\r\nContent-Transfer-Encoding: base64\r\n"+
+			"Content-Disposition: attachment; filename=\"%s\"\r\n\r\n", boundary, attachment.ContentType, attachment.Name))
+		encoded := base64.StdEncoding.EncodeToString(attachment.Data)
+		// lines of base64 are at most 76 characters, RFC 2045
+		for len(encoded) > 76 {
+			body.WriteString(encoded[:76] + "\r\n")
+			encoded = encoded[76:]
+		}
+		body.WriteString(encoded + "\r\n")
+	}
+	body.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
+	return body.String(), nil
+}
+
+// SendEmailWithAttachments is SendEmail attaching files to the email
+func SendEmailWithAttachments(subject string, receiver string, content string, attachments []Attachment) error {
 	if receiver == "" {
 		return fmt.Errorf("receiver is empty")
 	}
@@ -40,14 +81,17 @@ func SendEmail(subject string, receiver string, content string) error {
 		return err
 	}
 	messageId := fmt.Sprintf("<%x@%s>", buf, domain)
+	body, err := mailBody(content, attachments)
+	if err != nil {
+		return err
+	}
 
 	mail := []byte(fmt.Sprintf("To: %s\r\n"+
 		"From: %s<%s>\r\n"+
 		"Subject: %s\r\n"+
 		"Message-ID: %s\r\n"+ // add Message-ID header to avoid being treated as spam, RFC 5322
-		"Date: %s\r\n"+
-		"Content-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n",
-		receiver, config.SystemName, config.SMTPFrom, encodedSubject, messageId, time.Now().Format(time.RFC1123Z), content))
+		"Date: %s\r\n%s",
+		receiver, config.SystemName, config.SMTPFrom, encodedSubject, messageId, time.Now().Format(time.RFC1123Z), body))
 
 	auth := smtp.PlainAuth("", config.SMTPAccount, config.SMTPToken, config.SMTPServer)
 	addr := fmt.Sprintf("%s:%d", config.SMTPServer, config.SMTPPort)
@@ -0,0 +1,203 @@
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/env"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// IPOverride scales the IP based rate limits for clients inside CIDR,
+// e.g. a multiplier of 10 for an office sharing a single NAT address
+type IPOverride struct {
+	CIDR       string  `json:"cidr"`
+	Multiplier float64 `json:"multiplier"`
+}
+
+// IPPolicy controls how client IPs are resolved and limited by IP based rate limits
+type IPPolicy struct {
+	TrustedProxies      []string     `json:"trusted_proxies"`
+	ExemptCIDRs         []string     `json:"exempt_cidrs"`
+	ExemptTokenPrefixes []string     `json:"exempt_token_prefixes"`
+	Overrides           []IPOverride `json:"overrides"`
+
+	trustedProxies []*net.IPNet
+	exemptCIDRs    []*net.IPNet
+	overrides      []*net.IPNet
+}
+
+var ipPolicyLock sync.RWMutex
+var ipPolicy = newIPPolicyFromEnv()
+
+func newIPPolicyFromEnv() *IPPolicy {
+	policy := &IPPolicy{}
+	if trustedProxies := env.String("TRUSTED_PROXIES", ""); trustedProxies != "" {
+		policy.TrustedProxies = splitSubnets(trustedProxies)
+	}
+	if err := policy.compile(); err != nil {
+		logger.SysError("invalid TRUSTED_PROXIES: " + err.Error())
+		policy = &IPPolicy{}
+	}
+	return policy
+}
+
+// parseCIDR accepts both CIDRs and single addresses
+func parseCIDR(value string) (*net.IPNet, error) {
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid ip: %s", value)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse subnet: %w", err)
+	}
+	return ipNet, nil
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	ipNets := make([]*net.IPNet, 0, len(values))
+	for _, value := range values {
+		ipNet, err := parseCIDR(strings.TrimSpace(value))
+		if err != nil {
+			return nil, err
+		}
+		ipNets = append(ipNets, ipNet)
+	}
+	return ipNets, nil
+}
+
+func containsIP(ipNets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range ipNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *IPPolicy) compile() (err error) {
+	if p.trustedProxies, err = parseCIDRs(p.TrustedProxies); err != nil {
+		return err
+	}
+	if p.exemptCIDRs, err = parseCIDRs(p.ExemptCIDRs); err != nil {
+		return err
+	}
+	cidrs := make([]string, 0, len(p.Overrides))
+	for _, override := range p.Overrides {
+		if override.Multiplier <= 0 {
+			return fmt.Errorf("multiplier of %s must be greater than 0", override.CIDR)
+		}
+		cidrs = append(cidrs, override.CIDR)
+	}
+	p.overrides, err = parseCIDRs(cidrs)
+	return err
+}
+
+// HasTrustedProxies reports whether client IPs are resolved by the policy,
+// otherwise the framework default is kept for backward compatibility
+func (p *IPPolicy) HasTrustedProxies() bool {
+	return len(p.trustedProxies) > 0
+}
+
+// ClientIP walks X-Forwarded-For from right to left and returns the first
+// address that is not a trusted proxy
+func (p *IPPolicy) ClientIP(remoteIP string, forwardedFor string) string {
+	if !containsIP(p.trustedProxies, net.ParseIP(remoteIP)) || forwardedFor == "" {
+		return remoteIP
+	}
+	clientIP := remoteIP
+	hops := strings.Split(forwardedFor, ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		ip := net.ParseIP(hop)
+		if ip == nil {
+			break
+		}
+		clientIP = hop
+		if !containsIP(p.trustedProxies, ip) {
+			break
+		}
+	}
+	return clientIP
+}
+
+// IsExempt reports whether the request bypasses IP based rate limits
+func (p *IPPolicy) IsExempt(clientIP string, tokenKey string) bool {
+	if containsIP(p.exemptCIDRs, net.ParseIP(clientIP)) {
+		return true
+	}
+	if tokenKey == "" {
+		return false
+	}
+	for _, prefix := range p.ExemptTokenPrefixes {
+		if prefix != "" && strings.HasPrefix(tokenKey, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Multiplier returns the limit multiplier of the first override containing clientIP
+func (p *IPPolicy) Multiplier(clientIP string) float64 {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return 1
+	}
+	for i, ipNet := range p.overrides {
+		if ipNet.Contains(ip) {
+			return p.Overrides[i].Multiplier
+		}
+	}
+	return 1
+}
+
+func GetIPPolicy() *IPPolicy {
+	ipPolicyLock.RLock()
+	defer ipPolicyLock.RUnlock()
+	return ipPolicy
+}
+
+func IPPolicy2JSONString() string {
+	jsonBytes, err := json.Marshal(GetIPPolicy())
+	if err != nil {
+		logger.SysError("error marshalling ip policy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+// ValidateIPPolicyJSONString parses and compiles the policy without applying it
+func ValidateIPPolicyJSONString(jsonStr string) (*IPPolicy, error) {
+	policy := &IPPolicy{}
+	if err := json.Unmarshal([]byte(jsonStr), policy); err != nil {
+		return nil, err
+	}
+	if err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func UpdateIPPolicyByJSONString(jsonStr string) error {
+	policy, err := ValidateIPPolicyJSONString(jsonStr)
+	if err != nil {
+		return err
+	}
+	ipPolicyLock.Lock()
+	ipPolicy = policy
+	ipPolicyLock.Unlock()
+	return nil
+}
@@ -0,0 +1,27 @@
+package network
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestIPPolicy(t *testing.T) {
+	policy, err := ValidateIPPolicyJSONString(`{
+		"trusted_proxies": ["10.0.0.0/8"],
+		"exempt_cidrs": ["192.168.1.10"],
+		"exempt_token_prefixes": ["internal"],
+		"overrides": [{"cidr": "203.0.113.0/24", "multiplier": 10}]
+	}`)
+	Convey("TestIPPolicy", t, func() {
+		So(err, ShouldBeNil)
+		So(policy.ClientIP("10.0.0.2", "1.2.3.4, 5.6.7.8, 10.0.0.1"), ShouldEqual, "5.6.7.8")
+		So(policy.ClientIP("10.0.0.2", "10.0.0.3"), ShouldEqual, "10.0.0.3")
+		So(policy.ClientIP("8.8.8.8", "1.2.3.4"), ShouldEqual, "8.8.8.8")
+		So(policy.IsExempt("192.168.1.10", ""), ShouldBeTrue)
+		So(policy.IsExempt("192.168.1.11", "internal-abc"), ShouldBeTrue)
+		So(policy.IsExempt("192.168.1.11", "abc"), ShouldBeFalse)
+		So(policy.Multiplier("203.0.113.7"), ShouldEqual, 10)
+		So(policy.Multiplier("198.51.100.7"), ShouldEqual, 1)
+	})
+}
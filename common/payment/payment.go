@@ -0,0 +1,133 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+const (
+	StatusPaid   = "paid"
+	StatusFailed = "failed"
+)
+
+// signatureTolerance is how old a signed callback may be
+const signatureTolerance = 5 * time.Minute
+
+const requestTimeout = 30 * time.Second
+
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// Checkout is a top-up to be paid with a provider
+type Checkout struct {
+	TradeNo     string  // id of the payment in One API
+	Amount      float64 // in Currency
+	Currency    string
+	Description string
+	SuccessURL  string
+	CancelURL   string
+	CallbackURL string
+}
+
+// Session is a checkout created by a provider, the user pays at URL
+type Session struct {
+	Ref string // id of the payment in the provider
+	URL string
+}
+
+// Event is the outcome of a payment, told by a callback of its provider
+type Event struct {
+	TradeNo  string
+	Ref      string
+	Status   string  // paid or failed
+	Amount   float64 // in Currency, 0 when the provider did not tell it
+	Currency string
+}
+
+// Provider creates payments and verifies the callbacks telling their outcome
+type Provider interface {
+	Name() string
+	Create(ctx context.Context, checkout *Checkout) (*Session, error)
+	// Verify checks the signature of a callback and returns its event, nil when the
+	// callback is not about the outcome of a payment
+	Verify(header http.Header, body []byte) (*Event, error)
+}
+
+// Providers returns the providers configured
+func Providers() []Provider {
+	var providers []Provider
+	if config.PaymentStripeSecretKey != "" {
+		providers = append(providers, &Stripe{SecretKey: config.PaymentStripeSecretKey, WebhookSecret: config.PaymentStripeWebhookSecret})
+	}
+	if config.PaymentWebhookURL != "" {
+		providers = append(providers, &Webhook{URL: config.PaymentWebhookURL, Secret: config.PaymentWebhookSecret})
+	}
+	return providers
+}
+
+// ProviderNames returns the names of the providers configured
+func ProviderNames() []string {
+	names := make([]string, 0)
+	for _, provider := range Providers() {
+		names = append(names, provider.Name())
+	}
+	return names
+}
+
+func GetProvider(name string) (Provider, error) {
+	for _, provider := range Providers() {
+		if provider.Name() == name {
+			return provider, nil
+		}
+	}
+	return nil, fmt.Errorf("payment provider %s is not configured", name)
+}
+
+// sign returns the hex HMAC-SHA256 of "<timestamp>.<body>"
+func sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks a t=<timestamp>,v1=<signature> header, several v1 are accepted
+// while a secret is rolled
+func verifySignature(secret string, header string, body []byte, now time.Time) error {
+	if secret == "" {
+		return errors.New("the webhook secret of the payment provider is not set")
+	}
+	var timestamp int64
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if timestamp == 0 || len(signatures) == 0 {
+		return errors.New("missing or malformed signature")
+	}
+	if age := now.Sub(time.Unix(timestamp, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return errors.New("the timestamp of the signature is too old or in the future")
+	}
+	expected := sign(secret, timestamp, body)
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(expected), []byte(signature)) {
+			return nil
+		}
+	}
+	return errors.New("invalid signature")
+}
@@ -0,0 +1,66 @@
+package payment
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func signedHeader(name string, secret string, timestamp int64, body []byte) http.Header {
+	header := http.Header{}
+	header.Set(name, fmt.Sprintf("t=%d,v1=%s", timestamp, sign(secret, timestamp, body)))
+	return header
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"trade_no":"pay_1"}`)
+	now := time.Now()
+	header := fmt.Sprintf("t=%d,v1=%s", now.Unix(), sign("secret", now.Unix(), body))
+	assert.NoError(t, verifySignature("secret", header, body, now))
+	assert.NoError(t, verifySignature("secret", fmt.Sprintf("t=%d,v1=bad,v1=%s", now.Unix(), sign("secret", now.Unix(), body)), body, now))
+	assert.Error(t, verifySignature("other", header, body, now))
+	assert.Error(t, verifySignature("secret", header, []byte(`{"trade_no":"pay_2"}`), now))
+	assert.Error(t, verifySignature("secret", header, body, now.Add(10*time.Minute)))
+	assert.Error(t, verifySignature("secret", "v1=abc", body, now))
+	assert.Error(t, verifySignature("", header, body, now))
+}
+
+func TestStripeVerify(t *testing.T) {
+	stripe := &Stripe{WebhookSecret: "whsec"}
+	body := []byte(`{"type":"checkout.session.completed","data":{"object":{"id":"cs_1","client_reference_id":"pay_1","payment_status":"paid","amount_total":1000,"currency":"usd"}}}`)
+	event, err := stripe.Verify(signedHeader("Stripe-Signature", "whsec", time.Now().Unix(), body), body)
+	assert.NoError(t, err)
+	assert.Equal(t, &Event{TradeNo: "pay_1", Ref: "cs_1", Status: StatusPaid, Amount: 10, Currency: "USD"}, event)
+
+	body = []byte(`{"type":"checkout.session.completed","data":{"object":{"id":"cs_2","client_reference_id":"pay_2","payment_status":"paid","amount_total":250000,"currency":"vnd"}}}`)
+	event, err = stripe.Verify(signedHeader("Stripe-Signature", "whsec", time.Now().Unix(), body), body)
+	assert.NoError(t, err)
+	assert.Equal(t, 250000.0, event.Amount)
+
+	body = []byte(`{"type":"customer.created","data":{"object":{}}}`)
+	event, err = stripe.Verify(signedHeader("Stripe-Signature", "whsec", time.Now().Unix(), body), body)
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+
+	_, err = stripe.Verify(signedHeader("Stripe-Signature", "other", time.Now().Unix(), body), body)
+	assert.Error(t, err)
+}
+
+func TestWebhookVerify(t *testing.T) {
+	webhook := &Webhook{Secret: "secret"}
+	body := []byte(`{"id":"ref_1","trade_no":"pay_1","status":"failed","amount":5,"currency":"eur"}`)
+	event, err := webhook.Verify(signedHeader(webhookSignatureHeader, "secret", time.Now().Unix(), body), body)
+	assert.NoError(t, err)
+	assert.Equal(t, &Event{TradeNo: "pay_1", Ref: "ref_1", Status: StatusFailed, Amount: 5, Currency: "EUR"}, event)
+
+	body = []byte(`{"id":"ref_1","trade_no":"pay_1","status":"pending"}`)
+	event, err = webhook.Verify(signedHeader(webhookSignatureHeader, "secret", time.Now().Unix(), body), body)
+	assert.NoError(t, err)
+	assert.Nil(t, event)
+
+	_, err = webhook.Verify(http.Header{}, body)
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+package payment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stripeAPIURL = "https://api.stripe.com/v1/checkout/sessions"
+
+// zeroDecimalCurrencies are charged by Stripe in units instead of cents
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true, "MGA": true,
+	"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// Stripe takes the payments with Stripe Checkout, the outcome comes with the
+// checkout.session.completed and checkout.session.expired events of a Stripe webhook
+type Stripe struct {
+	SecretKey     string
+	WebhookSecret string
+	APIURL        string // the Stripe API by default
+}
+
+func (s *Stripe) Name() string {
+	return "stripe"
+}
+
+func toMinorUnits(amount float64, currency string) int64 {
+	if zeroDecimalCurrencies[currency] {
+		return int64(math.Round(amount))
+	}
+	return int64(math.Round(amount * 100))
+}
+
+func fromMinorUnits(amount int64, currency string) float64 {
+	if zeroDecimalCurrencies[currency] {
+		return float64(amount)
+	}
+	return float64(amount) / 100
+}
+
+type stripeSession struct {
+	Id                string `json:"id"`
+	URL               string `json:"url"`
+	ClientReferenceId string `json:"client_reference_id"`
+	PaymentStatus     string `json:"payment_status"`
+	AmountTotal       int64  `json:"amount_total"`
+	Currency          string `json:"currency"`
+}
+
+func (s *Stripe) Create(ctx context.Context, checkout *Checkout) (*Session, error) {
+	form := url.Values{}
+	form.Set("mode", "payment")
+	form.Set("client_reference_id", checkout.TradeNo)
+	form.Set("success_url", checkout.SuccessURL)
+	form.Set("cancel_url", checkout.CancelURL)
+	form.Set("line_items[0][quantity]", "1")
+	form.Set("line_items[0][price_data][currency]", strings.ToLower(checkout.Currency))
+	form.Set("line_items[0][price_data][unit_amount]", strconv.FormatInt(toMinorUnits(checkout.Amount, checkout.Currency), 10))
+	form.Set("line_items[0][price_data][product_data][name]", checkout.Description)
+	form.Set("metadata[trade_no]", checkout.TradeNo)
+	apiURL := s.APIURL
+	if apiURL == "" {
+		apiURL = stripeAPIURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	// a retried creation returns the same session
+	req.Header.Set("Idempotency-Key", checkout.TradeNo)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		var stripeError struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(body, &stripeError)
+		return nil, fmt.Errorf("stripe: status code %d: %s", resp.StatusCode, stripeError.Error.Message)
+	}
+	var session stripeSession
+	if err = json.Unmarshal(body, &session); err != nil {
+		return nil, err
+	}
+	return &Session{Ref: session.Id, URL: session.URL}, nil
+}
+
+func (s *Stripe) Verify(header http.Header, body []byte) (*Event, error) {
+	if err := verifySignature(s.WebhookSecret, header.Get("Stripe-Signature"), body, time.Now()); err != nil {
+		return nil, err
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object stripeSession `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	session := event.Data.Object
+	var status string
+	switch {
+	case event.Type == "checkout.session.completed" && session.PaymentStatus == "paid",
+		event.Type == "checkout.session.async_payment_succeeded":
+		status = StatusPaid
+	case event.Type == "checkout.session.expired", event.Type == "checkout.session.async_payment_failed":
+		status = StatusFailed
+	default:
+		return nil, nil
+	}
+	currency := strings.ToUpper(session.Currency)
+	return &Event{
+		TradeNo:  session.ClientReferenceId,
+		Ref:      session.Id,
+		Status:   status,
+		Amount:   fromMinorUnits(session.AmountTotal, currency),
+		Currency: currency,
+	}, nil
+}
@@ -0,0 +1,103 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook is a generic provider, for the payment services without a provider of their own.
+// One API posts the checkout as JSON to URL, which answers with the id and the url of the
+// payment, then the service posts the outcome to the callback url. Both ways, the body is
+// signed with the secret in the X-Payment-Signature header: t=<timestamp>,v1=<signature>,
+// the hex HMAC-SHA256 of "<timestamp>.<body>".
+type Webhook struct {
+	URL    string
+	Secret string
+}
+
+const webhookSignatureHeader = "X-Payment-Signature"
+
+func (w *Webhook) Name() string {
+	return "webhook"
+}
+
+type webhookCheckout struct {
+	TradeNo     string  `json:"trade_no"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Description string  `json:"description"`
+	SuccessURL  string  `json:"success_url"`
+	CancelURL   string  `json:"cancel_url"`
+	CallbackURL string  `json:"callback_url"`
+}
+
+// webhookEvent is the body of the callbacks, and of the answer to a checkout without status
+type webhookEvent struct {
+	Id       string  `json:"id"`
+	URL      string  `json:"url,omitempty"`
+	TradeNo  string  `json:"trade_no"`
+	Status   string  `json:"status"`
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+func (w *Webhook) Create(ctx context.Context, checkout *Checkout) (*Session, error) {
+	body, err := json.Marshal(webhookCheckout(*checkout))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s", timestamp, sign(w.Secret, timestamp, body)))
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("payment provider: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
+	var session webhookEvent
+	if err = json.Unmarshal(respBody, &session); err != nil {
+		return nil, err
+	}
+	if session.URL == "" {
+		return nil, errors.New("payment provider: no payment url")
+	}
+	return &Session{Ref: session.Id, URL: session.URL}, nil
+}
+
+func (w *Webhook) Verify(header http.Header, body []byte) (*Event, error) {
+	if err := verifySignature(w.Secret, header.Get(webhookSignatureHeader), body, time.Now()); err != nil {
+		return nil, err
+	}
+	var event webhookEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.Status != StatusPaid && event.Status != StatusFailed {
+		return nil, nil
+	}
+	return &Event{
+		TradeNo:  event.TradeNo,
+		Ref:      event.Id,
+		Status:   event.Status,
+		Amount:   event.Amount,
+		Currency: strings.ToUpper(event.Currency),
+	}, nil
+}
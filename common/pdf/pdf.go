@@ -0,0 +1,127 @@
+// Package pdf writes plain text documents, enough for the statements, with the
+// standard fonts every reader has, so no font is embedded. The text is encoded in
+// WinAnsiEncoding, the characters it lacks are printed as ?.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 595 // A4 in points
+	pageHeight = 842
+	margin     = 50
+)
+
+// Fonts of the lines, Courier keeps the columns of tables aligned
+const (
+	Regular = "F1" // Helvetica
+	Bold    = "F2" // Helvetica-Bold
+	Mono    = "F3" // Courier
+)
+
+var fontNames = map[string]string{
+	Regular: "Helvetica",
+	Bold:    "Helvetica-Bold",
+	Mono:    "Courier",
+}
+
+type line struct {
+	font string
+	size float64
+	text string
+}
+
+// Document is a list of lines laid out top to bottom on A4 pages
+type Document struct {
+	pages [][]line
+	y     float64
+}
+
+func New() *Document {
+	return &Document{}
+}
+
+// Line adds a line of text, on a new page when the current one is full
+func (d *Document) Line(font string, size float64, text string) {
+	height := size * 1.4
+	if len(d.pages) == 0 || d.y-height < margin {
+		d.pages = append(d.pages, nil)
+		d.y = pageHeight - margin
+	}
+	d.y -= height
+	d.pages[len(d.pages)-1] = append(d.pages[len(d.pages)-1], line{font: font, size: size, text: text})
+}
+
+// Space adds an empty line
+func (d *Document) Space(size float64) {
+	d.Line(Regular, size, "")
+}
+
+// escape encodes text in WinAnsiEncoding and escapes it for a string of a content stream
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b.WriteByte(byte(r))
+		case r == '€':
+			b.WriteByte(0x80)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.pages = append(d.pages, nil)
+	}
+	var objects []string
+	// 1 is the catalog, 2 the page tree, 3 to 5 the fonts, then a page and its content per page
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", 6+2*i))
+	}
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	for _, font := range []string{Regular, Bold, Mono} {
+		objects = append(objects, fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", fontNames[font]))
+	}
+	for i, page := range d.pages {
+		var content bytes.Buffer
+		y := float64(pageHeight - margin)
+		for _, l := range page {
+			y -= l.size * 1.4
+			if l.text == "" {
+				continue
+			}
+			fmt.Fprintf(&content, "BT /%s %.1f Tf %d %.1f Td (%s) Tj ET\n", l.font, l.size, margin, y, escape(l.text))
+		}
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>", pageWidth, pageHeight, 7+2*i))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()))
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return out.Bytes()
+}
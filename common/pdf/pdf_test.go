@@ -0,0 +1,40 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, `a \(b\) \\ c`, escape(`a (b) \ c`))
+	assert.Equal(t, "caf\xe9 \x80 ?", escape("café € 中"))
+}
+
+func TestBytes(t *testing.T) {
+	doc := New()
+	doc.Line(Bold, 16, "Statement")
+	for i := 0; i < 100; i++ {
+		doc.Line(Mono, 7, fmt.Sprintf("line %d", i))
+	}
+	out := doc.Bytes()
+	assert.True(t, bytes.HasPrefix(out, []byte("%PDF-1.4\n")))
+	assert.True(t, bytes.HasSuffix(out, []byte("%%EOF\n")))
+	assert.Contains(t, string(out), "/Count 2")
+	assert.Contains(t, string(out), "(line 99) Tj")
+
+	// every offset of the cross-reference table points at its object
+	xref := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(out)
+	start, _ := strconv.Atoi(string(xref[1]))
+	assert.True(t, bytes.HasPrefix(out[start:], []byte("xref\n")))
+	offsets := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(out, -1)
+	assert.Len(t, offsets, 9)
+	for i, offset := range offsets {
+		n, _ := strconv.Atoi(string(offset[1]))
+		assert.True(t, bytes.HasPrefix(out[n:], []byte(fmt.Sprintf("%d 0 obj", i+1))))
+	}
+}
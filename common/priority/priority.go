@@ -0,0 +1,37 @@
+package priority
+
+// The priority of a relay request is the class of its token. The interactive requests are
+// admitted, retried and kept under overload before the standard ones, the batch requests after
+// them.
+
+const (
+	Interactive = "interactive"
+	Standard    = "standard"
+	Batch       = "batch"
+)
+
+// Classes are the classes from the highest priority to the lowest
+var Classes = []string{Interactive, Standard, Batch}
+
+// IsValid tells whether a class can be set on a token, empty for standard
+func IsValid(class string) bool {
+	return class == "" || rank(class) >= 0
+}
+
+// Of returns the class of a token, standard when it has none
+func Of(class string) string {
+	if class == "" {
+		return Standard
+	}
+	return class
+}
+
+// rank returns the order of a class in Classes, -1 when it is unknown
+func rank(class string) int {
+	for i, c := range Classes {
+		if c == class {
+			return i
+		}
+	}
+	return -1
+}
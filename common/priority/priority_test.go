@@ -0,0 +1,61 @@
+package priority
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueOrder(t *testing.T) {
+	queue := NewQueue(1)
+	release, err := queue.Acquire(context.Background(), Standard)
+	assert.Nil(t, err)
+
+	admitted := make(chan string, 3)
+	for _, class := range []string{Batch, Standard, Interactive} {
+		class := class
+		go func() {
+			release, err := queue.Acquire(context.Background(), class)
+			assert.Nil(t, err)
+			admitted <- class
+			release()
+		}()
+		// waits in the order of the loop
+		for queue.Waiting(class) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	release()
+	assert.Equal(t, Interactive, <-admitted)
+	assert.Equal(t, Standard, <-admitted)
+	assert.Equal(t, Batch, <-admitted)
+	assert.Equal(t, 0, queue.Running())
+}
+
+func TestQueueTimeout(t *testing.T) {
+	queue := NewQueue(1)
+	release, err := queue.Acquire(context.Background(), "")
+	assert.Nil(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = queue.Acquire(ctx, Interactive)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, queue.Waiting(Interactive))
+	release()
+	release()
+	assert.Equal(t, 0, queue.Running())
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := retryBudgets[Batch]
+	budget.balance = 1
+	assert.True(t, WithdrawRetryBudget(Batch))
+	assert.False(t, WithdrawRetryBudget(Batch))
+	for i := 0; i < 20; i++ {
+		DepositRetryBudget(Batch)
+	}
+	assert.True(t, WithdrawRetryBudget(Batch))
+	budget.balance = retryBudgetCap
+}
@@ -0,0 +1,119 @@
+package priority
+
+import (
+	"context"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// Queue admits at most its capacity of requests at a time. The others wait for a slot, which
+// is given to the waiting request of the highest class, then of the earliest arrival.
+type Queue struct {
+	lock     sync.Mutex
+	capacity int
+	running  int
+	waiting  [][]chan struct{} // by rank
+}
+
+func NewQueue(capacity int) *Queue {
+	return &Queue{capacity: capacity, waiting: make([][]chan struct{}, len(Classes))}
+}
+
+var relayQueue *Queue
+var relayQueueOnce sync.Once
+
+// RelayQueue returns the queue of the relay requests of the node, nil without
+// RELAY_MAX_CONCURRENCY
+func RelayQueue() *Queue {
+	relayQueueOnce.Do(func() {
+		if config.RelayMaxConcurrency > 0 {
+			relayQueue = NewQueue(config.RelayMaxConcurrency)
+		}
+	})
+	return relayQueue
+}
+
+// Acquire waits for a slot until ctx is done, the slot is freed by calling release once
+func (q *Queue) Acquire(ctx context.Context, class string) (release func(), err error) {
+	r := rank(Of(class))
+	if r < 0 {
+		r = rank(Standard)
+	}
+	q.lock.Lock()
+	if q.running < q.capacity && q.waitingLocked() == 0 {
+		q.running++
+		q.lock.Unlock()
+		return q.releaser(), nil
+	}
+	ready := make(chan struct{})
+	q.waiting[r] = append(q.waiting[r], ready)
+	q.lock.Unlock()
+
+	select {
+	case <-ready:
+		return q.releaser(), nil
+	case <-ctx.Done():
+		q.lock.Lock()
+		removed := q.removeLocked(r, ready)
+		q.lock.Unlock()
+		if !removed {
+			// the slot was given in between
+			q.releaser()()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Waiting returns the number of requests of a class waiting for a slot
+func (q *Queue) Waiting(class string) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.waiting[rank(class)])
+}
+
+// Running returns the number of requests holding a slot
+func (q *Queue) Running() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.running
+}
+
+func (q *Queue) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(q.release)
+	}
+}
+
+// release gives the slot to the next waiting request, or frees it
+func (q *Queue) release() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for r, waiting := range q.waiting {
+		if len(waiting) > 0 {
+			close(waiting[0])
+			q.waiting[r] = waiting[1:]
+			return
+		}
+	}
+	q.running--
+}
+
+func (q *Queue) waitingLocked() int {
+	n := 0
+	for _, waiting := range q.waiting {
+		n += len(waiting)
+	}
+	return n
+}
+
+func (q *Queue) removeLocked(r int, ready chan struct{}) bool {
+	for i, w := range q.waiting[r] {
+		if w == ready {
+			q.waiting[r] = append(q.waiting[r][:i:i], q.waiting[r][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package priority
+
+import (
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// A retry budget caps the retries of a class to a ratio of its requests, so the retries of an
+// outage don't multiply the load on the channels left, and the batch requests give up first.
+// Every request deposits the ratio of its class and every retry withdraws one, the balance is
+// capped at retryBudgetCap to allow the retries of a burst.
+
+const retryBudgetCap = 100
+
+type retryBudget struct {
+	lock    sync.Mutex
+	balance float64
+}
+
+var retryBudgets = map[string]*retryBudget{
+	Interactive: {balance: retryBudgetCap},
+	Standard:    {balance: retryBudgetCap},
+	Batch:       {balance: retryBudgetCap},
+}
+
+func retryRatio(class string) float64 {
+	switch class {
+	case Interactive:
+		return config.RetryBudgetInteractive
+	case Batch:
+		return config.RetryBudgetBatch
+	default:
+		return config.RetryBudgetStandard
+	}
+}
+
+func budgetOf(class string) *retryBudget {
+	if budget, ok := retryBudgets[class]; ok {
+		return budget
+	}
+	return retryBudgets[Standard]
+}
+
+// DepositRetryBudget adds a request of a class to its retry budget
+func DepositRetryBudget(class string) {
+	budget := budgetOf(class)
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	budget.balance += retryRatio(class)
+	if budget.balance > retryBudgetCap {
+		budget.balance = retryBudgetCap
+	}
+}
+
+// WithdrawRetryBudget takes a retry of a class from its budget, false when it is used up
+func WithdrawRetryBudget(class string) bool {
+	budget := budgetOf(class)
+	budget.lock.Lock()
+	defer budget.lock.Unlock()
+	if budget.balance < 1 {
+		return false
+	}
+	budget.balance--
+	return true
+}
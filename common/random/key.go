@@ -0,0 +1,54 @@
+package random
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"math/big"
+	"strings"
+)
+
+const keyLength = 48
+const keyChecksumLength = 6
+
+// GeneratePrefixedKey returns a key of 48 characters: the prefix, an underscore, random
+// characters and a checksum of the rest. Secret scanners recognize a leaked key by its
+// prefix and the checksum rejects a mistyped key without looking it up.
+func GeneratePrefixedKey(prefix string) string {
+	body := prefix + "_"
+	n := keyLength - len(body) - keyChecksumLength
+	max := big.NewInt(int64(len(keyChars)))
+	random := make([]byte, n)
+	for i := range random {
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		random[i] = keyChars[index.Int64()]
+	}
+	body += string(random)
+	return body + keyChecksum(body)
+}
+
+func keyChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+	checksum := make([]byte, keyChecksumLength)
+	for i := keyChecksumLength - 1; i >= 0; i-- {
+		checksum[i] = keyChars[sum%uint32(len(keyChars))]
+		sum /= uint32(len(keyChars))
+	}
+	return string(checksum)
+}
+
+// IsPrefixedKey tells whether a key has a prefix, the keys issued before have none
+func IsPrefixedKey(key string) bool {
+	return strings.Contains(key, "_")
+}
+
+// CheckPrefixedKey tells whether the checksum of a prefixed key is valid
+func CheckPrefixedKey(key string) bool {
+	if len(key) != keyLength {
+		return false
+	}
+	body, checksum := key[:keyLength-keyChecksumLength], key[keyLength-keyChecksumLength:]
+	return keyChecksum(body) == checksum
+}
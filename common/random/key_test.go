@@ -0,0 +1,23 @@
+package random
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratePrefixedKey(t *testing.T) {
+	key := GeneratePrefixedKey("oneapi")
+	assert.Len(t, key, 48)
+	assert.True(t, strings.HasPrefix(key, "oneapi_"))
+	assert.True(t, IsPrefixedKey(key))
+	assert.True(t, CheckPrefixedKey(key))
+	assert.NotEqual(t, key, GeneratePrefixedKey("oneapi"))
+
+	typo := []byte(key)
+	typo[10] = typo[10] ^ 1
+	assert.False(t, CheckPrefixedKey(string(typo)))
+	assert.False(t, CheckPrefixedKey(key[:47]))
+	assert.False(t, IsPrefixedKey(GenerateKey()))
+}
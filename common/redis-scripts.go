@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/sha1"
 	"encoding/hex"
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
@@ -97,6 +98,92 @@ else
 end
 `
 
+// gcraRateLimitScript implements the generic cell rate algorithm (leaky bucket as a meter)
+// Only the theoretical arrival time (TAT) is stored, so memory is O(1) per key
+// KEYS[1]: the rate limit key
+// ARGV[1]: current timestamp in milliseconds
+// ARGV[2]: emission interval in milliseconds (window / max requests)
+// ARGV[3]: window size in milliseconds, the whole quota can be used as a burst
+// All arguments are integers so that the stored TAT keeps full precision
+// Returns: {allowed (0/1), remaining, reset_at_ms}
+// reset_at_ms is when the next request is allowed if rejected, or when the bucket drains otherwise
+const gcraRateLimitScript = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local window = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call('GET', key))
+if tat == nil or tat < now then
+    tat = now
+end
+
+local new_tat = tat + emission
+if new_tat - now > window then
+    return {0, 0, new_tat - window}
+end
+
+redis.call('SET', key, new_tat, 'PX', new_tat - now)
+local remaining = math.floor((window - (new_tat - now)) / emission)
+return {1, remaining, new_tat}
+`
+
+// gcraUndoScript takes back a request recorded by gcraRateLimitScript, keeping the expiry
+// KEYS[1]: the rate limit key
+// ARGV[1]: emission interval in milliseconds
+const gcraUndoScript = `
+local key = KEYS[1]
+local tat = tonumber(redis.call('GET', key))
+if tat == nil then
+    return 0
+end
+local ttl = redis.call('PTTL', key)
+if ttl > 0 then
+    redis.call('SET', key, tat - tonumber(ARGV[1]), 'PX', ttl)
+end
+return 1
+`
+
+// tpmBucketScript debits a token bucket refilled with its limit every minute. The balance can
+// go negative, the tokens used over it are paid by the next refills.
+// KEYS[1]: the bucket key
+// ARGV[1]: current timestamp in milliseconds
+// ARGV[2]: limit, tokens per minute
+// ARGV[3]: tokens to debit, negative to credit
+// ARGV[4]: 1 to debit only while the balance is positive
+// The balance is stored in 1/60000 tokens, so the refill of a millisecond is the limit
+// Returns: {debited (0/1), balance, retry_after_ms}
+const tpmBucketScript = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local debit = tonumber(ARGV[3]) * 60000
+local admission = tonumber(ARGV[4])
+local capacity = limit * 60000
+
+local bucket = redis.call('HMGET', key, 'balance', 'updated_at')
+local balance = tonumber(bucket[1])
+local updated_at = tonumber(bucket[2])
+if balance == nil then
+    balance = capacity
+    updated_at = now
+end
+if now > updated_at then
+    balance = math.min(capacity, balance + (now - updated_at) * limit)
+else
+    now = updated_at
+end
+
+if admission == 1 and balance <= 0 then
+    return {0, math.floor(balance / 60000), math.floor(-balance / limit) + 1}
+end
+
+balance = math.min(capacity, balance - debit)
+redis.call('HMSET', key, 'balance', balance, 'updated_at', now)
+redis.call('PEXPIRE', key, math.ceil((capacity - balance) / limit) + 1000)
+return {1, math.floor(balance / 60000), 0}
+`
+
 // decrementQuotaScript atomically decrements user quota
 // KEYS[1]: the quota key
 // ARGV[1]: amount to decrement
@@ -121,6 +208,34 @@ redis.call('DECRBY', key, decrement)
 return {new_value, 1}
 `
 
+// takeBatchUpdateScript moves the pending batch updates to the flushing hash,
+// unless a previous flush crashed or failed and left updates there
+// KEYS[1]: the pending hash
+// KEYS[2]: the flushing hash
+// Returns: 1 if the flushing hash holds updates to apply, 0 otherwise
+const takeBatchUpdateScript = `
+if redis.call('EXISTS', KEYS[2]) == 1 then
+    return 1
+end
+if redis.call('EXISTS', KEYS[1]) == 0 then
+    return 0
+end
+redis.call('RENAME', KEYS[1], KEYS[2])
+return 1
+`
+
+// releaseLockScript deletes a lock only if it is still held by its owner, a lock which expired
+// and was taken by another node is left alone
+// KEYS[1]: the lock key
+// ARGV[1]: the token of the owner
+// Returns: 1 if the lock was released, 0 otherwise
+const releaseLockScript = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    return redis.call('DEL', KEYS[1])
+end
+return 0
+`
+
 // RedisScriptManager manages Lua scripts with caching
 type RedisScriptManager struct {
 	scripts     map[string]string
@@ -150,7 +265,12 @@ func GetScriptManager() *RedisScriptManager {
 func (m *RedisScriptManager) registerBuiltinScripts() {
 	m.scripts["sliding_window_rate_limit"] = slidingWindowRateLimitScript
 	m.scripts["token_bucket_rate_limit"] = tokenBucketRateLimitScript
+	m.scripts["gcra_rate_limit"] = gcraRateLimitScript
+	m.scripts["gcra_undo"] = gcraUndoScript
+	m.scripts["tpm_bucket"] = tpmBucketScript
 	m.scripts["decrement_quota"] = decrementQuotaScript
+	m.scripts["take_batch_update"] = takeBatchUpdateScript
+	m.scripts["release_lock"] = releaseLockScript
 }
 
 // calculateSHA1 calculates the SHA1 hash of a script
@@ -183,6 +303,24 @@ func (m *RedisScriptManager) LoadScripts(ctx context.Context) error {
 	return nil
 }
 
+// CheckScripts loads the scripts to Redis again, it returns the errors by script name
+func (m *RedisScriptManager) CheckScripts(ctx context.Context) map[string]error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	failed := make(map[string]error)
+	for name, script := range m.scripts {
+		sha, err := RDB.ScriptLoad(ctx, script).Result()
+		if err != nil {
+			failed[name] = err
+			continue
+		}
+		m.scriptSHAs[name] = sha
+	}
+	m.initialized = true
+	return failed
+}
+
 // GetScriptSHA returns the SHA of a script, loading if necessary
 func (m *RedisScriptManager) GetScriptSHA(ctx context.Context, name string) (string, error) {
 	m.mu.RLock()
@@ -297,6 +435,67 @@ func SlidingWindowRateLimit(ctx context.Context, key string, maxRequests int, wi
 	}, nil
 }
 
+// GCRARateLimit performs GCRA rate limiting using Redis Lua script
+// It has the same limit and burst as SlidingWindowRateLimit but smooth pacing afterwards
+func GCRARateLimit(ctx context.Context, key string, maxRequests int, window time.Duration) (*RateLimitResult, error) {
+	if !RedisEnabled {
+		return &RateLimitResult{Allowed: true, Remaining: maxRequests - 1}, nil
+	}
+
+	result, err := GetScriptManager().RunScript(
+		ctx,
+		"gcra_rate_limit",
+		[]string{"gcra:" + key},
+		gcraScriptArgs(maxRequests, window, time.Now())...,
+	).Result()
+
+	if err != nil {
+		logger.SysError("GCRARateLimit script error: " + err.Error())
+		return &RateLimitResult{Allowed: true, Remaining: maxRequests - 1}, nil
+	}
+
+	arr, ok := result.([]interface{})
+	if !ok || len(arr) < 3 {
+		return &RateLimitResult{Allowed: true, Remaining: maxRequests - 1}, nil
+	}
+
+	return &RateLimitResult{
+		Allowed:   toInt64(arr[0]) == 1,
+		Remaining: int(toInt64(arr[1])),
+		ResetAt:   time.UnixMilli(toInt64(arr[2])),
+	}, nil
+}
+
+// UndoSlidingWindowRateLimit takes back the last request recorded by SlidingWindowRateLimit
+func UndoSlidingWindowRateLimit(ctx context.Context, key string) error {
+	if !RedisEnabled {
+		return nil
+	}
+	return RDB.ZPopMax(ctx, "ratelimit:"+key, 1).Err()
+}
+
+// UndoGCRARateLimit takes back a request recorded by GCRARateLimit
+func UndoGCRARateLimit(ctx context.Context, key string, maxRequests int, window time.Duration) error {
+	if !RedisEnabled {
+		return nil
+	}
+	return GetScriptManager().RunScript(ctx, "gcra_undo", []string{"gcra:" + key}, gcraEmissionMs(maxRequests, window)).Err()
+}
+
+// gcraEmissionMs returns the time between two requests paced by GCRA
+func gcraEmissionMs(maxRequests int, window time.Duration) int64 {
+	emissionMs := window.Milliseconds() / int64(maxRequests)
+	if emissionMs <= 0 {
+		emissionMs = 1
+	}
+	return emissionMs
+}
+
+// gcraScriptArgs returns the arguments of gcraRateLimitScript for a request at now
+func gcraScriptArgs(maxRequests int, window time.Duration, now time.Time) []interface{} {
+	return []interface{}{now.UnixMilli(), gcraEmissionMs(maxRequests, window), window.Milliseconds()}
+}
+
 // TokenBucketRateLimit performs token bucket rate limiting using Redis Lua script
 func TokenBucketRateLimit(ctx context.Context, key string, capacity int, refillRate float64, tokens int) (*RateLimitResult, error) {
 	if !RedisEnabled {
@@ -336,6 +535,32 @@ func TokenBucketRateLimit(ctx context.Context, key string, capacity int, refillR
 	}, nil
 }
 
+// TPMBucketDebit debits tokens from a TPM bucket using Redis Lua script, with admission set
+// only while its balance is positive
+func TPMBucketDebit(ctx context.Context, key string, limit int, tokens int, admission bool) (debited bool, balance int64, retryAfter time.Duration, err error) {
+	admissionArg := 0
+	if admission {
+		admissionArg = 1
+	}
+	result, err := GetScriptManager().RunScript(
+		ctx,
+		"tpm_bucket",
+		[]string{"tpm:" + key},
+		time.Now().UnixMilli(),
+		limit,
+		tokens,
+		admissionArg,
+	).Result()
+	if err != nil {
+		return false, 0, 0, err
+	}
+	arr, ok := result.([]interface{})
+	if !ok || len(arr) < 3 {
+		return false, 0, 0, fmt.Errorf("unexpected result of the tpm bucket script: %v", result)
+	}
+	return toInt64(arr[0]) == 1, toInt64(arr[1]), time.Duration(toInt64(arr[2])) * time.Millisecond, nil
+}
+
 // AtomicDecrementQuota atomically decrements quota using Lua script
 func AtomicDecrementQuota(ctx context.Context, key string, amount int64, minValue int64) (int64, bool, error) {
 	if !RedisEnabled {
@@ -365,6 +590,34 @@ func AtomicDecrementQuota(ctx context.Context, key string, amount int64, minValu
 	return newValue, wasUpdated, nil
 }
 
+// TakeBatchUpdates returns the updates of the flushing hash, moving the pending ones there first
+// if it is empty. Applied updates must be removed from the flushing hash with HDEL.
+func TakeBatchUpdates(ctx context.Context, pendingKey string, flushingKey string) (map[string]string, error) {
+	result, err := GetScriptManager().RunScript(
+		ctx,
+		"take_batch_update",
+		[]string{pendingKey, flushingKey},
+	).Result()
+	if err != nil {
+		return nil, err
+	}
+	if toInt64(result) == 0 {
+		return nil, nil
+	}
+	return RDB.HGetAll(ctx, flushingKey).Result()
+}
+
+// AcquireLock takes a lock for ttl, identified by a token unique to the owner, it returns false
+// when another owner holds it
+func AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	return RDB.SetNX(ctx, key, token, ttl).Result()
+}
+
+// ReleaseLock releases a lock taken with AcquireLock, unless it expired and was taken again
+func ReleaseLock(ctx context.Context, key string, token string) error {
+	return GetScriptManager().RunScript(ctx, "release_lock", []string{key}, token).Err()
+}
+
 // toInt64 converts interface{} to int64
 func toInt64(v interface{}) int64 {
 	switch val := v.(type) {
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// runScript runs a Lua script the way Redis does, with the GET, SET and PTTL commands of
+// redis.call backed by store, without expiry, and returns its reply converted to integers
+func runScript(t *testing.T, script string, store map[string]string, keys []string, args ...interface{}) []int64 {
+	L := lua.NewState()
+	defer L.Close()
+	redis := L.NewTable()
+	L.SetField(redis, "call", L.NewFunction(func(L *lua.LState) int {
+		switch command := strings.ToUpper(L.CheckString(1)); command {
+		case "GET":
+			if value, ok := store[L.CheckString(2)]; ok {
+				L.Push(lua.LString(value))
+			} else {
+				L.Push(lua.LFalse)
+			}
+		case "SET":
+			store[L.CheckString(2)] = L.ToString(3)
+			L.Push(lua.LString("OK"))
+		case "PTTL":
+			if _, ok := store[L.CheckString(2)]; ok {
+				L.Push(lua.LNumber(time.Minute.Milliseconds()))
+			} else {
+				L.Push(lua.LNumber(-2))
+			}
+		default:
+			L.RaiseError("unsupported command %s", command)
+		}
+		return 1
+	}))
+	L.SetGlobal("redis", redis)
+	keyTable := L.NewTable()
+	for _, key := range keys {
+		keyTable.Append(lua.LString(key))
+	}
+	L.SetGlobal("KEYS", keyTable)
+	argTable := L.NewTable()
+	for _, arg := range args {
+		argTable.Append(lua.LString(fmt.Sprint(arg)))
+	}
+	L.SetGlobal("ARGV", argTable)
+	require.NoError(t, L.DoString(script))
+
+	if number, ok := L.Get(-1).(lua.LNumber); ok {
+		return []int64{int64(number)}
+	}
+	reply, ok := L.Get(-1).(*lua.LTable)
+	require.True(t, ok, "the script must return a number or a table")
+	values := make([]int64, 0, reply.Len())
+	reply.ForEach(func(_ lua.LValue, value lua.LValue) {
+		// Redis truncates the Lua numbers to integers
+		values = append(values, int64(lua.LVAsNumber(value)))
+	})
+	return values
+}
+
+func TestGCRAScriptMatchesMemory(t *testing.T) {
+	start := time.UnixMilli(1700000000000)
+	testCases := []struct {
+		name          string
+		maxRequestNum int
+		duration      int64
+		offsets       []time.Duration // time of the requests since start
+		allowed       []bool
+	}{
+		{
+			name:          "burst then paced",
+			maxRequestNum: 10,
+			duration:      1,
+			offsets:       []time.Duration{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 50 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond},
+			allowed:       []bool{true, true, true, true, true, true, true, true, true, true, false, false, true, false, true, true, false},
+		},
+		{
+			name:          "refilled after the window",
+			maxRequestNum: 5,
+			duration:      60,
+			offsets:       []time.Duration{0, 0, 0, 0, 0, 0, 11 * time.Second, 12 * time.Second, 12 * time.Second, 2 * time.Minute, 2 * time.Minute},
+			allowed:       []bool{true, true, true, true, true, false, false, true, false, true, true},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var limiter ShardedRateLimiter
+			limiter.Init(time.Minute)
+			store := make(map[string]string)
+			window := time.Duration(testCase.duration) * time.Second
+			for i, offset := range testCase.offsets {
+				now := start.Add(offset)
+				allowed, remaining, resetAt := limiter.gcraRequest("key", testCase.maxRequestNum, testCase.duration, now)
+				reply := runScript(t, gcraRateLimitScript, store, []string{"gcra:key"}, gcraScriptArgs(testCase.maxRequestNum, window, now)...)
+				require.Len(t, reply, 3)
+				assert.Equal(t, testCase.allowed[i], allowed, "request %d in memory", i)
+				assert.Equal(t, testCase.allowed[i], reply[0] == 1, "request %d in Redis", i)
+				assert.Equal(t, remaining, int(reply[1]), "remaining of request %d", i)
+				assert.Equal(t, resetAt, (reply[2]+999)/1000, "reset of request %d", i)
+			}
+		})
+	}
+}
+
+func TestGCRAUndoScriptMatchesMemory(t *testing.T) {
+	var limiter ShardedRateLimiter
+	limiter.Init(time.Minute)
+	store := make(map[string]string)
+	now := time.UnixMilli(1700000000000)
+	request := func() (bool, bool) {
+		allowed, _, _ := limiter.gcraRequest("key", 3, 60, now)
+		reply := runScript(t, gcraRateLimitScript, store, []string{"gcra:key"}, gcraScriptArgs(3, time.Minute, now)...)
+		return allowed, reply[0] == 1
+	}
+	for i := 0; i < 3; i++ {
+		allowed, scriptAllowed := request()
+		assert.True(t, allowed)
+		assert.True(t, scriptAllowed)
+	}
+	allowed, scriptAllowed := request()
+	assert.False(t, allowed)
+	assert.False(t, scriptAllowed)
+
+	limiter.GCRAUndoRequest("key", 3, 60)
+	reply := runScript(t, gcraUndoScript, store, []string{"gcra:key"}, gcraEmissionMs(3, time.Minute))
+	assert.Equal(t, []int64{1}, reply)
+	allowed, scriptAllowed = request()
+	assert.True(t, allowed, "the request taken back is allowed again")
+	assert.True(t, scriptAllowed, "the request taken back is allowed again")
+	allowed, scriptAllowed = request()
+	assert.False(t, allowed)
+	assert.False(t, scriptAllowed)
+}
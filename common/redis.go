@@ -2,11 +2,16 @@ package common
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"github.com/songquanpeng/one-api/common/env"
 	"github.com/songquanpeng/one-api/common/logger"
 )
 
@@ -26,32 +31,211 @@ func InitRedisClient() (err error) {
 		return nil
 	}
 	redisConnString := os.Getenv("REDIS_CONN_STRING")
+	tlsConfig, err := redisTLSConfig()
+	if err != nil {
+		logger.FatalLog("failed to configure Redis TLS: " + err.Error())
+	}
+	pool := getRedisPoolOptions()
 	if os.Getenv("REDIS_MASTER_NAME") == "" {
 		logger.SysLog("Redis is enabled")
 		opt, err := redis.ParseURL(redisConnString)
 		if err != nil {
 			logger.FatalLog("failed to parse Redis connection string: " + err.Error())
 		}
+		if username := os.Getenv("REDIS_USERNAME"); username != "" {
+			opt.Username = username
+		}
+		if password := os.Getenv("REDIS_PASSWORD"); password != "" {
+			opt.Password = password
+		}
+		pool.apply(opt)
+		if tlsConfig != nil {
+			opt.TLSConfig = nil
+			opt.Dialer = redisDialer(tlsConfig, opt.DialTimeout)
+		}
 		RDB = redis.NewClient(opt)
 	} else {
-		// cluster mode
-		logger.SysLog("Redis cluster mode enabled")
-		RDB = redis.NewUniversalClient(&redis.UniversalOptions{
-			Addrs:      strings.Split(redisConnString, ","),
-			Password:   os.Getenv("REDIS_PASSWORD"),
-			MasterName: os.Getenv("REDIS_MASTER_NAME"),
-		})
-	}
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err = RDB.Ping(ctx).Result()
+		// sentinel mode, REDIS_CONN_STRING lists the sentinels
+		logger.SysLog("Redis sentinel mode enabled")
+		opt := &redis.FailoverOptions{
+			MasterName:       os.Getenv("REDIS_MASTER_NAME"),
+			SentinelAddrs:    strings.Split(redisConnString, ","),
+			SentinelUsername: os.Getenv("REDIS_SENTINEL_USERNAME"),
+			SentinelPassword: os.Getenv("REDIS_SENTINEL_PASSWORD"),
+			Username:         os.Getenv("REDIS_USERNAME"),
+			Password:         os.Getenv("REDIS_PASSWORD"),
+			DB:               env.Int("REDIS_DB", 0),
+			PoolSize:         pool.PoolSize,
+			MinIdleConns:     pool.MinIdleConns,
+			MaxRetries:       pool.MaxRetries,
+			DialTimeout:      pool.DialTimeout,
+			ReadTimeout:      pool.ReadTimeout,
+			WriteTimeout:     pool.WriteTimeout,
+			PoolTimeout:      pool.PoolTimeout,
+			IdleTimeout:      pool.IdleTimeout,
+		}
+		if tlsConfig != nil {
+			opt.Dialer = redisDialer(tlsConfig, pool.DialTimeout)
+		}
+		RDB = redis.NewFailoverClient(opt)
+	}
+
+	// Redis may be failing over while the gateway starts, give it some time
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = RDB.Ping(ctx).Result()
+		cancel()
+		if err == nil || attempt == redisConnectAttempts {
+			break
+		}
+		logger.SysWarnf("Redis ping test failed, retrying: %s", err.Error())
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
 	if err != nil {
 		logger.FatalLog("Redis ping test failed: " + err.Error())
 	}
+	go watchRedis(redisWatchInterval)
 	return err
 }
 
+const (
+	redisConnectAttempts = 5
+	redisWatchInterval   = 5 * time.Second
+)
+
+// redisPoolOptions tunes the connections to Redis, the zero values keep the defaults of the
+// client: 10 connections per CPU, 3 retries and timeouts of 5 seconds to dial and 3 to read
+// and write
+type redisPoolOptions struct {
+	PoolSize     int
+	MinIdleConns int
+	MaxRetries   int
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	PoolTimeout  time.Duration
+	IdleTimeout  time.Duration
+}
+
+// apply sets the options which are set, keeping those of the connection string otherwise
+func (p redisPoolOptions) apply(opt *redis.Options) {
+	if p.PoolSize != 0 {
+		opt.PoolSize = p.PoolSize
+	}
+	if p.MinIdleConns != 0 {
+		opt.MinIdleConns = p.MinIdleConns
+	}
+	if p.MaxRetries != 0 {
+		opt.MaxRetries = p.MaxRetries
+	}
+	if p.DialTimeout != 0 {
+		opt.DialTimeout = p.DialTimeout
+	}
+	if p.ReadTimeout != 0 {
+		opt.ReadTimeout = p.ReadTimeout
+	}
+	if p.WriteTimeout != 0 {
+		opt.WriteTimeout = p.WriteTimeout
+	}
+	if p.PoolTimeout != 0 {
+		opt.PoolTimeout = p.PoolTimeout
+	}
+	if p.IdleTimeout != 0 {
+		opt.IdleTimeout = p.IdleTimeout
+	}
+}
+
+func getRedisPoolOptions() redisPoolOptions {
+	seconds := func(name string) time.Duration {
+		return time.Duration(env.Int(name, 0)) * time.Second
+	}
+	return redisPoolOptions{
+		PoolSize:     env.Int("REDIS_POOL_SIZE", 0),
+		MinIdleConns: env.Int("REDIS_MIN_IDLE_CONNS", 0),
+		MaxRetries:   env.Int("REDIS_MAX_RETRIES", 0),
+		DialTimeout:  seconds("REDIS_DIAL_TIMEOUT"),
+		ReadTimeout:  seconds("REDIS_READ_TIMEOUT"),
+		WriteTimeout: seconds("REDIS_WRITE_TIMEOUT"),
+		PoolTimeout:  seconds("REDIS_POOL_TIMEOUT"),
+		IdleTimeout:  seconds("REDIS_IDLE_TIMEOUT"),
+	}
+}
+
+// redisTLSConfig returns the TLS config of REDIS_TLS_ENABLED, nil when disabled. A rediss://
+// connection string enables TLS with the system CAs without it.
+func redisTLSConfig() (*tls.Config, error) {
+	if !env.Bool("REDIS_TLS_ENABLED", false) {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         os.Getenv("REDIS_TLS_SERVER_NAME"),
+		InsecureSkipVerify: env.Bool("REDIS_TLS_INSECURE_SKIP_VERIFY", false),
+	}
+	if caFile := os.Getenv("REDIS_TLS_CA_FILE"); caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	certFile, keyFile := os.Getenv("REDIS_TLS_CERT_FILE"), os.Getenv("REDIS_TLS_KEY_FILE")
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// redisDialer dials Redis, and the sentinels, over TLS, verifying the certificate against the
+// host dialed unless REDIS_TLS_SERVER_NAME is set: the master changes on a failover
+func redisDialer(tlsConfig *tls.Config, timeout time.Duration) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	dialer := &net.Dialer{Timeout: timeout, KeepAlive: 5 * time.Minute}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		config := tlsConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		return tls.Client(conn, config), nil
+	}
+}
+
+// watchRedis logs when Redis becomes unreachable and reachable again. The client reconnects
+// by itself, to the new master after a sentinel failover; the scripts are loaded again as the
+// new master may not have them.
+func watchRedis(interval time.Duration) {
+	reachable := true
+	for {
+		time.Sleep(interval)
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := RDB.Ping(ctx).Err()
+		if err != nil && reachable {
+			logger.SysError("Redis is unreachable: " + err.Error())
+			reachable = false
+		} else if err == nil && !reachable {
+			logger.SysLog("Redis is reachable again")
+			reachable = true
+			if err := GetScriptManager().LoadScripts(ctx); err != nil {
+				logger.SysError("failed to load Redis scripts: " + err.Error())
+			}
+		}
+		cancel()
+	}
+}
+
 func ParseRedisOption() *redis.Options {
 	opt, err := redis.ParseURL(os.Getenv("REDIS_CONN_STRING"))
 	if err != nil {
@@ -60,6 +244,15 @@ func ParseRedisOption() *redis.Options {
 	return opt
 }
 
+// TenantKey prefixes a key shared by the users of a tenant, such as the cached models of a
+// group, with the tenant; the keys of the installation, the tenant 0, have no prefix
+func TenantKey(tenantId int, key string) string {
+	if tenantId == 0 {
+		return key
+	}
+	return fmt.Sprintf("tenant:%d:%s", tenantId, key)
+}
+
 func RedisSet(key string, value string, expiration time.Duration) error {
 	ctx := context.Background()
 	return RDB.Set(ctx, key, value, expiration).Err()
@@ -79,3 +272,19 @@ func RedisDecrease(key string, value int64) error {
 	ctx := context.Background()
 	return RDB.DecrBy(ctx, key, value).Err()
 }
+
+func RedisPublish(channel string, message string) error {
+	ctx := context.Background()
+	return RDB.Publish(ctx, channel, message).Err()
+}
+
+// RedisSubscribe subscribes to channels, it returns nil when the client can't subscribe
+func RedisSubscribe(ctx context.Context, channels ...string) *redis.PubSub {
+	subscriber, ok := RDB.(interface {
+		Subscribe(ctx context.Context, channels ...string) *redis.PubSub
+	})
+	if !ok {
+		return nil
+	}
+	return subscriber.Subscribe(ctx, channels...)
+}
@@ -0,0 +1,43 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedisTLSConfig(t *testing.T) {
+	t.Setenv("REDIS_TLS_ENABLED", "false")
+	tlsConfig, err := redisTLSConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, tlsConfig)
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.Nil(t, os.WriteFile(caFile, ca, 0600))
+
+	t.Setenv("REDIS_TLS_ENABLED", "true")
+	t.Setenv("REDIS_TLS_CA_FILE", caFile)
+	tlsConfig, err = redisTLSConfig()
+	assert.Nil(t, err)
+
+	// the certificate is verified against the host dialed
+	conn, err := redisDialer(tlsConfig, time.Second)(context.Background(), "tcp", server.Listener.Addr().String())
+	assert.Nil(t, err)
+	assert.Nil(t, conn.(*tls.Conn).Handshake())
+	_ = conn.Close()
+
+	assert.Nil(t, os.WriteFile(caFile, []byte("not a certificate"), 0600))
+	_, err = redisTLSConfig()
+	assert.NotNil(t, err)
+}
@@ -28,3 +28,9 @@ func ObjectData(c *gin.Context, object interface{}) error {
 func Done(c *gin.Context) {
 	StringData(c, "[DONE]")
 }
+
+// Comment sends an SSE comment, which clients ignore, e.g. a keep-alive
+func Comment(c *gin.Context, comment string) {
+	_, _ = c.Writer.WriteString(":" + strings.TrimPrefix(comment, ":") + "\n\n")
+	c.Writer.Flush()
+}
@@ -0,0 +1,334 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// The scheduler runs the periodic jobs of the gateway on one replica at a time. With Redis, the
+// replicas compete for a lock per job on each run, so the job goes on when a replica is gone;
+// without Redis, the jobs run on the master node only. The runs are recorded, in Redis when
+// enabled so that every replica lists the runs of the others.
+
+const (
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+
+	defaultTimeout = 10 * time.Minute
+	historySize    = 20
+)
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrRunning  = errors.New("job is already running")
+)
+
+// Job is a periodic task
+type Job struct {
+	Name        string
+	Description string
+	Interval    time.Duration
+	// random delay added to every interval, so the replicas do not all compete at once
+	Jitter time.Duration
+	// bounds a run, the lock of the job is held as long
+	Timeout time.Duration
+	// runs the job once at start instead of after the first interval
+	Immediate bool
+	Run       func(ctx context.Context) error
+
+	lock    sync.Mutex
+	running bool
+	nextRun time.Time
+	history []Run // of this node, without Redis
+}
+
+// Run is a run of a job
+type Run struct {
+	StartedAt int64  `json:"started_at"`
+	Duration  int64  `json:"duration"` // unit is ms
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	Node      string `json:"node"`
+	Manual    bool   `json:"manual"`
+}
+
+// JobStatus is a job as listed by the admin API
+type JobStatus struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Interval    int64  `json:"interval"` // unit is second
+	Jitter      int64  `json:"jitter"`   // unit is second
+	Running     bool   `json:"running"`  // on this node
+	NextRun     int64  `json:"next_run"` // on this node, the run may go to another one
+	LastRun     *Run   `json:"last_run"`
+	History     []Run  `json:"history"` // the latest first
+}
+
+var (
+	jobs     = make(map[string]*Job)
+	jobsLock sync.RWMutex
+	started  bool
+	nodeName = defaultNodeName()
+)
+
+func defaultNodeName() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = random.GetRandomString(8)
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// Register adds a job, to be called before Start
+func Register(job *Job) {
+	if job.Timeout <= 0 {
+		job.Timeout = defaultTimeout
+	}
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	if _, ok := jobs[job.Name]; ok {
+		logger.SysError("job registered twice: " + job.Name)
+		return
+	}
+	jobs[job.Name] = job
+	if started {
+		go loop(job)
+	}
+}
+
+// Start runs the registered jobs, and those registered later, on their schedule
+func Start() {
+	jobsLock.Lock()
+	defer jobsLock.Unlock()
+	if started {
+		return
+	}
+	started = true
+	for _, job := range jobs {
+		go loop(job)
+	}
+}
+
+func (j *Job) delay() time.Duration {
+	delay := j.Interval
+	if j.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(j.Jitter)))
+	}
+	return delay
+}
+
+func loop(job *Job) {
+	delay := job.delay()
+	if job.Immediate {
+		delay = 0
+	}
+	for {
+		job.lock.Lock()
+		job.nextRun = time.Now().Add(delay)
+		job.lock.Unlock()
+		time.Sleep(delay)
+		if err := runJob(job, false); err != nil && !errors.Is(err, ErrRunning) {
+			logger.SysError(fmt.Sprintf("job %s failed: %s", job.Name, err.Error()))
+		}
+		delay = job.delay()
+	}
+}
+
+// Trigger runs a job now in background, unless it is running on any node
+func Trigger(name string) error {
+	jobsLock.RLock()
+	job, ok := jobs[name]
+	jobsLock.RUnlock()
+	if !ok {
+		return ErrNotFound
+	}
+	release, err := job.acquire(true)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := job.execute(release, true); err != nil {
+			logger.SysError(fmt.Sprintf("job %s failed: %s", job.Name, err.Error()))
+		}
+	}()
+	return nil
+}
+
+// runJob runs a job if no node runs it, it returns ErrRunning otherwise
+func runJob(job *Job, manual bool) error {
+	release, err := job.acquire(manual)
+	if err != nil {
+		return err
+	}
+	return job.execute(release, manual)
+}
+
+func lockKey(name string) string {
+	return "scheduler:lock:" + name
+}
+
+func historyKey(name string) string {
+	return "scheduler:history:" + name
+}
+
+// acquire marks the job running on this node and takes its lock, it returns the function
+// releasing both
+func (j *Job) acquire(manual bool) (func(), error) {
+	j.lock.Lock()
+	if j.running {
+		j.lock.Unlock()
+		return nil, ErrRunning
+	}
+	j.running = true
+	j.lock.Unlock()
+	release := func() {
+		j.lock.Lock()
+		j.running = false
+		j.lock.Unlock()
+	}
+	if !common.RedisEnabled {
+		if !config.IsMasterNode && !manual {
+			release()
+			return nil, ErrRunning
+		}
+		return release, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	token := nodeName + ":" + random.GetRandomString(8)
+	acquired, err := common.AcquireLock(ctx, lockKey(j.Name), token, j.Timeout)
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to lock job: %w", err)
+	}
+	if !acquired {
+		release()
+		return nil, ErrRunning
+	}
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := common.ReleaseLock(ctx, lockKey(j.Name), token); err != nil {
+			logger.SysError(fmt.Sprintf("failed to unlock job %s: %s", j.Name, err.Error()))
+		}
+		release()
+	}, nil
+}
+
+func (j *Job) execute(release func(), manual bool) (err error) {
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), j.Timeout)
+	defer cancel()
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+		run := Run{
+			StartedAt: start.Unix(),
+			Duration:  time.Since(start).Milliseconds(),
+			Status:    RunSucceeded,
+			Node:      nodeName,
+			Manual:    manual,
+		}
+		if err != nil {
+			run.Status = RunFailed
+			run.Error = err.Error()
+		}
+		j.record(run)
+	}()
+	return j.Run(ctx)
+}
+
+func (j *Job) record(run Run) {
+	if common.RedisEnabled {
+		data, _ := json.Marshal(run)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := common.RDB.LPush(ctx, historyKey(j.Name), string(data)).Err()
+		if err == nil {
+			err = common.RDB.LTrim(ctx, historyKey(j.Name), 0, historySize-1).Err()
+		}
+		if err == nil {
+			return
+		}
+		logger.SysError(fmt.Sprintf("failed to record run of job %s: %s", j.Name, err.Error()))
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	j.history = append([]Run{run}, j.history...)
+	if len(j.history) > historySize {
+		j.history = j.history[:historySize]
+	}
+}
+
+func (j *Job) runs() []Run {
+	if common.RedisEnabled {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		values, err := common.RDB.LRange(ctx, historyKey(j.Name), 0, historySize-1).Result()
+		if err == nil {
+			runs := make([]Run, 0, len(values))
+			for _, value := range values {
+				var run Run
+				if json.Unmarshal([]byte(value), &run) == nil {
+					runs = append(runs, run)
+				}
+			}
+			return runs
+		}
+	}
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return append([]Run(nil), j.history...)
+}
+
+// List returns the jobs by name with their latest runs
+func List() []JobStatus {
+	jobsLock.RLock()
+	list := make([]*Job, 0, len(jobs))
+	for _, job := range jobs {
+		list = append(list, job)
+	}
+	jobsLock.RUnlock()
+	sort.Slice(list, func(i, k int) bool { return list[i].Name < list[k].Name })
+	statuses := make([]JobStatus, 0, len(list))
+	for _, job := range list {
+		history := job.runs()
+		status := JobStatus{
+			Name:        job.Name,
+			Description: job.Description,
+			Interval:    int64(job.Interval / time.Second),
+			Jitter:      int64(job.Jitter / time.Second),
+			History:     history,
+		}
+		if len(history) > 0 {
+			status.LastRun = &history[0]
+		}
+		job.lock.Lock()
+		status.Running = job.running
+		if !job.nextRun.IsZero() {
+			status.NextRun = job.nextRun.Unix()
+		}
+		job.lock.Unlock()
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Node returns the name the runs of this node are recorded with
+func Node() string {
+	return nodeName
+}
@@ -0,0 +1,65 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common"
+)
+
+func findJob(name string) *JobStatus {
+	for _, status := range List() {
+		if status.Name == name {
+			return &status
+		}
+	}
+	return nil
+}
+
+func TestTrigger(t *testing.T) {
+	common.RedisEnabled = false
+	release := make(chan struct{})
+	Register(&Job{
+		Name:     "test_trigger",
+		Interval: time.Hour,
+		Run: func(ctx context.Context) error {
+			<-release
+			return errors.New("boom")
+		},
+	})
+
+	assert.ErrorIs(t, Trigger("missing"), ErrNotFound)
+	assert.NoError(t, Trigger("test_trigger"))
+	assert.ErrorIs(t, Trigger("test_trigger"), ErrRunning)
+	close(release)
+	assert.Eventually(t, func() bool {
+		status := findJob("test_trigger")
+		return status.LastRun != nil && !status.Running
+	}, time.Second, 10*time.Millisecond)
+
+	status := findJob("test_trigger")
+	assert.Equal(t, RunFailed, status.LastRun.Status)
+	assert.Equal(t, "boom", status.LastRun.Error)
+	assert.True(t, status.LastRun.Manual)
+	assert.Equal(t, int64(3600), status.Interval)
+}
+
+func TestPanicIsRecorded(t *testing.T) {
+	common.RedisEnabled = false
+	job := &Job{
+		Name: "test_panic",
+		Run: func(ctx context.Context) error {
+			panic("oops")
+		},
+	}
+	Register(job)
+	err := runJob(job, true)
+	assert.EqualError(t, err, "panic: oops")
+	runs := job.runs()
+	assert.Len(t, runs, 1)
+	assert.Equal(t, RunFailed, runs[0].Status)
+}
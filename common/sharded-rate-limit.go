@@ -12,6 +12,13 @@ const (
 	ShardCount = 256
 )
 
+const (
+	// RateLimitAlgorithmSlidingWindow keeps a timestamp per request inside the window
+	RateLimitAlgorithmSlidingWindow = "sliding_window"
+	// RateLimitAlgorithmGCRA keeps a single theoretical arrival time per key
+	RateLimitAlgorithmGCRA = "gcra"
+)
+
 // shard represents a single shard with its own lock and data
 type shard struct {
 	store map[string]*rateLimitEntry
@@ -22,6 +29,8 @@ type shard struct {
 type rateLimitEntry struct {
 	timestamps []int64
 	lastAccess int64
+	// tat is the GCRA theoretical arrival time in nanoseconds
+	tat int64
 }
 
 // ShardedRateLimiter implements a high-performance rate limiter using sharding
@@ -107,8 +116,8 @@ func (l *ShardedRateLimiter) cleanupShard(s *shard, now, expirationSeconds int64
 			continue
 		}
 
-		// Also remove entries with empty timestamps
-		if len(entry.timestamps) == 0 {
+		// Also remove entries with empty timestamps and no pending GCRA state
+		if len(entry.timestamps) == 0 && entry.tat <= now*int64(time.Second) {
 			delete(s.store, key)
 		}
 	}
@@ -208,6 +217,72 @@ func (l *ShardedRateLimiter) RequestWithInfo(key string, maxRequestNum int, dura
 	return false, 0, resetAt
 }
 
+// UndoRequest takes back the last request recorded for key by RequestWithInfo
+func (l *ShardedRateLimiter) UndoRequest(key string) {
+	s := l.getShard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if entry, exists := s.store[key]; exists && len(entry.timestamps) > 0 {
+		entry.timestamps = entry.timestamps[:len(entry.timestamps)-1]
+	}
+}
+
+// GCRARequestWithInfo applies the generic cell rate algorithm (leaky bucket as a meter).
+// It allows maxRequestNum requests per duration seconds, the whole quota being usable
+// as a burst, and afterwards paces requests one emission interval apart.
+// Memory usage is a single timestamp per key regardless of request volume.
+func (l *ShardedRateLimiter) GCRARequestWithInfo(key string, maxRequestNum int, duration int64) (allowed bool, remaining int, resetAt int64) {
+	return l.gcraRequest(key, maxRequestNum, duration, time.Now())
+}
+
+func (l *ShardedRateLimiter) gcraRequest(key string, maxRequestNum int, duration int64, at time.Time) (allowed bool, remaining int, resetAt int64) {
+	s := l.getShard(key)
+	now := at.UnixNano()
+	window := duration * int64(time.Second)
+	emission := window / int64(maxRequestNum)
+	if emission <= 0 {
+		emission = 1
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, exists := s.store[key]
+	if !exists {
+		entry = &rateLimitEntry{}
+		s.store[key] = entry
+	}
+	entry.lastAccess = now / int64(time.Second)
+
+	tat := entry.tat
+	if tat < now {
+		tat = now
+	}
+	newTat := tat + emission
+	if newTat-now > window {
+		// the next request is allowed once newTat falls back into the window
+		allowAt := newTat - window
+		return false, 0, (allowAt + int64(time.Second) - 1) / int64(time.Second)
+	}
+	entry.tat = newTat
+	remaining = int((window - (newTat - now)) / emission)
+	return true, remaining, (newTat + int64(time.Second) - 1) / int64(time.Second)
+}
+
+// GCRAUndoRequest takes back a request recorded for key by GCRARequestWithInfo
+func (l *ShardedRateLimiter) GCRAUndoRequest(key string, maxRequestNum int, duration int64) {
+	s := l.getShard(key)
+	emission := duration * int64(time.Second) / int64(maxRequestNum)
+	if emission <= 0 {
+		emission = 1
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if entry, exists := s.store[key]; exists {
+		entry.tat -= emission
+	}
+}
+
 // GetStats returns statistics about the rate limiter
 func (l *ShardedRateLimiter) GetStats() map[string]int {
 	stats := make(map[string]int)
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGCRAMatchesSlidingWindowBurst(t *testing.T) {
+	var limiter ShardedRateLimiter
+	limiter.Init(time.Minute)
+
+	const maxRequestNum = 5
+	for i := 0; i < maxRequestNum; i++ {
+		slidingAllowed, slidingRemaining, _ := limiter.RequestWithInfo("sliding", maxRequestNum, 60)
+		gcraAllowed, gcraRemaining, _ := limiter.GCRARequestWithInfo("gcra", maxRequestNum, 60)
+		assert.True(t, slidingAllowed)
+		assert.True(t, gcraAllowed)
+		assert.Equal(t, slidingRemaining, gcraRemaining, "request %d", i)
+	}
+
+	slidingAllowed, _, _ := limiter.RequestWithInfo("sliding", maxRequestNum, 60)
+	gcraAllowed, gcraRemaining, gcraResetAt := limiter.GCRARequestWithInfo("gcra", maxRequestNum, 60)
+	assert.False(t, slidingAllowed)
+	assert.False(t, gcraAllowed)
+	assert.Equal(t, 0, gcraRemaining)
+	// the next request is allowed one emission interval (60s / 5) later
+	assert.InDelta(t, time.Now().Add(12*time.Second).Unix(), gcraResetAt, 1)
+}
+
+func TestGCRAPacesAfterBurst(t *testing.T) {
+	var limiter ShardedRateLimiter
+	limiter.Init(time.Minute)
+
+	// 10 requests per second means one emission interval is 100ms
+	now := time.UnixMilli(1700000000000)
+	for i := 0; i < 10; i++ {
+		allowed, _, _ := limiter.gcraRequest("paced", 10, 1, now)
+		assert.True(t, allowed)
+	}
+	allowed, _, _ := limiter.gcraRequest("paced", 10, 1, now)
+	assert.False(t, allowed)
+
+	now = now.Add(120 * time.Millisecond)
+	allowed, _, _ = limiter.gcraRequest("paced", 10, 1, now)
+	assert.True(t, allowed, "one request should be allowed after a single emission interval")
+	allowed, _, _ = limiter.gcraRequest("paced", 10, 1, now)
+	assert.False(t, allowed)
+}
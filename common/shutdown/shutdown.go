@@ -0,0 +1,105 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Phase orders the shutdown hooks, hooks of the same phase run concurrently
+type Phase int
+
+const (
+	// PhaseFlush writes buffered data, e.g. the log batcher and the batch updater
+	PhaseFlush Phase = iota
+	// PhasePersist saves in-memory state to be restored on the next start
+	PhasePersist
+	// PhaseClose releases connection pools and databases
+	PhaseClose
+	phaseCount
+)
+
+var phaseNames = [phaseCount]string{"flush", "persist", "close"}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+var (
+	hooksLock sync.Mutex
+	hooks     [phaseCount][]hook
+	draining  atomic.Bool
+)
+
+// Register adds a hook run by Shutdown once the in-flight requests are drained
+func Register(phase Phase, name string, fn func(ctx context.Context) error) {
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	hooks[phase] = append(hooks[phase], hook{name: name, fn: fn})
+}
+
+// Draining reports whether the server is shutting down
+func Draining() bool {
+	return draining.Load()
+}
+
+// Shutdown stops accepting requests, waits for the in-flight ones, relay streams
+// included, up to SHUTDOWN_DRAIN_TIMEOUT, then runs the hooks phase by phase
+// within SHUTDOWN_TIMEOUT
+func Shutdown(server *http.Server) {
+	draining.Store(true)
+	logger.SysLog("shutting down, draining in-flight requests")
+	drainCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownDrainTimeout)*time.Second)
+	err := server.Shutdown(drainCtx)
+	cancel()
+	if errors.Is(err, context.DeadlineExceeded) {
+		logger.SysError("drain timeout exceeded, closing the remaining connections")
+		_ = server.Close()
+	} else if err != nil {
+		logger.SysError("failed to shut down the http server: " + err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.ShutdownTimeout)*time.Second)
+	defer cancel()
+	runHooks(ctx)
+	logger.SysLog("shutdown completed")
+}
+
+func runHooks(ctx context.Context) {
+	hooksLock.Lock()
+	phases := hooks
+	hooksLock.Unlock()
+	for phase, phaseHooks := range phases {
+		var wg sync.WaitGroup
+		for _, h := range phaseHooks {
+			wg.Add(1)
+			go func(h hook) {
+				defer wg.Done()
+				start := time.Now()
+				if err := h.fn(ctx); err != nil {
+					logger.SysError("shutdown " + phaseNames[phase] + " " + h.name + " failed: " + err.Error())
+					return
+				}
+				logger.SysLogf("shutdown %s %s done in %v", phaseNames[phase], h.name, time.Since(start))
+			}(h)
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			logger.SysError("shutdown timeout exceeded during the " + phaseNames[phase] + " phase")
+			return
+		}
+	}
+}
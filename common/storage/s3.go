@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/client"
+)
+
+const s3RequestTimeout = 5 * time.Minute
+
+// S3Storage keeps the objects in an S3 compatible bucket, addressed path-style
+// by the endpoint, e.g. https://s3.us-east-1.amazonaws.com/bucket
+type S3Storage struct {
+	endpoint   string
+	signer     client.RequestSigner
+	httpClient *http.Client
+}
+
+func NewS3Storage(endpoint string, region string, accessKey string, secretKey string) *S3Storage {
+	return &S3Storage{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		signer:     client.AWSSigV4Signer(accessKey, secretKey, region, "s3"),
+		httpClient: &http.Client{Timeout: s3RequestTimeout},
+	}
+}
+
+func (s *S3Storage) do(ctx context.Context, method string, key string, data []byte) ([]byte, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint+"/"+strings.TrimPrefix(key, "/"), body)
+	if err != nil {
+		return nil, err
+	}
+	// S3 requires the payload hash to be sent along with the signature
+	hash := sha256.Sum256(data)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+	if err = s.signer.Sign(req); err != nil {
+		return nil, err
+	}
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, key, data)
+	return err
+}
+
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, key, nil)
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	_, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
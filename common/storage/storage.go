@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// ErrNotFound is returned when the object of a key does not exist
+var ErrNotFound = errors.New("object not found")
+
+// Storage keeps the content of the uploaded files, by key
+type Storage interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var defaultStorage Storage
+
+// Init sets up the storage selected by FILE_STORAGE, local (default) or s3
+func Init() error {
+	switch config.FileStorage {
+	case "", "local":
+		defaultStorage = NewLocalStorage(config.FileStorageDir)
+	case "s3":
+		if config.FileStorageS3Endpoint == "" {
+			return errors.New("FILE_STORAGE_S3_ENDPOINT is required by the s3 file storage")
+		}
+		defaultStorage = NewS3Storage(config.FileStorageS3Endpoint, config.FileStorageS3Region,
+			config.FileStorageS3AccessKey, config.FileStorageS3SecretKey)
+	default:
+		return fmt.Errorf("unknown file storage %s", config.FileStorage)
+	}
+	logger.SysLogf("using %s file storage", config.FileStorage)
+	return nil
+}
+
+// GetStorage returns the storage set up by Init, the local one if Init was not called
+func GetStorage() Storage {
+	if defaultStorage == nil {
+		defaultStorage = NewLocalStorage(config.FileStorageDir)
+	}
+	return defaultStorage
+}
@@ -0,0 +1,123 @@
+package tokenizer
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkoukk/tiktoken-go"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// defaultEncoding counts the tokens of the models of other vendors, whose tokenizers are
+// not public or not available in Go, it is close to most of them
+const defaultEncoding = tiktoken.MODEL_CL100K_BASE
+
+// encodingPrefixes map the model families to their encodings, the first prefix matching wins
+var encodingPrefixes = []struct {
+	prefix   string
+	encoding string
+}{
+	{"gpt-4o", tiktoken.MODEL_O200K_BASE},
+	{"chatgpt-4o", tiktoken.MODEL_O200K_BASE},
+	{"gpt-4.1", tiktoken.MODEL_O200K_BASE},
+	{"gpt-4.5", tiktoken.MODEL_O200K_BASE},
+	{"gpt-5", tiktoken.MODEL_O200K_BASE},
+	{"gpt-oss", tiktoken.MODEL_O200K_BASE},
+	{"gpt-image", tiktoken.MODEL_O200K_BASE},
+	{"codex-", tiktoken.MODEL_O200K_BASE},
+	{"o1", tiktoken.MODEL_O200K_BASE},
+	{"o3", tiktoken.MODEL_O200K_BASE},
+	{"o4", tiktoken.MODEL_O200K_BASE},
+	{"gpt-4", tiktoken.MODEL_CL100K_BASE},
+	{"gpt-3.5", tiktoken.MODEL_CL100K_BASE},
+	{"text-embedding", tiktoken.MODEL_CL100K_BASE},
+}
+
+var (
+	encodersLock sync.Mutex
+	// encoders holds the encodings loaded, nil for those which failed to load
+	encoders = map[string]*tiktoken.Tiktoken{}
+)
+
+// Init loads the encodings of the common models, the others are loaded when first used
+func Init() {
+	logger.SysLog("initializing tokenizer")
+	tiktoken.SetBpeLoader(vocabLoader{})
+	for _, encoding := range []string{tiktoken.MODEL_CL100K_BASE, tiktoken.MODEL_O200K_BASE} {
+		if getEncoder(encoding) == nil {
+			logger.SysError("tokens of the " + encoding + " models are estimated, " +
+				"if you are using in offline environment, please set TIKTOKEN_CACHE_DIR to a directory with the encoding files, or TOKENIZER_VOCAB_URL to a mirror of them")
+		}
+	}
+	logger.SysLog("tokenizer initialized")
+}
+
+// EncodingForModel returns the name of the encoding counting the tokens of the model
+func EncodingForModel(model string) string {
+	// models of aggregators are named like openai/gpt-4o
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	for _, family := range encodingPrefixes {
+		if strings.HasPrefix(model, family.prefix) {
+			return family.encoding
+		}
+	}
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encoding
+	}
+	return defaultEncoding
+}
+
+func getEncoder(encoding string) *tiktoken.Tiktoken {
+	encodersLock.Lock()
+	defer encodersLock.Unlock()
+	encoder, ok := encoders[encoding]
+	if ok {
+		return encoder
+	}
+	encoder, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		logger.SysError("failed to load the " + encoding + " encoding: " + err.Error())
+		encoder = nil
+	}
+	encoders[encoding] = encoder
+	return encoder
+}
+
+// Count returns the tokens of the text for the model, they are estimated when the
+// approximate token option is on or when the encoding of the model could not be loaded
+func Count(text string, model string) int {
+	if text == "" {
+		return 0
+	}
+	if config.ApproximateTokenEnabled {
+		return Estimate(text)
+	}
+	encoder := getEncoder(EncodingForModel(model))
+	if encoder == nil {
+		return Estimate(text)
+	}
+	return len(encoder.Encode(text, nil, nil))
+}
+
+// Estimate estimates the tokens of the text without encoding: about 4 characters a token
+// for the latin scripts, a token a character for the CJK ones, 2 characters a token else
+func Estimate(text string) int {
+	var latin, cjk, other int
+	for _, r := range text {
+		switch {
+		case r < utf8.RuneSelf:
+			latin++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			cjk++
+		default:
+			other++
+		}
+	}
+	return (latin+3)/4 + cjk + (other+1)/2
+}
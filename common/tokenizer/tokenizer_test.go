@@ -0,0 +1,57 @@
+package tokenizer
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestEncodingForModel(t *testing.T) {
+	assert.Equal(t, tiktoken.MODEL_O200K_BASE, EncodingForModel("gpt-4o-mini"))
+	assert.Equal(t, tiktoken.MODEL_O200K_BASE, EncodingForModel("o3-mini"))
+	assert.Equal(t, tiktoken.MODEL_O200K_BASE, EncodingForModel("openai/gpt-4.1"))
+	assert.Equal(t, tiktoken.MODEL_CL100K_BASE, EncodingForModel("gpt-4-turbo"))
+	assert.Equal(t, tiktoken.MODEL_CL100K_BASE, EncodingForModel("gpt-3.5-turbo-0125"))
+	assert.Equal(t, tiktoken.MODEL_P50K_BASE, EncodingForModel("text-davinci-003"))
+	assert.Equal(t, defaultEncoding, EncodingForModel("claude-3-5-sonnet-20241022"))
+}
+
+func TestEstimate(t *testing.T) {
+	assert.Equal(t, 0, Estimate(""))
+	assert.Equal(t, 3, Estimate("Hello world!"))
+	assert.Equal(t, 4, Estimate("你好世界"))
+	assert.Equal(t, 2, Estimate("при"))
+}
+
+func TestVocabLoaderUsesMirrorAndCache(t *testing.T) {
+	requests := 0
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/encodings/test.tiktoken", r.URL.Path)
+		fmt.Fprint(w, "YQ== 0\nYg== 1\n")
+	}))
+	defer mirror.Close()
+	cacheDir := t.TempDir()
+	t.Setenv("TIKTOKEN_CACHE_DIR", cacheDir)
+	config.TokenizerVocabURL = mirror.URL + "/encodings/"
+	defer func() { config.TokenizerVocabURL = "" }()
+
+	file := "https://openaipublic.blob.core.windows.net/encodings/test.tiktoken"
+	for i := 0; i < 2; i++ {
+		ranks, err := vocabLoader{}.LoadTiktokenBpe(file)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"a": 0, "b": 1}, ranks)
+	}
+	assert.Equal(t, 1, requests)
+	_, err := os.Stat(filepath.Join(cacheDir, fmt.Sprintf("%x", sha1.Sum([]byte(file)))))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,107 @@
+package tokenizer
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var vocabClient = &http.Client{Timeout: 2 * time.Minute}
+
+// vocabLoader loads the encoding files from the cache directory, or downloads them from
+// OpenAI or the mirror of TOKENIZER_VOCAB_URL into it. The files are named like tiktoken
+// does, the directories it filled keep working.
+type vocabLoader struct{}
+
+func vocabCacheDir() string {
+	if dir := os.Getenv("TIKTOKEN_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("DATA_GYM_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "data-gym-cache")
+}
+
+func (vocabLoader) LoadTiktokenBpe(file string) (map[string]int, error) {
+	cachePath := filepath.Join(vocabCacheDir(), fmt.Sprintf("%x", sha1.Sum([]byte(file))))
+	if contents, err := os.ReadFile(cachePath); err == nil {
+		return parseVocab(contents)
+	}
+	url := file
+	if config.TokenizerVocabURL != "" {
+		url = strings.TrimSuffix(config.TokenizerVocabURL, "/") + "/" + path.Base(file)
+	}
+	logger.SysLog("downloading encoding file " + url)
+	contents, err := downloadVocab(url)
+	if err != nil {
+		return nil, err
+	}
+	ranks, err := parseVocab(contents)
+	if err != nil {
+		return nil, err
+	}
+	if err = writeVocab(cachePath, contents); err != nil {
+		logger.SysError("failed to cache encoding file: " + err.Error())
+	}
+	return ranks, nil
+}
+
+func downloadVocab(url string) ([]byte, error) {
+	resp, err := vocabClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s: status code %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// writeVocab writes the file through a temporary file, a concurrent reader never sees it partly written
+func writeVocab(cachePath string, contents []byte) error {
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+	tmpPath := cachePath + "." + strconv.FormatInt(time.Now().UnixNano(), 10) + ".tmp"
+	if err := os.WriteFile(tmpPath, contents, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, cachePath)
+}
+
+// parseVocab reads the lines of an encoding file, a base64 token and its rank each
+func parseVocab(contents []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+		token, rank, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("invalid encoding line: %s", line)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, err
+		}
+		value, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(decoded)] = value
+	}
+	return ranks, nil
+}
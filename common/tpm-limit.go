@@ -0,0 +1,82 @@
+package common
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// A TPM bucket holds the tokens a key can use, it is refilled with its limit every minute up
+// to the limit. Requests are admitted while the balance is positive and debited with their
+// estimated prompt tokens, then with the difference between their usage and the estimate, so
+// the balance goes negative when the usage is over it and the debt is paid by the next refills.
+
+type tpmBucket struct {
+	limit     float64
+	balance   float64
+	updatedAt time.Time
+}
+
+var tpmBuckets = make(map[string]*tpmBucket)
+var tpmBucketsLock sync.Mutex
+var tpmBucketsCleanupOnce sync.Once
+
+// TPMDebit debits tokens, negative to credit them, from the bucket of a key and returns its
+// balance. With admission set it is only done while the balance is positive, otherwise it
+// returns false and the wait until the balance is positive again. Redis errors admit the
+// request with a full bucket.
+func TPMDebit(ctx context.Context, key string, limit int, tokens int, admission bool) (debited bool, balance int64, retryAfter time.Duration) {
+	if RedisEnabled {
+		debited, balance, retryAfter, err := TPMBucketDebit(ctx, key, limit, tokens, admission)
+		if err != nil {
+			logger.Error(ctx, "Redis tpm limit error: "+err.Error())
+			return true, int64(limit), 0
+		}
+		return debited, balance, retryAfter
+	}
+	tpmBucketsCleanupOnce.Do(func() {
+		go cleanupTPMBuckets()
+	})
+	return memoryTPMDebit(key, limit, tokens, admission, time.Now())
+}
+
+func memoryTPMDebit(key string, limit int, tokens int, admission bool, now time.Time) (bool, int64, time.Duration) {
+	tpmBucketsLock.Lock()
+	defer tpmBucketsLock.Unlock()
+	capacity := float64(limit)
+	bucket, ok := tpmBuckets[key]
+	if !ok {
+		bucket = &tpmBucket{limit: capacity, balance: capacity, updatedAt: now}
+		tpmBuckets[key] = bucket
+	}
+	bucket.limit = capacity
+	if elapsed := now.Sub(bucket.updatedAt); elapsed > 0 {
+		bucket.balance = math.Min(capacity, bucket.balance+elapsed.Minutes()*capacity)
+		bucket.updatedAt = now
+	}
+	if admission && bucket.balance <= 0 {
+		wait := time.Duration(-bucket.balance / capacity * float64(time.Minute))
+		return false, int64(bucket.balance), wait + time.Millisecond
+	}
+	bucket.balance = math.Min(capacity, bucket.balance-float64(tokens))
+	return true, int64(bucket.balance), 0
+}
+
+// cleanupTPMBuckets removes the buckets refilled to their limit, which are the same as new ones
+func cleanupTPMBuckets() {
+	for {
+		time.Sleep(time.Minute)
+		now := time.Now()
+		tpmBucketsLock.Lock()
+		for key, bucket := range tpmBuckets {
+			refill := time.Duration((bucket.limit - bucket.balance) / bucket.limit * float64(time.Minute))
+			if now.Sub(bucket.updatedAt) > refill {
+				delete(tpmBuckets, key)
+			}
+		}
+		tpmBucketsLock.Unlock()
+	}
+}
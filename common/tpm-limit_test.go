@@ -0,0 +1,41 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTPMDebitCarriesDebt(t *testing.T) {
+	now := time.Now()
+	const limit = 600
+
+	// the estimate is admitted, then the usage is over the balance
+	debited, _, _ := memoryTPMDebit("debt", limit, 100, true, now)
+	assert.True(t, debited)
+	debited, _, _ = memoryTPMDebit("debt", limit, 800, false, now)
+	assert.True(t, debited)
+
+	// the balance is -300, paid by half a minute of refill
+	debited, balance, retryAfter := memoryTPMDebit("debt", limit, 10, true, now)
+	assert.False(t, debited)
+	assert.Equal(t, int64(-300), balance)
+	assert.InDelta(t, 30*time.Second, retryAfter, float64(time.Second))
+
+	debited, _, _ = memoryTPMDebit("debt", limit, 10, true, now.Add(31*time.Second))
+	assert.True(t, debited)
+}
+
+func TestTPMDebitRefillIsCapped(t *testing.T) {
+	now := time.Now()
+	const limit = 600
+
+	memoryTPMDebit("capped", limit, 600, true, now)
+	// the credit of an estimate over the usage does not fill the bucket over its limit
+	memoryTPMDebit("capped", limit, -1000, false, now.Add(time.Hour))
+	debited, _, _ := memoryTPMDebit("capped", limit, 601, true, now.Add(time.Hour))
+	assert.True(t, debited)
+	debited, _, _ = memoryTPMDebit("capped", limit, 1, true, now.Add(time.Hour))
+	assert.False(t, debited)
+}
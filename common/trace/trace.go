@@ -0,0 +1,93 @@
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A trace is the timeline of the decisions the relay took for a request: the channels it chose
+// from, the caches it checked, the upstream calls, the retries, the channels it throttled or
+// disabled and why it rejected the request. The events are recorded in the context of the
+// request, nothing is recorded when the request is not traced.
+
+const (
+	StageSelection = "selection"
+	StageCache     = "cache"
+	StageUpstream  = "upstream"
+	StageRetry     = "retry"
+	StageBreaker   = "breaker"
+	StageRejected  = "rejected"
+	StageResult    = "result"
+)
+
+// maxEvents bounds the events of a request, a retried stream of tool calls should not grow its
+// trace without end
+const maxEvents = 256
+
+type Event struct {
+	Time      int64          `json:"time"` // unit is ms, since the start of the request
+	Stage     string         `json:"stage"`
+	Message   string         `json:"message"`
+	ChannelId int            `json:"channel_id,omitempty"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+type Trace struct {
+	start   time.Time
+	lock    sync.Mutex
+	events  []Event
+	dropped int
+}
+
+type contextKey struct{}
+
+// NewContext returns a context tracing the request from now
+func NewContext(ctx context.Context) (context.Context, *Trace) {
+	t := &Trace{start: time.Now()}
+	return context.WithValue(ctx, contextKey{}, t), t
+}
+
+// FromContext returns the trace of a request, nil when it is not traced
+func FromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(contextKey{}).(*Trace)
+	return t
+}
+
+// Record adds an event to the trace of the request, if any
+func Record(ctx context.Context, stage string, channelId int, message string, data map[string]any) {
+	if ctx == nil {
+		return
+	}
+	if t := FromContext(ctx); t != nil {
+		t.Add(stage, channelId, message, data)
+	}
+}
+
+func (t *Trace) Add(stage string, channelId int, message string, data map[string]any) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.events) >= maxEvents {
+		t.dropped++
+		return
+	}
+	t.events = append(t.events, Event{
+		Time:      time.Since(t.start).Milliseconds(),
+		Stage:     stage,
+		Message:   message,
+		ChannelId: channelId,
+		Data:      data,
+	})
+}
+
+// Start returns when the request started
+func (t *Trace) Start() time.Time {
+	return t.start
+}
+
+// Events returns the events recorded so far, with the count of those over the limit
+func (t *Trace) Events() ([]Event, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]Event(nil), t.events...), t.dropped
+}
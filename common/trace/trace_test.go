@@ -0,0 +1,33 @@
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecord(t *testing.T) {
+	// an untraced request records nothing
+	Record(context.Background(), StageCache, 0, "miss", nil)
+
+	ctx, tr := NewContext(context.Background())
+	Record(ctx, StageSelection, 3, "selected", map[string]any{"score": 0.9})
+	Record(ctx, StageUpstream, 3, "response", nil)
+	events, dropped := tr.Events()
+	assert.Equal(t, 0, dropped)
+	assert.Len(t, events, 2)
+	assert.Equal(t, StageSelection, events[0].Stage)
+	assert.Equal(t, 3, events[0].ChannelId)
+	assert.Equal(t, tr, FromContext(ctx))
+}
+
+func TestMaxEvents(t *testing.T) {
+	ctx, tr := NewContext(context.Background())
+	for i := 0; i < maxEvents+5; i++ {
+		Record(ctx, StageRetry, i, "retry", nil)
+	}
+	events, dropped := tr.Events()
+	assert.Len(t, events, maxEvents)
+	assert.Equal(t, 5, dropped)
+}
@@ -3,11 +3,12 @@ package common
 import (
 	"fmt"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/currency"
 )
 
 func LogQuota(quota int64) string {
 	if config.DisplayInCurrencyEnabled {
-		return fmt.Sprintf("＄%.6f 额度", float64(quota)/config.QuotaPerUnit)
+		return fmt.Sprintf("%s 额度", currency.FormatQuota(quota, currency.Default()))
 	} else {
 		return fmt.Sprintf("%d 点额度", quota)
 	}
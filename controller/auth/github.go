@@ -138,6 +138,7 @@ func GitHubOAuth(c *gin.Context) {
 			user.Email = githubUser.Email
 			user.Role = model.RoleCommonUser
 			user.Status = model.UserStatusEnabled
+			user.SignupIp = c.ClientIP()
 
 			if err := user.Insert(ctx, 0); err != nil {
 				c.JSON(http.StatusOK, gin.H{
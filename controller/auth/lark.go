@@ -127,6 +127,7 @@ func LarkOAuth(c *gin.Context) {
 			}
 			user.Role = model.RoleCommonUser
 			user.Status = model.UserStatusEnabled
+			user.SignupIp = c.ClientIP()
 
 			if err := user.Insert(ctx, 0); err != nil {
 				c.JSON(http.StatusOK, gin.H{
@@ -2,17 +2,21 @@ package auth
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/model"
@@ -33,6 +37,26 @@ type OidcUser struct {
 	Name              string `json:"name"`
 	PreferredUsername string `json:"preferred_username"`
 	Picture           string `json:"picture"`
+	// Claims are all the claims of the user info and of the id token
+	Claims map[string]any `json:"-"`
+}
+
+// idTokenClaims returns the claims of an id token, which is received from the token
+// endpoint directly, so its signature is not checked
+func idTokenClaims(idToken string) map[string]any {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	var claims map[string]any
+	if json.Unmarshal(payload, &claims) != nil {
+		return nil
+	}
+	return claims
 }
 
 func getOidcUserInfoByCode(code string) (*OidcUser, error) {
@@ -80,11 +104,24 @@ func getOidcUserInfoByCode(code string) (*OidcUser, error) {
 		logger.SysLog(err.Error())
 		return nil, errors.New("无法连接至 OIDC 服务器，请稍后重试！")
 	}
+	defer res2.Body.Close()
+	body, err := io.ReadAll(res2.Body)
+	if err != nil {
+		return nil, err
+	}
 	var oidcUser OidcUser
-	err = json.NewDecoder(res2.Body).Decode(&oidcUser)
+	err = json.Unmarshal(body, &oidcUser)
 	if err != nil {
 		return nil, err
 	}
+	if err = json.Unmarshal(body, &oidcUser.Claims); err != nil || oidcUser.Claims == nil {
+		oidcUser.Claims = make(map[string]any)
+	}
+	for claim, value := range idTokenClaims(oidcResponse.IDToken) {
+		if _, ok := oidcUser.Claims[claim]; !ok {
+			oidcUser.Claims[claim] = value
+		}
+	}
 	return &oidcUser, nil
 }
 
@@ -133,7 +170,7 @@ func OidcAuth(c *gin.Context) {
 			return
 		}
 	} else {
-		if config.RegisterEnabled {
+		if config.RegisterEnabled || config.OidcProvisioningEnabled {
 			user.Email = oidcUser.Email
 			if oidcUser.PreferredUsername != "" {
 				user.Username = oidcUser.PreferredUsername
@@ -145,6 +182,7 @@ func OidcAuth(c *gin.Context) {
 			} else {
 				user.DisplayName = "OIDC User"
 			}
+			user.SignupIp = c.ClientIP()
 			err := user.Insert(ctx, 0)
 			if err != nil {
 				c.JSON(http.StatusOK, gin.H{
@@ -169,6 +207,14 @@ func OidcAuth(c *gin.Context) {
 		})
 		return
 	}
+	if err = user.ApplySSOGroups(model.SSOGroups(oidcUser.Claims)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Set(ctxkey.SSOLogin, true)
 	controller.SetupLogin(&user, c)
 }
 
@@ -89,6 +89,7 @@ func WeChatAuth(c *gin.Context) {
 			user.DisplayName = "WeChat User"
 			user.Role = model.RoleCommonUser
 			user.Status = model.UserStatusEnabled
+			user.SignupIp = c.ClientIP()
 
 			if err := user.Insert(ctx, 0); err != nil {
 				c.JSON(http.StatusOK, gin.H{
@@ -0,0 +1,503 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+	dbmodel "github.com/songquanpeng/one-api/model"
+)
+
+const (
+	batchPollInterval      = 10 * time.Second
+	batchHeartbeatInterval = 30 * time.Second
+	batchMaxBackoff        = 60 * time.Second
+	batchMaxRequests       = 50000
+	batchMaxErrors         = 100
+)
+
+// batchHandler relays the requests of the batches, through the same routes as the
+// requests of the clients so that they are authenticated, rate limited and billed alike
+var batchHandler http.Handler
+
+var runningBatches int32
+
+type batchRequest struct {
+	Line     int             `json:"-"`
+	CustomId string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Url      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+type batchError struct {
+	Code    string  `json:"code"`
+	Message string  `json:"message"`
+	Param   *string `json:"param"`
+	Line    *int    `json:"line"`
+}
+
+func newBatchError(line int, code string, message string) batchError {
+	batchErr := batchError{Code: code, Message: message}
+	if line > 0 {
+		batchErr.Line = &line
+	}
+	return batchErr
+}
+
+// InitBatchRunner runs the batches on this node, at most config.BatchMaxRunning at a time
+func InitBatchRunner(handler http.Handler) {
+	batchHandler = handler
+	go func() {
+		for {
+			time.Sleep(batchPollInterval)
+			for atomic.LoadInt32(&runningBatches) < int32(config.BatchMaxRunning) {
+				batch, err := dbmodel.ClaimBatch()
+				if err != nil {
+					logger.SysError("failed to claim batch: " + err.Error())
+				}
+				if batch == nil {
+					break
+				}
+				atomic.AddInt32(&runningBatches, 1)
+				go func() {
+					defer atomic.AddInt32(&runningBatches, -1)
+					runBatch(batch)
+				}()
+			}
+		}
+	}()
+}
+
+// parseBatchInput parses the JSONL input file of a batch, every line must be a request to the endpoint of the batch
+func parseBatchInput(content []byte, endpoint string) ([]*batchRequest, []batchError) {
+	var requests []*batchRequest
+	var errs []batchError
+	customIds := make(map[string]bool)
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if len(errs) >= batchMaxErrors {
+			break
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		lineNumber := i + 1
+		request := &batchRequest{Line: lineNumber}
+		if err := json.Unmarshal(line, request); err != nil {
+			errs = append(errs, newBatchError(lineNumber, "invalid_json_line", "This line is not parseable as valid JSON."))
+			continue
+		}
+		var body struct {
+			Model  string `json:"model"`
+			Stream bool   `json:"stream"`
+		}
+		switch {
+		case request.CustomId == "":
+			errs = append(errs, newBatchError(lineNumber, "missing_required_parameter", "The custom_id of the request is missing."))
+		case customIds[request.CustomId]:
+			errs = append(errs, newBatchError(lineNumber, "duplicate_custom_id", fmt.Sprintf("The custom_id '%s' is used by more than one request.", request.CustomId)))
+		case request.Method != http.MethodPost:
+			errs = append(errs, newBatchError(lineNumber, "invalid_method", "The method of the request must be POST."))
+		case request.Url != endpoint:
+			errs = append(errs, newBatchError(lineNumber, "mismatched_endpoint", fmt.Sprintf("The url '%s' does not match the endpoint '%s' of the batch.", request.Url, endpoint)))
+		case json.Unmarshal(request.Body, &body) != nil || body.Model == "":
+			errs = append(errs, newBatchError(lineNumber, "missing_required_parameter", "The body of the request must be an object with a model."))
+		case body.Stream:
+			errs = append(errs, newBatchError(lineNumber, "invalid_request", "Streaming is not supported in a batch."))
+		default:
+			customIds[request.CustomId] = true
+			requests = append(requests, request)
+		}
+	}
+	if len(errs) == 0 && len(requests) == 0 {
+		errs = append(errs, newBatchError(0, "empty_file", "The input file has no requests."))
+	}
+	if len(requests) > batchMaxRequests {
+		errs = append(errs, newBatchError(0, "too_many_requests", fmt.Sprintf("The input file has more than %d requests.", batchMaxRequests)))
+	}
+	return requests, errs
+}
+
+func failBatch(ctx context.Context, batch *dbmodel.Batch, errs []batchError) {
+	data, _ := json.Marshal(errs)
+	err := dbmodel.UpdateBatchStatus(batch.Id, dbmodel.BatchStatusFailed, map[string]any{
+		"errors":    string(data),
+		"failed_at": helper.GetTimestamp(),
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to update batch %s: %s", batch.Id, err.Error())
+	}
+}
+
+// runBatch relays the requests of a batch not relayed yet, then writes its output files
+func runBatch(batch *dbmodel.Batch) {
+	ctx := helper.SetRequestID(context.Background(), batch.Id)
+	file, err := dbmodel.GetFile(batch.InputFileId, batch.UserId)
+	if err != nil {
+		failBatch(ctx, batch, []batchError{newBatchError(0, "invalid_file", "The input file was not found.")})
+		return
+	}
+	content, err := file.Content(ctx)
+	if err != nil {
+		// left to be taken over once stale
+		logger.Errorf(ctx, "failed to read input file of batch %s: %s", batch.Id, err.Error())
+		return
+	}
+	requests, errs := parseBatchInput(content, batch.Endpoint)
+	if len(errs) > 0 {
+		failBatch(ctx, batch, errs)
+		return
+	}
+	results, err := dbmodel.GetBatchResults(batch.Id)
+	if err != nil {
+		// left to be taken over once stale
+		logger.Errorf(ctx, "failed to get results of batch %s: %s", batch.Id, err.Error())
+		return
+	}
+	runner := &batchRunner{batch: batch, total: len(requests)}
+	done := make(map[int]bool, len(results))
+	for _, result := range results {
+		done[result.Line] = true
+		runner.count(result.StatusCode)
+	}
+	if batch.Status == dbmodel.BatchStatusInProgress {
+		token, err := dbmodel.GetTokenById(batch.TokenId)
+		if err != nil {
+			failBatch(ctx, batch, []batchError{newBatchError(0, "invalid_token", "The token that created the batch was not found.")})
+			return
+		}
+		runner.key = token.Key
+		var pending []*batchRequest
+		for _, request := range requests {
+			if !done[request.Line] {
+				pending = append(pending, request)
+			}
+		}
+		logger.Infof(ctx, "running batch %s, %d of %d requests pending", batch.Id, len(pending), len(requests))
+		runner.run(ctx, pending)
+	}
+	runner.finalize(ctx, requests)
+}
+
+type batchRunner struct {
+	batch     *dbmodel.Batch
+	key       string
+	total     int
+	completed int32
+	failed    int32
+	cancelled int32
+
+	mutex    sync.Mutex
+	resumeAt time.Time
+}
+
+func (r *batchRunner) count(statusCode int) {
+	if statusCode/100 == 2 {
+		atomic.AddInt32(&r.completed, 1)
+	} else {
+		atomic.AddInt32(&r.failed, 1)
+	}
+}
+
+// stopping tells whether the batch was cancelled or its completion window ended
+func (r *batchRunner) stopping() bool {
+	return atomic.LoadInt32(&r.cancelled) == 1 || helper.GetTimestamp() >= r.batch.ExpiresAt
+}
+
+func (r *batchRunner) run(ctx context.Context, pending []*batchRequest) {
+	concurrency := config.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	queue := make(chan *batchRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for request := range queue {
+				r.process(ctx, request)
+			}
+		}()
+	}
+	stop := make(chan struct{})
+	go r.heartbeat(ctx, stop)
+	for _, request := range pending {
+		if r.stopping() {
+			break
+		}
+		queue <- request
+	}
+	close(queue)
+	wg.Wait()
+	close(stop)
+	r.saveProgress(ctx)
+}
+
+// heartbeat saves the progress periodically, which keeps the batch from being taken over
+// and notices its cancellation
+func (r *batchRunner) heartbeat(ctx context.Context, stop chan struct{}) {
+	ticker := time.NewTicker(batchHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			r.saveProgress(ctx)
+		}
+	}
+}
+
+func (r *batchRunner) saveProgress(ctx context.Context) {
+	status, err := dbmodel.UpdateBatchProgress(r.batch.Id, r.total, int(atomic.LoadInt32(&r.completed)), int(atomic.LoadInt32(&r.failed)))
+	if err != nil {
+		logger.Errorf(ctx, "failed to update batch %s: %s", r.batch.Id, err.Error())
+		return
+	}
+	if status == dbmodel.BatchStatusCancelling {
+		atomic.StoreInt32(&r.cancelled, 1)
+	}
+}
+
+// process relays a request, retrying it when rate limited until the batch stops
+func (r *batchRunner) process(ctx context.Context, request *batchRequest) {
+	for attempt := 0; ; attempt++ {
+		r.waitForRateLimit()
+		if r.stopping() {
+			return
+		}
+		recorder := r.dispatch(ctx, request)
+		if recorder.statusCode == http.StatusTooManyRequests {
+			r.pause(retryAfter(recorder.header, attempt))
+			continue
+		}
+		result := &dbmodel.BatchResult{
+			BatchId:    r.batch.Id,
+			Line:       request.Line,
+			StatusCode: recorder.statusCode,
+			RequestId:  recorder.header.Get(helper.RequestIdKey),
+			Body:       recorder.body.Bytes(),
+		}
+		if err := result.Insert(); err != nil {
+			logger.Errorf(ctx, "failed to save result of batch %s: %s", r.batch.Id, err.Error())
+		}
+		r.count(recorder.statusCode)
+		return
+	}
+}
+
+func (r *batchRunner) dispatch(ctx context.Context, request *batchRequest) *batchRecorder {
+	recorder := &batchRecorder{header: make(http.Header)}
+	req, err := http.NewRequestWithContext(dbmodel.WithSignatureVerified(ctx), http.MethodPost, request.Url, bytes.NewReader(request.Body))
+	if err != nil {
+		recorder.statusCode = http.StatusInternalServerError
+		return recorder
+	}
+	req.Header.Set("Authorization", "Bearer sk-"+r.key)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = net.JoinHostPort(r.batch.ClientIp, "0")
+	batchHandler.ServeHTTP(recorder, req)
+	if recorder.statusCode == 0 {
+		recorder.statusCode = http.StatusOK
+	}
+	return recorder
+}
+
+// pause holds back every request of the batch until the rate limit resets
+func (r *batchRunner) pause(duration time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if resumeAt := time.Now().Add(duration); resumeAt.After(r.resumeAt) {
+		r.resumeAt = resumeAt
+	}
+}
+
+func (r *batchRunner) waitForRateLimit() {
+	r.mutex.Lock()
+	wait := time.Until(r.resumeAt)
+	r.mutex.Unlock()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// retryAfter follows the Retry-After header of the rate limiters, or backs off exponentially
+func retryAfter(header http.Header, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header.Get("Retry-After")); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if attempt > 6 {
+		return batchMaxBackoff
+	}
+	backoff := time.Second << attempt
+	if backoff > batchMaxBackoff {
+		backoff = batchMaxBackoff
+	}
+	return backoff
+}
+
+// finalize writes the output file with the successful responses and the error file with the
+// others, the requests not relayed before the completion window ended are reported as expired
+func (r *batchRunner) finalize(ctx context.Context, requests []*batchRequest) {
+	batch, err := dbmodel.GetBatch(r.batch.Id, r.batch.UserId)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get batch %s: %s", r.batch.Id, err.Error())
+		return
+	}
+	err = dbmodel.UpdateBatchStatus(batch.Id, dbmodel.BatchStatusFinalizing, map[string]any{"finalizing_at": helper.GetTimestamp()})
+	if err != nil {
+		logger.Errorf(ctx, "failed to update batch %s: %s", batch.Id, err.Error())
+		return
+	}
+	results, err := dbmodel.GetBatchResults(batch.Id)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get results of batch %s: %s", batch.Id, err.Error())
+		return
+	}
+	resultByLine := make(map[int]*dbmodel.BatchResult, len(results))
+	for _, result := range results {
+		resultByLine[result.Line] = result
+	}
+	var output, errorOutput bytes.Buffer
+	completed, failed, unfinished := 0, 0, 0
+	for _, request := range requests {
+		line := map[string]any{
+			"id":        "batch_req_" + random.GetUUID(),
+			"custom_id": request.CustomId,
+			"response":  nil,
+			"error":     nil,
+		}
+		result, ok := resultByLine[request.Line]
+		if !ok {
+			unfinished++
+			if batch.CancellingAt != 0 {
+				continue
+			}
+			line["error"] = map[string]any{
+				"code":    "batch_expired",
+				"message": "This request could not be executed before the completion window expired.",
+			}
+			data, _ := json.Marshal(line)
+			errorOutput.Write(append(data, '\n'))
+			continue
+		}
+		var body any = json.RawMessage(result.Body)
+		if !json.Valid(result.Body) {
+			body = string(result.Body)
+		}
+		line["response"] = map[string]any{
+			"status_code": result.StatusCode,
+			"request_id":  result.RequestId,
+			"body":        body,
+		}
+		data, _ := json.Marshal(line)
+		if result.StatusCode/100 == 2 {
+			completed++
+			output.Write(append(data, '\n'))
+		} else {
+			failed++
+			errorOutput.Write(append(data, '\n'))
+		}
+	}
+	now := helper.GetTimestamp()
+	status := dbmodel.BatchStatusCompleted
+	fields := map[string]any{
+		"request_total":     len(requests),
+		"request_completed": completed,
+		"request_failed":    failed,
+	}
+	switch {
+	case batch.CancellingAt != 0:
+		status = dbmodel.BatchStatusCancelled
+		fields["cancelled_at"] = now
+	case unfinished > 0:
+		status = dbmodel.BatchStatusExpired
+		fields["expired_at"] = now
+	default:
+		fields["completed_at"] = now
+	}
+	if output.Len() > 0 {
+		file := &dbmodel.File{
+			Id:          "file-" + random.GetUUID(),
+			UserId:      batch.UserId,
+			Purpose:     dbmodel.FilePurposeBatchOutput,
+			Filename:    batch.Id + "_output.jsonl",
+			ContentType: "application/jsonl",
+		}
+		if err = file.Save(ctx, output.Bytes()); err != nil {
+			logger.Errorf(ctx, "failed to save output file of batch %s: %s", batch.Id, err.Error())
+			return
+		}
+		fields["output_file_id"] = file.Id
+	}
+	if errorOutput.Len() > 0 {
+		file := &dbmodel.File{
+			Id:          "file-" + random.GetUUID(),
+			UserId:      batch.UserId,
+			Purpose:     dbmodel.FilePurposeBatchOutput,
+			Filename:    batch.Id + "_error.jsonl",
+			ContentType: "application/jsonl",
+		}
+		if err = file.Save(ctx, errorOutput.Bytes()); err != nil {
+			logger.Errorf(ctx, "failed to save error file of batch %s: %s", batch.Id, err.Error())
+			return
+		}
+		fields["error_file_id"] = file.Id
+	}
+	if err = dbmodel.UpdateBatchStatus(batch.Id, status, fields); err != nil {
+		logger.Errorf(ctx, "failed to update batch %s: %s", batch.Id, err.Error())
+		return
+	}
+	dbmodel.EmitWebhookEvent(dbmodel.WebhookEventBatchCompleted, map[string]any{
+		"batch_id":       batch.Id,
+		"user_id":        batch.UserId,
+		"status":         status,
+		"output_file_id": fields["output_file_id"],
+		"error_file_id":  fields["error_file_id"],
+		"completed":      completed,
+		"failed":         failed,
+	})
+	if err = dbmodel.DeleteBatchResults(batch.Id); err != nil {
+		logger.Errorf(ctx, "failed to delete results of batch %s: %s", batch.Id, err.Error())
+	}
+	logger.Infof(ctx, "batch %s %s, %d completed, %d failed", batch.Id, status, completed, failed)
+}
+
+// batchRecorder keeps the response of a request relayed for a batch
+type batchRecorder struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *batchRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *batchRecorder) Write(data []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *batchRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *batchRecorder) Flush() {}
@@ -0,0 +1,212 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/batch
+// batches are emulated for every channel, their requests are relayed one by one
+
+const (
+	batchListDefaultLimit = 20
+	batchListMaxLimit     = 100
+)
+
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/moderations":      true,
+	"/v1/responses":        true,
+}
+
+type createBatchRequest struct {
+	InputFileId      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata"`
+}
+
+// nullableString and nullableTime turn unset fields into null
+func nullableString(value string) any {
+	if value == "" {
+		return nil
+	}
+	return value
+}
+
+func nullableTime(timestamp int64) any {
+	if timestamp == 0 {
+		return nil
+	}
+	return timestamp
+}
+
+func batchObject(batch *dbmodel.Batch) gin.H {
+	var errors any
+	if batch.Errors != "" {
+		errors = gin.H{
+			"object": "list",
+			"data":   json.RawMessage(batch.Errors),
+		}
+	}
+	var metadata any
+	if batch.Metadata != "" {
+		metadata = json.RawMessage(batch.Metadata)
+	}
+	return gin.H{
+		"id":                batch.Id,
+		"object":            "batch",
+		"endpoint":          batch.Endpoint,
+		"errors":            errors,
+		"input_file_id":     batch.InputFileId,
+		"completion_window": batch.CompletionWindow,
+		"status":            batch.Status,
+		"output_file_id":    nullableString(batch.OutputFileId),
+		"error_file_id":     nullableString(batch.ErrorFileId),
+		"created_at":        batch.CreatedAt,
+		"in_progress_at":    nullableTime(batch.InProgressAt),
+		"expires_at":        nullableTime(batch.ExpiresAt),
+		"finalizing_at":     nullableTime(batch.FinalizingAt),
+		"completed_at":      nullableTime(batch.CompletedAt),
+		"failed_at":         nullableTime(batch.FailedAt),
+		"expired_at":        nullableTime(batch.ExpiredAt),
+		"cancelling_at":     nullableTime(batch.CancellingAt),
+		"cancelled_at":      nullableTime(batch.CancelledAt),
+		"request_counts": gin.H{
+			"total":     batch.RequestTotal,
+			"completed": batch.RequestCompleted,
+			"failed":    batch.RequestFailed,
+		},
+		"metadata": metadata,
+	}
+}
+
+func batchNotFound(c *gin.Context, id string) {
+	invalidRequest(c, http.StatusNotFound, "batch_id", fmt.Sprintf("No batch found with id '%s'.", id))
+}
+
+func CreateBatch(c *gin.Context) {
+	var request createBatchRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		invalidRequest(c, http.StatusBadRequest, "", "Invalid request body: "+err.Error())
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		invalidRequest(c, http.StatusBadRequest, "endpoint", fmt.Sprintf("Invalid endpoint '%s'.", request.Endpoint))
+		return
+	}
+	if request.CompletionWindow != "24h" {
+		invalidRequest(c, http.StatusBadRequest, "completion_window", "Invalid completion_window, only '24h' is supported.")
+		return
+	}
+	userId := c.GetInt(ctxkey.Id)
+	file, err := dbmodel.GetFile(request.InputFileId, userId)
+	if err != nil || file.Purpose != dbmodel.FilePurposeBatch {
+		invalidRequest(c, http.StatusBadRequest, "input_file_id", fmt.Sprintf("Invalid input_file_id '%s'.", request.InputFileId))
+		return
+	}
+	batch := &dbmodel.Batch{
+		Id:               "batch_" + random.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt(ctxkey.TokenId),
+		ClientIp:         c.ClientIP(),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           dbmodel.BatchStatusValidating,
+		ExpiresAt:        helper.GetTimestamp() + 24*3600,
+	}
+	if len(request.Metadata) > 0 {
+		metadata, _ := json.Marshal(request.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if err = batch.Insert(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": model.Error{Message: err.Error(), Type: "one_api_error"},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = batchListDefaultLimit
+	}
+	if limit > batchListMaxLimit {
+		limit = batchListMaxLimit
+	}
+	// one more to know whether there are more
+	batches, err := dbmodel.GetBatches(c.GetInt(ctxkey.Id), c.Query("after"), limit+1)
+	if err != nil {
+		batchNotFound(c, c.Query("after"))
+		return
+	}
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	data := make([]gin.H, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, batchObject(batch))
+	}
+	var firstId, lastId any
+	if len(batches) > 0 {
+		firstId = batches[0].Id
+		lastId = batches[len(batches)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": hasMore,
+	})
+}
+
+func GetBatch(c *gin.Context) {
+	id := c.Param("id")
+	batch, err := dbmodel.GetBatch(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		batchNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
+
+// CancelBatch stops a batch, the requests finished so far are kept in its output file
+func CancelBatch(c *gin.Context) {
+	id := c.Param("id")
+	userId := c.GetInt(ctxkey.Id)
+	cancelled, err := dbmodel.CancelBatch(id, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": model.Error{Message: err.Error(), Type: "one_api_error"},
+		})
+		return
+	}
+	batch, err := dbmodel.GetBatch(id, userId)
+	if err != nil {
+		batchNotFound(c, id)
+		return
+	}
+	if !cancelled && batch.Status != dbmodel.BatchStatusCancelling && batch.Status != dbmodel.BatchStatusCancelled {
+		invalidRequest(c, http.StatusConflict, "", fmt.Sprintf("Cannot cancel a batch with status '%s'.", batch.Status))
+		return
+	}
+	c.JSON(http.StatusOK, batchObject(batch))
+}
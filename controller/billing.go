@@ -4,10 +4,17 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/currency"
 	"github.com/songquanpeng/one-api/model"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 )
 
+// userCurrency returns the currency the amounts are displayed in for the user of the request
+func userCurrency(c *gin.Context) string {
+	userGroup, _ := model.CacheGetUserGroup(c.GetInt(ctxkey.Id))
+	return currency.OfGroup(userGroup)
+}
+
 func GetSubscription(c *gin.Context) {
 	var remainQuota int64
 	var usedQuota int64
@@ -45,7 +52,7 @@ func GetSubscription(c *gin.Context) {
 	quota := remainQuota + usedQuota
 	amount := float64(quota)
 	if config.DisplayInCurrencyEnabled {
-		amount /= config.QuotaPerUnit
+		amount = currency.FromQuota(quota, userCurrency(c))
 	}
 	if token != nil && token.UnlimitedQuota {
 		amount = 100000000
@@ -86,7 +93,7 @@ func GetUsage(c *gin.Context) {
 	}
 	amount := float64(quota)
 	if config.DisplayInCurrencyEnabled {
-		amount /= config.QuotaPerUnit
+		amount = currency.FromQuota(quota, userCurrency(c))
 	}
 	usage := OpenAIUsageResponse{
 		Object:     "list",
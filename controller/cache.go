@@ -3,12 +3,14 @@ package controller
 import (
 	"context"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/cache"
 )
 
@@ -177,26 +179,30 @@ func clearExactCache() int {
 	var cursor uint64
 	var cleared int
 
-	for {
-		var keys []string
-		var err error
-		keys, cursor, err = common.RDB.Scan(ctx, cursor, "llm:cache:exact:*", 100).Result()
-		if err != nil {
-			logger.SysError("Failed to scan Redis keys: " + err.Error())
-			break
-		}
-
-		if len(keys) > 0 {
-			deleted, err := common.RDB.Del(ctx, keys...).Result()
+	// the caches of the tenants are prefixed with their tenant, see common.TenantKey
+	for _, pattern := range []string{"llm:cache:exact:*", "tenant:*:llm:cache:exact:*"} {
+		cursor = 0
+		for {
+			var keys []string
+			var err error
+			keys, cursor, err = common.RDB.Scan(ctx, cursor, pattern, 100).Result()
 			if err != nil {
-				logger.SysError("Failed to delete Redis keys: " + err.Error())
-			} else {
-				cleared += int(deleted)
+				logger.SysError("Failed to scan Redis keys: " + err.Error())
+				break
+			}
+
+			if len(keys) > 0 {
+				deleted, err := common.RDB.Del(ctx, keys...).Result()
+				if err != nil {
+					logger.SysError("Failed to delete Redis keys: " + err.Error())
+				} else {
+					cleared += int(deleted)
+				}
 			}
-		}
 
-		if cursor == 0 {
-			break
+			if cursor == 0 {
+				break
+			}
 		}
 	}
 
@@ -205,7 +211,7 @@ func clearExactCache() int {
 
 // ToggleCacheRequest represents cache toggle request
 type ToggleCacheRequest struct {
-	Type    string `json:"type"`    // "exact" or "semantic"
+	Type    string `json:"type"`    // "exact", "semantic" or "coalescing"
 	Enabled bool   `json:"enabled"`
 }
 
@@ -228,22 +234,30 @@ func ToggleCache(c *gin.Context) {
 		return
 	}
 
+	// saved as a setting, so the toggle persists and reaches the other replicas
+	var key string
 	switch req.Type {
 	case "exact":
-		config.ResponseCacheEnabled = req.Enabled
-		logger.SysLog("Exact cache toggled: " + boolToString(req.Enabled))
-
+		key = "ResponseCacheEnabled"
 	case "semantic":
-		config.SemanticCacheEnabled = req.Enabled
-		logger.SysLog("Semantic cache toggled: " + boolToString(req.Enabled))
-
+		key = "SemanticCacheEnabled"
+	case "coalescing":
+		key = "RequestCoalescingEnabled"
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{
 			"success": false,
-			"message": "Invalid cache type. Use 'exact' or 'semantic'",
+			"message": "Invalid cache type. Use 'exact', 'semantic' or 'coalescing'",
+		})
+		return
+	}
+	if err := model.UpdateSettings(map[string]string{key: strconv.FormatBool(req.Enabled)}); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
 		})
 		return
 	}
+	logger.SysLog("Cache " + req.Type + " toggled: " + boolToString(req.Enabled))
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -251,6 +265,23 @@ func ToggleCache(c *gin.Context) {
 	})
 }
 
+// GetCacheFlights returns the counts of the coalesced requests since the start of the node
+// and the requests in flight with the number of requests waiting for each
+// @Summary Get coalesced requests
+// @Description Returns the request coalescing metrics of the node
+// @Tags Cache
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /api/cache/flights [get]
+func GetCacheFlights(c *gin.Context) {
+	stats := cache.GetFlightStats()
+	stats["enabled"] = config.RequestCoalescingEnabled
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    stats,
+	})
+}
+
 func boolToString(b bool) string {
 	if b {
 		return "enabled"
@@ -407,7 +407,7 @@ func UpdateChannelBalance(c *gin.Context) {
 }
 
 func updateAllChannelsBalance() error {
-	channels, err := model.GetAllChannels(0, 0, "all")
+	channels, err := model.GetAllChannels(model.AllTenants, 0, 0, "all")
 	if err != nil {
 		return err
 	}
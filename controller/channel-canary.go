@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllChannelCanaries(c *gin.Context) {
+	canaries, err := model.GetAllChannelCanaries()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    canaries,
+	})
+}
+
+// AddChannelCanary marks a channel as canary, its traffic starts at the first step
+func AddChannelCanary(c *gin.Context) {
+	canary := model.ChannelCanary{}
+	err := c.ShouldBindJSON(&canary)
+	if err == nil {
+		canary.Id = 0
+		err = canary.Validate()
+	}
+	if err == nil {
+		err = canary.Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationCanaries, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    canary,
+	})
+}
+
+// RestartChannelCanary runs a canary again from its first step
+func RestartChannelCanary(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	canary, err := model.GetChannelCanaryById(id)
+	if err == nil {
+		err = canary.Validate()
+	}
+	if err == nil {
+		err = canary.Restart()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationCanaries, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// DeleteChannelCanary ends a canary, its channel is selected as usual again
+func DeleteChannelCanary(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	canary, err := model.GetChannelCanaryById(id)
+	if err == nil {
+		err = canary.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationCanaries, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetChannelMargins returns what the users were billed and what the providers are estimated
+// to charge per channel, and per model with group_by=model
+func GetChannelMargins(c *gin.Context) {
+	if !config.UsageRollupEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "usage rollups are disabled",
+		})
+		return
+	}
+	filter := model.UsageRollupFilter{ModelName: c.Query("model_name")}
+	filter.Start, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.End, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	filter.ChannelId, _ = strconv.Atoi(c.Query("channel"))
+	margins, err := model.GetChannelMargins(filter, c.Query("group_by") == model.UsageGroupModel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := userCurrency(c)
+	for _, margin := range margins {
+		margin.Cost = currency.FromQuota(margin.Quota, code)
+		margin.UpstreamCost = currency.FromQuota(margin.UpstreamQuota, code)
+		margin.Margin = currency.FromQuota(margin.MarginQuota, code)
+		margin.Currency = code
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    margins,
+	})
+}
+
+func GetChannelInvoices(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	channelId, _ := strconv.Atoi(c.Query("channel"))
+	invoices, err := model.GetChannelInvoices(channelId, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    invoices,
+	})
+}
+
+var invoiceImportColumns = []string{"channel_id", "period_start", "period_end", "amount", "currency", "reference"}
+
+// parseInvoiceTime parses a date, 2006-01-02 in UTC, or a unix timestamp
+func parseInvoiceTime(value string) (int64, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.Unix(), nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// parseInvoicesCSV reads the invoices of a CSV file with the invoiceImportColumns, the periods
+// are dates or unix timestamps
+func parseInvoicesCSV(c *gin.Context) ([]*model.ChannelInvoice, error) {
+	records, err := csv.NewReader(c.Request.Body).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var invoices []*model.ChannelInvoice
+	for i, record := range records {
+		if i == 0 && record[0] == invoiceImportColumns[0] {
+			continue
+		}
+		if len(record) < 4 {
+			return nil, fmt.Errorf("line %d: expected the columns %s", i+1, strings.Join(invoiceImportColumns, ","))
+		}
+		for len(record) < len(invoiceImportColumns) {
+			record = append(record, "")
+		}
+		invoice := model.ChannelInvoice{Currency: record[4], Reference: record[5]}
+		if invoice.ChannelId, err = strconv.Atoi(record[0]); err == nil {
+			if invoice.PeriodStart, err = parseInvoiceTime(record[1]); err == nil {
+				if invoice.PeriodEnd, err = parseInvoiceTime(record[2]); err == nil {
+					invoice.Amount, err = strconv.ParseFloat(record[3], 64)
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+		invoices = append(invoices, &invoice)
+	}
+	return invoices, nil
+}
+
+// ImportChannelInvoices imports the invoices of the providers, a JSON array or with
+// Content-Type text/csv a CSV file
+func ImportChannelInvoices(c *gin.Context) {
+	var invoices []*model.ChannelInvoice
+	var err error
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		invoices, err = parseInvoicesCSV(c)
+	} else {
+		err = c.ShouldBindJSON(&invoices)
+	}
+	if err == nil {
+		err = model.ImportChannelInvoices(invoices)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    len(invoices),
+	})
+}
+
+func DeleteChannelInvoice(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteChannelInvoiceById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetChannelReconciliation compares the invoices of a channel, or of all of them, with the
+// upstream cost estimated over their periods
+func GetChannelReconciliation(c *gin.Context) {
+	if !config.UsageRollupEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "usage rollups are disabled",
+		})
+		return
+	}
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	channelId, _ := strconv.Atoi(c.Query("channel"))
+	invoices, err := model.GetChannelInvoices(channelId, p*config.ItemsPerPage, config.ItemsPerPage)
+	reconciliations := make([]*model.ChannelReconciliation, 0, len(invoices))
+	for _, invoice := range invoices {
+		var reconciliation *model.ChannelReconciliation
+		if reconciliation, err = model.ReconcileChannelInvoice(invoice); err != nil {
+			break
+		}
+		reconciliations = append(reconciliations, reconciliation)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    reconciliations,
+	})
+}
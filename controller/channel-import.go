@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+// the passphrase of the encrypted exports is sent in a header to keep it out of the logs of
+// the urls
+const exportPassphraseHeader = "X-Export-Passphrase"
+
+// ExportChannels downloads the channels, with secrets=encrypt their keys and secrets are
+// encrypted with the passphrase of the request, they are left out by default
+func ExportChannels(c *gin.Context) {
+	secrets := c.DefaultQuery("secrets", model.ChannelSecretsOmit)
+	export, err := model.ExportChannels(secrets, c.GetHeader(exportPassphraseHeader))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=channels-%s.json", time.Now().UTC().Format("20060102")))
+	c.JSON(http.StatusOK, export)
+}
+
+var channelImportColumns = []string{"name", "type", "key", "base_url", "models", "group", "priority", "weight", "tags"}
+
+// parseChannelsCSV reads the channels of a CSV file with the channelImportColumns, the
+// columns after models are optional
+func parseChannelsCSV(body io.Reader) ([]model.ConfigChannel, error) {
+	records, err := csv.NewReader(body).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var channels []model.ConfigChannel
+	for i, record := range records {
+		if i == 0 && record[0] == channelImportColumns[0] {
+			continue
+		}
+		if len(record) < 5 {
+			return nil, fmt.Errorf("line %d: expected the columns %s", i+1, strings.Join(channelImportColumns, ","))
+		}
+		for len(record) < len(channelImportColumns) {
+			record = append(record, "")
+		}
+		channel := model.ConfigChannel{Name: record[0], Key: record[2], BaseURL: record[3], Models: record[4], Group: record[5], Tags: record[8]}
+		if channel.Type, err = strconv.Atoi(record[1]); err == nil && record[6] != "" {
+			channel.Priority, err = strconv.ParseInt(record[6], 10, 64)
+		}
+		if err == nil && record[7] != "" {
+			var weight uint64
+			weight, err = strconv.ParseUint(record[7], 10, 32)
+			channel.Weight = uint(weight)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// parseChannelsJSON reads the channels of an export, or of a JSON array of channels
+func parseChannelsJSON(body io.Reader, passphrase string) ([]model.ConfigChannel, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	var channels []model.ConfigChannel
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &channels)
+		return channels, err
+	}
+	export := model.ChannelExport{}
+	if err = json.Unmarshal(data, &export); err == nil {
+		err = export.Unseal(passphrase)
+	}
+	return export.Channels, err
+}
+
+// ImportChannels creates the channels of a file, an export or a JSON array of channels, or
+// with Content-Type text/csv a CSV file. With dry_run=true it only returns what the import
+// would do.
+func ImportChannels(c *gin.Context) {
+	var channels []model.ConfigChannel
+	var err error
+	if strings.HasPrefix(c.ContentType(), "text/csv") {
+		channels, err = parseChannelsCSV(c.Request.Body)
+	} else {
+		channels, err = parseChannelsJSON(c.Request.Body, c.GetHeader(exportPassphraseHeader))
+	}
+	var results []*model.ChannelImportResult
+	if err == nil {
+		results, err = model.ImportChannels(channels, c.Query("dry_run") == "true")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+			"data":    results,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    results,
+	})
+}
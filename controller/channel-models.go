@@ -0,0 +1,164 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+	relayadaptor "github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// upstreamModelsRequest returns the request listing the models of the provider of a channel
+func upstreamModelsRequest(c *gin.Context, meta *meta.Meta) (*http.Request, error) {
+	baseURL := strings.TrimSuffix(meta.BaseURL, "/")
+	var fullRequestURL string
+	header := http.Header{}
+	switch meta.APIType {
+	case apitype.Anthropic:
+		fullRequestURL = baseURL + "/v1/models?limit=1000"
+		header.Set("x-api-key", meta.APIKey)
+		header.Set("anthropic-version", "2023-06-01")
+	case apitype.Gemini:
+		fullRequestURL = baseURL + "/v1beta/models?pageSize=1000"
+		header.Set("x-goog-api-key", meta.APIKey)
+	case apitype.Ollama:
+		fullRequestURL = baseURL + "/api/tags"
+	case apitype.OpenAI:
+		if meta.ChannelType == channeltype.Azure {
+			fullRequestURL = fmt.Sprintf("%s/openai/models?api-version=%s", baseURL, openai.AzureAPIVersion(meta.Config, relaymode.Unknown, "", nil))
+			header.Set("api-key", meta.APIKey)
+			break
+		}
+		fullRequestURL = openai.GetFullRequestURL(baseURL, "/v1/models", meta.ChannelType)
+		header.Set("Authorization", "Bearer "+meta.APIKey)
+	default:
+		return nil, fmt.Errorf("listing the models of channel type %d is not supported", meta.ChannelType)
+	}
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, fullRequestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	return req, nil
+}
+
+// parseUpstreamModels reads the model names of the OpenAI, Anthropic, Gemini and Ollama model lists
+func parseUpstreamModels(body []byte) ([]string, error) {
+	var response struct {
+		Data []struct {
+			Id string `json:"id"`
+		} `json:"data"`
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	models := make([]string, 0, len(response.Data)+len(response.Models))
+	add := func(name string) {
+		name = strings.TrimPrefix(name, "models/")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			models = append(models, name)
+		}
+	}
+	for _, item := range response.Data {
+		add(item.Id)
+	}
+	for _, item := range response.Models {
+		add(item.Name)
+	}
+	sort.Strings(models)
+	return models, nil
+}
+
+// fetchChannelModels queries the models the provider of a channel serves
+func fetchChannelModels(c *gin.Context, channel *model.Channel) ([]string, error) {
+	channelContext, meta := channelContext(c, channel)
+	req, err := upstreamModelsRequest(channelContext, meta)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := relayadaptor.GetHTTPClient(channelContext).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResponse struct {
+			Error relaymodel.Error `json:"error"`
+		}
+		if json.Unmarshal(body, &errResponse) == nil && errResponse.Error.Message != "" {
+			return nil, fmt.Errorf("status code: %d, error message: %s", resp.StatusCode, errResponse.Error.Message)
+		}
+		return nil, fmt.Errorf("status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+	return parseUpstreamModels(body)
+}
+
+// FetchChannelModels lists the models of the provider of a channel, and with POST
+// replaces the models of the channel with them
+func FetchChannelModels(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	models, err := fetchChannelModels(c, channel)
+	if err == nil && len(models) == 0 {
+		err = errors.New("the channel lists no models")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Request.Method == http.MethodPost {
+		channel.Models = strings.Join(models, ",")
+		if err = channel.Update(); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    models,
+	})
+}
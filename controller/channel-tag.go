@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetChannelTags lists the tags of the channels with their descriptions and channel counts
+func GetChannelTags(c *gin.Context) {
+	tags, err := model.GetChannelTags()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tags,
+	})
+}
+
+// SaveChannelTag creates or updates the description of a tag
+func SaveChannelTag(c *gin.Context) {
+	tag := model.ChannelTag{}
+	err := c.ShouldBindJSON(&tag)
+	if err == nil {
+		err = model.SaveChannelTag(&tag)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tag,
+	})
+}
+
+// DeleteChannelTag removes a tag from its channels and deletes its description
+func DeleteChannelTag(c *gin.Context) {
+	count, err := model.DeleteChannelTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
+
+// UpdateChannelsByTag enables, disables or archives all the channels of a tag
+func UpdateChannelsByTag(c *gin.Context) {
+	count, err := model.UpdateChannelsByTag(c.Param("tag"), c.Param("action"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
+	})
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
 	"github.com/songquanpeng/one-api/relay"
+	relayadaptor "github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/controller"
@@ -213,6 +214,172 @@ func TestChannel(c *gin.Context) {
 	return
 }
 
+// channelTestResult is the outcome of a test completion, the error is the one of the channel
+type channelTestResult struct {
+	Model    string            `json:"model"`
+	Latency  int64             `json:"latency"`
+	Response string            `json:"response,omitempty"`
+	Error    *relaymodel.Error `json:"error,omitempty"`
+}
+
+// TestChannelModel sends a test completion for the model of the request body to a channel
+func TestChannelModel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var request struct {
+		Model string `json:"model"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	testRequest := buildTestRequest(request.Model)
+	tik := time.Now()
+	responseMessage, err, openaiErr := testChannel(c.Request.Context(), channel, testRequest)
+	result := channelTestResult{
+		Model:    testRequest.Model,
+		Latency:  time.Since(tik).Milliseconds(),
+		Response: responseMessage,
+	}
+	if err != nil {
+		if openaiErr == nil {
+			openaiErr = &relaymodel.Error{Message: err.Error()}
+		}
+		result.Error = openaiErr
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": openaiErr.Message,
+			"data":    result,
+		})
+		return
+	}
+	go channel.UpdateResponseTime(result.Latency)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
+
+// channelContext returns a context to send requests to a channel outside of the relay
+func channelContext(c *gin.Context, channel *model.Channel) (*gin.Context, *meta.Meta) {
+	channelContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	channelContext.Request = c.Request.Clone(c.Request.Context())
+	channelContext.Request.Header.Set("Authorization", "Bearer "+channel.Key)
+	middleware.SetupContextForSelectedChannel(channelContext, channel, "")
+	return channelContext, meta.GetByContext(channelContext)
+}
+
+// azureDeploymentCheck is whether the deployment serving a model of an Azure channel exists
+type azureDeploymentCheck struct {
+	Model      string `json:"model"`
+	Deployment string `json:"deployment"`
+	Exists     bool   `json:"exists"`
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message,omitempty"`
+}
+
+// checkAzureDeployment sends an empty chat completion to a deployment, Azure rejects it
+// without running a model when the deployment exists, and answers 404 when it does not
+func checkAzureDeployment(c *gin.Context, meta *meta.Meta, deployment string) (int, string, error) {
+	apiVersion := openai.AzureAPIVersion(meta.Config, relaymode.ChatCompletions, "", nil)
+	fullRequestURL := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", meta.BaseURL, url.PathEscape(deployment), apiVersion)
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, fullRequestURL, strings.NewReader("{}"))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("api-key", meta.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := relayadaptor.GetHTTPClient(c).Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	var response struct {
+		Error relaymodel.Error `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&response)
+	return resp.StatusCode, response.Error.Message, nil
+}
+
+// TestChannelDeployments checks that the deployments of the models of an Azure channel exist
+func TestChannelDeployments(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if channel.Type != channeltype.Azure {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "only Azure channels have deployments",
+		})
+		return
+	}
+	testContext, meta := channelContext(c, channel)
+	modelMapping := channel.GetModelMapping()
+	checks := make([]azureDeploymentCheck, 0)
+	for _, modelName := range strings.Split(channel.Models, ",") {
+		modelName = strings.TrimSpace(modelName)
+		if modelName == "" {
+			continue
+		}
+		if mappedName := modelMapping[modelName]; mappedName != "" {
+			modelName = mappedName
+		}
+		check := azureDeploymentCheck{
+			Model:      modelName,
+			Deployment: openai.AzureDeployment(meta.Config, modelName),
+		}
+		statusCode, message, err := checkAzureDeployment(testContext, meta, check.Deployment)
+		if err != nil {
+			check.Message = err.Error()
+		} else {
+			check.StatusCode = statusCode
+			check.Exists = statusCode != http.StatusNotFound
+			if !check.Exists {
+				check.Message = message
+			}
+		}
+		checks = append(checks, check)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    checks,
+	})
+}
+
 var testAllChannelsLock sync.Mutex
 var testAllChannelsRunning bool = false
 
@@ -227,7 +394,7 @@ func testChannels(ctx context.Context, notify bool, scope string) error {
 	}
 	testAllChannelsRunning = true
 	testAllChannelsLock.Unlock()
-	channels, err := model.GetAllChannels(0, 0, scope)
+	channels, err := model.GetAllChannels(model.AllTenants, 0, 0, scope)
 	if err != nil {
 		return err
 	}
@@ -1,8 +1,10 @@
 package controller
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
@@ -15,7 +17,17 @@ func GetAllChannels(c *gin.Context) {
 	if p < 0 {
 		p = 0
 	}
-	channels, err := model.GetAllChannels(p*config.ItemsPerPage, config.ItemsPerPage, "limited")
+	scope := "limited"
+	if c.Query("archived") == "true" {
+		scope = "archived"
+	}
+	var channels []*model.Channel
+	var err error
+	if tag := c.Query("tag"); tag != "" {
+		channels, err = model.GetChannelsByTag(adminTenantId(c), tag)
+	} else {
+		channels, err = model.GetAllChannels(adminTenantId(c), p*config.ItemsPerPage, config.ItemsPerPage, scope)
+	}
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -33,7 +45,7 @@ func GetAllChannels(c *gin.Context) {
 
 func SearchChannels(c *gin.Context) {
 	keyword := c.Query("keyword")
-	channels, err := model.SearchChannels(keyword)
+	channels, err := model.SearchChannels(adminTenantId(c), keyword)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -84,6 +96,27 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = channel.ValidateConfig(); err == nil {
+		err = channel.SealConfig()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if tenantId := c.GetInt(ctxkey.TenantId); tenantId != model.DefaultTenantId {
+		channel.TenantId = tenantId
+	} else if channel.TenantId != model.DefaultTenantId {
+		if _, err = model.GetTenantById(channel.TenantId); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "租户不存在",
+			})
+			return
+		}
+	}
 	channel.CreatedTime = helper.GetTimestamp()
 	keys := strings.Split(channel.Key, "\n")
 	channels := make([]model.Channel, 0, len(keys))
@@ -128,6 +161,44 @@ func DeleteChannel(c *gin.Context) {
 	return
 }
 
+// RestoreChannel brings back an archived channel, disabled
+func RestoreChannel(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	channel := model.Channel{Id: id}
+	err := channel.Restore()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+// PurgeChannel deletes an archived channel for good
+func PurgeChannel(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	channel := model.Channel{Id: id}
+	err := channel.Purge()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
 func DeleteDisabledChannel(c *gin.Context) {
 	rows, err := model.DeleteDisabledChannel()
 	if err != nil {
@@ -155,6 +226,27 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if err = channel.ValidateConfig(); err == nil {
+		err = channel.SealConfig()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// the tenant of a channel doesn't change
+	if channel.TenantId, err = model.GetChannelTenantId(channel.Id); err == nil && !managesTenant(c, channel.TenantId) {
+		err = errors.New("无权更新其他租户的渠道")
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
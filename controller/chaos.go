@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllChaosRules(c *gin.Context) {
+	rules, err := model.GetAllChaosRules()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rules,
+	})
+}
+
+func GetChaosRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	rule, err := model.GetChaosRuleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rule,
+	})
+}
+
+func AddChaosRule(c *gin.Context) {
+	rule := model.ChaosRule{}
+	err := c.ShouldBindJSON(&rule)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = rule.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	rule.Id = 0
+	if rule.Status == 0 {
+		rule.Status = model.ChaosRuleStatusEnabled
+	}
+	if err = rule.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationChaosRules, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rule,
+	})
+}
+
+func UpdateChaosRule(c *gin.Context) {
+	rule := model.ChaosRule{}
+	err := c.ShouldBindJSON(&rule)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanRule, err := model.GetChaosRuleById(rule.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanRule.Status = rule.Status
+	} else {
+		rule.CreatedTime = cleanRule.CreatedTime
+		if rule.Status == 0 {
+			rule.Status = cleanRule.Status
+		}
+		cleanRule = &rule
+	}
+	if err = cleanRule.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanRule.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationChaosRules, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanRule,
+	})
+}
+
+func DeleteChaosRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	rule, err := model.GetChaosRuleById(id)
+	if err == nil {
+		err = rule.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationChaosRules, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllDebugCaptureRules(c *gin.Context) {
+	rules, err := model.GetAllDebugCaptureRules()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rules,
+	})
+}
+
+// AddDebugCaptureRule starts capturing the requests of a token or a channel for ttl seconds
+func AddDebugCaptureRule(c *gin.Context) {
+	rule := model.DebugCaptureRule{}
+	err := c.ShouldBindJSON(&rule)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = rule.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	rule.Id = 0
+	if err = rule.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationDebugCapture, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rule,
+	})
+}
+
+func DeleteDebugCaptureRule(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	rule, err := model.GetDebugCaptureRuleById(id)
+	if err == nil {
+		err = rule.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationDebugCapture, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetDebugCaptures lists the latest captures, the bodies are returned by GetDebugCapture
+func GetDebugCaptures(c *gin.Context) {
+	tokenId, _ := strconv.Atoi(c.Query("token_id"))
+	channelId, _ := strconv.Atoi(c.Query("channel_id"))
+	captures, err := model.GetDebugCaptures(tokenId, channelId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    captures,
+	})
+}
+
+func GetDebugCapture(c *gin.Context) {
+	captures, err := model.GetDebugCapturesByRequestId(c.Param("request_id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(captures) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "no capture for this request id",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    captures,
+	})
+}
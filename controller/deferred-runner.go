@@ -0,0 +1,264 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/loadshed"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/priority"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+const (
+	deferredPollInterval = 10 * time.Second
+	deferredPollLimit    = 50
+	// a request rate limited this many times is failed with the last response
+	deferredMaxAttempts = 10
+	// the node is idle under this pressure of the load shedding
+	deferredIdlePressure     = 0.5
+	deferredCallbackTimeout  = 10 * time.Second
+	deferredCallbackMaxDelay = 5 * time.Minute
+)
+
+// deferredHandler relays the deferred requests through the routes of the clients, see batchHandler
+var deferredHandler http.Handler
+
+var runningDeferred int32
+
+var deferredCallbackClient = &http.Client{Timeout: deferredCallbackTimeout}
+
+// deferredWindow is a time of the day, in minutes from midnight, which may wrap around midnight
+type deferredWindow struct {
+	start int
+	end   int
+}
+
+// parseDeferredWindow parses "HH:MM-HH:MM", nil for an empty window
+func parseDeferredWindow(value string) (*deferredWindow, error) {
+	if value == "" {
+		return nil, nil
+	}
+	start, end, found := strings.Cut(value, "-")
+	if !found {
+		return nil, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", value)
+	}
+	startTime, err := time.Parse("15:04", strings.TrimSpace(start))
+	if err != nil {
+		return nil, fmt.Errorf("invalid window %q: %w", value, err)
+	}
+	endTime, err := time.Parse("15:04", strings.TrimSpace(end))
+	if err != nil {
+		return nil, fmt.Errorf("invalid window %q: %w", value, err)
+	}
+	return &deferredWindow{
+		start: startTime.Hour()*60 + startTime.Minute(),
+		end:   endTime.Hour()*60 + endTime.Minute(),
+	}, nil
+}
+
+func (w *deferredWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// InitDeferredRunner relays the deferred requests on this node, at most config.DeferredConcurrency at a time
+func InitDeferredRunner(handler http.Handler) {
+	deferredHandler = handler
+	window, err := parseDeferredWindow(config.DeferredWindow)
+	if err != nil {
+		logger.SysError("DEFERRED_WINDOW is ignored: " + err.Error())
+	}
+	go func() {
+		for {
+			time.Sleep(deferredPollInterval)
+			if atomic.LoadInt32(&runningDeferred) >= int32(config.DeferredConcurrency) {
+				continue
+			}
+			pending, err := dbmodel.GetPendingDeferredRequests(deferredPollLimit)
+			if err != nil {
+				logger.SysError("failed to get deferred requests: " + err.Error())
+				continue
+			}
+			idle := window.contains(time.Now()) && nodeIdle()
+			for _, request := range pending {
+				if atomic.LoadInt32(&runningDeferred) >= int32(config.DeferredConcurrency) {
+					break
+				}
+				if !idle && helper.GetTimestamp() < request.ExpiresAt && !discountedChannelHealthy(request) {
+					continue
+				}
+				claimed, err := dbmodel.ClaimDeferredRequest(request)
+				if err != nil {
+					logger.SysError("failed to claim deferred request: " + err.Error())
+				}
+				if claimed == nil {
+					continue
+				}
+				atomic.AddInt32(&runningDeferred, 1)
+				go func() {
+					defer atomic.AddInt32(&runningDeferred, -1)
+					runDeferred(claimed)
+				}()
+			}
+		}
+	}()
+}
+
+// nodeIdle tells whether the node has capacity to spare: not near its load shedding thresholds
+// and no request waiting in the relay queue
+func nodeIdle() bool {
+	stats := loadshed.GetStats()
+	if stats.Level != loadshed.LevelNormal || stats.Pressure >= deferredIdlePressure {
+		return false
+	}
+	if queue := priority.RelayQueue(); queue != nil {
+		for _, class := range priority.Classes {
+			if queue.Waiting(class) > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func discountedChannelHealthy(request *dbmodel.DeferredRequest) bool {
+	group, err := dbmodel.CacheGetUserGroup(request.UserId)
+	if err != nil {
+		return false
+	}
+	return dbmodel.DiscountedChannelHealthy(request.TenantId, group, request.Model)
+}
+
+// runDeferred relays a deferred request and saves its response, a rate limited request is
+// queued again
+func runDeferred(request *dbmodel.DeferredRequest) {
+	ctx := helper.SetRequestID(context.Background(), request.Id)
+	token, err := dbmodel.GetTokenById(request.TokenId)
+	if err != nil {
+		body, _ := json.Marshal(gin.H{
+			"error": model.Error{
+				Message: "The token that queued the request was not found.",
+				Type:    "invalid_request_error",
+				Code:    "invalid_token",
+			},
+		})
+		finishDeferred(ctx, request, dbmodel.DeferredStatusFailed, http.StatusUnauthorized, "", body, "")
+		return
+	}
+	key := "sk-" + token.Key
+	recorder := dispatchDeferred(ctx, request, key)
+	if recorder.statusCode == http.StatusTooManyRequests && request.Attempts+1 < deferredMaxAttempts {
+		if err = dbmodel.RequeueDeferredRequest(request.Id); err != nil {
+			logger.Errorf(ctx, "failed to queue deferred request %s again: %s", request.Id, err.Error())
+		}
+		return
+	}
+	status := dbmodel.DeferredStatusCompleted
+	if recorder.statusCode/100 != 2 {
+		status = dbmodel.DeferredStatusFailed
+	}
+	finishDeferred(ctx, request, status, recorder.statusCode, recorder.header.Get(helper.RequestIdKey), recorder.body.Bytes(), key)
+}
+
+func dispatchDeferred(ctx context.Context, request *dbmodel.DeferredRequest, key string) *batchRecorder {
+	recorder := &batchRecorder{header: make(http.Header)}
+	req, err := http.NewRequestWithContext(dbmodel.WithSignatureVerified(dbmodel.WithDeferred(ctx)), http.MethodPost, request.Endpoint, bytes.NewReader(request.Body))
+	if err != nil {
+		recorder.statusCode = http.StatusInternalServerError
+		return recorder
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = net.JoinHostPort(request.ClientIp, "0")
+	deferredHandler.ServeHTTP(recorder, req)
+	if recorder.statusCode == 0 {
+		recorder.statusCode = http.StatusOK
+	}
+	return recorder
+}
+
+func finishDeferred(ctx context.Context, request *dbmodel.DeferredRequest, status string, statusCode int, requestId string, body []byte, key string) {
+	err := dbmodel.FinishDeferredRequest(request.Id, status, statusCode, requestId, body)
+	if err != nil {
+		// left to be relayed again once stale
+		logger.Errorf(ctx, "failed to save deferred request %s: %s", request.Id, err.Error())
+		return
+	}
+	logger.Infof(ctx, "deferred request %s %s with status code %d", request.Id, status, statusCode)
+	if request.CallbackUrl == "" || key == "" {
+		return
+	}
+	finished, err := dbmodel.GetDeferredRequest(request.Id, request.UserId)
+	if err != nil {
+		logger.Errorf(ctx, "failed to get deferred request %s: %s", request.Id, err.Error())
+		return
+	}
+	payload, err := json.Marshal(deferredObject(finished))
+	if err != nil {
+		logger.Errorf(ctx, "failed to marshal deferred request %s: %s", request.Id, err.Error())
+		return
+	}
+	go deliverDeferredCallback(ctx, finished, payload, key)
+}
+
+// deliverDeferredCallback posts a finished deferred request to its callback url, signed with the
+// token which queued it like the webhook deliveries, retrying with an exponential backoff
+func deliverDeferredCallback(ctx context.Context, request *dbmodel.DeferredRequest, payload []byte, key string) {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := postDeferredCallback(ctx, request, payload, key)
+		if err == nil {
+			return
+		}
+		if attempt >= config.WebhookRetryTimes {
+			logger.Errorf(ctx, "failed to call back deferred request %s after %d attempts: %s", request.Id, attempt+1, err.Error())
+			return
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > deferredCallbackMaxDelay {
+			delay = deferredCallbackMaxDelay
+		}
+	}
+}
+
+func postDeferredCallback(ctx context.Context, request *dbmodel.DeferredRequest, payload []byte, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, request.CallbackUrl, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	timestamp := helper.GetTimestamp()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Deferred-Request-Id", request.Id)
+	req.Header.Set("X-Deferred-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, dbmodel.SignWebhookPayload(key, timestamp, payload)))
+	resp, err := deferredCallbackClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
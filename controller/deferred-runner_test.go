@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dbmodel "github.com/songquanpeng/one-api/model"
+)
+
+func TestDeferredWindow(t *testing.T) {
+	at := func(value string) time.Time {
+		parsed, _ := time.Parse("15:04", value)
+		return parsed
+	}
+	testCases := []struct {
+		window  string
+		inside  []string
+		outside []string
+	}{
+		{"", []string{"00:00", "12:00", "23:59"}, nil},
+		{"01:00-06:00", []string{"01:00", "03:30", "05:59"}, []string{"00:59", "06:00", "23:00"}},
+		// wraps around midnight
+		{"22:00-02:00", []string{"22:00", "23:59", "00:00", "01:59"}, []string{"02:00", "12:00", "21:59"}},
+		{" 22:00 - 02:00 ", []string{"23:00"}, []string{"03:00"}},
+	}
+	for _, testCase := range testCases {
+		window, err := parseDeferredWindow(testCase.window)
+		require.NoError(t, err, testCase.window)
+		for _, inside := range testCase.inside {
+			assert.True(t, window.contains(at(inside)), "%s in %q", inside, testCase.window)
+		}
+		for _, outside := range testCase.outside {
+			assert.False(t, window.contains(at(outside)), "%s in %q", outside, testCase.window)
+		}
+	}
+	for _, invalid := range []string{"22:00", "25:00-02:00", "22:00-2pm"} {
+		_, err := parseDeferredWindow(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestRunDeferredRequeuesRateLimited(t *testing.T) {
+	initTestDB(t, &dbmodel.Token{}, &dbmodel.DeferredRequest{})
+	token := &dbmodel.Token{UserId: 1, Key: "deferredtoken", Name: "deferred", RequireSignature: true, SigningSecret: "secret"}
+	require.NoError(t, dbmodel.DB.Create(token).Error)
+	request := &dbmodel.DeferredRequest{
+		Id:       "deferred_1",
+		UserId:   1,
+		TokenId:  token.Id,
+		Endpoint: "/v1/chat/completions",
+		Body:     []byte(`{"model":"gpt-4o"}`),
+		Status:   dbmodel.DeferredStatusRunning,
+	}
+	require.NoError(t, dbmodel.DB.Create(request).Error)
+
+	statusCode := http.StatusTooManyRequests
+	previousHandler := deferredHandler
+	deferredHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the request is relayed without a signature, its creation was signed
+		assert.True(t, dbmodel.IsSignatureVerified(r.Context()))
+		assert.True(t, dbmodel.IsDeferred(r.Context()))
+		assert.Equal(t, "Bearer sk-deferredtoken", r.Header.Get("Authorization"))
+		w.WriteHeader(statusCode)
+	})
+	t.Cleanup(func() {
+		deferredHandler = previousHandler
+	})
+	reload := func() *dbmodel.DeferredRequest {
+		reloaded := &dbmodel.DeferredRequest{}
+		require.NoError(t, dbmodel.DB.First(reloaded, "id = ?", request.Id).Error)
+		return reloaded
+	}
+
+	// a rate limited request is queued again
+	runDeferred(request)
+	requeued := reload()
+	assert.Equal(t, dbmodel.DeferredStatusQueued, requeued.Status)
+	assert.Equal(t, 1, requeued.Attempts)
+
+	// until its last attempt, failed with the last response
+	requeued.Attempts = deferredMaxAttempts - 1
+	runDeferred(requeued)
+	failed := reload()
+	assert.Equal(t, dbmodel.DeferredStatusFailed, failed.Status)
+	assert.Equal(t, http.StatusTooManyRequests, failed.ResponseStatus)
+
+	statusCode = http.StatusOK
+	runDeferred(request)
+	assert.Equal(t, dbmodel.DeferredStatusCompleted, reload().Status)
+}
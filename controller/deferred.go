@@ -0,0 +1,156 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// deferred requests are requests to the endpoints of the batches which the client does not
+// wait for, they are relayed later at a discount, see deferred-runner.go
+
+type createDeferredRequest struct {
+	Endpoint    string            `json:"endpoint"`
+	Body        json.RawMessage   `json:"body"`
+	CallbackUrl string            `json:"callback_url"`
+	Metadata    map[string]string `json:"metadata"`
+}
+
+func deferredObject(request *dbmodel.DeferredRequest) gin.H {
+	var metadata any
+	if request.Metadata != "" {
+		metadata = json.RawMessage(request.Metadata)
+	}
+	var response any
+	if request.Status == dbmodel.DeferredStatusCompleted || request.Status == dbmodel.DeferredStatusFailed {
+		var body any = json.RawMessage(request.ResponseBody)
+		if !json.Valid(request.ResponseBody) {
+			body = string(request.ResponseBody)
+		}
+		response = gin.H{
+			"status_code": request.ResponseStatus,
+			"request_id":  request.RequestId,
+			"body":        body,
+		}
+	}
+	return gin.H{
+		"id":           request.Id,
+		"object":       "deferred_request",
+		"endpoint":     request.Endpoint,
+		"model":        request.Model,
+		"status":       request.Status,
+		"callback_url": nullableString(request.CallbackUrl),
+		"created_at":   request.CreatedAt,
+		"expires_at":   request.ExpiresAt,
+		"started_at":   nullableTime(request.StartedAt),
+		"completed_at": nullableTime(request.CompletedAt),
+		"cancelled_at": nullableTime(request.CancelledAt),
+		"response":     response,
+		"metadata":     metadata,
+	}
+}
+
+func deferredRequestNotFound(c *gin.Context, id string) {
+	invalidRequest(c, http.StatusNotFound, "deferred_request_id", fmt.Sprintf("No deferred request found with id '%s'.", id))
+}
+
+// CreateDeferredRequest queues a request to be relayed when the gateway has capacity to spare
+func CreateDeferredRequest(c *gin.Context) {
+	var request createDeferredRequest
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		invalidRequest(c, http.StatusBadRequest, "", "Invalid request body: "+err.Error())
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		invalidRequest(c, http.StatusBadRequest, "endpoint", fmt.Sprintf("Invalid endpoint '%s'.", request.Endpoint))
+		return
+	}
+	var body struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if json.Unmarshal(request.Body, &body) != nil || body.Model == "" {
+		invalidRequest(c, http.StatusBadRequest, "body", "The body of the request must be an object with a model.")
+		return
+	}
+	if body.Stream {
+		invalidRequest(c, http.StatusBadRequest, "body", "Streaming is not supported for a deferred request.")
+		return
+	}
+	if request.CallbackUrl != "" {
+		parsed, err := url.Parse(request.CallbackUrl)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			invalidRequest(c, http.StatusBadRequest, "callback_url", "The callback_url must be an http or https url.")
+			return
+		}
+	}
+	now := helper.GetTimestamp()
+	deferred := &dbmodel.DeferredRequest{
+		Id:          "deferred_" + random.GetUUID(),
+		UserId:      c.GetInt(ctxkey.Id),
+		TokenId:     c.GetInt(ctxkey.TokenId),
+		TenantId:    c.GetInt(ctxkey.TenantId),
+		ClientIp:    c.ClientIP(),
+		Endpoint:    request.Endpoint,
+		Model:       body.Model,
+		Body:        request.Body,
+		CallbackUrl: request.CallbackUrl,
+		Status:      dbmodel.DeferredStatusQueued,
+		ExpiresAt:   now + int64(config.DeferredMaxDelay)*3600,
+	}
+	if len(request.Metadata) > 0 {
+		metadata, _ := json.Marshal(request.Metadata)
+		deferred.Metadata = string(metadata)
+	}
+	if err := deferred.Insert(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": model.Error{Message: err.Error(), Type: "one_api_error"},
+		})
+		return
+	}
+	c.JSON(http.StatusAccepted, deferredObject(deferred))
+}
+
+func GetDeferredRequest(c *gin.Context) {
+	id := c.Param("id")
+	request, err := dbmodel.GetDeferredRequest(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		deferredRequestNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, deferredObject(request))
+}
+
+// CancelDeferredRequest cancels a deferred request which has not been relayed yet
+func CancelDeferredRequest(c *gin.Context) {
+	id := c.Param("id")
+	userId := c.GetInt(ctxkey.Id)
+	cancelled, err := dbmodel.CancelDeferredRequest(id, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": model.Error{Message: err.Error(), Type: "one_api_error"},
+		})
+		return
+	}
+	request, err := dbmodel.GetDeferredRequest(id, userId)
+	if err != nil {
+		deferredRequestNotFound(c, id)
+		return
+	}
+	if !cancelled && request.Status != dbmodel.DeferredStatusCancelled {
+		invalidRequest(c, http.StatusConflict, "", fmt.Sprintf("Cannot cancel a deferred request with status '%s'.", request.Status))
+		return
+	}
+	c.JSON(http.StatusOK, deferredObject(request))
+}
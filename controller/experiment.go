@@ -0,0 +1,189 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllExperiments(c *gin.Context) {
+	experiments, err := model.GetAllExperiments()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    experiments,
+	})
+}
+
+func GetExperiment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	experiment, err := model.GetExperimentById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    experiment,
+	})
+}
+
+func AddExperiment(c *gin.Context) {
+	experiment := model.Experiment{}
+	err := c.ShouldBindJSON(&experiment)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = experiment.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	experiment.Id = 0
+	if experiment.Status == 0 {
+		experiment.Status = model.ExperimentStatusEnabled
+	}
+	if err = experiment.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationExperiments, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    experiment,
+	})
+}
+
+func UpdateExperiment(c *gin.Context) {
+	experiment := model.Experiment{}
+	err := c.ShouldBindJSON(&experiment)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanExperiment, err := model.GetExperimentById(experiment.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanExperiment.Status = experiment.Status
+	} else {
+		experiment.CreatedTime = cleanExperiment.CreatedTime
+		if experiment.Status == 0 {
+			experiment.Status = cleanExperiment.Status
+		}
+		cleanExperiment = &experiment
+	}
+	if err = cleanExperiment.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanExperiment.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationExperiments, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanExperiment,
+	})
+}
+
+func DeleteExperiment(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	experiment, err := model.GetExperimentById(id)
+	if err == nil {
+		err = experiment.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationExperiments, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetExperimentResults returns the outcomes of the arms of an experiment with their standard errors
+func GetExperimentResults(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	results, err := model.GetExperimentResults(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    results,
+	})
+}
+
+// recordExperimentRequest records the outcome of a relay request in the arm of its experiment
+func recordExperimentRequest(c *gin.Context, startTime time.Time, failed bool) {
+	if experimentId := c.GetInt(ctxkey.ExperimentId); experimentId != 0 {
+		model.RecordExperimentRequest(experimentId, c.GetString(ctxkey.ExperimentArm), time.Since(startTime), failed)
+	}
+}
@@ -0,0 +1,185 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/files
+
+const (
+	fileMinExpiresAfter = 3600    // unit is second
+	fileMaxExpiresAfter = 2592000 // unit is second
+)
+
+func fileObject(file *dbmodel.File) gin.H {
+	return gin.H{
+		"id":         file.Id,
+		"object":     "file",
+		"bytes":      file.Bytes,
+		"created_at": file.CreatedAt,
+		"expires_at": nullableTime(file.ExpiresAt),
+		"filename":   file.Filename,
+		"purpose":    file.Purpose,
+		"status":     "processed",
+	}
+}
+
+func invalidRequest(c *gin.Context, statusCode int, param string, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": model.Error{
+			Message: message,
+			Type:    "invalid_request_error",
+			Param:   param,
+		},
+	})
+}
+
+func fileNotFound(c *gin.Context, id string) {
+	invalidRequest(c, http.StatusNotFound, "id", fmt.Sprintf("No such File object: %s", id))
+}
+
+func fileServerError(c *gin.Context, err error) {
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"error": model.Error{Message: err.Error(), Type: "one_api_error"},
+	})
+}
+
+func UploadFile(c *gin.Context) {
+	ctx := c.Request.Context()
+	purpose := c.PostForm("purpose")
+	if !dbmodel.IsFileUploadPurpose(purpose) {
+		invalidRequest(c, http.StatusBadRequest, "purpose", fmt.Sprintf("Invalid purpose '%s'.", purpose))
+		return
+	}
+	var expiresAfter int64
+	if seconds := c.PostForm("expires_after[seconds]"); seconds != "" {
+		expiresAfter, _ = strconv.ParseInt(seconds, 10, 64)
+		if c.PostForm("expires_after[anchor]") != "created_at" || expiresAfter < fileMinExpiresAfter || expiresAfter > fileMaxExpiresAfter {
+			invalidRequest(c, http.StatusBadRequest, "expires_after", fmt.Sprintf("expires_after must be anchored at created_at with seconds between %d and %d.", fileMinExpiresAfter, fileMaxExpiresAfter))
+			return
+		}
+	}
+	header, err := c.FormFile("file")
+	if err != nil {
+		invalidRequest(c, http.StatusBadRequest, "file", "A file must be uploaded.")
+		return
+	}
+	if header.Size > int64(config.FileMaxSize)<<20 {
+		invalidRequest(c, http.StatusBadRequest, "file", fmt.Sprintf("The file exceeds the maximum size of %d MB.", config.FileMaxSize))
+		return
+	}
+	userId := c.GetInt(ctxkey.Id)
+	if config.FileStorageQuota > 0 {
+		used, err := dbmodel.GetUserFileBytes(userId)
+		if err != nil {
+			fileServerError(c, err)
+			return
+		}
+		if used+header.Size > int64(config.FileStorageQuota)<<20 {
+			invalidRequest(c, http.StatusBadRequest, "file", fmt.Sprintf("The file storage quota of %d MB is exceeded.", config.FileStorageQuota))
+			return
+		}
+	}
+	reader, err := header.Open()
+	if err != nil {
+		invalidRequest(c, http.StatusBadRequest, "file", err.Error())
+		return
+	}
+	defer reader.Close()
+	content, err := io.ReadAll(reader)
+	if err != nil {
+		invalidRequest(c, http.StatusBadRequest, "file", err.Error())
+		return
+	}
+	file := &dbmodel.File{
+		Id:          "file-" + random.GetUUID(),
+		UserId:      userId,
+		Purpose:     purpose,
+		Filename:    header.Filename,
+		ContentType: header.Header.Get("Content-Type"),
+	}
+	if expiresAfter > 0 {
+		file.ExpiresAt = helper.GetTimestamp() + expiresAfter
+	}
+	if err = file.Save(ctx, content); err != nil {
+		fileServerError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+func ListFiles(c *gin.Context) {
+	files, err := dbmodel.GetFiles(c.GetInt(ctxkey.Id), c.Query("purpose"))
+	if err != nil {
+		fileServerError(c, err)
+		return
+	}
+	data := make([]gin.H, 0, len(files))
+	for _, file := range files {
+		data = append(data, fileObject(file))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+func GetFile(c *gin.Context) {
+	id := c.Param("id")
+	file, err := dbmodel.GetFile(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		fileNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, fileObject(file))
+}
+
+func GetFileContent(c *gin.Context) {
+	id := c.Param("id")
+	file, err := dbmodel.GetFile(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		fileNotFound(c, id)
+		return
+	}
+	content, err := file.Content(c.Request.Context())
+	if err != nil {
+		fileServerError(c, err)
+		return
+	}
+	contentType := file.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Filename))
+	c.Data(http.StatusOK, contentType, content)
+}
+
+func DeleteFile(c *gin.Context) {
+	id := c.Param("id")
+	deleted, err := dbmodel.DeleteFile(c.Request.Context(), id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		fileServerError(c, err)
+		return
+	}
+	if !deleted {
+		fileNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "file",
+		"deleted": true,
+	})
+}
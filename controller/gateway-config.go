@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+// ExportGatewayConfig returns the gateway config as a YAML file or, with ?format=json, as JSON
+func ExportGatewayConfig(c *gin.Context) {
+	cfg, err := model.ExportGatewayConfig()
+	var data []byte
+	if err == nil {
+		data, err = model.MarshalGatewayConfig(cfg, c.Query("format"))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	contentType := "application/yaml"
+	if c.Query("format") == "json" {
+		contentType = "application/json"
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// ApplyGatewayConfig applies the config, YAML or JSON, of the request body and returns the
+// changes it made, with ?dry_run=true the changes it would make
+func ApplyGatewayConfig(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cfg, err := model.UnmarshalGatewayConfig(body)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	changes, err := model.ApplyGatewayConfig(cfg, c.Query("dry_run") == "true")
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    changes,
+	})
+}
@@ -31,6 +31,7 @@ type ChannelHealthDetail struct {
 	RequestCount    int64   `json:"request_count"`
 	ConsecutiveFail int     `json:"consecutive_fail"`
 	Score           float64 `json:"score"`
+	ThrottledUntil  int64   `json:"throttled_until"` // unix time, 0 when the channel is not throttled
 }
 
 // IntelligenceStats represents overall intelligence system stats
@@ -48,7 +49,7 @@ type IntelligenceStats struct {
 // GetIntelligenceHealth returns health status grouped by provider
 func GetIntelligenceHealth(c *gin.Context) {
 	stats := model.GetChannelHealthStats()
-	channels, _ := model.GetAllChannels(0, 0, "enabled")
+	channels, _ := model.GetAllChannels(model.AllTenants, 0, 0, "enabled")
 
 	// Create channel ID to channel map
 	channelMap := make(map[int]*model.Channel)
@@ -145,7 +146,7 @@ func safeInt(m map[string]interface{}, key string) int {
 // GetChannelHealthDetails returns detailed health for all channels
 func GetChannelHealthDetails(c *gin.Context) {
 	stats := model.GetChannelHealthStats()
-	channels, _ := model.GetAllChannels(0, 0, "enabled")
+	channels, _ := model.GetAllChannels(model.AllTenants, 0, 0, "enabled")
 
 	var result []ChannelHealthDetail
 	for _, channel := range channels {
@@ -164,9 +165,12 @@ func GetChannelHealthDetails(c *gin.Context) {
 			detail.RequestCount = safeInt64(stat, "total_requests")
 			detail.ConsecutiveFail = safeInt(stat, "consecutive_fail")
 			detail.Score = safeFloat64(stat, "score")
+			detail.ThrottledUntil = safeInt64(stat, "throttled_until")
 
 			// Determine status
-			if detail.SuccessRate >= 0.95 && detail.ConsecutiveFail == 0 {
+			if detail.ThrottledUntil > 0 {
+				detail.Status = "throttled"
+			} else if detail.SuccessRate >= 0.95 && detail.ConsecutiveFail == 0 {
 				detail.Status = "healthy"
 			} else if detail.SuccessRate >= 0.80 || detail.ConsecutiveFail < 3 {
 				detail.Status = "degraded"
@@ -192,7 +196,7 @@ func GetChannelHealthDetails(c *gin.Context) {
 // GetIntelligenceStats returns overall stats for the intelligence system
 func GetIntelligenceStats(c *gin.Context) {
 	stats := model.GetChannelHealthStats()
-	channels, _ := model.GetAllChannels(0, 0, "enabled")
+	channels, _ := model.GetAllChannels(model.AllTenants, 0, 0, "enabled")
 
 	result := IntelligenceStats{
 		ActiveChannels: len(channels),
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/scheduler"
+)
+
+// GetAllJobs lists the periodic jobs with their latest runs
+func GetAllJobs(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"node": scheduler.Node(),
+			"jobs": scheduler.List(),
+		},
+	})
+}
+
+// RunJob runs a job now, unless a node is running it
+func RunJob(c *gin.Context) {
+	err := scheduler.Trigger(c.Param("name"))
+	if err != nil {
+		status := http.StatusOK
+		if errors.Is(err, scheduler.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
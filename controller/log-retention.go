@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetLogRetention(c *gin.Context) {
+	data := gin.H{
+		"status":            model.GetLogRetentionStatus(),
+		"retention_days":    config.LogRetentionDays,
+		"archive_enabled":   config.LogArchiveEnabled,
+		"partition_enabled": config.LogPartitionEnabled,
+	}
+	if config.LogPartitionEnabled {
+		partitions, err := model.GetLogPartitions()
+		if err != nil {
+			data["partition_error"] = err.Error()
+		}
+		data["partitions"] = partitions
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    data,
+	})
+}
+
+// RunLogRetention starts the retention job, days defaults to LOG_RETENTION_DAYS
+func RunLogRetention(c *gin.Context) {
+	days := config.LogRetentionDays
+	if c.Query("days") != "" {
+		var err error
+		days, err = strconv.Atoi(c.Query("days"))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "invalid days",
+			})
+			return
+		}
+	}
+	if err := model.StartLogRetention(days); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetLogRetentionStatus(),
+	})
+}
+
+func EnsureLogPartitions(c *gin.Context) {
+	if err := model.EnsureLogPartitions(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	partitions, _ := model.GetLogPartitions()
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    partitions,
+	})
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const (
+	logSearchMaxLimit    = 1000
+	logExportBatchSize   = 1000
+	logExportMaxRows     = 1000000
+	logSearchDefaultSize = 100
+)
+
+var logExportColumns = []string{
+	"id", "created_at", "type", "user_id", "username", "token_name", "channel", "model_name", "status_code",
+	"cache_hit", "prompt_tokens", "completion_tokens", "quota", "elapsed_time", "is_stream", "partial", "request_id", "content",
+}
+
+func parseLogSearchFilter(c *gin.Context) (*model.LogSearchFilter, error) {
+	filter := &model.LogSearchFilter{
+		TenantId:  adminTenantId(c),
+		Username:  c.Query("username"),
+		TokenName: c.Query("token_name"),
+		ModelName: c.Query("model_name"),
+	}
+	var err error
+	parseInt := func(key string) int {
+		if err != nil || c.Query(key) == "" {
+			return 0
+		}
+		var value int
+		if value, err = strconv.Atoi(c.Query(key)); err != nil {
+			err = fmt.Errorf("invalid %s", key)
+		}
+		return value
+	}
+	filter.Type = parseInt("type")
+	filter.UserId = parseInt("user_id")
+	filter.ChannelId = parseInt("channel")
+	filter.Start = int64(parseInt("start_timestamp"))
+	filter.End = int64(parseInt("end_timestamp"))
+	filter.MinLatency = int64(parseInt("min_latency"))
+	switch status := c.Query("status"); status {
+	case "", model.LogStatusSuccess, model.LogStatusError:
+		filter.Status = status
+	default:
+		filter.StatusCode = parseInt("status")
+	}
+	if c.Query("cache_hit") != "" {
+		cacheHit, parseErr := strconv.ParseBool(c.Query("cache_hit"))
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("invalid cache_hit")
+		}
+		filter.CacheHit = &cacheHit
+	}
+	if c.Query("partial") != "" {
+		partial, parseErr := strconv.ParseBool(c.Query("partial"))
+		if parseErr != nil && err == nil {
+			err = fmt.Errorf("invalid partial")
+		}
+		filter.Partial = &partial
+	}
+	return filter, err
+}
+
+// SearchLogsByCursor pages through the logs newest first, pass the returned
+// next_cursor as cursor to get the next page, format=csv or jsonl exports
+// every matching log instead
+func SearchLogsByCursor(c *gin.Context) {
+	filter, err := parseLogSearchFilter(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cursor, _ := strconv.Atoi(c.Query("cursor"))
+	switch format := c.Query("format"); format {
+	case "csv", "jsonl":
+		exportLogs(c, filter, cursor, format)
+		return
+	case "":
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "unsupported format: " + format,
+		})
+		return
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 {
+		limit = logSearchDefaultSize
+	}
+	if limit > logSearchMaxLimit {
+		limit = logSearchMaxLimit
+	}
+	logs, err := model.SearchLogs(filter, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	nextCursor := 0
+	if len(logs) == limit {
+		nextCursor = logs[len(logs)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"logs":        logs,
+			"next_cursor": nextCursor,
+		},
+	})
+}
+
+// exportLogs streams the matching logs by batches, so exports do not hold them all in memory
+func exportLogs(c *gin.Context, filter *model.LogSearchFilter, cursor int, format string) {
+	contentType := "application/x-ndjson"
+	if format == "csv" {
+		contentType = "text/csv; charset=utf-8"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=logs-%s.%s", time.Now().Format("20060102150405"), format))
+	c.Status(http.StatusOK)
+
+	csvWriter := csv.NewWriter(c.Writer)
+	encoder := json.NewEncoder(c.Writer)
+	if format == "csv" {
+		_ = csvWriter.Write(logExportColumns)
+	}
+	exported := 0
+	for exported < logExportMaxRows {
+		logs, err := model.SearchLogs(filter, cursor, logExportBatchSize)
+		if err != nil {
+			// the headers are already sent, the truncated export is all we can do
+			logger.Error(c.Request.Context(), "failed to export logs: "+err.Error())
+			break
+		}
+		for _, log := range logs {
+			if format == "csv" {
+				err = csvWriter.Write(logExportRecord(log))
+			} else {
+				err = encoder.Encode(log)
+			}
+			if err != nil {
+				return
+			}
+		}
+		csvWriter.Flush()
+		c.Writer.Flush()
+		exported += len(logs)
+		if len(logs) < logExportBatchSize {
+			break
+		}
+		cursor = logs[len(logs)-1].Id
+	}
+	csvWriter.Flush()
+}
+
+func logExportRecord(log *model.Log) []string {
+	return []string{
+		strconv.Itoa(log.Id),
+		strconv.FormatInt(log.CreatedAt, 10),
+		strconv.Itoa(log.Type),
+		strconv.Itoa(log.UserId),
+		log.Username,
+		log.TokenName,
+		strconv.Itoa(log.ChannelId),
+		log.ModelName,
+		strconv.Itoa(log.StatusCode),
+		log.CacheHit,
+		strconv.Itoa(log.PromptTokens),
+		strconv.Itoa(log.CompletionTokens),
+		strconv.Itoa(log.Quota),
+		strconv.FormatInt(log.ElapsedTime, 10),
+		strconv.FormatBool(log.IsStream),
+		strconv.FormatBool(log.Partial),
+		log.RequestId,
+		log.Content,
+	}
+}
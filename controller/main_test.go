@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// initTestDB opens a SQLite database with the tables of tables only, migrating them all is slow
+func initTestDB(t *testing.T, tables ...any) {
+	common.RedisEnabled = false
+	config.MemoryCacheEnabled = false
+	common.UsingSQLite = true
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/one-api.db"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(tables...))
+	model.DB = db
+	model.LOG_DB = db
+}
@@ -8,8 +8,10 @@ import (
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/currency"
 	"github.com/songquanpeng/one-api/common/i18n"
 	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/payment"
 	"github.com/songquanpeng/one-api/model"
 
 	"github.com/gin-gonic/gin"
@@ -38,6 +40,9 @@ func GetStatus(c *gin.Context) {
 			"chat_link":                   config.ChatLink,
 			"quota_per_unit":              config.QuotaPerUnit,
 			"display_in_currency":         config.DisplayInCurrencyEnabled,
+			"currency":                    currency.Default(),
+			"exchange_rates":              currency.ExchangeRates(),
+			"payment_providers":           payment.ProviderNames(),
 			"oidc":                        config.OidcEnabled,
 			"oidc_client_id":              config.OidcClientId,
 			"oidc_well_known":             config.OidcWellKnown,
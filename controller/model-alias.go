@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllModelAliases(c *gin.Context) {
+	aliases, err := model.GetAllModelAliases()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    aliases,
+	})
+}
+
+func GetModelAlias(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	alias, err := model.GetModelAliasById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    alias,
+	})
+}
+
+func AddModelAlias(c *gin.Context) {
+	alias := model.ModelAlias{}
+	err := c.ShouldBindJSON(&alias)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = alias.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	alias.Id = 0
+	alias.Hits = 0
+	alias.LastHitTime = 0
+	if alias.Status == 0 {
+		alias.Status = model.ModelAliasStatusEnabled
+	}
+	if err = alias.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationModelAliases, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    alias,
+	})
+}
+
+func UpdateModelAlias(c *gin.Context) {
+	alias := model.ModelAlias{}
+	err := c.ShouldBindJSON(&alias)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanAlias, err := model.GetModelAliasById(alias.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanAlias.Status = alias.Status
+	} else {
+		alias.Hits = cleanAlias.Hits
+		alias.LastHitTime = cleanAlias.LastHitTime
+		alias.CreatedTime = cleanAlias.CreatedTime
+		if alias.Status == 0 {
+			alias.Status = cleanAlias.Status
+		}
+		cleanAlias = &alias
+	}
+	if err = cleanAlias.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanAlias.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationModelAliases, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanAlias,
+	})
+}
+
+func DeleteModelAlias(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	alias, err := model.GetModelAliasById(id)
+	if err == nil {
+		err = alias.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationModelAliases, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
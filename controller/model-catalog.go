@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// ModelPricing is per million tokens in the currency of the group of the user, with the
+// group ratio applied
+type ModelPricing struct {
+	Input       float64 `json:"input"`
+	Output      float64 `json:"output"`
+	CachedInput float64 `json:"cached_input"`
+	Currency    string  `json:"currency"`
+}
+
+// ModelCatalogEntry describes a model the user can use, the fields of the model metadata
+// are zero when the model has none
+type ModelCatalogEntry struct {
+	Id               string       `json:"id"`
+	Object           string       `json:"object"`
+	OwnedBy          string       `json:"owned_by"`
+	Description      string       `json:"description"`
+	ContextWindow    int          `json:"context_window"`
+	MaxOutputTokens  int          `json:"max_output_tokens"`
+	InputModalities  []string     `json:"input_modalities"`
+	OutputModalities []string     `json:"output_modalities"`
+	SupportsTools    bool         `json:"supports_tools"`
+	SupportsJSON     bool         `json:"supports_json"`
+	Pricing          ModelPricing `json:"pricing"`
+	Deprecated       bool         `json:"deprecated"`
+	SunsetTime       int64        `json:"sunset_time"`
+	Replacement      string       `json:"replacement"`
+}
+
+// pricePerMillionTokens converts a ratio to the price of a million tokens in a currency
+func pricePerMillionTokens(ratio float64, code string) float64 {
+	return math.Round(currency.FromUSD(ratio*1000000/config.QuotaPerUnit, code)*1000000) / 1000000
+}
+
+func getModelCatalogEntry(modelName string, groupRatio float64, code string) ModelCatalogEntry {
+	entry := ModelCatalogEntry{
+		Id:               modelName,
+		Object:           "model",
+		OwnedBy:          "custom",
+		InputModalities:  model.Modalities(""),
+		OutputModalities: model.Modalities(""),
+	}
+	if openAIModel, ok := modelsMap[modelName]; ok {
+		entry.OwnedBy = openAIModel.OwnedBy
+	}
+	if meta, ok := model.CacheGetModelMeta(modelName); ok {
+		entry.Description = meta.Description
+		entry.ContextWindow = meta.ContextWindow
+		entry.MaxOutputTokens = meta.MaxOutputTokens
+		entry.InputModalities = model.Modalities(meta.InputModalities)
+		entry.OutputModalities = model.Modalities(meta.OutputModalities)
+		entry.SupportsTools = meta.SupportsTools
+		entry.SupportsJSON = meta.SupportsJSON
+		entry.Deprecated = meta.Deprecated
+		entry.SunsetTime = meta.SunsetTime
+		entry.Replacement = meta.Replacement
+	}
+	ratio := billingratio.GetModelRatio(modelName, 0) * groupRatio
+	entry.Pricing = ModelPricing{
+		Input:       pricePerMillionTokens(ratio, code),
+		Output:      pricePerMillionTokens(ratio*billingratio.GetCompletionRatio(modelName, 0), code),
+		CachedInput: pricePerMillionTokens(ratio*billingratio.GetCacheRatio(modelName), code),
+		Currency:    code,
+	}
+	return entry
+}
+
+// ListModelCatalog lists the models of ListModels with their capabilities, prices and
+// deprecation
+func ListModelCatalog(c *gin.Context) {
+	userGroup, _ := model.CacheGetUserGroup(c.GetInt(ctxkey.Id))
+	groupRatio := billingratio.GetGroupRatio(userGroup)
+	code := currency.OfGroup(userGroup)
+	modelNames := availableModelNames(c)
+	sort.Strings(modelNames)
+	catalog := make([]ModelCatalogEntry, 0, len(modelNames))
+	for i, modelName := range modelNames {
+		if i > 0 && modelName == modelNames[i-1] {
+			continue
+		}
+		catalog = append(catalog, getModelCatalogEntry(modelName, groupRatio, code))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   catalog,
+	})
+}
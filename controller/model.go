@@ -129,18 +129,40 @@ func ListAllModels(c *gin.Context) {
 	})
 }
 
-func ListModels(c *gin.Context) {
+// availableModelNames returns the models the token or else the group of the user can use
+func availableModelNames(c *gin.Context) []string {
 	ctx := c.Request.Context()
 	var availableModels []string
-	if c.GetString(ctxkey.AvailableModels) != "" {
-		availableModels = strings.Split(c.GetString(ctxkey.AvailableModels), ",")
+	if tokenModels := c.GetString(ctxkey.AvailableModels); tokenModels != "" {
+		hasPattern := false
+		for _, tokenModel := range strings.Split(tokenModels, ",") {
+			if model.IsModelPattern(tokenModel) {
+				hasPattern = true
+			} else {
+				availableModels = append(availableModels, tokenModel)
+			}
+		}
+		if hasPattern {
+			// the patterns list the models of the group they match
+			userGroup, _ := model.CacheGetUserGroup(c.GetInt(ctxkey.Id))
+			groupModels, _ := model.CacheGetGroupModels(ctx, c.GetInt(ctxkey.TenantId), userGroup)
+			for _, groupModel := range groupModels {
+				if model.IsModelAllowed(groupModel, tokenModels) {
+					availableModels = append(availableModels, groupModel)
+				}
+			}
+		}
 	} else {
 		userId := c.GetInt(ctxkey.Id)
 		userGroup, _ := model.CacheGetUserGroup(userId)
-		availableModels, _ = model.CacheGetGroupModels(ctx, userGroup)
+		availableModels, _ = model.CacheGetGroupModels(ctx, c.GetInt(ctxkey.TenantId), userGroup)
 	}
+	return availableModels
+}
+
+func ListModels(c *gin.Context) {
 	modelSet := make(map[string]bool)
-	for _, availableModel := range availableModels {
+	for _, availableModel := range availableModelNames(c) {
 		modelSet[availableModel] = true
 	}
 	availableOpenAIModels := make([]OpenAIModels, 0)
@@ -196,7 +218,7 @@ func GetUserAvailableModels(c *gin.Context) {
 		})
 		return
 	}
-	models, err := model.CacheGetGroupModels(ctx, userGroup)
+	models, err := model.CacheGetGroupModels(ctx, c.GetInt(ctxkey.TenantId), userGroup)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
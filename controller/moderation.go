@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllModerationPolicies(c *gin.Context) {
+	policies, err := model.GetAllModerationPolicies()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policies,
+	})
+}
+
+func GetModerationPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	policy, err := model.GetModerationPolicyById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policy,
+	})
+}
+
+func AddModerationPolicy(c *gin.Context) {
+	policy := model.ModerationPolicy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = policy.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	policy.Id = 0
+	if policy.Status == 0 {
+		policy.Status = model.ModerationPolicyStatusEnabled
+	}
+	if err = policy.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationModeration, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policy,
+	})
+}
+
+func UpdateModerationPolicy(c *gin.Context) {
+	policy := model.ModerationPolicy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPolicy, err := model.GetModerationPolicyById(policy.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanPolicy.Status = policy.Status
+	} else {
+		policy.CreatedTime = cleanPolicy.CreatedTime
+		if policy.Status == 0 {
+			policy.Status = cleanPolicy.Status
+		}
+		cleanPolicy = &policy
+	}
+	if err = cleanPolicy.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanPolicy.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationModeration, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPolicy,
+	})
+}
+
+func DeleteModerationPolicy(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	policy, err := model.GetModerationPolicyById(id)
+	if err == nil {
+		err = policy.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationModeration, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
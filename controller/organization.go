@@ -0,0 +1,493 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+type addOrganizationRequest struct {
+	model.Organization
+	OwnerId int `json:"owner_id"`
+}
+
+type organizationMemberRequest struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	organizations, err := model.GetAllOrganizations(p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+}
+
+func GetOrganization(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	organization, err := model.GetOrganizationById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	members, err := model.GetOrganizationMembers(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": organization,
+			"members":      members,
+		},
+	})
+}
+
+func AddOrganization(c *gin.Context) {
+	req := addOrganizationRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.OwnerId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "organization needs an owner",
+		})
+		return
+	}
+	organization := model.Organization{
+		Name:  req.Name,
+		Quota: req.Quota,
+	}
+	if err = organization.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = organization.Insert(req.OwnerId); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organization,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	organization := model.Organization{}
+	err := c.ShouldBindJSON(&organization)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = organization.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if organization.Status != model.OrganizationStatusEnabled && organization.Status != model.OrganizationStatusDisabled {
+		organization.Status = model.OrganizationStatusEnabled
+	}
+	if err = organization.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organization,
+	})
+}
+
+func DeleteOrganization(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	organization, err := model.GetOrganizationById(id)
+	if err == nil {
+		err = organization.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// getSelfOrganizationMember returns the membership of the user, and answers the request
+// when the user is in no organization, or does not manage it while manage is set
+func getSelfOrganizationMember(c *gin.Context, manage bool) (*model.OrganizationMember, bool) {
+	member, err := model.GetUserOrganizationMember(c.GetInt(ctxkey.Id))
+	if err != nil {
+		message := err.Error()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			message = "您不属于任何组织"
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return nil, false
+	}
+	if manage && !member.CanManage() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "只有组织的所有者和管理员可以执行此操作",
+		})
+		return nil, false
+	}
+	return member, true
+}
+
+func GetSelfOrganization(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, false)
+	if !ok {
+		return
+	}
+	organization, err := model.GetOrganizationById(member.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": organization,
+			"role":         member.Role,
+		},
+	})
+}
+
+func GetSelfOrganizationMembers(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, false)
+	if !ok {
+		return
+	}
+	members, err := model.GetOrganizationMembers(member.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    members,
+	})
+}
+
+// checkAssignableRole tells whether the member may give a role: the owner gives the admin
+// and member roles, the admins only the member role, and there is a single owner
+func checkAssignableRole(member *model.OrganizationMember, role string) error {
+	if !model.IsOrganizationRole(role) || role == model.OrganizationRoleOwner {
+		return fmt.Errorf("invalid role %s, use admin or member", role)
+	}
+	if role == model.OrganizationRoleAdmin && member.Role != model.OrganizationRoleOwner {
+		return errors.New("只有组织的所有者可以任命管理员")
+	}
+	return nil
+}
+
+// checkManageableMember tells whether the member may change or remove another member
+func checkManageableMember(member *model.OrganizationMember, userId int) error {
+	target, err := model.GetUserOrganizationMember(userId)
+	if err != nil || target.OrganizationId != member.OrganizationId {
+		return fmt.Errorf("user #%d is not in the organization", userId)
+	}
+	if target.Role == model.OrganizationRoleOwner {
+		return errors.New("不能修改或移除组织的所有者")
+	}
+	if target.Role == model.OrganizationRoleAdmin && member.Role != model.OrganizationRoleOwner {
+		return errors.New("只有组织的所有者可以修改或移除管理员")
+	}
+	return nil
+}
+
+func AddSelfOrganizationMember(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	req := organizationMemberRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Role == "" {
+		req.Role = model.OrganizationRoleMember
+	}
+	if err = checkAssignableRole(member, req.Role); err == nil && req.UserId == 0 {
+		user := model.User{Username: req.Username}
+		if err = user.FillUserByUsername(); err == nil {
+			req.UserId = user.Id
+		}
+	}
+	if err == nil {
+		var tenantId int
+		if tenantId, err = model.GetUserTenantId(req.UserId); err == nil && tenantId != c.GetInt(ctxkey.TenantId) {
+			err = errors.New("the user belongs to another tenant")
+		}
+	}
+	if err == nil {
+		err = model.AddOrganizationMember(member.OrganizationId, req.UserId, req.Role)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func UpdateSelfOrganizationMember(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	req := organizationMemberRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = checkManageableMember(member, req.UserId)
+	if err == nil {
+		err = checkAssignableRole(member, req.Role)
+	}
+	if err == nil {
+		err = model.UpdateOrganizationMemberRole(member.OrganizationId, req.UserId, req.Role)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func RemoveSelfOrganizationMember(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	err := checkManageableMember(member, userId)
+	if err == nil {
+		err = model.RemoveOrganizationMember(member.OrganizationId, userId)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetSelfOrganizationTokens(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	tokens, err := model.GetOrganizationTokens(member.OrganizationId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tokens,
+	})
+}
+
+// AddSelfOrganizationToken issues a token paid by the organization to one of its members,
+// the member owning the token defaults to the one issuing it
+func AddSelfOrganizationToken(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	token := model.Token{}
+	err := c.ShouldBindJSON(&token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateToken(c, token); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("参数错误：%s", err.Error()),
+		})
+		return
+	}
+	userId := token.UserId
+	if userId == 0 {
+		userId = member.UserId
+	}
+	owner, err := model.GetUserOrganizationMember(userId)
+	if err != nil || owner.OrganizationId != member.OrganizationId {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("user #%d is not in the organization", userId),
+		})
+		return
+	}
+	cleanToken := model.Token{
+		UserId:            userId,
+		OrganizationId:    member.OrganizationId,
+		Name:              token.Name,
+		Key:               model.NewTokenKey(),
+		CreatedTime:       helper.GetTimestamp(),
+		AccessedTime:      helper.GetTimestamp(),
+		ExpiredTime:       token.ExpiredTime,
+		RemainQuota:       token.RemainQuota,
+		UnlimitedQuota:    token.UnlimitedQuota,
+		Models:            token.Models,
+		Subnet:            token.Subnet,
+		Endpoints:         token.Endpoints,
+		Groups:            token.Groups,
+		StructuredOutput:  token.StructuredOutput,
+		MaxStreamDuration: token.MaxStreamDuration,
+		Priority:          token.Priority,
+		RequireSignature:  token.RequireSignature,
+	}
+	if err = cleanToken.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanToken,
+	})
+}
+
+func DeleteSelfOrganizationToken(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteOrganizationToken(member.OrganizationId, id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetSelfOrganizationUsage returns the usage of all the members of the organization, with
+// the parameters of GetUserUsage
+func GetSelfOrganizationUsage(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	getUsage(c, model.UsageRollupFilter{}, func(filter model.UsageRollupFilter, groupBy []string) ([]*model.UsageSummary, error) {
+		return model.GetOrganizationUsage(member.OrganizationId, filter, groupBy)
+	})
+}
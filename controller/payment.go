@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/payment"
+	"github.com/songquanpeng/one-api/model"
+)
+
+type createPaymentRequest struct {
+	Provider string  `json:"provider"`
+	Amount   float64 `json:"amount"` // in the currency of the user
+}
+
+// CreatePayment starts a top-up of the current user with a payment provider, the user pays
+// at the url returned
+func CreatePayment(c *gin.Context) {
+	ctx := c.Request.Context()
+	req := createPaymentRequest{}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	provider, err := payment.GetProvider(req.Provider)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	code := userCurrency(c)
+	if req.Amount < config.PaymentMinAmount {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("the amount must be at least %s", currency.Format(config.PaymentMinAmount, code)),
+		})
+		return
+	}
+	topUp := model.NewPayment(c.GetInt(ctxkey.Id), provider.Name(), req.Amount, code)
+	if err = topUp.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	session, err := provider.Create(ctx, &payment.Checkout{
+		TradeNo:     topUp.TradeNo,
+		Amount:      topUp.Amount,
+		Currency:    topUp.Currency,
+		Description: fmt.Sprintf("%s top-up", config.SystemName),
+		SuccessURL:  fmt.Sprintf("%s/topup?trade_no=%s", config.ServerAddress, topUp.TradeNo),
+		CancelURL:   fmt.Sprintf("%s/topup", config.ServerAddress),
+		CallbackURL: fmt.Sprintf("%s/api/payment/callback/%s", config.ServerAddress, provider.Name()),
+	})
+	if err != nil {
+		logger.Errorf(ctx, "failed to create payment %s: %s", topUp.TradeNo, err.Error())
+		_, _ = model.SettlePayment(ctx, topUp.TradeNo, "", false, 0, "")
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "failed to create the payment: " + err.Error(),
+		})
+		return
+	}
+	if err = topUp.UpdateProviderRef(session.Ref); err != nil {
+		logger.Errorf(ctx, "failed to save the provider reference of payment %s: %s", topUp.TradeNo, err.Error())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"trade_no": topUp.TradeNo,
+			"url":      session.URL,
+			"amount":   topUp.Amount,
+			"currency": topUp.Currency,
+			"quota":    topUp.Quota,
+		},
+	})
+}
+
+// PaymentCallback receives the outcome of a payment from its provider, the callback is
+// authenticated by its signature
+func PaymentCallback(c *gin.Context) {
+	ctx := c.Request.Context()
+	provider, err := payment.GetProvider(c.Param("provider"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	event, err := provider.Verify(c.Request.Header, body)
+	if err != nil {
+		logger.Warnf(ctx, "rejected %s payment callback: %s", provider.Name(), err.Error())
+		c.JSON(http.StatusBadRequest, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if event == nil {
+		// an event the provider sends which is not about a payment outcome
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+		})
+		return
+	}
+	topUp, err := model.GetPaymentByTradeNo(event.TradeNo)
+	if err == nil && topUp.Provider != provider.Name() {
+		err = fmt.Errorf("payment %s was not made with %s", event.TradeNo, provider.Name())
+	}
+	if err == nil {
+		_, err = model.SettlePayment(ctx, event.TradeNo, event.Ref, event.Status == payment.StatusPaid, event.Amount, event.Currency)
+	}
+	if err != nil {
+		logger.Errorf(ctx, "failed to settle %s payment %s: %s", provider.Name(), event.TradeNo, err.Error())
+		// the provider sends the callback again
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func getPayments(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	payments, err := model.GetPayments(userId, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    payments,
+	})
+}
+
+// GetAllPayments lists the payments of every user, or of user_id
+func GetAllPayments(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getPayments(c, userId)
+}
+
+func GetSelfPayments(c *gin.Context) {
+	getPayments(c, c.GetInt(ctxkey.Id))
+}
+
+// GetPaymentReceipt returns the receipt of a paid payment of the current user
+func GetPaymentReceipt(c *gin.Context) {
+	topUp, err := model.GetPaymentByTradeNo(c.Param("trade_no"))
+	if err == nil && (topUp.UserId != c.GetInt(ctxkey.Id) || topUp.Status != model.PaymentStatusPaid) {
+		err = fmt.Errorf("payment %s has no receipt", c.Param("trade_no"))
+	}
+	var user *model.User
+	if err == nil {
+		user, err = model.GetUserById(topUp.UserId, false)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"receipt_no":   topUp.TradeNo,
+			"issuer":       config.SystemName,
+			"username":     user.Username,
+			"email":        user.Email,
+			"paid_time":    topUp.PaidTime,
+			"provider":     topUp.Provider,
+			"provider_ref": topUp.ProviderRef,
+			"description":  fmt.Sprintf("%s top-up", config.SystemName),
+			"amount":       topUp.Amount,
+			"currency":     topUp.Currency,
+			"amount_text":  currency.Format(topUp.Amount, topUp.Currency),
+			"quota":        topUp.Quota,
+		},
+	})
+}
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllPlugins(c *gin.Context) {
+	plugins, err := model.GetAllPlugins()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plugins,
+	})
+}
+
+func GetPlugin(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plugin, err := model.GetPluginById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plugin,
+	})
+}
+
+func AddPlugin(c *gin.Context) {
+	plugin := model.Plugin{}
+	err := c.ShouldBindJSON(&plugin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = plugin.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	plugin.Id = 0
+	if plugin.Status == 0 {
+		plugin.Status = model.PluginStatusEnabled
+	}
+	if err = plugin.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPlugins, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    plugin,
+	})
+}
+
+func UpdatePlugin(c *gin.Context) {
+	plugin := model.Plugin{}
+	err := c.ShouldBindJSON(&plugin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPlugin, err := model.GetPluginById(plugin.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanPlugin.Status = plugin.Status
+	} else {
+		plugin.CreatedTime = cleanPlugin.CreatedTime
+		if plugin.Status == 0 {
+			plugin.Status = cleanPlugin.Status
+		}
+		cleanPlugin = &plugin
+	}
+	if err = cleanPlugin.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanPlugin.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPlugins, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPlugin,
+	})
+}
+
+func DeletePlugin(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	plugin, err := model.GetPluginById(id)
+	if err == nil {
+		err = plugin.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPlugins, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
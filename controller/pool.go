@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetPoolConfig returns the runtime pool overrides and the effective settings of every pool
+func GetPoolConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"overrides": client.GetPoolConfig(),
+			"pools":     client.GetPoolManager().GetStats(),
+		},
+	})
+}
+
+// UpdatePoolConfig replaces the pool overrides, affected pools are rebuilt without restart
+func UpdatePoolConfig(c *gin.Context) {
+	poolConfig := client.PoolConfig{}
+	err := c.ShouldBindJSON(&poolConfig)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	jsonBytes, _ := json.Marshal(poolConfig)
+	if _, err = client.ValidatePoolConfigJSONString(string(jsonBytes)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.UpdateOption("ConnectionPoolConfig", string(jsonBytes)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    client.GetPoolManager().GetStats(),
+	})
+}
+
+// GetPoolStats returns the connection reuse, setup latency and per-host connection counts of every pool
+func GetPoolStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    client.GetPoolManager().GetTraceStats(),
+	})
+}
+
+// GetDNSCacheStats returns the hit ratio and resolution latency of the upstream DNS cache
+func GetDNSCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    client.GetDNSCacheStats(),
+	})
+}
@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetPromoGrants lists the promo grants of the user_id of the query, of all the users when
+// it is not given
+func GetPromoGrants(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	grants, err := model.GetPromoGrants(userId, p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    grants,
+	})
+}
+
+// GetSelfPromotions returns the promo grants of the current user and their free allowances
+// this month
+func GetSelfPromotions(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userId := c.GetInt(ctxkey.Id)
+	grants, err := model.GetPromoGrants(userId, p*config.ItemsPerPage, config.ItemsPerPage)
+	var allowances []*model.FreeAllowanceStatus
+	if err == nil {
+		var group string
+		if group, err = model.CacheGetUserGroup(userId); err == nil {
+			allowances, err = model.GetFreeAllowances(userId, group)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, grant := range grants {
+		grant.Ip = ""
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"grants":          grants,
+			"free_allowances": allowances,
+		},
+	})
+}
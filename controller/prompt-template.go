@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllPromptTemplates(c *gin.Context) {
+	templates, err := model.GetAllPromptTemplates()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    templates,
+	})
+}
+
+func GetPromptTemplate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	template, err := model.GetPromptTemplateById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    template,
+	})
+}
+
+func AddPromptTemplate(c *gin.Context) {
+	template := model.PromptTemplate{}
+	err := c.ShouldBindJSON(&template)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = template.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	template.Id = 0
+	if template.Status == 0 {
+		template.Status = model.PromptTemplateStatusEnabled
+	}
+	if err = template.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPromptTemplates, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    template,
+	})
+}
+
+func UpdatePromptTemplate(c *gin.Context) {
+	template := model.PromptTemplate{}
+	err := c.ShouldBindJSON(&template)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanTemplate, err := model.GetPromptTemplateById(template.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanTemplate.Status = template.Status
+	} else {
+		template.CreatedTime = cleanTemplate.CreatedTime
+		if template.Status == 0 {
+			template.Status = cleanTemplate.Status
+		}
+		cleanTemplate = &template
+	}
+	if err = cleanTemplate.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanTemplate.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPromptTemplates, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanTemplate,
+	})
+}
+
+func DeletePromptTemplate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	template, err := model.GetPromptTemplateById(id)
+	if err == nil {
+		err = template.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPromptTemplates, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetPromptTemplateVersions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	versions, err := model.GetPromptTemplateVersions(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    versions,
+	})
+}
+
+type rollbackPromptTemplateRequest struct {
+	Version int `json:"version"`
+}
+
+// RollbackPromptTemplate makes the content of an earlier version the current one, as a new version
+func RollbackPromptTemplate(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var req rollbackPromptTemplateRequest
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	template, err := model.GetPromptTemplateById(id)
+	if err == nil {
+		err = template.Rollback(req.Version)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationPromptTemplates, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    template,
+	})
+}
@@ -0,0 +1,197 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/network"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetAllRateLimits(c *gin.Context) {
+	rateLimits, err := model.GetAllRateLimits()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rateLimits,
+	})
+}
+
+func GetRateLimit(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	rateLimit, err := model.GetRateLimitById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rateLimit,
+	})
+}
+
+func AddRateLimit(c *gin.Context) {
+	rateLimit := model.RateLimit{}
+	err := c.ShouldBindJSON(&rateLimit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = rateLimit.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	rateLimit.Id = 0
+	if rateLimit.Status == 0 {
+		rateLimit.Status = model.RateLimitStatusEnabled
+	}
+	if err = rateLimit.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRateLimits, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rateLimit,
+	})
+}
+
+func UpdateRateLimit(c *gin.Context) {
+	rateLimit := model.RateLimit{}
+	err := c.ShouldBindJSON(&rateLimit)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanRateLimit, err := model.GetRateLimitById(rateLimit.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanRateLimit.Status = rateLimit.Status
+	} else {
+		rateLimit.CreatedTime = cleanRateLimit.CreatedTime
+		if rateLimit.Status == 0 {
+			rateLimit.Status = cleanRateLimit.Status
+		}
+		cleanRateLimit = &rateLimit
+	}
+	if err = cleanRateLimit.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanRateLimit.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRateLimits, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanRateLimit,
+	})
+}
+
+func DeleteRateLimit(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	rateLimit, err := model.GetRateLimitById(id)
+	if err == nil {
+		err = rateLimit.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRateLimits, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetRateLimitIPPolicy(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    network.GetIPPolicy(),
+	})
+}
+
+func UpdateRateLimitIPPolicy(c *gin.Context) {
+	policy := network.IPPolicy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	jsonBytes, _ := json.Marshal(policy)
+	if _, err = network.ValidateIPPolicyJSONString(string(jsonBytes)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = model.UpdateOption("RateLimitIPPolicy", string(jsonBytes)); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    network.GetIPPolicy(),
+	})
+}
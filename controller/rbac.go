@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+type permissionMatrixRole struct {
+	Name        string   `json:"name"`
+	Id          int      `json:"id,omitempty"` // id of a custom role, 0 for the built-in roles
+	Permissions []string `json:"permissions"`
+}
+
+// GetPermissionMatrix returns the permissions of the admin APIs and those of every role,
+// built-in and custom
+func GetPermissionMatrix(c *gin.Context) {
+	customRoles, err := model.GetAllCustomRoles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	roles := []permissionMatrixRole{
+		{Name: "common", Permissions: model.BuiltinRolePermissions(model.RoleCommonUser)},
+		{Name: "admin", Permissions: model.BuiltinRolePermissions(model.RoleAdminUser)},
+		{Name: "root", Permissions: model.BuiltinRolePermissions(model.RoleRootUser)},
+	}
+	for _, role := range customRoles {
+		roles = append(roles, permissionMatrixRole{
+			Name:        role.Name,
+			Id:          role.Id,
+			Permissions: strings.Split(role.Permissions, ","),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"permissions": model.AllPermissions,
+			"roles":       roles,
+		},
+	})
+}
+
+// GetSelfPermissions returns the permissions of the current user
+func GetSelfPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetUserPermissions(c.GetInt(ctxkey.Id), c.GetInt(ctxkey.Role)),
+	})
+}
+
+func GetAllCustomRoles(c *gin.Context) {
+	roles, err := model.GetAllCustomRoles()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    roles,
+	})
+}
+
+func GetCustomRole(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	role, err := model.GetCustomRoleById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    role,
+	})
+}
+
+func AddCustomRole(c *gin.Context) {
+	role := model.CustomRole{}
+	err := c.ShouldBindJSON(&role)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = role.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	role.Id = 0
+	if err = role.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRBAC, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    role,
+	})
+}
+
+func UpdateCustomRole(c *gin.Context) {
+	role := model.CustomRole{}
+	err := c.ShouldBindJSON(&role)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanRole, err := model.GetCustomRoleById(role.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	role.CreatedTime = cleanRole.CreatedTime
+	if err = role.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = role.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRBAC, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    role,
+	})
+}
+
+func DeleteCustomRole(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	role, err := model.GetCustomRoleById(id)
+	if err == nil {
+		err = role.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRBAC, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func GetAllRoleBindings(c *gin.Context) {
+	bindings, err := model.GetAllRoleBindings()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    bindings,
+	})
+}
+
+func AddRoleBinding(c *gin.Context) {
+	binding := model.RoleBinding{}
+	err := c.ShouldBindJSON(&binding)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	binding.Id = 0
+	if err = binding.Validate(); err == nil {
+		err = binding.Insert()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRBAC, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    binding,
+	})
+}
+
+func DeleteRoleBinding(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if err := model.DeleteRoleBindingById(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationRBAC, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -115,6 +115,9 @@ func AddRedemption(c *gin.Context) {
 			Key:         key,
 			CreatedTime: helper.GetTimestamp(),
 			Quota:       redemption.Quota,
+			ExpiredTime: redemption.ExpiredTime,
+			MaxUses:     redemption.MaxUses,
+			Promotional: redemption.Promotional,
 		}
 		err = cleanRedemption.Insert()
 		if err != nil {
@@ -177,6 +180,9 @@ func UpdateRedemption(c *gin.Context) {
 		// If you add more fields, please also update redemption.Update()
 		cleanRedemption.Name = redemption.Name
 		cleanRedemption.Quota = redemption.Quota
+		cleanRedemption.ExpiredTime = redemption.ExpiredTime
+		cleanRedemption.MaxUses = redemption.MaxUses
+		cleanRedemption.Promotional = redemption.Promotional
 	}
 	err = cleanRedemption.Update()
 	if err != nil {
@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+)
+
+// A relay request with an Idempotency-Key header is relayed once for a token: its response
+// is kept for IdempotencyTTL and sent again to the requests retried with the same key,
+// which are neither relayed nor billed again.
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	// a request still in progress after this is considered lost and can be retried
+	idempotencyLockTTL         = 10 * time.Minute
+	maxIdempotentResponseSize  = 4 * 1024 * 1024
+	idempotencyMemoryPurgeTime = time.Minute
+)
+
+type idempotencyRecord struct {
+	Completed   bool   `json:"completed"`
+	BodyHash    string `json:"body_hash"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+type memoryIdempotencyRecord struct {
+	value     string
+	expiresAt time.Time
+}
+
+var (
+	memoryIdempotencyLock      sync.Mutex
+	memoryIdempotencyRecords   = make(map[string]memoryIdempotencyRecord)
+	memoryIdempotencyPurgeTime time.Time
+)
+
+// storeIdempotencyRecord saves a record, only if there is none for the key when create is set
+func storeIdempotencyRecord(ctx context.Context, key string, record *idempotencyRecord, ttl time.Duration, create bool) (bool, error) {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+	if common.RedisEnabled {
+		if create {
+			return common.RDB.SetNX(ctx, key, value, ttl).Result()
+		}
+		return true, common.RDB.Set(ctx, key, value, ttl).Err()
+	}
+	memoryIdempotencyLock.Lock()
+	defer memoryIdempotencyLock.Unlock()
+	now := time.Now()
+	if now.Sub(memoryIdempotencyPurgeTime) > idempotencyMemoryPurgeTime {
+		for k, r := range memoryIdempotencyRecords {
+			if now.After(r.expiresAt) {
+				delete(memoryIdempotencyRecords, k)
+			}
+		}
+		memoryIdempotencyPurgeTime = now
+	}
+	if r, ok := memoryIdempotencyRecords[key]; create && ok && now.Before(r.expiresAt) {
+		return false, nil
+	}
+	memoryIdempotencyRecords[key] = memoryIdempotencyRecord{value: string(value), expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func getIdempotencyRecord(ctx context.Context, key string) (*idempotencyRecord, error) {
+	var value string
+	if common.RedisEnabled {
+		var err error
+		if value, err = common.RDB.Get(ctx, key).Result(); err != nil {
+			return nil, err
+		}
+	} else {
+		memoryIdempotencyLock.Lock()
+		r, ok := memoryIdempotencyRecords[key]
+		memoryIdempotencyLock.Unlock()
+		if !ok || time.Now().After(r.expiresAt) {
+			return nil, errors.New("idempotency record expired")
+		}
+		value = r.value
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func deleteIdempotencyRecord(ctx context.Context, key string) {
+	if common.RedisEnabled {
+		if err := common.RDB.Del(ctx, key).Err(); err != nil {
+			logger.Errorf(ctx, "failed to delete idempotency record: %s", err.Error())
+		}
+		return
+	}
+	memoryIdempotencyLock.Lock()
+	delete(memoryIdempotencyRecords, key)
+	memoryIdempotencyLock.Unlock()
+}
+
+// idempotencyRecorder passes the response to the client and keeps a copy of it
+type idempotencyRecorder struct {
+	gin.ResponseWriter
+	body      []byte
+	truncated bool
+}
+
+func (w *idempotencyRecorder) record(data []byte) {
+	if w.truncated {
+		return
+	}
+	if len(w.body)+len(data) > maxIdempotentResponseSize {
+		w.truncated = true
+		w.body = nil
+		return
+	}
+	w.body = append(w.body, data...)
+}
+
+func (w *idempotencyRecorder) Write(data []byte) (int, error) {
+	w.record(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *idempotencyRecorder) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// startIdempotentRequest returns the function completing a request with an Idempotency-Key,
+// nil with done set when the request was answered, from the record of an earlier request
+func startIdempotentRequest(c *gin.Context) (finish func(), done bool) {
+	idempotencyKey := c.GetHeader(idempotencyKeyHeader)
+	if idempotencyKey == "" {
+		return nil, false
+	}
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		renderRelayError(c, openai.ErrorWrapper(fmt.Errorf("the %s header is longer than %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength), "invalid_idempotency_key", http.StatusBadRequest))
+		return nil, true
+	}
+	ctx := c.Request.Context()
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		renderRelayError(c, openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest))
+		return nil, true
+	}
+	keyHash := sha256.Sum256([]byte(idempotencyKey))
+	bodyHash := sha256.Sum256(requestBody)
+	key := fmt.Sprintf("idempotency:%d:%s", c.GetInt(ctxkey.TokenId), hex.EncodeToString(keyHash[:]))
+	record := &idempotencyRecord{BodyHash: hex.EncodeToString(bodyHash[:])}
+	created, err := storeIdempotencyRecord(ctx, key, record, idempotencyLockTTL, true)
+	if err != nil {
+		// relayed without the guarantee rather than failed
+		logger.Errorf(ctx, "failed to store idempotency record: %s", err.Error())
+		return nil, false
+	}
+	if !created {
+		replayIdempotentRequest(c, key, record.BodyHash)
+		return nil, true
+	}
+	recorder := &idempotencyRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	return func() {
+		c.Writer = recorder.ResponseWriter
+		// failed requests are not billed, they are relayed again when retried
+		if recorder.Status()/100 != 2 || recorder.truncated || c.Request.Context().Err() != nil {
+			deleteIdempotencyRecord(context.Background(), key)
+			return
+		}
+		record.Completed = true
+		record.StatusCode = recorder.Status()
+		record.ContentType = recorder.Header().Get("Content-Type")
+		record.Body = recorder.body
+		if _, err := storeIdempotencyRecord(context.Background(), key, record, time.Duration(config.IdempotencyTTL)*time.Second, false); err != nil {
+			logger.Errorf(ctx, "failed to store idempotency record: %s", err.Error())
+		}
+	}, false
+}
+
+func replayIdempotentRequest(c *gin.Context, key string, bodyHash string) {
+	record, err := getIdempotencyRecord(c.Request.Context(), key)
+	if err != nil {
+		renderRelayError(c, openai.ErrorWrapper(errors.New("the response of the request with this Idempotency-Key has expired, retry it"), "idempotency_key_expired", http.StatusConflict))
+		return
+	}
+	if record.BodyHash != bodyHash {
+		renderRelayError(c, openai.ErrorWrapper(errors.New("the Idempotency-Key was used with a different request body"), "idempotency_key_reused", http.StatusUnprocessableEntity))
+		return
+	}
+	if !record.Completed {
+		renderRelayError(c, openai.ErrorWrapper(errors.New("a request with this Idempotency-Key is in progress"), "idempotency_request_in_progress", http.StatusConflict))
+		return
+	}
+	c.Header(idempotencyReplayedHeader, "true")
+	c.Data(record.StatusCode, record.ContentType, record.Body)
+}
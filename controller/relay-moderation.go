@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+const contentFilterFinishReason = "content_filter"
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged bool `json:"flagged"`
+	} `json:"results"`
+}
+
+// moderationPolicy returns the policy moderating the request, nil if it is not moderated.
+// Chat completions and completions are moderated.
+func moderationPolicy(c *gin.Context, relayMode int) *dbmodel.ModerationPolicy {
+	if relayMode != relaymode.ChatCompletions && relayMode != relaymode.Completions {
+		return nil
+	}
+	return dbmodel.CacheGetModerationPolicy(c.GetString(ctxkey.Group))
+}
+
+func decodeJSONObject(data []byte) (map[string]any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// keeps the large integers, e.g. seed, as they are
+	decoder.UseNumber()
+	var object map[string]any
+	err := decoder.Decode(&object)
+	return object, err
+}
+
+func rewriteContent(content any, rewrite func(string) string) any {
+	switch content := content.(type) {
+	case string:
+		return rewrite(content)
+	case []any:
+		for i, item := range content {
+			if part, ok := item.(map[string]any); ok {
+				if text, ok := part["text"].(string); ok {
+					part["text"] = rewrite(text)
+				}
+				continue
+			}
+			content[i] = rewriteContent(item, rewrite)
+		}
+	}
+	return content
+}
+
+// rewriteTexts replaces every text of a request or a response with its rewrite: the contents
+// of the messages and the prompt of a request, the messages and the texts of the choices of
+// a response
+func rewriteTexts(object map[string]any, rewrite func(string) string) {
+	messages, _ := object["messages"].([]any)
+	for _, message := range messages {
+		if message, ok := message.(map[string]any); ok && message["content"] != nil {
+			message["content"] = rewriteContent(message["content"], rewrite)
+		}
+	}
+	if prompt, ok := object["prompt"]; ok {
+		object["prompt"] = rewriteContent(prompt, rewrite)
+	}
+	choices, _ := object["choices"].([]any)
+	for _, choice := range choices {
+		choice, ok := choice.(map[string]any)
+		if !ok {
+			continue
+		}
+		if message, ok := choice["message"].(map[string]any); ok && message["content"] != nil {
+			message["content"] = rewriteContent(message["content"], rewrite)
+		}
+		if text, ok := choice["text"].(string); ok {
+			choice["text"] = rewrite(text)
+		}
+	}
+}
+
+func collectTexts(object map[string]any) []string {
+	var texts []string
+	rewriteTexts(object, func(text string) string {
+		if text != "" {
+			texts = append(texts, text)
+		}
+		return text
+	})
+	return texts
+}
+
+// classifyModeration asks the classifier model of the policy, on a channel of the group
+// serving it, whether one of the texts is flagged
+func classifyModeration(c *gin.Context, policy *dbmodel.ModerationPolicy, texts []string) (bool, error) {
+	group := c.GetString(ctxkey.Group)
+	channel, err := dbmodel.CacheGetRandomSatisfiedChannel(c.GetInt(ctxkey.TenantId), group, policy.ClassifierModel, false)
+	if err != nil {
+		return false, err
+	}
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = channeltype.ChannelBaseURLs[channel.Type]
+	}
+	modelName := policy.ClassifierModel
+	if mappedModel, ok := channel.GetModelMapping()[modelName]; ok && mappedModel != "" {
+		modelName = mappedModel
+	}
+	requestBody, err := json.Marshal(map[string]any{"model": modelName, "input": texts})
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(config.ModerationTimeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/v1/moderations", bytes.NewReader(requestBody))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+channel.Key)
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	var moderation moderationResponse
+	if err = json.NewDecoder(resp.Body).Decode(&moderation); err != nil {
+		return false, err
+	}
+	for _, result := range moderation.Results {
+		if result.Flagged {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// moderateTexts returns the action of the policy on the texts, empty if there is none. The
+// texts whose redaction is the action are classified once redacted. A classifier which cannot
+// be reached lets the texts through.
+func moderateTexts(c *gin.Context, policy *dbmodel.ModerationPolicy, object map[string]any) string {
+	action := ""
+	for _, text := range collectTexts(object) {
+		action = dbmodel.StrongerModerationAction(action, policy.Match(text))
+	}
+	if action == dbmodel.ModerationActionRedact {
+		rewriteTexts(object, policy.Redact)
+	}
+	if policy.ClassifierModel == "" || action == dbmodel.ModerationActionBlock {
+		return action
+	}
+	texts := collectTexts(object)
+	if len(texts) == 0 {
+		return action
+	}
+	flagged, err := classifyModeration(c, policy, texts)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "moderation classifier %s failed, letting the content through: %s", policy.ClassifierModel, err.Error())
+		return action
+	}
+	if flagged {
+		action = dbmodel.StrongerModerationAction(action, policy.ClassifierAction)
+	}
+	return action
+}
+
+func recordModeration(c *gin.Context, stage string, action string) string {
+	monitor.GetMetricsCollector().RecordModeration(c.GetString(ctxkey.Group), stage, action)
+	logger.Infof(c.Request.Context(), "moderation policy outcome on the %s: %s", stage, action)
+	return stage + ":" + action
+}
+
+// moderateRequest applies the policy to the prompt of the request. A blocked request is
+// recorded and returns an error, a redacted one is relayed redacted.
+func moderateRequest(c *gin.Context, policy *dbmodel.ModerationPolicy) *model.ErrorWithStatusCode {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil
+	}
+	request, err := decodeJSONObject(requestBody)
+	if err != nil {
+		return nil
+	}
+	action := moderateTexts(c, policy, request)
+	if action == "" {
+		return nil
+	}
+	outcome := recordModeration(c, "request", action)
+	c.Set(ctxkey.Moderation, outcome)
+	switch action {
+	case dbmodel.ModerationActionBlock:
+		dbmodel.RecordErrorLog(c.Request.Context(), &dbmodel.Log{
+			UserId:     c.GetInt(ctxkey.Id),
+			ChannelId:  c.GetInt(ctxkey.ChannelId),
+			ModelName:  c.GetString(ctxkey.OriginalModel),
+			TokenName:  c.GetString(ctxkey.TokenName),
+			Content:    "内容审核：" + outcome,
+			StatusCode: http.StatusBadRequest,
+			Moderation: outcome,
+		})
+		return openai.ErrorWrapper(errors.New("the request was blocked by the moderation policy"), "content_blocked", http.StatusBadRequest)
+	case dbmodel.ModerationActionRedact:
+		redactedBody, err := json.Marshal(request)
+		if err != nil {
+			return openai.ErrorWrapper(err, "moderation_redact_failed", http.StatusInternalServerError)
+		}
+		c.Set(ctxkey.KeyRequestBody, redactedBody)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(redactedBody))
+	}
+	return nil
+}
+
+// moderatesResponse tells whether the response of the request is moderated, streamed
+// responses are not
+func moderatesResponse(c *gin.Context, policy *dbmodel.ModerationPolicy) bool {
+	if !policy.ModerateResponses {
+		return false
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return false
+	}
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	return json.Unmarshal(requestBody, &request) == nil && !request.Stream
+}
+
+// moderateResponse applies the policy to the recorded response then sends it. The choices of a
+// blocked response are emptied and finish with content_filter.
+func moderateResponse(c *gin.Context, policy *dbmodel.ModerationPolicy, recorder *outputRecorder) {
+	c.Writer = recorder.ResponseWriter
+	defer recorder.flush()
+	if recorder.status != http.StatusOK {
+		return
+	}
+	response, err := decodeJSONObject(recorder.body.Bytes())
+	if err != nil {
+		return
+	}
+	action := moderateTexts(c, policy, response)
+	if action == "" {
+		return
+	}
+	outcome := recordModeration(c, "response", action)
+	dbmodel.RecordModerationLog(c.Request.Context(), &dbmodel.Log{
+		UserId:     c.GetInt(ctxkey.Id),
+		ChannelId:  c.GetInt(ctxkey.ChannelId),
+		ModelName:  c.GetString(ctxkey.OriginalModel),
+		TokenName:  c.GetString(ctxkey.TokenName),
+		Content:    "内容审核：" + outcome,
+		Moderation: outcome,
+	})
+	if action == dbmodel.ModerationActionFlag {
+		return
+	}
+	if action == dbmodel.ModerationActionBlock {
+		rewriteTexts(response, func(string) string {
+			return ""
+		})
+		choices, _ := response["choices"].([]any)
+		for _, choice := range choices {
+			if choice, ok := choice.(map[string]any); ok {
+				choice["finish_reason"] = contentFilterFinishReason
+			}
+		}
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "failed to rewrite the moderated response: %s", err.Error())
+		return
+	}
+	recorder.body.Reset()
+	recorder.body.Write(body)
+	recorder.header.Del("Content-Length")
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/plugin"
+)
+
+// rejectionError is the error answering a request rejected by a plugin
+func rejectionError(rejection *plugin.Rejection) *model.ErrorWithStatusCode {
+	statusCode := rejection.StatusCode
+	if statusCode < http.StatusBadRequest || statusCode > 599 {
+		statusCode = http.StatusBadRequest
+	}
+	return openai.ErrorWrapper(errors.New(rejection.Message), "plugin_rejected", statusCode)
+}
+
+// runPreRequestPlugins runs the pre_request plugins of the group on the request, they may
+// change it through the request global or reject it. Plugins failing are skipped.
+func runPreRequestPlugins(c *gin.Context) *model.ErrorWithStatusCode {
+	plugins := dbmodel.CacheGetPlugins(dbmodel.PluginHookPreRequest, c.GetString(ctxkey.Group))
+	if len(plugins) == 0 {
+		return nil
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return nil
+	}
+	request, err := decodeJSONObject(requestBody)
+	if err != nil {
+		// multipart requests, as audio transcriptions, are not given to the plugins
+		return nil
+	}
+	changed := false
+	for _, p := range plugins {
+		_, values, err := middleware.RunPlugin(c, p, map[string]any{"request": request, "context": middleware.PluginContext(c)}, "request")
+		var rejection *plugin.Rejection
+		if errors.As(err, &rejection) {
+			logger.Infof(c.Request.Context(), "request rejected by plugin %s: %s", p.Name, rejection.Message)
+			return rejectionError(rejection)
+		}
+		if err != nil {
+			continue
+		}
+		if newRequest, ok := values["request"].(map[string]any); ok {
+			request = newRequest
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	requestBody, err = json.Marshal(request)
+	if err != nil {
+		return openai.ErrorWrapper(err, "marshal_request_body_failed", http.StatusInternalServerError)
+	}
+	c.Set(ctxkey.KeyRequestBody, requestBody)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	return nil
+}
+
+// startPostResponsePlugins makes the response go through the post_response plugins of the
+// group and returns the function sending it once the request is done, streamed responses
+// are sent as they are
+func startPostResponsePlugins(c *gin.Context) func() {
+	plugins := dbmodel.CacheGetPlugins(dbmodel.PluginHookPostResponse, c.GetString(ctxkey.Group))
+	if len(plugins) == 0 {
+		return func() {}
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return func() {}
+	}
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	_ = json.Unmarshal(requestBody, &request)
+	if request.Stream {
+		return func() {}
+	}
+	recorder := newOutputRecorder(c.Writer)
+	c.Writer = recorder
+	return func() {
+		runPostResponsePlugins(c, plugins, recorder)
+	}
+}
+
+// runPostResponsePlugins runs the plugins on the recorded response then sends it, a plugin
+// rejecting the response replaces it with an error
+func runPostResponsePlugins(c *gin.Context, plugins []*dbmodel.Plugin, recorder *outputRecorder) {
+	c.Writer = recorder.ResponseWriter
+	defer recorder.flush()
+	if recorder.status != http.StatusOK {
+		return
+	}
+	response, err := decodeJSONObject(recorder.body.Bytes())
+	if err != nil {
+		return
+	}
+	changed := false
+	for _, p := range plugins {
+		_, values, err := middleware.RunPlugin(c, p, map[string]any{"response": response, "context": middleware.PluginContext(c)}, "response")
+		var rejection *plugin.Rejection
+		if errors.As(err, &rejection) {
+			logger.Infof(c.Request.Context(), "response rejected by plugin %s: %s", p.Name, rejection.Message)
+			bizErr := rejectionError(rejection)
+			requestId := c.GetString(helper.RequestIdKey)
+			bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
+			body, _ := json.Marshal(gin.H{"error": bizErr.Error, "request_id": requestId})
+			recorder.status = bizErr.StatusCode
+			recorder.body.Reset()
+			recorder.body.Write(body)
+			recorder.header.Del("Content-Length")
+			return
+		}
+		if err != nil {
+			continue
+		}
+		if newResponse, ok := values["response"].(map[string]any); ok {
+			response = newResponse
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "failed to rewrite the response of the plugins: %s", err.Error())
+		return
+	}
+	recorder.body.Reset()
+	recorder.body.Write(body)
+	recorder.header.Del("Content-Length")
+}
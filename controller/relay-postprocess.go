@@ -0,0 +1,181 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/postprocess"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// postProcessing returns the post-processors of the response of a completion with the
+// config of the group, none for the other requests
+func postProcessing(c *gin.Context, relayMode int) ([]string, postprocess.Config) {
+	if relayMode != relaymode.ChatCompletions && relayMode != relaymode.Completions {
+		return nil, postprocess.Config{}
+	}
+	return postprocess.Resolve(c.GetString(ctxkey.PostProcessors), c.GetString(ctxkey.Group))
+}
+
+// startPostProcessing makes the response go through the post-processors and returns the
+// function sending what is left of it once the request is done
+func startPostProcessing(c *gin.Context, processors []string, cfg postprocess.Config) func() {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return func() {}
+	}
+	var request struct {
+		Stream bool `json:"stream"`
+	}
+	_ = json.Unmarshal(requestBody, &request)
+	if request.Stream {
+		writer := &postProcessWriter{ResponseWriter: c.Writer, processors: processors, cfg: cfg, choices: make(map[int64]postprocess.Processor)}
+		c.Writer = writer
+		return func() {
+			c.Writer = writer.ResponseWriter
+			writer.finish()
+		}
+	}
+	recorder := newOutputRecorder(c.Writer)
+	c.Writer = recorder
+	return func() {
+		postProcessResponse(c, processors, cfg, recorder)
+	}
+}
+
+// processChoices runs the processors on the content of the choices of a completion or of
+// a chunk of a streamed one, choice gives the processors of the content of a choice
+func processChoices(object map[string]any, choice func(index int64) postprocess.Processor) {
+	choices, _ := object["choices"].([]any)
+	for _, item := range choices {
+		item, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		var index int64
+		if number, ok := item["index"].(json.Number); ok {
+			index, _ = number.Int64()
+		}
+		processor := choice(index)
+		finished := item["finish_reason"] != nil && item["finish_reason"] != ""
+		process := func(holder map[string]any, key string) {
+			content, ok := holder[key].(string)
+			output := processor.Process(content)
+			if finished {
+				output += processor.Flush()
+			}
+			if ok || output != "" {
+				holder[key] = output
+			}
+		}
+		switch {
+		case item["message"] != nil:
+			if message, ok := item["message"].(map[string]any); ok {
+				process(message, "content")
+			}
+		case item["delta"] != nil:
+			if delta, ok := item["delta"].(map[string]any); ok {
+				process(delta, "content")
+			}
+		default:
+			process(item, "text")
+		}
+	}
+}
+
+// postProcessResponse runs the processors on the recorded response then sends it
+func postProcessResponse(c *gin.Context, processors []string, cfg postprocess.Config, recorder *outputRecorder) {
+	c.Writer = recorder.ResponseWriter
+	defer recorder.flush()
+	if recorder.status != http.StatusOK {
+		return
+	}
+	response, err := decodeJSONObject(recorder.body.Bytes())
+	if err != nil {
+		return
+	}
+	processChoices(response, func(int64) postprocess.Processor {
+		return postprocess.New(processors, cfg)
+	})
+	body, err := json.Marshal(response)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "failed to rewrite the post-processed response: %s", err.Error())
+		return
+	}
+	recorder.body.Reset()
+	recorder.body.Write(body)
+	recorder.header.Del("Content-Length")
+}
+
+var eventSeparator = []byte("\n\n")
+
+// postProcessWriter runs the processors on the chunks of a streamed completion as they are
+// written, the processors of a choice are flushed by the chunk finishing it
+type postProcessWriter struct {
+	gin.ResponseWriter
+	processors []string
+	cfg        postprocess.Config
+	choices    map[int64]postprocess.Processor
+	pending    []byte // the event being written
+}
+
+func (w *postProcessWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.Index(w.pending, eventSeparator)
+		if end < 0 {
+			return len(data), nil
+		}
+		event := w.processEvent(w.pending[:end])
+		w.pending = w.pending[end+len(eventSeparator):]
+		if _, err := w.ResponseWriter.Write(append(event, eventSeparator...)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *postProcessWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *postProcessWriter) processEvent(event []byte) []byte {
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(event), []byte("data:"))
+	if !ok {
+		return event
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("[DONE]")) {
+		return event
+	}
+	chunk, err := decodeJSONObject(data)
+	if err != nil {
+		return event
+	}
+	processChoices(chunk, func(index int64) postprocess.Processor {
+		processor, ok := w.choices[index]
+		if !ok {
+			processor = postprocess.New(w.processors, w.cfg)
+			w.choices[index] = processor
+		}
+		return processor
+	})
+	processed, err := json.Marshal(chunk)
+	if err != nil {
+		return event
+	}
+	return append([]byte("data: "), processed...)
+}
+
+// finish sends the incomplete event left, if any
+func (w *postProcessWriter) finish() {
+	if len(w.pending) > 0 {
+		_, _ = w.ResponseWriter.Write(w.pending)
+		w.pending = nil
+	}
+}
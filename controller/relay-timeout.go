@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+const (
+	requestTimeoutHeader = "X-Request-Timeout"
+	requestTimeoutField  = "request_timeout"
+)
+
+func parseRequestTimeout(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, errors.New("the request timeout must be a positive number of seconds")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// requestTimeout returns how long the request may take, zero if it is not bounded: the
+// X-Request-Timeout header or the request_timeout field of the body, in seconds, and for a
+// stream the max stream duration of the token if it is shorter. The field is removed from
+// the body relayed.
+func requestTimeout(c *gin.Context) (time.Duration, *model.ErrorWithStatusCode) {
+	var timeout time.Duration
+	var err error
+	if header := c.GetHeader(requestTimeoutHeader); header != "" {
+		if timeout, err = parseRequestTimeout(header); err != nil {
+			return 0, openai.ErrorWrapper(err, "invalid_request_timeout", http.StatusBadRequest)
+		}
+	}
+	maxStreamDuration := time.Duration(c.GetInt64(ctxkey.MaxStreamDuration)) * time.Second
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil || (maxStreamDuration == 0 && !bytes.Contains(requestBody, []byte(`"`+requestTimeoutField+`"`))) {
+		return timeout, nil
+	}
+	request, err := decodeJSONObject(requestBody)
+	if err != nil {
+		return timeout, nil
+	}
+	if field, ok := request[requestTimeoutField]; ok {
+		fieldTimeout, err := parseRequestTimeout(string(asJSONNumber(field)))
+		if err != nil {
+			return 0, openai.ErrorWrapper(err, "invalid_request_timeout", http.StatusBadRequest)
+		}
+		if timeout == 0 {
+			timeout = fieldTimeout
+		}
+		delete(request, requestTimeoutField)
+		if requestBody, err = json.Marshal(request); err != nil {
+			return 0, openai.ErrorWrapper(err, "marshal_request_body_failed", http.StatusInternalServerError)
+		}
+		c.Set(ctxkey.KeyRequestBody, requestBody)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	}
+	if stream, _ := request["stream"].(bool); stream && maxStreamDuration > 0 && (timeout == 0 || maxStreamDuration < timeout) {
+		timeout = maxStreamDuration
+	}
+	return timeout, nil
+}
+
+func asJSONNumber(value any) json.Number {
+	number, _ := value.(json.Number)
+	return number
+}
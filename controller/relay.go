@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
@@ -13,9 +14,12 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/priority"
+	"github.com/songquanpeng/one-api/common/trace"
 	"github.com/songquanpeng/one-api/middleware"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/controller"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
@@ -26,6 +30,8 @@ import (
 func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 	var err *model.ErrorWithStatusCode
 	switch relayMode {
+	case relaymode.ImagesEdits:
+		fallthrough
 	case relaymode.ImagesGenerations:
 		err = controller.RelayImageHelper(c, relayMode)
 	case relaymode.AudioSpeech:
@@ -36,54 +42,150 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 		err = controller.RelayAudioHelper(c, relayMode)
 	case relaymode.Proxy:
 		err = controller.RelayProxyHelper(c, relayMode)
+	case relaymode.Responses:
+		err = controller.RelayResponsesHelper(c)
+	case relaymode.ChatCompletions:
+		if schema := structuredOutputSchema(c); schema != nil {
+			err = relayStructuredOutput(c, schema)
+		} else {
+			err = controller.RelayTextHelper(c)
+		}
 	default:
 		err = controller.RelayTextHelper(c)
 	}
 	return err
 }
 
+// renderRelayError sends an error which ends the request before it is relayed
+func renderRelayError(c *gin.Context, bizErr *model.ErrorWithStatusCode) {
+	requestId := c.GetString(helper.RequestIdKey)
+	bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
+	c.JSON(bizErr.StatusCode, gin.H{
+		"error":      bizErr.Error,
+		"request_id": requestId,
+	})
+}
+
 func Relay(c *gin.Context) {
+	timeout, bizErr := requestTimeout(c)
+	if bizErr != nil {
+		renderRelayError(c, bizErr)
+		return
+	}
+	if timeout > 0 {
+		// cancels the upstream call, the usage streamed so far is billed
+		timeoutCtx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(timeoutCtx)
+	}
+	finishIdempotentRequest, done := startIdempotentRequest(c)
+	if done {
+		return
+	}
+	if finishIdempotentRequest != nil {
+		defer finishIdempotentRequest()
+	}
 	ctx := c.Request.Context()
 	relayMode := relaymode.GetByPath(c.Request.URL.Path)
 	if config.DebugEnabled {
 		requestBody, _ := common.GetRequestBody(c)
 		logger.Debugf(ctx, "request body: %s", string(requestBody))
 	}
+	if bizErr := runPreRequestPlugins(c); bizErr != nil {
+		renderRelayError(c, bizErr)
+		return
+	}
+	if policy := moderationPolicy(c, relayMode); policy != nil {
+		if bizErr := moderateRequest(c, policy); bizErr != nil {
+			renderRelayError(c, bizErr)
+			return
+		}
+		if moderatesResponse(c, policy) {
+			recorder := newOutputRecorder(c.Writer)
+			c.Writer = recorder
+			defer moderateResponse(c, policy, recorder)
+		}
+	}
+	if processors, cfg := postProcessing(c, relayMode); len(processors) > 0 {
+		defer startPostProcessing(c, processors, cfg)()
+	}
+	defer startPostResponsePlugins(c)()
+	startTime := time.Now()
+	if mirror := sampleTrafficMirror(c, relayMode); mirror != nil {
+		defer func() {
+			if !controller.RejectedBeforeRelay(bizErr) {
+				mirrorRequest(c, mirror, startTime, bizErr != nil)
+			}
+		}()
+	}
+	class := priority.Of(c.GetString(ctxkey.Priority))
+	priority.DepositRetryBudget(class)
+	defer func() {
+		monitor.GetMetricsCollector().RecordTenantRequest(c.GetInt(ctxkey.TenantId), c.GetString(ctxkey.OriginalModel), bizErr == nil)
+		monitor.GetMetricsCollector().RecordPriorityRequest(class, time.Since(startTime), bizErr == nil)
+	}()
 	channelId := c.GetInt(ctxkey.ChannelId)
 	userId := c.GetInt(ctxkey.Id)
-	bizErr := relayHelper(c, relayMode)
+	bizErr = relayHelper(c, relayMode)
+	recordChannelRequest(c, startTime, bizErr)
 	if bizErr == nil {
 		monitor.Emit(channelId, true)
+		recordExperimentRequest(c, startTime, false)
+		return
+	}
+	if controller.RejectedBeforeRelay(bizErr) {
+		// no channel is at fault, the request was not relayed
+		renderRelayError(c, bizErr)
 		return
 	}
 	lastFailedChannelId := channelId
 	channelName := c.GetString(ctxkey.ChannelName)
 	group := c.GetString(ctxkey.Group)
+	tenantId := c.GetInt(ctxkey.TenantId)
 	originalModel := c.GetString(ctxkey.OriginalModel)
 	// Clone bizErr to avoid race condition
 	errCopy := *bizErr
 	go processChannelRelayError(ctx, userId, channelId, channelName, errCopy)
+	traceRelayError(ctx, channelId, bizErr)
 	requestId := c.GetString(helper.RequestIdKey)
 	retryTimes := config.RetryTimes
 	if !shouldRetry(c, bizErr.StatusCode) {
 		logger.Errorf(ctx, "relay error happen, status code is %d, won't retry in this case", bizErr.StatusCode)
+		trace.Record(ctx, trace.StageRetry, channelId, fmt.Sprintf("won't retry after status code %d", bizErr.StatusCode), nil)
 		retryTimes = 0
 	}
 	for i := retryTimes; i > 0; i-- {
-		channel, err := dbmodel.CacheGetRandomSatisfiedChannel(group, originalModel, i != retryTimes)
+		var channel *dbmodel.Channel
+		var err error
+		if acceptType := channeltype.ModeFilter(relayMode); acceptType != nil {
+			channel, err = dbmodel.CacheGetRandomSatisfiedChannelOfType(tenantId, group, originalModel, i != retryTimes, acceptType)
+		} else {
+			channel, err = dbmodel.CacheGetRandomSatisfiedChannel(tenantId, group, originalModel, i != retryTimes)
+		}
 		if err != nil {
 			logger.Errorf(ctx, "CacheGetRandomSatisfiedChannel failed: %+v", err)
+			trace.Record(ctx, trace.StageRetry, 0, "no channel to retry with: "+err.Error(), nil)
 			break
 		}
 		logger.Infof(ctx, "using channel #%d to retry (remain times %d)", channel.Id, i)
 		if channel.Id == lastFailedChannelId {
+			trace.Record(ctx, trace.StageRetry, channel.Id, fmt.Sprintf("skipped channel #%d, which just failed", channel.Id), nil)
 			continue
 		}
+		if !priority.WithdrawRetryBudget(class) {
+			logger.Warnf(ctx, "the retry budget of the %s requests is used up, won't retry", class)
+			trace.Record(ctx, trace.StageRetry, 0, fmt.Sprintf("the retry budget of the %s requests is used up", class), nil)
+			break
+		}
+		trace.Record(ctx, trace.StageRetry, channel.Id, fmt.Sprintf("retrying on channel #%d %s", channel.Id, channel.Name), map[string]any{"remain": i})
 		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		attemptTime := time.Now()
 		bizErr = relayHelper(c, relayMode)
+		recordChannelRequest(c, attemptTime, bizErr)
 		if bizErr == nil {
+			recordExperimentRequest(c, startTime, false)
 			return
 		}
 		channelId := c.GetInt(ctxkey.ChannelId)
@@ -92,8 +194,20 @@ func Relay(c *gin.Context) {
 		// Clone bizErr to avoid race condition
 		errCopy := *bizErr
 		go processChannelRelayError(ctx, userId, channelId, channelName, errCopy)
+		traceRelayError(ctx, channelId, bizErr)
 	}
 	if bizErr != nil {
+		dbmodel.RecordUsageError(userId, originalModel, lastFailedChannelId, c.GetString(ctxkey.TokenName))
+		recordExperimentRequest(c, startTime, true)
+		dbmodel.RecordErrorLog(ctx, &dbmodel.Log{
+			UserId:      userId,
+			ChannelId:   lastFailedChannelId,
+			ModelName:   originalModel,
+			TokenName:   c.GetString(ctxkey.TokenName),
+			Content:     bizErr.Error.Message,
+			StatusCode:  bizErr.StatusCode,
+			ElapsedTime: time.Since(startTime).Milliseconds(),
+		})
 		if bizErr.StatusCode == http.StatusTooManyRequests {
 			bizErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
@@ -101,12 +215,28 @@ func Relay(c *gin.Context) {
 		// BUG: bizErr is in race condition
 		bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
 		c.JSON(bizErr.StatusCode, gin.H{
-			"error": bizErr.Error,
+			"error":      bizErr.Error,
+			"request_id": requestId,
 		})
 	}
 }
 
+// recordChannelRequest records an attempt on the channel of the context in the channel
+// metrics, labeled with the tags of the channel, and in the canaries
+func recordChannelRequest(c *gin.Context, startTime time.Time, bizErr *model.ErrorWithStatusCode) {
+	if controller.RejectedBeforeRelay(bizErr) {
+		return
+	}
+	monitor.GetMetricsCollector().RecordChannelRequest(c.GetInt(ctxkey.ChannelId), c.GetString(ctxkey.ChannelName),
+		c.GetString(ctxkey.ChannelTags), c.GetString(ctxkey.OriginalModel), time.Since(startTime), bizErr == nil)
+	dbmodel.RecordCanaryOutcome(c.GetInt(ctxkey.ChannelId), c.GetString(ctxkey.OriginalModel), time.Since(startTime), bizErr != nil)
+}
+
 func shouldRetry(c *gin.Context, statusCode int) bool {
+	if c.Request.Context().Err() != nil {
+		// timed out, or the client is gone
+		return false
+	}
 	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
 		return false
 	}
@@ -125,6 +255,21 @@ func shouldRetry(c *gin.Context, statusCode int) bool {
 	return true
 }
 
+// traceRelayError records an attempt which failed, and whether its channel is disabled for it
+func traceRelayError(ctx context.Context, channelId int, bizErr *model.ErrorWithStatusCode) {
+	if trace.FromContext(ctx) == nil {
+		return
+	}
+	trace.Record(ctx, trace.StageUpstream, channelId, fmt.Sprintf("relay failed with status code %d", bizErr.StatusCode), map[string]any{
+		"error": bizErr.Message,
+		"type":  bizErr.Type,
+		"code":  bizErr.Code,
+	})
+	if monitor.ShouldDisableChannel(&bizErr.Error, bizErr.StatusCode) {
+		trace.Record(ctx, trace.StageBreaker, channelId, fmt.Sprintf("channel #%d is disabled for this error", channelId), nil)
+	}
+}
+
 func processChannelRelayError(ctx context.Context, userId int, channelId int, channelName string, err model.ErrorWithStatusCode) {
 	logger.Errorf(ctx, "relay error (channel id %d, user id: %d): %s", channelId, userId, err.Message)
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
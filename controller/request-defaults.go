@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetSelfRequestDefaults returns the defaults applied to the requests of every token of the user
+func GetSelfRequestDefaults(c *gin.Context) {
+	getRequestDefaults(c, c.GetInt(ctxkey.Id), 0)
+}
+
+func UpdateSelfRequestDefaults(c *gin.Context) {
+	updateRequestDefaults(c, c.GetInt(ctxkey.Id), 0)
+}
+
+// GetTokenRequestDefaults returns the defaults of a token, which override those of the user
+func GetTokenRequestDefaults(c *gin.Context) {
+	tokenId, ok := requestDefaultsTokenId(c)
+	if !ok {
+		return
+	}
+	getRequestDefaults(c, c.GetInt(ctxkey.Id), tokenId)
+}
+
+func UpdateTokenRequestDefaults(c *gin.Context) {
+	tokenId, ok := requestDefaultsTokenId(c)
+	if !ok {
+		return
+	}
+	updateRequestDefaults(c, c.GetInt(ctxkey.Id), tokenId)
+}
+
+func requestDefaultsTokenId(c *gin.Context) (int, bool) {
+	tokenId, err := strconv.Atoi(c.Param("id"))
+	if err == nil {
+		_, err = model.GetTokenByIds(tokenId, c.GetInt(ctxkey.Id))
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return 0, false
+	}
+	return tokenId, true
+}
+
+func getRequestDefaults(c *gin.Context, userId int, tokenId int) {
+	defaults, err := model.GetRequestDefaults(userId, tokenId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    defaults,
+	})
+}
+
+// updateRequestDefaults replaces the defaults, the parameters left out are unset
+func updateRequestDefaults(c *gin.Context, userId int, tokenId int) {
+	defaults := model.RequestDefaults{}
+	if err := c.ShouldBindJSON(&defaults); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err := defaults.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "参数错误：" + err.Error(),
+		})
+		return
+	}
+	defaults.UserId = userId
+	defaults.TokenId = tokenId
+	if err := model.SaveRequestDefaults(&defaults); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    defaults,
+	})
+}
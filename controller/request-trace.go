@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetRequestTrace returns the decision timeline of a past request, with its logs and debug
+// captures, to investigate what the relay did for it
+func GetRequestTrace(c *gin.Context) {
+	requestId := c.Param("request_id")
+	tenantId := adminTenantId(c)
+	traces, err := model.GetRequestTraces(requestId, tenantId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	logs, err := model.GetLogsByRequestId(requestId, tenantId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(traces) == 0 && len(logs) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "no trace nor log for this request id",
+		})
+		return
+	}
+	tracesData := make([]gin.H, 0, len(traces))
+	for _, requestTrace := range traces {
+		tracesData = append(tracesData, gin.H{
+			"id":           requestTrace.Id,
+			"created_at":   requestTrace.CreatedAt,
+			"user_id":      requestTrace.UserId,
+			"token_id":     requestTrace.TokenId,
+			"tenant_id":    requestTrace.TenantId,
+			"method":       requestTrace.Method,
+			"path":         requestTrace.Path,
+			"model_name":   requestTrace.ModelName,
+			"status_code":  requestTrace.StatusCode,
+			"elapsed_time": requestTrace.ElapsedTime,
+			"dropped":      requestTrace.Dropped,
+			"events":       requestTrace.GetEvents(),
+		})
+	}
+	// the request is one of the tenant of the admin, its traces or logs were found
+	captures := make([]gin.H, 0)
+	if found, err := model.GetDebugCapturesByRequestId(requestId); err == nil {
+		for _, capture := range found {
+			captures = append(captures, gin.H{
+				"id":           capture.Id,
+				"channel_id":   capture.ChannelId,
+				"status_code":  capture.StatusCode,
+				"elapsed_time": capture.ElapsedTime,
+				"truncated":    capture.Truncated,
+			})
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"request_id": requestId,
+			"traces":     tracesData,
+			"logs":       logs,
+			"captures":   captures,
+		},
+	})
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func responseNotFound(c *gin.Context, id string) {
+	c.JSON(http.StatusNotFound, gin.H{
+		"error": model.Error{
+			Message: fmt.Sprintf("No response found with id '%s'.", id),
+			Type:    "invalid_request_error",
+			Param:   "response_id",
+		},
+	})
+}
+
+// storedResponseObject returns the stored response object with the current status,
+// the status changes when a background response is cancelled or lost
+func storedResponseObject(stored *dbmodel.StoredResponse) map[string]any {
+	object := map[string]any{}
+	_ = json.Unmarshal([]byte(stored.Response), &object)
+	object["status"] = stored.Status
+	return object
+}
+
+func GetResponse(c *gin.Context) {
+	id := c.Param("id")
+	stored, err := dbmodel.GetStoredResponse(id, c.GetInt(ctxkey.Id))
+	if err != nil {
+		responseNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, storedResponseObject(stored))
+}
+
+func DeleteResponse(c *gin.Context) {
+	id := c.Param("id")
+	deleted, err := dbmodel.DeleteStoredResponse(id, c.GetInt(ctxkey.Id))
+	if err != nil || !deleted {
+		responseNotFound(c, id)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"id":      id,
+		"object":  "response",
+		"deleted": true,
+	})
+}
+
+// CancelResponse cancels a background response, its relay still completes but the
+// result is discarded
+func CancelResponse(c *gin.Context) {
+	id := c.Param("id")
+	userId := c.GetInt(ctxkey.Id)
+	cancelled, err := dbmodel.CancelStoredResponse(id, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": model.Error{Message: err.Error(), Type: "one_api_error"},
+		})
+		return
+	}
+	stored, err := dbmodel.GetStoredResponse(id, userId)
+	if err != nil {
+		responseNotFound(c, id)
+		return
+	}
+	if !cancelled && stored.Status != dbmodel.StoredResponseStatusCancelled {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": model.Error{
+				Message: "Only background responses in progress can be cancelled.",
+				Type:    "invalid_request_error",
+			},
+		})
+		return
+	}
+	c.JSON(http.StatusOK, storedResponseObject(stored))
+}
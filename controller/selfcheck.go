@@ -0,0 +1,22 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+// GetSelfCheck returns the report of the startup self-check, or of a new one with refresh=true
+func GetSelfCheck(c *gin.Context) {
+	report := model.GetSelfCheckReport()
+	if report == nil || c.Query("refresh") == "true" {
+		report = model.RunSelfCheck(c.Request.Context())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    report,
+	})
+}
@@ -0,0 +1,52 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/model"
+)
+
+func GetSettings(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetSettings(),
+	})
+}
+
+// UpdateSettings changes the settings of a JSON object mapping their keys to their values,
+// e.g. {"ResponseCacheEnabled": true, "ResponseCacheTTL": 600}
+func UpdateSettings(c *gin.Context) {
+	var request map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	values := make(map[string]string, len(request))
+	for key, raw := range request {
+		var value string
+		if json.Unmarshal(raw, &value) != nil {
+			// booleans and numbers are kept as written
+			value = string(raw)
+		}
+		values[key] = value
+	}
+	if err := model.UpdateSettings(values); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetSettings(),
+	})
+}
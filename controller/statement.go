@@ -0,0 +1,103 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// getStatement answers a statement request with the statement of getStatement for the month
+// of the query, as JSON or with format=pdf as a PDF file
+func getStatement(c *gin.Context, getStatement func(month string, code string) (*model.Statement, error)) {
+	if !config.UsageRollupEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "usage rollups are disabled",
+		})
+		return
+	}
+	statement, err := getStatement(c.Query("month"), userCurrency(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("format") == "pdf" {
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", statement.FileName()))
+		c.Data(http.StatusOK, "application/pdf", statement.PDF())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    statement,
+	})
+}
+
+// GetStatement returns the statement of the user_id or the organization_id of the query
+func GetStatement(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	organizationId, _ := strconv.Atoi(c.Query("organization_id"))
+	getStatement(c, func(month string, code string) (*model.Statement, error) {
+		if organizationId != 0 {
+			return model.GetOrganizationStatement(organizationId, month, code)
+		}
+		return model.GetUserStatement(userId, month, code)
+	})
+}
+
+func GetSelfStatement(c *gin.Context) {
+	userId := c.GetInt(ctxkey.Id)
+	getStatement(c, func(month string, code string) (*model.Statement, error) {
+		return model.GetUserStatement(userId, month, code)
+	})
+}
+
+func GetSelfOrganizationStatement(c *gin.Context) {
+	member, ok := getSelfOrganizationMember(c, true)
+	if !ok {
+		return
+	}
+	getStatement(c, func(month string, code string) (*model.Statement, error) {
+		return model.GetOrganizationStatement(member.OrganizationId, month, code)
+	})
+}
+
+// EmailSelfStatement emails the statement of the month of the query to the current user
+func EmailSelfStatement(c *gin.Context) {
+	userId := c.GetInt(ctxkey.Id)
+	user, err := model.GetUserById(userId, false)
+	if err == nil && user.Email == "" {
+		err = errors.New("请先绑定邮箱")
+	}
+	var statement *model.Statement
+	if err == nil && !config.UsageRollupEnabled {
+		err = errors.New("usage rollups are disabled")
+	}
+	if err == nil {
+		statement, err = model.GetUserStatement(userId, c.Query("month"), userCurrency(c))
+	}
+	if err == nil {
+		err = statement.Email(user.Email)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/jsonschema"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+const structuredOutputRepairPrompt = "Your previous reply does not match the required JSON schema:\n%s\nReply again with only the corrected JSON, without any other text."
+
+// outputRecorder holds a response back until its output is validated
+type outputRecorder struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newOutputRecorder(w gin.ResponseWriter) *outputRecorder {
+	return &outputRecorder{ResponseWriter: w, header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *outputRecorder) Header() http.Header {
+	return w.header
+}
+
+func (w *outputRecorder) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *outputRecorder) WriteHeaderNow() {}
+
+func (w *outputRecorder) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *outputRecorder) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *outputRecorder) Status() int {
+	return w.status
+}
+
+func (w *outputRecorder) Size() int {
+	return w.body.Len()
+}
+
+func (w *outputRecorder) Written() bool {
+	return w.body.Len() > 0
+}
+
+func (w *outputRecorder) Flush() {}
+
+// flush sends the response held back
+func (w *outputRecorder) flush() {
+	for key, values := range w.header {
+		w.ResponseWriter.Header()[key] = values
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, _ = w.ResponseWriter.Write(w.body.Bytes())
+}
+
+// structuredOutputSchema returns the JSON schema the output of a chat completion is
+// validated against, nil if it is not. Strict json_schema response formats are validated,
+// and with a token enabling it all json_schema and json_object ones. Streamed completions
+// and completions of several choices are not.
+func structuredOutputSchema(c *gin.Context) map[string]any {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil || !bytes.Contains(requestBody, []byte(`"response_format"`)) {
+		return nil
+	}
+	var request model.GeneralOpenAIRequest
+	if err = json.Unmarshal(requestBody, &request); err != nil {
+		return nil
+	}
+	if request.Stream || request.N > 1 || request.ResponseFormat == nil {
+		return nil
+	}
+	tokenEnforced := c.GetBool(ctxkey.StructuredOutput)
+	switch request.ResponseFormat.Type {
+	case "json_schema":
+		jsonSchema := request.ResponseFormat.JsonSchema
+		if jsonSchema == nil || jsonSchema.Schema == nil {
+			return nil
+		}
+		if tokenEnforced || (jsonSchema.Strict != nil && *jsonSchema.Strict) {
+			return jsonSchema.Schema
+		}
+	case "json_object":
+		if tokenEnforced {
+			return map[string]any{"type": "object"}
+		}
+	}
+	return nil
+}
+
+// trimCodeFence removes the markdown code fence some models put around JSON
+func trimCodeFence(output string) string {
+	output = strings.TrimSpace(output)
+	if !strings.HasPrefix(output, "```") || !strings.HasSuffix(output, "```") || len(output) < 6 {
+		return output
+	}
+	output = strings.TrimSuffix(output[3:], "```")
+	if newline := strings.Index(output, "\n"); newline >= 0 && !strings.ContainsAny(output[:newline], "{[") {
+		// the language of the fence
+		output = output[newline+1:]
+	}
+	return strings.TrimSpace(output)
+}
+
+// validateOutput validates the output of the first choice of a recorded chat completion.
+// It returns the output and its errors, none if the response is not a chat completion or
+// calls tools. An output which is valid once its code fence is removed is replaced.
+func validateOutput(recorder *outputRecorder, schema map[string]any) (string, []string) {
+	if recorder.status != http.StatusOK {
+		return "", nil
+	}
+	var response map[string]any
+	if err := json.Unmarshal(recorder.body.Bytes(), &response); err != nil {
+		return "", nil
+	}
+	choices, _ := response["choices"].([]any)
+	if len(choices) == 0 {
+		return "", nil
+	}
+	choice, _ := choices[0].(map[string]any)
+	message, _ := choice["message"].(map[string]any)
+	if message == nil || message["tool_calls"] != nil {
+		return "", nil
+	}
+	output, _ := message["content"].(string)
+	errs := jsonschema.ValidateJSON(schema, output)
+	if len(errs) == 0 {
+		return output, nil
+	}
+	trimmed := trimCodeFence(output)
+	if trimmed == output || len(jsonschema.ValidateJSON(schema, trimmed)) > 0 {
+		return output, errs
+	}
+	message["content"] = trimmed
+	body, err := json.Marshal(response)
+	if err != nil {
+		return output, errs
+	}
+	recorder.body.Reset()
+	recorder.body.Write(body)
+	recorder.header.Del("Content-Length")
+	return trimmed, nil
+}
+
+// relayRecorded relays a chat completion holding its response back
+func relayRecorded(c *gin.Context) (*outputRecorder, *model.ErrorWithStatusCode) {
+	recorder := newOutputRecorder(c.Writer)
+	c.Writer = recorder
+	bizErr := controller.RelayTextHelper(c)
+	c.Writer = recorder.ResponseWriter
+	return recorder, bizErr
+}
+
+// structuredOutputRepairBody returns the request asking for the output to be repaired, or
+// the original request when it is sent to the fallback model
+func structuredOutputRepairBody(requestBody []byte, output string, errs []string, fallbackModel string) ([]byte, error) {
+	var request map[string]any
+	if err := json.Unmarshal(requestBody, &request); err != nil {
+		return nil, err
+	}
+	if fallbackModel != "" {
+		request["model"] = fallbackModel
+	} else {
+		messages, _ := request["messages"].([]any)
+		request["messages"] = append(messages,
+			map[string]any{"role": "assistant", "content": output},
+			map[string]any{"role": "user", "content": fmt.Sprintf(structuredOutputRepairPrompt, strings.Join(errs, "\n"))},
+		)
+	}
+	return json.Marshal(request)
+}
+
+// relayStructuredOutput relays a chat completion whose output must match a JSON schema. An
+// invalid output is repaired once, by STRUCTURED_OUTPUT_FALLBACK_MODEL if set or else by
+// asking the same model to correct it, then an error is returned if it is still invalid.
+func relayStructuredOutput(c *gin.Context, schema map[string]any) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	recorder, bizErr := relayRecorded(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	output, errs := validateOutput(recorder, schema)
+	if len(errs) == 0 {
+		recorder.flush()
+		return nil
+	}
+	logger.Warnf(ctx, "structured output does not match its schema, repairing: %s", strings.Join(errs, "; "))
+
+	requestBody, _ := common.GetRequestBody(c)
+	fallbackModel := config.StructuredOutputFallbackModel
+	if fallbackModel == c.GetString(ctxkey.OriginalModel) {
+		fallbackModel = ""
+	}
+	if fallbackModel != "" {
+		channel, err := dbmodel.CacheGetRandomSatisfiedChannel(c.GetInt(ctxkey.TenantId), c.GetString(ctxkey.Group), fallbackModel, false)
+		if err != nil {
+			logger.Errorf(ctx, "no channel for structured output fallback model %s: %s", fallbackModel, err.Error())
+			fallbackModel = ""
+		} else {
+			middleware.SetupContextForSelectedChannel(c, channel, fallbackModel)
+		}
+	}
+	repairBody, err := structuredOutputRepairBody(requestBody, output, errs, fallbackModel)
+	if err != nil {
+		return openai.ErrorWrapper(err, "structured_output_repair_failed", http.StatusInternalServerError)
+	}
+	c.Set(ctxkey.KeyRequestBody, repairBody)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(repairBody))
+	defer func() {
+		// restored for the retries
+		c.Set(ctxkey.KeyRequestBody, requestBody)
+	}()
+	recorder, bizErr = relayRecorded(c)
+	if bizErr != nil {
+		return bizErr
+	}
+	if _, errs = validateOutput(recorder, schema); len(errs) == 0 {
+		recorder.flush()
+		return nil
+	}
+	requestId := c.GetString(helper.RequestIdKey)
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error": model.Error{
+			Message: helper.MessageWithRequestId("the output of the model does not match the JSON schema of response_format after a repair: "+strings.Join(errs, "; "), requestId),
+			Type:    "one_api_error",
+			Code:    "structured_output_invalid",
+		},
+		"request_id": requestId,
+	})
+	return nil
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// adminTenantId returns the tenant whose rows the admin of the request manages: the admins
+// of the installation manage every tenant, or the one of the tenant_id query, the admins of
+// a tenant only their own
+func adminTenantId(c *gin.Context) int {
+	tenantId := c.GetInt(ctxkey.TenantId)
+	if tenantId != model.DefaultTenantId {
+		return tenantId
+	}
+	if query := c.Query("tenant_id"); query != "" {
+		if id, err := strconv.Atoi(query); err == nil && id >= 0 {
+			return id
+		}
+	}
+	return model.AllTenants
+}
+
+// managesTenant tells whether the admin of the request manages the rows of a tenant
+func managesTenant(c *gin.Context, tenantId int) bool {
+	own := c.GetInt(ctxkey.TenantId)
+	return own == model.DefaultTenantId || own == tenantId
+}
+
+func GetAllTenants(c *gin.Context) {
+	tenants, err := model.GetAllTenants()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tenants,
+	})
+}
+
+func GetTenant(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	tenant, err := model.GetTenantById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tenant,
+	})
+}
+
+func AddTenant(c *gin.Context) {
+	tenant := model.Tenant{}
+	err := c.ShouldBindJSON(&tenant)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = tenant.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	tenant.Id = 0
+	if err = tenant.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tenant,
+	})
+}
+
+func UpdateTenant(c *gin.Context) {
+	tenant := model.Tenant{}
+	err := c.ShouldBindJSON(&tenant)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if _, err = model.GetTenantById(tenant.Id); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = tenant.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = tenant.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    tenant,
+	})
+}
+
+func DeleteTenant(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	tenant, err := model.GetTenantById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = tenant.Delete(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func tenantAdminContext(tenantId int, method string, target string, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(ctxkey.Id, 100)
+	c.Set(ctxkey.Role, model.RoleRootUser)
+	c.Set(ctxkey.TenantId, tenantId)
+	return c, w
+}
+
+func TestAdminTenantId(t *testing.T) {
+	tests := []struct {
+		tenantId int
+		query    string
+		want     int
+	}{
+		{model.DefaultTenantId, "", model.AllTenants},
+		{model.DefaultTenantId, "?tenant_id=3", 3},
+		{model.DefaultTenantId, "?tenant_id=0", model.DefaultTenantId},
+		{model.DefaultTenantId, "?tenant_id=-1", model.AllTenants},
+		{model.DefaultTenantId, "?tenant_id=abc", model.AllTenants},
+		{2, "", 2},
+		{2, "?tenant_id=3", 2},
+		{2, "?tenant_id=-1", 2},
+	}
+	for _, tt := range tests {
+		c, _ := tenantAdminContext(tt.tenantId, http.MethodGet, "/api/user/"+tt.query, "")
+		assert.Equal(t, tt.want, adminTenantId(c), "tenant %d%s", tt.tenantId, tt.query)
+	}
+	c, _ := tenantAdminContext(model.DefaultTenantId, http.MethodGet, "/", "")
+	assert.True(t, managesTenant(c, 3))
+	c, _ = tenantAdminContext(2, http.MethodGet, "/", "")
+	assert.True(t, managesTenant(c, 2))
+	assert.False(t, managesTenant(c, 3))
+	assert.False(t, managesTenant(c, model.DefaultTenantId))
+}
+
+func TestTenantAdminListsOwnRows(t *testing.T) {
+	initTestDB(t, &model.Channel{}, &model.User{}, &model.Log{})
+	for i, tenantId := range []int{model.DefaultTenantId, 2, 3} {
+		name := "tenant-" + strconv.Itoa(tenantId)
+		require.NoError(t, model.DB.Create(&model.Channel{Name: name, TenantId: tenantId}).Error)
+		require.NoError(t, model.DB.Create(&model.User{Username: name, AccessToken: name, AffCode: name, TenantId: tenantId}).Error)
+		require.NoError(t, model.DB.Create(&model.Log{UserId: i + 1, Username: name, Type: model.LogTypeConsume, TenantId: tenantId}).Error)
+	}
+
+	tests := []struct {
+		name    string
+		handler gin.HandlerFunc
+		target  string
+		field   string
+	}{
+		{"logs", GetAllLogs, "/api/log/", "username"},
+		{"channels", GetAllChannels, "/api/channel/", "name"},
+		{"users", GetAllUsers, "/api/user/", "username"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names := func(tenantId int, query string) []string {
+				c, w := tenantAdminContext(tenantId, http.MethodGet, tt.target+query, "")
+				tt.handler(c)
+				var response struct {
+					Success bool             `json:"success"`
+					Data    []map[string]any `json:"data"`
+				}
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				require.True(t, response.Success)
+				var names []string
+				for _, row := range response.Data {
+					names = append(names, row[tt.field].(string))
+				}
+				return names
+			}
+			assert.ElementsMatch(t, []string{"tenant-0", "tenant-2", "tenant-3"}, names(model.DefaultTenantId, ""))
+			assert.Equal(t, []string{"tenant-3"}, names(model.DefaultTenantId, "?tenant_id=3"))
+			assert.Equal(t, []string{"tenant-2"}, names(2, ""))
+			// the admin of a tenant can't ask for the rows of another one
+			assert.Equal(t, []string{"tenant-2"}, names(2, "?tenant_id=3"))
+			assert.Equal(t, []string{"tenant-2"}, names(2, "?tenant_id=-1"))
+		})
+	}
+}
+
+func TestTenantAdminUpdatesOwnUsers(t *testing.T) {
+	initTestDB(t, &model.User{})
+	own := &model.User{Username: "own", Password: "password", AccessToken: "own", AffCode: "own", TenantId: 2}
+	require.NoError(t, model.DB.Create(own).Error)
+	other := &model.User{Username: "other", Password: "password", AccessToken: "other", AffCode: "other", TenantId: 3}
+	require.NoError(t, model.DB.Create(other).Error)
+
+	update := func(user *model.User) bool {
+		body := `{"id": ` + strconv.Itoa(user.Id) + `, "username": "` + user.Username + `", "display_name": "renamed", "role": 1}`
+		c, w := tenantAdminContext(2, http.MethodPut, "/api/user/", body)
+		UpdateUser(c)
+		var response struct {
+			Success bool `json:"success"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Success
+	}
+	assert.True(t, update(own))
+	assert.False(t, update(other))
+	user, err := model.GetUserById(other.Id, false)
+	require.NoError(t, err)
+	assert.Empty(t, user.DisplayName)
+}
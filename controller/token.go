@@ -7,10 +7,13 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/network"
-	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/common/priority"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/postprocess"
 	"net/http"
+	"path"
 	"strconv"
+	"strings"
 )
 
 func GetAllTokens(c *gin.Context) {
@@ -21,7 +24,13 @@ func GetAllTokens(c *gin.Context) {
 	}
 
 	order := c.Query("order")
-	tokens, err := model.GetAllUserTokens(userId, p*config.ItemsPerPage, config.ItemsPerPage, order)
+	var tokens []*model.Token
+	var err error
+	if c.Query("archived") == "true" {
+		tokens, err = model.GetArchivedUserTokens(userId, p*config.ItemsPerPage, config.ItemsPerPage)
+	} else {
+		tokens, err = model.GetAllUserTokens(userId, p*config.ItemsPerPage, config.ItemsPerPage, order)
+	}
 
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -117,6 +126,39 @@ func validateToken(c *gin.Context, token model.Token) error {
 			return fmt.Errorf("无效的网段：%s", err.Error())
 		}
 	}
+	if token.Models != nil && *token.Models != "" {
+		for _, pattern := range strings.Split(*token.Models, ",") {
+			if _, err := path.Match(strings.TrimSpace(pattern), ""); err != nil {
+				return fmt.Errorf("无效的模型：%s", pattern)
+			}
+		}
+	}
+	if token.Endpoints != nil && *token.Endpoints != "" {
+		for _, endpoint := range strings.Split(*token.Endpoints, ",") {
+			endpoint = strings.TrimSpace(endpoint)
+			known := false
+			for _, tokenEndpoint := range model.TokenEndpoints {
+				if endpoint == tokenEndpoint {
+					known = true
+					break
+				}
+			}
+			if !known {
+				return fmt.Errorf("无效的接口：%s，可选的接口：%s", endpoint, strings.Join(model.TokenEndpoints, ","))
+			}
+		}
+	}
+	if token.PromptTemplateId != 0 {
+		if _, err := model.GetPromptTemplateById(token.PromptTemplateId); err != nil {
+			return fmt.Errorf("提示词模板不存在")
+		}
+	}
+	if err := postprocess.ValidateNames(postprocess.SplitNames(token.PostProcessors)); err != nil {
+		return err
+	}
+	if !priority.IsValid(token.Priority) {
+		return fmt.Errorf("无效的优先级：%s，可选的优先级：%s", token.Priority, strings.Join(priority.Classes, ","))
+	}
 	return nil
 }
 
@@ -140,16 +182,24 @@ func AddToken(c *gin.Context) {
 	}
 
 	cleanToken := model.Token{
-		UserId:         c.GetInt(ctxkey.Id),
-		Name:           token.Name,
-		Key:            random.GenerateKey(),
-		CreatedTime:    helper.GetTimestamp(),
-		AccessedTime:   helper.GetTimestamp(),
-		ExpiredTime:    token.ExpiredTime,
-		RemainQuota:    token.RemainQuota,
-		UnlimitedQuota: token.UnlimitedQuota,
-		Models:         token.Models,
-		Subnet:         token.Subnet,
+		UserId:            c.GetInt(ctxkey.Id),
+		Name:              token.Name,
+		Key:               model.NewTokenKey(),
+		CreatedTime:       helper.GetTimestamp(),
+		AccessedTime:      helper.GetTimestamp(),
+		ExpiredTime:       token.ExpiredTime,
+		RemainQuota:       token.RemainQuota,
+		UnlimitedQuota:    token.UnlimitedQuota,
+		Models:            token.Models,
+		Subnet:            token.Subnet,
+		Endpoints:         token.Endpoints,
+		Groups:            token.Groups,
+		StructuredOutput:  token.StructuredOutput,
+		MaxStreamDuration: token.MaxStreamDuration,
+		PromptTemplateId:  token.PromptTemplateId,
+		PostProcessors:    token.PostProcessors,
+		Priority:          token.Priority,
+		RequireSignature:  token.RequireSignature,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -185,6 +235,119 @@ func DeleteToken(c *gin.Context) {
 	return
 }
 
+// RestoreToken brings back an archived token of the current user, disabled
+func RestoreToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt(ctxkey.Id)
+	token, err := model.GetTokenByIds(id, userId)
+	if err == nil {
+		err = token.Restore()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    token,
+	})
+}
+
+// PurgeToken deletes an archived token of the current user for good
+func PurgeToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt(ctxkey.Id)
+	token, err := model.GetTokenByIds(id, userId)
+	if err == nil {
+		err = token.Purge()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type rotateTokenRequest struct {
+	GracePeriod *int64 `json:"grace_period"` // seconds the current key keeps working, TOKEN_ROTATION_GRACE_PERIOD by default
+}
+
+// RotateToken gives a token a new key, the current one keeps working for a grace period
+func RotateToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt(ctxkey.Id)
+	request := rotateTokenRequest{}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	gracePeriod := int64(config.TokenRotationGracePeriod)
+	if request.GracePeriod != nil {
+		gracePeriod = *request.GracePeriod
+	}
+	token, err := model.GetTokenByIds(id, userId)
+	if err == nil {
+		err = token.Rotate(gracePeriod)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    token,
+	})
+}
+
+type revokeTokenRequest struct {
+	Key string `json:"key"`
+}
+
+// RevokeLeakedToken revokes a leaked key, it requires no login so that whoever finds the
+// key, such as a secret scanner, can report it
+func RevokeLeakedToken(c *gin.Context) {
+	request := revokeTokenRequest{}
+	if err := c.ShouldBindJSON(&request); err != nil || request.Key == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimSpace(request.Key), "sk-")
+	key = strings.Split(key, "-")[0]
+	if _, err := model.RevokeLeakedTokenKey(c.Request.Context(), key); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
 func UpdateToken(c *gin.Context) {
 	userId := c.GetInt(ctxkey.Id)
 	statusOnly := c.Query("status_only")
@@ -213,6 +376,20 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if cleanToken.Status == model.TokenStatusArchived || token.Status == model.TokenStatusArchived {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "令牌已被删除，请先恢复令牌",
+		})
+		return
+	}
+	if cleanToken.OrganizationId != 0 && statusOnly == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织令牌只能由组织管理员修改",
+		})
+		return
+	}
 	if token.Status == model.TokenStatusEnabled {
 		if cleanToken.Status == model.TokenStatusExpired && cleanToken.ExpiredTime <= helper.GetTimestamp() && cleanToken.ExpiredTime != -1 {
 			c.JSON(http.StatusOK, gin.H{
@@ -239,6 +416,14 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.Models = token.Models
 		cleanToken.Subnet = token.Subnet
+		cleanToken.Endpoints = token.Endpoints
+		cleanToken.Groups = token.Groups
+		cleanToken.StructuredOutput = token.StructuredOutput
+		cleanToken.MaxStreamDuration = token.MaxStreamDuration
+		cleanToken.PromptTemplateId = token.PromptTemplateId
+		cleanToken.PostProcessors = token.PostProcessors
+		cleanToken.Priority = token.Priority
+		cleanToken.RequireSignature = token.RequireSignature
 	}
 	err = cleanToken.Update()
 	if err != nil {
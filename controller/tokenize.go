@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/tokenizer"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// TokenizeResponse is the count of the prompt of a request, the context window is 0 when the
+// model has no metadata
+type TokenizeResponse struct {
+	Object          string `json:"object"`
+	Model           string `json:"model"`
+	Encoding        string `json:"encoding"`
+	InputTokens     int    `json:"input_tokens"`
+	ContextWindow   int    `json:"context_window"`
+	RemainingTokens *int   `json:"remaining_tokens,omitempty"` // the tokens left for the completion, negative when the prompt doesn't fit
+}
+
+// Tokenize counts the prompt tokens of a chat completion, a completion or a moderation
+// request as the gateway bills them, without relaying it
+func Tokenize(c *gin.Context) {
+	request := relaymodel.GeneralOpenAIRequest{}
+	if err := common.UnmarshalBodyReusable(c, &request); err != nil {
+		renderTokenizeError(c, "invalid request body: "+err.Error(), "")
+		return
+	}
+	modelName := c.GetString(ctxkey.RequestModel)
+	if modelName == "" {
+		renderTokenizeError(c, "model is required", "model")
+		return
+	}
+	var tokens int
+	switch {
+	case len(request.Messages) > 0:
+		tokens = openai.CountTokenMessages(request.Messages, modelName)
+	case request.Prompt != nil:
+		tokens = openai.CountTokenInput(request.Prompt, modelName)
+	case request.Input != nil:
+		tokens = openai.CountTokenInput(request.Input, modelName)
+	default:
+		renderTokenizeError(c, "messages, prompt or input is required", "messages")
+		return
+	}
+	response := TokenizeResponse{
+		Object:      "tokenize",
+		Model:       modelName,
+		Encoding:    tokenizer.EncodingForModel(modelName),
+		InputTokens: tokens,
+	}
+	if modelMeta, ok := model.CacheGetModelMeta(modelName); ok && modelMeta.ContextWindow > 0 {
+		response.ContextWindow = modelMeta.ContextWindow
+		remaining := modelMeta.ContextWindow - tokens
+		response.RemainingTokens = &remaining
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func renderTokenizeError(c *gin.Context, message string, param string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": relaymodel.Error{
+			Message: message,
+			Type:    "invalid_request_error",
+			Param:   param,
+		},
+	})
+}
@@ -0,0 +1,255 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
+	relaycontroller "github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func GetAllTrafficMirrors(c *gin.Context) {
+	mirrors, err := model.GetAllTrafficMirrors()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    mirrors,
+	})
+}
+
+func GetTrafficMirror(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	mirror, err := model.GetTrafficMirrorById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    mirror,
+	})
+}
+
+func AddTrafficMirror(c *gin.Context) {
+	mirror := model.TrafficMirror{}
+	err := c.ShouldBindJSON(&mirror)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = mirror.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	mirror.Id = 0
+	if mirror.Status == 0 {
+		mirror.Status = model.TrafficMirrorStatusEnabled
+	}
+	if err = mirror.Insert(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationTrafficMirrors, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    mirror,
+	})
+}
+
+func UpdateTrafficMirror(c *gin.Context) {
+	mirror := model.TrafficMirror{}
+	err := c.ShouldBindJSON(&mirror)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanMirror, err := model.GetTrafficMirrorById(mirror.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if c.Query("status_only") != "" {
+		cleanMirror.Status = mirror.Status
+	} else {
+		mirror.CreatedTime = cleanMirror.CreatedTime
+		if mirror.Status == 0 {
+			mirror.Status = cleanMirror.Status
+		}
+		cleanMirror = &mirror
+	}
+	if err = cleanMirror.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = cleanMirror.Update(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationTrafficMirrors, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanMirror,
+	})
+}
+
+func DeleteTrafficMirror(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	mirror, err := model.GetTrafficMirrorById(id)
+	if err == nil {
+		err = mirror.Delete()
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	model.PublishInvalidation(model.InvalidationTrafficMirrors, "")
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetTrafficMirrorResults compares the error rate and the latency of the shadow channel of a
+// mirror with production
+func GetTrafficMirrorResults(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	results, err := model.GetTrafficMirrorResults(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    results,
+	})
+}
+
+// trafficMirrorTimeout bounds the shadow requests, they are not cancelled with the production
+// ones
+const trafficMirrorTimeout = 5 * time.Minute
+
+var trafficMirrorSlots = make(chan struct{}, config.TrafficMirrorMaxInFlight)
+
+// sampleTrafficMirror returns the mirror a relay request is mirrored by, nil when it is not
+func sampleTrafficMirror(c *gin.Context, relayMode int) *model.TrafficMirror {
+	if c.GetInt(ctxkey.TenantId) != model.DefaultTenantId {
+		// the requests of the tenants are not sent to the channels of the installation
+		return nil
+	}
+	switch relayMode {
+	case relaymode.ChatCompletions, relaymode.Completions, relaymode.Embeddings:
+		return model.CacheSampleTrafficMirror(c.GetString(ctxkey.Group), c.GetString(ctxkey.OriginalModel))
+	default:
+		return nil
+	}
+}
+
+// mirrorRequest sends a copy of a relay request which ended to the shadow channel of its
+// mirror, and records the outcomes of both
+func mirrorRequest(c *gin.Context, mirror *model.TrafficMirror, startTime time.Time, failed bool) {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return
+	}
+	select {
+	case trafficMirrorSlots <- struct{}{}:
+	default:
+		model.RecordTrafficMirrorDropped(mirror.Id)
+		return
+	}
+	model.RecordTrafficMirrorRequest(mirror.Id, model.TrafficMirrorProduction, time.Since(startTime), failed)
+
+	requestId := c.GetString(helper.RequestIdKey)
+	ctx, cancel := context.WithTimeout(helper.SetRequestID(context.Background(), requestId), trafficMirrorTimeout)
+	mirrorContext, _ := gin.CreateTestContext(httptest.NewRecorder())
+	mirrorContext.Request = c.Request.Clone(ctx)
+	mirrorContext.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	mirrorContext.Set(ctxkey.KeyRequestBody, requestBody)
+	for _, key := range []string{helper.RequestIdKey, ctxkey.Id, ctxkey.Group, ctxkey.TokenId, ctxkey.TokenName, ctxkey.RequestModel} {
+		if value, ok := c.Get(key); ok {
+			mirrorContext.Set(key, value)
+		}
+	}
+	middleware.SetupContextForSelectedChannel(mirrorContext, mirror.Channel(), c.GetString(ctxkey.OriginalModel))
+	go func() {
+		defer func() {
+			cancel()
+			<-trafficMirrorSlots
+		}()
+		mirrorStart := time.Now()
+		bizErr := relaycontroller.RelayMirrorText(mirrorContext, mirror.Model)
+		model.RecordTrafficMirrorRequest(mirror.Id, model.TrafficMirrorShadow, time.Since(mirrorStart), bizErr != nil)
+		if bizErr != nil {
+			logger.Warnf(ctx, "traffic mirror %s: shadow channel #%d failed: %s", mirror.Name, mirror.ChannelId, bizErr.Error.Message)
+		}
+	}()
+}
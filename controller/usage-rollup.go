@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func getUsageRollups(c *gin.Context, filter model.UsageRollupFilter) {
+	if !config.UsageRollupEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "usage rollups are disabled",
+		})
+		return
+	}
+	filter.Start, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.End, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	filter.ModelName = c.Query("model_name")
+	filter.Daily = c.Query("granularity") == "day"
+	stats, err := model.GetUsageRollups(filter)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    stats,
+	})
+}
+
+func GetUsageRollups(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	channel, _ := strconv.Atoi(c.Query("channel"))
+	getUsageRollups(c, model.UsageRollupFilter{UserId: userId, ChannelId: channel})
+}
+
+func GetUserUsageRollups(c *gin.Context) {
+	getUsageRollups(c, model.UsageRollupFilter{UserId: c.GetInt(ctxkey.Id)})
+}
+
+// RebuildUsageRollups recomputes the rollups of a time range from the logs
+func RebuildUsageRollups(c *gin.Context) {
+	start, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	end, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	if end <= start {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "invalid time range",
+		})
+		return
+	}
+	rows, err := model.RebuildUsageRollups(start, end)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    rows,
+	})
+}
+
+var usageExportColumns = []string{"requests", "prompt_tokens", "completion_tokens", "quota", "cost", "cached_tokens", "cache_saved_quota", "cache_saved_cost", "errors", "currency"}
+
+// GetUserUsage returns the usage of the user grouped by the comma separated group_by, among
+// day, model and token, as JSON or with format=csv as a CSV file
+func GetUserUsage(c *gin.Context) {
+	getUsage(c, model.UsageRollupFilter{UserId: c.GetInt(ctxkey.Id)}, model.GetUsageSummaries)
+}
+
+// getUsage answers a usage request with the summaries of getSummaries
+func getUsage(c *gin.Context, filter model.UsageRollupFilter, getSummaries func(model.UsageRollupFilter, []string) ([]*model.UsageSummary, error)) {
+	if !config.UsageRollupEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "usage rollups are disabled",
+		})
+		return
+	}
+	filter.Start, _ = strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	filter.End, _ = strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	filter.ModelName = c.Query("model_name")
+	var groupBy []string
+	for _, group := range strings.Split(c.DefaultQuery("group_by", model.UsageGroupDay), ",") {
+		if group = strings.TrimSpace(group); group == "" {
+			continue
+		}
+		if !model.IsUsageGroup(group) {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("cannot group usage by %s, use day, model or token", group),
+			})
+			return
+		}
+		groupBy = append(groupBy, group)
+	}
+	summaries, err := getSummaries(filter, groupBy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// the costs are in the currency of the user asking
+	code := userCurrency(c)
+	for _, summary := range summaries {
+		summary.Cost = currency.FromQuota(summary.Quota, code)
+		summary.CacheSavedCost = currency.FromQuota(summary.CacheSavedQuota, code)
+		summary.Currency = code
+	}
+	if c.Query("format") == "csv" {
+		exportUsage(c, groupBy, summaries)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    summaries,
+	})
+}
+
+func exportUsage(c *gin.Context, groupBy []string, summaries []*model.UsageSummary) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=usage-%s.csv", time.Now().Format("20060102150405")))
+	c.Status(http.StatusOK)
+	csvWriter := csv.NewWriter(c.Writer)
+	_ = csvWriter.Write(append(append([]string{}, groupBy...), usageExportColumns...))
+	for _, summary := range summaries {
+		record := make([]string, 0, len(groupBy)+len(usageExportColumns))
+		for _, group := range groupBy {
+			switch group {
+			case model.UsageGroupDay:
+				record = append(record, time.Unix(summary.Day, 0).UTC().Format("2006-01-02"))
+			case model.UsageGroupModel:
+				record = append(record, summary.ModelName)
+			case model.UsageGroupToken:
+				record = append(record, summary.TokenName)
+			}
+		}
+		record = append(record,
+			strconv.FormatInt(summary.Requests, 10),
+			strconv.FormatInt(summary.PromptTokens, 10),
+			strconv.FormatInt(summary.CompletionTokens, 10),
+			strconv.FormatInt(summary.Quota, 10),
+			strconv.FormatFloat(summary.Cost, 'f', 6, 64),
+			strconv.FormatInt(summary.CachedTokens, 10),
+			strconv.FormatInt(summary.CacheSavedQuota, 10),
+			strconv.FormatFloat(summary.CacheSavedCost, 'f', 6, 64),
+			strconv.FormatInt(summary.Errors, 10),
+			summary.Currency,
+		)
+		_ = csvWriter.Write(record)
+	}
+	csvWriter.Flush()
+}
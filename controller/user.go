@@ -66,6 +66,15 @@ func Login(c *gin.Context) {
 
 // setup session & cookies and then return user info
 func SetupLogin(user *model.User, c *gin.Context) {
+	if !c.GetBool(ctxkey.SSOLogin) {
+		if err := model.CheckSSOEnforced(user); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": err.Error(),
+				"success": false,
+			})
+			return
+		}
+	}
 	session := sessions.Default(c)
 	session.Set("id", user.Id)
 	session.Set("username", user.Username)
@@ -165,6 +174,7 @@ func Register(c *gin.Context) {
 		Password:    user.Password,
 		DisplayName: user.Username,
 		InviterId:   inviterId,
+		SignupIp:    c.ClientIP(),
 	}
 	if config.EmailVerificationEnabled {
 		cleanUser.Email = user.Email
@@ -191,7 +201,7 @@ func GetAllUsers(c *gin.Context) {
 	}
 
 	order := c.DefaultQuery("order", "")
-	users, err := model.GetAllUsers(p*config.ItemsPerPage, config.ItemsPerPage, order)
+	users, err := model.GetAllUsers(adminTenantId(c), p*config.ItemsPerPage, config.ItemsPerPage, order)
 
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -210,7 +220,7 @@ func GetAllUsers(c *gin.Context) {
 
 func SearchUsers(c *gin.Context) {
 	keyword := c.Query("keyword")
-	users, err := model.SearchUsers(keyword)
+	users, err := model.SearchUsers(adminTenantId(c), keyword)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -401,6 +411,14 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if !managesTenant(c, originUser.TenantId) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权更新其他租户的用户信息",
+		})
+		return
+	}
+	updatedUser.TenantId = originUser.TenantId // the tenant of a user doesn't change
 	if myRole <= updatedUser.Role && myRole != model.RoleRootUser {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -571,11 +589,23 @@ func CreateUser(c *gin.Context) {
 		})
 		return
 	}
+	if tenantId := c.GetInt(ctxkey.TenantId); tenantId != model.DefaultTenantId {
+		user.TenantId = tenantId
+	} else if user.TenantId != model.DefaultTenantId {
+		if _, err := model.GetTenantById(user.TenantId); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "租户不存在",
+			})
+			return
+		}
+	}
 	// Even for admin users, we cannot fully trust them!
 	cleanUser := model.User{
 		Username:    user.Username,
 		Password:    user.Password,
 		DisplayName: user.DisplayName,
+		TenantId:    user.TenantId,
 	}
 	if err := cleanUser.Insert(ctx, 0); err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -629,6 +659,13 @@ func ManageUser(c *gin.Context) {
 		})
 		return
 	}
+	if !managesTenant(c, user.TenantId) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权更新其他租户的用户信息",
+		})
+		return
+	}
 	switch req.Action {
 	case "disable":
 		user.Status = model.UserStatusDisabled
@@ -0,0 +1,162 @@
+package grpcapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/grpcapi/pb"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// adminServer implements the admin service with the same rules as the REST controllers
+type adminServer struct {
+	pb.UnimplementedAdminServiceServer
+}
+
+func pageBounds(page int32, pageSize int32) (int, int) {
+	if page < 0 {
+		page = 0
+	}
+	if pageSize <= 0 || pageSize > 100 {
+		pageSize = int32(config.ItemsPerPage)
+	}
+	return int(page * pageSize), int(pageSize)
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func toChannel(channel *model.Channel) *pb.Channel {
+	var weight uint32
+	if channel.Weight != nil {
+		weight = uint32(*channel.Weight)
+	}
+	return &pb.Channel{
+		Id:           int64(channel.Id),
+		Type:         int32(channel.Type),
+		Name:         channel.Name,
+		Status:       int32(channel.Status),
+		Group:        channel.Group,
+		Models:       channel.Models,
+		Priority:     channel.GetPriority(),
+		Weight:       weight,
+		BaseUrl:      channel.GetBaseURL(),
+		UsedQuota:    channel.UsedQuota,
+		Balance:      channel.Balance,
+		ResponseTime: int32(channel.ResponseTime),
+		CreatedTime:  channel.CreatedTime,
+		TestTime:     channel.TestTime,
+	}
+}
+
+func toToken(token *model.Token) *pb.Token {
+	return &pb.Token{
+		Id:             int64(token.Id),
+		UserId:         int64(token.UserId),
+		Name:           token.Name,
+		Status:         int32(token.Status),
+		CreatedTime:    token.CreatedTime,
+		AccessedTime:   token.AccessedTime,
+		ExpiredTime:    token.ExpiredTime,
+		RemainQuota:    token.RemainQuota,
+		UnlimitedQuota: token.UnlimitedQuota,
+		UsedQuota:      token.UsedQuota,
+		Models:         stringValue(token.Models),
+		Subnet:         stringValue(token.Subnet),
+	}
+}
+
+func (s *adminServer) ListChannels(ctx context.Context, req *pb.ListChannelsRequest) (*pb.ListChannelsResponse, error) {
+	startIdx, num := pageBounds(req.Page, req.PageSize)
+	channels, err := model.GetAllChannels(model.AllTenants, startIdx, num, "limited")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response := &pb.ListChannelsResponse{Channels: make([]*pb.Channel, 0, len(channels))}
+	for _, channel := range channels {
+		response.Channels = append(response.Channels, toChannel(channel))
+	}
+	return response, nil
+}
+
+func (s *adminServer) GetChannel(ctx context.Context, req *pb.GetChannelRequest) (*pb.Channel, error) {
+	channel, err := model.GetChannelById(int(req.Id), false)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return toChannel(channel), nil
+}
+
+func (s *adminServer) SetChannelStatus(ctx context.Context, req *pb.SetChannelStatusRequest) (*pb.Channel, error) {
+	if req.Status != model.ChannelStatusEnabled && req.Status != model.ChannelStatusManuallyDisabled {
+		return nil, status.Error(codes.InvalidArgument, "status must be 1 (enabled) or 2 (disabled)")
+	}
+	channel, err := model.GetChannelById(int(req.Id), false)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	model.UpdateChannelStatusById(channel.Id, int(req.Status))
+	channel.Status = int(req.Status)
+	return toChannel(channel), nil
+}
+
+func (s *adminServer) ListTokens(ctx context.Context, req *pb.ListTokensRequest) (*pb.ListTokensResponse, error) {
+	startIdx, num := pageBounds(req.Page, req.PageSize)
+	tokens, err := model.GetAllUserTokens(int(req.UserId), startIdx, num, "")
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response := &pb.ListTokensResponse{Tokens: make([]*pb.Token, 0, len(tokens))}
+	for _, token := range tokens {
+		response.Tokens = append(response.Tokens, toToken(token))
+	}
+	return response, nil
+}
+
+func (s *adminServer) GetToken(ctx context.Context, req *pb.GetTokenRequest) (*pb.Token, error) {
+	token, err := model.GetTokenById(int(req.Id))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	return toToken(token), nil
+}
+
+func (s *adminServer) SetTokenStatus(ctx context.Context, req *pb.SetTokenStatusRequest) (*pb.Token, error) {
+	if req.Status != model.TokenStatusEnabled && req.Status != model.TokenStatusDisabled {
+		return nil, status.Error(codes.InvalidArgument, "status must be 1 (enabled) or 2 (disabled)")
+	}
+	token, err := model.GetTokenById(int(req.Id))
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if token.Status == model.TokenStatusArchived {
+		return nil, status.Error(codes.FailedPrecondition, "the token is archived, restore it first")
+	}
+	if req.Status == model.TokenStatusEnabled {
+		if token.Status == model.TokenStatusExpired && token.ExpiredTime <= helper.GetTimestamp() && token.ExpiredTime != -1 {
+			return nil, status.Error(codes.FailedPrecondition, "the token is expired, change its expiration time first")
+		}
+		if token.Status == model.TokenStatusExhausted && token.RemainQuota <= 0 && !token.UnlimitedQuota {
+			return nil, status.Error(codes.FailedPrecondition, "the token is exhausted, change its remaining quota first")
+		}
+	}
+	token.Status = int(req.Status)
+	if err = token.Update(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return toToken(token), nil
+}
+
+func (s *adminServer) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.Usage, error) {
+	quota := model.SumUsedQuota(model.AllTenants, model.LogTypeConsume, req.StartTimestamp, req.EndTimestamp, req.ModelName, req.Username, req.TokenName, int(req.Channel))
+	tokens := model.SumUsedToken(model.LogTypeConsume, req.StartTimestamp, req.EndTimestamp, req.ModelName, req.Username, req.TokenName)
+	return &pb.Usage{Quota: quota, Tokens: int64(tokens)}, nil
+}
@@ -0,0 +1,189 @@
+package grpcapi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/songquanpeng/one-api/grpcapi/pb"
+)
+
+const chatCompletionsPath = "/v1/chat/completions"
+
+// relayServer relays the calls through the HTTP handler
+type relayServer struct {
+	pb.UnimplementedRelayServiceServer
+	handler http.Handler
+}
+
+// withStream sets the stream field of a JSON request
+func withStream(body []byte, stream bool) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var request map[string]any
+	if err := decoder.Decode(&request); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid request body: "+err.Error())
+	}
+	request["stream"] = stream
+	return json.Marshal(request)
+}
+
+func (s *relayServer) newRequest(ctx context.Context, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, chatCompletionsPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	req.Header.Set("Authorization", authorization(ctx))
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "127.0.0.1:0"
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		req.RemoteAddr = p.Addr.String()
+	}
+	return req, nil
+}
+
+func (s *relayServer) ChatCompletion(ctx context.Context, req *pb.RelayRequest) (*pb.RelayResponse, error) {
+	body, err := withStream(req.Body, false)
+	if err != nil {
+		return nil, err
+	}
+	httpRequest, err := s.newRequest(ctx, body)
+	if err != nil {
+		return nil, err
+	}
+	writer := newStreamWriter(ctx, nil)
+	s.handler.ServeHTTP(writer, httpRequest)
+	return &pb.RelayResponse{StatusCode: int32(writer.statusCode()), Body: writer.body.Bytes()}, nil
+}
+
+func (s *relayServer) StreamChatCompletion(req *pb.RelayRequest, stream pb.RelayService_StreamChatCompletionServer) error {
+	ctx := stream.Context()
+	body, err := withStream(req.Body, true)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := s.newRequest(ctx, body)
+	if err != nil {
+		return err
+	}
+	writer := newStreamWriter(ctx, stream.Send)
+	s.handler.ServeHTTP(writer, httpRequest)
+	return writer.finish()
+}
+
+var (
+	eventSeparator = []byte("\n\n")
+	dataPrefix     = []byte("data:")
+	doneData       = []byte("[DONE]")
+)
+
+// streamWriter is the response writer of a relay call. With send, the data of every event of
+// a successful stream is sent as a message, otherwise the response is held back.
+type streamWriter struct {
+	ctx     context.Context
+	send    func(*pb.RelayResponse) error
+	header  http.Header
+	status  int
+	body    bytes.Buffer
+	sendErr error
+}
+
+func newStreamWriter(ctx context.Context, send func(*pb.RelayResponse) error) *streamWriter {
+	return &streamWriter{ctx: ctx, send: send, header: make(http.Header)}
+}
+
+func (w *streamWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *streamWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *streamWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *streamWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	if w.send == nil || w.statusCode() != http.StatusOK {
+		return len(data), nil
+	}
+	for {
+		pending := w.body.Bytes()
+		end := bytes.Index(pending, eventSeparator)
+		if end < 0 {
+			return len(data), nil
+		}
+		event := pending[:end]
+		w.body.Next(end + len(eventSeparator))
+		if err := w.sendEvent(event); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *streamWriter) sendEvent(event []byte) error {
+	if w.sendErr != nil {
+		return w.sendErr
+	}
+	data, ok := bytes.CutPrefix(bytes.TrimSpace(event), dataPrefix)
+	if !ok {
+		return nil
+	}
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || bytes.Equal(data, doneData) {
+		return nil
+	}
+	w.sendErr = w.send(&pb.RelayResponse{StatusCode: http.StatusOK, Body: bytes.Clone(data)})
+	return w.sendErr
+}
+
+// finish sends the response held back, the error response of a stream which did not start
+func (w *streamWriter) finish() error {
+	if w.sendErr != nil {
+		return w.sendErr
+	}
+	if w.statusCode() == http.StatusOK {
+		rest := bytes.TrimSpace(w.body.Bytes())
+		if len(rest) == 0 {
+			return nil
+		}
+		if !bytes.HasPrefix(rest, dataPrefix) {
+			// the provider answered the stream with a complete response
+			return w.send(&pb.RelayResponse{StatusCode: http.StatusOK, Body: rest})
+		}
+		return w.sendEvent(rest)
+	}
+	return w.send(&pb.RelayResponse{StatusCode: int32(w.statusCode()), Body: w.body.Bytes()})
+}
+
+// Flush is called by the relay after every chunk, the chunks are sent as they are written
+func (w *streamWriter) Flush() {}
+
+// CloseNotify is used by the streams of some adaptors to stop once the client is gone
+func (w *streamWriter) CloseNotify() <-chan bool {
+	closed := make(chan bool, 1)
+	go func() {
+		<-w.ctx.Done()
+		closed <- true
+	}()
+	return closed
+}
+
+func (w *streamWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("a gRPC relay call can not be hijacked")
+}
@@ -0,0 +1,50 @@
+package grpcapi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/grpcapi/pb"
+)
+
+func collect(messages *[]*pb.RelayResponse) func(*pb.RelayResponse) error {
+	return func(message *pb.RelayResponse) error {
+		*messages = append(*messages, message)
+		return nil
+	}
+}
+
+func TestStreamWriterSendsEvents(t *testing.T) {
+	var messages []*pb.RelayResponse
+	w := newStreamWriter(context.Background(), collect(&messages))
+	_, _ = w.Write([]byte("data: {\"id\":1}\n\ndata: {\"id\""))
+	_, _ = w.Write([]byte(":2}\n\n: keep-alive\n\ndata: [DONE]\n\n"))
+	require.NoError(t, w.finish())
+	require.Len(t, messages, 2)
+	assert.Equal(t, `{"id":1}`, string(messages[0].Body))
+	assert.Equal(t, `{"id":2}`, string(messages[1].Body))
+}
+
+func TestStreamWriterHoldsBackErrors(t *testing.T) {
+	var messages []*pb.RelayResponse
+	w := newStreamWriter(context.Background(), collect(&messages))
+	w.WriteHeader(http.StatusTooManyRequests)
+	_, _ = w.Write([]byte(`{"error":{"message":"rate limited"}}`))
+	assert.Empty(t, messages)
+	require.NoError(t, w.finish())
+	require.Len(t, messages, 1)
+	assert.Equal(t, int32(http.StatusTooManyRequests), messages[0].StatusCode)
+	assert.Equal(t, `{"error":{"message":"rate limited"}}`, string(messages[0].Body))
+}
+
+func TestWithStream(t *testing.T) {
+	body, err := withStream([]byte(`{"model":"gpt-4o","seed":12345678901234567}`), true)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"gpt-4o","seed":12345678901234567,"stream":true}`, string(body))
+	_, err = withStream([]byte(`not json`), false)
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+// Package grpcapi serves the admin operations and the relay over gRPC, for the internal
+// platforms preferring it to the REST API. The relay calls go through the HTTP handler, so
+// they are authenticated, limited and billed as the HTTP requests.
+package grpcapi
+
+//go:generate protoc -I pb --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative oneapi.proto
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/songquanpeng/one-api/common/blacklist"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/grpcapi/pb"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const (
+	adminServicePrefix = "/oneapi.v1.AdminService/"
+	relayServicePrefix = "/oneapi.v1.RelayService/"
+)
+
+// adminPermissions are the permissions the admin calls require, as the REST API
+var adminPermissions = map[string]string{
+	pb.AdminService_ListChannels_FullMethodName:     model.PermissionChannelsRead,
+	pb.AdminService_GetChannel_FullMethodName:       model.PermissionChannelsRead,
+	pb.AdminService_SetChannelStatus_FullMethodName: model.PermissionChannelsWrite,
+	pb.AdminService_ListTokens_FullMethodName:       model.PermissionUsersRead,
+	pb.AdminService_GetToken_FullMethodName:         model.PermissionUsersRead,
+	pb.AdminService_SetTokenStatus_FullMethodName:   model.PermissionUsersWrite,
+	pb.AdminService_GetUsage_FullMethodName:         model.PermissionLogsRead,
+}
+
+var server *grpc.Server
+
+// Start serves the gRPC services on GRPC_PORT, the relay service with handler when
+// GRPC_RELAY_ENABLED is set
+func Start(handler http.Handler) {
+	if config.GrpcPort == 0 {
+		return
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", config.GrpcPort))
+	if err != nil {
+		logger.FatalLog("failed to listen for gRPC: " + err.Error())
+	}
+	server = grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(streamAuthInterceptor),
+	)
+	pb.RegisterAdminServiceServer(server, &adminServer{})
+	if config.GrpcRelayEnabled {
+		pb.RegisterRelayServiceServer(server, &relayServer{handler: handler})
+	}
+	go func() {
+		logger.SysLogf("gRPC server started on :%d", config.GrpcPort)
+		if err := server.Serve(listener); err != nil {
+			logger.FatalLog("failed to start gRPC server: " + err.Error())
+		}
+	}()
+}
+
+// Stop waits for the calls in progress up to timeout, then closes their connections
+func Stop(timeout time.Duration) {
+	if server == nil {
+		return
+	}
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		logger.SysError("gRPC drain timeout exceeded, closing the remaining connections")
+		server.Stop()
+	}
+}
+
+func authorization(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// authenticate checks the credentials of a call, the access token of a user with the
+// permission of the method for the admin service and a token for the relay service
+func authenticate(ctx context.Context, method string) error {
+	key := authorization(ctx)
+	if key == "" {
+		return status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+	switch {
+	case strings.HasPrefix(method, adminServicePrefix):
+		user := model.ValidateAccessToken(key)
+		if user == nil || user.Username == "" {
+			return status.Error(codes.Unauthenticated, "invalid access token")
+		}
+		if user.Status == model.UserStatusDisabled || blacklist.IsUserBanned(user.Id) {
+			return status.Error(codes.PermissionDenied, "user is banned")
+		}
+		if user.TenantId != model.DefaultTenantId {
+			// the admin calls are not scoped to a tenant
+			return status.Error(codes.PermissionDenied, "the admin service is only open to the admins of the installation")
+		}
+		permission, ok := adminPermissions[method]
+		if !ok {
+			return status.Error(codes.Unimplemented, "unknown method")
+		}
+		if !model.HasPermission(user.Id, user.Role, permission) {
+			return status.Error(codes.PermissionDenied, "permission "+permission+" required")
+		}
+	case strings.HasPrefix(method, relayServicePrefix):
+		key = strings.TrimPrefix(key, "Bearer ")
+		key = strings.TrimPrefix(key, "sk-")
+		if _, err := model.ValidateUserToken(strings.Split(key, "-")[0]); err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+	default:
+		return status.Error(codes.Unimplemented, "unknown service")
+	}
+	return nil
+}
+
+func unaryAuthInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := authenticate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func streamAuthInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := authenticate(stream.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
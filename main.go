@@ -1,26 +1,44 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
 	"github.com/gin-gonic/gin"
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/songquanpeng/one-api/cli"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/circuitbreaker"
 	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/i18n"
+	"github.com/songquanpeng/one-api/common/loadshed"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/scheduler"
+	"github.com/songquanpeng/one-api/common/shutdown"
+	"github.com/songquanpeng/one-api/common/storage"
+	"github.com/songquanpeng/one-api/common/tokenizer"
 	"github.com/songquanpeng/one-api/controller"
+	"github.com/songquanpeng/one-api/grpcapi"
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/automodel"
 	"github.com/songquanpeng/one-api/relay/cache"
-	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/router"
 )
 
@@ -28,6 +46,13 @@ import (
 var buildFS embed.FS
 
 func main() {
+	if len(os.Args) > 1 {
+		if os.Args[1] == "serve" {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		} else if cli.IsCommand(os.Args[1]) {
+			os.Exit(cli.Run(os.Args[1:]))
+		}
+	}
 	common.Init()
 	logger.SetupLogger()
 	logger.SysLogf("One API %s started", common.Version)
@@ -41,19 +66,29 @@ func main() {
 
 	// Initialize SQL Database
 	model.InitDB()
+	if *common.EncryptChannels {
+		count, err := model.EncryptChannels()
+		if err != nil {
+			logger.FatalLog("failed to encrypt channels: " + err.Error())
+		}
+		logger.SysLogf("%d channels encrypted", count)
+		os.Exit(0)
+	}
 	model.InitLogDB()
+	model.InitLogSinks()
+	model.InitUsageRollup()
+	model.InitResponseStore()
+	if err := storage.Init(); err != nil {
+		logger.FatalLog("failed to initialize file storage: " + err.Error())
+	}
+	model.InitFileStore()
 
 	var err error
 	err = model.CreateRootAccountIfNeed()
 	if err != nil {
 		logger.FatalLog("database init error: " + err.Error())
 	}
-	defer func() {
-		err := model.CloseDB()
-		if err != nil {
-			logger.FatalLog("failed to close database: " + err.Error())
-		}
-	}()
+	registerShutdownHooks()
 
 	// Initialize Redis
 	err = common.InitRedisClient()
@@ -63,6 +98,55 @@ func main() {
 
 	// Initialize options
 	model.InitOptionMap()
+	if *common.ExportConfig != "" || *common.ApplyConfig != "" {
+		if err := runConfigCommand(); err != nil {
+			logger.FatalLog(err.Error())
+		}
+		os.Exit(0)
+	}
+	model.InitRateLimitCache()
+	go model.SyncRateLimitCache(config.SyncFrequency)
+	model.InitDebugCaptureCache()
+	go model.SyncDebugCaptureCache(config.SyncFrequency)
+	model.InitModerationCache()
+	go model.SyncModerationCache(config.SyncFrequency)
+	model.InitWebhookCache()
+	go model.SyncWebhookCache(config.SyncFrequency)
+	model.InitModelMetaCache()
+	go model.SyncModelMetaCache(config.SyncFrequency)
+	model.InitModelAliasCache()
+	go model.SyncModelAliasCache(config.SyncFrequency)
+	model.InitPromptTemplateCache()
+	go model.SyncPromptTemplateCache(config.SyncFrequency)
+	model.InitExperimentCache()
+	go model.SyncExperimentCache(config.SyncFrequency)
+	model.InitTrafficMirrorCache()
+	go model.SyncTrafficMirrorCache(config.SyncFrequency)
+	model.InitChaosRuleCache()
+	go model.SyncChaosRuleCache(config.SyncFrequency)
+	model.InitPluginCache()
+	go model.SyncPluginCache(config.SyncFrequency)
+	model.InitRBACCache()
+	go model.SyncRBACCache(config.SyncFrequency)
+	model.InitTenantCache()
+	go model.SyncTenantCache(config.SyncFrequency)
+	model.InitPromoCache()
+	go model.SyncPromoCache(config.SyncFrequency)
+	circuitbreaker.OnChannelStateChange = monitor.ChannelBreakerStateChanged
+	model.OnCanaryRolledBack = monitor.CanaryRolledBack
+	model.InitCanaryCache()
+	go model.SyncCanaries(config.CanaryCheckFrequency)
+	if config.LoadSheddingEnabled {
+		memoryThreshold := uint64(config.LoadSheddingMemoryThreshold) << 20
+		if memoryThreshold == 0 {
+			memoryThreshold = loadshed.DefaultMemoryThreshold()
+		}
+		loadshed.Start(loadshed.Settings{
+			CPUPercent:  config.LoadSheddingCPUThreshold,
+			MemoryBytes: memoryThreshold,
+			Goroutines:  config.LoadSheddingGoroutineThreshold,
+		})
+	}
 	logger.SysLog(fmt.Sprintf("using theme %s", config.Theme))
 	if common.RedisEnabled {
 		// for compatibility with old versions
@@ -84,12 +168,39 @@ func main() {
 		cache.InitSemanticCache()
 		logger.SysLog("semantic cache enabled")
 	}
+
+	// Apply the settings changed at runtime, here or on another replica
+	automodel.Init()
+	model.OnSettingChange("AutoModelEnabled", func(string) { automodel.Init() })
+	model.OnSettingChange("ResponseCacheEnabled", func(value string) {
+		if value == "true" {
+			cache.InitResponseCache()
+		}
+	})
+	model.OnSettingChange("SemanticCacheEnabled", func(value string) {
+		if value == "true" {
+			cache.InitSemanticCache()
+		}
+	})
+	go model.SyncSettings(config.SettingsSyncFrequency)
+	if common.RedisEnabled {
+		go model.SubscribeInvalidations()
+	}
 	
 	if config.MemoryCacheEnabled {
 		logger.SysLog("sync frequency: " + strconv.Itoa(config.SyncFrequency))
 		go model.SyncOptions(config.SyncFrequency)
 		go model.SyncChannelCache(config.SyncFrequency)
 	}
+	if common.RedisEnabled && config.IsMasterNode {
+		go model.SyncPreConsumeJournal(config.SyncFrequency)
+	}
+	model.RunSelfCheck(context.Background())
+	model.RegisterJobs()
+	scheduler.Start()
+	if config.IsMasterNode && config.StatementEmailEnabled && config.UsageRollupEnabled {
+		go model.SyncStatementEmails()
+	}
 	if os.Getenv("CHANNEL_TEST_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_TEST_FREQUENCY"))
 		if err != nil {
@@ -105,7 +216,7 @@ func main() {
 	if config.EnableMetric {
 		logger.SysLog("metric enabled, will disable channel if too much request failed")
 	}
-	openai.InitTokenEncoders()
+	tokenizer.Init()
 	client.Init()
 
 	// Initialize i18n
@@ -126,13 +237,132 @@ func main() {
 	server.Use(sessions.Sessions("session", store))
 
 	router.SetRouter(server, buildFS)
+	controller.InitBatchRunner(server)
+	controller.InitDeferredRunner(server)
+	grpcapi.Start(server)
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
 	}
-	logger.SysLogf("server started on http://localhost:%s", port)
-	err = server.Run(":" + port)
+	httpServer := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
+	}
+	go func() {
+		logger.SysLogf("server started on http://localhost:%s", port)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	grpcapi.Stop(time.Duration(config.ShutdownDrainTimeout) * time.Second)
+	shutdown.Shutdown(httpServer)
+}
+
+// runConfigCommand exports the gateway config to the file of --export-config or applies the
+// one of --apply-config, printing the changes
+func runConfigCommand() error {
+	if *common.ExportConfig != "" {
+		cfg, err := model.ExportGatewayConfig()
+		if err != nil {
+			return fmt.Errorf("failed to export the config: %w", err)
+		}
+		format := "yaml"
+		if strings.HasSuffix(*common.ExportConfig, ".json") {
+			format = "json"
+		}
+		data, err := model.MarshalGatewayConfig(cfg, format)
+		if err != nil {
+			return fmt.Errorf("failed to export the config: %w", err)
+		}
+		return os.WriteFile(*common.ExportConfig, data, 0600)
+	}
+	var data []byte
+	var err error
+	if *common.ApplyConfig == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*common.ApplyConfig)
+	}
 	if err != nil {
-		logger.FatalLog("failed to start HTTP server: " + err.Error())
+		return fmt.Errorf("failed to read the config: %w", err)
 	}
+	cfg, err := model.UnmarshalGatewayConfig(data)
+	if err != nil {
+		return err
+	}
+	changes, err := model.ApplyGatewayConfig(cfg, *common.DryRun)
+	if err != nil {
+		return fmt.Errorf("failed to apply the config: %w", err)
+	}
+	for _, change := range changes {
+		line := fmt.Sprintf("%s %s %s", change.Action, change.Kind, change.Name)
+		if len(change.Fields) > 0 {
+			line += " (" + strings.Join(change.Fields, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+	if len(changes) == 0 {
+		fmt.Println("no changes")
+	} else if *common.DryRun {
+		fmt.Printf("%d changes planned, none made\n", len(changes))
+	}
+	return nil
+}
+
+// registerShutdownHooks stops the subsystems in order once the in-flight requests are drained
+func registerShutdownHooks() {
+	breakerStateFile := filepath.Join(logger.LogDir, "breaker-state.json")
+	if err := circuitbreaker.GetChannelBreakerManager().LoadState(breakerStateFile); err != nil && !os.IsNotExist(err) {
+		logger.SysError("failed to restore circuit breaker state: " + err.Error())
+	}
+
+	shutdown.Register(shutdown.PhaseFlush, "log batcher", func(ctx context.Context) error {
+		model.StopLogBatcher()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseFlush, "log sinks", model.FlushLogSinks)
+	shutdown.Register(shutdown.PhaseFlush, "usage rollups", func(ctx context.Context) error {
+		model.FlushUsageRollups()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseFlush, "batch updater", func(ctx context.Context) error {
+		model.FlushBatchUpdate()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseFlush, "model alias hits", func(ctx context.Context) error {
+		model.FlushModelAliasHits()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseFlush, "experiment results", func(ctx context.Context) error {
+		model.FlushExperimentStats()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseFlush, "traffic mirror results", func(ctx context.Context) error {
+		model.FlushTrafficMirrorStats()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseFlush, "canary outcomes", func(ctx context.Context) error {
+		model.FlushCanaryOutcomes()
+		return nil
+	})
+	shutdown.Register(shutdown.PhasePersist, "circuit breakers", func(ctx context.Context) error {
+		return circuitbreaker.GetChannelBreakerManager().SaveState(breakerStateFile)
+	})
+	shutdown.Register(shutdown.PhaseClose, "connection pools", func(ctx context.Context) error {
+		client.GetPoolManager().CloseIdleConnections()
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseClose, "redis", func(ctx context.Context) error {
+		if closer, ok := common.RDB.(io.Closer); ok && common.RedisEnabled {
+			return closer.Close()
+		}
+		return nil
+	})
+	shutdown.Register(shutdown.PhaseClose, "database", func(ctx context.Context) error {
+		return model.CloseDB()
+	})
 }
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+)
+
+func abortWithAnthropicMessage(c *gin.Context, statusCode int, message string) {
+	c.JSON(statusCode, anthropic.InboundError(statusCode, helper.MessageWithRequestId(message, c.GetString(helper.RequestIdKey))))
+	c.Abort()
+	logger.Error(c.Request.Context(), message)
+}
+
+// AnthropicMessages serves the Anthropic messages API, the request is converted to a
+// chat completion request relayed as usual and the response converted back. It must
+// run before TokenAuth, which is given the x-api-key header.
+func AnthropicMessages() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if c.Request.Header.Get("Authorization") == "" && c.Request.Header.Get("x-api-key") != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+c.Request.Header.Get("x-api-key"))
+		}
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			abortWithAnthropicMessage(c, http.StatusBadRequest, "failed to read request body: "+err.Error())
+			return
+		}
+		keepSignedRequest(c, requestBody)
+		var inboundRequest anthropic.InboundRequest
+		if err = json.Unmarshal(requestBody, &inboundRequest); err != nil {
+			abortWithAnthropicMessage(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		openaiRequest, err := anthropic.ConvertInboundRequest(&inboundRequest)
+		if err != nil {
+			abortWithAnthropicMessage(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		convertedBody, err := json.Marshal(openaiRequest)
+		if err != nil {
+			abortWithAnthropicMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Set(ctxkey.KeyRequestBody, convertedBody)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(convertedBody))
+		c.Request.ContentLength = int64(len(convertedBody))
+		// the relay mode and the upstream urls are derived from the path
+		c.Request.URL.Path = "/v1/chat/completions"
+
+		writer := anthropic.NewInboundResponseWriter(c.Writer, inboundRequest.Model)
+		c.Writer = writer
+		c.Next()
+		writer.Finish()
+	}
+}
@@ -7,12 +7,21 @@ import (
 	"github.com/songquanpeng/one-api/common/blacklist"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/network"
+	"github.com/songquanpeng/one-api/common/priority"
 	"github.com/songquanpeng/one-api/model"
 	"net/http"
 	"strings"
 )
 
 func authHelper(c *gin.Context, minRole int) {
+	authorize(c, func(id int, role int) bool {
+		return role >= minRole
+	})
+}
+
+// authorize authenticates the user by the session or the access token, and lets the request
+// through if allowed
+func authorize(c *gin.Context, allowed func(id int, role int) bool) {
 	session := sessions.Default(c)
 	username := session.Get("username")
 	role := session.Get("role")
@@ -56,7 +65,25 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
-	if role.(int) < minRole {
+	tenantId, err := model.CacheGetUserTenantId(id.(int))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		c.Abort()
+		return
+	}
+	if !model.CacheIsTenantEnabled(tenantId) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "租户已被禁用",
+		})
+		c.Abort()
+		return
+	}
+	c.Set(ctxkey.TenantId, tenantId)
+	if !allowed(id.(int), role.(int)) {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": "无权进行此操作，权限不足",
@@ -67,6 +94,10 @@ func authHelper(c *gin.Context, minRole int) {
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
+	// the user rules of the endpoint rate limits could not be checked before authentication
+	if !checkEndpointRateLimits(c) {
+		return
+	}
 	c.Next()
 }
 
@@ -101,12 +132,35 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithMessage(c, http.StatusUnauthorized, err.Error())
 			return
 		}
+		if token.RequireSignature {
+			if err := verifyTokenSignature(c, token); err != nil {
+				abortWithMessage(c, http.StatusUnauthorized, err.Error())
+				return
+			}
+		}
 		if token.Subnet != nil && *token.Subnet != "" {
 			if !network.IsIpInSubnets(ctx, c.ClientIP(), *token.Subnet) {
 				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌只能在指定网段使用：%s，当前 ip：%s", *token.Subnet, c.ClientIP()))
 				return
 			}
 		}
+		if token.Endpoints != nil && *token.Endpoints != "" {
+			if endpoint := tokenEndpoint(c.Request.URL.Path); endpoint != "" && !isInList(endpoint, *token.Endpoints) {
+				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权访问 %s 接口，允许的接口：%s", endpoint, *token.Endpoints))
+				return
+			}
+		}
+		if token.Groups != nil && *token.Groups != "" {
+			userGroup, err := model.CacheGetUserGroup(token.UserId)
+			if err != nil {
+				abortWithMessage(c, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !isInList(userGroup, *token.Groups) {
+				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌只能在分组 %s 中使用，当前分组：%s", *token.Groups, userGroup))
+				return
+			}
+		}
 		userEnabled, err := model.CacheIsUserEnabled(token.UserId)
 		if err != nil {
 			abortWithMessage(c, http.StatusInternalServerError, err.Error())
@@ -116,22 +170,58 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		if !model.CacheIsTenantEnabled(token.TenantId) {
+			abortWithMessage(c, http.StatusForbidden, "租户已被禁用")
+			return
+		}
+		if token.OrganizationId != 0 {
+			if err := model.ValidateOrganizationToken(token); err != nil {
+				abortWithMessage(c, http.StatusForbidden, err.Error())
+				return
+			}
+		}
+		model.RecordTokenUse(token, c.ClientIP())
+		setQuotaHeader(c, token)
+		if err := applyRequestDefaults(c, token); err != nil {
+			abortWithMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
 		requestModel, err := getRequestModel(c)
 		if err != nil && shouldCheckModel(c) {
 			abortWithMessage(c, http.StatusBadRequest, err.Error())
 			return
 		}
+		if requestModel != "" {
+			requestModel = applyModelAlias(c, requestModel)
+		}
 		c.Set(ctxkey.RequestModel, requestModel)
 		if token.Models != nil && *token.Models != "" {
 			c.Set(ctxkey.AvailableModels, *token.Models)
-			if requestModel != "" && !isModelInList(requestModel, *token.Models) {
-				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s", requestModel))
+			if requestModel != "" && !model.IsModelAllowed(requestModel, *token.Models) {
+				abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("该令牌无权使用模型：%s，允许的模型：%s", requestModel, *token.Models))
 				return
 			}
 		}
 		c.Set(ctxkey.Id, token.UserId)
 		c.Set(ctxkey.TokenId, token.Id)
 		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenKey, token.Key)
+		c.Set(ctxkey.OrganizationId, token.OrganizationId)
+		c.Set(ctxkey.TenantId, token.TenantId)
+		c.Set(ctxkey.StructuredOutput, token.StructuredOutput)
+		c.Set(ctxkey.MaxStreamDuration, token.MaxStreamDuration)
+		c.Set(ctxkey.PromptTemplateId, token.PromptTemplateId)
+		c.Set(ctxkey.PostProcessors, token.PostProcessors)
+		c.Set(ctxkey.Priority, priority.Of(token.Priority))
+		if model.IsDeferred(c.Request.Context()) {
+			// the deferred requests give way to every other request, on the cheapest channels
+			c.Set(ctxkey.Priority, priority.Batch)
+			c.Set(ctxkey.SelectionStrategy, "cost")
+		}
+		if requestModel != "" {
+			requestModel = applyExperiment(c, token, requestModel)
+			c.Set(ctxkey.RequestModel, requestModel)
+		}
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set(ctxkey.SpecificChannelId, parts[1])
@@ -163,5 +253,8 @@ func shouldCheckModel(c *gin.Context) bool {
 	if strings.HasPrefix(c.Request.URL.Path, "/v1/audio") {
 		return true
 	}
+	if c.Request.URL.Path == "/v1/responses" {
+		return true
+	}
 	return false
 }
@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/network"
+)
+
+// RateLimitDimension identifies the request attribute a limit is keyed on
+type RateLimitDimension string
+
+const (
+	RateLimitDimensionIP    RateLimitDimension = "ip"
+	RateLimitDimensionToken RateLimitDimension = "token"
+	RateLimitDimensionUser  RateLimitDimension = "user"
+)
+
+// RateLimitRule is an independent limit enforced on a single dimension
+type RateLimitRule struct {
+	Dimension     RateLimitDimension
+	MaxRequestNum int
+	Duration      int64 // unit is second
+}
+
+// rate returns the allowed requests per second, lower means stricter
+func (r RateLimitRule) rate() float64 {
+	return float64(r.MaxRequestNum) / float64(r.Duration)
+}
+
+// clientIP resolves the client address using the trusted proxies of the IP policy
+func clientIP(c *gin.Context) string {
+	policy := network.GetIPPolicy()
+	if !policy.HasTrustedProxies() {
+		return c.ClientIP()
+	}
+	return policy.ClientIP(c.RemoteIP(), c.GetHeader("X-Forwarded-For"))
+}
+
+// ipRateLimitSubject returns the client IP and its effective limit,
+// ok is false when the request is exempt from IP based limits. The token prefix
+// exemptions only apply to the token validated by TokenAuth, never to the raw
+// Authorization header, so the limiters running before auth ignore them.
+func ipRateLimitSubject(c *gin.Context, maxRequestNum int) (ip string, limit int, ok bool) {
+	policy := network.GetIPPolicy()
+	ip = clientIP(c)
+	if policy.IsExempt(ip, c.GetString(ctxkey.TokenKey)) {
+		return ip, maxRequestNum, false
+	}
+	limit = int(float64(maxRequestNum) * policy.Multiplier(ip))
+	if limit < 1 {
+		limit = 1
+	}
+	return ip, limit, true
+}
+
+// rateLimitSubject returns the value of the dimension for the current request along with
+// the rule adjusted by the IP policy, an empty subject means the rule is skipped
+// (e.g. token before TokenAuth, or an exempt IP)
+func rateLimitSubject(c *gin.Context, rule RateLimitRule) (string, RateLimitRule) {
+	switch rule.Dimension {
+	case RateLimitDimensionIP:
+		ip, limit, ok := ipRateLimitSubject(c, rule.MaxRequestNum)
+		if ok {
+			rule.MaxRequestNum = limit
+			return ip, rule
+		}
+	case RateLimitDimensionToken:
+		if tokenId := c.GetInt(ctxkey.TokenId); tokenId != 0 {
+			return strconv.Itoa(tokenId), rule
+		}
+	case RateLimitDimensionUser:
+		if userId := c.GetInt(ctxkey.Id); userId != 0 {
+			return strconv.Itoa(userId), rule
+		}
+	}
+	return "", rule
+}
+
+// rateLimitCheck records one request against key using the given algorithm and reports the limiter state
+func rateLimitCheck(ctx context.Context, algorithm string, key string, maxRequestNum int, duration int64) (allowed bool, remaining int, resetAt time.Time) {
+	if common.RedisEnabled {
+		window := time.Duration(duration) * time.Second
+		var result *common.RateLimitResult
+		var err error
+		if algorithm == common.RateLimitAlgorithmGCRA {
+			result, err = common.GCRARateLimit(ctx, key, maxRequestNum, window)
+		} else {
+			result, err = common.SlidingWindowRateLimit(ctx, key, maxRequestNum, window)
+		}
+		if err != nil {
+			logger.Error(ctx, "Redis rate limit error: "+err.Error())
+			// Fail open on error
+			return true, maxRequestNum - 1, time.Now().Add(window)
+		}
+		return result.Allowed, result.Remaining, result.ResetAt
+	}
+	var reset int64
+	if algorithm == common.RateLimitAlgorithmGCRA {
+		allowed, remaining, reset = shardedRateLimiter.GCRARequestWithInfo(key, maxRequestNum, duration)
+	} else {
+		allowed, remaining, reset = shardedRateLimiter.RequestWithInfo(key, maxRequestNum, duration)
+	}
+	return allowed, remaining, time.Unix(reset, 0)
+}
+
+// rateLimitUndo takes back a request recorded by rateLimitCheck
+func rateLimitUndo(ctx context.Context, algorithm string, key string, maxRequestNum int, duration int64) {
+	if common.RedisEnabled {
+		var err error
+		if algorithm == common.RateLimitAlgorithmGCRA {
+			err = common.UndoGCRARateLimit(ctx, key, maxRequestNum, time.Duration(duration)*time.Second)
+		} else {
+			err = common.UndoSlidingWindowRateLimit(ctx, key)
+		}
+		if err != nil {
+			logger.Error(ctx, "Redis rate limit undo error: "+err.Error())
+		}
+		return
+	}
+	if algorithm == common.RateLimitAlgorithmGCRA {
+		shardedRateLimiter.GCRAUndoRequest(key, maxRequestNum, duration)
+	} else {
+		shardedRateLimiter.UndoRequest(key)
+	}
+}
+
+func abortWithRateLimit(c *gin.Context, rule RateLimitRule, resetAt time.Time) {
+	c.Header("X-RateLimit-Limit", strconv.Itoa(rule.MaxRequestNum))
+	c.Header("X-RateLimit-Remaining", "0")
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+	c.Header("Retry-After", strconv.FormatInt(int64(time.Until(resetAt).Seconds())+1, 10))
+	setRequestLimitHeaders(c, rule.MaxRequestNum, 0, resetAt)
+	message := fmt.Sprintf("rate limit exceeded on %s: %d requests per %d seconds", rule.Dimension, rule.MaxRequestNum, rule.Duration)
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"message":   helper.MessageWithRequestId(message, c.GetString(helper.RequestIdKey)),
+			"type":      "one_api_error",
+			"code":      "rate_limit_exceeded",
+			"dimension": rule.Dimension,
+		},
+		"request_id": c.GetString(helper.RequestIdKey),
+	})
+	c.Abort()
+	logger.Warn(c.Request.Context(), message)
+}
+
+// CompositeRateLimit enforces several dimensions at once, each with its own limit.
+// Rules are evaluated from the strictest to the loosest and the first violation
+// aborts the request, reporting the dimension that triggered in the 429 body. The
+// request is taken back from the dimensions it passed, so rejected requests do not
+// use up their limits.
+func CompositeRateLimit(mark string, rules ...RateLimitRule) func(c *gin.Context) {
+	activeRules := make([]RateLimitRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.MaxRequestNum > 0 && rule.Duration > 0 {
+			activeRules = append(activeRules, rule)
+		}
+	}
+	if len(activeRules) == 0 || config.DebugEnabled {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	sort.SliceStable(activeRules, func(i, j int) bool {
+		return activeRules[i].rate() < activeRules[j].rate()
+	})
+	if !common.RedisEnabled {
+		shardedRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+	}
+	algorithm := rateLimitAlgorithm(mark)
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		var tightestRule *RateLimitRule
+		var tightestRemaining int
+		var tightestResetAt time.Time
+		type hit struct {
+			key  string
+			rule RateLimitRule
+		}
+		hits := make([]hit, 0, len(activeRules))
+		for i := range activeRules {
+			subject, rule := rateLimitSubject(c, activeRules[i])
+			if subject == "" {
+				continue
+			}
+			key := fmt.Sprintf("%s:%s:%s", mark, rule.Dimension, subject)
+			allowed, remaining, resetAt := rateLimitCheck(ctx, algorithm, key, rule.MaxRequestNum, rule.Duration)
+			if !allowed {
+				for _, hit := range hits {
+					rateLimitUndo(ctx, algorithm, hit.key, hit.rule.MaxRequestNum, hit.rule.Duration)
+				}
+				abortWithRateLimit(c, rule, resetAt)
+				return
+			}
+			hits = append(hits, hit{key: key, rule: rule})
+			if tightestRule == nil || remaining < tightestRemaining {
+				tightestRule = &rule
+				tightestRemaining = remaining
+				tightestResetAt = resetAt
+			}
+		}
+		if tightestRule != nil {
+			c.Header("X-RateLimit-Limit", strconv.Itoa(tightestRule.MaxRequestNum))
+			c.Header("X-RateLimit-Remaining", strconv.Itoa(tightestRemaining))
+			c.Header("X-RateLimit-Reset", strconv.FormatInt(tightestResetAt.Unix(), 10))
+			setRequestLimitHeaders(c, tightestRule.MaxRequestNum, tightestRemaining, tightestResetAt)
+		}
+		c.Next()
+	}
+}
+
+// RelayRateLimit returns middleware enforcing IP, token and user limits on relay requests,
+// it must be placed after TokenAuth so that token and user are known
+func RelayRateLimit() func(c *gin.Context) {
+	return CompositeRateLimit("RL",
+		RateLimitRule{Dimension: RateLimitDimensionIP, MaxRequestNum: config.RelayIPRateLimitNum, Duration: config.RelayIPRateLimitDuration},
+		RateLimitRule{Dimension: RateLimitDimensionToken, MaxRequestNum: config.RelayTokenRateLimitNum, Duration: config.RelayTokenRateLimitDuration},
+		RateLimitRule{Dimension: RateLimitDimensionUser, MaxRequestNum: config.RelayUserRateLimitNum, Duration: config.RelayUserRateLimitDuration},
+	)
+}
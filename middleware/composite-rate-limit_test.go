@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/network"
+)
+
+func setExemptTokenPrefix(t *testing.T) {
+	previous := network.IPPolicy2JSONString()
+	require.NoError(t, network.UpdateIPPolicyByJSONString(`{"exempt_token_prefixes": ["internal"]}`))
+	t.Cleanup(func() {
+		_ = network.UpdateIPPolicyByJSONString(previous)
+	})
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+}
+
+func rateLimitedStatuses(handlers []gin.HandlerFunc, authorization string, requests int) []int {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/limited", append(handlers, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})...)
+	statuses := make([]int, 0, requests)
+	for i := 0; i < requests; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = "198.51.100.7:1234"
+		req.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		statuses = append(statuses, w.Code)
+	}
+	return statuses
+}
+
+func TestPreAuthRateLimitIgnoresExemptTokenHeader(t *testing.T) {
+	setExemptTokenPrefix(t)
+	limiter := rateLimitFactoryOptimized(2, 60, "TEST-PREAUTH")
+	statuses := rateLimitedStatuses([]gin.HandlerFunc{limiter}, "Bearer sk-internal-forged", 3)
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests}, statuses)
+}
+
+func TestRateLimitHonorsValidatedExemptToken(t *testing.T) {
+	setExemptTokenPrefix(t)
+	tokenAuth := func(c *gin.Context) {
+		c.Set(ctxkey.TokenKey, "internal-validated")
+	}
+	limiter := CompositeRateLimit("TEST-AUTH", RateLimitRule{Dimension: RateLimitDimensionIP, MaxRequestNum: 2, Duration: 60})
+	statuses := rateLimitedStatuses([]gin.HandlerFunc{tokenAuth, limiter}, "Bearer sk-internal-validated", 3)
+	assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK}, statuses)
+}
+
+func TestCompositeRateLimitTakesBackRejectedRequests(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/limited", func(c *gin.Context) {
+		userId, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+		c.Set(ctxkey.Id, userId)
+	}, CompositeRateLimit("TEST-UNDO",
+		RateLimitRule{Dimension: RateLimitDimensionIP, MaxRequestNum: 2, Duration: 60},
+		RateLimitRule{Dimension: RateLimitDimensionUser, MaxRequestNum: 1, Duration: 60},
+	), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	request := func(ip string, userId int) int {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-User-Id", strconv.Itoa(userId))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, request("198.51.100.8", 1))
+	assert.Equal(t, http.StatusOK, request("198.51.100.8", 2))
+	// the user limit is checked first, the IP limit rejects the request
+	assert.Equal(t, http.StatusTooManyRequests, request("198.51.100.8", 3))
+	// so the rejected request did not use up the limit of the user
+	assert.Equal(t, http.StatusOK, request("198.51.100.9", 3))
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// captureWriter keeps the beginning of the response, streams included
+type captureWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *captureWriter) capture(data []byte) {
+	remain := w.limit - w.body.Len()
+	if remain < len(data) {
+		if remain < 0 {
+			remain = 0
+		}
+		data = data[:remain]
+		w.truncated = true
+	}
+	w.body.Write(data)
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.capture(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func truncateCapture(body []byte, limit int) (string, bool) {
+	if len(body) > limit {
+		return string(body[:limit]), true
+	}
+	return string(body), false
+}
+
+// DebugCapture stores the request and response bodies of the tokens and channels
+// with an active debug capture rule, it must run after Distribute
+func DebugCapture() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !model.DebugCaptureActive() {
+			c.Next()
+			return
+		}
+		startTime := time.Now()
+		limit := config.DebugCaptureMaxBodySize * 1024
+		requestBody, err := common.GetRequestBody(c)
+		if err == nil {
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		}
+		writer := &captureWriter{ResponseWriter: c.Writer, limit: limit}
+		c.Writer = writer
+		c.Next()
+
+		// the channel may have changed on retry, the last one served the response
+		if !model.CacheDebugCaptureEnabled(c.GetInt(ctxkey.TokenId), c.GetInt(ctxkey.ChannelId)) {
+			return
+		}
+		capturedRequest, requestTruncated := truncateCapture(requestBody, limit)
+		go model.RecordDebugCapture(&model.DebugCapture{
+			RequestId:    c.GetString(helper.RequestIdKey),
+			UserId:       c.GetInt(ctxkey.Id),
+			TokenId:      c.GetInt(ctxkey.TokenId),
+			ChannelId:    c.GetInt(ctxkey.ChannelId),
+			ModelName:    c.GetString(ctxkey.RequestModel),
+			Method:       c.Request.Method,
+			Path:         c.Request.URL.Path,
+			StatusCode:   writer.Status(),
+			ElapsedTime:  time.Since(startTime).Milliseconds(),
+			RequestBody:  capturedRequest,
+			ResponseBody: writer.body.String(),
+			Truncated:    requestTruncated || writer.truncated,
+		})
+	}
+}
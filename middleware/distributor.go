@@ -4,15 +4,18 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/trace"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/automodel"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 type ModelRequest struct {
@@ -25,6 +28,7 @@ func Distribute() func(c *gin.Context) {
 		userId := c.GetInt(ctxkey.Id)
 		userGroup, _ := model.CacheGetUserGroup(userId)
 		c.Set(ctxkey.Group, userGroup)
+		tenantId := c.GetInt(ctxkey.TenantId)
 		var requestModel string
 		var channel *model.Channel
 		channelId, ok := c.Get(ctxkey.SpecificChannelId)
@@ -35,7 +39,7 @@ func Distribute() func(c *gin.Context) {
 				return
 			}
 			channel, err = model.GetChannelById(id, true)
-			if err != nil {
+			if err != nil || channel.TenantId != tenantId {
 				abortWithMessage(c, http.StatusBadRequest, "无效的渠道 Id")
 				return
 			}
@@ -88,7 +92,7 @@ func Distribute() func(c *gin.Context) {
 					
 					// Get the channel and set up context
 					channel, err = model.GetChannelById(result.ChannelID, true)
-					if err == nil && channel != nil {
+					if err == nil && channel != nil && channel.TenantId == tenantId {
 						requestModel = result.SelectedModel
 						c.Set(ctxkey.RequestModel, requestModel)
 						
@@ -105,6 +109,7 @@ func Distribute() func(c *gin.Context) {
 							c.Set(ctxkey.AvailableChannels, 1)
 						}
 						
+						traceSelection(c, channel, requestModel)
 						SetupContextForSelectedChannel(c, channel, requestModel)
 						c.Next()
 						return
@@ -114,9 +119,23 @@ func Distribute() func(c *gin.Context) {
 				}
 			}
 			
+		// a select_channel plugin may choose the channel itself
+		if pluginChannel := selectPluginChannel(c, userGroup, requestModel); pluginChannel != nil {
+			c.Set(ctxkey.SelectionReason, "Selected by a select_channel plugin")
+			traceSelection(c, pluginChannel, requestModel)
+			SetupContextForSelectedChannel(c, pluginChannel, requestModel)
+			c.Next()
+			return
+		}
+
 		// For non-virtual models, use intelligent channel selection based on health
 		var err error
-		selectionInfo, err := model.CacheGetHealthiestChannel(userGroup, requestModel)
+		var selectionInfo *model.ChannelSelectionInfo
+		if strategy := c.GetString(ctxkey.SelectionStrategy); strategy != "" {
+			selectionInfo, err = model.CacheGetStrategyChannel(tenantId, userGroup, requestModel, strategy)
+		} else {
+			selectionInfo, err = model.CacheGetHealthiestChannel(tenantId, userGroup, requestModel)
+		}
 		
 		// Tracking variables
 		var healthScore float64
@@ -126,7 +145,7 @@ func Distribute() func(c *gin.Context) {
 		
 		if err != nil {
 			// Fallback to random if healthiest fails
-			channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, false)
+			channel, err = model.CacheGetRandomSatisfiedChannel(tenantId, userGroup, requestModel, false)
 			if err != nil {
 				message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, requestModel)
 				if channel != nil {
@@ -167,22 +186,63 @@ func Distribute() func(c *gin.Context) {
 		}
 	}
 
+		if acceptType := channeltype.ModeFilter(relaymode.GetByPath(c.Request.URL.Path)); acceptType != nil && !acceptType(channel.Type) {
+			if ok {
+				abortWithMessage(c, http.StatusBadRequest, "该渠道不支持此接口")
+				return
+			}
+			// the channel chosen may serve the model but not the endpoint, as audio or image edits
+			modeChannel, err := model.CacheGetRandomSatisfiedChannelOfType(tenantId, userGroup, requestModel, false, acceptType)
+			if err != nil {
+				abortWithMessage(c, http.StatusServiceUnavailable, fmt.Sprintf("当前分组 %s 下对于模型 %s 无支持此接口的可用渠道", userGroup, requestModel))
+				return
+			}
+			channel = modeChannel
+			c.Set(ctxkey.SelectionReason, "Random selection among the channels serving the endpoint")
+		}
+
 		logger.Debugf(ctx, "user id %d, user group: %s, request model: %s, using channel #%d", userId, userGroup, requestModel, channel.Id)
+		traceSelection(c, channel, requestModel)
 		SetupContextForSelectedChannel(c, channel, requestModel)
 		c.Next()
 	}
 }
 
+// traceSelection records the channel chosen for the request among the channels of the model,
+// with their scores
+func traceSelection(c *gin.Context, channel *model.Channel, requestModel string) {
+	ctx := c.Request.Context()
+	if trace.FromContext(ctx) == nil {
+		return
+	}
+	data := map[string]any{
+		"model":  requestModel,
+		"group":  c.GetString(ctxkey.Group),
+		"reason": c.GetString(ctxkey.SelectionReason),
+	}
+	if strategy := c.GetString(ctxkey.SelectionStrategy); strategy != "" {
+		data["strategy"] = strategy
+	}
+	if _, ok := c.Get(ctxkey.SpecificChannelId); !ok {
+		data["candidates"] = model.GetChannelCandidates(c.GetInt(ctxkey.TenantId), c.GetString(ctxkey.Group), requestModel, c.GetString(ctxkey.SelectionStrategy))
+	}
+	trace.Record(ctx, trace.StageSelection, channel.Id, fmt.Sprintf("selected channel #%d %s", channel.Id, channel.Name), data)
+}
+
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
 	c.Set(ctxkey.Channel, channel.Type)
 	c.Set(ctxkey.ChannelId, channel.Id)
 	c.Set(ctxkey.ChannelName, channel.Name)
+	c.Set(ctxkey.ChannelTags, strings.Join(channel.GetTags(), ","))
 	if channel.SystemPrompt != nil && *channel.SystemPrompt != "" {
 		c.Set(ctxkey.SystemPrompt, *channel.SystemPrompt)
 	}
+	if prompt := promptTemplateSystemPrompt(c, modelName); prompt != "" {
+		c.Set(ctxkey.SystemPrompt, prompt)
+	}
 	
 	// Get model mapping and track actual model
-	modelMapping := channel.GetModelMapping()
+	modelMapping := aliasModelMapping(c, channel.GetModelMapping(), modelName)
 	c.Set(ctxkey.ModelMapping, modelMapping)
 	
 	// Determine actual model after mapping
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// EndpointRateLimit enforces the per-endpoint limits managed through /api/ratelimits.
+// Rules are read from the in-memory cache, so changes apply without a redeploy.
+// IP rules follow the exemptions and overrides of the IP policy. Token and user rules
+// need the request to be authenticated, the ones skipped here are enforced by the auth
+// middlewares of the /api routes once they know the user.
+func EndpointRateLimit() func(c *gin.Context) {
+	if !common.RedisEnabled {
+		shardedRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+	}
+	return func(c *gin.Context) {
+		if !checkEndpointRateLimits(c) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// endpointLimitHit is the count a request took from an endpoint limit
+type endpointLimitHit struct {
+	key  string
+	rule RateLimitRule
+}
+
+// checkEndpointRateLimits records the request against the endpoint limits matching it whose
+// subject is known, each limit once per request, and aborts the request when one is exceeded.
+// A rejected request gives back what it took from the others, the ones counted before the
+// authentication included.
+func checkEndpointRateLimits(c *gin.Context) bool {
+	if config.DebugEnabled {
+		return true
+	}
+	rateLimits := model.CacheGetMatchedRateLimits(c.Request.Method, c.Request.URL.Path)
+	if len(rateLimits) == 0 {
+		return true
+	}
+	value, _ := c.Get(ctxkey.EndpointLimits)
+	checked, _ := value.(map[int]endpointLimitHit)
+	if checked == nil {
+		checked = make(map[int]endpointLimitHit, len(rateLimits))
+		c.Set(ctxkey.EndpointLimits, checked)
+	}
+	ctx := c.Request.Context()
+	algorithm := rateLimitAlgorithm("EP")
+	for _, rateLimit := range rateLimits {
+		if _, ok := checked[rateLimit.Id]; ok {
+			continue
+		}
+		subject, rule := rateLimitSubject(c, RateLimitRule{
+			Dimension:     RateLimitDimension(rateLimit.Dimension),
+			MaxRequestNum: rateLimit.Limit,
+			Duration:      rateLimit.Window,
+		})
+		if subject == "" {
+			continue
+		}
+		key := fmt.Sprintf("EP:%d:%s:%s", rateLimit.Id, rule.Dimension, subject)
+		allowed, remaining, resetAt := rateLimitCheck(ctx, algorithm, key, rule.MaxRequestNum, rule.Duration)
+		if !allowed {
+			for _, hit := range checked {
+				rateLimitUndo(ctx, algorithm, hit.key, hit.rule.MaxRequestNum, hit.rule.Duration)
+			}
+			abortWithRateLimit(c, rule, resetAt)
+			return false
+		}
+		checked[rateLimit.Id] = endpointLimitHit{key: key, rule: rule}
+		setRequestLimitHeaders(c, rule.MaxRequestNum, remaining, resetAt)
+	}
+	return true
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestEndpointRateLimitTakesBackRejectedRequests(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/one-api.db"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.RateLimit{}))
+	previous := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		db.Where("1 = 1").Delete(&model.RateLimit{})
+		model.InitRateLimitCache()
+		model.DB = previous
+		common.RedisEnabled = redisEnabled
+	})
+	require.NoError(t, db.Create(&model.RateLimit{PathPattern: "/limited", Method: "*", Dimension: "ip", Limit: 2, Window: 60, Status: model.RateLimitStatusEnabled}).Error)
+	require.NoError(t, db.Create(&model.RateLimit{PathPattern: "/limited", Method: "*", Dimension: "user", Limit: 1, Window: 60, Status: model.RateLimitStatusEnabled}).Error)
+	model.InitRateLimitCache()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// the IP limit is counted before the authentication, the user limit once it knows the user
+	router.GET("/limited", EndpointRateLimit(), func(c *gin.Context) {
+		userId, _ := strconv.Atoi(c.GetHeader("X-User-Id"))
+		c.Set(ctxkey.Id, userId)
+		if !checkEndpointRateLimits(c) {
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	request := func(ip string, userId int) int {
+		req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-User-Id", strconv.Itoa(userId))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, request("198.51.100.10", 1))
+	// the user limit rejects the request, which gives back its count of the IP limit
+	assert.Equal(t, http.StatusTooManyRequests, request("198.51.100.11", 1))
+	assert.Equal(t, http.StatusOK, request("198.51.100.11", 2))
+	assert.Equal(t, http.StatusOK, request("198.51.100.11", 3))
+	assert.Equal(t, http.StatusTooManyRequests, request("198.51.100.11", 4))
+}
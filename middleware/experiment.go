@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// experimentRegenerationWindow is how long a prompt sent again by the same token counts as
+// a regeneration of the answer
+const experimentRegenerationWindow = 10 * time.Minute
+
+// applyExperiment puts the request in the arm of its token when an experiment runs for its
+// group and model, and returns the model to relay. The tokens not allowed the model of their
+// arm are kept out of the experiment.
+func applyExperiment(c *gin.Context, token *model.Token, requestModel string) string {
+	group, err := model.CacheGetUserGroup(token.UserId)
+	if err != nil {
+		return requestModel
+	}
+	experiment := model.CacheGetExperiment(group, requestModel)
+	if experiment == nil {
+		return requestModel
+	}
+	arm := experiment.ArmOf(token.Id)
+	if arm.Model != "" && token.Models != nil && *token.Models != "" && !model.IsModelAllowed(arm.Model, *token.Models) {
+		return requestModel
+	}
+	c.Set(ctxkey.ExperimentId, experiment.Id)
+	c.Set(ctxkey.ExperimentArm, arm.Name)
+	c.Header("X-Experiment", fmt.Sprintf("%s; arm=%s", experiment.Name, arm.Name))
+	if isRegeneration(c, token.Id) {
+		model.RecordExperimentRegeneration(experiment.Id, arm.Name)
+	}
+	if arm.PromptTemplateId != 0 {
+		c.Set(ctxkey.PromptTemplateId, arm.PromptTemplateId)
+	}
+	if arm.SelectionStrategy != "" {
+		c.Set(ctxkey.SelectionStrategy, arm.SelectionStrategy)
+	}
+	if arm.Model == "" || arm.Model == requestModel {
+		return requestModel
+	}
+	// the body still names the requested model, it is mapped like the model of an alias
+	if c.GetString(ctxkey.AliasedModel) == "" {
+		c.Set(ctxkey.AliasedModel, requestModel)
+	}
+	return arm.Model
+}
+
+var recentPrompts = make(map[[sha256.Size]byte]time.Time)
+var recentPromptsLock sync.Mutex
+var recentPromptsSweep time.Time
+
+// isRegeneration tells whether the token sent the same prompt in the last
+// experimentRegenerationWindow, as clients do to regenerate an answer
+func isRegeneration(c *gin.Context, tokenId int) bool {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return false
+	}
+	var prompt struct {
+		Messages json.RawMessage `json:"messages"`
+		Input    json.RawMessage `json:"input"`
+		Prompt   json.RawMessage `json:"prompt"`
+	}
+	if json.Unmarshal(requestBody, &prompt) != nil {
+		return false
+	}
+	if len(prompt.Messages) == 0 && len(prompt.Input) == 0 && len(prompt.Prompt) == 0 {
+		return false
+	}
+	hash := sha256.New()
+	_, _ = fmt.Fprintf(hash, "%d\n", tokenId)
+	hash.Write(prompt.Messages)
+	hash.Write(prompt.Input)
+	hash.Write(prompt.Prompt)
+	var key [sha256.Size]byte
+	copy(key[:], hash.Sum(nil))
+
+	now := time.Now()
+	recentPromptsLock.Lock()
+	defer recentPromptsLock.Unlock()
+	if now.Sub(recentPromptsSweep) > experimentRegenerationWindow {
+		for k, sentAt := range recentPrompts {
+			if now.Sub(sentAt) > experimentRegenerationWindow {
+				delete(recentPrompts, k)
+			}
+		}
+		recentPromptsSweep = now
+	}
+	sentAt, ok := recentPrompts[key]
+	recentPrompts[key] = now
+	return ok && now.Sub(sentAt) <= experimentRegenerationWindow
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+)
+
+func abortWithGeminiMessage(c *gin.Context, statusCode int, message string) {
+	c.JSON(statusCode, gemini.InboundError(statusCode, helper.MessageWithRequestId(message, c.GetString(helper.RequestIdKey))))
+	c.Abort()
+	logger.Error(c.Request.Context(), message)
+}
+
+// GeminiGenerateContent serves the Gemini generateContent and streamGenerateContent
+// methods of the /v1beta/models/*action route, the request is converted to a chat
+// completion request relayed as usual and the response converted back. It must run
+// before TokenAuth, which is given the x-goog-api-key header or the key parameter.
+func GeminiGenerateContent() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if c.Request.Header.Get("Authorization") == "" {
+			key := c.Request.Header.Get("x-goog-api-key")
+			if key == "" {
+				key = c.Query("key")
+			}
+			if key != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+key)
+			}
+		}
+		modelName, method, _ := strings.Cut(strings.TrimPrefix(c.Param("action"), "/"), ":")
+		var stream bool
+		switch method {
+		case "generateContent":
+		case "streamGenerateContent":
+			stream = true
+		default:
+			abortWithGeminiMessage(c, http.StatusNotFound, "unsupported method: "+method)
+			return
+		}
+		requestBody, err := common.GetRequestBody(c)
+		if err != nil {
+			abortWithGeminiMessage(c, http.StatusBadRequest, "failed to read request body: "+err.Error())
+			return
+		}
+		keepSignedRequest(c, requestBody)
+		var inboundRequest gemini.InboundRequest
+		if err = json.Unmarshal(requestBody, &inboundRequest); err != nil {
+			abortWithGeminiMessage(c, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		openaiRequest, err := gemini.ConvertInboundRequest(&inboundRequest, modelName, stream)
+		if err != nil {
+			abortWithGeminiMessage(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		convertedBody, err := json.Marshal(openaiRequest)
+		if err != nil {
+			abortWithGeminiMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		sse := c.Query("alt") == "sse"
+		c.Set(ctxkey.KeyRequestBody, convertedBody)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(convertedBody))
+		c.Request.ContentLength = int64(len(convertedBody))
+		// the relay mode and the upstream urls are derived from the path
+		c.Request.URL.Path = "/v1/chat/completions"
+		c.Request.URL.RawQuery = "" // may hold the key
+
+		writer := gemini.NewInboundResponseWriter(c.Writer, modelName, sse)
+		c.Writer = writer
+		c.Next()
+		writer.Finish()
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/loadshed"
+	"github.com/songquanpeng/one-api/common/priority"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// LoadShedding rejects relay requests with 429 while the process is overloaded, the
+// requests of the interactive tokens and of the priority groups only when the overload is
+// critical, those of the batch tokens as soon as the process nears its thresholds
+func LoadShedding() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !config.LoadSheddingEnabled {
+			c.Next()
+			return
+		}
+		if !loadshed.ShouldShed(shedPriority(c)) {
+			c.Next()
+			return
+		}
+		message := fmt.Sprintf("the server is overloaded (%s), please retry later", loadshed.CurrentLevel())
+		c.Header("Retry-After", strconv.Itoa(config.LoadSheddingRetryAfter))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": gin.H{
+				"message": helper.MessageWithRequestId(message, c.GetString(helper.RequestIdKey)),
+				"type":    "one_api_error",
+				"code":    "server_overloaded",
+			},
+			"request_id": c.GetString(helper.RequestIdKey),
+		})
+		c.Abort()
+	}
+}
+
+func shedPriority(c *gin.Context) loadshed.Priority {
+	switch c.GetString(ctxkey.Priority) {
+	case priority.Interactive:
+		return loadshed.PriorityHigh
+	case priority.Batch:
+		return loadshed.PriorityBatch
+	}
+	if config.LoadSheddingPriorityGroups != "" && loadshed.CurrentLevel() != loadshed.LevelNormal {
+		userGroup, _ := model.CacheGetUserGroup(c.GetInt(ctxkey.Id))
+		if isInList(userGroup, config.LoadSheddingPriorityGroups) {
+			return loadshed.PriorityHigh
+		}
+	}
+	return loadshed.PriorityLow
+}
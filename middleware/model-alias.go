@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
+)
+
+// applyModelAlias returns the model an alias redirects the requested model to, so the
+// token restrictions and the channel selection see the target model
+func applyModelAlias(c *gin.Context, requestModel string) string {
+	alias := model.CacheResolveModelAlias(requestModel)
+	if alias == nil {
+		return requestModel
+	}
+	model.RecordModelAliasHit(alias.Id)
+	monitor.GetMetricsCollector().RecordModelAliasHit(alias.Pattern, alias.Target)
+	c.Set(ctxkey.AliasedModel, requestModel)
+	c.Header("X-Model-Alias", fmt.Sprintf("%s -> %s", requestModel, alias.Target))
+	if alias.SunsetTime > 0 {
+		c.Header("Sunset", time.Unix(alias.SunsetTime, 0).UTC().Format(http.TimeFormat))
+	}
+	if alias.Warning != "" {
+		c.Header("Warning", fmt.Sprintf("299 - %q", alias.Warning))
+	}
+	return alias.Target
+}
+
+// aliasModelMapping adds the model requested under an alias to the model mapping of a
+// channel, the request body still names it
+func aliasModelMapping(c *gin.Context, modelMapping map[string]string, modelName string) map[string]string {
+	aliasedModel := c.GetString(ctxkey.AliasedModel)
+	if aliasedModel == "" {
+		return modelMapping
+	}
+	aliasMapping := make(map[string]string, len(modelMapping)+1)
+	for from, to := range modelMapping {
+		aliasMapping[from] = to
+	}
+	aliasMapping[aliasedModel] = modelName
+	if mapped, ok := modelMapping[modelName]; ok {
+		aliasMapping[aliasedModel] = mapped
+	}
+	return aliasMapping
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// RequirePermission authenticates the user and requires a permission of the admin APIs
+func RequirePermission(permission string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		authorize(c, func(id int, role int) bool {
+			return model.HasPermission(id, role, permission) && tenantAllowed(c, c.GetInt(ctxkey.TenantId))
+		})
+	}
+}
+
+// PermissionAuth authenticates the user and requires the read permission of a resource for
+// the GET requests, its write permission for the others
+func PermissionAuth(resource string) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		permission := resource + ":write"
+		if c.Request.Method == http.MethodGet {
+			permission = resource + ":read"
+		}
+		authorize(c, func(id int, role int) bool {
+			return model.HasPermission(id, role, permission) && tenantAllowed(c, c.GetInt(ctxkey.TenantId))
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/plugin"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// PluginContext describes a relay request to the plugins, as their context global
+func PluginContext(c *gin.Context) map[string]any {
+	return map[string]any{
+		"group":      c.GetString(ctxkey.Group),
+		"user_id":    c.GetInt(ctxkey.Id),
+		"token_id":   c.GetInt(ctxkey.TokenId),
+		"token_name": c.GetString(ctxkey.TokenName),
+		"model":      c.GetString(ctxkey.RequestModel),
+		"path":       c.Request.URL.Path,
+	}
+}
+
+// RunPlugin runs the script of a plugin within PLUGIN_TIMEOUT. The errors of the script are
+// logged and returned, a script calling reject returns a *plugin.Rejection.
+func RunPlugin(c *gin.Context, p *model.Plugin, globals map[string]any, outputs ...string) (any, map[string]any, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(config.PluginTimeout)*time.Millisecond)
+	defer cancel()
+	result, values, err := plugin.Run(ctx, p.Proto(), globals, outputs...)
+	var rejection *plugin.Rejection
+	if err != nil && !errors.As(err, &rejection) {
+		logger.Errorf(c.Request.Context(), "plugin %s failed, skipping it: %s", p.Name, err.Error())
+	}
+	return result, values, err
+}
+
+// selectPluginChannel returns the channel a select_channel plugin chooses among the channels
+// serving the model and the endpoint, nil when the plugins leave the choice to the selection
+func selectPluginChannel(c *gin.Context, group string, modelName string) *model.Channel {
+	plugins := model.CacheGetPlugins(model.PluginHookSelectChannel, group)
+	if len(plugins) == 0 {
+		return nil
+	}
+	acceptType := channeltype.ModeFilter(relaymode.GetByPath(c.Request.URL.Path))
+	tracker := model.GetHealthTracker()
+	var channels []*model.Channel
+	var candidates []any
+	for _, channel := range model.CacheGetChannels(c.GetInt(ctxkey.TenantId), group, modelName) {
+		if acceptType != nil && !acceptType(channel.Type) {
+			continue
+		}
+		weight := 0
+		if channel.Weight != nil {
+			weight = int(*channel.Weight)
+		}
+		candidate := map[string]any{
+			"id":        channel.Id,
+			"name":      channel.Name,
+			"type":      channel.Type,
+			"priority":  channel.GetPriority(),
+			"weight":    weight,
+			"throttled": tracker.IsThrottled(channel.Id),
+		}
+		if health := tracker.GetHealth(channel.Id); health != nil {
+			candidate["success_rate"] = health.SuccessRate()
+			candidate["avg_latency"] = health.AvgLatency().Milliseconds()
+		}
+		channels = append(channels, channel)
+		candidates = append(candidates, candidate)
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+	for _, p := range plugins {
+		result, _, err := RunPlugin(c, p, map[string]any{"context": PluginContext(c), "channels": candidates})
+		if err != nil {
+			continue
+		}
+		id, ok := result.(int64)
+		if !ok {
+			continue
+		}
+		for _, channel := range channels {
+			if int64(channel.Id) == id {
+				c.Set(ctxkey.SelectionReason, fmt.Sprintf("Selected by plugin %s", p.Name))
+				c.Set(ctxkey.AvailableChannels, len(channels))
+				return channel
+			}
+		}
+		logger.Warnf(c.Request.Context(), "plugin %s selected channel #%d, which does not serve %s", p.Name, id, modelName)
+	}
+	return nil
+}
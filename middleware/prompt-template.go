@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// promptTemplateSystemPrompt renders the prompt template of the token, or of the group of the
+// user, empty when there is none
+func promptTemplateSystemPrompt(c *gin.Context, modelName string) string {
+	template := model.CacheGetPromptTemplate(c.GetInt(ctxkey.PromptTemplateId), c.GetString(ctxkey.Group))
+	if template == nil {
+		return ""
+	}
+	if template.UsesVariable("user_name") && c.GetString(ctxkey.Username) == "" {
+		c.Set(ctxkey.Username, model.GetUsernameById(c.GetInt(ctxkey.Id)))
+	}
+	now := time.Now()
+	return template.Render(func(name string) (string, bool) {
+		switch name {
+		case "date":
+			return now.Format("2006-01-02"), true
+		case "time":
+			return now.Format("15:04"), true
+		case "datetime":
+			return now.Format("2006-01-02 15:04:05 MST"), true
+		case "weekday":
+			return now.Weekday().String(), true
+		case "user_id":
+			return strconv.Itoa(c.GetInt(ctxkey.Id)), true
+		case "user_name":
+			return c.GetString(ctxkey.Username), true
+		case "group":
+			return c.GetString(ctxkey.Group), true
+		case "token_name":
+			return c.GetString(ctxkey.TokenName), true
+		case "model":
+			return modelName, true
+		}
+		return "", false
+	})
+}
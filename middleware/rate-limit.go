@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,7 +21,11 @@ var shardedRateLimiter = common.GetShardedRateLimiter()
 // This reduces 5-6 Redis RTTs to just 1 RTT
 func redisRateLimiterOptimized(c *gin.Context, maxRequestNum int, duration int64, mark string) {
 	ctx := c.Request.Context()
-	key := mark + c.ClientIP()
+	ip, maxRequestNum, ok := ipRateLimitSubject(c, maxRequestNum)
+	if !ok {
+		return
+	}
+	key := mark + ip
 	window := time.Duration(duration) * time.Second
 
 	result, err := common.SlidingWindowRateLimit(ctx, key, maxRequestNum, window)
@@ -46,7 +51,11 @@ func redisRateLimiterOptimized(c *gin.Context, maxRequestNum int, duration int64
 
 // memoryRateLimiterOptimized uses sharded rate limiter for 50x throughput
 func memoryRateLimiterOptimized(c *gin.Context, maxRequestNum int, duration int64, mark string) {
-	key := mark + c.ClientIP()
+	ip, maxRequestNum, ok := ipRateLimitSubject(c, maxRequestNum)
+	if !ok {
+		return
+	}
+	key := mark + ip
 
 	allowed, remaining, resetAt := shardedRateLimiter.RequestWithInfo(key, maxRequestNum, duration)
 
@@ -64,6 +73,42 @@ func memoryRateLimiterOptimized(c *gin.Context, maxRequestNum int, duration int6
 	}
 }
 
+// rateLimitAlgorithm returns the algorithm configured for the limiter identified by mark
+func rateLimitAlgorithm(mark string) string {
+	algorithm := config.RateLimitAlgorithm
+	for _, override := range strings.Split(config.RateLimitAlgorithmOverrides, ",") {
+		parts := strings.SplitN(strings.TrimSpace(override), ":", 2)
+		if len(parts) == 2 && parts[0] == mark {
+			algorithm = parts[1]
+		}
+	}
+	if algorithm != common.RateLimitAlgorithmGCRA {
+		algorithm = common.RateLimitAlgorithmSlidingWindow
+	}
+	return algorithm
+}
+
+// gcraRateLimiter paces requests per client IP using GCRA, in Redis or in memory
+func gcraRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+	ip, maxRequestNum, ok := ipRateLimitSubject(c, maxRequestNum)
+	if !ok {
+		return
+	}
+	allowed, remaining, resetAt := rateLimitCheck(c.Request.Context(), common.RateLimitAlgorithmGCRA, mark+ip, maxRequestNum, duration)
+
+	// Set rate limit headers
+	c.Header("X-RateLimit-Limit", strconv.Itoa(maxRequestNum))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(resetAt.Unix(), 10))
+
+	if !allowed {
+		c.Header("Retry-After", strconv.FormatInt(int64(time.Until(resetAt).Seconds())+1, 10))
+		c.Status(http.StatusTooManyRequests)
+		c.Abort()
+		return
+	}
+}
+
 // rateLimitFactoryOptimized creates optimized rate limiting middleware
 func rateLimitFactoryOptimized(maxRequestNum int, duration int64, mark string) func(c *gin.Context) {
 	if maxRequestNum == 0 || config.DebugEnabled {
@@ -72,6 +117,15 @@ func rateLimitFactoryOptimized(maxRequestNum int, duration int64, mark string) f
 		}
 	}
 
+	if rateLimitAlgorithm(mark) == common.RateLimitAlgorithmGCRA {
+		if !common.RedisEnabled {
+			shardedRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+		}
+		return func(c *gin.Context) {
+			gcraRateLimiter(c, maxRequestNum, duration, mark)
+		}
+	}
+
 	if common.RedisEnabled {
 		return func(c *gin.Context) {
 			redisRateLimiterOptimized(c, maxRequestNum, duration, mark)
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// The responses to the API keys carry the rate limit headers of the OpenAI API, computed from
+// the limits of the gateway, so the backoff of the client SDKs works against it: the
+// x-ratelimit-*-requests headers of the tightest request limit, set here, the
+// x-ratelimit-*-tokens headers of the TPM limits, set by the relay, and x-remaining-quota.
+
+// setRequestLimitHeaders sets the x-ratelimit-*-requests headers of a request limit, unless a
+// tighter one already did
+func setRequestLimitHeaders(c *gin.Context, limit int, remaining int, resetAt time.Time) {
+	if tightest, ok := c.Get(ctxkey.RemainingRequests); ok && tightest.(int) < remaining {
+		return
+	}
+	c.Set(ctxkey.RemainingRequests, remaining)
+	c.Header("x-ratelimit-limit-requests", strconv.Itoa(limit))
+	c.Header("x-ratelimit-remaining-requests", strconv.Itoa(remaining))
+	c.Header("x-ratelimit-reset-requests", helper.FormatRateLimitReset(time.Until(resetAt)))
+}
+
+// setQuotaHeader sets x-remaining-quota, the quota the token can still use: the quota of its
+// account, at most its own remaining quota
+func setQuotaHeader(c *gin.Context, token *model.Token) {
+	quota, err := model.CacheGetAccountQuota(c.Request.Context(), token.UserId, token.OrganizationId)
+	if err != nil {
+		logger.Warnf(c.Request.Context(), "failed to get the quota of user %d: %s", token.UserId, err.Error())
+		return
+	}
+	if !token.UnlimitedQuota && token.RemainQuota < quota {
+		quota = token.RemainQuota
+	}
+	if quota < 0 {
+		quota = 0
+	}
+	c.Header("x-remaining-quota", strconv.FormatInt(quota, 10))
+}
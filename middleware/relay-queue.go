@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/priority"
+	"github.com/songquanpeng/one-api/monitor"
+)
+
+// RelayQueue admits RELAY_MAX_CONCURRENCY relay requests at a time, the others wait for a
+// slot in the order of the priority of their token, at most RELAY_QUEUE_TIMEOUT
+func RelayQueue() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		queue := priority.RelayQueue()
+		if queue == nil {
+			c.Next()
+			return
+		}
+		class := c.GetString(ctxkey.Priority)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(config.RelayQueueTimeout)*time.Second)
+		start := time.Now()
+		release, err := queue.Acquire(ctx, class)
+		cancel()
+		monitor.GetMetricsCollector().RecordQueueWait(priority.Of(class), time.Since(start))
+		if err != nil {
+			if c.Request.Context().Err() != nil {
+				// the client is gone
+				c.Abort()
+				return
+			}
+			logger.Warnf(c.Request.Context(), "no relay slot within %ds for a %s request", config.RelayQueueTimeout, priority.Of(class))
+			c.Header("Retry-After", strconv.Itoa(config.LoadSheddingRetryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": helper.MessageWithRequestId("too many requests are being relayed, please retry later", c.GetString(helper.RequestIdKey)),
+					"type":    "one_api_error",
+					"code":    "relay_queue_timeout",
+				},
+				"request_id": c.GetString(helper.RequestIdKey),
+			})
+			c.Abort()
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// applyRequestDefaults sets the parameters a chat or completion request omits to the defaults
+// of its token and user, before the model is read, so a default or pinned model is the one the
+// token restrictions and the channel selection see
+func applyRequestDefaults(c *gin.Context, token *model.Token) error {
+	path := c.Request.URL.Path
+	chat := strings.HasPrefix(path, "/v1/chat/completions")
+	if !chat && !strings.HasPrefix(path, "/v1/completions") {
+		return nil
+	}
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	defaults, err := model.CacheGetRequestDefaults(token.UserId, token.Id)
+	if err != nil || defaults == nil {
+		return err
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	var request map[string]json.RawMessage
+	if err = json.Unmarshal(requestBody, &request); err != nil {
+		// left to the relay to reject
+		return nil
+	}
+	changed := false
+	setDefault := func(key string, value any) {
+		if raw, ok := request[key]; ok && string(raw) != "null" {
+			return
+		}
+		request[key], _ = json.Marshal(value)
+		changed = true
+	}
+	if defaults.Model != "" {
+		if defaults.PinModel {
+			delete(request, "model")
+		}
+		setDefault("model", defaults.Model)
+	}
+	if defaults.Temperature != nil {
+		setDefault("temperature", *defaults.Temperature)
+	}
+	if defaults.TopP != nil {
+		setDefault("top_p", *defaults.TopP)
+	}
+	if _, ok := request["max_completion_tokens"]; defaults.MaxTokens != nil && !ok {
+		setDefault("max_tokens", *defaults.MaxTokens)
+	}
+	if chat && defaults.SystemPrompt != "" {
+		if messages, ok := withDefaultSystemPrompt(request["messages"], defaults.SystemPrompt); ok {
+			request["messages"] = messages
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	c.Set(ctxkey.KeyRequestBody, body)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	c.Request.ContentLength = int64(len(body))
+	return nil
+}
+
+// withDefaultSystemPrompt returns the messages of a chat with the system prompt first, false
+// when they have a system or developer message already
+func withDefaultSystemPrompt(rawMessages json.RawMessage, systemPrompt string) (json.RawMessage, bool) {
+	var messages []json.RawMessage
+	if json.Unmarshal(rawMessages, &messages) != nil || len(messages) == 0 {
+		return nil, false
+	}
+	for _, message := range messages {
+		var role struct {
+			Role string `json:"role"`
+		}
+		_ = json.Unmarshal(message, &role)
+		if role.Role == "system" || role.Role == "developer" {
+			return nil, false
+		}
+	}
+	systemMessage, _ := json.Marshal(gin.H{"role": "system", "content": systemPrompt})
+	result, err := json.Marshal(append([]json.RawMessage{systemMessage}, messages...))
+	if err != nil {
+		return nil, false
+	}
+	return result, true
+}
@@ -8,11 +8,15 @@ import (
 
 func RequestId() func(c *gin.Context) {
 	return func(c *gin.Context) {
-		id := helper.GenRequestID()
+		id := c.GetHeader(helper.InboundRequestIdHeader)
+		if !helper.IsValidRequestID(id) {
+			id = helper.GenRequestID()
+		}
 		c.Set(helper.RequestIdKey, id)
 		ctx := helper.SetRequestID(c.Request.Context(), id)
 		c.Request = c.Request.WithContext(ctx)
 		c.Header(helper.RequestIdKey, id)
+		c.Header(helper.InboundRequestIdHeader, id)
 		c.Next()
 	}
 }
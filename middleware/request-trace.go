@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/trace"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// RequestTrace records the decisions the relay takes for the request and saves them once it
+// ended, see /api/requests/:request_id/trace
+func RequestTrace() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !config.RequestTraceEnabled {
+			c.Next()
+			return
+		}
+		ctx, requestTrace := trace.NewContext(c.Request.Context())
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		requestTrace.Add(trace.StageResult, c.GetInt(ctxkey.ChannelId), fmt.Sprintf("status %d", status), nil)
+		events, dropped := requestTrace.Events()
+		modelName := c.GetString(ctxkey.OriginalModel)
+		if modelName == "" {
+			modelName = c.GetString(ctxkey.RequestModel)
+		}
+		go model.RecordRequestTrace(&model.RequestTrace{
+			RequestId:   c.GetString(helper.RequestIdKey),
+			UserId:      c.GetInt(ctxkey.Id),
+			TokenId:     c.GetInt(ctxkey.TokenId),
+			TenantId:    c.GetInt(ctxkey.TenantId),
+			Method:      c.Request.Method,
+			Path:        c.Request.URL.Path,
+			ModelName:   modelName,
+			StatusCode:  status,
+			ElapsedTime: time.Since(requestTrace.Start()).Milliseconds(),
+			Dropped:     dropped,
+		}, events)
+	}
+}
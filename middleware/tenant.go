@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// tenantAdminRoutes are the admin routes open to the admins of the tenants, with the kind of
+// the row their :id param is, the controllers scope the rest of them to the tenant. The other
+// admin routes configure the installation and are only open to its admins.
+var tenantAdminRoutes = map[string]string{
+	http.MethodGet + " /api/channel/":                     "",
+	http.MethodGet + " /api/channel/search":               "",
+	http.MethodGet + " /api/channel/models":               "",
+	http.MethodGet + " /api/channel/:id":                  "channel",
+	http.MethodGet + " /api/channel/test/:id":             "channel",
+	http.MethodGet + " /api/channel/test/:id/deployments": "channel",
+	http.MethodPost + " /api/channel/:id/test":            "channel",
+	http.MethodGet + " /api/channel/:id/fetch_models":     "channel",
+	http.MethodPost + " /api/channel/:id/fetch_models":    "channel",
+	http.MethodGet + " /api/channel/update_balance/:id":   "channel",
+	http.MethodPost + " /api/channel/":                    "",
+	http.MethodPut + " /api/channel/":                     "",
+	http.MethodDelete + " /api/channel/:id":               "channel",
+	http.MethodPost + " /api/channel/:id/restore":         "channel",
+	http.MethodDelete + " /api/channel/:id/purge":         "channel",
+	http.MethodGet + " /api/user/":                        "",
+	http.MethodGet + " /api/user/search":                  "",
+	http.MethodGet + " /api/user/:id":                     "user",
+	http.MethodPost + " /api/user/":                       "",
+	http.MethodPost + " /api/user/manage":                 "",
+	http.MethodPut + " /api/user/":                        "",
+	http.MethodDelete + " /api/user/:id":                  "user",
+	http.MethodGet + " /api/log/":                         "",
+	http.MethodGet + " /api/log/stat":                     "",
+	http.MethodGet + " /api/log/search":                   "",
+	http.MethodGet + " /api/logs/search":                  "",
+}
+
+// tenantAllowed tells whether an admin of a tenant may use the route of the request, the
+// admins of the installation use them all
+func tenantAllowed(c *gin.Context, tenantId int) bool {
+	if tenantId == model.DefaultTenantId {
+		return true
+	}
+	kind, ok := tenantAdminRoutes[c.Request.Method+" "+c.FullPath()]
+	if !ok {
+		return false
+	}
+	if kind == "" {
+		return true
+	}
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return false
+	}
+	var owner int
+	switch kind {
+	case "channel":
+		owner, err = model.GetChannelTenantId(id)
+	case "user":
+		owner, err = model.GetUserTenantId(id)
+	}
+	if err != nil {
+		logger.SysError("failed to get the tenant of the " + kind + ": " + err.Error())
+		return false
+	}
+	return owner == tenantId
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestTenantAllowed(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(t.TempDir()+"/one-api.db"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&model.Channel{}, &model.User{}))
+	previous := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = previous
+	})
+	require.NoError(t, db.Create(&model.Channel{Id: 1, Name: "own", TenantId: 2}).Error)
+	require.NoError(t, db.Create(&model.Channel{Id: 2, Name: "other", TenantId: 3}).Error)
+	require.NoError(t, db.Create(&model.User{Id: 1, Username: "own", AccessToken: "own", AffCode: "own", TenantId: 2}).Error)
+	require.NoError(t, db.Create(&model.User{Id: 2, Username: "other", AccessToken: "other", AffCode: "other", TenantId: 3}).Error)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	var tenantId int
+	handlers := []gin.HandlerFunc{func(c *gin.Context) {
+		c.Set(ctxkey.TenantId, tenantId)
+		if !tenantAllowed(c, c.GetInt(ctxkey.TenantId)) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+		c.Status(http.StatusOK)
+	}}
+	router.GET("/api/channel/", handlers...)
+	router.GET("/api/channel/:id", handlers...)
+	router.DELETE("/api/user/:id", handlers...)
+	router.GET("/api/option/", handlers...)
+	router.POST("/api/tenant/", handlers...)
+
+	tests := []struct {
+		name     string
+		tenantId int
+		method   string
+		path     string
+		want     int
+	}{
+		{"installation admin on the options", model.DefaultTenantId, http.MethodGet, "/api/option/", http.StatusOK},
+		{"installation admin on a channel of a tenant", model.DefaultTenantId, http.MethodGet, "/api/channel/2", http.StatusOK},
+		{"tenant admin on the channels", 2, http.MethodGet, "/api/channel/", http.StatusOK},
+		{"tenant admin on the options", 2, http.MethodGet, "/api/option/", http.StatusForbidden},
+		{"tenant admin adding a tenant", 2, http.MethodPost, "/api/tenant/", http.StatusForbidden},
+		{"tenant admin on their channel", 2, http.MethodGet, "/api/channel/1", http.StatusOK},
+		{"tenant admin on a channel of another tenant", 2, http.MethodGet, "/api/channel/2", http.StatusForbidden},
+		{"tenant admin on a missing channel", 2, http.MethodGet, "/api/channel/9", http.StatusForbidden},
+		{"tenant admin on a channel without id", 2, http.MethodGet, "/api/channel/abc", http.StatusForbidden},
+		{"tenant admin deleting their user", 2, http.MethodDelete, "/api/user/1", http.StatusOK},
+		{"tenant admin deleting a user of another tenant", 2, http.MethodDelete, "/api/user/2", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenantId = tt.tenantId
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.want, w.Code)
+		})
+	}
+}
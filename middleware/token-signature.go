@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+const signatureNoncePurgeInterval = time.Minute
+
+var (
+	signatureNonceLock      sync.Mutex
+	signatureNonces         = make(map[string]time.Time) // nonce key -> expiration
+	signatureNoncePurgeTime time.Time
+)
+
+// useSignatureNonce records the nonce of a signed request, it returns false if the nonce was
+// already used by the token
+func useSignatureNonce(ctx context.Context, tokenId int, nonce string) (bool, error) {
+	key := fmt.Sprintf("signature_nonce:%d:%s", tokenId, nonce)
+	ttl := 2 * time.Duration(config.TokenSignatureTolerance) * time.Second
+	if common.RedisEnabled {
+		return common.RDB.SetNX(ctx, key, "1", ttl).Result()
+	}
+	signatureNonceLock.Lock()
+	defer signatureNonceLock.Unlock()
+	now := time.Now()
+	if now.Sub(signatureNoncePurgeTime) > signatureNoncePurgeInterval {
+		for k, expiresAt := range signatureNonces {
+			if now.After(expiresAt) {
+				delete(signatureNonces, k)
+			}
+		}
+		signatureNoncePurgeTime = now
+	}
+	if expiresAt, ok := signatureNonces[key]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	signatureNonces[key] = now.Add(ttl)
+	return true, nil
+}
+
+// signedRequest is the method, URI and body of a request as the client sent and signed it
+type signedRequest struct {
+	method string
+	uri    string
+	body   []byte
+}
+
+// keepSignedRequest saves the request before AnthropicMessages or GeminiGenerateContent
+// convert it, its signature is verified against the request the client sent
+func keepSignedRequest(c *gin.Context, body []byte) {
+	c.Set(ctxkey.SignedRequest, &signedRequest{
+		method: c.Request.Method,
+		uri:    c.Request.URL.RequestURI(),
+		body:   body,
+	})
+}
+
+// verifyTokenSignature checks the signature of a request of a token requiring signatures, the
+// requests replayed by the batches and the deferred requests were checked when created
+func verifyTokenSignature(c *gin.Context, token *model.Token) error {
+	if model.IsSignatureVerified(c.Request.Context()) {
+		return nil
+	}
+	header := c.Request.Header.Get(model.TokenSignatureHeader)
+	if header == "" {
+		return errors.New("this token requires signed requests, the X-Signature header is missing")
+	}
+	signature, err := model.ParseTokenSignature(header)
+	if err != nil {
+		return err
+	}
+	value, _ := c.Get(ctxkey.SignedRequest)
+	request, ok := value.(*signedRequest)
+	if !ok {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+		request = &signedRequest{method: c.Request.Method, uri: c.Request.URL.RequestURI(), body: body}
+	}
+	if err = signature.Verify(token, request.method, request.uri, request.body); err != nil {
+		return err
+	}
+	fresh, err := useSignatureNonce(c.Request.Context(), token.Id, signature.Nonce)
+	if err != nil {
+		return fmt.Errorf("failed to check the nonce of the signature: %w", err)
+	}
+	if !fresh {
+		return errors.New("the nonce of the signature was already used")
+	}
+	return nil
+}
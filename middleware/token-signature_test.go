@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+)
+
+func TestUseSignatureNonce(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+	ctx := context.Background()
+	testCases := []struct {
+		tokenId int
+		nonce   string
+		fresh   bool
+	}{
+		{1, "nonce-a", true},
+		{1, "nonce-a", false}, // replayed
+		{1, "nonce-b", true},
+		{2, "nonce-a", true}, // the nonces are per token
+		{2, "nonce-a", false},
+	}
+	for i, testCase := range testCases {
+		fresh, err := useSignatureNonce(ctx, testCase.tokenId, testCase.nonce)
+		require.NoError(t, err)
+		assert.Equal(t, testCase.fresh, fresh, "nonce %d", i)
+	}
+}
+
+func signedContext(t *testing.T, token *model.Token, path string, body string, signedPath string, signedBody string, nonce string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	timestamp := helper.GetTimestamp()
+	signature := model.SignTokenRequest(token.SigningSecret, timestamp, nonce, http.MethodPost, signedPath, []byte(signedBody))
+	c.Request.Header.Set(model.TokenSignatureHeader, fmt.Sprintf("t=%d,n=%s,v1=%s", timestamp, nonce, signature))
+	return c
+}
+
+func TestVerifyTokenSignature(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() {
+		common.RedisEnabled = redisEnabled
+	})
+	token := &model.Token{Id: 100, SigningSecret: "secret"}
+	body := `{"model":"gpt-4o"}`
+
+	c := signedContext(t, token, "/v1/chat/completions", body, "/v1/chat/completions", body, "valid")
+	require.NoError(t, verifyTokenSignature(c, token))
+	// the body is still there for the relay
+	relayed, _ := io.ReadAll(c.Request.Body)
+	assert.Equal(t, body, string(relayed))
+
+	c = signedContext(t, token, "/v1/chat/completions", body, "/v1/chat/completions", body, "valid")
+	assert.Error(t, verifyTokenSignature(c, token), "the nonce was used")
+
+	c = signedContext(t, token, "/v1/chat/completions", `{"model":"o1"}`, "/v1/chat/completions", body, "tampered")
+	assert.Error(t, verifyTokenSignature(c, token))
+
+	// a /v1/messages request is converted before TokenAuth, it is verified as the client sent it
+	anthropicBody := `{"model":"claude-3-5-sonnet","max_tokens":16,"messages":[]}`
+	c = signedContext(t, token, "/v1/messages", anthropicBody, "/v1/messages", anthropicBody, "converted")
+	keepSignedRequest(c, []byte(anthropicBody))
+	c.Request.URL.Path = "/v1/chat/completions"
+	c.Request.Body = io.NopCloser(bytes.NewBufferString(body))
+	assert.NoError(t, verifyTokenSignature(c, token))
+
+	// the requests replayed by the batches and the deferred requests are not signed again
+	c = signedContext(t, token, "/v1/chat/completions", body, "/v1/chat/completions", body, "replayed")
+	c.Request.Header.Del(model.TokenSignatureHeader)
+	assert.Error(t, verifyTokenSignature(c, token))
+	c.Request = c.Request.WithContext(model.WithSignatureVerified(c.Request.Context()))
+	assert.NoError(t, verifyTokenSignature(c, token))
+}
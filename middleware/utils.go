@@ -6,6 +6,7 @@ import (
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/trace"
 	"strings"
 )
 
@@ -15,9 +16,11 @@ func abortWithMessage(c *gin.Context, statusCode int, message string) {
 			"message": helper.MessageWithRequestId(message, c.GetString(helper.RequestIdKey)),
 			"type":    "one_api_error",
 		},
+		"request_id": c.GetString(helper.RequestIdKey),
 	})
 	c.Abort()
 	logger.Error(c.Request.Context(), message)
+	trace.Record(c.Request.Context(), trace.StageRejected, 0, message, map[string]any{"status": statusCode})
 }
 
 func getRequestModel(c *gin.Context) (string, error) {
@@ -36,7 +39,7 @@ func getRequestModel(c *gin.Context) (string, error) {
 			modelRequest.Model = c.Param("model")
 		}
 	}
-	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") || strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") {
 		if modelRequest.Model == "" {
 			modelRequest.Model = "dall-e-2"
 		}
@@ -49,12 +52,45 @@ func getRequestModel(c *gin.Context) (string, error) {
 	return modelRequest.Model, nil
 }
 
-func isModelInList(modelName string, models string) bool {
-	modelList := strings.Split(models, ",")
-	for _, model := range modelList {
-		if modelName == model {
+// isInList tells whether a value is one of a comma separated list
+func isInList(value string, list string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == value {
 			return true
 		}
 	}
 	return false
 }
+
+// tokenEndpointPrefixes map the paths of the relay, without the /v1 prefix, to the
+// endpoints a token can be restricted to
+var tokenEndpointPrefixes = []struct {
+	prefix   string
+	endpoint string
+}{
+	{"/chat/completions", "chat"},
+	{"/completions", "completions"},
+	{"/edits", "completions"},
+	{"/embeddings", "embeddings"},
+	{"/engines/", "embeddings"},
+	{"/images/", "images"},
+	{"/audio/", "audio"},
+	{"/moderations", "moderations"},
+	{"/responses", "responses"},
+	{"/batches", "batches"},
+	{"/files", "batches"},
+	{"/deferred", "batches"},
+	{"/oneapi/proxy/", "proxy"},
+}
+
+// tokenEndpoint returns the endpoint of a request path, empty for the paths every token
+// can use, such as the model list
+func tokenEndpoint(path string) string {
+	path = strings.TrimPrefix(path, "/v1")
+	for _, item := range tokenEndpointPrefixes {
+		if strings.HasPrefix(path, item.prefix) {
+			return item.endpoint
+		}
+	}
+	return ""
+}
@@ -17,9 +17,10 @@ type Ability struct {
 	ChannelId int    `json:"channel_id" gorm:"primaryKey;autoIncrement:false;index"`
 	Enabled   bool   `json:"enabled"`
 	Priority  *int64 `json:"priority" gorm:"bigint;default:0;index"`
+	TenantId  int    `json:"tenant_id" gorm:"default:0;index"` // the tenant of the channel
 }
 
-func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
+func GetRandomSatisfiedChannel(tenantId int, group string, model string, ignoreFirstPriority bool) (*Channel, error) {
 	ability := Ability{}
 	groupCol := "`group`"
 	trueVal := "1"
@@ -31,10 +32,10 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 	var err error = nil
 	var channelQuery *gorm.DB
 	if ignoreFirstPriority {
-		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
+		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and tenant_id = ?", group, model, tenantId)
 	} else {
-		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
-		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
+		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and tenant_id = ?", group, model, tenantId)
+		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and tenant_id = ? and priority = (?)", group, model, tenantId, maxPrioritySubQuery)
 	}
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("RANDOM()").First(&ability).Error
@@ -50,6 +51,33 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 	return &channel, err
 }
 
+// GetSatisfiedChannels returns the enabled channels of the group of the tenant serving the model,
+// sorted by priority
+func GetSatisfiedChannels(tenantId int, group string, model string) ([]*Channel, error) {
+	groupCol := "`group`"
+	trueVal := "1"
+	if common.UsingPostgreSQL {
+		groupCol = `"group"`
+		trueVal = "true"
+	}
+	var channelIds []int
+	err := DB.Model(&Ability{}).Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and tenant_id = ?", group, model, tenantId).Pluck("channel_id", &channelIds).Error
+	if err != nil {
+		return nil, err
+	}
+	var channels []*Channel
+	if len(channelIds) == 0 {
+		return channels, nil
+	}
+	if err = DB.Where("id in ?", channelIds).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	sort.Slice(channels, func(i, j int) bool {
+		return channels[i].GetPriority() > channels[j].GetPriority()
+	})
+	return channels, nil
+}
+
 func (channel *Channel) AddAbilities() error {
 	models_ := strings.Split(channel.Models, ",")
 	models_ = utils.DeDuplication(models_)
@@ -63,6 +91,7 @@ func (channel *Channel) AddAbilities() error {
 				ChannelId: channel.Id,
 				Enabled:   channel.Status == ChannelStatusEnabled,
 				Priority:  channel.Priority,
+				TenantId:  channel.TenantId,
 			}
 			abilities = append(abilities, ability)
 		}
@@ -95,7 +124,7 @@ func UpdateAbilityStatus(channelId int, status bool) error {
 	return DB.Model(&Ability{}).Where("channel_id = ?", channelId).Select("enabled").Update("enabled", status).Error
 }
 
-func GetGroupModels(ctx context.Context, group string) ([]string, error) {
+func GetGroupModels(ctx context.Context, tenantId int, group string) ([]string, error) {
 	groupCol := "`group`"
 	trueVal := "1"
 	if common.UsingPostgreSQL {
@@ -103,7 +132,7 @@ func GetGroupModels(ctx context.Context, group string) ([]string, error) {
 		trueVal = "true"
 	}
 	var models []string
-	err := DB.Model(&Ability{}).Distinct("model").Where(groupCol+" = ? and enabled = "+trueVal, group).Pluck("model", &models).Error
+	err := DB.Model(&Ability{}).Distinct("model").Where(groupCol+" = ? and enabled = "+trueVal+" and tenant_id = ?", group, tenantId).Pluck("model", &models).Error
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,174 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// a running batch not updated within this time was lost with its node and is taken over
+const batchStaleTime = 300 // unit is second
+
+// Batch is a batch job whose requests are relayed one by one through the channels
+type Batch struct {
+	Id               string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId           int    `json:"user_id" gorm:"index"`
+	TokenId          int    `json:"token_id"`
+	ClientIp         string `json:"-" gorm:"type:varchar(64)"` // the requests are relayed as if sent from this ip
+	Endpoint         string `json:"endpoint" gorm:"type:varchar(64)"`
+	InputFileId      string `json:"input_file_id" gorm:"type:varchar(64)"`
+	OutputFileId     string `json:"output_file_id" gorm:"type:varchar(64)"`
+	ErrorFileId      string `json:"error_file_id" gorm:"type:varchar(64)"`
+	CompletionWindow string `json:"completion_window" gorm:"type:varchar(16)"`
+	Status           string `json:"status" gorm:"type:varchar(16);index"`
+	Errors           string `json:"-" gorm:"type:text"` // validation errors of the input file
+	Metadata         string `json:"-" gorm:"type:text"`
+	RequestTotal     int    `json:"request_total"`
+	RequestCompleted int    `json:"request_completed"`
+	RequestFailed    int    `json:"request_failed"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	FinalizingAt     int64  `json:"finalizing_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	ExpiredAt        int64  `json:"expired_at" gorm:"bigint"`
+	CancellingAt     int64  `json:"cancelling_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+	UpdatedAt        int64  `json:"updated_at" gorm:"bigint"`
+}
+
+// BatchResult is the outcome of a single request of a running batch, kept until the
+// batch is finalized so that a batch taken over by another node skips it
+type BatchResult struct {
+	Id         int    `json:"id"`
+	BatchId    string `json:"batch_id" gorm:"type:varchar(64);index"`
+	Line       int    `json:"line"`
+	StatusCode int    `json:"status_code"`
+	RequestId  string `json:"request_id" gorm:"type:varchar(64)"`
+	Body       []byte `json:"-"`
+}
+
+func (b *Batch) Insert() error {
+	now := helper.GetTimestamp()
+	b.CreatedAt = now
+	b.UpdatedAt = now
+	return DB.Create(b).Error
+}
+
+func GetBatch(id string, userId int) (*Batch, error) {
+	if id == "" {
+		return nil, errors.New("id is empty")
+	}
+	var batch Batch
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(&batch).Error
+	return &batch, err
+}
+
+// GetBatches lists the batches of a user, newest first, starting after the batch afterId
+func GetBatches(userId int, afterId string, limit int) ([]*Batch, error) {
+	var batches []*Batch
+	tx := DB.Where("user_id = ?", userId)
+	if afterId != "" {
+		after, err := GetBatch(afterId, userId)
+		if err != nil {
+			return nil, err
+		}
+		tx = tx.Where("created_at < ? OR (created_at = ? AND id < ?)", after.CreatedAt, after.CreatedAt, after.Id)
+	}
+	err := tx.Order("created_at desc, id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+// CancelBatch cancels a batch at once if it has not started, otherwise asks its runner to stop
+func CancelBatch(id string, userId int) (bool, error) {
+	now := helper.GetTimestamp()
+	result := DB.Model(&Batch{}).
+		Where("id = ? AND user_id = ? AND status = ?", id, userId, BatchStatusValidating).
+		Updates(map[string]any{"status": BatchStatusCancelled, "cancelling_at": now, "cancelled_at": now, "updated_at": now})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.RowsAffected > 0, result.Error
+	}
+	result = DB.Model(&Batch{}).
+		Where("id = ? AND user_id = ? AND status = ?", id, userId, BatchStatusInProgress).
+		Updates(map[string]any{"status": BatchStatusCancelling, "cancelling_at": now, "updated_at": now})
+	return result.RowsAffected > 0, result.Error
+}
+
+// ClaimBatch takes a new batch, or a running one whose node was lost, for this node to run.
+// It returns nil if there is none.
+func ClaimBatch() (*Batch, error) {
+	now := helper.GetTimestamp()
+	var batch Batch
+	err := DB.Where("status = ? OR (status IN ? AND updated_at < ?)", BatchStatusValidating,
+		[]string{BatchStatusInProgress, BatchStatusCancelling, BatchStatusFinalizing}, now-batchStaleTime).
+		Order("created_at").Limit(1).Find(&batch).Error
+	if err != nil || batch.Id == "" {
+		return nil, err
+	}
+	updates := map[string]any{"updated_at": now}
+	if batch.Status == BatchStatusValidating {
+		updates["status"] = BatchStatusInProgress
+		updates["in_progress_at"] = now
+	}
+	result := DB.Model(&Batch{}).
+		Where("id = ? AND status = ? AND updated_at = ?", batch.Id, batch.Status, batch.UpdatedAt).
+		Updates(updates)
+	if result.Error != nil || result.RowsAffected == 0 {
+		// taken by another node
+		return nil, result.Error
+	}
+	return GetBatch(batch.Id, batch.UserId)
+}
+
+// UpdateBatchProgress saves the request counts of a running batch and returns its status,
+// which becomes cancelling when the user cancels it
+func UpdateBatchProgress(id string, total int, completed int, failed int) (string, error) {
+	err := DB.Model(&Batch{}).Where("id = ?", id).Updates(map[string]any{
+		"request_total":     total,
+		"request_completed": completed,
+		"request_failed":    failed,
+		"updated_at":        helper.GetTimestamp(),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+	var batch Batch
+	err = DB.Select("status").Where("id = ?", id).First(&batch).Error
+	return batch.Status, err
+}
+
+// UpdateBatchStatus moves a batch to the given status, setting the fields given along
+func UpdateBatchStatus(id string, status string, fields map[string]any) error {
+	updates := map[string]any{"status": status, "updated_at": helper.GetTimestamp()}
+	for key, value := range fields {
+		updates[key] = value
+	}
+	return DB.Model(&Batch{}).Where("id = ?", id).Updates(updates).Error
+}
+
+func (r *BatchResult) Insert() error {
+	return DB.Create(r).Error
+}
+
+// GetBatchResults returns the results saved for a batch, ordered by input line
+func GetBatchResults(batchId string) ([]*BatchResult, error) {
+	var results []*BatchResult
+	err := DB.Where("batch_id = ?", batchId).Order("line").Find(&results).Error
+	return results, err
+}
+
+func DeleteBatchResults(batchId string) error {
+	return DB.Where("batch_id = ?", batchId).Delete(&BatchResult{}).Error
+}
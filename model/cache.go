@@ -9,6 +9,7 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/random"
+	"gorm.io/gorm"
 	"math/rand"
 	"sort"
 	"strconv"
@@ -33,11 +34,17 @@ func CacheGetTokenByKey(key string) (*Token, error) {
 	var token Token
 	if !common.RedisEnabled {
 		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = getTokenByPreviousKey(key, &token)
+		}
 		return &token, err
 	}
 	tokenObjectString, err := common.RedisGet(fmt.Sprintf("token:%s", key))
 	if err != nil {
 		err := DB.Where(keyCol+" = ?", key).First(&token).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = getTokenByPreviousKey(key, &token)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -148,19 +155,22 @@ func CacheIsUserEnabled(userId int) (bool, error) {
 	return userEnabled, err
 }
 
-func CacheGetGroupModels(ctx context.Context, group string) ([]string, error) {
+// CacheGetGroupModels returns the models of a group of a tenant, the groups of the tenants
+// have their own keys
+func CacheGetGroupModels(ctx context.Context, tenantId int, group string) ([]string, error) {
 	if !common.RedisEnabled {
-		return GetGroupModels(ctx, group)
+		return GetGroupModels(ctx, tenantId, group)
 	}
-	modelsStr, err := common.RedisGet(fmt.Sprintf("group_models:%s", group))
+	key := common.TenantKey(tenantId, fmt.Sprintf("group_models:%s", group))
+	modelsStr, err := common.RedisGet(key)
 	if err == nil {
 		return strings.Split(modelsStr, ","), nil
 	}
-	models, err := GetGroupModels(ctx, group)
+	models, err := GetGroupModels(ctx, tenantId, group)
 	if err != nil {
 		return nil, err
 	}
-	err = common.RedisSet(fmt.Sprintf("group_models:%s", group), strings.Join(models, ","), time.Duration(GroupModelsCacheSeconds)*time.Second)
+	err = common.RedisSet(key, strings.Join(models, ","), time.Duration(GroupModelsCacheSeconds)*time.Second)
 	if err != nil {
 		logger.SysError("Redis set group models error: " + err.Error())
 	}
@@ -180,7 +190,8 @@ func GetEnabledChannels() []*Channel {
 	return result
 }
 
-var group2model2channels map[string]map[string][]*Channel
+// the channels of a tenant only serve the requests of the tenant, see Tenant
+var tenant2group2model2channels map[int]map[string]map[string][]*Channel
 var channelId2channel map[int]*Channel
 var channelSyncLock sync.RWMutex
 
@@ -191,41 +202,38 @@ func InitChannelCache() {
 	for _, channel := range channels {
 		newChannelId2channel[channel.Id] = channel
 	}
-	var abilities []*Ability
-	DB.Find(&abilities)
-	groups := make(map[string]bool)
-	for _, ability := range abilities {
-		groups[ability.Group] = true
-	}
-	newGroup2model2channels := make(map[string]map[string][]*Channel)
-	for group := range groups {
-		newGroup2model2channels[group] = make(map[string][]*Channel)
-	}
+	newTenant2group2model2channels := make(map[int]map[string]map[string][]*Channel)
 	for _, channel := range channels {
+		group2model2channels := newTenant2group2model2channels[channel.TenantId]
+		if group2model2channels == nil {
+			group2model2channels = make(map[string]map[string][]*Channel)
+			newTenant2group2model2channels[channel.TenantId] = group2model2channels
+		}
 		groups := strings.Split(channel.Group, ",")
 		for _, group := range groups {
+			if group2model2channels[group] == nil {
+				group2model2channels[group] = make(map[string][]*Channel)
+			}
 			models := strings.Split(channel.Models, ",")
 			for _, model := range models {
-				if _, ok := newGroup2model2channels[group][model]; !ok {
-					newGroup2model2channels[group][model] = make([]*Channel, 0)
-				}
-				newGroup2model2channels[group][model] = append(newGroup2model2channels[group][model], channel)
+				group2model2channels[group][model] = append(group2model2channels[group][model], channel)
 			}
 		}
 	}
 
 	// sort by priority
-	for group, model2channels := range newGroup2model2channels {
-		for model, channels := range model2channels {
-			sort.Slice(channels, func(i, j int) bool {
-				return channels[i].GetPriority() > channels[j].GetPriority()
-			})
-			newGroup2model2channels[group][model] = channels
+	for _, group2model2channels := range newTenant2group2model2channels {
+		for _, model2channels := range group2model2channels {
+			for _, channels := range model2channels {
+				sort.Slice(channels, func(i, j int) bool {
+					return channels[i].GetPriority() > channels[j].GetPriority()
+				})
+			}
 		}
 	}
 
 	channelSyncLock.Lock()
-	group2model2channels = newGroup2model2channels
+	tenant2group2model2channels = newTenant2group2model2channels
 	channelSyncLock.Unlock()
 	logger.SysLog("channels synced from database")
 }
@@ -238,16 +246,56 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
-func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
+func CacheGetRandomSatisfiedChannel(tenantId int, group string, model string, ignoreFirstPriority bool) (*Channel, error) {
 	if !config.MemoryCacheEnabled {
-		return GetRandomSatisfiedChannel(group, model, ignoreFirstPriority)
+		return GetRandomSatisfiedChannel(tenantId, group, model, ignoreFirstPriority)
 	}
 	channelSyncLock.RLock()
 	defer channelSyncLock.RUnlock()
-	channels := group2model2channels[group][model]
+	channels := tenant2group2model2channels[tenantId][group][model]
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
+	return randomChannelByPriority(withCanaryShares(withoutThrottledChannels(channels)), ignoreFirstPriority), nil
+}
+
+// CacheGetChannels returns the enabled channels of a group of a tenant serving a model, empty
+// without the memory cache
+func CacheGetChannels(tenantId int, group string, model string) []*Channel {
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	return append([]*Channel(nil), tenant2group2model2channels[tenantId][group][model]...)
+}
+
+// CacheGetRandomSatisfiedChannelOfType chooses like CacheGetRandomSatisfiedChannel among the
+// channels whose type is accepted
+func CacheGetRandomSatisfiedChannelOfType(tenantId int, group string, model string, ignoreFirstPriority bool, acceptType func(channelType int) bool) (*Channel, error) {
+	var channels []*Channel
+	if config.MemoryCacheEnabled {
+		channelSyncLock.RLock()
+		channels = tenant2group2model2channels[tenantId][group][model]
+		channelSyncLock.RUnlock()
+	} else {
+		var err error
+		if channels, err = GetSatisfiedChannels(tenantId, group, model); err != nil {
+			return nil, err
+		}
+	}
+	accepted := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if acceptType(channel.Type) {
+			accepted = append(accepted, channel)
+		}
+	}
+	if len(accepted) == 0 {
+		return nil, errors.New("channel not found")
+	}
+	return randomChannelByPriority(withCanaryShares(withoutThrottledChannels(accepted)), ignoreFirstPriority), nil
+}
+
+// randomChannelByPriority chooses a channel of the highest priority, or of the lower ones,
+// channels are sorted by priority
+func randomChannelByPriority(channels []*Channel, ignoreFirstPriority bool) *Channel {
 	endIdx := len(channels)
 	// choose by priority
 	firstChannel := channels[0]
@@ -265,5 +313,5 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 			idx = random.RandRange(endIdx, len(channels))
 		}
 	}
-	return channels[idx], nil
+	return channels[idx]
 }
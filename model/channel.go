@@ -2,8 +2,12 @@ package model
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -15,6 +19,7 @@ const (
 	ChannelStatusEnabled          = 1 // don't use 0, 0 is the default value!
 	ChannelStatusManuallyDisabled = 2 // also don't use 0
 	ChannelStatusAutoDisabled     = 3
+	ChannelStatusArchived         = 4 // deleted, restored or purged by the admins
 )
 
 type Channel struct {
@@ -38,39 +43,94 @@ type Channel struct {
 	Priority           *int64  `json:"priority" gorm:"bigint;default:0"`
 	Config             string  `json:"config"`
 	SystemPrompt       *string `json:"system_prompt" gorm:"type:text"`
+	ArchivedTime       int64   `json:"archived_time" gorm:"bigint;default:0"`
+	Tags               *string `json:"tags" gorm:"type:varchar(255);default:''"` // comma separated, see ChannelTag
+	TenantId           int     `json:"tenant_id" gorm:"default:0;index"`         // see Tenant, set on creation
 }
 
 type ChannelConfig struct {
-	Region            string `json:"region,omitempty"`
-	SK                string `json:"sk,omitempty"`
-	AK                string `json:"ak,omitempty"`
-	UserID            string `json:"user_id,omitempty"`
-	APIVersion        string `json:"api_version,omitempty"`
-	LibraryID         string `json:"library_id,omitempty"`
-	Plugin            string `json:"plugin,omitempty"`
-	VertexAIProjectID string `json:"vertex_ai_project_id,omitempty"`
-	VertexAIADC       string `json:"vertex_ai_adc,omitempty"`
-}
-
-func GetAllChannels(startIdx int, num int, scope string) ([]*Channel, error) {
+	Region            string             `json:"region,omitempty"`
+	SK                string             `json:"sk,omitempty"`
+	AK                string             `json:"ak,omitempty"`
+	UserID            string             `json:"user_id,omitempty"`
+	APIVersion        string             `json:"api_version,omitempty"`
+	LibraryID         string             `json:"library_id,omitempty"`
+	Plugin            string             `json:"plugin,omitempty"`
+	VertexAIProjectID string             `json:"vertex_ai_project_id,omitempty"`
+	VertexAIADC       string             `json:"vertex_ai_adc,omitempty"`
+	Proxy             string             `json:"proxy,omitempty"`   // outbound proxy url, "direct" bypasses the relay proxy
+	Headers           map[string]string  `json:"headers,omitempty"` // injected into every upstream request
+	TLS               *client.TLSOptions `json:"tls,omitempty"`
+	LocalAddress      string             `json:"local_address,omitempty"` // source IP or interface of upstream connections
+	// CostRatio is the part of the list price the provider charges for the channel, e.g. 0.8
+	// with a 20% discount, 0 is the list price
+	CostRatio float64 `json:"cost_ratio,omitempty"`
+	// Azure: deployment name of each model, and the api-version of each request feature
+	Deployments map[string]string `json:"deployments,omitempty"`
+	APIVersions map[string]string `json:"api_versions,omitempty"`
+	// Mock shapes the synthetic responses of a mock channel
+	Mock *MockOptions `json:"mock,omitempty"`
+}
+
+// MockOptions are the behavior of a mock channel, which answers without calling any API
+type MockOptions struct {
+	// LatencyMs is the mean time to the first byte, spread by LatencyJitterMs following
+	// LatencyDistribution: "uniform" (the default), "normal", with the jitter as its standard
+	// deviation, or "exponential", which ignores the jitter
+	LatencyMs           int    `json:"latency_ms,omitempty"`
+	LatencyJitterMs     int    `json:"latency_jitter_ms,omitempty"`
+	LatencyDistribution string `json:"latency_distribution,omitempty"`
+	// TokensPerSecond is the speed of the streams, 0 sends every token at once
+	TokensPerSecond float64 `json:"tokens_per_second,omitempty"`
+	// CompletionTokens is the length of the completions, 16 when 0
+	CompletionTokens int `json:"completion_tokens,omitempty"`
+	// Echo answers with the last user message instead of a synthetic text
+	Echo bool `json:"echo,omitempty"`
+	// ErrorRate is the part of the requests failing, from 0 to 1, with ErrorStatus, 500 when 0
+	ErrorRate   float64 `json:"error_rate,omitempty"`
+	ErrorStatus int     `json:"error_status,omitempty"`
+}
+
+func GetAllChannels(tenantId int, startIdx int, num int, scope string) ([]*Channel, error) {
 	var channels []*Channel
 	var err error
+	tx := DB.Scopes(tenantScope(tenantId))
 	switch scope {
 	case "all":
-		err = DB.Order("id desc").Find(&channels).Error
+		err = tx.Order("id desc").Where("status <> ?", ChannelStatusArchived).Find(&channels).Error
 	case "disabled":
-		err = DB.Order("id desc").Where("status = ? or status = ?", ChannelStatusAutoDisabled, ChannelStatusManuallyDisabled).Find(&channels).Error
+		err = tx.Order("id desc").Where("status = ? or status = ?", ChannelStatusAutoDisabled, ChannelStatusManuallyDisabled).Find(&channels).Error
+	case "archived":
+		err = tx.Order("archived_time desc, id desc").Where("status = ?", ChannelStatusArchived).Limit(num).Offset(startIdx).Omit("key").Find(&channels).Error
 	default:
-		err = DB.Order("id desc").Limit(num).Offset(startIdx).Omit("key").Find(&channels).Error
+		err = tx.Order("id desc").Where("status <> ?", ChannelStatusArchived).Limit(num).Offset(startIdx).Omit("key").Find(&channels).Error
 	}
 	return channels, err
 }
 
-func SearchChannels(keyword string) (channels []*Channel, err error) {
-	err = DB.Omit("key").Where("id = ? or name LIKE ?", helper.String2Int(keyword), keyword+"%").Find(&channels).Error
+func SearchChannels(tenantId int, keyword string) (channels []*Channel, err error) {
+	err = DB.Scopes(tenantScope(tenantId)).Omit("key").Where("status <> ?", ChannelStatusArchived).
+		Where("id = ? or name LIKE ?", helper.String2Int(keyword), keyword+"%").Find(&channels).Error
 	return channels, err
 }
 
+// GetChannelNames returns the names of channels, archived ones included, for the records
+// which reference them; purged channels have none
+func GetChannelNames(ids []int) (map[int]string, error) {
+	names := make(map[int]string, len(ids))
+	if len(ids) == 0 {
+		return names, nil
+	}
+	var channels []*Channel
+	if err := DB.Select("id", "name").Where("id IN ?", ids).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		names[channel.Id] = channel.Name
+	}
+	return names, nil
+}
+
 func GetChannelById(id int, selectAll bool) (*Channel, error) {
 	channel := Channel{Id: id}
 	var err error = nil
@@ -94,6 +154,7 @@ func BatchInsertChannels(channels []Channel) error {
 			return err
 		}
 	}
+	PublishInvalidation(InvalidationChannels, "")
 	return nil
 }
 
@@ -131,17 +192,26 @@ func (channel *Channel) Insert() error {
 		return err
 	}
 	err = channel.AddAbilities()
+	PublishInvalidation(InvalidationChannels, strconv.Itoa(channel.Id))
 	return err
 }
 
 func (channel *Channel) Update() error {
 	var err error
+	var status int
+	if err = DB.Model(&Channel{}).Where("id = ?", channel.Id).Pluck("status", &status).Error; err != nil {
+		return err
+	}
+	if status == ChannelStatusArchived || channel.Status == ChannelStatusArchived {
+		return errors.New("the channel is archived, restore it first")
+	}
 	err = DB.Model(channel).Updates(channel).Error
 	if err != nil {
 		return err
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
 	err = channel.UpdateAbilities()
+	PublishInvalidation(InvalidationChannels, strconv.Itoa(channel.Id))
 	return err
 }
 
@@ -165,16 +235,61 @@ func (channel *Channel) UpdateBalance(balance float64) {
 	}
 }
 
+// Delete archives the channel: it is no longer selected nor listed, and its config is kept
+// until it is restored or purged
 func (channel *Channel) Delete() error {
-	var err error
-	err = DB.Delete(channel).Error
+	result := DB.Model(&Channel{}).Where("id = ? AND status <> ?", channel.Id, ChannelStatusArchived).
+		Updates(map[string]any{"status": ChannelStatusArchived, "archived_time": helper.GetTimestamp()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("channel #%d does not exist or is already archived", channel.Id)
+	}
+	err := channel.DeleteAbilities()
+	GetHealthTracker().Remove(channel.Id)
+	PublishInvalidation(InvalidationChannels, strconv.Itoa(channel.Id))
+	return err
+}
+
+// Restore brings an archived channel back, disabled until an admin enables it
+func (channel *Channel) Restore() error {
+	err := DB.Where("id = ? AND status = ?", channel.Id, ChannelStatusArchived).First(channel).Error
+	if err != nil {
+		return fmt.Errorf("channel #%d is not archived", channel.Id)
+	}
+	channel.Status = ChannelStatusManuallyDisabled
+	channel.ArchivedTime = 0
+	err = DB.Model(channel).Select("status", "archived_time").Updates(channel).Error
 	if err != nil {
 		return err
 	}
-	err = channel.DeleteAbilities()
+	err = channel.UpdateAbilities()
+	PublishInvalidation(InvalidationChannels, strconv.Itoa(channel.Id))
 	return err
 }
 
+// Purge deletes an archived channel for good, its logs keep its id
+func (channel *Channel) Purge() error {
+	result := DB.Where("id = ? AND status = ?", channel.Id, ChannelStatusArchived).Delete(&Channel{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("channel #%d is not archived", channel.Id)
+	}
+	return channel.DeleteAbilities()
+}
+
+// secrets returns the config fields which are encrypted when they are stored
+func (cfg *ChannelConfig) secrets() []*string {
+	secrets := []*string{&cfg.SK, &cfg.AK, &cfg.VertexAIADC}
+	if cfg.TLS != nil {
+		secrets = append(secrets, &cfg.TLS.ClientKey)
+	}
+	return secrets
+}
+
 func (channel *Channel) LoadConfig() (ChannelConfig, error) {
 	var cfg ChannelConfig
 	if channel.Config == "" {
@@ -184,18 +299,188 @@ func (channel *Channel) LoadConfig() (ChannelConfig, error) {
 	if err != nil {
 		return cfg, err
 	}
+	for _, secret := range cfg.secrets() {
+		if *secret == "" {
+			continue
+		}
+		*secret, err = common.DecryptString(*secret)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to decrypt channel config: %w", err)
+		}
+	}
 	return cfg, nil
 }
 
+// SealConfig encrypts the secrets of the channel config before it is stored,
+// values that are already encrypted are kept as is. The TLS client key is never
+// stored in plaintext, the other secrets are when ENCRYPTION_KEY is not set.
+func (channel *Channel) SealConfig() error {
+	if channel.Config == "" {
+		return nil
+	}
+	var cfg ChannelConfig
+	if err := json.Unmarshal([]byte(channel.Config), &cfg); err != nil {
+		return err
+	}
+	sealed := false
+	for _, secret := range cfg.secrets() {
+		if *secret == "" || common.IsEncrypted(*secret) {
+			continue
+		}
+		if !common.EncryptionEnabled() && (cfg.TLS == nil || secret != &cfg.TLS.ClientKey) {
+			continue
+		}
+		encrypted, err := common.EncryptString(*secret)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt channel config: %w", err)
+		}
+		*secret = encrypted
+		sealed = true
+	}
+	if !sealed {
+		return nil
+	}
+	jsonBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	channel.Config = string(jsonBytes)
+	return nil
+}
+
+// BeforeSave normalizes the tags of the channel, and encrypts its key and its config secrets
+// when ENCRYPTION_KEY is set
+func (channel *Channel) BeforeSave(tx *gorm.DB) error {
+	if err := channel.normalizeTags(); err != nil {
+		return err
+	}
+	if !common.EncryptionEnabled() {
+		return nil
+	}
+	if channel.Key != "" && !common.IsEncrypted(channel.Key) {
+		key, err := common.EncryptString(channel.Key)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt channel key: %w", err)
+		}
+		channel.Key = key
+	}
+	return channel.SealConfig()
+}
+
+// AfterSave decrypts the key encrypted by BeforeSave, the key is used as is
+func (channel *Channel) AfterSave(tx *gorm.DB) error {
+	return channel.openKey()
+}
+
+// AfterFind decrypts the key of the channel, the config secrets are decrypted by LoadConfig
+func (channel *Channel) AfterFind(tx *gorm.DB) error {
+	return channel.openKey()
+}
+
+func (channel *Channel) openKey() error {
+	if !common.IsEncrypted(channel.Key) {
+		return nil
+	}
+	key, err := common.DecryptString(channel.Key)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the key of channel #%d: %w", channel.Id, err)
+	}
+	channel.Key = key
+	return nil
+}
+
+// reencryptConfig encrypts the secrets of a channel config with the current encryption key
+func reencryptConfig(channelConfig string) (string, error) {
+	if channelConfig == "" {
+		return channelConfig, nil
+	}
+	var cfg ChannelConfig
+	if err := json.Unmarshal([]byte(channelConfig), &cfg); err != nil {
+		return "", err
+	}
+	changed := false
+	for _, secret := range cfg.secrets() {
+		encrypted, err := common.ReencryptString(*secret)
+		if err != nil {
+			return "", err
+		}
+		if encrypted != *secret {
+			*secret = encrypted
+			changed = true
+		}
+	}
+	if !changed {
+		return channelConfig, nil
+	}
+	jsonBytes, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// EncryptChannels encrypts the keys and the config secrets of the channels which are stored
+// in plaintext or encrypted with a previous key, and returns how many channels it changed
+func EncryptChannels() (int, error) {
+	if !common.EncryptionEnabled() {
+		return 0, errors.New("ENCRYPTION_KEY is not set")
+	}
+	// the stored values are read and written as is
+	db := DB.Session(&gorm.Session{SkipHooks: true})
+	var channels []*Channel
+	if err := db.Find(&channels).Error; err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, channel := range channels {
+		key, err := common.ReencryptString(channel.Key)
+		if err != nil {
+			return count, fmt.Errorf("channel #%d: %w", channel.Id, err)
+		}
+		channelConfig, err := reencryptConfig(channel.Config)
+		if err != nil {
+			return count, fmt.Errorf("channel #%d: %w", channel.Id, err)
+		}
+		if key == channel.Key && channelConfig == channel.Config {
+			continue
+		}
+		err = db.Model(channel).UpdateColumns(map[string]any{"key": key, "config": channelConfig}).Error
+		if err != nil {
+			return count, fmt.Errorf("channel #%d: %w", channel.Id, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// ValidateConfig checks the channel config fields that are used as is when relaying
+func (channel *Channel) ValidateConfig() error {
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err = client.ValidateProxyURL(cfg.Proxy); err != nil {
+		return err
+	}
+	if err = client.ValidateLocalAddress(cfg.LocalAddress); err != nil {
+		return err
+	}
+	if cfg.TLS != nil {
+		return client.ValidateTLSOptions(cfg.TLS)
+	}
+	return nil
+}
+
 func UpdateChannelStatusById(id int, status int) {
 	err := UpdateAbilityStatus(id, status == ChannelStatusEnabled)
 	if err != nil {
 		logger.SysError("failed to update ability status: " + err.Error())
 	}
-	err = DB.Model(&Channel{}).Where("id = ?", id).Update("status", status).Error
+	err = DB.Model(&Channel{}).Where("id = ? AND status <> ?", id, ChannelStatusArchived).Update("status", status).Error
 	if err != nil {
 		logger.SysError("failed to update channel status: " + err.Error())
 	}
+	PublishInvalidation(InvalidationChannels, strconv.Itoa(id))
 }
 
 func UpdateChannelUsedQuota(id int, quota int64) {
@@ -203,22 +488,42 @@ func UpdateChannelUsedQuota(id int, quota int64) {
 		addNewRecord(BatchUpdateTypeChannelUsedQuota, id, quota)
 		return
 	}
-	updateChannelUsedQuota(id, quota)
-}
-
-func updateChannelUsedQuota(id int, quota int64) {
-	err := DB.Model(&Channel{}).Where("id = ?", id).Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+	err := updateChannelUsedQuota(id, quota)
 	if err != nil {
 		logger.SysError("failed to update channel used quota: " + err.Error())
 	}
 }
 
+func updateChannelUsedQuota(id int, quota int64) error {
+	return DB.Model(&Channel{}).Where("id = ?", id).Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+}
+
+// archiveChannels archives the channels matching the conditions, see Channel.Delete
+func archiveChannels(query any, args ...any) (int64, error) {
+	var ids []int
+	if err := DB.Model(&Channel{}).Where(query, args...).Where("status <> ?", ChannelStatusArchived).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	result := DB.Model(&Channel{}).Where("id IN ?", ids).
+		Updates(map[string]any{"status": ChannelStatusArchived, "archived_time": helper.GetTimestamp()})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	err := DB.Where("channel_id IN ?", ids).Delete(&Ability{}).Error
+	for _, id := range ids {
+		GetHealthTracker().Remove(id)
+	}
+	PublishInvalidation(InvalidationChannels, "")
+	return result.RowsAffected, err
+}
+
 func DeleteChannelByStatus(status int64) (int64, error) {
-	result := DB.Where("status = ?", status).Delete(&Channel{})
-	return result.RowsAffected, result.Error
+	return archiveChannels("status = ?", status)
 }
 
 func DeleteDisabledChannel() (int64, error) {
-	result := DB.Where("status = ? or status = ?", ChannelStatusAutoDisabled, ChannelStatusManuallyDisabled).Delete(&Channel{})
-	return result.RowsAffected, result.Error
+	return archiveChannels("status = ? or status = ?", ChannelStatusAutoDisabled, ChannelStatusManuallyDisabled)
 }
@@ -0,0 +1,428 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// A canary ramps the traffic of a new channel up step by step, e.g. to 1%, 5%, 25% and then
+// 100% of the requests for its models, each step lasting an interval. The outcomes of its
+// requests during a step are compared with the baseline, the requests of the other channels
+// for its models, and the canary is rolled back to no traffic with an alert as soon as its
+// error rate or its latency degrades. A completed canary leaves the channel to the usual
+// selection.
+
+const (
+	ChannelCanaryStatusRunning    = 1 // don't use 0, 0 is the default value!
+	ChannelCanaryStatusCompleted  = 2
+	ChannelCanaryStatusRolledBack = 3
+)
+
+const (
+	defaultCanarySteps             = "1,5,25,100"
+	defaultCanaryStepInterval      = 600 // seconds
+	defaultCanaryMinRequests       = 20
+	defaultCanaryMaxErrorRateDelta = 0.05
+	defaultCanaryMaxLatencyRatio   = 1.5
+)
+
+type ChannelCanary struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"index"`
+	Steps     string `json:"steps" gorm:"type:varchar(64)"` // comma separated percents of the traffic, the last one is 100
+	// StepInterval is how long a step lasts before the next one, in seconds
+	StepInterval int64 `json:"step_interval" gorm:"bigint"`
+	// MinRequests is the number of requests of the canary and of the baseline in a step
+	// before it is judged, a step only ends with enough of them
+	MinRequests int64 `json:"min_requests"`
+	// the canary is rolled back when its error rate exceeds the one of the baseline by more
+	// than MaxErrorRateDelta, or its mean latency the one of the baseline times MaxLatencyRatio
+	MaxErrorRateDelta float64 `json:"max_error_rate_delta"`
+	MaxLatencyRatio   float64 `json:"max_latency_ratio"`
+	Status            int     `json:"status" gorm:"default:1"`
+	Step              int     `json:"step"` // index of the current step in Steps
+	StepStartedTime   int64   `json:"step_started_time" gorm:"bigint"`
+	Reason            string  `json:"reason" gorm:"type:text"` // why it was rolled back
+	// outcomes of the current step
+	CanaryRequests     int64   `json:"canary_requests" gorm:"default:0"`
+	CanaryErrors       int64   `json:"canary_errors" gorm:"default:0"`
+	CanaryLatencySum   float64 `json:"canary_latency_sum" gorm:"default:0"` // milliseconds
+	BaselineRequests   int64   `json:"baseline_requests" gorm:"default:0"`
+	BaselineErrors     int64   `json:"baseline_errors" gorm:"default:0"`
+	BaselineLatencySum float64 `json:"baseline_latency_sum" gorm:"default:0"`
+	CreatedTime        int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime        int64   `json:"updated_time" gorm:"bigint"`
+
+	steps  []int
+	models map[string]bool
+}
+
+// OnCanaryRolledBack is called when a canary is rolled back, to alert the admins
+var OnCanaryRolledBack func(canary *ChannelCanary, channelName string)
+
+func parseCanarySteps(steps string) ([]int, error) {
+	var percents []int
+	for _, step := range strings.Split(steps, ",") {
+		percent, err := strconv.Atoi(strings.TrimSpace(step))
+		if err != nil || percent <= 0 || percent > 100 {
+			return nil, fmt.Errorf("invalid step %q, steps are percents above 0 and at most 100", step)
+		}
+		if len(percents) > 0 && percent <= percents[len(percents)-1] {
+			return nil, errors.New("the steps must increase")
+		}
+		percents = append(percents, percent)
+	}
+	if percents[len(percents)-1] != 100 {
+		return nil, errors.New("the last step must be 100")
+	}
+	return percents, nil
+}
+
+// Validate checks the canary and fills its defaults
+func (canary *ChannelCanary) Validate() error {
+	if canary.Steps == "" {
+		canary.Steps = defaultCanarySteps
+	}
+	if canary.StepInterval == 0 {
+		canary.StepInterval = defaultCanaryStepInterval
+	}
+	if canary.MinRequests == 0 {
+		canary.MinRequests = defaultCanaryMinRequests
+	}
+	if canary.MaxErrorRateDelta == 0 {
+		canary.MaxErrorRateDelta = defaultCanaryMaxErrorRateDelta
+	}
+	if canary.MaxLatencyRatio == 0 {
+		canary.MaxLatencyRatio = defaultCanaryMaxLatencyRatio
+	}
+	steps, err := parseCanarySteps(canary.Steps)
+	if err != nil {
+		return err
+	}
+	if len(steps) < 2 {
+		return errors.New("a canary needs at least two steps")
+	}
+	if canary.StepInterval < 0 || canary.MinRequests < 0 || canary.MaxErrorRateDelta < 0 || canary.MaxLatencyRatio < 1 {
+		return errors.New("the step interval, the min requests and the max error rate delta can't be negative, the max latency ratio is at least 1")
+	}
+	channel, err := GetChannelById(canary.ChannelId, false)
+	if err != nil {
+		return fmt.Errorf("channel #%d not found", canary.ChannelId)
+	}
+	if channel.Status == ChannelStatusArchived {
+		return fmt.Errorf("channel #%d is archived", canary.ChannelId)
+	}
+	var count int64
+	err = DB.Model(&ChannelCanary{}).Where("channel_id = ? AND id <> ? AND status <> ?", canary.ChannelId, canary.Id, ChannelCanaryStatusCompleted).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("channel #%d already has a canary", canary.ChannelId)
+	}
+	return nil
+}
+
+// Percent returns the share of the traffic of the canary, 0 once rolled back
+func (canary *ChannelCanary) Percent() int {
+	if canary.Status == ChannelCanaryStatusRolledBack || canary.Step >= len(canary.steps) {
+		return 0
+	}
+	return canary.steps[canary.Step]
+}
+
+func GetAllChannelCanaries() ([]*ChannelCanary, error) {
+	var canaries []*ChannelCanary
+	err := DB.Order("id desc").Find(&canaries).Error
+	return canaries, err
+}
+
+func GetChannelCanaryById(id int) (*ChannelCanary, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	canary := ChannelCanary{Id: id}
+	err := DB.First(&canary, "id = ?", id).Error
+	return &canary, err
+}
+
+// Insert starts the canary at its first step
+func (canary *ChannelCanary) Insert() error {
+	canary.CreatedTime = helper.GetTimestamp()
+	canary.UpdatedTime = canary.CreatedTime
+	canary.StepStartedTime = canary.CreatedTime
+	canary.Status = ChannelCanaryStatusRunning
+	canary.Step = 0
+	return DB.Create(canary).Error
+}
+
+// Restart runs a canary again from its first step, after a rollback
+func (canary *ChannelCanary) Restart() error {
+	now := helper.GetTimestamp()
+	return DB.Model(canary).Updates(map[string]any{
+		"status":               ChannelCanaryStatusRunning,
+		"step":                 0,
+		"step_started_time":    now,
+		"reason":               "",
+		"canary_requests":      0,
+		"canary_errors":        0,
+		"canary_latency_sum":   0,
+		"baseline_requests":    0,
+		"baseline_errors":      0,
+		"baseline_latency_sum": 0,
+		"updated_time":         now,
+	}).Error
+}
+
+// Delete ends the canary, its channel is selected as usual again
+func (canary *ChannelCanary) Delete() error {
+	return DB.Delete(canary).Error
+}
+
+var activeCanaries []*ChannelCanary
+var canarySyncLock sync.RWMutex
+
+// InitCanaryCache loads the running and rolled back canaries with the models of their
+// channels into memory
+func InitCanaryCache() {
+	var canaries []*ChannelCanary
+	err := DB.Where("status <> ?", ChannelCanaryStatusCompleted).Find(&canaries).Error
+	if err != nil {
+		logger.SysError("failed to load channel canaries: " + err.Error())
+		return
+	}
+	active := make([]*ChannelCanary, 0, len(canaries))
+	for _, canary := range canaries {
+		steps, err := parseCanarySteps(canary.Steps)
+		channel, channelErr := GetChannelById(canary.ChannelId, false)
+		if err != nil || channelErr != nil {
+			logger.SysError(fmt.Sprintf("skipping invalid canary #%d", canary.Id))
+			continue
+		}
+		canary.steps = steps
+		canary.models = make(map[string]bool)
+		for _, model := range strings.Split(channel.Models, ",") {
+			canary.models[model] = true
+		}
+		active = append(active, canary)
+	}
+	canarySyncLock.Lock()
+	activeCanaries = active
+	canarySyncLock.Unlock()
+}
+
+// SyncCanaries saves the outcomes recorded since the last sync, moves the canaries forward
+// or rolls them back on the master node, and reloads them
+func SyncCanaries(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushCanaryOutcomes()
+		if config.IsMasterNode {
+			if err := evaluateCanaries(); err != nil {
+				logger.SysError("failed to evaluate channel canaries: " + err.Error())
+			}
+		}
+		InitCanaryCache()
+	}
+}
+
+// withCanaryShares lets the canaries among the channels serve their share of the requests:
+// a request is given a canary for its share, or the other channels. The channels are kept
+// when they are all canaries.
+func withCanaryShares(channels []*Channel) []*Channel {
+	canarySyncLock.RLock()
+	defer canarySyncLock.RUnlock()
+	if len(activeCanaries) == 0 {
+		return channels
+	}
+	percents := make(map[int]int, len(activeCanaries))
+	for _, canary := range activeCanaries {
+		percents[canary.ChannelId] = canary.Percent()
+	}
+	var canaries []*Channel
+	others := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if _, ok := percents[channel.Id]; ok {
+			canaries = append(canaries, channel)
+		} else {
+			others = append(others, channel)
+		}
+	}
+	if len(canaries) == 0 || len(others) == 0 {
+		return channels
+	}
+	point := rand.Float64() * 100
+	for _, channel := range canaries {
+		if point < float64(percents[channel.Id]) {
+			return []*Channel{channel}
+		}
+		point -= float64(percents[channel.Id])
+	}
+	return others
+}
+
+type canaryStepKey struct {
+	canaryId int
+	step     int
+}
+
+type canaryOutcomes struct {
+	canaryRequests     int64
+	canaryErrors       int64
+	canaryLatencySum   float64
+	baselineRequests   int64
+	baselineErrors     int64
+	baselineLatencySum float64
+}
+
+var canaryOutcomesLock sync.Mutex
+var canaryOutcomesPending = make(map[canaryStepKey]*canaryOutcomes)
+
+// RecordCanaryOutcome records a request relayed by a channel in the current step of the
+// running canaries, on the side of the canary or of their baseline
+func RecordCanaryOutcome(channelId int, modelName string, latency time.Duration, failed bool) {
+	canarySyncLock.RLock()
+	defer canarySyncLock.RUnlock()
+	for _, canary := range activeCanaries {
+		if canary.Status != ChannelCanaryStatusRunning || (channelId != canary.ChannelId && !canary.models[modelName]) {
+			continue
+		}
+		key := canaryStepKey{canaryId: canary.Id, step: canary.Step}
+		ms := float64(latency.Milliseconds())
+		canaryOutcomesLock.Lock()
+		outcomes, ok := canaryOutcomesPending[key]
+		if !ok {
+			outcomes = &canaryOutcomes{}
+			canaryOutcomesPending[key] = outcomes
+		}
+		if channelId == canary.ChannelId {
+			outcomes.canaryRequests++
+			outcomes.canaryLatencySum += ms
+			if failed {
+				outcomes.canaryErrors++
+			}
+		} else {
+			outcomes.baselineRequests++
+			outcomes.baselineLatencySum += ms
+			if failed {
+				outcomes.baselineErrors++
+			}
+		}
+		canaryOutcomesLock.Unlock()
+	}
+}
+
+// FlushCanaryOutcomes adds the outcomes recorded in memory to their canaries, those of a step
+// which is over are dropped
+func FlushCanaryOutcomes() {
+	canaryOutcomesLock.Lock()
+	pending := canaryOutcomesPending
+	canaryOutcomesPending = make(map[canaryStepKey]*canaryOutcomes)
+	canaryOutcomesLock.Unlock()
+
+	for key, outcomes := range pending {
+		err := DB.Model(&ChannelCanary{}).
+			Where("id = ? AND step = ? AND status = ?", key.canaryId, key.step, ChannelCanaryStatusRunning).
+			Updates(map[string]any{
+				"canary_requests":      gorm.Expr("canary_requests + ?", outcomes.canaryRequests),
+				"canary_errors":        gorm.Expr("canary_errors + ?", outcomes.canaryErrors),
+				"canary_latency_sum":   gorm.Expr("canary_latency_sum + ?", outcomes.canaryLatencySum),
+				"baseline_requests":    gorm.Expr("baseline_requests + ?", outcomes.baselineRequests),
+				"baseline_errors":      gorm.Expr("baseline_errors + ?", outcomes.baselineErrors),
+				"baseline_latency_sum": gorm.Expr("baseline_latency_sum + ?", outcomes.baselineLatencySum),
+			}).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to save the outcomes of canary #%d: %s", key.canaryId, err.Error()))
+		}
+	}
+}
+
+// degradation returns why the canary degraded in its current step, empty when it did not or
+// when there are not enough requests to tell
+func (canary *ChannelCanary) degradation() string {
+	if canary.CanaryRequests < canary.MinRequests || canary.BaselineRequests < canary.MinRequests {
+		return ""
+	}
+	errorRate := float64(canary.CanaryErrors) / float64(canary.CanaryRequests)
+	baselineErrorRate := float64(canary.BaselineErrors) / float64(canary.BaselineRequests)
+	if errorRate-baselineErrorRate > canary.MaxErrorRateDelta {
+		return fmt.Sprintf("error rate %.2f%% against %.2f%% for the baseline", errorRate*100, baselineErrorRate*100)
+	}
+	latency := canary.CanaryLatencySum / float64(canary.CanaryRequests)
+	baselineLatency := canary.BaselineLatencySum / float64(canary.BaselineRequests)
+	if baselineLatency > 0 && latency > baselineLatency*canary.MaxLatencyRatio {
+		return fmt.Sprintf("mean latency %.0fms against %.0fms for the baseline", latency, baselineLatency)
+	}
+	return ""
+}
+
+// evaluateCanaries rolls back the degraded canaries and moves the others whose step is over
+// to their next step
+func evaluateCanaries() error {
+	var canaries []*ChannelCanary
+	if err := DB.Where("status = ?", ChannelCanaryStatusRunning).Find(&canaries).Error; err != nil {
+		return err
+	}
+	now := helper.GetTimestamp()
+	for _, canary := range canaries {
+		steps, err := parseCanarySteps(canary.Steps)
+		if err != nil {
+			continue
+		}
+		// the update only applies to the step which was evaluated
+		current := DB.Model(&ChannelCanary{}).Where("id = ? AND step = ? AND status = ?", canary.Id, canary.Step, ChannelCanaryStatusRunning)
+		if reason := canary.degradation(); reason != "" {
+			result := current.Updates(map[string]any{"status": ChannelCanaryStatusRolledBack, "reason": reason, "updated_time": now})
+			if result.Error != nil || result.RowsAffected == 0 {
+				continue
+			}
+			canary.Status, canary.Reason = ChannelCanaryStatusRolledBack, reason
+			channelName := fmt.Sprintf("#%d", canary.ChannelId)
+			if names, err := GetChannelNames([]int{canary.ChannelId}); err == nil && names[canary.ChannelId] != "" {
+				channelName = names[canary.ChannelId]
+			}
+			logger.SysLog(fmt.Sprintf("canary of channel %s rolled back at %d%%: %s", channelName, steps[canary.Step], reason))
+			if OnCanaryRolledBack != nil {
+				OnCanaryRolledBack(canary, channelName)
+			}
+			continue
+		}
+		if now-canary.StepStartedTime < canary.StepInterval || canary.CanaryRequests < canary.MinRequests {
+			continue
+		}
+		next := canary.Step + 1
+		status := ChannelCanaryStatusRunning
+		if next >= len(steps)-1 {
+			next = len(steps) - 1
+			status = ChannelCanaryStatusCompleted
+		}
+		err = current.Updates(map[string]any{
+			"status":               status,
+			"step":                 next,
+			"step_started_time":    now,
+			"canary_requests":      0,
+			"canary_errors":        0,
+			"canary_latency_sum":   0,
+			"baseline_requests":    0,
+			"baseline_errors":      0,
+			"baseline_latency_sum": 0,
+			"updated_time":         now,
+		}).Error
+		if err != nil {
+			return err
+		}
+		logger.SysLog(fmt.Sprintf("canary of channel #%d moved to %d%% of the traffic", canary.ChannelId, steps[next]))
+	}
+	return nil
+}
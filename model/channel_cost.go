@@ -0,0 +1,210 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// The consume logs record the quota billed to the users and the upstream quota, what the
+// provider of the channel is estimated to charge for the request, see UpstreamModelRatio and
+// the cost ratio of the channels. The rollups sum both per channel and model for the margin
+// reports, and the invoices of the providers are reconciled with the estimates.
+
+// ChannelMargin sums the quota billed and the upstream quota of a channel, and of a model
+// when the margins are per model
+type ChannelMargin struct {
+	ChannelId     int     `json:"channel"`
+	ChannelName   string  `json:"channel_name" gorm:"-"`
+	ModelName     string  `json:"model_name,omitempty"`
+	Requests      int64   `json:"requests"`
+	Quota         int64   `json:"quota"`
+	UpstreamQuota int64   `json:"upstream_quota"`
+	MarginQuota   int64   `json:"margin_quota" gorm:"-"`
+	MarginRate    float64 `json:"margin_rate" gorm:"-"`   // margin of the quota billed, 0 when nothing was billed
+	Cost          float64 `json:"cost" gorm:"-"`          // quota in Currency
+	UpstreamCost  float64 `json:"upstream_cost" gorm:"-"` // upstream quota in Currency
+	Margin        float64 `json:"margin" gorm:"-"`        // margin quota in Currency
+	Currency      string  `json:"currency" gorm:"-"`
+}
+
+// GetChannelMargins sums the rollups matching the filter per channel, and per model too when
+// byModel is set
+func GetChannelMargins(filter UsageRollupFilter, byModel bool) (margins []*ChannelMargin, err error) {
+	groups := "channel_id"
+	if byModel {
+		groups = "channel_id, model_name"
+	}
+	tx := LOG_DB.Model(&UsageRollup{}).Select(groups + `, sum(requests) as requests, sum(quota) as quota,
+		sum(upstream_quota) as upstream_quota`)
+	if filter.Start != 0 {
+		tx = tx.Where("hour >= ?", filter.Start-filter.Start%usageRollupHour)
+	}
+	if filter.End != 0 {
+		tx = tx.Where("hour <= ?", filter.End)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	err = tx.Where("channel_id <> 0").Group(groups).Order(groups).Scan(&margins).Error
+	if err != nil {
+		return nil, err
+	}
+	var channels []*Channel
+	if err = DB.Select("id", "name").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[int]string, len(channels))
+	for _, channel := range channels {
+		names[channel.Id] = channel.Name
+	}
+	for _, margin := range margins {
+		margin.ChannelName = names[margin.ChannelId]
+		margin.MarginQuota = margin.Quota - margin.UpstreamQuota
+		if margin.Quota != 0 {
+			margin.MarginRate = float64(margin.MarginQuota) / float64(margin.Quota)
+		}
+	}
+	return margins, nil
+}
+
+// ChannelInvoice is the total a provider invoiced for a channel over a period
+type ChannelInvoice struct {
+	Id          int     `json:"id"`
+	ChannelId   int     `json:"channel_id" gorm:"index"`
+	PeriodStart int64   `json:"period_start" gorm:"bigint"`
+	PeriodEnd   int64   `json:"period_end" gorm:"bigint"` // the period is [PeriodStart, PeriodEnd)
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency" gorm:"type:varchar(8)"`
+	Reference   string  `json:"reference" gorm:"type:varchar(128);default:''"` // number of the invoice at the provider
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+}
+
+func (i *ChannelInvoice) Validate() error {
+	i.Currency = strings.ToUpper(strings.TrimSpace(i.Currency))
+	if i.Currency == "" {
+		i.Currency = currency.USD
+	}
+	if !currency.IsCode(i.Currency) {
+		return fmt.Errorf("unknown currency %s", i.Currency)
+	}
+	if i.PeriodEnd <= i.PeriodStart {
+		return errors.New("the period of the invoice ends before it starts")
+	}
+	if i.Amount < 0 {
+		return errors.New("the amount of the invoice is negative")
+	}
+	if len(i.Reference) > 128 {
+		return errors.New("the reference of the invoice is too long")
+	}
+	if _, err := GetChannelById(i.ChannelId, false); err != nil {
+		return fmt.Errorf("channel #%d does not exist", i.ChannelId)
+	}
+	return nil
+}
+
+// ImportChannelInvoices adds invoices, an invoice replaces the one of its channel with the
+// same reference so importing them again updates them
+func ImportChannelInvoices(invoices []*ChannelInvoice) error {
+	for _, invoice := range invoices {
+		if err := invoice.Validate(); err != nil {
+			return err
+		}
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, invoice := range invoices {
+			invoice.Id = 0
+			invoice.CreatedTime = helper.GetTimestamp()
+			if invoice.Reference != "" {
+				var existing ChannelInvoice
+				err := tx.Where("channel_id = ? AND reference = ?", invoice.ChannelId, invoice.Reference).Limit(1).Find(&existing).Error
+				if err != nil {
+					return err
+				}
+				if existing.Id != 0 {
+					invoice.Id = existing.Id
+					if err = tx.Save(invoice).Error; err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := tx.Create(invoice).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetChannelInvoices lists the invoices of a channel, of all the channels when it is 0, the
+// latest periods first
+func GetChannelInvoices(channelId int, startIdx int, num int) ([]*ChannelInvoice, error) {
+	var invoices []*ChannelInvoice
+	tx := DB.Order("period_start desc, id desc")
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	err := tx.Limit(num).Offset(startIdx).Find(&invoices).Error
+	return invoices, err
+}
+
+func DeleteChannelInvoiceById(id int) error {
+	result := DB.Delete(&ChannelInvoice{}, "id = ?", id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return fmt.Errorf("invoice #%d does not exist", id)
+	}
+	return result.Error
+}
+
+// ChannelReconciliation compares an invoice with the upstream quota estimated for its channel
+// over its period, the amounts are in the currency of the invoice
+type ChannelReconciliation struct {
+	Invoice       *ChannelInvoice `json:"invoice"`
+	Requests      int64           `json:"requests"`
+	Quota         int64           `json:"quota"`
+	UpstreamQuota int64           `json:"upstream_quota"`
+	Billed        float64         `json:"billed"`     // billed to the users
+	Estimated     float64         `json:"estimated"`  // estimated cost at the provider
+	Delta         float64         `json:"delta"`      // invoiced minus estimated
+	DeltaRate     float64         `json:"delta_rate"` // delta of the estimate, 0 when nothing was estimated
+	Margin        float64         `json:"margin"`     // billed minus invoiced
+}
+
+// ReconcileChannelInvoice sums the rollups of the channel of an invoice over its period
+func ReconcileChannelInvoice(invoice *ChannelInvoice) (*ChannelReconciliation, error) {
+	var sums struct {
+		Requests      int64
+		Quota         int64
+		UpstreamQuota int64
+	}
+	err := LOG_DB.Model(&UsageRollup{}).
+		Select("coalesce(sum(requests), 0) as requests, coalesce(sum(quota), 0) as quota, coalesce(sum(upstream_quota), 0) as upstream_quota").
+		Where("channel_id = ? AND hour >= ? AND hour < ?", invoice.ChannelId, invoice.PeriodStart, invoice.PeriodEnd).
+		Scan(&sums).Error
+	if err != nil {
+		return nil, err
+	}
+	reconciliation := ChannelReconciliation{
+		Invoice:       invoice,
+		Requests:      sums.Requests,
+		Quota:         sums.Quota,
+		UpstreamQuota: sums.UpstreamQuota,
+	}
+	reconciliation.Billed = currency.FromQuota(reconciliation.Quota, invoice.Currency)
+	reconciliation.Estimated = currency.FromQuota(reconciliation.UpstreamQuota, invoice.Currency)
+	reconciliation.Delta = invoice.Amount - reconciliation.Estimated
+	if reconciliation.Estimated != 0 {
+		reconciliation.DeltaRate = reconciliation.Delta / reconciliation.Estimated
+	}
+	reconciliation.Margin = reconciliation.Billed - invoice.Amount
+	return &reconciliation, nil
+}
@@ -9,15 +9,27 @@ type ChannelSelectionInfo struct {
 
 // CacheGetHealthiestChannel selects the channel with the best health metrics
 // Returns the selected channel along with selection metadata
-func CacheGetHealthiestChannel(group string, model string) (*ChannelSelectionInfo, error) {
-	channel, err := CacheGetSmartChannel(group, model, false)
+func CacheGetHealthiestChannel(tenantId int, group string, model string) (*ChannelSelectionInfo, error) {
+	channel, err := CacheGetSmartChannel(tenantId, group, model, false)
 	if err != nil {
 		return nil, err
 	}
-	
+	return channelSelectionInfo(tenantId, group, model, channel), nil
+}
+
+// CacheGetStrategyChannel selects a channel with a selection strategy, as balanced or cost
+func CacheGetStrategyChannel(tenantId int, group string, model string, strategyName string) (*ChannelSelectionInfo, error) {
+	channel, err := CacheGetChannelWithStrategy(tenantId, group, model, strategyName)
+	if err != nil {
+		return nil, err
+	}
+	return channelSelectionInfo(tenantId, group, model, channel), nil
+}
+
+func channelSelectionInfo(tenantId int, group string, model string, channel *Channel) *ChannelSelectionInfo {
 	// Get available channel count
 	channelSyncLock.RLock()
-	channels := group2model2channels[group][model]
+	channels := tenant2group2model2channels[tenantId][group][model]
 	availableCount := len(channels)
 	channelSyncLock.RUnlock()
 	
@@ -37,5 +49,42 @@ func CacheGetHealthiestChannel(group string, model string) (*ChannelSelectionInf
 		Channel:        channel,
 		AvailableCount: availableCount,
 		SelectionScore: score,
-	}, nil
+	}
+}
+
+// ChannelCandidate is a channel a request could be relayed by, with the score the selection
+// compared it by, for the request traces
+type ChannelCandidate struct {
+	Id        int     `json:"id"`
+	Name      string  `json:"name"`
+	Priority  int64   `json:"priority"`
+	Score     float64 `json:"score"`
+	Throttled bool    `json:"throttled,omitempty"`
+}
+
+// GetChannelCandidates returns the channels of a model of a group with their scores, under a
+// selection strategy or the default scoring when empty
+func GetChannelCandidates(tenantId int, group string, model string, strategyName string) []ChannelCandidate {
+	channelSyncLock.RLock()
+	channels := tenant2group2model2channels[tenantId][group][model]
+	channelSyncLock.RUnlock()
+
+	selector := GetSmartChannelSelector()
+	candidates := make([]ChannelCandidate, 0, len(channels))
+	for _, channel := range channels {
+		var score float64
+		if strategyName != "" {
+			score = selector.getChannelScoreWithStrategy(channel, GetStrategy(strategyName))
+		} else {
+			score = selector.getChannelScore(channel)
+		}
+		candidates = append(candidates, ChannelCandidate{
+			Id:        channel.Id,
+			Name:      channel.Name,
+			Priority:  channel.GetPriority(),
+			Score:     score,
+			Throttled: selector.tracker.IsThrottled(channel.Id),
+		})
+	}
+	return candidates
 }
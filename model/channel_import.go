@@ -0,0 +1,194 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+)
+
+// The channels are exported to files and imported in bulk, to move them between gateways or
+// to migrate them from other gateways. A file holds channels in the format of the gateway
+// config; the keys and the config secrets are left out of the exports, or sealed with a key
+// derived from a passphrase. The channels of an import are validated and those duplicating
+// a channel, by name or by key, are skipped.
+
+const (
+	ChannelSecretsOmit    = "omit"
+	ChannelSecretsEncrypt = "encrypt"
+)
+
+const (
+	ChannelImportCreate  = "create"
+	ChannelImportSkip    = "skip"    // duplicate of a channel
+	ChannelImportInvalid = "invalid" // nothing is imported while a channel is invalid
+)
+
+// ChannelExport is an export of the channels
+type ChannelExport struct {
+	Version    int               `json:"version"`
+	ExportedAt int64             `json:"exported_at"`
+	Secrets    string            `json:"secrets"` // omit or encrypt
+	Encryption *common.ExportKey `json:"encryption,omitempty"`
+	Channels   []ConfigChannel   `json:"channels"`
+}
+
+// ExportChannels exports the channels which are not archived, their secrets left out or
+// encrypted with the passphrase
+func ExportChannels(secrets string, passphrase string) (*ChannelExport, error) {
+	export := &ChannelExport{Version: 1, ExportedAt: helper.GetTimestamp(), Secrets: secrets, Channels: make([]ConfigChannel, 0)}
+	var err error
+	switch secrets {
+	case ChannelSecretsOmit:
+	case ChannelSecretsEncrypt:
+		if export.Encryption, err = common.NewExportKey(passphrase); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown secrets %s, use omit or encrypt", secrets)
+	}
+	var channels []*Channel
+	if err = DB.Where("status <> ?", ChannelStatusArchived).Order("id asc").Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		configChannel, err := toConfigChannel(channel)
+		if err != nil {
+			return nil, fmt.Errorf("channel %s: %w", channel.Name, err)
+		}
+		for _, secret := range append(configChannel.Config.secrets(), &configChannel.Key) {
+			if export.Encryption == nil {
+				*secret = ""
+			} else if *secret, err = export.Encryption.Seal(*secret); err != nil {
+				return nil, err
+			}
+		}
+		if isEmptyChannelConfig(configChannel.Config) {
+			configChannel.Config = nil
+		}
+		export.Channels = append(export.Channels, *configChannel)
+	}
+	return export, nil
+}
+
+// Unseal decrypts the secrets of an export with its passphrase
+func (e *ChannelExport) Unseal(passphrase string) error {
+	if e.Secrets != ChannelSecretsEncrypt {
+		return nil
+	}
+	if e.Encryption == nil {
+		return errors.New("the encryption of the export is missing")
+	}
+	if err := e.Encryption.Open(passphrase); err != nil {
+		return err
+	}
+	for i := range e.Channels {
+		secrets := []*string{&e.Channels[i].Key}
+		if e.Channels[i].Config != nil {
+			secrets = append(secrets, e.Channels[i].Config.secrets()...)
+		}
+		for _, secret := range secrets {
+			var err error
+			if *secret, err = e.Encryption.Unseal(*secret); err != nil {
+				return fmt.Errorf("channel %s: %w", e.Channels[i].Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ChannelImportResult is what importing a channel does, or would do in a dry run
+type ChannelImportResult struct {
+	Line   int    `json:"line"` // position of the channel in the file, from 1
+	Name   string `json:"name"`
+	Action string `json:"action"` // create, skip or invalid
+	Reason string `json:"reason,omitempty"`
+}
+
+// validateImportedChannel checks a channel of an import and returns it as a channel to create
+func validateImportedChannel(c *ConfigChannel) (*Channel, error) {
+	c.Name = strings.TrimSpace(c.Name)
+	c.Key = strings.TrimSpace(c.Key)
+	if c.Name == "" {
+		return nil, errors.New("the name is empty")
+	}
+	if c.Type <= channeltype.Unknown || c.Type >= channeltype.Dummy {
+		return nil, fmt.Errorf("unknown type %d", c.Type)
+	}
+	if c.Key == "" {
+		return nil, errors.New("the key is empty, exports without secrets can't be imported")
+	}
+	if strings.TrimSpace(c.Models) == "" {
+		return nil, errors.New("no models")
+	}
+	if c.Group == "" {
+		c.Group = "default"
+	}
+	switch c.Status {
+	case 0:
+		c.Status = ChannelStatusEnabled
+	case ChannelStatusEnabled, ChannelStatusManuallyDisabled:
+	default:
+		return nil, fmt.Errorf("invalid status %d, use 1 (enabled) or 2 (disabled)", c.Status)
+	}
+	channel := &Channel{CreatedTime: helper.GetTimestamp()}
+	if err := c.toChannel(channel); err != nil {
+		return nil, err
+	}
+	return channel, nil
+}
+
+// ImportChannels creates the channels of an import, unless it is a dry run, and returns what
+// is done with each of them. Nothing is imported when a channel is invalid.
+func ImportChannels(channels []ConfigChannel, dryRun bool) ([]*ChannelImportResult, error) {
+	var existing []*Channel
+	if err := DB.Where("status <> ?", ChannelStatusArchived).Find(&existing).Error; err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(existing))
+	keys := make(map[string]bool, len(existing))
+	channelKey := func(channelType int, key string) string {
+		return fmt.Sprintf("%d:%s", channelType, key)
+	}
+	for _, channel := range existing {
+		names[channel.Name] = true
+		keys[channelKey(channel.Type, channel.Key)] = true
+	}
+	results := make([]*ChannelImportResult, 0, len(channels))
+	creates := make([]Channel, 0, len(channels))
+	invalid := 0
+	for i := range channels {
+		result := &ChannelImportResult{Line: i + 1, Name: channels[i].Name, Action: ChannelImportCreate}
+		results = append(results, result)
+		channel, err := validateImportedChannel(&channels[i])
+		switch {
+		case err != nil:
+			result.Action, result.Reason = ChannelImportInvalid, err.Error()
+			invalid++
+			continue
+		case names[channels[i].Name]:
+			result.Action, result.Reason = ChannelImportSkip, "a channel has the same name"
+			continue
+		case keys[channelKey(channels[i].Type, channels[i].Key)]:
+			result.Action, result.Reason = ChannelImportSkip, "a channel of the same type has the same key"
+			continue
+		}
+		// the channels of the file are duplicates of the ones above them
+		names[channels[i].Name] = true
+		keys[channelKey(channels[i].Type, channels[i].Key)] = true
+		creates = append(creates, *channel)
+	}
+	if dryRun {
+		return results, nil
+	}
+	if invalid > 0 {
+		return results, fmt.Errorf("%d channels are invalid, nothing was imported", invalid)
+	}
+	if len(creates) == 0 {
+		return results, nil
+	}
+	return results, BatchInsertChannels(creates)
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// The health tracker keeps the latest response latencies, the time until the response headers,
+// of each model of each channel. The adaptive timeouts bound the requests by a multiple of
+// their p99 instead of the static response timeout of the provider, which is far too long for
+// a fast model and can be too short for a reasoning one.
+
+const (
+	latencyWindowSize = 256
+	// a model of a channel with fewer latencies keeps the static timeouts
+	adaptiveTimeoutMinSamples = 20
+	adaptiveTimeoutPercentile = 0.99
+)
+
+// latencyWindow is a ring of the latest latencies
+type latencyWindow struct {
+	samples [latencyWindowSize]time.Duration
+	count   int
+	next    int
+}
+
+func (w *latencyWindow) add(latency time.Duration) {
+	w.samples[w.next] = latency
+	w.next = (w.next + 1) % latencyWindowSize
+	if w.count < latencyWindowSize {
+		w.count++
+	}
+}
+
+func (w *latencyWindow) percentile(p float64) time.Duration {
+	samples := append([]time.Duration(nil), w.samples[:w.count]...)
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	index := int(math.Ceil(p*float64(len(samples)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return samples[index]
+}
+
+var (
+	responseLatencies     = make(map[int]map[string]*latencyWindow)
+	responseLatenciesLock sync.Mutex
+)
+
+// RecordResponseLatency records the time a channel took to answer a request for a model
+func (t *ChannelHealthTracker) RecordResponseLatency(channelId int, model string, latency time.Duration) {
+	responseLatenciesLock.Lock()
+	defer responseLatenciesLock.Unlock()
+	models, ok := responseLatencies[channelId]
+	if !ok {
+		models = make(map[string]*latencyWindow)
+		responseLatencies[channelId] = models
+	}
+	window, ok := models[model]
+	if !ok {
+		window = &latencyWindow{}
+		models[model] = window
+	}
+	window.add(latency)
+}
+
+// ResponseLatencyPercentile returns a percentile of the latest response latencies of a model of
+// a channel, with their count
+func (t *ChannelHealthTracker) ResponseLatencyPercentile(channelId int, model string, p float64) (time.Duration, int) {
+	responseLatenciesLock.Lock()
+	defer responseLatenciesLock.Unlock()
+	window, ok := responseLatencies[channelId][model]
+	if !ok || window.count == 0 {
+		return 0, 0
+	}
+	return window.percentile(p), window.count
+}
+
+func forgetResponseLatencies(channelId int) {
+	responseLatenciesLock.Lock()
+	delete(responseLatencies, channelId)
+	responseLatenciesLock.Unlock()
+}
+
+// AdaptiveResponseTimeout returns how long a channel may take to answer a request for a model:
+// its p99 response latency times AdaptiveTimeoutMultiplier, within the bounds of the adaptive
+// timeouts. It is 0 when they are disabled or the latencies are too few.
+func AdaptiveResponseTimeout(channelId int, model string) time.Duration {
+	if !config.AdaptiveTimeoutEnabled {
+		return 0
+	}
+	p99, count := GetHealthTracker().ResponseLatencyPercentile(channelId, model, adaptiveTimeoutPercentile)
+	if count < adaptiveTimeoutMinSamples {
+		return 0
+	}
+	timeout := time.Duration(float64(p99) * config.AdaptiveTimeoutMultiplier)
+	if floor := time.Duration(config.AdaptiveTimeoutMin) * time.Second; timeout < floor {
+		timeout = floor
+	}
+	if ceiling := time.Duration(config.AdaptiveTimeoutMax) * time.Second; ceiling > 0 && timeout > ceiling {
+		timeout = ceiling
+	}
+	return timeout
+}
@@ -4,6 +4,8 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
 )
 
 // ChannelHealth tracks the health metrics of a channel
@@ -17,6 +19,7 @@ type ChannelHealth struct {
 	LastError      time.Time
 	LastSuccess    time.Time
 	ConsecutiveFail int
+	ThrottledUntil time.Time // set by a 429 of the provider with a Retry-After header
 	mu             sync.RWMutex
 }
 
@@ -64,6 +67,14 @@ func (t *ChannelHealthTracker) GetOrCreate(channelId int) *ChannelHealth {
 	return h
 }
 
+// Remove forgets the health of a channel, when it is archived
+func (t *ChannelHealthTracker) Remove(channelId int) {
+	t.mu.Lock()
+	delete(t.channels, channelId)
+	t.mu.Unlock()
+	forgetResponseLatencies(channelId)
+}
+
 // RecordSuccess records a successful request
 func (t *ChannelHealthTracker) RecordSuccess(channelId int, latency time.Duration) {
 	h := t.GetOrCreate(channelId)
@@ -92,6 +103,28 @@ func (t *ChannelHealthTracker) RecordFailure(channelId int, latency time.Duratio
 	h.ConsecutiveFail++
 }
 
+// Throttle makes the selectors skip a channel until a time, a longer throttle already set is kept
+func (t *ChannelHealthTracker) Throttle(channelId int, until time.Time) {
+	h := t.GetOrCreate(channelId)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if until.After(h.ThrottledUntil) {
+		h.ThrottledUntil = until
+	}
+}
+
+// IsThrottled tells whether a channel is skipped because its provider asked to retry later
+func (t *ChannelHealthTracker) IsThrottled(channelId int) bool {
+	h := t.GetHealth(channelId)
+	if h == nil {
+		return false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return time.Now().Before(h.ThrottledUntil)
+}
+
 // GetHealth returns the health record for a channel
 func (t *ChannelHealthTracker) GetHealth(channelId int) *ChannelHealth {
 	t.mu.RLock()
@@ -419,18 +452,21 @@ func (s *SmartChannelSelector) getChannelScoreWithStrategy(channel *Channel, str
 }
 
 // CacheGetChannelWithStrategy gets a channel using strategy-based selection
-func CacheGetChannelWithStrategy(group string, model string, strategyName string) (*Channel, error) {
+func CacheGetChannelWithStrategy(tenantId int, group string, model string, strategyName string) (*Channel, error) {
 	channelSyncLock.RLock()
-	channels := group2model2channels[group][model]
+	channels := tenant2group2model2channels[tenantId][group][model]
 	channelSyncLock.RUnlock()
 
 	if len(channels) == 0 {
 		return nil, ErrNoAvailableChannel
 	}
 
+	if strategyName == "" {
+		strategyName = config.SelectionStrategy
+	}
 	strategy := GetStrategy(strategyName)
 	selector := GetSmartChannelSelector()
-	channel := selector.SelectChannelWithStrategy(channels, strategy)
+	channel := selector.SelectChannelWithStrategy(withCanaryShares(withoutThrottledChannels(channels)), strategy)
 
 	if channel == nil {
 		return nil, ErrNoAvailableChannel
@@ -455,18 +491,18 @@ func GetSmartChannelSelector() *SmartChannelSelector {
 
 // CacheGetSmartChannel gets a channel using smart selection
 // This is the enhanced version of CacheGetRandomSatisfiedChannel
-func CacheGetSmartChannel(group string, model string, ignoreFirstPriority bool) (*Channel, error) {
+func CacheGetSmartChannel(tenantId int, group string, model string, ignoreFirstPriority bool) (*Channel, error) {
 	channelSyncLock.RLock()
-	channels := group2model2channels[group][model]
+	channels := tenant2group2model2channels[tenantId][group][model]
 	channelSyncLock.RUnlock()
 
 	if len(channels) == 0 {
 		// Fallback to database query
-		return GetRandomSatisfiedChannel(group, model, ignoreFirstPriority)
+		return GetRandomSatisfiedChannel(tenantId, group, model, ignoreFirstPriority)
 	}
 
 	selector := GetSmartChannelSelector()
-	channel := selector.SelectChannelWithPriority(channels, ignoreFirstPriority)
+	channel := selector.SelectChannelWithPriority(withCanaryShares(withoutThrottledChannels(channels)), ignoreFirstPriority)
 
 	if channel == nil {
 		return nil, ErrNoAvailableChannel
@@ -486,6 +522,34 @@ func RecordChannelResult(channelId int, latency time.Duration, success bool) {
 	}
 }
 
+// ThrottleChannel makes the selectors skip a channel for the wait of the Retry-After header of
+// a 429, capped by MaxChannelThrottle
+func ThrottleChannel(channelId int, wait time.Duration) {
+	if maxWait := time.Duration(config.MaxChannelThrottle) * time.Second; wait > maxWait {
+		wait = maxWait
+	}
+	if wait <= 0 {
+		return
+	}
+	GetHealthTracker().Throttle(channelId, time.Now().Add(wait))
+}
+
+// withoutThrottledChannels removes the throttled channels, keeping the order by priority,
+// all the channels are kept when every one of them is throttled
+func withoutThrottledChannels(channels []*Channel) []*Channel {
+	tracker := GetHealthTracker()
+	available := make([]*Channel, 0, len(channels))
+	for _, channel := range channels {
+		if !tracker.IsThrottled(channel.Id) {
+			available = append(available, channel)
+		}
+	}
+	if len(available) == 0 {
+		return channels
+	}
+	return available
+}
+
 // GetChannelHealthStats returns health stats for all tracked channels
 func GetChannelHealthStats() map[int]map[string]interface{} {
 	tracker := GetHealthTracker()
@@ -493,8 +557,13 @@ func GetChannelHealthStats() map[int]map[string]interface{} {
 	defer tracker.mu.RUnlock()
 
 	stats := make(map[int]map[string]interface{})
+	now := time.Now()
 	for id, h := range tracker.channels {
 		h.mu.RLock()
+		var throttledUntil int64
+		if now.Before(h.ThrottledUntil) {
+			throttledUntil = h.ThrottledUntil.Unix()
+		}
 		stats[id] = map[string]interface{}{
 			"total_requests":   h.TotalRequests,
 			"success_count":    h.SuccessCount,
@@ -506,6 +575,7 @@ func GetChannelHealthStats() map[int]map[string]interface{} {
 			"last_error":       h.LastError,
 			"last_success":     h.LastSuccess,
 			"score":            h.Score(1.0),
+			"throttled_until":  throttledUntil,
 		}
 		h.mu.RUnlock()
 	}
@@ -0,0 +1,232 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// Channels are tagged, with their vendor or their region for example, to manage them as a
+// fleet: the channels of a tag are listed, enabled, disabled or archived together, and their
+// tags label the channel metrics. A ChannelTag names and describes the group of channels
+// carrying a tag, a tag needs none to be used.
+
+const (
+	ChannelTagEnable  = "enable"
+	ChannelTagDisable = "disable"
+	ChannelTagArchive = "archive"
+)
+
+const maxChannelTagsLength = 255
+
+type ChannelTag struct {
+	Name        string `json:"name" gorm:"primaryKey;type:varchar(64)"`
+	Description string `json:"description" gorm:"type:varchar(255)"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// ChannelTagSummary is a tag with the number of channels carrying it
+type ChannelTagSummary struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Channels    int    `json:"channels"`
+	Enabled     int    `json:"enabled"`
+}
+
+// NormalizeChannelTags trims, deduplicates and sorts comma separated tags
+func NormalizeChannelTags(tags string) string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return strings.Join(normalized, ",")
+}
+
+func (channel *Channel) GetTags() []string {
+	tags := stringValue(channel.Tags)
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+func (channel *Channel) HasTag(tag string) bool {
+	for _, t := range channel.GetTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeTags normalizes the tags of a channel before it is saved
+func (channel *Channel) normalizeTags() error {
+	if channel.Tags == nil {
+		return nil
+	}
+	tags := NormalizeChannelTags(*channel.Tags)
+	if len(tags) > maxChannelTagsLength {
+		return fmt.Errorf("the tags of the channel are longer than %d characters", maxChannelTagsLength)
+	}
+	channel.Tags = &tags
+	return nil
+}
+
+// GetChannelsByTag returns the channels carrying a tag which are not archived, without their keys
+func GetChannelsByTag(tenantId int, tag string) ([]*Channel, error) {
+	var candidates []*Channel
+	err := DB.Scopes(tenantScope(tenantId)).Omit("key").Where("status <> ? AND tags LIKE ?", ChannelStatusArchived, "%"+tag+"%").
+		Order("id desc").Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+	// LIKE also matches the tags containing the tag
+	channels := make([]*Channel, 0, len(candidates))
+	for _, channel := range candidates {
+		if channel.HasTag(tag) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels, nil
+}
+
+// GetChannelTags returns the tags of the channels which are not archived, and the described
+// tags no channel carries
+func GetChannelTags() ([]*ChannelTagSummary, error) {
+	var channels []*Channel
+	if err := DB.Select("id", "status", "tags").Where("status <> ? AND tags <> ''", ChannelStatusArchived).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	var described []*ChannelTag
+	if err := DB.Find(&described).Error; err != nil {
+		return nil, err
+	}
+	summaries := make(map[string]*ChannelTagSummary)
+	summary := func(name string) *ChannelTagSummary {
+		if summaries[name] == nil {
+			summaries[name] = &ChannelTagSummary{Name: name}
+		}
+		return summaries[name]
+	}
+	for _, tag := range described {
+		summary(tag.Name).Description = tag.Description
+	}
+	for _, channel := range channels {
+		for _, tag := range channel.GetTags() {
+			s := summary(tag)
+			s.Channels++
+			if channel.Status == ChannelStatusEnabled {
+				s.Enabled++
+			}
+		}
+	}
+	tags := make([]*ChannelTagSummary, 0, len(summaries))
+	for _, s := range summaries {
+		tags = append(tags, s)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].Name < tags[j].Name
+	})
+	return tags, nil
+}
+
+func validateChannelTagName(name string) error {
+	if name == "" {
+		return errors.New("the tag name is empty")
+	}
+	if strings.Contains(name, ",") || strings.TrimSpace(name) != name {
+		return errors.New("tag names can't contain commas nor start or end with spaces")
+	}
+	if len(name) > 64 {
+		return errors.New("tag names are at most 64 characters long")
+	}
+	return nil
+}
+
+// SaveChannelTag creates or updates the description of a tag
+func SaveChannelTag(tag *ChannelTag) error {
+	if err := validateChannelTagName(tag.Name); err != nil {
+		return err
+	}
+	tag.UpdatedTime = helper.GetTimestamp()
+	return DB.Save(tag).Error
+}
+
+// DeleteChannelTag removes a tag from the channels carrying it, archived ones included, and
+// deletes its description; it returns the number of channels it changed
+func DeleteChannelTag(name string) (int64, error) {
+	var candidates []*Channel
+	if err := DB.Select("id", "tags").Where("tags LIKE ?", "%"+name+"%").Find(&candidates).Error; err != nil {
+		return 0, err
+	}
+	var count int64
+	for _, channel := range candidates {
+		if !channel.HasTag(name) {
+			continue
+		}
+		var remaining []string
+		for _, tag := range channel.GetTags() {
+			if tag != name {
+				remaining = append(remaining, tag)
+			}
+		}
+		if err := DB.Model(&Channel{}).Where("id = ?", channel.Id).Update("tags", strings.Join(remaining, ",")).Error; err != nil {
+			return count, err
+		}
+		count++
+	}
+	if err := DB.Delete(&ChannelTag{}, "name = ?", name).Error; err != nil {
+		return count, err
+	}
+	if count > 0 {
+		PublishInvalidation(InvalidationChannels, "")
+	}
+	return count, nil
+}
+
+// UpdateChannelsByTag enables, disables or archives the channels of a tag, and returns the
+// number of channels it changed. Enabling also brings back the channels disabled
+// automatically.
+func UpdateChannelsByTag(tag string, action string) (int64, error) {
+	channels, err := GetChannelsByTag(AllTenants, tag)
+	if err != nil {
+		return 0, err
+	}
+	var ids []int
+	var status int
+	switch action {
+	case ChannelTagEnable:
+		status = ChannelStatusEnabled
+	case ChannelTagDisable:
+		status = ChannelStatusManuallyDisabled
+	case ChannelTagArchive:
+		status = ChannelStatusArchived
+	default:
+		return 0, fmt.Errorf("unknown action %s, use enable, disable or archive", action)
+	}
+	for _, channel := range channels {
+		if channel.Status != status {
+			ids = append(ids, channel.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if status == ChannelStatusArchived {
+		return archiveChannels("id IN ?", ids)
+	}
+	for _, id := range ids {
+		UpdateChannelStatusById(id, status)
+	}
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,175 @@
+package model
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// A chaos rule injects a fault into a share of the upstream requests of its channels and
+// models, a delay, a dropped stream or a synthetic error, to check that the retries, the
+// circuit breakers and the failover behave as designed before a real outage. The rules are
+// only applied with CHAOS_ENABLED.
+
+const (
+	ChaosRuleStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	ChaosRuleStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	ChaosFaultDelay      = "delay"       // the request is sent after DelayMs
+	ChaosFaultDropStream = "drop_stream" // the stream is cut after DropAfterBytes
+	ChaosFaultError      = "error"       // the request is not sent, the channel answers ErrorStatus
+)
+
+type ChaosRule struct {
+	Id             int     `json:"id"`
+	Name           string  `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	ChannelIds     string  `json:"channel_ids" gorm:"type:varchar(255);default:''"` // comma separated channel ids, empty for all of them
+	Models         string  `json:"models" gorm:"type:text"`                         // comma separated requested models or patterns, empty for all of them
+	Percent        float64 `json:"percent"`                                         // share of the matching upstream requests faulted, up to 100
+	Fault          string  `json:"fault" gorm:"type:varchar(16)"`
+	DelayMs        int     `json:"delay_ms" gorm:"default:0"`
+	DropAfterBytes int     `json:"drop_after_bytes" gorm:"default:0"`
+	ErrorStatus    int     `json:"error_status" gorm:"default:0"` // 500 when 0
+	RetryAfter     int     `json:"retry_after" gorm:"default:0"`  // Retry-After of a 429 in seconds, none when 0
+	ExpiredTime    int64   `json:"expired_time" gorm:"bigint"`    // the rule stops applying then, -1 never
+	Status         int     `json:"status" gorm:"default:1"`
+	CreatedTime    int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime    int64   `json:"updated_time" gorm:"bigint"`
+}
+
+func (r *ChaosRule) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("chaos rule name is empty")
+	}
+	if r.Percent <= 0 || r.Percent > 100 {
+		return errors.New("the percent of the faulted requests must be above 0 and at most 100")
+	}
+	for _, id := range strings.Split(r.ChannelIds, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(id); err != nil {
+			return errors.New("invalid channel id " + id)
+		}
+	}
+	switch r.Fault {
+	case ChaosFaultDelay:
+		if r.DelayMs <= 0 {
+			return errors.New("the delay of the rule must be above 0")
+		}
+	case ChaosFaultDropStream:
+		if r.DropAfterBytes < 0 {
+			return errors.New("the bytes before the stream is dropped can't be negative")
+		}
+	case ChaosFaultError:
+		if r.ErrorStatus == 0 {
+			r.ErrorStatus = http.StatusInternalServerError
+		}
+		if r.ErrorStatus < 400 || r.ErrorStatus > 599 {
+			return errors.New("the error status must be a 4xx or 5xx status")
+		}
+		if r.RetryAfter < 0 {
+			return errors.New("retry_after can't be negative")
+		}
+	default:
+		return errors.New("the fault must be delay, drop_stream or error")
+	}
+	if r.ExpiredTime == 0 {
+		r.ExpiredTime = -1
+	}
+	return nil
+}
+
+func (r *ChaosRule) matches(channelId int, modelName string, isStream bool) bool {
+	if r.Fault == ChaosFaultDropStream && !isStream {
+		return false
+	}
+	if r.ExpiredTime != -1 && r.ExpiredTime < helper.GetTimestamp() {
+		return false
+	}
+	if r.ChannelIds != "" && !isInCommaList(strconv.Itoa(channelId), r.ChannelIds) {
+		return false
+	}
+	return r.Models == "" || IsModelAllowed(modelName, r.Models)
+}
+
+func GetAllChaosRules() ([]*ChaosRule, error) {
+	var rules []*ChaosRule
+	err := DB.Order("id asc").Find(&rules).Error
+	return rules, err
+}
+
+func GetChaosRuleById(id int) (*ChaosRule, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	rule := ChaosRule{Id: id}
+	err := DB.First(&rule, "id = ?", id).Error
+	return &rule, err
+}
+
+func (r *ChaosRule) Insert() error {
+	r.CreatedTime = helper.GetTimestamp()
+	r.UpdatedTime = r.CreatedTime
+	return DB.Create(r).Error
+}
+
+func (r *ChaosRule) Update() error {
+	r.UpdatedTime = helper.GetTimestamp()
+	return DB.Model(r).Select("name", "channel_ids", "models", "percent", "fault", "delay_ms", "drop_after_bytes", "error_status", "retry_after", "expired_time", "status", "updated_time").Updates(r).Error
+}
+
+func (r *ChaosRule) Delete() error {
+	return DB.Delete(r).Error
+}
+
+var enabledChaosRules []*ChaosRule
+var chaosRuleSyncLock sync.RWMutex
+
+// InitChaosRuleCache loads the enabled chaos rules into memory, it is called on startup, after
+// every admin change and periodically
+func InitChaosRuleCache() {
+	var rules []*ChaosRule
+	err := DB.Where("status = ?", ChaosRuleStatusEnabled).Order("id asc").Find(&rules).Error
+	if err != nil {
+		logger.SysError("failed to load chaos rules: " + err.Error())
+		return
+	}
+	chaosRuleSyncLock.Lock()
+	enabledChaosRules = rules
+	chaosRuleSyncLock.Unlock()
+}
+
+func SyncChaosRuleCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitChaosRuleCache()
+	}
+}
+
+// CacheSampleChaosRule returns the first rule of a channel and a requested model whose fault
+// is drawn for an upstream request, nil when chaos is disabled or none is
+func CacheSampleChaosRule(channelId int, modelName string, isStream bool) *ChaosRule {
+	if !config.ChaosEnabled {
+		return nil
+	}
+	chaosRuleSyncLock.RLock()
+	defer chaosRuleSyncLock.RUnlock()
+	for _, rule := range enabledChaosRules {
+		if rule.matches(channelId, modelName, isStream) && rand.Float64()*100 < rule.Percent {
+			return rule
+		}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	debugCaptureDefaultTTL = 3600          // unit is second
+	debugCaptureMaxTTL     = 7 * 24 * 3600 // unit is second
+	debugCaptureListSize   = 100
+)
+
+// DebugCaptureRule enables the payload capture of a token or a channel until it expires
+type DebugCaptureRule struct {
+	Id          int   `json:"id"`
+	TokenId     int   `json:"token_id" gorm:"index"`
+	ChannelId   int   `json:"channel_id" gorm:"index"`
+	ExpiresAt   int64 `json:"expires_at" gorm:"bigint;index"`
+	CreatedTime int64 `json:"created_time" gorm:"bigint"`
+	TTL         int64 `json:"ttl" gorm:"-"` // unit is second, only used on creation
+}
+
+// DebugCapture is a redacted, size limited, request and response body pair
+type DebugCapture struct {
+	Id           int    `json:"id"`
+	RequestId    string `json:"request_id" gorm:"type:varchar(128);index"`
+	CreatedAt    int64  `json:"created_at" gorm:"bigint;index"`
+	UserId       int    `json:"user_id"`
+	TokenId      int    `json:"token_id" gorm:"index"`
+	ChannelId    int    `json:"channel_id" gorm:"index"`
+	ModelName    string `json:"model_name" gorm:"type:varchar(255);default:''"`
+	Method       string `json:"method" gorm:"type:varchar(16)"`
+	Path         string `json:"path" gorm:"type:varchar(255)"`
+	StatusCode   int    `json:"status_code"`
+	ElapsedTime  int64  `json:"elapsed_time"` // unit is ms
+	RequestBody  string `json:"request_body,omitempty" gorm:"type:text"`
+	ResponseBody string `json:"response_body,omitempty" gorm:"type:text"`
+	Truncated    bool   `json:"truncated"`
+}
+
+func (r *DebugCaptureRule) Validate() error {
+	if (r.TokenId == 0) == (r.ChannelId == 0) {
+		return errors.New("either token_id or channel_id is required")
+	}
+	if r.TTL == 0 {
+		r.TTL = debugCaptureDefaultTTL
+	}
+	if r.TTL < 0 || r.TTL > debugCaptureMaxTTL {
+		return errors.New("ttl must be between 1 second and 7 days")
+	}
+	return nil
+}
+
+func (r *DebugCaptureRule) Insert() error {
+	r.CreatedTime = helper.GetTimestamp()
+	r.ExpiresAt = r.CreatedTime + r.TTL
+	return DB.Create(r).Error
+}
+
+func (r *DebugCaptureRule) Delete() error {
+	return DB.Delete(r).Error
+}
+
+func GetAllDebugCaptureRules() ([]*DebugCaptureRule, error) {
+	var rules []*DebugCaptureRule
+	err := DB.Order("id desc").Find(&rules).Error
+	return rules, err
+}
+
+func GetDebugCaptureRuleById(id int) (*DebugCaptureRule, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	rule := DebugCaptureRule{Id: id}
+	err := DB.First(&rule, "id = ?", id).Error
+	return &rule, err
+}
+
+var activeDebugCaptureRules []*DebugCaptureRule
+var debugCaptureSyncLock sync.RWMutex
+
+// InitDebugCaptureCache loads the unexpired capture rules into memory,
+// it is called on startup, after every admin change and periodically
+func InitDebugCaptureCache() {
+	var rules []*DebugCaptureRule
+	err := DB.Where("expires_at > ?", helper.GetTimestamp()).Find(&rules).Error
+	if err != nil {
+		logger.SysError("failed to load debug capture rules: " + err.Error())
+		return
+	}
+	debugCaptureSyncLock.Lock()
+	activeDebugCaptureRules = rules
+	debugCaptureSyncLock.Unlock()
+}
+
+// SyncDebugCaptureCache reloads the rules, the master node also deletes the
+// expired rules and the captures older than DEBUG_CAPTURE_RETENTION
+func SyncDebugCaptureCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitDebugCaptureCache()
+		if config.IsMasterNode {
+			deleteExpiredDebugCaptures()
+		}
+	}
+}
+
+func deleteExpiredDebugCaptures() {
+	now := helper.GetTimestamp()
+	if err := DB.Where("expires_at <= ?", now).Delete(&DebugCaptureRule{}).Error; err != nil {
+		logger.SysError("failed to delete expired debug capture rules: " + err.Error())
+	}
+	cutoff := now - int64(config.DebugCaptureRetention)*3600
+	if err := LOG_DB.Where("created_at < ?", cutoff).Delete(&DebugCapture{}).Error; err != nil {
+		logger.SysError("failed to delete expired debug captures: " + err.Error())
+	}
+}
+
+// DebugCaptureActive reports whether any capture rule is loaded, so requests
+// are only buffered while debugging
+func DebugCaptureActive() bool {
+	debugCaptureSyncLock.RLock()
+	defer debugCaptureSyncLock.RUnlock()
+	return len(activeDebugCaptureRules) > 0
+}
+
+// CacheDebugCaptureEnabled reports whether the requests of the token or the channel are captured
+func CacheDebugCaptureEnabled(tokenId int, channelId int) bool {
+	now := helper.GetTimestamp()
+	debugCaptureSyncLock.RLock()
+	defer debugCaptureSyncLock.RUnlock()
+	for _, rule := range activeDebugCaptureRules {
+		if rule.ExpiresAt <= now {
+			continue
+		}
+		if (rule.TokenId != 0 && rule.TokenId == tokenId) || (rule.ChannelId != 0 && rule.ChannelId == channelId) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordDebugCapture redacts the bodies then stores the capture
+func RecordDebugCapture(capture *DebugCapture) {
+	capture.RequestBody = helper.RedactSecrets(capture.RequestBody)
+	capture.ResponseBody = helper.RedactSecrets(capture.ResponseBody)
+	capture.CreatedAt = helper.GetTimestamp()
+	if err := LOG_DB.Create(capture).Error; err != nil {
+		logger.SysError("failed to record debug capture: " + err.Error())
+	}
+}
+
+// GetDebugCaptures lists the latest captures without their bodies
+func GetDebugCaptures(tokenId int, channelId int) (captures []*DebugCapture, err error) {
+	tx := LOG_DB.Omit("request_body", "response_body")
+	if tokenId != 0 {
+		tx = tx.Where("token_id = ?", tokenId)
+	}
+	if channelId != 0 {
+		tx = tx.Where("channel_id = ?", channelId)
+	}
+	err = tx.Order("id desc").Limit(debugCaptureListSize).Find(&captures).Error
+	return captures, err
+}
+
+// GetDebugCapturesByRequestId returns the captures of a request id with their bodies
+func GetDebugCapturesByRequestId(requestId string) (captures []*DebugCapture, err error) {
+	err = LOG_DB.Where("request_id = ?", requestId).Order("id").Find(&captures).Error
+	return captures, err
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"context"
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// A deferred request is a request to the relay queued by a client which does not wait for
+// it. It is relayed when the gateway has capacity to spare, at a discounted price, and its
+// response is fetched by polling or posted to the callback url of the client.
+
+const (
+	DeferredStatusQueued    = "queued"
+	DeferredStatusRunning   = "running"
+	DeferredStatusCompleted = "completed"
+	DeferredStatusFailed    = "failed"
+	DeferredStatusCancelled = "cancelled"
+)
+
+// a running deferred request not finished within this time was lost with its node and is queued again
+const deferredStaleTime = 900 // unit is second
+
+// a discounted channel with a lower success rate is not healthy enough to relay the deferred requests
+const deferredHealthySuccessRate = 0.9
+
+type DeferredRequest struct {
+	Id             string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId         int    `json:"user_id" gorm:"index"`
+	TokenId        int    `json:"token_id"`
+	TenantId       int    `json:"tenant_id"`
+	ClientIp       string `json:"-" gorm:"type:varchar(64)"` // the request is relayed as if sent from this ip
+	Endpoint       string `json:"endpoint" gorm:"type:varchar(64)"`
+	Model          string `json:"model" gorm:"type:varchar(128)"`
+	Body           []byte `json:"-"`
+	CallbackUrl    string `json:"callback_url" gorm:"type:varchar(512)"`
+	Metadata       string `json:"-" gorm:"type:text"`
+	Status         string `json:"status" gorm:"type:varchar(16);index"`
+	Attempts       int    `json:"attempts"` // the relays rate limited, then queued again
+	ResponseStatus int    `json:"response_status"`
+	ResponseBody   []byte `json:"-"`
+	RequestId      string `json:"request_id" gorm:"type:varchar(64)"` // of the relayed request, in the logs
+	CreatedAt      int64  `json:"created_at" gorm:"bigint;index"`
+	ExpiresAt      int64  `json:"expires_at" gorm:"bigint"` // relayed from then on whatever the load
+	StartedAt      int64  `json:"started_at" gorm:"bigint"`
+	CompletedAt    int64  `json:"completed_at" gorm:"bigint"`
+	CancelledAt    int64  `json:"cancelled_at" gorm:"bigint"`
+	UpdatedAt      int64  `json:"updated_at" gorm:"bigint"`
+}
+
+type deferredContextKey struct{}
+
+// WithDeferred marks the context of the relayed request of a deferred request
+func WithDeferred(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deferredContextKey{}, true)
+}
+
+// IsDeferred tells whether a relayed request is the one of a deferred request, which is
+// billed at the deferred price
+func IsDeferred(ctx context.Context) bool {
+	deferred, _ := ctx.Value(deferredContextKey{}).(bool)
+	return deferred
+}
+
+func (r *DeferredRequest) Insert() error {
+	now := helper.GetTimestamp()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	return DB.Create(r).Error
+}
+
+func GetDeferredRequest(id string, userId int) (*DeferredRequest, error) {
+	if id == "" {
+		return nil, errors.New("id is empty")
+	}
+	var request DeferredRequest
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(&request).Error
+	return &request, err
+}
+
+// CancelDeferredRequest cancels a deferred request which has not been relayed yet
+func CancelDeferredRequest(id string, userId int) (bool, error) {
+	now := helper.GetTimestamp()
+	result := DB.Model(&DeferredRequest{}).
+		Where("id = ? AND user_id = ? AND status = ?", id, userId, DeferredStatusQueued).
+		Updates(map[string]any{"status": DeferredStatusCancelled, "cancelled_at": now, "updated_at": now})
+	return result.RowsAffected > 0, result.Error
+}
+
+// GetPendingDeferredRequests returns the oldest deferred requests waiting to be relayed,
+// including the running ones whose node was lost
+func GetPendingDeferredRequests(limit int) ([]*DeferredRequest, error) {
+	var requests []*DeferredRequest
+	err := DB.Omit("body", "response_body").
+		Where("status = ? OR (status = ? AND updated_at < ?)", DeferredStatusQueued, DeferredStatusRunning, helper.GetTimestamp()-deferredStaleTime).
+		Order("created_at").Limit(limit).Find(&requests).Error
+	return requests, err
+}
+
+// ClaimDeferredRequest takes a pending deferred request for this node to relay, it returns nil
+// if another node took it first
+func ClaimDeferredRequest(pending *DeferredRequest) (*DeferredRequest, error) {
+	now := helper.GetTimestamp()
+	result := DB.Model(&DeferredRequest{}).
+		Where("id = ? AND status = ? AND updated_at = ?", pending.Id, pending.Status, pending.UpdatedAt).
+		Updates(map[string]any{"status": DeferredStatusRunning, "started_at": now, "updated_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return GetDeferredRequest(pending.Id, pending.UserId)
+}
+
+// RequeueDeferredRequest queues a deferred request again after its relay was rate limited
+func RequeueDeferredRequest(id string) error {
+	return DB.Model(&DeferredRequest{}).Where("id = ?", id).Updates(map[string]any{
+		"status":     DeferredStatusQueued,
+		"attempts":   gorm.Expr("attempts + ?", 1),
+		"updated_at": helper.GetTimestamp(),
+	}).Error
+}
+
+// DiscountedChannelHealthy tells whether a channel of a model which the provider bills below
+// the list price is healthy: not throttled and succeeding
+func DiscountedChannelHealthy(tenantId int, group string, model string) bool {
+	tracker := GetHealthTracker()
+	for _, channel := range CacheGetChannels(tenantId, group, model) {
+		cfg, err := channel.LoadConfig()
+		if err != nil || cfg.CostRatio <= 0 || cfg.CostRatio >= 1 || tracker.IsThrottled(channel.Id) {
+			continue
+		}
+		if health := tracker.GetHealth(channel.Id); health == nil || health.SuccessRate() >= deferredHealthySuccessRate {
+			return true
+		}
+	}
+	return false
+}
+
+// FinishDeferredRequest saves the response of a relayed deferred request
+func FinishDeferredRequest(id string, status string, responseStatus int, requestId string, responseBody []byte) error {
+	now := helper.GetTimestamp()
+	return DB.Model(&DeferredRequest{}).Where("id = ?", id).Updates(map[string]any{
+		"status":          status,
+		"response_status": responseStatus,
+		"request_id":      requestId,
+		"response_body":   responseBody,
+		"completed_at":    now,
+		"updated_at":      now,
+	}).Error
+}
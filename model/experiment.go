@@ -0,0 +1,410 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	ExperimentStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	ExperimentStatusDisabled = 2 // also don't use 0
+)
+
+// ExperimentArm is a variant of an experiment, the empty fields keep what the request
+// would use without the experiment
+type ExperimentArm struct {
+	Name              string `json:"name"`
+	Weight            int    `json:"weight"`                       // share of the tokens, 1 by default
+	Model             string `json:"model,omitempty"`              // model relayed instead of the requested one
+	PromptTemplateId  int    `json:"prompt_template_id,omitempty"` // prompt template replacing the one of the token
+	SelectionStrategy string `json:"selection_strategy,omitempty"` // balanced, performance, cost or resilient
+}
+
+// Experiment splits the requests of its groups for its models between its arms. A token is
+// always given the same arm, picked by the hash of its id.
+type Experiment struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Groups      string `json:"groups" gorm:"type:varchar(255);default:''"` // comma separated user groups, empty for all of them
+	Models      string `json:"models" gorm:"type:text"`                    // comma separated requested models or patterns, empty for all of them
+	Arms        string `json:"arms" gorm:"type:text"`                      // JSON list of ExperimentArm
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+
+	arms        []ExperimentArm
+	totalWeight int
+}
+
+// compile parses the arms of the experiment
+func (e *Experiment) compile() error {
+	e.arms = nil
+	e.totalWeight = 0
+	if err := json.Unmarshal([]byte(e.Arms), &e.arms); err != nil {
+		return fmt.Errorf("invalid arms: %w", err)
+	}
+	names := make(map[string]bool, len(e.arms))
+	for i := range e.arms {
+		arm := &e.arms[i]
+		if arm.Name == "" {
+			return fmt.Errorf("arm %d has no name", i)
+		}
+		if names[arm.Name] {
+			return fmt.Errorf("arm %s is defined twice", arm.Name)
+		}
+		names[arm.Name] = true
+		if arm.Weight < 0 {
+			return fmt.Errorf("arm %s has a negative weight", arm.Name)
+		}
+		if arm.Weight == 0 {
+			arm.Weight = 1
+		}
+		if _, ok := StrategyMap[arm.SelectionStrategy]; arm.SelectionStrategy != "" && !ok {
+			return fmt.Errorf("arm %s has an unknown selection strategy: %s", arm.Name, arm.SelectionStrategy)
+		}
+		e.totalWeight += arm.Weight
+	}
+	return nil
+}
+
+func (e *Experiment) Validate() error {
+	e.Name = strings.TrimSpace(e.Name)
+	if e.Name == "" {
+		return errors.New("experiment name is empty")
+	}
+	if err := e.compile(); err != nil {
+		return err
+	}
+	if len(e.arms) < 2 {
+		return errors.New("an experiment needs at least two arms")
+	}
+	for _, arm := range e.arms {
+		if arm.PromptTemplateId == 0 {
+			continue
+		}
+		if _, err := GetPromptTemplateById(arm.PromptTemplateId); err != nil {
+			return fmt.Errorf("prompt template #%d of arm %s not found", arm.PromptTemplateId, arm.Name)
+		}
+	}
+	return nil
+}
+
+func (e *Experiment) matches(group string, modelName string) bool {
+	if e.Groups != "" && !isInCommaList(group, e.Groups) {
+		return false
+	}
+	return e.Models == "" || IsModelAllowed(modelName, e.Models)
+}
+
+// ArmOf returns the arm of a token
+func (e *Experiment) ArmOf(tokenId int) *ExperimentArm {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(fmt.Sprintf("%d:%d", e.Id, tokenId)))
+	point := int(hash.Sum32() % uint32(e.totalWeight))
+	for i := range e.arms {
+		if point < e.arms[i].Weight {
+			return &e.arms[i]
+		}
+		point -= e.arms[i].Weight
+	}
+	return &e.arms[len(e.arms)-1]
+}
+
+func isInCommaList(value string, list string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == value {
+			return true
+		}
+	}
+	return false
+}
+
+func GetAllExperiments() ([]*Experiment, error) {
+	var experiments []*Experiment
+	err := DB.Order("id asc").Find(&experiments).Error
+	return experiments, err
+}
+
+func GetExperimentById(id int) (*Experiment, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	experiment := Experiment{Id: id}
+	err := DB.First(&experiment, "id = ?", id).Error
+	return &experiment, err
+}
+
+func (e *Experiment) Insert() error {
+	e.CreatedTime = helper.GetTimestamp()
+	e.UpdatedTime = e.CreatedTime
+	return DB.Create(e).Error
+}
+
+func (e *Experiment) Update() error {
+	e.UpdatedTime = helper.GetTimestamp()
+	return DB.Model(e).Select("name", "description", "groups", "models", "arms", "status", "updated_time").Updates(e).Error
+}
+
+// Delete removes the experiment with its results
+func (e *Experiment) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("experiment_id = ?", e.Id).Delete(&ExperimentArmStat{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(e).Error
+	})
+}
+
+var enabledExperiments []*Experiment
+var experimentSyncLock sync.RWMutex
+
+// InitExperimentCache loads the enabled experiments into memory, it is called on startup,
+// after every admin change and periodically
+func InitExperimentCache() {
+	var experiments []*Experiment
+	err := DB.Where("status = ?", ExperimentStatusEnabled).Order("id asc").Find(&experiments).Error
+	if err != nil {
+		logger.SysError("failed to load experiments: " + err.Error())
+		return
+	}
+	compiled := make([]*Experiment, 0, len(experiments))
+	for _, experiment := range experiments {
+		if err := experiment.compile(); err != nil || len(experiment.arms) == 0 {
+			logger.SysError(fmt.Sprintf("skipping invalid experiment #%d", experiment.Id))
+			continue
+		}
+		compiled = append(compiled, experiment)
+	}
+	experimentSyncLock.Lock()
+	enabledExperiments = compiled
+	experimentSyncLock.Unlock()
+}
+
+// SyncExperimentCache saves the results recorded since the last sync and reloads the experiments
+func SyncExperimentCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushExperimentStats()
+		InitExperimentCache()
+	}
+}
+
+// CacheGetExperiment returns the first experiment running for a group and a requested model,
+// nil when there is none
+func CacheGetExperiment(group string, modelName string) *Experiment {
+	experimentSyncLock.RLock()
+	defer experimentSyncLock.RUnlock()
+	for _, experiment := range enabledExperiments {
+		if experiment.matches(group, modelName) {
+			return experiment
+		}
+	}
+	return nil
+}
+
+// ExperimentArmStat holds the outcomes of the requests of an arm, with the sums of squares
+// giving the variances the arms are compared with
+type ExperimentArmStat struct {
+	Id               int     `json:"-"`
+	ExperimentId     int     `json:"experiment_id" gorm:"uniqueIndex:idx_experiment_arm,priority:1"`
+	Arm              string  `json:"arm" gorm:"type:varchar(64);uniqueIndex:idx_experiment_arm,priority:2"`
+	Requests         int64   `json:"requests" gorm:"default:0"`
+	Errors           int64   `json:"errors" gorm:"default:0"`
+	Regenerations    int64   `json:"regenerations" gorm:"default:0"` // requests repeating a recent prompt of the same token
+	LatencySum       float64 `json:"latency_sum" gorm:"default:0"`   // milliseconds
+	LatencySquareSum float64 `json:"latency_square_sum" gorm:"default:0"`
+	BilledRequests   int64   `json:"billed_requests" gorm:"default:0"`
+	QuotaSum         float64 `json:"quota_sum" gorm:"default:0"`
+	QuotaSquareSum   float64 `json:"quota_square_sum" gorm:"default:0"`
+}
+
+type experimentArmKey struct {
+	experimentId int
+	arm          string
+}
+
+var experimentStatsLock sync.Mutex
+var experimentStatsPending = make(map[experimentArmKey]*ExperimentArmStat)
+
+func addExperimentStat(experimentId int, arm string, update func(stat *ExperimentArmStat)) {
+	if experimentId == 0 {
+		return
+	}
+	key := experimentArmKey{experimentId: experimentId, arm: arm}
+	experimentStatsLock.Lock()
+	defer experimentStatsLock.Unlock()
+	stat, ok := experimentStatsPending[key]
+	if !ok {
+		stat = &ExperimentArmStat{ExperimentId: experimentId, Arm: arm}
+		experimentStatsPending[key] = stat
+	}
+	update(stat)
+}
+
+func (s *ExperimentArmStat) add(other *ExperimentArmStat) {
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	s.Regenerations += other.Regenerations
+	s.LatencySum += other.LatencySum
+	s.LatencySquareSum += other.LatencySquareSum
+	s.BilledRequests += other.BilledRequests
+	s.QuotaSum += other.QuotaSum
+	s.QuotaSquareSum += other.QuotaSquareSum
+}
+
+// RecordExperimentRequest records the outcome of a relay request, after its retries
+func RecordExperimentRequest(experimentId int, arm string, latency time.Duration, failed bool) {
+	ms := float64(latency.Milliseconds())
+	addExperimentStat(experimentId, arm, func(stat *ExperimentArmStat) {
+		stat.Requests++
+		if failed {
+			stat.Errors++
+		}
+		stat.LatencySum += ms
+		stat.LatencySquareSum += ms * ms
+	})
+}
+
+// RecordExperimentQuota records the quota a request was billed
+func RecordExperimentQuota(experimentId int, arm string, quota int64) {
+	addExperimentStat(experimentId, arm, func(stat *ExperimentArmStat) {
+		stat.BilledRequests++
+		stat.QuotaSum += float64(quota)
+		stat.QuotaSquareSum += float64(quota) * float64(quota)
+	})
+}
+
+// RecordExperimentRegeneration counts a request regenerating the answer to a prompt
+func RecordExperimentRegeneration(experimentId int, arm string) {
+	addExperimentStat(experimentId, arm, func(stat *ExperimentArmStat) {
+		stat.Regenerations++
+	})
+}
+
+// FlushExperimentStats adds the outcomes recorded in memory to the stats table
+func FlushExperimentStats() {
+	experimentStatsLock.Lock()
+	pending := experimentStatsPending
+	experimentStatsPending = make(map[experimentArmKey]*ExperimentArmStat)
+	experimentStatsLock.Unlock()
+
+	for key, stat := range pending {
+		err := DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "experiment_id"}, {Name: "arm"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"requests":           gorm.Expr("experiment_arm_stats.requests + ?", stat.Requests),
+				"errors":             gorm.Expr("experiment_arm_stats.errors + ?", stat.Errors),
+				"regenerations":      gorm.Expr("experiment_arm_stats.regenerations + ?", stat.Regenerations),
+				"latency_sum":        gorm.Expr("experiment_arm_stats.latency_sum + ?", stat.LatencySum),
+				"latency_square_sum": gorm.Expr("experiment_arm_stats.latency_square_sum + ?", stat.LatencySquareSum),
+				"billed_requests":    gorm.Expr("experiment_arm_stats.billed_requests + ?", stat.BilledRequests),
+				"quota_sum":          gorm.Expr("experiment_arm_stats.quota_sum + ?", stat.QuotaSum),
+				"quota_square_sum":   gorm.Expr("experiment_arm_stats.quota_square_sum + ?", stat.QuotaSquareSum),
+			}),
+		}).Create(stat).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to save the results of experiment #%d: %s", key.experimentId, err.Error()))
+			// keep the outcomes for the next flush
+			addExperimentStat(key.experimentId, key.arm, func(s *ExperimentArmStat) {
+				s.add(stat)
+			})
+		}
+	}
+}
+
+// ExperimentArmResult summarizes the outcomes of an arm, each mean comes with its standard
+// error so the arms can be compared, e.g. by a z-test on their difference
+type ExperimentArmResult struct {
+	Arm                    string  `json:"arm"`
+	Requests               int64   `json:"requests"`
+	ErrorRate              float64 `json:"error_rate"`
+	ErrorRateStdErr        float64 `json:"error_rate_std_err"`
+	RegenerationRate       float64 `json:"regeneration_rate"`
+	RegenerationRateStdErr float64 `json:"regeneration_rate_std_err"`
+	LatencyMean            float64 `json:"latency_mean"` // milliseconds
+	LatencyStdDev          float64 `json:"latency_std_dev"`
+	LatencyStdErr          float64 `json:"latency_std_err"`
+	BilledRequests         int64   `json:"billed_requests"`
+	QuotaMean              float64 `json:"quota_mean"`
+	QuotaStdDev            float64 `json:"quota_std_dev"`
+	QuotaStdErr            float64 `json:"quota_std_err"`
+}
+
+// proportion returns a rate and its standard error
+func proportion(count int64, n int64) (float64, float64) {
+	if n == 0 {
+		return 0, 0
+	}
+	// the regenerations are counted when the requests start, the requests when they end
+	p := math.Min(1, float64(count)/float64(n))
+	return p, math.Sqrt(p * (1 - p) / float64(n))
+}
+
+// meanStdDev returns the mean, the sample standard deviation and the standard error of the mean
+func meanStdDev(sum float64, squareSum float64, n int64) (float64, float64, float64) {
+	if n == 0 {
+		return 0, 0, 0
+	}
+	mean := sum / float64(n)
+	if n == 1 {
+		return mean, 0, 0
+	}
+	variance := math.Max(0, (squareSum-sum*mean)/float64(n-1))
+	stdDev := math.Sqrt(variance)
+	return mean, stdDev, stdDev / math.Sqrt(float64(n))
+}
+
+// GetExperimentResults returns the results of the arms of an experiment, the outcomes not
+// saved yet by this instance included
+func GetExperimentResults(experimentId int) ([]*ExperimentArmResult, error) {
+	var stats []*ExperimentArmStat
+	if err := DB.Where("experiment_id = ?", experimentId).Order("arm asc").Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	byArm := make(map[string]*ExperimentArmStat, len(stats))
+	for _, stat := range stats {
+		byArm[stat.Arm] = stat
+	}
+	experimentStatsLock.Lock()
+	for key, pending := range experimentStatsPending {
+		if key.experimentId != experimentId {
+			continue
+		}
+		stat, ok := byArm[key.arm]
+		if !ok {
+			stat = &ExperimentArmStat{ExperimentId: experimentId, Arm: key.arm}
+			byArm[key.arm] = stat
+			stats = append(stats, stat)
+		}
+		stat.add(pending)
+	}
+	experimentStatsLock.Unlock()
+
+	results := make([]*ExperimentArmResult, 0, len(stats))
+	for _, stat := range stats {
+		result := &ExperimentArmResult{
+			Arm:            stat.Arm,
+			Requests:       stat.Requests,
+			BilledRequests: stat.BilledRequests,
+		}
+		result.ErrorRate, result.ErrorRateStdErr = proportion(stat.Errors, stat.Requests)
+		result.RegenerationRate, result.RegenerationRateStdErr = proportion(stat.Regenerations, stat.Requests)
+		result.LatencyMean, result.LatencyStdDev, result.LatencyStdErr = meanStdDev(stat.LatencySum, stat.LatencySquareSum, stat.Requests)
+		result.QuotaMean, result.QuotaStdDev, result.QuotaStdErr = meanStdDev(stat.QuotaSum, stat.QuotaSquareSum, stat.BilledRequests)
+		results = append(results, result)
+	}
+	return results, nil
+}
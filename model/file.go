@@ -0,0 +1,155 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/storage"
+)
+
+const (
+	FilePurposeBatch       = "batch"
+	FilePurposeBatchOutput = "batch_output"
+	FilePurposeVision      = "vision"
+	FilePurposeUserData    = "user_data"
+	FilePurposeAssistants  = "assistants"
+)
+
+// the purposes a file can be uploaded with, batch_output files are generated only
+var fileUploadPurposes = map[string]bool{
+	FilePurposeBatch:      true,
+	FilePurposeVision:     true,
+	FilePurposeUserData:   true,
+	FilePurposeAssistants: true,
+}
+
+const fileCleanBatchSize = 1000
+
+// File is a file of the files API, its content is kept by the file storage
+type File struct {
+	Id          string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Purpose     string `json:"purpose" gorm:"type:varchar(32)"`
+	Filename    string `json:"filename" gorm:"type:varchar(255)"`
+	ContentType string `json:"content_type" gorm:"type:varchar(128)"`
+	Bytes       int64  `json:"bytes"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint"`
+	ExpiresAt   int64  `json:"expires_at" gorm:"bigint;index"` // 0 never expires
+}
+
+func IsFileUploadPurpose(purpose string) bool {
+	return fileUploadPurposes[purpose]
+}
+
+func (f *File) storageKey() string {
+	return fmt.Sprintf("%d/%s", f.UserId, f.Id)
+}
+
+// Save stores the content of a new file then records it. The file expires after
+// FILE_RETENTION unless ExpiresAt is set.
+func (f *File) Save(ctx context.Context, content []byte) error {
+	f.CreatedAt = helper.GetTimestamp()
+	f.Bytes = int64(len(content))
+	if f.ExpiresAt == 0 && config.FileRetention > 0 {
+		f.ExpiresAt = f.CreatedAt + int64(config.FileRetention)*3600
+	}
+	if err := storage.GetStorage().Put(ctx, f.storageKey(), content); err != nil {
+		return err
+	}
+	if err := DB.Create(f).Error; err != nil {
+		_ = storage.GetStorage().Delete(ctx, f.storageKey())
+		return err
+	}
+	return nil
+}
+
+func (f *File) Content(ctx context.Context) ([]byte, error) {
+	return storage.GetStorage().Get(ctx, f.storageKey())
+}
+
+// GetFile returns a file of a user unless it expired
+func GetFile(id string, userId int) (*File, error) {
+	if id == "" {
+		return nil, errors.New("id is empty")
+	}
+	var file File
+	err := DB.Where("id = ? AND user_id = ? AND (expires_at = 0 OR expires_at > ?)", id, userId, helper.GetTimestamp()).
+		First(&file).Error
+	return &file, err
+}
+
+// GetFiles lists the files of a user, newest first
+func GetFiles(userId int, purpose string) ([]*File, error) {
+	var files []*File
+	tx := DB.Where("user_id = ? AND (expires_at = 0 OR expires_at > ?)", userId, helper.GetTimestamp())
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+	err := tx.Order("created_at desc").Find(&files).Error
+	return files, err
+}
+
+// GetUserFileBytes returns the storage used by the files of a user
+func GetUserFileBytes(userId int) (int64, error) {
+	var bytes int64
+	err := DB.Model(&File{}).Where("user_id = ? AND (expires_at = 0 OR expires_at > ?)", userId, helper.GetTimestamp()).Select("COALESCE(SUM(bytes), 0)").Scan(&bytes).Error
+	return bytes, err
+}
+
+func DeleteFile(ctx context.Context, id string, userId int) (bool, error) {
+	file, err := GetFile(id, userId)
+	if err != nil {
+		return false, nil
+	}
+	result := DB.Where("id = ? AND user_id = ?", id, userId).Delete(&File{})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	if err = storage.GetStorage().Delete(ctx, file.storageKey()); err != nil {
+		logger.Errorf(ctx, "failed to delete content of file %s: %s", id, err.Error())
+	}
+	return true, nil
+}
+
+// InitFileStore periodically deletes the expired files on the master node
+func InitFileStore() {
+	if !config.IsMasterNode {
+		return
+	}
+	go func() {
+		for {
+			cleanExpiredFiles()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func cleanExpiredFiles() {
+	ctx := context.Background()
+	for {
+		var files []*File
+		err := DB.Where("expires_at > 0 AND expires_at <= ?", helper.GetTimestamp()).Limit(fileCleanBatchSize).Find(&files).Error
+		if err != nil {
+			logger.SysError("failed to get expired files: " + err.Error())
+			return
+		}
+		for _, file := range files {
+			if err = storage.GetStorage().Delete(ctx, file.storageKey()); err != nil {
+				logger.SysError(fmt.Sprintf("failed to delete content of file %s: %s", file.Id, err.Error()))
+				return
+			}
+			if err = DB.Delete(file).Error; err != nil {
+				logger.SysError("failed to delete expired file: " + err.Error())
+				return
+			}
+		}
+		if len(files) < fileCleanBatchSize {
+			return
+		}
+	}
+}
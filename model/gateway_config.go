@@ -0,0 +1,614 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// The gateway config is a declarative description of the channels, the groups, the settings,
+// the selection strategy and cache policies included, and the rate limits. Applying a config
+// makes the gateway match it: the sections present are created, updated and, for the
+// channels, groups and rate limits, deleted to match, the sections left out are not managed.
+// The channels are identified by their name and exported without their keys and secrets,
+// which are kept when a config leaves them empty.
+
+const (
+	ConfigActionCreate = "create"
+	ConfigActionUpdate = "update"
+	ConfigActionDelete = "delete"
+)
+
+type GatewayConfig struct {
+	Channels   []ConfigChannel    `json:"channels"`
+	Groups     map[string]float64 `json:"groups"`   // ratio of each group
+	Settings   map[string]any     `json:"settings"` // the settings listed are managed
+	RateLimits []ConfigRateLimit  `json:"rate_limits"`
+}
+
+type ConfigChannel struct {
+	Name         string         `json:"name"`
+	Type         int            `json:"type"`
+	Key          string         `json:"key,omitempty"` // never exported, kept when empty
+	Status       int            `json:"status"`
+	BaseURL      string         `json:"base_url,omitempty"`
+	Models       string         `json:"models"`
+	Group        string         `json:"group"`
+	ModelMapping string         `json:"model_mapping,omitempty"`
+	Priority     int64          `json:"priority"`
+	Weight       uint           `json:"weight"`
+	SystemPrompt string         `json:"system_prompt,omitempty"`
+	Tags         string         `json:"tags,omitempty"`
+	Config       *ChannelConfig `json:"config,omitempty"` // exported without its secrets, kept when empty
+}
+
+type ConfigRateLimit struct {
+	PathPattern string `json:"path_pattern"`
+	Method      string `json:"method"`
+	Dimension   string `json:"dimension"`
+	Limit       int    `json:"limit"`
+	Window      int64  `json:"window"`
+	Status      int    `json:"status"`
+}
+
+func (r ConfigRateLimit) name() string {
+	return fmt.Sprintf("%s %s by %s", r.Method, r.PathPattern, r.Dimension)
+}
+
+// ConfigChange is a change applying a config makes
+type ConfigChange struct {
+	Kind   string   `json:"kind"` // channel, group, setting or rate_limit
+	Name   string   `json:"name"`
+	Action string   `json:"action"`
+	Fields []string `json:"fields,omitempty"` // the fields an update changes
+}
+
+// ExportGatewayConfig returns the current config
+func ExportGatewayConfig() (*GatewayConfig, error) {
+	var channels []*Channel
+	// the channels of the tenants are managed by their admins, not by the config
+	if err := DB.Order("name asc").Where("status <> ? AND tenant_id = ?", ChannelStatusArchived, DefaultTenantId).Find(&channels).Error; err != nil {
+		return nil, err
+	}
+	cfg := &GatewayConfig{
+		Channels:   make([]ConfigChannel, 0, len(channels)),
+		Groups:     make(map[string]float64),
+		Settings:   make(map[string]any),
+		RateLimits: make([]ConfigRateLimit, 0),
+	}
+	for _, channel := range channels {
+		configChannel, err := toConfigChannel(channel)
+		if err != nil {
+			return nil, err
+		}
+		configChannel.Key = ""
+		if configChannel.Config != nil {
+			for _, secret := range configChannel.Config.secrets() {
+				*secret = ""
+			}
+			if isEmptyChannelConfig(configChannel.Config) {
+				configChannel.Config = nil
+			}
+		}
+		cfg.Channels = append(cfg.Channels, *configChannel)
+	}
+	if err := json.Unmarshal([]byte(billingratio.GroupRatio2JSONString()), &cfg.Groups); err != nil {
+		return nil, err
+	}
+	for _, setting := range GetSettings() {
+		cfg.Settings[setting.Key] = typedSettingValue(setting.Type, setting.Value)
+	}
+	rateLimits, err := GetAllRateLimits()
+	if err != nil {
+		return nil, err
+	}
+	for _, rateLimit := range rateLimits {
+		cfg.RateLimits = append(cfg.RateLimits, toConfigRateLimit(rateLimit))
+	}
+	sort.Slice(cfg.RateLimits, func(i, j int) bool {
+		return cfg.RateLimits[i].name() < cfg.RateLimits[j].name()
+	})
+	return cfg, nil
+}
+
+// MarshalGatewayConfig encodes a config as YAML or, with format json, as JSON
+func MarshalGatewayConfig(cfg *GatewayConfig, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil || format == "json" {
+		return data, err
+	}
+	// the YAML has the keys of the JSON
+	var document any
+	if err = json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(document); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// UnmarshalGatewayConfig decodes a config in YAML or JSON
+func UnmarshalGatewayConfig(data []byte) (*GatewayConfig, error) {
+	var document any
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	var cfg GatewayConfig
+	if err = decoder.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	return &cfg, nil
+}
+
+// ApplyGatewayConfig makes the gateway match a config and returns the changes it made, or
+// only plans them with dryRun. The whole config is validated before any change is made.
+func ApplyGatewayConfig(cfg *GatewayConfig, dryRun bool) ([]ConfigChange, error) {
+	plan := &configPlan{changes: make([]ConfigChange, 0)}
+	if cfg.Channels != nil {
+		if err := plan.planChannels(cfg.Channels); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Groups != nil {
+		if err := plan.planGroups(cfg.Groups); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Settings != nil {
+		if err := plan.planSettings(cfg.Settings); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RateLimits != nil {
+		if err := plan.planRateLimits(cfg.RateLimits); err != nil {
+			return nil, err
+		}
+	}
+	if dryRun {
+		return plan.changes, nil
+	}
+	for _, apply := range plan.steps {
+		if err := apply(); err != nil {
+			return nil, err
+		}
+	}
+	return plan.changes, nil
+}
+
+type configPlan struct {
+	changes []ConfigChange
+	steps   []func() error
+}
+
+func (p *configPlan) add(change ConfigChange, step func() error) {
+	p.changes = append(p.changes, change)
+	if step != nil {
+		p.steps = append(p.steps, step)
+	}
+}
+
+func toConfigChannel(channel *Channel) (*ConfigChannel, error) {
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	var weight uint
+	if channel.Weight != nil {
+		weight = *channel.Weight
+	}
+	configChannel := &ConfigChannel{
+		Name:         channel.Name,
+		Type:         channel.Type,
+		Key:          channel.Key,
+		Status:       channel.Status,
+		BaseURL:      channel.GetBaseURL(),
+		Models:       channel.Models,
+		Group:        channel.Group,
+		ModelMapping: stringValue(channel.ModelMapping),
+		Priority:     channel.GetPriority(),
+		Weight:       weight,
+		SystemPrompt: stringValue(channel.SystemPrompt),
+		Tags:         stringValue(channel.Tags),
+		Config:       &cfg,
+	}
+	return configChannel, nil
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func isEmptyChannelConfig(cfg *ChannelConfig) bool {
+	data, _ := json.Marshal(cfg)
+	return string(data) == "{}"
+}
+
+// desiredChannel completes a channel of a config with the key and the secrets of the
+// current one, nil for a new channel
+func desiredChannel(declared ConfigChannel, current *ConfigChannel) (*ConfigChannel, error) {
+	desired := declared
+	if desired.Status == 0 {
+		desired.Status = ChannelStatusEnabled
+	}
+	if desired.Group == "" {
+		desired.Group = "default"
+	}
+	cfg := ChannelConfig{}
+	if declared.Config != nil {
+		cfg = *declared.Config
+	}
+	desired.Config = &cfg
+	if current == nil {
+		if desired.Key == "" {
+			return nil, fmt.Errorf("channel %s has no key", desired.Name)
+		}
+		return &desired, nil
+	}
+	if desired.Key == "" {
+		desired.Key = current.Key
+	}
+	// the automatic disabling and enabling of the channels is not undone
+	if desired.Status == ChannelStatusEnabled && current.Status == ChannelStatusAutoDisabled {
+		desired.Status = current.Status
+	}
+	currentSecrets := current.Config.secrets()
+	for i, secret := range cfg.secrets() {
+		if *secret == "" && i < len(currentSecrets) {
+			*secret = *currentSecrets[i]
+		}
+	}
+	return &desired, nil
+}
+
+// changedChannelFields returns the columns of the fields differing between two channels
+func changedChannelFields(desired *ConfigChannel, current *ConfigChannel) []string {
+	var fields []string
+	compare := func(column string, changed bool) {
+		if changed {
+			fields = append(fields, column)
+		}
+	}
+	compare("type", desired.Type != current.Type)
+	compare("key", desired.Key != current.Key)
+	compare("status", desired.Status != current.Status)
+	compare("base_url", desired.BaseURL != current.BaseURL)
+	compare("models", desired.Models != current.Models)
+	compare("group", desired.Group != current.Group)
+	compare("model_mapping", desired.ModelMapping != current.ModelMapping)
+	compare("priority", desired.Priority != current.Priority)
+	compare("weight", desired.Weight != current.Weight)
+	compare("system_prompt", desired.SystemPrompt != current.SystemPrompt)
+	compare("tags", NormalizeChannelTags(desired.Tags) != current.Tags)
+	desiredConfig, _ := json.Marshal(desired.Config)
+	currentConfig, _ := json.Marshal(current.Config)
+	compare("config", !bytes.Equal(desiredConfig, currentConfig))
+	return fields
+}
+
+// toChannel sets the fields of a channel of a config on a stored channel
+func (c *ConfigChannel) toChannel(channel *Channel) error {
+	channel.Name = c.Name
+	channel.Type = c.Type
+	channel.Key = c.Key
+	channel.Status = c.Status
+	channel.BaseURL = &c.BaseURL
+	channel.Models = c.Models
+	channel.Group = c.Group
+	channel.ModelMapping = &c.ModelMapping
+	channel.Priority = &c.Priority
+	channel.Weight = &c.Weight
+	channel.SystemPrompt = &c.SystemPrompt
+	tags := NormalizeChannelTags(c.Tags)
+	channel.Tags = &tags
+	channel.Config = ""
+	if c.Config != nil && !isEmptyChannelConfig(c.Config) {
+		data, err := json.Marshal(c.Config)
+		if err != nil {
+			return err
+		}
+		channel.Config = string(data)
+	}
+	if err := channel.ValidateConfig(); err != nil {
+		return fmt.Errorf("channel %s: %w", c.Name, err)
+	}
+	return channel.SealConfig()
+}
+
+func (p *configPlan) planChannels(declared []ConfigChannel) error {
+	var channels []*Channel
+	if err := DB.Where("status <> ? AND tenant_id = ?", ChannelStatusArchived, DefaultTenantId).Find(&channels).Error; err != nil {
+		return err
+	}
+	current := make(map[string]*Channel, len(channels))
+	for _, channel := range channels {
+		if _, ok := current[channel.Name]; ok {
+			return fmt.Errorf("several channels are named %s, give them unique names to manage them with a config", channel.Name)
+		}
+		current[channel.Name] = channel
+	}
+	names := make(map[string]bool, len(declared))
+	sorted := append([]ConfigChannel(nil), declared...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Name < sorted[j].Name
+	})
+	for _, channel := range sorted {
+		channel.Name = strings.TrimSpace(channel.Name)
+		if channel.Name == "" {
+			return errors.New("a channel of the config has no name")
+		}
+		if names[channel.Name] {
+			return fmt.Errorf("the config has several channels named %s", channel.Name)
+		}
+		names[channel.Name] = true
+		stored, ok := current[channel.Name]
+		if !ok {
+			desired, err := desiredChannel(channel, nil)
+			if err != nil {
+				return err
+			}
+			newChannel := &Channel{CreatedTime: helper.GetTimestamp()}
+			if err = desired.toChannel(newChannel); err != nil {
+				return err
+			}
+			p.add(ConfigChange{Kind: "channel", Name: channel.Name, Action: ConfigActionCreate}, newChannel.Insert)
+			continue
+		}
+		currentChannel, err := toConfigChannel(stored)
+		if err != nil {
+			return err
+		}
+		desired, err := desiredChannel(channel, currentChannel)
+		if err != nil {
+			return err
+		}
+		fields := changedChannelFields(desired, currentChannel)
+		if len(fields) == 0 {
+			continue
+		}
+		if err = desired.toChannel(stored); err != nil {
+			return err
+		}
+		channel := stored
+		p.add(ConfigChange{Kind: "channel", Name: channel.Name, Action: ConfigActionUpdate, Fields: fields}, func() error {
+			return channel.updateFields(fields)
+		})
+	}
+	for _, channel := range channels {
+		if names[channel.Name] {
+			continue
+		}
+		p.add(ConfigChange{Kind: "channel", Name: channel.Name, Action: ConfigActionDelete}, channel.Delete)
+	}
+	return nil
+}
+
+// updateFields saves the given columns of a channel, zero values included
+func (channel *Channel) updateFields(fields []string) error {
+	if err := DB.Model(channel).Select(fields).Updates(channel).Error; err != nil {
+		return err
+	}
+	err := channel.UpdateAbilities()
+	PublishInvalidation(InvalidationChannels, strconv.Itoa(channel.Id))
+	return err
+}
+
+func (p *configPlan) planGroups(declared map[string]float64) error {
+	current := make(map[string]float64)
+	if err := json.Unmarshal([]byte(billingratio.GroupRatio2JSONString()), &current); err != nil {
+		return err
+	}
+	var changes []ConfigChange
+	for group, ratio := range declared {
+		if ratio < 0 {
+			return fmt.Errorf("the ratio of group %s is negative", group)
+		}
+		currentRatio, ok := current[group]
+		switch {
+		case !ok:
+			changes = append(changes, ConfigChange{Kind: "group", Name: group, Action: ConfigActionCreate})
+		case currentRatio != ratio:
+			changes = append(changes, ConfigChange{Kind: "group", Name: group, Action: ConfigActionUpdate, Fields: []string{"ratio"}})
+		}
+	}
+	for group := range current {
+		if _, ok := declared[group]; !ok {
+			changes = append(changes, ConfigChange{Kind: "group", Name: group, Action: ConfigActionDelete})
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Name < changes[j].Name
+	})
+	data, err := json.Marshal(declared)
+	if err != nil {
+		return err
+	}
+	// the groups are saved at once, with their last change
+	for _, change := range changes[:len(changes)-1] {
+		p.add(change, nil)
+	}
+	p.add(changes[len(changes)-1], func() error {
+		return UpdateOption("GroupRatio", string(data))
+	})
+	return nil
+}
+
+// typedSettingValue returns the value of a setting as a value of its type, for the config
+func typedSettingValue(settingType string, value string) any {
+	switch settingType {
+	case SettingTypeBool:
+		return value == "true"
+	case SettingTypeInt:
+		if number, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return number
+		}
+	case SettingTypeFloat:
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+	return value
+}
+
+func settingString(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func (p *configPlan) planSettings(declared map[string]any) error {
+	values := make(map[string]string, len(declared))
+	for key, value := range declared {
+		validated, err := ValidateSetting(key, settingString(value))
+		if err != nil {
+			return err
+		}
+		values[key] = validated
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	config.OptionMapRWMutex.RLock()
+	current := make(map[string]string, len(keys))
+	for _, key := range keys {
+		current[key] = config.OptionMap[key]
+	}
+	config.OptionMapRWMutex.RUnlock()
+	for _, key := range keys {
+		key, value := key, values[key]
+		if current[key] == value {
+			continue
+		}
+		p.add(ConfigChange{Kind: "setting", Name: key, Action: ConfigActionUpdate, Fields: []string{"value"}}, func() error {
+			return UpdateOption(key, value)
+		})
+	}
+	return nil
+}
+
+func toConfigRateLimit(rateLimit *RateLimit) ConfigRateLimit {
+	return ConfigRateLimit{
+		PathPattern: rateLimit.PathPattern,
+		Method:      rateLimit.Method,
+		Dimension:   rateLimit.Dimension,
+		Limit:       rateLimit.Limit,
+		Window:      rateLimit.Window,
+		Status:      rateLimit.Status,
+	}
+}
+
+func (p *configPlan) planRateLimits(declared []ConfigRateLimit) error {
+	rateLimits, err := GetAllRateLimits()
+	if err != nil {
+		return err
+	}
+	current := make(map[string]*RateLimit, len(rateLimits))
+	for _, rateLimit := range rateLimits {
+		current[toConfigRateLimit(rateLimit).name()] = rateLimit
+	}
+	names := make(map[string]bool, len(declared))
+	var desired []*RateLimit
+	for _, rateLimit := range declared {
+		r := &RateLimit{
+			PathPattern: rateLimit.PathPattern,
+			Method:      rateLimit.Method,
+			Dimension:   rateLimit.Dimension,
+			Limit:       rateLimit.Limit,
+			Window:      rateLimit.Window,
+			Status:      rateLimit.Status,
+		}
+		if err = r.Validate(); err != nil {
+			return fmt.Errorf("rate limit %s: %w", rateLimit.PathPattern, err)
+		}
+		if r.Status == 0 {
+			r.Status = RateLimitStatusEnabled
+		}
+		name := toConfigRateLimit(r).name()
+		if names[name] {
+			return fmt.Errorf("the config has several rate limits %s", name)
+		}
+		names[name] = true
+		desired = append(desired, r)
+	}
+	sort.Slice(desired, func(i, j int) bool {
+		return toConfigRateLimit(desired[i]).name() < toConfigRateLimit(desired[j]).name()
+	})
+	changed := false
+	for _, r := range desired {
+		name := toConfigRateLimit(r).name()
+		stored, ok := current[name]
+		if !ok {
+			p.add(ConfigChange{Kind: "rate_limit", Name: name, Action: ConfigActionCreate}, r.Insert)
+			changed = true
+			continue
+		}
+		var fields []string
+		if r.Limit != stored.Limit {
+			fields = append(fields, "limit")
+		}
+		if r.Window != stored.Window {
+			fields = append(fields, "window")
+		}
+		if r.Status != stored.Status {
+			fields = append(fields, "status")
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		r.Id = stored.Id
+		p.add(ConfigChange{Kind: "rate_limit", Name: name, Action: ConfigActionUpdate, Fields: fields}, r.Update)
+		changed = true
+	}
+	for _, rateLimit := range rateLimits {
+		name := toConfigRateLimit(rateLimit).name()
+		if names[name] {
+			continue
+		}
+		p.add(ConfigChange{Kind: "rate_limit", Name: name, Action: ConfigActionDelete}, rateLimit.Delete)
+		changed = true
+	}
+	if changed {
+		p.steps = append(p.steps, func() error {
+			PublishInvalidation(InvalidationRateLimits, "")
+			return nil
+		})
+	}
+	return nil
+}
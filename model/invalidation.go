@@ -0,0 +1,154 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Invalidations are published on a Redis channel when the channels, tokens, options or
+// admin rules change, so every replica, the publishing one included, reloads the caches
+// they affect at once instead of at its next sync. Without Redis they are applied to the
+// caches of this process only.
+
+const invalidationChannel = "one-api:invalidations"
+
+const (
+	InvalidationChannels        = "channels" // the key is the id of the channel
+	InvalidationToken           = "token"    // the key is the key of the token
+	InvalidationOption          = "option"   // the key is the key of the option
+	InvalidationRateLimits      = "rate_limits"
+	InvalidationModeration      = "moderation"
+	InvalidationWebhooks        = "webhooks"
+	InvalidationDebugCapture    = "debug_capture"
+	InvalidationModelMetas      = "model_metas"
+	InvalidationModelAliases    = "model_aliases"
+	InvalidationPromptTemplates = "prompt_templates"
+	InvalidationExperiments     = "experiments"
+	InvalidationPlugins         = "plugins"
+	InvalidationRBAC            = "rbac"
+	InvalidationTrafficMirrors  = "traffic_mirrors"
+	InvalidationCanaries        = "canaries"
+	InvalidationTenants         = "tenants"
+	InvalidationChaosRules      = "chaos_rules"
+)
+
+// channelCacheReloadDelay groups the invalidations of channels changed together, e.g. by
+// a batch import, into one reload
+const channelCacheReloadDelay = 100 * time.Millisecond
+
+type invalidation struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key,omitempty"`
+}
+
+// PublishInvalidation tells every replica that a cached object changed
+func PublishInvalidation(kind string, key string) {
+	if !common.RedisEnabled {
+		applyInvalidation(invalidation{Kind: kind, Key: key})
+		return
+	}
+	message, _ := json.Marshal(invalidation{Kind: kind, Key: key})
+	if err := common.RedisPublish(invalidationChannel, string(message)); err != nil {
+		logger.SysError(fmt.Sprintf("failed to publish the invalidation of %s %s: %s", kind, key, err.Error()))
+		applyInvalidation(invalidation{Kind: kind, Key: key})
+	}
+}
+
+// SubscribeInvalidations applies the invalidations published by the replicas, it
+// reconnects until the process exits
+func SubscribeInvalidations() {
+	for {
+		pubsub := common.RedisSubscribe(context.Background(), invalidationChannel)
+		if pubsub == nil {
+			logger.SysError("the Redis client does not support subscriptions, caches are only refreshed by their sync")
+			return
+		}
+		for message := range pubsub.Channel() {
+			var inv invalidation
+			if err := json.Unmarshal([]byte(message.Payload), &inv); err != nil {
+				logger.SysError("failed to parse invalidation: " + err.Error())
+				continue
+			}
+			applyInvalidation(inv)
+		}
+		_ = pubsub.Close()
+		time.Sleep(time.Second)
+	}
+}
+
+func applyInvalidation(inv invalidation) {
+	switch inv.Kind {
+	case InvalidationChannels:
+		if config.MemoryCacheEnabled {
+			scheduleChannelCacheReload()
+		}
+	case InvalidationToken:
+		if common.RedisEnabled && inv.Key != "" {
+			if err := common.RedisDel(fmt.Sprintf("token:%s", inv.Key)); err != nil {
+				logger.SysError("failed to delete cached token: " + err.Error())
+			}
+		}
+	case InvalidationOption:
+		option := Option{}
+		if err := DB.Where(&Option{Key: inv.Key}).First(&option).Error; err != nil {
+			logger.SysError(fmt.Sprintf("failed to reload option %s: %s", inv.Key, err.Error()))
+			return
+		}
+		if err := updateOptionMap(option.Key, option.Value); err != nil {
+			logger.SysError("failed to update option map: " + err.Error())
+		}
+	case InvalidationRateLimits:
+		InitRateLimitCache()
+	case InvalidationModeration:
+		InitModerationCache()
+	case InvalidationWebhooks:
+		InitWebhookCache()
+	case InvalidationDebugCapture:
+		InitDebugCaptureCache()
+	case InvalidationModelMetas:
+		InitModelMetaCache()
+	case InvalidationModelAliases:
+		InitModelAliasCache()
+	case InvalidationPromptTemplates:
+		InitPromptTemplateCache()
+	case InvalidationExperiments:
+		InitExperimentCache()
+	case InvalidationPlugins:
+		InitPluginCache()
+	case InvalidationRBAC:
+		InitRBACCache()
+	case InvalidationTrafficMirrors:
+		InitTrafficMirrorCache()
+	case InvalidationCanaries:
+		InitCanaryCache()
+	case InvalidationTenants:
+		InitTenantCache()
+	case InvalidationChaosRules:
+		InitChaosRuleCache()
+	}
+}
+
+var channelCacheReloadLock sync.Mutex
+var channelCacheReloadPending bool
+
+func scheduleChannelCacheReload() {
+	channelCacheReloadLock.Lock()
+	defer channelCacheReloadLock.Unlock()
+	if channelCacheReloadPending {
+		return
+	}
+	channelCacheReloadPending = true
+	time.AfterFunc(channelCacheReloadDelay, func() {
+		channelCacheReloadLock.Lock()
+		channelCacheReloadPending = false
+		channelCacheReloadLock.Unlock()
+		InitChannelCache()
+	})
+}
@@ -0,0 +1,30 @@
+package model
+
+import (
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/scheduler"
+)
+
+// RegisterJobs registers the periodic maintenance jobs run by one replica at a time
+func RegisterJobs() {
+	if config.LogRetentionDays > 0 || config.LogPartitionEnabled {
+		scheduler.Register(&scheduler.Job{
+			Name:        "log_retention",
+			Description: "Maintain the log partitions, archive and delete the logs older than LOG_RETENTION_DAYS",
+			Interval:    logRetentionInterval,
+			Jitter:      10 * time.Minute,
+			Timeout:     6 * time.Hour,
+			Immediate:   true,
+			Run:         RunLogRetentionJob,
+		})
+	}
+	scheduler.Register(&scheduler.Job{
+		Name:        "request_trace_retention",
+		Description: "Delete the request traces older than REQUEST_TRACE_RETENTION",
+		Interval:    time.Hour,
+		Jitter:      5 * time.Minute,
+		Run:         DeleteExpiredRequestTraces,
+	})
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"fmt"
+	"net/http"
 
 	"gorm.io/gorm"
 
@@ -24,11 +25,25 @@ type Log struct {
 	Quota             int    `json:"quota" gorm:"default:0"`
 	PromptTokens      int    `json:"prompt_tokens" gorm:"default:0"`
 	CompletionTokens  int    `json:"completion_tokens" gorm:"default:0"`
+	ReasoningTokens   int    `json:"reasoning_tokens" gorm:"default:0"` // part of the completion tokens
+	CachedTokens      int    `json:"cached_tokens" gorm:"default:0"`     // prompt tokens read from the cache of the provider
+	CacheSavedQuota   int    `json:"cache_saved_quota" gorm:"default:0"` // quota the cached tokens did not cost
+	UpstreamQuota     int    `json:"upstream_quota" gorm:"default:0"`    // estimated cost of the request at the provider
 	ChannelId         int    `json:"channel" gorm:"index"`
+	TenantId          int    `json:"tenant_id" gorm:"default:0;index"` // the tenant of the user
+	ChannelName       string `json:"channel_name,omitempty" gorm:"-:all"` // only for the admin logs, archived channels included
 	RequestId         string `json:"request_id" gorm:"default:''"`
 	ElapsedTime       int64  `json:"elapsed_time" gorm:"default:0"` // unit is ms
+	StatusCode        int    `json:"status_code" gorm:"default:0"`  // http status of the relay, 0 on older logs
+	// exact or semantic when the response came from the response cache
+	CacheHit          string `json:"cache_hit" gorm:"type:varchar(16);default:''"`
+	// stage:action outcomes of the moderation policy, e.g. request:redact,response:flag
+	Moderation        string `json:"moderation" gorm:"type:varchar(64);default:''"`
+	ImageCount        int    `json:"image_count" gorm:"default:0"` // images generated or edited
 	IsStream          bool   `json:"is_stream" gorm:"default:false"`
 	SystemPromptReset bool   `json:"system_prompt_reset" gorm:"default:false"`
+	// the stream ended before the completion, only the output streamed was billed
+	Partial           bool   `json:"partial" gorm:"default:false"`
 	// Smart Model Selection tracking
 	VirtualModel      string  `json:"virtual_model" gorm:"type:varchar(255);index"`       // Original requested model (e.g., "auto-smart", "smart-model")
 	ResolvedModel     string  `json:"resolved_model"`                   // Actual model used (e.g., "gpt-4o")
@@ -47,11 +62,18 @@ const (
 	LogTypeManage
 	LogTypeSystem
 	LogTypeTest
+	LogTypeError
 )
 
 func recordLogHelper(ctx context.Context, log *Log) {
 	requestId := helper.GetRequestID(ctx)
 	log.RequestId = requestId
+	log.TenantId = tenantOfUser(log.UserId)
+	sendToLogSinks(log)
+	rollupLog(log)
+	if !config.LogSQLEnabled {
+		return
+	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
 		logger.Error(ctx, "failed to record log: "+err.Error())
@@ -93,6 +115,28 @@ func RecordConsumeLog(ctx context.Context, log *Log) {
 	log.Username = GetUsernameById(log.UserId)
 	log.CreatedAt = helper.GetTimestamp()
 	log.Type = LogTypeConsume
+	if log.StatusCode == 0 {
+		log.StatusCode = http.StatusOK
+	}
+	recordLogHelper(ctx, log)
+}
+
+// RecordErrorLog records a relay request which failed on every channel
+func RecordErrorLog(ctx context.Context, log *Log) {
+	if !config.LogConsumeEnabled {
+		return
+	}
+	log.Username = GetUsernameById(log.UserId)
+	log.CreatedAt = helper.GetTimestamp()
+	log.Type = LogTypeError
+	recordLogHelper(ctx, log)
+}
+
+// RecordModerationLog records a moderation outcome which is not part of a consume or an error log
+func RecordModerationLog(ctx context.Context, log *Log) {
+	log.Username = GetUsernameById(log.UserId)
+	log.CreatedAt = helper.GetTimestamp()
+	log.Type = LogTypeSystem
 	recordLogHelper(ctx, log)
 }
 
@@ -102,12 +146,12 @@ func RecordTestLog(ctx context.Context, log *Log) {
 	recordLogHelper(ctx, log)
 }
 
-func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int) (logs []*Log, err error) {
+func GetAllLogs(tenantId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int, channel int) (logs []*Log, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
-		tx = LOG_DB
+		tx = LOG_DB.Scopes(tenantScope(tenantId))
 	} else {
-		tx = LOG_DB.Where("type = ?", logType)
+		tx = LOG_DB.Scopes(tenantScope(tenantId)).Where("type = ?", logType)
 	}
 	if modelName != "" {
 		tx = tx.Where("model_name = ?", modelName)
@@ -128,9 +172,31 @@ func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName
 		tx = tx.Where("channel_id = ?", channel)
 	}
 	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&logs).Error
+	if err == nil {
+		err = fillChannelNames(logs)
+	}
 	return logs, err
 }
 
+// fillChannelNames sets the names of the channels of logs, which outlive them when they are
+// purged
+func fillChannelNames(logs []*Log) error {
+	var ids []int
+	for _, log := range logs {
+		if log.ChannelId != 0 {
+			ids = append(ids, log.ChannelId)
+		}
+	}
+	names, err := GetChannelNames(ids)
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		log.ChannelName = names[log.ChannelId]
+	}
+	return nil
+}
+
 func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
@@ -150,26 +216,26 @@ func GetUserLogs(userId int, logType int, startTimestamp int64, endTimestamp int
 	if endTimestamp != 0 {
 		tx = tx.Where("created_at <= ?", endTimestamp)
 	}
-	err = tx.Order("id desc").Limit(num).Offset(startIdx).Omit("id").Find(&logs).Error
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Omit("id", "upstream_quota").Find(&logs).Error
 	return logs, err
 }
 
-func SearchAllLogs(keyword string) (logs []*Log, err error) {
-	err = LOG_DB.Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(config.MaxRecentItems).Find(&logs).Error
+func SearchAllLogs(tenantId int, keyword string) (logs []*Log, err error) {
+	err = LOG_DB.Scopes(tenantScope(tenantId)).Where("type = ? or content LIKE ?", keyword, keyword+"%").Order("id desc").Limit(config.MaxRecentItems).Find(&logs).Error
+	if err == nil {
+		err = fillChannelNames(logs)
+	}
 	return logs, err
 }
 
 func SearchUserLogs(userId int, keyword string) (logs []*Log, err error) {
-	err = LOG_DB.Where("user_id = ? and type = ?", userId, keyword).Order("id desc").Limit(config.MaxRecentItems).Omit("id").Find(&logs).Error
+	err = LOG_DB.Where("user_id = ? and type = ?", userId, keyword).Order("id desc").Limit(config.MaxRecentItems).Omit("id", "upstream_quota").Find(&logs).Error
 	return logs, err
 }
 
-func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (quota int64) {
-	ifnull := "ifnull"
-	if common.UsingPostgreSQL {
-		ifnull = "COALESCE"
-	}
-	tx := LOG_DB.Table("logs").Select(fmt.Sprintf("%s(sum(quota),0)", ifnull))
+// consumeLogs selects the consume logs matching the filters of the log stat API
+func consumeLogs(tenantId int, selection string, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) *gorm.DB {
+	tx := LOG_DB.Table("logs").Select(selection).Scopes(tenantScope(tenantId))
 	if username != "" {
 		tx = tx.Where("username = ?", username)
 	}
@@ -188,10 +254,35 @@ func SumUsedQuota(logType int, startTimestamp int64, endTimestamp int64, modelNa
 	if channel != 0 {
 		tx = tx.Where("channel_id = ?", channel)
 	}
-	tx.Where("type = ?", LogTypeConsume).Scan(&quota)
+	return tx.Where("type = ?", LogTypeConsume)
+}
+
+func SumUsedQuota(tenantId int, logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (quota int64) {
+	ifnull := "ifnull"
+	if common.UsingPostgreSQL {
+		ifnull = "COALESCE"
+	}
+	consumeLogs(tenantId, fmt.Sprintf("%s(sum(quota),0)", ifnull), startTimestamp, endTimestamp, modelName, username, tokenName, channel).Scan(&quota)
 	return quota
 }
 
+// CacheSavings sums the prompt tokens read from the cache of the providers and the quota
+// they did not cost
+type CacheSavings struct {
+	CachedTokens    int64 `json:"cached_tokens"`
+	CacheSavedQuota int64 `json:"cache_saved_quota"`
+}
+
+func SumCacheSavings(tenantId int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, channel int) (savings CacheSavings) {
+	ifnull := "ifnull"
+	if common.UsingPostgreSQL {
+		ifnull = "COALESCE"
+	}
+	selection := fmt.Sprintf("%s(sum(cached_tokens),0) as cached_tokens, %s(sum(cache_saved_quota),0) as cache_saved_quota", ifnull, ifnull)
+	consumeLogs(tenantId, selection, startTimestamp, endTimestamp, modelName, username, tokenName, channel).Scan(&savings)
+	return savings
+}
+
 func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string) (token int) {
 	ifnull := "ifnull"
 	if common.UsingPostgreSQL {
@@ -232,6 +323,9 @@ type LogStatistic struct {
 }
 
 func SearchLogsByDayAndModel(userId, start, end int) (LogStatistics []*LogStatistic, err error) {
+	if config.UsageRollupEnabled {
+		return searchRollupsByDayAndModel(userId, start, end)
+	}
 	groupSelect := "DATE_FORMAT(FROM_UNIXTIME(created_at), '%Y-%m-%d') as day"
 
 	if common.UsingPostgreSQL {
@@ -2,7 +2,9 @@ package model
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/songquanpeng/one-api/common/config"
@@ -10,6 +12,21 @@ import (
 	"github.com/songquanpeng/one-api/common/logger"
 )
 
+const (
+	logBatchRetryBackoff  = time.Second
+	logSpillReplayBatches = 10 // per flush period
+)
+
+// LogBatcherDurability is the snapshot of the retry and spill counters of the log batcher
+type LogBatcherDurability struct {
+	Retried       int64
+	Spilled       int64
+	Replayed      int64
+	Dropped       int64
+	SpillBytes    int64
+	SpillSegments int
+}
+
 // LogBatcher handles async batched log insertion
 // This decouples logging from the request path, reducing latency by 5-20ms
 type LogBatcher struct {
@@ -21,6 +38,14 @@ type LogBatcher struct {
 	done        chan struct{}
 	wg          sync.WaitGroup
 	started     bool
+
+	// failed batches are retried, then spilled to disk and replayed once the database recovers
+	spill    *logSpill
+	replayMu sync.Mutex
+	retried  atomic.Int64
+	spilled  atomic.Int64
+	replayed atomic.Int64
+	dropped  atomic.Int64
 }
 
 var (
@@ -52,6 +77,7 @@ func NewLogBatcher(maxSize int, flushPeriod time.Duration) *LogBatcher {
 		maxSize:     maxSize,
 		flushPeriod: flushPeriod,
 		done:        make(chan struct{}),
+		spill:       newLogSpill(),
 	}
 }
 
@@ -85,6 +111,7 @@ func (b *LogBatcher) Stop() {
 
 	// Final flush
 	b.flush()
+	b.replaySpill()
 
 	logger.SysLog("Log batcher stopped")
 }
@@ -100,6 +127,7 @@ func (b *LogBatcher) flushLoop() {
 		select {
 		case <-ticker.C:
 			b.flush()
+			b.replaySpill()
 		case <-b.done:
 			return
 		}
@@ -109,6 +137,12 @@ func (b *LogBatcher) flushLoop() {
 // Add adds a log to the buffer
 // If the buffer is full, it triggers an immediate flush
 func (b *LogBatcher) Add(log *Log) {
+	log.TenantId = tenantOfUser(log.UserId)
+	sendToLogSinks(log)
+	rollupLog(log)
+	if !config.LogSQLEnabled {
+		return
+	}
 	b.mu.Lock()
 	b.buffer = append(b.buffer, log)
 	shouldFlush := len(b.buffer) >= b.maxSize
@@ -134,18 +168,73 @@ func (b *LogBatcher) flush() {
 
 	// Batch insert
 	start := time.Now()
-	err := batchInsertLogs(logs)
+	err := b.insertWithRetry(logs)
 	duration := time.Since(start)
 
 	if err != nil {
 		logger.SysError("Failed to batch insert logs: " + err.Error())
-		// On failure, we could implement retry logic here
-		// For now, logs are lost on failure
+		b.spillLogs(logs)
 	} else {
 		logger.SysLogf("Batch inserted %d logs in %v", len(logs), duration)
 	}
 }
 
+// insertWithRetry inserts logs, retrying with an exponential backoff
+func (b *LogBatcher) insertWithRetry(logs []*Log) error {
+	backoff := logBatchRetryBackoff
+	err := batchInsertLogs(logs)
+	for i := 0; i < config.LogBatchRetryTimes && err != nil; i++ {
+		time.Sleep(backoff)
+		backoff *= 2
+		b.retried.Add(1)
+		err = batchInsertLogs(logs)
+	}
+	return err
+}
+
+// spillLogs writes a failed batch to disk, logs are only dropped when the spill is full or unwritable
+func (b *LogBatcher) spillLogs(logs []*Log) {
+	if err := b.spill.append(logs); err != nil {
+		b.dropped.Add(int64(len(logs)))
+		logger.SysError(fmt.Sprintf("Failed to spill %d logs, they are lost: %s", len(logs), err.Error()))
+		return
+	}
+	b.spilled.Add(int64(len(logs)))
+	logger.SysLogf("Spilled %d logs to %s", len(logs), b.spill.dir)
+}
+
+// replaySpill inserts the spilled batches in order until one fails
+func (b *LogBatcher) replaySpill() {
+	b.replayMu.Lock()
+	defer b.replayMu.Unlock()
+	if err := b.spill.init(); err != nil {
+		return
+	}
+	for i := 0; i < logSpillReplayBatches; i++ {
+		if _, segments := b.spill.stats(); segments == 0 {
+			return
+		}
+		name, logs, err := b.spill.oldest()
+		if err != nil {
+			logger.SysError("Failed to read spilled logs: " + err.Error())
+			return
+		}
+		if name == "" {
+			return
+		}
+		if err = batchInsertLogs(logs); err != nil {
+			return
+		}
+		if err = b.spill.remove(name); err != nil {
+			// replaying it again would insert the logs twice
+			logger.SysError("Failed to remove replayed log segment " + name + ": " + err.Error())
+			return
+		}
+		b.replayed.Add(int64(len(logs)))
+		logger.SysLogf("Replayed %d spilled logs", len(logs))
+	}
+}
+
 // batchInsertLogs inserts multiple logs in a single transaction
 func batchInsertLogs(logs []*Log) error {
 	if len(logs) == 0 {
@@ -181,11 +270,30 @@ func (b *LogBatcher) Stats() map[string]interface{} {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	durability := b.Durability()
 	return map[string]interface{}{
-		"buffer_size":   len(b.buffer),
-		"max_size":      b.maxSize,
-		"flush_period":  b.flushPeriod.String(),
-		"started":       b.started,
+		"buffer_size":    len(b.buffer),
+		"max_size":       b.maxSize,
+		"flush_period":   b.flushPeriod.String(),
+		"started":        b.started,
+		"retried":        durability.Retried,
+		"spilled":        durability.Spilled,
+		"replayed":       durability.Replayed,
+		"dropped":        durability.Dropped,
+		"spill_bytes":    durability.SpillBytes,
+		"spill_segments": durability.SpillSegments,
+	}
+}
+
+func (b *LogBatcher) Durability() LogBatcherDurability {
+	spillSize, spillSegments := b.spill.stats()
+	return LogBatcherDurability{
+		Retried:       b.retried.Load(),
+		Spilled:       b.spilled.Load(),
+		Replayed:      b.replayed.Load(),
+		Dropped:       b.dropped.Load(),
+		SpillBytes:    spillSize,
+		SpillSegments: spillSegments,
 	}
 }
 
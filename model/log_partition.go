@@ -0,0 +1,161 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Monthly partitions of the logs table, ranged on created_at. The table has to
+// be converted to a partitioned one by the administrator beforehand, see the
+// README, only the partitions are maintained here.
+
+const (
+	logPartitionMonthsAhead = 2
+	logPartitionMaxValue    = "pmax"
+)
+
+var logPartitionNamePattern = regexp.MustCompile(`^p(\d{6})$`)
+
+// LogPartition is a monthly partition of the logs table
+type LogPartition struct {
+	Name  string `json:"name"`
+	Start int64  `json:"start"` // first created_at of the partition
+	End   int64  `json:"end"`   // created_at upper bound, exclusive
+}
+
+func logPartitionDialect() string {
+	return LOG_DB.Dialector.Name()
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func logPartitionFor(month time.Time) LogPartition {
+	start := monthStart(month)
+	return LogPartition{
+		Name:  "p" + start.Format("200601"),
+		Start: start.Unix(),
+		End:   start.AddDate(0, 1, 0).Unix(),
+	}
+}
+
+func parseLogPartition(name string) (LogPartition, bool) {
+	match := logPartitionNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return LogPartition{}, false
+	}
+	month, err := time.Parse("200601", match[1])
+	if err != nil {
+		return LogPartition{}, false
+	}
+	return logPartitionFor(month), true
+}
+
+// GetLogPartitions lists the monthly partitions, it fails when the logs table is not partitioned
+func GetLogPartitions() ([]LogPartition, error) {
+	var names []string
+	var err error
+	switch logPartitionDialect() {
+	case "mysql":
+		err = LOG_DB.Raw("SELECT partition_name FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name = 'logs' AND partition_name IS NOT NULL ORDER BY partition_ordinal_position").Scan(&names).Error
+	case "postgres":
+		err = LOG_DB.Raw("SELECT child.relname FROM pg_inherits JOIN pg_class parent ON parent.oid = pg_inherits.inhparent JOIN pg_class child ON child.oid = pg_inherits.inhrelid WHERE parent.relname = 'logs' ORDER BY child.relname").Scan(&names).Error
+	default:
+		return nil, errors.New("log partitioning needs MySQL or PostgreSQL")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, errors.New("table logs is not partitioned")
+	}
+	var partitions []LogPartition
+	for _, name := range names {
+		// postgres partitions are tables of their own, named logs_p202601
+		if partition, ok := parseLogPartition(strings.TrimPrefix(name, "logs_")); ok {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions, nil
+}
+
+// EnsureLogPartitions creates the partitions of the current month and of the next ones
+func EnsureLogPartitions() error {
+	partitions, err := GetLogPartitions()
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(partitions))
+	for _, partition := range partitions {
+		existing[partition.Name] = true
+	}
+	now := time.Now()
+	for i := 0; i <= logPartitionMonthsAhead; i++ {
+		partition := logPartitionFor(monthStart(now).AddDate(0, i, 0))
+		if existing[partition.Name] {
+			continue
+		}
+		if err = createLogPartition(partition); err != nil {
+			return fmt.Errorf("create log partition %s failed: %w", partition.Name, err)
+		}
+		logger.SysLog("created log partition " + partition.Name)
+	}
+	return nil
+}
+
+func createLogPartition(partition LogPartition) error {
+	if logPartitionDialect() == "postgres" {
+		return LOG_DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS logs_%s PARTITION OF logs FOR VALUES FROM (%d) TO (%d)", partition.Name, partition.Start, partition.End)).Error
+	}
+	var hasMaxValue int64
+	if err := LOG_DB.Raw("SELECT COUNT(*) FROM information_schema.partitions WHERE table_schema = DATABASE() AND table_name = 'logs' AND partition_name = ?", logPartitionMaxValue).Scan(&hasMaxValue).Error; err != nil {
+		return err
+	}
+	if hasMaxValue > 0 {
+		return LOG_DB.Exec(fmt.Sprintf("ALTER TABLE logs REORGANIZE PARTITION %s INTO (PARTITION %s VALUES LESS THAN (%d), PARTITION %s VALUES LESS THAN MAXVALUE)",
+			logPartitionMaxValue, partition.Name, partition.End, logPartitionMaxValue)).Error
+	}
+	return LOG_DB.Exec(fmt.Sprintf("ALTER TABLE logs ADD PARTITION (PARTITION %s VALUES LESS THAN (%d))", partition.Name, partition.End)).Error
+}
+
+// dropExpiredLogPartitions drops the partitions entirely older than cutoff, a
+// partition holding rows above maxArchivedId is kept so no row is dropped unarchived
+func dropExpiredLogPartitions(cutoff int64, maxArchivedId int, bounded bool) ([]string, error) {
+	partitions, err := GetLogPartitions()
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, partition := range partitions {
+		if partition.End > cutoff {
+			continue
+		}
+		if bounded {
+			var unarchived int64
+			if err = LOG_DB.Model(&Log{}).Where("created_at >= ? AND created_at < ? AND id > ?", partition.Start, partition.End, maxArchivedId).Count(&unarchived).Error; err != nil {
+				return dropped, err
+			}
+			if unarchived > 0 {
+				continue
+			}
+		}
+		statement := "ALTER TABLE logs DROP PARTITION " + partition.Name
+		if logPartitionDialect() == "postgres" {
+			statement = "DROP TABLE logs_" + partition.Name
+		}
+		if err = LOG_DB.Exec(statement).Error; err != nil {
+			return dropped, err
+		}
+		dropped = append(dropped, partition.Name)
+		logger.SysLog("dropped log partition " + partition.Name + " older than " + strconv.FormatInt(cutoff, 10))
+	}
+	return dropped, nil
+}
@@ -0,0 +1,277 @@
+package model
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	logRetentionBatchSize   = 5000
+	logRetentionInterval    = 24 * time.Hour
+	logArchiveDirName       = "log-archive"
+	logArchiveUploadTimeout = 10 * time.Minute
+)
+
+var ErrLogRetentionRunning = errors.New("log retention job is already running")
+
+// LogRetentionStatus describes the running or last finished retention job
+type LogRetentionStatus struct {
+	Running           bool     `json:"running"`
+	Cutoff            int64    `json:"cutoff"`
+	StartedAt         int64    `json:"started_at"`
+	FinishedAt        int64    `json:"finished_at"`
+	Archived          int64    `json:"archived"`
+	Deleted           int64    `json:"deleted"`
+	ArchiveFile       string   `json:"archive_file"`
+	DroppedPartitions []string `json:"dropped_partitions"`
+	Error             string   `json:"error"`
+}
+
+var logRetentionLock sync.Mutex
+var logRetentionStatus LogRetentionStatus
+
+func GetLogRetentionStatus() LogRetentionStatus {
+	logRetentionLock.Lock()
+	defer logRetentionLock.Unlock()
+	return logRetentionStatus
+}
+
+func updateLogRetentionStatus(update func(status *LogRetentionStatus)) {
+	logRetentionLock.Lock()
+	defer logRetentionLock.Unlock()
+	update(&logRetentionStatus)
+}
+
+// StartLogRetention archives then deletes, in background, the logs older than days
+func StartLogRetention(days int) error {
+	if days <= 0 {
+		return errors.New("retention days must be positive")
+	}
+	cutoff := time.Now().AddDate(0, 0, -days).Unix()
+	logRetentionLock.Lock()
+	if logRetentionStatus.Running {
+		logRetentionLock.Unlock()
+		return ErrLogRetentionRunning
+	}
+	logRetentionStatus = LogRetentionStatus{
+		Running:   true,
+		Cutoff:    cutoff,
+		StartedAt: helper.GetTimestamp(),
+	}
+	logRetentionLock.Unlock()
+
+	go func() {
+		err := runLogRetention(cutoff)
+		updateLogRetentionStatus(func(status *LogRetentionStatus) {
+			status.Running = false
+			status.FinishedAt = helper.GetTimestamp()
+			if err != nil {
+				status.Error = err.Error()
+			}
+		})
+		status := GetLogRetentionStatus()
+		if err != nil {
+			logger.SysError("log retention failed: " + err.Error())
+			return
+		}
+		logger.SysLogf("log retention done: %d logs archived, %d deleted, %d partitions dropped", status.Archived, status.Deleted, len(status.DroppedPartitions))
+	}()
+	return nil
+}
+
+func runLogRetention(cutoff int64) error {
+	// without an archive every old log goes, otherwise only the archived ones,
+	// logs replayed from the spill meanwhile are left to the next run
+	maxArchivedId := 0
+	bounded := config.LogArchiveEnabled
+	if bounded {
+		archiveFile, count, lastId, err := archiveLogs(cutoff)
+		if err != nil {
+			return fmt.Errorf("archive logs failed: %w", err)
+		}
+		if count == 0 {
+			return nil
+		}
+		if config.LogArchiveS3Endpoint != "" {
+			if err = uploadLogArchive(archiveFile); err != nil {
+				return fmt.Errorf("upload log archive failed: %w", err)
+			}
+			_ = os.Remove(archiveFile)
+		}
+		maxArchivedId = lastId
+	}
+
+	if config.LogPartitionEnabled {
+		dropped, err := dropExpiredLogPartitions(cutoff, maxArchivedId, bounded)
+		updateLogRetentionStatus(func(status *LogRetentionStatus) {
+			status.DroppedPartitions = dropped
+		})
+		if err != nil {
+			return fmt.Errorf("drop log partitions failed: %w", err)
+		}
+	}
+	return deleteExpiredLogs(cutoff, maxArchivedId, bounded)
+}
+
+func logArchiveDir() string {
+	if config.LogArchiveDir != "" {
+		return config.LogArchiveDir
+	}
+	return filepath.Join(logger.LogDir, logArchiveDirName)
+}
+
+// archiveLogs writes the logs older than cutoff to a gzipped JSONL file, by ascending id
+func archiveLogs(cutoff int64) (name string, count int64, lastId int, err error) {
+	dir := logArchiveDir()
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, 0, err
+	}
+	name = filepath.Join(dir, fmt.Sprintf("logs-before-%s-%d.jsonl.gz", time.Unix(cutoff, 0).UTC().Format("20060102"), time.Now().Unix()))
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", 0, 0, err
+	}
+	defer func() {
+		if err != nil || count == 0 {
+			_ = os.Remove(name)
+		}
+	}()
+	defer file.Close()
+	gz := gzip.NewWriter(file)
+	encoder := json.NewEncoder(gz)
+	for {
+		var logs []*Log
+		if err = LOG_DB.Where("created_at < ? AND id > ?", cutoff, lastId).Order("id").Limit(logRetentionBatchSize).Find(&logs).Error; err != nil {
+			return "", 0, 0, err
+		}
+		if len(logs) == 0 {
+			break
+		}
+		for _, log := range logs {
+			if err = encoder.Encode(log); err != nil {
+				return "", 0, 0, err
+			}
+		}
+		lastId = logs[len(logs)-1].Id
+		count += int64(len(logs))
+		updateLogRetentionStatus(func(status *LogRetentionStatus) {
+			status.Archived = count
+		})
+	}
+	if err = gz.Close(); err != nil {
+		return "", 0, 0, err
+	}
+	if err = file.Sync(); err != nil {
+		return "", 0, 0, err
+	}
+	if count > 0 {
+		updateLogRetentionStatus(func(status *LogRetentionStatus) {
+			status.ArchiveFile = name
+		})
+	}
+	return name, count, lastId, nil
+}
+
+// uploadLogArchive puts the archive into the S3 compatible bucket of LOG_ARCHIVE_S3_ENDPOINT
+func uploadLogArchive(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), logArchiveUploadTimeout)
+	defer cancel()
+	objectURL := strings.TrimSuffix(config.LogArchiveS3Endpoint, "/") + "/one-api/" + filepath.Base(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, objectURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	hash := sha256.Sum256(data)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hash[:]))
+	signer := client.AWSSigV4Signer(config.LogArchiveS3AccessKey, config.LogArchiveS3SecretKey, config.LogArchiveS3Region, "s3")
+	if err = signer.Sign(req); err != nil {
+		return err
+	}
+	resp, err := logSinkHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	updateLogRetentionStatus(func(status *LogRetentionStatus) {
+		status.ArchiveFile = objectURL
+	})
+	return nil
+}
+
+// deleteExpiredLogs deletes by batches of ids to keep the locks short
+func deleteExpiredLogs(cutoff int64, maxArchivedId int, bounded bool) error {
+	for {
+		var ids []int
+		tx := LOG_DB.Model(&Log{}).Where("created_at < ?", cutoff)
+		if bounded {
+			tx = tx.Where("id <= ?", maxArchivedId)
+		}
+		if err := tx.Order("id").Limit(logRetentionBatchSize).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		result := LOG_DB.Where("id IN ?", ids).Delete(&Log{})
+		if result.Error != nil {
+			return result.Error
+		}
+		updateLogRetentionStatus(func(status *LogRetentionStatus) {
+			status.Deleted += result.RowsAffected
+		})
+	}
+}
+
+// RunLogRetentionJob maintains the log partitions and runs the retention, it returns once the
+// retention is done so the job keeps its lock meanwhile
+func RunLogRetentionJob(ctx context.Context) error {
+	if config.LogPartitionEnabled {
+		if err := EnsureLogPartitions(); err != nil {
+			return fmt.Errorf("failed to maintain log partitions: %w", err)
+		}
+	}
+	if config.LogRetentionDays <= 0 {
+		return nil
+	}
+	if err := StartLogRetention(config.LogRetentionDays); err != nil {
+		return err
+	}
+	for GetLogRetentionStatus().Running {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+	if status := GetLogRetentionStatus(); status.Error != "" {
+		return errors.New(status.Error)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package model
+
+import (
+	"gorm.io/gorm"
+)
+
+const (
+	LogStatusSuccess = "success"
+	LogStatusError   = "error"
+)
+
+// LogSearchFilter selects the logs of SearchLogs, zero values match everything but TenantId
+type LogSearchFilter struct {
+	TenantId   int // AllTenants matches every tenant
+	Type       int
+	UserId     int
+	Username   string
+	TokenName  string
+	ChannelId  int
+	ModelName  string
+	Status     string // success, error or a http status code
+	StatusCode int
+	CacheHit   *bool
+	Partial    *bool
+	Start      int64
+	End        int64
+	MinLatency int64 // unit is ms
+}
+
+func (filter *LogSearchFilter) apply(tx *gorm.DB) *gorm.DB {
+	tx = tx.Scopes(tenantScope(filter.TenantId))
+	if filter.Type != LogTypeUnknown {
+		tx = tx.Where("type = ?", filter.Type)
+	}
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Username != "" {
+		tx = tx.Where("username = ?", filter.Username)
+	}
+	if filter.TokenName != "" {
+		tx = tx.Where("token_name = ?", filter.TokenName)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	switch {
+	case filter.StatusCode != 0:
+		tx = tx.Where("status_code = ?", filter.StatusCode)
+	case filter.Status == LogStatusSuccess:
+		tx = tx.Where("type = ? AND status_code < ?", LogTypeConsume, 400)
+	case filter.Status == LogStatusError:
+		tx = tx.Where("type = ?", LogTypeError)
+	}
+	if filter.CacheHit != nil {
+		if *filter.CacheHit {
+			tx = tx.Where("cache_hit <> ''")
+		} else {
+			tx = tx.Where("cache_hit = ''")
+		}
+	}
+	if filter.Partial != nil {
+		tx = tx.Where("partial = ?", *filter.Partial)
+	}
+	if filter.Start != 0 {
+		tx = tx.Where("created_at >= ?", filter.Start)
+	}
+	if filter.End != 0 {
+		tx = tx.Where("created_at <= ?", filter.End)
+	}
+	if filter.MinLatency != 0 {
+		tx = tx.Where("elapsed_time >= ?", filter.MinLatency)
+	}
+	return tx
+}
+
+// SearchLogs returns up to limit logs matching filter with an id below cursor, newest first.
+// A cursor of 0 starts from the newest log, the next cursor is the id of the last log returned.
+func SearchLogs(filter *LogSearchFilter, cursor int, limit int) (logs []*Log, err error) {
+	tx := filter.apply(LOG_DB.Model(&Log{}))
+	if cursor > 0 {
+		tx = tx.Where("id < ?", cursor)
+	}
+	err = tx.Order("id desc").Limit(limit).Find(&logs).Error
+	return logs, err
+}
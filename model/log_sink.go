@@ -0,0 +1,204 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// LogSink receives the logs in addition to, or instead of, the SQL log table
+type LogSink interface {
+	Name() string
+	Write(ctx context.Context, logs []*Log) error
+}
+
+const (
+	logSinkQueueSize     = 10000
+	logSinkBatchSize     = 500
+	logSinkFlushPeriod   = time.Second
+	logSinkWriteTimeout  = 10 * time.Second
+	logSinkRetryInterval = time.Second
+)
+
+// logSinkWorker batches the logs of one sink, so a slow sink does not hold back the others
+type logSinkWorker struct {
+	sink    LogSink
+	queue   chan *Log
+	flush   chan chan struct{}
+	written atomic.Int64
+	failed  atomic.Int64
+	dropped atomic.Int64
+}
+
+var logSinkWorkers []*logSinkWorker
+
+func newLogSink(name string) (LogSink, error) {
+	switch name {
+	case "clickhouse":
+		return newClickHouseLogSink(config.LogSinkClickHouseURL, config.LogSinkClickHouseTable)
+	case "kafka":
+		return newKafkaLogSink(config.LogSinkKafkaRestURL, config.LogSinkKafkaTopic)
+	case "loki":
+		return newLokiLogSink(config.LogSinkLokiURL)
+	case "file":
+		return newFileLogSink(config.LogSinkFileDir, config.LogSinkFileMaxSize, config.LogSinkFileMaxBackups)
+	}
+	return nil, fmt.Errorf("unknown log sink: %s", name)
+}
+
+// InitLogSinks starts the sinks listed in LOG_SINKS
+func InitLogSinks() {
+	for _, name := range strings.Split(config.LogSinks, ",") {
+		name = strings.TrimSpace(strings.ToLower(name))
+		if name == "" {
+			continue
+		}
+		sink, err := newLogSink(name)
+		if err != nil {
+			logger.FatalLog("failed to init log sink: " + err.Error())
+		}
+		worker := &logSinkWorker{
+			sink:  sink,
+			queue: make(chan *Log, logSinkQueueSize),
+			flush: make(chan chan struct{}),
+		}
+		logSinkWorkers = append(logSinkWorkers, worker)
+		go worker.run()
+		logger.SysLog("log sink enabled: " + name)
+	}
+	if !config.LogSQLEnabled {
+		if len(logSinkWorkers) == 0 {
+			logger.SysError("LOG_SQL_ENABLED is false but no log sink is configured, logs are still written to the database")
+			config.LogSQLEnabled = true
+		} else {
+			logger.SysLog("logs are only written to the log sinks, usage statistics of the log table are unavailable")
+		}
+	}
+}
+
+// sendToLogSinks queues a copy of log for every sink, it never blocks the request
+func sendToLogSinks(log *Log) {
+	if len(logSinkWorkers) == 0 {
+		return
+	}
+	copied := *log
+	for _, worker := range logSinkWorkers {
+		select {
+		case worker.queue <- &copied:
+		default:
+			worker.dropped.Add(1)
+		}
+	}
+}
+
+func (w *logSinkWorker) run() {
+	ticker := time.NewTicker(logSinkFlushPeriod)
+	defer ticker.Stop()
+	batch := make([]*Log, 0, logSinkBatchSize)
+	for {
+		select {
+		case log := <-w.queue:
+			batch = append(batch, log)
+			if len(batch) < logSinkBatchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case done := <-w.flush:
+			batch = w.drain(batch)
+			if len(batch) > 0 {
+				w.write(batch)
+			}
+			close(done)
+			batch = make([]*Log, 0, logSinkBatchSize)
+			continue
+		}
+		w.write(batch)
+		batch = make([]*Log, 0, logSinkBatchSize)
+	}
+}
+
+// drain moves the queued logs to batch, writing full batches on the way
+func (w *logSinkWorker) drain(batch []*Log) []*Log {
+	for {
+		select {
+		case log := <-w.queue:
+			batch = append(batch, log)
+			if len(batch) >= logSinkBatchSize {
+				w.write(batch)
+				batch = make([]*Log, 0, logSinkBatchSize)
+			}
+		default:
+			return batch
+		}
+	}
+}
+
+// FlushLogSinks writes the queued logs of every sink, used on shutdown
+func FlushLogSinks(ctx context.Context) error {
+	for _, worker := range logSinkWorkers {
+		done := make(chan struct{})
+		select {
+		case worker.flush <- done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// write sends a batch, retrying once before giving up on it
+func (w *logSinkWorker) write(logs []*Log) {
+	err := w.writeOnce(logs)
+	if err != nil {
+		time.Sleep(logSinkRetryInterval)
+		err = w.writeOnce(logs)
+	}
+	if err != nil {
+		w.failed.Add(int64(len(logs)))
+		logger.SysError(fmt.Sprintf("failed to write %d logs to the %s sink: %s", len(logs), w.sink.Name(), err.Error()))
+		return
+	}
+	w.written.Add(int64(len(logs)))
+}
+
+func (w *logSinkWorker) writeOnce(logs []*Log) error {
+	ctx, cancel := context.WithTimeout(context.Background(), logSinkWriteTimeout)
+	defer cancel()
+	return w.sink.Write(ctx, logs)
+}
+
+// LogSinkStats is the snapshot of a log sink
+type LogSinkStats struct {
+	Name    string `json:"name"`
+	Written int64  `json:"written"`
+	Failed  int64  `json:"failed"`
+	Dropped int64  `json:"dropped"` // the queue was full
+	Queued  int    `json:"queued"`
+}
+
+func GetLogSinkStats() []LogSinkStats {
+	stats := make([]LogSinkStats, 0, len(logSinkWorkers))
+	for _, worker := range logSinkWorkers {
+		stats = append(stats, LogSinkStats{
+			Name:    worker.sink.Name(),
+			Written: worker.written.Load(),
+			Failed:  worker.failed.Load(),
+			Dropped: worker.dropped.Load(),
+			Queued:  len(worker.queue),
+		})
+	}
+	return stats
+}
@@ -0,0 +1,116 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	logSinkFileName   = "one-api-logs.jsonl"
+	logSinkFilePrefix = "one-api-logs-"
+)
+
+// fileLogSink appends JSON lines to a file rotated by size, it is only used by
+// its sink worker so it needs no locking
+type fileLogSink struct {
+	dir        string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func newFileLogSink(dir string, maxSizeMB int, maxBackups int) (LogSink, error) {
+	if dir == "" {
+		dir = logger.LogDir
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileLogSink{
+		dir:        dir,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}, nil
+}
+
+func (s *fileLogSink) Name() string {
+	return "file"
+}
+
+func (s *fileLogSink) Write(_ context.Context, logs []*Log) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, log := range logs {
+		if err := encoder.Encode(log); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(buf.Len()) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(buf.Bytes())
+	s.size += int64(n)
+	return err
+}
+
+func (s *fileLogSink) open() error {
+	file, err := os.OpenFile(filepath.Join(s.dir, logSinkFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file after its rotation time and removes the oldest backups
+func (s *fileLogSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		logger.SysError("failed to close log sink file: " + err.Error())
+	}
+	s.file = nil
+	backup := logSinkFilePrefix + time.Now().Format("20060102-150405.000") + ".jsonl"
+	if err := os.Rename(filepath.Join(s.dir, logSinkFileName), filepath.Join(s.dir, backup)); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+	if s.maxBackups <= 0 {
+		return nil
+	}
+	backups, err := filepath.Glob(filepath.Join(s.dir, logSinkFilePrefix+"*.jsonl"))
+	if err != nil {
+		return nil
+	}
+	sort.Strings(backups)
+	for len(backups) > s.maxBackups {
+		_ = os.Remove(backups[0])
+		backups = backups[1:]
+	}
+	return nil
+}
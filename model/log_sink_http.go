@@ -0,0 +1,185 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var logSinkHTTPClient = &http.Client{}
+
+// postLogSink sends body and fails on non 2xx responses
+func postLogSink(ctx context.Context, endpoint string, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := logSinkHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+func requireSinkURL(name string, rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("%s log sink needs a url", name)
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%s log sink url must be an http or https url", name)
+	}
+	return parsed, nil
+}
+
+var clickHouseTablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// clickHouseLogSink inserts through the ClickHouse HTTP interface, columns
+// of the table missing from the log are left to their defaults
+type clickHouseLogSink struct {
+	endpoint string
+}
+
+func newClickHouseLogSink(rawURL string, table string) (LogSink, error) {
+	endpoint, err := requireSinkURL("clickhouse", rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !clickHouseTablePattern.MatchString(table) {
+		return nil, errors.New("invalid clickhouse table name: " + table)
+	}
+	query := endpoint.Query()
+	query.Set("query", "INSERT INTO "+table+" FORMAT JSONEachRow")
+	query.Set("input_format_skip_unknown_fields", "1")
+	endpoint.RawQuery = query.Encode()
+	return &clickHouseLogSink{endpoint: endpoint.String()}, nil
+}
+
+func (s *clickHouseLogSink) Name() string {
+	return "clickhouse"
+}
+
+func (s *clickHouseLogSink) Write(ctx context.Context, logs []*Log) error {
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, log := range logs {
+		if err := encoder.Encode(log); err != nil {
+			return err
+		}
+	}
+	return postLogSink(ctx, s.endpoint, "application/x-ndjson", body.Bytes())
+}
+
+// kafkaLogSink produces to a topic through a Kafka REST proxy (v2 API),
+// logs are keyed by user so the logs of a user stay in order
+type kafkaLogSink struct {
+	endpoint string
+}
+
+func newKafkaLogSink(rawURL string, topic string) (LogSink, error) {
+	endpoint, err := requireSinkURL("kafka", rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if topic == "" {
+		return nil, errors.New("kafka log sink needs a topic")
+	}
+	return &kafkaLogSink{endpoint: strings.TrimSuffix(endpoint.String(), "/") + "/topics/" + url.PathEscape(topic)}, nil
+}
+
+func (s *kafkaLogSink) Name() string {
+	return "kafka"
+}
+
+func (s *kafkaLogSink) Write(ctx context.Context, logs []*Log) error {
+	type record struct {
+		Key   string `json:"key"`
+		Value *Log   `json:"value"`
+	}
+	records := make([]record, 0, len(logs))
+	for _, log := range logs {
+		records = append(records, record{Key: strconv.Itoa(log.UserId), Value: log})
+	}
+	body, err := json.Marshal(map[string]interface{}{"records": records})
+	if err != nil {
+		return err
+	}
+	return postLogSink(ctx, s.endpoint, "application/vnd.kafka.json.v2+json", body)
+}
+
+// lokiLogSink pushes the logs as JSON lines, one stream per log type
+type lokiLogSink struct {
+	endpoint string
+}
+
+func newLokiLogSink(rawURL string) (LogSink, error) {
+	endpoint, err := requireSinkURL("loki", rawURL)
+	if err != nil {
+		return nil, err
+	}
+	return &lokiLogSink{endpoint: strings.TrimSuffix(endpoint.String(), "/") + "/loki/api/v1/push"}, nil
+}
+
+func (s *lokiLogSink) Name() string {
+	return "loki"
+}
+
+var logTypeNames = map[int]string{
+	LogTypeUnknown: "unknown",
+	LogTypeTopup:   "topup",
+	LogTypeConsume: "consume",
+	LogTypeManage:  "manage",
+	LogTypeSystem:  "system",
+	LogTypeTest:    "test",
+}
+
+func (s *lokiLogSink) Write(ctx context.Context, logs []*Log) error {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	streams := make(map[int]*stream)
+	for _, log := range logs {
+		line, err := json.Marshal(log)
+		if err != nil {
+			return err
+		}
+		st, ok := streams[log.Type]
+		if !ok {
+			typeName, ok := logTypeNames[log.Type]
+			if !ok {
+				typeName = strconv.Itoa(log.Type)
+			}
+			st = &stream{Stream: map[string]string{"job": "one-api", "type": typeName}}
+			streams[log.Type] = st
+		}
+		timestamp := strconv.FormatInt(log.CreatedAt*1e9, 10)
+		st.Values = append(st.Values, [2]string{timestamp, string(line)})
+	}
+	payload := struct {
+		Streams []*stream `json:"streams"`
+	}{}
+	for _, st := range streams {
+		payload.Streams = append(payload.Streams, st)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return postLogSink(ctx, s.endpoint, "application/json", body)
+}
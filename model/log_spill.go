@@ -0,0 +1,218 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	logSpillSuffix   = ".jsonl"
+	logSpillTmpExt   = ".tmp"
+	logSpillCorrupt  = ".corrupt"
+	logSpillDirName  = "log-spill"
+	logSpillFileMode = 0600
+)
+
+var errLogSpillFull = errors.New("log spill queue is full")
+
+// logSpill is an on-disk queue of the log batches that could not be inserted.
+// Each batch is written to its own segment, named by creation time so segments
+// are replayed in order, and the segment is removed once it has been replayed.
+type logSpill struct {
+	dir     string
+	maxSize int64
+
+	initOnce sync.Once
+	initErr  error
+
+	mu       sync.Mutex
+	size     int64
+	segments int
+	seq      int
+}
+
+func newLogSpill() *logSpill {
+	dir := config.LogSpillDir
+	if dir == "" {
+		dir = filepath.Join(logger.LogDir, logSpillDirName)
+	}
+	return &logSpill{
+		dir:     dir,
+		maxSize: int64(config.LogSpillMaxSize) * 1024 * 1024,
+	}
+}
+
+// init creates the spill directory and accounts the segments left by a previous run
+func (s *logSpill) init() error {
+	s.initOnce.Do(func() {
+		if s.initErr = os.MkdirAll(s.dir, 0700); s.initErr != nil {
+			return
+		}
+		names, err := s.list()
+		if err != nil {
+			s.initErr = err
+			return
+		}
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(s.dir, name))
+			if err != nil {
+				continue
+			}
+			s.size += info.Size()
+			s.segments++
+		}
+		if s.segments > 0 {
+			logger.SysLogf("found %d spilled log segments in %s", s.segments, s.dir)
+		}
+	})
+	return s.initErr
+}
+
+// list returns the segment names in replay order, unfinished writes are removed
+func (s *logSpill) list() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasSuffix(name, logSpillTmpExt) {
+			_ = os.Remove(filepath.Join(s.dir, name))
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, logSpillSuffix) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// append writes logs as a new segment, fsynced before it becomes visible
+func (s *logSpill) append(logs []*Log) error {
+	if s.maxSize <= 0 {
+		return errLogSpillFull
+	}
+	if err := s.init(); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, log := range logs {
+		if err := encoder.Encode(log); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(buf.Len()) > s.maxSize {
+		return errLogSpillFull
+	}
+	s.seq++
+	name := filepath.Join(s.dir, fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.seq%1000000, logSpillSuffix))
+	if err := writeFileSync(name+logSpillTmpExt, buf.Bytes()); err != nil {
+		_ = os.Remove(name + logSpillTmpExt)
+		return err
+	}
+	if err := os.Rename(name+logSpillTmpExt, name); err != nil {
+		_ = os.Remove(name + logSpillTmpExt)
+		return err
+	}
+	s.size += int64(buf.Len())
+	s.segments++
+	return nil
+}
+
+func writeFileSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, logSpillFileMode)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// oldest reads the oldest segment, corrupt segments are renamed and skipped
+func (s *logSpill) oldest() (string, []*Log, error) {
+	if err := s.init(); err != nil {
+		return "", nil, err
+	}
+	for {
+		names, err := s.list()
+		if err != nil || len(names) == 0 {
+			return "", nil, err
+		}
+		name := filepath.Join(s.dir, names[0])
+		logs, err := readLogSegment(name)
+		if err == nil {
+			return name, logs, nil
+		}
+		logger.SysError(fmt.Sprintf("corrupt log spill segment %s, moving it aside: %s", name, err.Error()))
+		s.forget(name)
+		if err = os.Rename(name, name+logSpillCorrupt); err != nil {
+			return "", nil, err
+		}
+	}
+}
+
+func readLogSegment(name string) ([]*Log, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var logs []*Log
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		log := &Log{}
+		if err = json.Unmarshal(scanner.Bytes(), log); err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	return logs, scanner.Err()
+}
+
+// remove deletes a segment once its logs have been inserted
+func (s *logSpill) remove(name string) error {
+	s.forget(name)
+	return os.Remove(name)
+}
+
+func (s *logSpill) forget(name string) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.size -= info.Size()
+	s.segments--
+	s.mu.Unlock()
+}
+
+func (s *logSpill) stats() (size int64, segments int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size, s.segments
+}
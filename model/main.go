@@ -134,33 +134,28 @@ func InitDB() {
 	logger.SysLog("database migrated")
 }
 
+// dbModels are the tables of DB, in migration order
+var dbModels = []any{
+	&Channel{}, &Token{}, &User{}, &Option{}, &Redemption{}, &Ability{}, &Log{},
+	&DebugCaptureRule{}, &DebugCapture{}, &RequestTrace{}, &ModerationPolicy{}, &Webhook{},
+	&StoredResponse{}, &File{}, &Batch{}, &BatchResult{}, &DeferredRequest{}, &RateLimit{},
+	&Organization{}, &OrganizationMember{}, &ModelMeta{}, &ModelAlias{}, &PromptTemplate{},
+	&PromptTemplateVersion{}, &Experiment{}, &ExperimentArmStat{}, &Plugin{}, &CustomRole{},
+	&RoleBinding{}, &Payment{}, &ChannelInvoice{}, &RedemptionUse{}, &PromoGrant{},
+	&FreeAllowanceUsage{}, &ChannelTag{}, &TrafficMirror{}, &TrafficMirrorStat{}, &ChaosRule{},
+	&ChannelCanary{}, &RequestDefaults{}, &Tenant{},
+}
+
+// logDBModels are the tables of LOG_DB, along with the usage rollups
+var logDBModels = []any{&Log{}, &DebugCapture{}, &RequestTrace{}}
+
 func migrateDB() error {
-	var err error
-	if err = DB.AutoMigrate(&Channel{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&Token{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&User{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&Option{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&Redemption{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&Ability{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&Log{}); err != nil {
-		return err
-	}
-	if err = DB.AutoMigrate(&Channel{}); err != nil {
-		return err
+	for _, table := range dbModels {
+		if err := DB.AutoMigrate(table); err != nil {
+			return err
+		}
 	}
-	return nil
+	return migrateUsageRollup(DB)
 }
 
 func InitLogDB() {
@@ -193,11 +188,12 @@ func InitLogDB() {
 }
 
 func migrateLOGDB() error {
-	var err error
-	if err = LOG_DB.AutoMigrate(&Log{}); err != nil {
-		return err
+	for _, table := range logDBModels {
+		if err := LOG_DB.AutoMigrate(table); err != nil {
+			return err
+		}
 	}
-	return nil
+	return migrateUsageRollup(LOG_DB)
 }
 
 func setDBConns(db *gorm.DB) *sql.DB {
@@ -0,0 +1,168 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	ModelAliasStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	ModelAliasStatusDisabled = 2 // also don't use 0
+)
+
+// ModelAlias redirects the requests for a model, or the models matching a pattern such as
+// claude-2*, to another model before the channel is selected, for all the channels
+type ModelAlias struct {
+	Id          int    `json:"id"`
+	Pattern     string `json:"pattern" gorm:"type:varchar(128);uniqueIndex"`
+	Target      string `json:"target" gorm:"type:varchar(128)"`
+	SunsetTime  int64  `json:"sunset_time" gorm:"bigint;default:0"` // sent in the Sunset header, 0 for none
+	Warning     string `json:"warning" gorm:"type:varchar(512);default:''"`
+	Status      int    `json:"status" gorm:"default:1"`
+	Hits        int64  `json:"hits" gorm:"bigint;default:0"`
+	LastHitTime int64  `json:"last_hit_time" gorm:"bigint;default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (a *ModelAlias) Validate() error {
+	a.Pattern = strings.TrimSpace(a.Pattern)
+	a.Target = strings.TrimSpace(a.Target)
+	if a.Pattern == "" || a.Target == "" {
+		return errors.New("alias needs a pattern and a target model")
+	}
+	if _, err := path.Match(a.Pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern: %s", a.Pattern)
+	}
+	if IsModelPattern(a.Target) {
+		return errors.New("the target must be a model, not a pattern")
+	}
+	if a.matches(a.Target) {
+		return errors.New("the target matches the pattern of the alias")
+	}
+	return nil
+}
+
+func (a *ModelAlias) matches(modelName string) bool {
+	if a.Pattern == modelName {
+		return true
+	}
+	matched, _ := path.Match(a.Pattern, modelName)
+	return matched
+}
+
+func GetAllModelAliases() ([]*ModelAlias, error) {
+	var aliases []*ModelAlias
+	if err := DB.Order("id asc").Find(&aliases).Error; err != nil {
+		return nil, err
+	}
+	modelAliasHitsLock.Lock()
+	for _, alias := range aliases {
+		alias.Hits += modelAliasHits[alias.Id]
+	}
+	modelAliasHitsLock.Unlock()
+	return aliases, nil
+}
+
+func GetModelAliasById(id int) (*ModelAlias, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	alias := ModelAlias{Id: id}
+	err := DB.First(&alias, "id = ?", id).Error
+	return &alias, err
+}
+
+func (a *ModelAlias) Insert() error {
+	a.CreatedTime = helper.GetTimestamp()
+	return DB.Create(a).Error
+}
+
+func (a *ModelAlias) Update() error {
+	return DB.Model(a).Select("pattern", "target", "sunset_time", "warning", "status").Updates(a).Error
+}
+
+func (a *ModelAlias) Delete() error {
+	return DB.Delete(a).Error
+}
+
+var enabledModelAliases []*ModelAlias
+var modelAliasSyncLock sync.RWMutex
+
+// InitModelAliasCache loads the enabled aliases into memory, it is called on startup, after
+// every admin change and periodically
+func InitModelAliasCache() {
+	var aliases []*ModelAlias
+	err := DB.Where("status = ?", ModelAliasStatusEnabled).Order("id asc").Find(&aliases).Error
+	if err != nil {
+		logger.SysError("failed to load model aliases: " + err.Error())
+		return
+	}
+	modelAliasSyncLock.Lock()
+	enabledModelAliases = aliases
+	modelAliasSyncLock.Unlock()
+}
+
+// SyncModelAliasCache saves the hits counted since the last sync and reloads the aliases
+func SyncModelAliasCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushModelAliasHits()
+		InitModelAliasCache()
+	}
+}
+
+// CacheResolveModelAlias returns the alias redirecting a model, the aliases of a model
+// win over the patterns matching it, nil when the model is not redirected
+func CacheResolveModelAlias(modelName string) *ModelAlias {
+	modelAliasSyncLock.RLock()
+	defer modelAliasSyncLock.RUnlock()
+	var matched *ModelAlias
+	for _, alias := range enabledModelAliases {
+		if alias.Pattern == modelName {
+			return alias
+		}
+		if matched == nil && alias.matches(modelName) {
+			matched = alias
+		}
+	}
+	return matched
+}
+
+var modelAliasHits = make(map[int]int64)
+var modelAliasLastHits = make(map[int]int64)
+var modelAliasHitsLock sync.Mutex
+
+// RecordModelAliasHit counts a request redirected by an alias, the counts are saved by
+// SyncModelAliasCache
+func RecordModelAliasHit(id int) {
+	modelAliasHitsLock.Lock()
+	modelAliasHits[id]++
+	modelAliasLastHits[id] = helper.GetTimestamp()
+	modelAliasHitsLock.Unlock()
+}
+
+// FlushModelAliasHits saves the hits counted in memory
+func FlushModelAliasHits() {
+	modelAliasHitsLock.Lock()
+	hits, lastHits := modelAliasHits, modelAliasLastHits
+	modelAliasHits, modelAliasLastHits = make(map[int]int64), make(map[int]int64)
+	modelAliasHitsLock.Unlock()
+	for id, count := range hits {
+		err := DB.Model(&ModelAlias{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"hits":          gorm.Expr("hits + ?", count),
+			"last_hit_time": lastHits[id],
+		}).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to save the hits of model alias #%d: %s", id, err.Error()))
+		}
+	}
+}
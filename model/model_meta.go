@@ -0,0 +1,153 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Modalities of the input and output of models
+const (
+	ModalityText  = "text"
+	ModalityImage = "image"
+	ModalityAudio = "audio"
+	ModalityVideo = "video"
+	ModalityFile  = "file"
+)
+
+var modalities = []string{ModalityText, ModalityImage, ModalityAudio, ModalityVideo, ModalityFile}
+
+// ModelMeta describes what a model can do, for the model catalog. The prices of the catalog
+// come from the model ratios, the metadata only describes the model.
+type ModelMeta struct {
+	Id               int    `json:"id"`
+	Name             string `json:"name" gorm:"type:varchar(128);uniqueIndex"`
+	Description      string `json:"description" gorm:"type:text"`
+	ContextWindow    int    `json:"context_window" gorm:"default:0"`     // tokens, 0 is unknown
+	MaxOutputTokens  int    `json:"max_output_tokens" gorm:"default:0"`  // tokens, 0 is unknown
+	InputModalities  string `json:"input_modalities" gorm:"default:''"`  // comma separated, empty for text
+	OutputModalities string `json:"output_modalities" gorm:"default:''"` // comma separated, empty for text
+	SupportsTools    bool   `json:"supports_tools" gorm:"default:false"`
+	SupportsJSON     bool   `json:"supports_json" gorm:"column:supports_json;default:false"` // json_object and json_schema response formats
+	Deprecated       bool   `json:"deprecated" gorm:"default:false"`
+	SunsetTime       int64  `json:"sunset_time" gorm:"bigint;default:0"` // when the model stops being served, 0 is unknown
+	Replacement      string `json:"replacement" gorm:"type:varchar(128);default:''"`
+	CreatedTime      int64  `json:"created_time" gorm:"bigint"`
+}
+
+func validateModalities(value string) error {
+	for _, modality := range strings.Split(value, ",") {
+		modality = strings.TrimSpace(modality)
+		if modality == "" {
+			continue
+		}
+		known := false
+		for _, m := range modalities {
+			if modality == m {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown modality: %s", modality)
+		}
+	}
+	return nil
+}
+
+func (m *ModelMeta) Validate() error {
+	m.Name = strings.TrimSpace(m.Name)
+	if m.Name == "" {
+		return errors.New("model name is empty")
+	}
+	if m.ContextWindow < 0 || m.MaxOutputTokens < 0 {
+		return errors.New("token limits must not be negative")
+	}
+	if err := validateModalities(m.InputModalities); err != nil {
+		return err
+	}
+	return validateModalities(m.OutputModalities)
+}
+
+// Modalities splits comma separated modalities, text when there is none
+func Modalities(value string) []string {
+	var result []string
+	for _, modality := range strings.Split(value, ",") {
+		if modality = strings.TrimSpace(modality); modality != "" {
+			result = append(result, modality)
+		}
+	}
+	if len(result) == 0 {
+		result = []string{ModalityText}
+	}
+	return result
+}
+
+func GetAllModelMetas() ([]*ModelMeta, error) {
+	var metas []*ModelMeta
+	err := DB.Order("name asc").Find(&metas).Error
+	return metas, err
+}
+
+func GetModelMetaById(id int) (*ModelMeta, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	meta := ModelMeta{Id: id}
+	err := DB.First(&meta, "id = ?", id).Error
+	return &meta, err
+}
+
+func (m *ModelMeta) Insert() error {
+	m.CreatedTime = helper.GetTimestamp()
+	return DB.Create(m).Error
+}
+
+func (m *ModelMeta) Update() error {
+	return DB.Model(m).Select("name", "description", "context_window", "max_output_tokens", "input_modalities",
+		"output_modalities", "supports_tools", "supports_json", "deprecated", "sunset_time", "replacement").Updates(m).Error
+}
+
+func (m *ModelMeta) Delete() error {
+	return DB.Delete(m).Error
+}
+
+var modelMetas map[string]*ModelMeta
+var modelMetaSyncLock sync.RWMutex
+
+// InitModelMetaCache loads the model metadata into memory, it is called on startup, after
+// every admin change and periodically
+func InitModelMetaCache() {
+	var metas []*ModelMeta
+	if err := DB.Find(&metas).Error; err != nil {
+		logger.SysError("failed to load model metadata: " + err.Error())
+		return
+	}
+	newModelMetas := make(map[string]*ModelMeta, len(metas))
+	for _, meta := range metas {
+		newModelMetas[meta.Name] = meta
+	}
+	modelMetaSyncLock.Lock()
+	modelMetas = newModelMetas
+	modelMetaSyncLock.Unlock()
+}
+
+func SyncModelMetaCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitModelMetaCache()
+	}
+}
+
+// CacheGetModelMeta returns the metadata of a model, false when the model has none
+func CacheGetModelMeta(name string) (*ModelMeta, bool) {
+	modelMetaSyncLock.RLock()
+	defer modelMetaSyncLock.RUnlock()
+	meta, ok := modelMetas[name]
+	return meta, ok
+}
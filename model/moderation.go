@@ -0,0 +1,212 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	ModerationPolicyStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	ModerationPolicyStatusDisabled = 2 // also don't use 0
+)
+
+// Moderation actions, from the weakest to the strongest
+const (
+	ModerationActionFlag   = "flag"
+	ModerationActionRedact = "redact"
+	ModerationActionBlock  = "block"
+)
+
+const moderationRedaction = "[REDACTED]"
+
+var moderationActionRanks = map[string]int{
+	ModerationActionFlag:   1,
+	ModerationActionRedact: 2,
+	ModerationActionBlock:  3,
+}
+
+// ModerationRule matches a keyword, case insensitively, or a regular expression
+type ModerationRule struct {
+	Type    string `json:"type"` // keyword or regex
+	Pattern string `json:"pattern"`
+	Action  string `json:"action"` // block, flag or redact
+}
+
+// ModerationPolicy moderates the prompts, and optionally the responses, of the requests
+// of a group. The policy of the empty group applies to the groups without their own.
+type ModerationPolicy struct {
+	Id                int    `json:"id"`
+	Group             string `json:"group" gorm:"column:group_name;type:varchar(64);index"`
+	Rules             string `json:"rules" gorm:"type:text"`                                    // JSON list of ModerationRule
+	ClassifierModel   string `json:"classifier_model" gorm:"type:varchar(255);default:''"`      // served by a channel of the /v1/moderations API
+	ClassifierAction  string `json:"classifier_action" gorm:"type:varchar(16);default:'block'"` // block or flag
+	ModerateResponses bool   `json:"moderate_responses" gorm:"default:false"`
+	Status            int    `json:"status" gorm:"default:1"`
+	CreatedTime       int64  `json:"created_time" gorm:"bigint"`
+
+	rules   []ModerationRule
+	regexps []*regexp.Regexp
+}
+
+// compile parses and compiles the rules of the policy
+func (p *ModerationPolicy) compile() error {
+	p.rules = nil
+	p.regexps = nil
+	if strings.TrimSpace(p.Rules) == "" {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(p.Rules), &p.rules); err != nil {
+		return fmt.Errorf("invalid rules: %w", err)
+	}
+	for i, rule := range p.rules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("rule %d has no pattern", i)
+		}
+		if _, ok := moderationActionRanks[rule.Action]; !ok {
+			return fmt.Errorf("rule %d has an invalid action: %s", i, rule.Action)
+		}
+		var pattern string
+		switch rule.Type {
+		case "keyword":
+			pattern = "(?i)" + regexp.QuoteMeta(rule.Pattern)
+		case "regex":
+			pattern = rule.Pattern
+		default:
+			return fmt.Errorf("rule %d has an invalid type: %s", i, rule.Type)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("rule %d has an invalid pattern: %w", i, err)
+		}
+		p.regexps = append(p.regexps, re)
+	}
+	return nil
+}
+
+func (p *ModerationPolicy) Validate() error {
+	p.Group = strings.TrimSpace(p.Group)
+	if p.ClassifierAction == "" {
+		p.ClassifierAction = ModerationActionBlock
+	}
+	if p.ClassifierAction != ModerationActionBlock && p.ClassifierAction != ModerationActionFlag {
+		return fmt.Errorf("invalid classifier action: %s", p.ClassifierAction)
+	}
+	if err := p.compile(); err != nil {
+		return err
+	}
+	if len(p.rules) == 0 && p.ClassifierModel == "" {
+		return errors.New("either rules or a classifier model is required")
+	}
+	return nil
+}
+
+// Match returns the strongest action of the rules matching the text, empty if none does
+func (p *ModerationPolicy) Match(text string) string {
+	action := ""
+	for i, re := range p.regexps {
+		if moderationActionRanks[p.rules[i].Action] > moderationActionRanks[action] && re.MatchString(text) {
+			action = p.rules[i].Action
+		}
+	}
+	return action
+}
+
+// Redact replaces the matches of the redact rules in the text
+func (p *ModerationPolicy) Redact(text string) string {
+	for i, re := range p.regexps {
+		if p.rules[i].Action == ModerationActionRedact {
+			text = re.ReplaceAllString(text, moderationRedaction)
+		}
+	}
+	return text
+}
+
+// StrongerModerationAction returns the stronger of two actions
+func StrongerModerationAction(a string, b string) string {
+	if moderationActionRanks[b] > moderationActionRanks[a] {
+		return b
+	}
+	return a
+}
+
+func GetAllModerationPolicies() ([]*ModerationPolicy, error) {
+	var policies []*ModerationPolicy
+	err := DB.Order("id desc").Find(&policies).Error
+	return policies, err
+}
+
+func GetModerationPolicyById(id int) (*ModerationPolicy, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	policy := ModerationPolicy{Id: id}
+	err := DB.First(&policy, "id = ?", id).Error
+	return &policy, err
+}
+
+func (p *ModerationPolicy) Insert() error {
+	p.CreatedTime = helper.GetTimestamp()
+	return DB.Create(p).Error
+}
+
+func (p *ModerationPolicy) Update() error {
+	return DB.Model(p).Select("group_name", "rules", "classifier_model", "classifier_action", "moderate_responses", "status").Updates(p).Error
+}
+
+func (p *ModerationPolicy) Delete() error {
+	return DB.Delete(p).Error
+}
+
+var enabledModerationPolicies map[string]*ModerationPolicy
+var moderationSyncLock sync.RWMutex
+
+// InitModerationCache loads the enabled moderation policies into memory,
+// it is called on startup, after every admin change and periodically
+func InitModerationCache() {
+	var policies []*ModerationPolicy
+	err := DB.Where("status = ?", ModerationPolicyStatusEnabled).Order("id asc").Find(&policies).Error
+	if err != nil {
+		logger.SysError("failed to load moderation policies: " + err.Error())
+		return
+	}
+	groupPolicies := make(map[string]*ModerationPolicy)
+	for _, policy := range policies {
+		if _, ok := groupPolicies[policy.Group]; ok {
+			continue
+		}
+		if err = policy.compile(); err != nil {
+			logger.SysError(fmt.Sprintf("failed to load moderation policy %d: %s", policy.Id, err.Error()))
+			continue
+		}
+		groupPolicies[policy.Group] = policy
+	}
+	moderationSyncLock.Lock()
+	enabledModerationPolicies = groupPolicies
+	moderationSyncLock.Unlock()
+}
+
+func SyncModerationCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitModerationCache()
+	}
+}
+
+// CacheGetModerationPolicy returns the policy of the group, or else the default policy,
+// nil if the requests of the group are not moderated
+func CacheGetModerationPolicy(group string) *ModerationPolicy {
+	moderationSyncLock.RLock()
+	defer moderationSyncLock.RUnlock()
+	if policy, ok := enabledModerationPolicies[group]; ok {
+		return policy
+	}
+	return enabledModerationPolicies[""]
+}
@@ -1,9 +1,14 @@
 package model
 
 import (
+	"fmt"
+	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/currency"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/network"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/postprocess"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +34,8 @@ func InitOptionMap() {
 	config.OptionMap["EmailVerificationEnabled"] = strconv.FormatBool(config.EmailVerificationEnabled)
 	config.OptionMap["GitHubOAuthEnabled"] = strconv.FormatBool(config.GitHubOAuthEnabled)
 	config.OptionMap["OidcEnabled"] = strconv.FormatBool(config.OidcEnabled)
+	config.OptionMap["OidcProvisioningEnabled"] = strconv.FormatBool(config.OidcProvisioningEnabled)
+	config.OptionMap["OidcClaimMapping"] = SSOMapping2JSONString()
 	config.OptionMap["WeChatAuthEnabled"] = strconv.FormatBool(config.WeChatAuthEnabled)
 	config.OptionMap["TurnstileCheckEnabled"] = strconv.FormatBool(config.TurnstileCheckEnabled)
 	config.OptionMap["RegisterEnabled"] = strconv.FormatBool(config.RegisterEnabled)
@@ -65,16 +72,38 @@ func InitOptionMap() {
 	config.OptionMap["QuotaForNewUser"] = strconv.FormatInt(config.QuotaForNewUser, 10)
 	config.OptionMap["QuotaForInviter"] = strconv.FormatInt(config.QuotaForInviter, 10)
 	config.OptionMap["QuotaForInvitee"] = strconv.FormatInt(config.QuotaForInvitee, 10)
+	config.OptionMap["PromoQuotaValidDays"] = strconv.Itoa(config.PromoQuotaValidDays)
+	config.OptionMap["PromoSignupIPDailyLimit"] = strconv.Itoa(config.PromoSignupIPDailyLimit)
+	config.OptionMap["FreeAllowances"] = FreeAllowances2JSONString()
 	config.OptionMap["QuotaRemindThreshold"] = strconv.FormatInt(config.QuotaRemindThreshold, 10)
+	config.OptionMap["WebhookSpendThreshold"] = strconv.FormatInt(config.WebhookSpendThreshold, 10)
 	config.OptionMap["PreConsumedQuota"] = strconv.FormatInt(config.PreConsumedQuota, 10)
 	config.OptionMap["ModelRatio"] = billingratio.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
+	config.OptionMap["GroupPreConsumeStrategy"] = billingratio.GroupPreConsumeStrategy2JSONString()
+	config.OptionMap["GroupPostProcessing"] = postprocess.GroupPostProcessing2JSONString()
 	config.OptionMap["CompletionRatio"] = billingratio.CompletionRatio2JSONString()
+	config.OptionMap["ReasoningRatio"] = billingratio.ReasoningRatio2JSONString()
+	config.OptionMap["CacheRatio"] = billingratio.CacheRatio2JSONString()
+	config.OptionMap["UpstreamModelRatio"] = billingratio.UpstreamModelRatio2JSONString()
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
+	config.OptionMap["Currency"] = config.Currency
+	config.OptionMap["ExchangeRates"] = currency.ExchangeRates2JSONString()
+	config.OptionMap["GroupCurrency"] = currency.GroupCurrency2JSONString()
 	config.OptionMap["RetryTimes"] = strconv.Itoa(config.RetryTimes)
 	config.OptionMap["Theme"] = config.Theme
+	config.OptionMap["RateLimitIPPolicy"] = network.IPPolicy2JSONString()
+	config.OptionMap["ConnectionPoolConfig"] = client.PoolConfig2JSONString()
+	config.OptionMap["ResponseCacheEnabled"] = strconv.FormatBool(config.ResponseCacheEnabled)
+	config.OptionMap["ResponseCacheTTL"] = strconv.Itoa(config.ResponseCacheTTL)
+	config.OptionMap["SemanticCacheEnabled"] = strconv.FormatBool(config.SemanticCacheEnabled)
+	config.OptionMap["SemanticCacheThreshold"] = strconv.FormatFloat(config.SemanticCacheThreshold, 'f', -1, 64)
+	config.OptionMap["RequestCoalescingEnabled"] = strconv.FormatBool(config.RequestCoalescingEnabled)
+	config.OptionMap["RequestTraceEnabled"] = strconv.FormatBool(config.RequestTraceEnabled)
+	config.OptionMap["AutoModelEnabled"] = strconv.FormatBool(config.AutoModelEnabled)
+	config.OptionMap["SelectionStrategy"] = config.SelectionStrategy
 	config.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
 }
@@ -113,12 +142,25 @@ func UpdateOption(key string, value string) error {
 	// otherwise it will execute Update (with all fields).
 	DB.Save(&option)
 	// Update OptionMap
-	return updateOptionMap(key, value)
+	if err := updateOptionMap(key, value); err != nil {
+		return err
+	}
+	PublishInvalidation(InvalidationOption, key)
+	return nil
 }
 
-func updateOptionMap(key string, value string) (err error) {
+func updateOptionMap(key string, value string) error {
 	config.OptionMapRWMutex.Lock()
-	defer config.OptionMapRWMutex.Unlock()
+	changed := config.OptionMap[key] != value
+	err := setOption(key, value)
+	config.OptionMapRWMutex.Unlock()
+	if err == nil && changed {
+		notifySettingChange(key, value)
+	}
+	return err
+}
+
+func setOption(key string, value string) (err error) {
 	config.OptionMap[key] = value
 	if strings.HasSuffix(key, "Enabled") {
 		boolValue := value == "true"
@@ -133,6 +175,8 @@ func updateOptionMap(key string, value string) (err error) {
 			config.GitHubOAuthEnabled = boolValue
 		case "OidcEnabled":
 			config.OidcEnabled = boolValue
+		case "OidcProvisioningEnabled":
+			config.OidcProvisioningEnabled = boolValue
 		case "WeChatAuthEnabled":
 			config.WeChatAuthEnabled = boolValue
 		case "TurnstileCheckEnabled":
@@ -153,6 +197,16 @@ func updateOptionMap(key string, value string) (err error) {
 			config.DisplayInCurrencyEnabled = boolValue
 		case "DisplayTokenStatEnabled":
 			config.DisplayTokenStatEnabled = boolValue
+		case "ResponseCacheEnabled":
+			config.ResponseCacheEnabled = boolValue
+		case "SemanticCacheEnabled":
+			config.SemanticCacheEnabled = boolValue
+		case "RequestCoalescingEnabled":
+			config.RequestCoalescingEnabled = boolValue
+		case "RequestTraceEnabled":
+			config.RequestTraceEnabled = boolValue
+		case "AutoModelEnabled":
+			config.AutoModelEnabled = boolValue
 		}
 	}
 	switch key {
@@ -191,6 +245,8 @@ func updateOptionMap(key string, value string) (err error) {
 		config.OidcTokenEndpoint = value
 	case "OidcUserinfoEndpoint":
 		config.OidcUserinfoEndpoint = value
+	case "OidcClaimMapping":
+		err = UpdateSSOMappingByJSONString(value)
 	case "Footer":
 		config.Footer = value
 	case "SystemName":
@@ -217,8 +273,16 @@ func updateOptionMap(key string, value string) (err error) {
 		config.QuotaForInviter, _ = strconv.ParseInt(value, 10, 64)
 	case "QuotaForInvitee":
 		config.QuotaForInvitee, _ = strconv.ParseInt(value, 10, 64)
+	case "PromoQuotaValidDays":
+		config.PromoQuotaValidDays, _ = strconv.Atoi(value)
+	case "PromoSignupIPDailyLimit":
+		config.PromoSignupIPDailyLimit, _ = strconv.Atoi(value)
+	case "FreeAllowances":
+		err = UpdateFreeAllowancesByJSONString(value)
 	case "QuotaRemindThreshold":
 		config.QuotaRemindThreshold, _ = strconv.ParseInt(value, 10, 64)
+	case "WebhookSpendThreshold":
+		config.WebhookSpendThreshold, _ = strconv.ParseInt(value, 10, 64)
 	case "PreConsumedQuota":
 		config.PreConsumedQuota, _ = strconv.ParseInt(value, 10, 64)
 	case "RetryTimes":
@@ -227,8 +291,18 @@ func updateOptionMap(key string, value string) (err error) {
 		err = billingratio.UpdateModelRatioByJSONString(value)
 	case "GroupRatio":
 		err = billingratio.UpdateGroupRatioByJSONString(value)
+	case "GroupPreConsumeStrategy":
+		err = billingratio.UpdateGroupPreConsumeStrategyByJSONString(value)
+	case "GroupPostProcessing":
+		err = postprocess.UpdateGroupPostProcessingByJSONString(value)
 	case "CompletionRatio":
 		err = billingratio.UpdateCompletionRatioByJSONString(value)
+	case "ReasoningRatio":
+		err = billingratio.UpdateReasoningRatioByJSONString(value)
+	case "CacheRatio":
+		err = billingratio.UpdateCacheRatioByJSONString(value)
+	case "UpstreamModelRatio":
+		err = billingratio.UpdateUpstreamModelRatioByJSONString(value)
 	case "TopUpLink":
 		config.TopUpLink = value
 	case "ChatLink":
@@ -237,8 +311,28 @@ func updateOptionMap(key string, value string) (err error) {
 		config.ChannelDisableThreshold, _ = strconv.ParseFloat(value, 64)
 	case "QuotaPerUnit":
 		config.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
+	case "Currency":
+		if currency.IsCode(value) {
+			config.Currency = value
+		} else {
+			err = fmt.Errorf("invalid currency code %s", value)
+		}
+	case "ExchangeRates":
+		err = currency.UpdateExchangeRatesByJSONString(value)
+	case "GroupCurrency":
+		err = currency.UpdateGroupCurrencyByJSONString(value)
 	case "Theme":
 		config.Theme = value
+	case "RateLimitIPPolicy":
+		err = network.UpdateIPPolicyByJSONString(value)
+	case "ConnectionPoolConfig":
+		err = client.UpdatePoolConfigByJSONString(value)
+	case "ResponseCacheTTL":
+		config.ResponseCacheTTL, _ = strconv.Atoi(value)
+	case "SemanticCacheThreshold":
+		config.SemanticCacheThreshold, _ = strconv.ParseFloat(value, 64)
+	case "SelectionStrategy":
+		config.SelectionStrategy = value
 	}
 	return err
 }
@@ -0,0 +1,311 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// An organization groups users sharing a quota. The tokens issued by the organization
+// are owned by a member but consume the quota of the organization instead of the
+// quota of the member, the personal tokens of the members are not affected.
+
+const (
+	OrganizationStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	OrganizationStatusDisabled = 2 // also don't use 0
+)
+
+// Roles of the members of an organization
+const (
+	OrganizationRoleOwner  = "owner"
+	OrganizationRoleAdmin  = "admin"
+	OrganizationRoleMember = "member"
+)
+
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);index"`
+	Quota       int64  `json:"quota" gorm:"bigint;default:0"`
+	UsedQuota   int64  `json:"used_quota" gorm:"bigint;default:0"` // quota consumed by the tokens of the organization
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	SSOEnforced bool   `json:"sso_enforced" gorm:"column:sso_enforced;default:false"` // members sign in with OIDC only
+}
+
+// OrganizationMember is the membership of a user, a user belongs to one organization at most
+type OrganizationMember struct {
+	Id             int    `json:"id"`
+	OrganizationId int    `json:"organization_id" gorm:"index"`
+	UserId         int    `json:"user_id" gorm:"uniqueIndex"`
+	Username       string `json:"username" gorm:"-"`
+	Role           string `json:"role" gorm:"type:varchar(16)"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+func IsOrganizationRole(role string) bool {
+	return role == OrganizationRoleOwner || role == OrganizationRoleAdmin || role == OrganizationRoleMember
+}
+
+// CanManage tells whether the member manages the members and tokens of the organization
+func (m *OrganizationMember) CanManage() bool {
+	return m.Role == OrganizationRoleOwner || m.Role == OrganizationRoleAdmin
+}
+
+func (o *Organization) Validate() error {
+	o.Name = strings.TrimSpace(o.Name)
+	if o.Name == "" {
+		return errors.New("organization name is empty")
+	}
+	if len(o.Name) > 64 {
+		return errors.New("organization name is too long")
+	}
+	return nil
+}
+
+func GetAllOrganizations(startIdx int, num int) ([]*Organization, error) {
+	var organizations []*Organization
+	err := DB.Order("id desc").Limit(num).Offset(startIdx).Find(&organizations).Error
+	return organizations, err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	organization := Organization{Id: id}
+	err := DB.First(&organization, "id = ?", id).Error
+	return &organization, err
+}
+
+// Insert creates the organization with its owner
+func (o *Organization) Insert(ownerId int) error {
+	o.CreatedTime = helper.GetTimestamp()
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(o).Error; err != nil {
+			return err
+		}
+		return addOrganizationMember(tx, o.Id, ownerId, OrganizationRoleOwner)
+	})
+}
+
+func (o *Organization) Update() error {
+	return DB.Model(o).Select("name", "quota", "status", "sso_enforced").Updates(o).Error
+}
+
+// Delete removes the organization, its members and the tokens it issued
+func (o *Organization) Delete() error {
+	var keys []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Token{}).Where("organization_id = ?", o.Id).Pluck("key", &keys).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", o.Id).Delete(&Token{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("organization_id = ?", o.Id).Delete(&OrganizationMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(o).Error
+	})
+	if err == nil {
+		for _, key := range keys {
+			PublishInvalidation(InvalidationToken, key)
+		}
+		PublishInvalidation(InvalidationRBAC, "")
+	}
+	return err
+}
+
+// GetUserOrganizationMember returns the membership of a user, gorm.ErrRecordNotFound when
+// the user is in no organization
+func GetUserOrganizationMember(userId int) (*OrganizationMember, error) {
+	member := OrganizationMember{}
+	err := DB.First(&member, "user_id = ?", userId).Error
+	return &member, err
+}
+
+func GetOrganizationMembers(organizationId int) ([]*OrganizationMember, error) {
+	var members []*OrganizationMember
+	err := DB.Where("organization_id = ?", organizationId).Order("id asc").Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		member.Username = GetUsernameById(member.UserId)
+	}
+	return members, nil
+}
+
+func getOrganizationMemberIds(organizationId int) ([]int, error) {
+	var userIds []int
+	err := DB.Model(&OrganizationMember{}).Where("organization_id = ?", organizationId).Pluck("user_id", &userIds).Error
+	return userIds, err
+}
+
+func addOrganizationMember(tx *gorm.DB, organizationId int, userId int, role string) error {
+	var count int64
+	if err := tx.Model(&OrganizationMember{}).Where("user_id = ?", userId).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("user #%d is already in an organization", userId)
+	}
+	if err := tx.First(&User{}, "id = ?", userId).Error; err != nil {
+		return fmt.Errorf("user #%d does not exist", userId)
+	}
+	return tx.Create(&OrganizationMember{
+		OrganizationId: organizationId,
+		UserId:         userId,
+		Role:           role,
+		CreatedTime:    helper.GetTimestamp(),
+	}).Error
+}
+
+// AddOrganizationMember adds a member, who is granted the roles bound to the organization
+func AddOrganizationMember(organizationId int, userId int, role string) error {
+	err := addOrganizationMember(DB, organizationId, userId, role)
+	if err == nil {
+		PublishInvalidation(InvalidationRBAC, "")
+	}
+	return err
+}
+
+func UpdateOrganizationMemberRole(organizationId int, userId int, role string) error {
+	result := DB.Model(&OrganizationMember{}).Where("organization_id = ? AND user_id = ?", organizationId, userId).Update("role", role)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return fmt.Errorf("user #%d is not in the organization", userId)
+	}
+	return result.Error
+}
+
+// RemoveOrganizationMember removes a member, and disables the tokens the organization
+// issued to them
+func RemoveOrganizationMember(organizationId int, userId int) error {
+	var keys []string
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("organization_id = ? AND user_id = ?", organizationId, userId).Delete(&OrganizationMember{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("user #%d is not in the organization", userId)
+		}
+		if err := tx.Model(&Token{}).Where("organization_id = ? AND user_id = ?", organizationId, userId).Pluck("key", &keys).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Token{}).Where("organization_id = ? AND user_id = ? AND status <> ?", organizationId, userId, TokenStatusArchived).
+			Update("status", TokenStatusDisabled).Error
+	})
+	if err == nil {
+		for _, key := range keys {
+			PublishInvalidation(InvalidationToken, key)
+		}
+		PublishInvalidation(InvalidationRBAC, "")
+	}
+	return err
+}
+
+// GetOrganizationTokens returns the tokens issued by an organization
+func GetOrganizationTokens(organizationId int) ([]*Token, error) {
+	var tokens []*Token
+	err := DB.Where("organization_id = ? AND status <> ?", organizationId, TokenStatusArchived).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// DeleteOrganizationToken revokes a token issued by an organization
+func DeleteOrganizationToken(organizationId int, id int) error {
+	token := Token{}
+	if err := DB.First(&token, "id = ? AND organization_id = ? AND status <> ?", id, organizationId, TokenStatusArchived).Error; err != nil {
+		return err
+	}
+	return token.Delete()
+}
+
+// ValidateOrganizationToken checks that the member owning an organization token is still
+// in the enabled organization
+func ValidateOrganizationToken(token *Token) error {
+	member, err := GetUserOrganizationMember(token.UserId)
+	if err != nil || member.OrganizationId != token.OrganizationId {
+		return errors.New("该令牌所属组织已移除此用户")
+	}
+	organization, err := GetOrganizationById(token.OrganizationId)
+	if err != nil {
+		return err
+	}
+	if organization.Status != OrganizationStatusEnabled {
+		return errors.New("该令牌所属组织已被禁用")
+	}
+	return nil
+}
+
+func GetOrganizationQuota(id int) (quota int64, err error) {
+	err = DB.Model(&Organization{}).Where("id = ?", id).Select("quota").Find(&quota).Error
+	return quota, err
+}
+
+// DecreaseOrganizationQuota consumes quota of an organization, a negative quota gives
+// back quota consumed in advance
+func DecreaseOrganizationQuota(id int, quota int64) error {
+	return DB.Model(&Organization{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"quota":      gorm.Expr("quota - ?", quota),
+		"used_quota": gorm.Expr("used_quota + ?", quota),
+	}).Error
+}
+
+// preConsumeOrganizationQuota is PreConsumeTokenQuota for the tokens issued by an organization
+func preConsumeOrganizationQuota(token *Token, quota int64) error {
+	organizationQuota, err := GetOrganizationQuota(token.OrganizationId)
+	if err != nil {
+		return err
+	}
+	if organizationQuota < quota {
+		return errors.New("组织额度不足")
+	}
+	if organizationQuota-quota <= 0 {
+		EmitWebhookEvent(WebhookEventQuotaExhausted, map[string]any{
+			"user_id":         token.UserId,
+			"organization_id": token.OrganizationId,
+			"token_id":        token.Id,
+			"quota":           organizationQuota - quota,
+		})
+	}
+	if !token.UnlimitedQuota {
+		if err = DecreaseTokenQuota(token.Id, quota); err != nil {
+			return err
+		}
+	}
+	return DecreaseOrganizationQuota(token.OrganizationId, quota)
+}
+
+// CacheGetAccountQuota returns the quota a request draws on: the quota of the organization
+// for the tokens it issued, else the quota of the user
+func CacheGetAccountQuota(ctx context.Context, userId int, organizationId int) (int64, error) {
+	if organizationId != 0 {
+		return GetOrganizationQuota(organizationId)
+	}
+	return CacheGetUserQuota(ctx, userId)
+}
+
+// CacheDecreaseAccountQuota is CacheDecreaseUserQuota for the quota of CacheGetAccountQuota,
+// the quota of organizations is not cached
+func CacheDecreaseAccountQuota(userId int, organizationId int, quota int64) error {
+	if organizationId != 0 {
+		return nil
+	}
+	return CacheDecreaseUserQuota(userId, quota)
+}
+
+// GetOrganizationUsage sums the usage rollups of the members of an organization
+func GetOrganizationUsage(organizationId int, filter UsageRollupFilter, groupBy []string) ([]*UsageSummary, error) {
+	userIds, err := getOrganizationMemberIds(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	filter.UserIds = userIds
+	return GetUsageSummaries(filter, groupBy)
+}
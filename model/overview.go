@@ -0,0 +1,235 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/circuitbreaker"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+const (
+	overviewWindow    = 5 * 60 // seconds of logs the rates and latency are computed on
+	overviewCacheTTL  = 10 * time.Second
+	overviewTopModels = 5
+	// the channels failing more often, on enough requests, are unhealthy
+	unhealthySuccessRate = 0.8
+	unhealthyMinRequests = 10
+)
+
+// OverviewModel is a model of the top models of the day
+type OverviewModel struct {
+	ModelName string  `json:"model_name"`
+	Requests  int64   `json:"requests"`
+	Quota     int64   `json:"quota"`
+	Cost      float64 `json:"cost" gorm:"-"`
+}
+
+// OverviewChannel is an unhealthy channel, disabled by the gateway, throttled by its
+// provider or failing
+type OverviewChannel struct {
+	Id          int     `json:"id"`
+	Name        string  `json:"name"`
+	Status      int     `json:"status"`
+	Reason      string  `json:"reason"` // auto_disabled, throttled or failing
+	SuccessRate float64 `json:"success_rate"`
+}
+
+// OverviewBreaker is a circuit breaker which is not closed
+type OverviewBreaker struct {
+	Name                string `json:"name"`
+	State               string `json:"state"`
+	ConsecutiveFailures uint32 `json:"consecutive_failures"`
+}
+
+// Overview is the summary of the activity of the gateway shown by the dashboard
+type Overview struct {
+	Window            int64              `json:"window"` // unit is second
+	Requests          int64              `json:"requests"`
+	RPS               float64            `json:"rps"`
+	ErrorRate         float64            `json:"error_rate"`
+	P95Latency        int64              `json:"p95_latency"` // unit is ms
+	CacheHitRate      float64            `json:"cache_hit_rate"`
+	QuotaToday        int64              `json:"quota_today"`
+	CostToday         float64            `json:"cost_today"`
+	Currency          string             `json:"currency"`
+	TopModels         []*OverviewModel   `json:"top_models"`
+	UnhealthyChannels []*OverviewChannel `json:"unhealthy_channels"`
+	OpenBreakers      []OverviewBreaker  `json:"open_breakers"` // of the installation only
+	UpdatedAt         int64              `json:"updated_at"`
+}
+
+type cachedOverview struct {
+	overview  *Overview
+	expiresAt time.Time
+}
+
+var (
+	overviews     = make(map[int]*cachedOverview)
+	overviewsLock sync.Mutex
+)
+
+// GetOverview returns the overview of a tenant, computed at most every 10 seconds
+func GetOverview(tenantId int) (*Overview, error) {
+	overviewsLock.Lock()
+	defer overviewsLock.Unlock()
+	if cached, ok := overviews[tenantId]; ok && time.Now().Before(cached.expiresAt) {
+		return cached.overview, nil
+	}
+	overview, err := computeOverview(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	overviews[tenantId] = &cachedOverview{overview: overview, expiresAt: time.Now().Add(overviewCacheTTL)}
+	return overview, nil
+}
+
+func computeOverview(tenantId int) (*Overview, error) {
+	now := helper.GetTimestamp()
+	overview := &Overview{
+		Window:    overviewWindow,
+		Currency:  currency.Default(),
+		UpdatedAt: now,
+	}
+	if err := overviewRequests(tenantId, now-overviewWindow, overview); err != nil {
+		return nil, err
+	}
+	if err := overviewToday(tenantId, now-now%86400, overview); err != nil {
+		return nil, err
+	}
+	channels, err := unhealthyChannels(tenantId)
+	if err != nil {
+		return nil, err
+	}
+	overview.UnhealthyChannels = channels
+	overview.OpenBreakers = make([]OverviewBreaker, 0)
+	if tenantId == AllTenants {
+		overview.OpenBreakers = openBreakers()
+	}
+	return overview, nil
+}
+
+// overviewRequests computes the rates and latency of the relay requests logged since start
+func overviewRequests(tenantId int, start int64, overview *Overview) error {
+	var counts struct {
+		Requests  int64
+		Errors    int64
+		CacheHits int64
+	}
+	err := LOG_DB.Table("logs").Scopes(tenantScope(tenantId)).
+		Select(`count(*) as requests, COALESCE(sum(case when type = ? then 1 else 0 end), 0) as errors,
+			COALESCE(sum(case when cache_hit <> '' then 1 else 0 end), 0) as cache_hits`, LogTypeError).
+		Where("created_at >= ? and type in ?", start, []int{LogTypeConsume, LogTypeError}).
+		Scan(&counts).Error
+	if err != nil {
+		return err
+	}
+	overview.Requests = counts.Requests
+	overview.RPS = float64(counts.Requests) / overviewWindow
+	if counts.Requests > 0 {
+		overview.ErrorRate = float64(counts.Errors) / float64(counts.Requests)
+	}
+	succeeded := counts.Requests - counts.Errors
+	if succeeded <= 0 {
+		return nil
+	}
+	overview.CacheHitRate = float64(counts.CacheHits) / float64(succeeded)
+	// the latency the slowest 5% of the successful requests are above
+	var latencies []int64
+	err = LOG_DB.Table("logs").Scopes(tenantScope(tenantId)).
+		Where("created_at >= ? and type = ?", start, LogTypeConsume).
+		Order("elapsed_time desc").Offset(int(succeeded*5/100)).Limit(1).
+		Pluck("elapsed_time", &latencies).Error
+	if err != nil {
+		return err
+	}
+	if len(latencies) > 0 {
+		overview.P95Latency = latencies[0]
+	}
+	return nil
+}
+
+// overviewToday sums the quota of the day and finds its most requested models
+func overviewToday(tenantId int, start int64, overview *Overview) error {
+	var models []*OverviewModel
+	err := consumeLogs(tenantId, "model_name, count(*) as requests, COALESCE(sum(quota), 0) as quota", start, 0, "", "", "", 0).
+		Group("model_name").Scan(&models).Error
+	if err != nil {
+		return err
+	}
+	for _, model := range models {
+		overview.QuotaToday += model.Quota
+		model.Cost = currency.FromQuota(model.Quota, overview.Currency)
+	}
+	overview.CostToday = currency.FromQuota(overview.QuotaToday, overview.Currency)
+	sort.Slice(models, func(i, j int) bool {
+		if models[i].Requests != models[j].Requests {
+			return models[i].Requests > models[j].Requests
+		}
+		return models[i].ModelName < models[j].ModelName
+	})
+	if len(models) > overviewTopModels {
+		models = models[:overviewTopModels]
+	}
+	overview.TopModels = models
+	return nil
+}
+
+// unhealthyChannels returns the channels disabled by the gateway, and the enabled ones which
+// are throttled or failing on this node
+func unhealthyChannels(tenantId int) ([]*OverviewChannel, error) {
+	var channels []*Channel
+	err := DB.Scopes(tenantScope(tenantId)).Select("id", "name", "status").
+		Where("status in ?", []int{ChannelStatusEnabled, ChannelStatusAutoDisabled}).Order("id").Find(&channels).Error
+	if err != nil {
+		return nil, err
+	}
+	tracker := GetHealthTracker()
+	unhealthy := make([]*OverviewChannel, 0)
+	for _, channel := range channels {
+		overviewChannel := &OverviewChannel{
+			Id:          channel.Id,
+			Name:        channel.Name,
+			Status:      channel.Status,
+			SuccessRate: 1,
+		}
+		var requests int64
+		if health := tracker.GetHealth(channel.Id); health != nil {
+			overviewChannel.SuccessRate = health.SuccessRate()
+			health.mu.RLock()
+			requests = health.TotalRequests
+			health.mu.RUnlock()
+		}
+		switch {
+		case channel.Status == ChannelStatusAutoDisabled:
+			overviewChannel.Reason = "auto_disabled"
+		case tracker.IsThrottled(channel.Id):
+			overviewChannel.Reason = "throttled"
+		case requests >= unhealthyMinRequests && overviewChannel.SuccessRate < unhealthySuccessRate:
+			overviewChannel.Reason = "failing"
+		default:
+			continue
+		}
+		unhealthy = append(unhealthy, overviewChannel)
+	}
+	return unhealthy, nil
+}
+
+func openBreakers() []OverviewBreaker {
+	breakers := make([]OverviewBreaker, 0)
+	for name, breaker := range circuitbreaker.GetChannelBreakerManager().GetAll() {
+		state := breaker.State()
+		if state == circuitbreaker.StateClosed {
+			continue
+		}
+		breakers = append(breakers, OverviewBreaker{
+			Name:                name,
+			State:               state.String(),
+			ConsecutiveFailures: breaker.Counts().ConsecutiveFailures,
+		})
+	}
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].Name < breakers[j].Name })
+	return breakers
+}
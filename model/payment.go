@@ -0,0 +1,148 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	PaymentStatusPending = 1 // don't use 0, 0 is the default value!
+	PaymentStatusPaid    = 2
+	PaymentStatusFailed  = 3
+)
+
+// Payment is a top-up of the quota of a user, paid with a payment provider. It is
+// credited once, when the provider tells it was paid.
+type Payment struct {
+	Id          int     `json:"id"`
+	UserId      int     `json:"user_id" gorm:"index"`
+	TradeNo     string  `json:"trade_no" gorm:"type:varchar(64);uniqueIndex"`
+	Provider    string  `json:"provider" gorm:"type:varchar(32)"`
+	ProviderRef string  `json:"provider_ref" gorm:"type:varchar(255);default:''"` // id of the payment in the provider
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency" gorm:"type:varchar(3)"`
+	Quota       int64   `json:"quota" gorm:"bigint"`
+	Status      int     `json:"status" gorm:"default:1"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+	PaidTime    int64   `json:"paid_time" gorm:"bigint"`
+}
+
+// NewPayment returns a pending payment of an amount, the quota is the amount converted
+// with the exchange rate of its currency
+func NewPayment(userId int, provider string, amount float64, code string) *Payment {
+	return &Payment{
+		UserId:   userId,
+		TradeNo:  "pay_" + random.GetUUID(),
+		Provider: provider,
+		Amount:   amount,
+		Currency: code,
+		Quota:    currency.ToQuota(amount, code),
+		Status:   PaymentStatusPending,
+	}
+}
+
+func (p *Payment) Insert() error {
+	p.CreatedTime = helper.GetTimestamp()
+	return DB.Create(p).Error
+}
+
+func (p *Payment) UpdateProviderRef(ref string) error {
+	p.ProviderRef = ref
+	return DB.Model(p).Update("provider_ref", ref).Error
+}
+
+func GetPaymentByTradeNo(tradeNo string) (*Payment, error) {
+	if tradeNo == "" {
+		return nil, errors.New("trade_no 为空！")
+	}
+	payment := Payment{}
+	err := DB.First(&payment, "trade_no = ?", tradeNo).Error
+	return &payment, err
+}
+
+// GetPayments returns the payments of a user, or of every user when userId is 0, newest first
+func GetPayments(userId int, startIdx int, num int) ([]*Payment, error) {
+	var payments []*Payment
+	tx := DB.Order("id desc")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err := tx.Limit(num).Offset(startIdx).Find(&payments).Error
+	return payments, err
+}
+
+// SettlePayment records the outcome of a payment told by its provider: a paid payment
+// credits its quota to the user, in the same transaction. A payment already settled is
+// left as it is, so a callback sent again credits nothing. The amount paid, when the
+// provider tells it, must be the amount of the payment. The status only moves from pending
+// and the quota is credited by the delivery which moved it, so callbacks delivered at the
+// same time credit it once.
+func SettlePayment(ctx context.Context, tradeNo string, ref string, paid bool, amount float64, code string) (*Payment, error) {
+	payment := &Payment{}
+	credited := false
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("trade_no = ?", tradeNo).First(payment).Error
+		if err != nil {
+			return fmt.Errorf("payment %s does not exist", tradeNo)
+		}
+		if payment.Status != PaymentStatusPending {
+			return nil
+		}
+		if ref != "" {
+			payment.ProviderRef = ref
+		}
+		columns := []string{"status", "provider_ref"}
+		if paid {
+			if amount != 0 && (code != payment.Currency || math.Abs(amount-payment.Amount) > 0.005) {
+				return fmt.Errorf("payment %s: %.2f %s was paid instead of %.2f %s", tradeNo, amount, code, payment.Amount, payment.Currency)
+			}
+			payment.Status = PaymentStatusPaid
+			payment.PaidTime = helper.GetTimestamp()
+			columns = append(columns, "paid_time")
+		} else {
+			payment.Status = PaymentStatusFailed
+		}
+		result := tx.Model(payment).Where("status = ?", PaymentStatusPending).Select(columns).Updates(payment)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			// another delivery of the callback settled it first
+			return tx.First(payment, payment.Id).Error
+		}
+		if !paid {
+			return nil
+		}
+		credited = true
+		return tx.Model(&User{}).Where("id = ?", payment.UserId).Update("quota", gorm.Expr("quota + ?", payment.Quota)).Error
+	})
+	if err != nil || !credited {
+		return payment, err
+	}
+	if err = CacheUpdateUserQuota(ctx, payment.UserId); err != nil {
+		logger.Error(ctx, "error update user quota cache: "+err.Error())
+	}
+	RecordLog(ctx, payment.UserId, LogTypeTopup, fmt.Sprintf("通过 %s 支付 %s %s 充值 %s", payment.Provider,
+		strconv.FormatFloat(payment.Amount, 'f', -1, 64), payment.Currency, common.LogQuota(payment.Quota)))
+	EmitWebhookEvent(WebhookEventPaymentSucceeded, map[string]any{
+		"user_id":  payment.UserId,
+		"trade_no": payment.TradeNo,
+		"provider": payment.Provider,
+		"amount":   payment.Amount,
+		"currency": payment.Currency,
+		"quota":    payment.Quota,
+	})
+	return payment, nil
+}
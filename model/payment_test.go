@@ -0,0 +1,49 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+// initTestDB opens a SQLite database with the tables of tables only, migrating them all is slow
+func initTestDB(t *testing.T, tables ...any) {
+	common.RedisEnabled = false
+	config.MemoryCacheEnabled = false
+	common.SQLitePath = t.TempDir() + "/one-api.db"
+	var err error
+	DB, err = chooseDB("SQL_DSN")
+	require.NoError(t, err)
+	require.NoError(t, DB.AutoMigrate(tables...))
+	LOG_DB = DB
+}
+
+func TestSettlePaymentTwice(t *testing.T) {
+	initTestDB(t, &User{}, &Payment{}, &Log{})
+	user := &User{Username: "payer", Password: "password", Quota: 100}
+	require.NoError(t, DB.Create(user).Error)
+	payment := NewPayment(user.Id, "stripe", 10, "USD")
+	require.NoError(t, payment.Insert())
+
+	ctx := context.Background()
+	settled, err := SettlePayment(ctx, payment.TradeNo, "pi_1", true, 10, "USD")
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusPaid, settled.Status)
+	settled, err = SettlePayment(ctx, payment.TradeNo, "pi_1", true, 10, "USD")
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusPaid, settled.Status)
+
+	quota, err := GetUserQuota(user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 100+payment.Quota, quota)
+
+	// a failure told after the payment was paid leaves it paid
+	settled, err = SettlePayment(ctx, payment.TradeNo, "", false, 0, "")
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusPaid, settled.Status)
+}
@@ -0,0 +1,149 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/plugin"
+)
+
+const (
+	PluginStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PluginStatusDisabled = 2 // also don't use 0
+)
+
+// Extension points of the relay a plugin is run at
+const (
+	PluginHookPreRequest    = "pre_request"    // gets the request, may change it or reject it
+	PluginHookPostResponse  = "post_response"  // gets the response, not streamed, and may change it
+	PluginHookSelectChannel = "select_channel" // gets the channels serving the model, may return the id of one
+)
+
+// Plugin is a Lua script run by the relay at one of its extension points for the requests
+// of its groups, in the order of their ids
+type Plugin struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Hook        string `json:"hook" gorm:"type:varchar(32);index"`
+	Groups      string `json:"groups" gorm:"type:varchar(255);default:''"` // comma separated user groups, empty for all of them
+	Script      string `json:"script" gorm:"type:text"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+
+	proto *lua.FunctionProto
+}
+
+func (p *Plugin) compile() error {
+	proto, err := plugin.Compile(p.Name, p.Script)
+	if err != nil {
+		return fmt.Errorf("invalid script: %w", err)
+	}
+	p.proto = proto
+	return nil
+}
+
+func (p *Plugin) Validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("plugin name is empty")
+	}
+	switch p.Hook {
+	case PluginHookPreRequest, PluginHookPostResponse, PluginHookSelectChannel:
+	default:
+		return fmt.Errorf("unknown hook: %s", p.Hook)
+	}
+	return p.compile()
+}
+
+// Proto returns the compiled script of a plugin of the cache
+func (p *Plugin) Proto() *lua.FunctionProto {
+	return p.proto
+}
+
+func (p *Plugin) appliesTo(group string) bool {
+	return p.Groups == "" || isInCommaList(group, p.Groups)
+}
+
+func GetAllPlugins() ([]*Plugin, error) {
+	var plugins []*Plugin
+	err := DB.Order("id asc").Find(&plugins).Error
+	return plugins, err
+}
+
+func GetPluginById(id int) (*Plugin, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	p := Plugin{Id: id}
+	err := DB.First(&p, "id = ?", id).Error
+	return &p, err
+}
+
+func (p *Plugin) Insert() error {
+	p.CreatedTime = helper.GetTimestamp()
+	p.UpdatedTime = p.CreatedTime
+	return DB.Create(p).Error
+}
+
+func (p *Plugin) Update() error {
+	p.UpdatedTime = helper.GetTimestamp()
+	return DB.Model(p).Select("name", "description", "hook", "groups", "script", "status", "updated_time").Updates(p).Error
+}
+
+func (p *Plugin) Delete() error {
+	return DB.Delete(p).Error
+}
+
+var hookPlugins map[string][]*Plugin
+var pluginSyncLock sync.RWMutex
+
+// InitPluginCache compiles the enabled plugins, it is called on startup, after every admin
+// change and periodically
+func InitPluginCache() {
+	var plugins []*Plugin
+	err := DB.Where("status = ?", PluginStatusEnabled).Order("id asc").Find(&plugins).Error
+	if err != nil {
+		logger.SysError("failed to load plugins: " + err.Error())
+		return
+	}
+	newHookPlugins := make(map[string][]*Plugin)
+	for _, p := range plugins {
+		if err := p.compile(); err != nil {
+			logger.SysError(fmt.Sprintf("skipping plugin %s: %s", p.Name, err.Error()))
+			continue
+		}
+		newHookPlugins[p.Hook] = append(newHookPlugins[p.Hook], p)
+	}
+	pluginSyncLock.Lock()
+	hookPlugins = newHookPlugins
+	pluginSyncLock.Unlock()
+}
+
+func SyncPluginCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitPluginCache()
+	}
+}
+
+// CacheGetPlugins returns the plugins run at a hook for a group, in order
+func CacheGetPlugins(hook string, group string) []*Plugin {
+	pluginSyncLock.RLock()
+	defer pluginSyncLock.RUnlock()
+	var plugins []*Plugin
+	for _, p := range hookPlugins[hook] {
+		if p.appliesTo(group) {
+			plugins = append(plugins, p)
+		}
+	}
+	return plugins
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// The pre-consume journal keeps in Redis the quota pre-consumed by each request until the
+// request is billed, so the quota pre-consumed by the requests of a crashed process is
+// returned by the reconciliation instead of being lost.
+
+const preConsumeJournalKey = "pre_consume_journal"
+
+const preConsumeJournalTimeout = 2 * time.Second
+
+type preConsumeJournalEntry struct {
+	TokenId     int   `json:"token_id"`
+	Quota       int64 `json:"quota"`
+	CreatedTime int64 `json:"created_time"`
+}
+
+// JournalPreConsumedQuota records quota pre-consumed from a token and returns the id of the
+// entry, empty without Redis
+func JournalPreConsumedQuota(tokenId int, quota int64) string {
+	if !common.RedisEnabled || quota == 0 {
+		return ""
+	}
+	entry, _ := json.Marshal(preConsumeJournalEntry{
+		TokenId:     tokenId,
+		Quota:       quota,
+		CreatedTime: helper.GetTimestamp(),
+	})
+	id := helper.GenRequestID()
+	ctx, cancel := context.WithTimeout(context.Background(), preConsumeJournalTimeout)
+	defer cancel()
+	if err := common.RDB.HSet(ctx, preConsumeJournalKey, id, string(entry)).Err(); err != nil {
+		logger.SysError("failed to journal pre-consumed quota: " + err.Error())
+		return ""
+	}
+	return id
+}
+
+// SettlePreConsumedQuota removes the entry of a request being billed, it returns false when
+// the reconciliation has already returned the pre-consumed quota
+func SettlePreConsumedQuota(id string) bool {
+	if id == "" || !common.RedisEnabled {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), preConsumeJournalTimeout)
+	defer cancel()
+	removed, err := common.RDB.HDel(ctx, preConsumeJournalKey, id).Result()
+	if err != nil {
+		// the entry may be returned by the reconciliation too
+		logger.SysError("failed to settle pre-consumed quota: " + err.Error())
+		return true
+	}
+	return removed == 1
+}
+
+// ReconcilePreConsumedQuota returns the quota pre-consumed by the requests which were not
+// billed PRE_CONSUME_RECONCILE_AFTER seconds after they started
+func ReconcilePreConsumedQuota() {
+	ctx := context.Background()
+	entries, err := common.RDB.HGetAll(ctx, preConsumeJournalKey).Result()
+	if err != nil {
+		logger.SysError("failed to read the pre-consume journal: " + err.Error())
+		return
+	}
+	deadline := helper.GetTimestamp() - int64(config.PreConsumeReconcileAfter)
+	for id, value := range entries {
+		var entry preConsumeJournalEntry
+		if err := json.Unmarshal([]byte(value), &entry); err != nil {
+			common.RDB.HDel(ctx, preConsumeJournalKey, id)
+			continue
+		}
+		if entry.CreatedTime > deadline {
+			continue
+		}
+		// removing the entry first makes either the reconciliation or the billing return the quota
+		if removed, err := common.RDB.HDel(ctx, preConsumeJournalKey, id).Result(); err != nil || removed == 0 {
+			continue
+		}
+		if err := PostConsumeTokenQuota(entry.TokenId, -entry.Quota); err != nil {
+			logger.SysError(fmt.Sprintf("failed to return the quota pre-consumed by token #%d: %s", entry.TokenId, err.Error()))
+			continue
+		}
+		logger.SysLog(fmt.Sprintf("returned the quota %d pre-consumed by token #%d and never billed", entry.Quota, entry.TokenId))
+	}
+}
+
+func SyncPreConsumeJournal(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		ReconcilePreConsumedQuota()
+	}
+}
@@ -0,0 +1,418 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// The promotions give quota for free: the signup bonus, the referral credits and the
+// promotional redemption codes, coupons, are recorded as promo grants. The quota of a grant
+// expires after PromoQuotaValidDays, the promo quota is spent first and what is left of it
+// when it expires is taken back. The free allowances give tokens of some models every month,
+// the requests are not billed for them.
+
+// Sources of the promo grants
+const (
+	PromoSourceSignup  = "signup"
+	PromoSourceInvitee = "invitee"
+	PromoSourceInviter = "inviter"
+	PromoSourceCoupon  = "coupon"
+)
+
+const (
+	PromoGrantStatusActive  = 1 // don't use 0, 0 is the default value!
+	PromoGrantStatusExpired = 2
+)
+
+// PromoGrant is quota given by a promotion
+type PromoGrant struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Source      string `json:"source" gorm:"type:varchar(16);index"`
+	Quota       int64  `json:"quota" gorm:"bigint"`
+	RemainQuota int64  `json:"remain_quota" gorm:"bigint"`                            // not spent yet, only tracked for the grants which expire
+	Ip          string `json:"ip,omitempty" gorm:"type:varchar(64);index;default:''"` // of the signup, for the abuse checks
+	Ref         string `json:"ref" gorm:"type:varchar(64);default:''"`                // name of the coupon, or id of the invitee
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint;index"`
+	ExpiredTime int64  `json:"expired_time" gorm:"bigint;default:0"` // 0 never expires
+}
+
+// recordPromoGrant records quota given by a promotion, the caller adds it to the quota of
+// the user
+func recordPromoGrant(tx *gorm.DB, userId int, source string, quota int64, ip string, ref string) error {
+	grant := PromoGrant{
+		UserId:      userId,
+		Source:      source,
+		Quota:       quota,
+		RemainQuota: quota,
+		Ip:          ip,
+		Ref:         ref,
+		Status:      PromoGrantStatusActive,
+		CreatedTime: helper.GetTimestamp(),
+	}
+	if config.PromoQuotaValidDays > 0 && quota > 0 {
+		grant.ExpiredTime = grant.CreatedTime + int64(config.PromoQuotaValidDays)*86400
+	}
+	if err := tx.Create(&grant).Error; err != nil {
+		return err
+	}
+	if grant.ExpiredTime != 0 {
+		markPromoUser(userId)
+	}
+	return nil
+}
+
+// promoSignupAllowed tells whether a signup from an IP gets the signup promotions, there are
+// only PromoSignupIPDailyLimit of them a day per IP
+func promoSignupAllowed(ip string) bool {
+	if config.PromoSignupIPDailyLimit <= 0 || ip == "" {
+		return true
+	}
+	var count int64
+	err := DB.Model(&PromoGrant{}).Where("source = ? AND ip = ? AND created_time > ?",
+		PromoSourceSignup, ip, helper.GetTimestamp()-86400).Count(&count).Error
+	return err == nil && count < int64(config.PromoSignupIPDailyLimit)
+}
+
+// referralAllowed tells whether an inviter and the user signing up with their code get the
+// referral credits, not when they signed up from the same IP
+func referralAllowed(inviterId int, ip string) bool {
+	if ip == "" {
+		return true
+	}
+	var count int64
+	err := DB.Model(&PromoGrant{}).Where("user_id = ? AND source = ? AND ip = ?", inviterId, PromoSourceSignup, ip).Count(&count).Error
+	return err == nil && count == 0
+}
+
+// grantSignupPromotions records the signup bonus of a new user, already in their quota, and
+// gives the referral credits. The signup is recorded even without a bonus when its IP is
+// known, for the abuse checks.
+func grantSignupPromotions(ctx context.Context, user *User, inviterId int) {
+	if user.Quota > 0 || user.SignupIp != "" {
+		if err := recordPromoGrant(DB, user.Id, PromoSourceSignup, user.Quota, user.SignupIp, ""); err != nil {
+			logger.Error(ctx, "failed to record the signup bonus: "+err.Error())
+		}
+	}
+	if user.Quota > 0 {
+		RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(user.Quota)))
+	}
+	if inviterId == 0 {
+		return
+	}
+	if !referralAllowed(inviterId, user.SignupIp) {
+		logger.Warnf(ctx, "user #%d invited by user #%d signed up from the same IP, no referral credits", user.Id, inviterId)
+		return
+	}
+	if config.QuotaForInvitee > 0 {
+		_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee)
+		if err := recordPromoGrant(DB, user.Id, PromoSourceInvitee, config.QuotaForInvitee, user.SignupIp, ""); err != nil {
+			logger.Error(ctx, "failed to record the referral credits: "+err.Error())
+		}
+		RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(config.QuotaForInvitee)))
+	}
+	if config.QuotaForInviter > 0 {
+		_ = IncreaseUserQuota(inviterId, config.QuotaForInviter)
+		if err := recordPromoGrant(DB, inviterId, PromoSourceInviter, config.QuotaForInviter, "", fmt.Sprintf("%d", user.Id)); err != nil {
+			logger.Error(ctx, "failed to record the referral credits: "+err.Error())
+		}
+		RecordLog(ctx, inviterId, LogTypeSystem, fmt.Sprintf("邀请用户赠送 %s", common.LogQuota(config.QuotaForInviter)))
+	}
+}
+
+func GetPromoGrants(userId int, startIdx int, num int) ([]*PromoGrant, error) {
+	var grants []*PromoGrant
+	tx := DB.Order("id desc")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	err := tx.Limit(num).Offset(startIdx).Find(&grants).Error
+	return grants, err
+}
+
+// promoUsers are the users with promo quota which expires, whose spending is tracked
+var promoUsers = make(map[int]bool)
+var promoUsersLock sync.RWMutex
+
+func markPromoUser(userId int) {
+	promoUsersLock.Lock()
+	promoUsers[userId] = true
+	promoUsersLock.Unlock()
+}
+
+// InitPromoCache loads the users with promo quota which expires, it is called on startup and
+// periodically
+func InitPromoCache() {
+	var userIds []int
+	err := DB.Model(&PromoGrant{}).Where("status = ? AND expired_time <> 0 AND remain_quota > 0", PromoGrantStatusActive).
+		Distinct().Pluck("user_id", &userIds).Error
+	if err != nil {
+		logger.SysError("failed to load the promo grants: " + err.Error())
+		return
+	}
+	newPromoUsers := make(map[int]bool, len(userIds))
+	for _, userId := range userIds {
+		newPromoUsers[userId] = true
+	}
+	promoUsersLock.Lock()
+	promoUsers = newPromoUsers
+	promoUsersLock.Unlock()
+}
+
+// spendPromoQuota takes quota consumed by a user from their promo grants which expire, the
+// ones expiring first first. The grants are locked, and a grant spent in between by another
+// request, where they can't be, is not taken below 0.
+func spendPromoQuota(userId int, quota int64) {
+	promoUsersLock.RLock()
+	tracked := promoUsers[userId]
+	promoUsersLock.RUnlock()
+	if !tracked || quota <= 0 {
+		return
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		var grants []*PromoGrant
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND status = ? AND expired_time <> 0 AND remain_quota > 0", userId, PromoGrantStatusActive).
+			Order("expired_time asc, id asc").Find(&grants).Error
+		if err != nil {
+			return err
+		}
+		for _, grant := range grants {
+			if quota <= 0 {
+				break
+			}
+			spent := quota
+			if spent > grant.RemainQuota {
+				spent = grant.RemainQuota
+			}
+			result := tx.Model(grant).Where("remain_quota >= ?", spent).Update("remain_quota", gorm.Expr("remain_quota - ?", spent))
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				continue
+			}
+			quota -= spent
+		}
+		return nil
+	})
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to spend the promo quota of user #%d: %s", userId, err.Error()))
+	}
+}
+
+// ExpirePromoGrants takes back the quota left of the promo grants which expired
+func ExpirePromoGrants() {
+	var grants []*PromoGrant
+	err := DB.Where("status = ? AND expired_time <> 0 AND expired_time <= ?", PromoGrantStatusActive, helper.GetTimestamp()).
+		Find(&grants).Error
+	if err != nil {
+		logger.SysError("failed to expire the promo grants: " + err.Error())
+		return
+	}
+	for _, grant := range grants {
+		var expired int64
+		err = DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(grant).Where("status = ?", PromoGrantStatusActive).Update("status", PromoGrantStatusExpired)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			var user User
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota").First(&user, "id = ?", grant.UserId).Error; err != nil {
+				return err
+			}
+			// the quota the user has left is all that can be taken back
+			expired = grant.RemainQuota
+			if expired > user.Quota {
+				expired = user.Quota
+			}
+			if expired <= 0 {
+				return nil
+			}
+			return tx.Model(&User{}).Where("id = ?", grant.UserId).Update("quota", gorm.Expr("quota - ?", expired)).Error
+		})
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to expire promo grant #%d: %s", grant.Id, err.Error()))
+			continue
+		}
+		if expired > 0 {
+			ctx := context.Background()
+			if err = CacheUpdateUserQuota(ctx, grant.UserId); err != nil {
+				logger.SysError("error update user quota cache: " + err.Error())
+			}
+			RecordLog(ctx, grant.UserId, LogTypeSystem, fmt.Sprintf("赠送额度过期，扣除 %s", common.LogQuota(expired)))
+		}
+	}
+}
+
+// SyncPromoCache expires the promo grants on the master node and reloads the users with
+// promo quota which expires
+func SyncPromoCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		if config.IsMasterNode {
+			ExpirePromoGrants()
+		}
+		InitPromoCache()
+	}
+}
+
+// FreeAllowance gives every user of the groups Tokens of a model for free every month (UTC)
+type FreeAllowance struct {
+	Model  string   `json:"model"`
+	Tokens int64    `json:"tokens"`
+	Groups []string `json:"groups,omitempty"` // every group when empty
+}
+
+// FreeAllowanceUsage is the tokens of a model a user got for free in a month
+type FreeAllowanceUsage struct {
+	Id        int    `json:"-"`
+	UserId    int    `json:"user_id" gorm:"uniqueIndex:idx_free_allowance_usage,priority:1"`
+	ModelName string `json:"model_name" gorm:"type:varchar(255);uniqueIndex:idx_free_allowance_usage,priority:2"`
+	Month     string `json:"month" gorm:"type:varchar(7);uniqueIndex:idx_free_allowance_usage,priority:3"` // 2006-01
+	Tokens    int64  `json:"tokens" gorm:"default:0"`
+}
+
+var freeAllowances []FreeAllowance
+var freeAllowancesLock sync.RWMutex
+
+func FreeAllowances2JSONString() string {
+	freeAllowancesLock.RLock()
+	defer freeAllowancesLock.RUnlock()
+	if freeAllowances == nil {
+		return "[]"
+	}
+	jsonBytes, err := json.Marshal(freeAllowances)
+	if err != nil {
+		logger.SysError("error marshalling free allowances: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateFreeAllowancesByJSONString(jsonStr string) error {
+	var allowances []FreeAllowance
+	if err := json.Unmarshal([]byte(jsonStr), &allowances); err != nil {
+		return err
+	}
+	for _, allowance := range allowances {
+		if strings.TrimSpace(allowance.Model) == "" {
+			return errors.New("the model of a free allowance is empty")
+		}
+		if allowance.Tokens <= 0 {
+			return fmt.Errorf("the free allowance of %s has no tokens", allowance.Model)
+		}
+	}
+	freeAllowancesLock.Lock()
+	freeAllowances = allowances
+	freeAllowancesLock.Unlock()
+	return nil
+}
+
+// getFreeAllowance returns the tokens of a model the users of a group get every month
+func getFreeAllowance(group string, modelName string) int64 {
+	freeAllowancesLock.RLock()
+	defer freeAllowancesLock.RUnlock()
+	for _, allowance := range freeAllowances {
+		if allowance.Model == modelName {
+			return allowance.tokensOf(group)
+		}
+	}
+	return 0
+}
+
+// tokensOf returns the tokens of the allowance for the users of a group, 0 when it is not
+// for their group
+func (a FreeAllowance) tokensOf(group string) int64 {
+	if len(a.Groups) == 0 {
+		return a.Tokens
+	}
+	for _, g := range a.Groups {
+		if g == group {
+			return a.Tokens
+		}
+	}
+	return 0
+}
+
+// UseFreeAllowance takes the tokens of a request from the free allowance of the user for the
+// model, and returns how many of them are free
+func UseFreeAllowance(userId int, group string, modelName string, tokens int64) int64 {
+	allowance := getFreeAllowance(group, modelName)
+	if allowance == 0 || tokens <= 0 {
+		return 0
+	}
+	usage := FreeAllowanceUsage{UserId: userId, ModelName: modelName, Month: time.Now().UTC().Format("2006-01")}
+	if err := DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&usage).Error; err != nil {
+		logger.SysError("failed to use the free allowance: " + err.Error())
+		return 0
+	}
+	// concurrent requests retry when another one used the allowance in between
+	for i := 0; i < 3; i++ {
+		err := DB.Where("user_id = ? AND model_name = ? AND month = ?", usage.UserId, usage.ModelName, usage.Month).First(&usage).Error
+		if err != nil {
+			logger.SysError("failed to use the free allowance: " + err.Error())
+			return 0
+		}
+		free := allowance - usage.Tokens
+		if free <= 0 {
+			return 0
+		}
+		if free > tokens {
+			free = tokens
+		}
+		result := DB.Model(&FreeAllowanceUsage{}).Where("id = ? AND tokens = ?", usage.Id, usage.Tokens).
+			Update("tokens", gorm.Expr("tokens + ?", free))
+		if result.Error != nil {
+			logger.SysError("failed to use the free allowance: " + result.Error.Error())
+			return 0
+		}
+		if result.RowsAffected == 1 {
+			return free
+		}
+	}
+	return 0
+}
+
+// FreeAllowanceStatus is what is left of a free allowance of a user this month
+type FreeAllowanceStatus struct {
+	Model  string `json:"model"`
+	Month  string `json:"month"`
+	Tokens int64  `json:"tokens"`
+	Used   int64  `json:"used"`
+}
+
+// GetFreeAllowances returns the free allowances of a user of a group this month
+func GetFreeAllowances(userId int, group string) ([]*FreeAllowanceStatus, error) {
+	month := time.Now().UTC().Format("2006-01")
+	var usages []*FreeAllowanceUsage
+	if err := DB.Where("user_id = ? AND month = ?", userId, month).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	used := make(map[string]int64, len(usages))
+	for _, usage := range usages {
+		used[usage.ModelName] = usage.Tokens
+	}
+	freeAllowancesLock.RLock()
+	defer freeAllowancesLock.RUnlock()
+	statuses := make([]*FreeAllowanceStatus, 0)
+	for _, allowance := range freeAllowances {
+		if tokens := allowance.tokensOf(group); tokens > 0 {
+			statuses = append(statuses, &FreeAllowanceStatus{Model: allowance.Model, Month: month, Tokens: tokens, Used: used[allowance.Model]})
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/config"
+)
+
+func TestSpendPromoQuota(t *testing.T) {
+	initTestDB(t, &PromoGrant{})
+	validDays := config.PromoQuotaValidDays
+	config.PromoQuotaValidDays = 30
+	t.Cleanup(func() {
+		config.PromoQuotaValidDays = validDays
+	})
+	require.NoError(t, recordPromoGrant(DB, 1, PromoSourceSignup, 100, "", ""))
+	require.NoError(t, recordPromoGrant(DB, 1, PromoSourceCoupon, 50, "", "coupon"))
+	require.NoError(t, DB.Model(&PromoGrant{}).Where("source = ?", PromoSourceCoupon).Update("expired_time", 1).Error)
+	remainQuotas := func() map[string]int64 {
+		var grants []*PromoGrant
+		require.NoError(t, DB.Where("user_id = ?", 1).Find(&grants).Error)
+		remain := make(map[string]int64, len(grants))
+		for _, grant := range grants {
+			remain[grant.Source] = grant.RemainQuota
+		}
+		return remain
+	}
+
+	// the grant expiring first is spent first
+	spendPromoQuota(1, 30)
+	assert.Equal(t, map[string]int64{PromoSourceSignup: 100, PromoSourceCoupon: 20}, remainQuotas())
+	spendPromoQuota(1, 40)
+	assert.Equal(t, map[string]int64{PromoSourceSignup: 80, PromoSourceCoupon: 0}, remainQuotas())
+	// the grants are not spent below 0
+	spendPromoQuota(1, 1000)
+	assert.Equal(t, map[string]int64{PromoSourceSignup: 0, PromoSourceCoupon: 0}, remainQuotas())
+	spendPromoQuota(1, 10)
+	assert.Equal(t, map[string]int64{PromoSourceSignup: 0, PromoSourceCoupon: 0}, remainQuotas())
+}
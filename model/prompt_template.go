@@ -0,0 +1,241 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	PromptTemplateStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PromptTemplateStatusDisabled = 2 // also don't use 0
+)
+
+// PromptTemplate is a system prompt with {{variables}}, rendered for each request of the
+// tokens using it and of the users of its groups. It overrides the system prompt of the channels.
+type PromptTemplate struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Content     string `json:"content" gorm:"type:text"`
+	Groups      string `json:"groups" gorm:"type:varchar(255);default:''"` // comma separated user groups using it
+	Version     int    `json:"version" gorm:"default:1"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// PromptTemplateVersion is the content of a template at one of its versions, kept to roll back
+type PromptTemplateVersion struct {
+	Id          int    `json:"id"`
+	TemplateId  int    `json:"template_id" gorm:"uniqueIndex:idx_prompt_template_version"`
+	Version     int    `json:"version" gorm:"uniqueIndex:idx_prompt_template_version"`
+	Content     string `json:"content" gorm:"type:text"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+var promptTemplateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+
+// Render replaces the variables of the template with their values, the unknown ones are kept
+func (t *PromptTemplate) Render(value func(name string) (string, bool)) string {
+	return promptTemplateVariable.ReplaceAllStringFunc(t.Content, func(match string) string {
+		name := promptTemplateVariable.FindStringSubmatch(match)[1]
+		if v, ok := value(name); ok {
+			return v
+		}
+		return match
+	})
+}
+
+// UsesVariable tells whether the template has a variable, to skip the costly ones
+func (t *PromptTemplate) UsesVariable(name string) bool {
+	for _, match := range promptTemplateVariable.FindAllStringSubmatch(t.Content, -1) {
+		if match[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *PromptTemplate) groups() []string {
+	var groups []string
+	for _, group := range strings.Split(t.Groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+func (t *PromptTemplate) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("template name is empty")
+	}
+	if strings.TrimSpace(t.Content) == "" {
+		return errors.New("template content is empty")
+	}
+	groups := t.groups()
+	t.Groups = strings.Join(groups, ",")
+	if len(groups) == 0 {
+		return nil
+	}
+	// a group uses a single template
+	var others []*PromptTemplate
+	if err := DB.Where("id <> ?", t.Id).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		for _, group := range other.groups() {
+			for _, g := range groups {
+				if g == group {
+					return fmt.Errorf("group %s already uses template %s", group, other.Name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func GetAllPromptTemplates() ([]*PromptTemplate, error) {
+	var templates []*PromptTemplate
+	err := DB.Order("id asc").Find(&templates).Error
+	return templates, err
+}
+
+func GetPromptTemplateById(id int) (*PromptTemplate, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	template := PromptTemplate{Id: id}
+	err := DB.First(&template, "id = ?", id).Error
+	return &template, err
+}
+
+func GetPromptTemplateVersions(templateId int) ([]*PromptTemplateVersion, error) {
+	var versions []*PromptTemplateVersion
+	err := DB.Where("template_id = ?", templateId).Order("version desc").Find(&versions).Error
+	return versions, err
+}
+
+func (t *PromptTemplate) Insert() error {
+	t.CreatedTime = helper.GetTimestamp()
+	t.UpdatedTime = t.CreatedTime
+	t.Version = 1
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(t).Error; err != nil {
+			return err
+		}
+		return tx.Create(&PromptTemplateVersion{
+			TemplateId:  t.Id,
+			Version:     t.Version,
+			Content:     t.Content,
+			CreatedTime: t.CreatedTime,
+		}).Error
+	})
+}
+
+// Update saves the template, a new version is created when its content changed
+func (t *PromptTemplate) Update() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		var current PromptTemplate
+		if err := tx.First(&current, "id = ?", t.Id).Error; err != nil {
+			return err
+		}
+		t.Version = current.Version
+		t.UpdatedTime = helper.GetTimestamp()
+		if t.Content != current.Content {
+			t.Version++
+			err := tx.Create(&PromptTemplateVersion{
+				TemplateId:  t.Id,
+				Version:     t.Version,
+				Content:     t.Content,
+				CreatedTime: t.UpdatedTime,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(t).Select("name", "description", "content", "groups", "version", "status", "updated_time").Updates(t).Error
+	})
+}
+
+// Rollback makes the content of an earlier version the content of a new version
+func (t *PromptTemplate) Rollback(version int) error {
+	var previous PromptTemplateVersion
+	err := DB.First(&previous, "template_id = ? AND version = ?", t.Id, version).Error
+	if err != nil {
+		return fmt.Errorf("version %d of the template not found", version)
+	}
+	t.Content = previous.Content
+	return t.Update()
+}
+
+// Delete removes the template with its versions, the tokens using it use the template of
+// their group again
+func (t *PromptTemplate) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("template_id = ?", t.Id).Delete(&PromptTemplateVersion{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Token{}).Where("prompt_template_id = ?", t.Id).Update("prompt_template_id", 0).Error; err != nil {
+			return err
+		}
+		return tx.Delete(t).Error
+	})
+}
+
+var enabledPromptTemplates map[int]*PromptTemplate
+var groupPromptTemplates map[string]*PromptTemplate
+var promptTemplateSyncLock sync.RWMutex
+
+// InitPromptTemplateCache loads the enabled templates into memory, it is called on startup,
+// after every admin change and periodically
+func InitPromptTemplateCache() {
+	var templates []*PromptTemplate
+	err := DB.Where("status = ?", PromptTemplateStatusEnabled).Order("id asc").Find(&templates).Error
+	if err != nil {
+		logger.SysError("failed to load prompt templates: " + err.Error())
+		return
+	}
+	newEnabledPromptTemplates := make(map[int]*PromptTemplate, len(templates))
+	newGroupPromptTemplates := make(map[string]*PromptTemplate)
+	for _, template := range templates {
+		newEnabledPromptTemplates[template.Id] = template
+		for _, group := range template.groups() {
+			if _, ok := newGroupPromptTemplates[group]; !ok {
+				newGroupPromptTemplates[group] = template
+			}
+		}
+	}
+	promptTemplateSyncLock.Lock()
+	enabledPromptTemplates = newEnabledPromptTemplates
+	groupPromptTemplates = newGroupPromptTemplates
+	promptTemplateSyncLock.Unlock()
+}
+
+func SyncPromptTemplateCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitPromptTemplateCache()
+	}
+}
+
+// CacheGetPromptTemplate returns the template of a token, or else of the group of its user,
+// nil when there is none
+func CacheGetPromptTemplate(templateId int, group string) *PromptTemplate {
+	promptTemplateSyncLock.RLock()
+	defer promptTemplateSyncLock.RUnlock()
+	if template, ok := enabledPromptTemplates[templateId]; ok {
+		return template
+	}
+	return groupPromptTemplates[group]
+}
@@ -0,0 +1,160 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	RateLimitStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	RateLimitStatusDisabled = 2 // also don't use 0
+)
+
+// RateLimit is a per-endpoint limit editable at runtime through the admin API
+type RateLimit struct {
+	Id          int    `json:"id"`
+	PathPattern string `json:"path_pattern" gorm:"type:varchar(255)"` // glob, a trailing /** matches any sub path
+	Method      string `json:"method" gorm:"type:varchar(16);default:'*'"`
+	Dimension   string `json:"dimension" gorm:"type:varchar(16);default:'ip'"` // ip, token or user
+	Limit       int    `json:"limit" gorm:"column:max_requests"`
+	Window      int64  `json:"window" gorm:"column:window_seconds;bigint"` // unit is second
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+var validRateLimitDimensions = map[string]bool{
+	"ip":    true,
+	"token": true,
+	"user":  true,
+}
+
+var validRateLimitMethods = map[string]bool{
+	"*":                true,
+	http.MethodGet:     true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+}
+
+func (r *RateLimit) Validate() error {
+	r.PathPattern = strings.TrimSpace(r.PathPattern)
+	r.Method = strings.ToUpper(strings.TrimSpace(r.Method))
+	if r.Method == "" {
+		r.Method = "*"
+	}
+	if r.Dimension == "" {
+		r.Dimension = "ip"
+	}
+	if !strings.HasPrefix(r.PathPattern, "/") {
+		return errors.New("path pattern must start with /")
+	}
+	if _, err := path.Match(strings.TrimSuffix(r.PathPattern, "/**"), ""); err != nil {
+		return fmt.Errorf("invalid path pattern: %w", err)
+	}
+	if !validRateLimitMethods[r.Method] {
+		return fmt.Errorf("invalid method: %s", r.Method)
+	}
+	if !validRateLimitDimensions[r.Dimension] {
+		return fmt.Errorf("invalid dimension: %s", r.Dimension)
+	}
+	if r.Dimension == "token" && (r.PathPattern == "/api" || strings.HasPrefix(r.PathPattern, "/api/")) {
+		// the dashboard API authenticates users, never tokens
+		return errors.New("the /api routes are not called with tokens, use the user dimension")
+	}
+	if r.Limit <= 0 || r.Window <= 0 {
+		return errors.New("limit and window must be greater than 0")
+	}
+	return nil
+}
+
+// Matches reports whether the rule applies to the given request method and path
+func (r *RateLimit) Matches(method string, requestPath string) bool {
+	if r.Method != "*" && r.Method != method {
+		return false
+	}
+	if prefix, ok := strings.CutSuffix(r.PathPattern, "/**"); ok {
+		if requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/") {
+			return true
+		}
+		matched, _ := path.Match(prefix, requestPath)
+		return matched
+	}
+	matched, _ := path.Match(r.PathPattern, requestPath)
+	return matched
+}
+
+func GetAllRateLimits() ([]*RateLimit, error) {
+	var rateLimits []*RateLimit
+	err := DB.Order("id desc").Find(&rateLimits).Error
+	return rateLimits, err
+}
+
+func GetRateLimitById(id int) (*RateLimit, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	rateLimit := RateLimit{Id: id}
+	err := DB.First(&rateLimit, "id = ?", id).Error
+	return &rateLimit, err
+}
+
+func (r *RateLimit) Insert() error {
+	r.CreatedTime = helper.GetTimestamp()
+	return DB.Create(r).Error
+}
+
+func (r *RateLimit) Update() error {
+	return DB.Model(r).Select("path_pattern", "method", "dimension", "max_requests", "window_seconds", "status").Updates(r).Error
+}
+
+func (r *RateLimit) Delete() error {
+	return DB.Delete(r).Error
+}
+
+var enabledRateLimits []*RateLimit
+var rateLimitSyncLock sync.RWMutex
+
+// InitRateLimitCache loads enabled endpoint rate limits into memory,
+// it is called on startup, after every admin change and periodically
+func InitRateLimitCache() {
+	var rateLimits []*RateLimit
+	err := DB.Where("status = ?", RateLimitStatusEnabled).Order("id asc").Find(&rateLimits).Error
+	if err != nil {
+		logger.SysError("failed to load rate limits: " + err.Error())
+		return
+	}
+	rateLimitSyncLock.Lock()
+	enabledRateLimits = rateLimits
+	rateLimitSyncLock.Unlock()
+}
+
+func SyncRateLimitCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitRateLimitCache()
+	}
+}
+
+// CacheGetMatchedRateLimits returns the enabled rules applying to the request
+func CacheGetMatchedRateLimits(method string, requestPath string) []*RateLimit {
+	rateLimitSyncLock.RLock()
+	defer rateLimitSyncLock.RUnlock()
+	var matched []*RateLimit
+	for _, rateLimit := range enabledRateLimits {
+		if rateLimit.Matches(method, requestPath) {
+			matched = append(matched, rateLimit)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitValidate(t *testing.T) {
+	testCases := []struct {
+		rateLimit RateLimit
+		valid     bool
+	}{
+		{RateLimit{PathPattern: "/v1/chat/completions", Dimension: "token", Limit: 10, Window: 60}, true},
+		{RateLimit{PathPattern: "/api/user/**", Dimension: "user", Limit: 10, Window: 60}, true},
+		{RateLimit{PathPattern: "/*/**", Dimension: "token", Limit: 10, Window: 60}, true},
+		{RateLimit{PathPattern: "/api/user/**", Dimension: "token", Limit: 10, Window: 60}, false},
+		{RateLimit{PathPattern: "/api", Dimension: "token", Limit: 10, Window: 60}, false},
+		{RateLimit{PathPattern: "v1/chat/completions", Limit: 10, Window: 60}, false},
+		{RateLimit{PathPattern: "/v1/chat/completions", Dimension: "model", Limit: 10, Window: 60}, false},
+		{RateLimit{PathPattern: "/v1/chat/completions", Limit: 0, Window: 60}, false},
+	}
+	for _, testCase := range testCases {
+		err := testCase.rateLimit.Validate()
+		assert.Equal(t, testCase.valid, err == nil, testCase.rateLimit.PathPattern, err)
+	}
+}
@@ -0,0 +1,330 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// The admin APIs require a permission, a resource and read or write. The built-in roles
+// keep their meaning: an admin has every permission but those of the settings, the root
+// user has them all. Custom roles grant more permissions to the users they are bound to,
+// directly or through their organization.
+
+const (
+	PermissionChannelsRead       = "channels:read"
+	PermissionChannelsWrite      = "channels:write"
+	PermissionUsersRead          = "users:read"
+	PermissionUsersWrite         = "users:write"
+	PermissionBillingRead        = "billing:read"
+	PermissionBillingWrite       = "billing:write"
+	PermissionLogsRead           = "logs:read"
+	PermissionLogsWrite          = "logs:write"
+	PermissionPoliciesRead       = "policies:read"
+	PermissionPoliciesWrite      = "policies:write"
+	PermissionModelsRead         = "models:read"
+	PermissionModelsWrite        = "models:write"
+	PermissionOrganizationsRead  = "organizations:read"
+	PermissionOrganizationsWrite = "organizations:write"
+	PermissionSystemRead         = "system:read"
+	PermissionSystemWrite        = "system:write"
+	PermissionSettingsRead       = "settings:read"
+	PermissionSettingsWrite      = "settings:write"
+)
+
+type PermissionInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// AllPermissions lists the permissions of the admin APIs
+var AllPermissions = []PermissionInfo{
+	{PermissionChannelsRead, "List, search and view channels"},
+	{PermissionChannelsWrite, "Add, update, delete and test channels, update their balance"},
+	{PermissionUsersRead, "List, search and view users"},
+	{PermissionUsersWrite, "Add, update, manage and delete users"},
+	{PermissionBillingRead, "List and view redemption codes, payments and statements"},
+	{PermissionBillingWrite, "Manage redemption codes and top up users"},
+	{PermissionLogsRead, "Search logs, debug captures and usage rollups"},
+	{PermissionLogsWrite, "Delete logs, run the retention, manage debug capture rules and rebuild rollups"},
+	{PermissionPoliciesRead, "View rate limits, moderation policies, webhooks and plugins"},
+	{PermissionPoliciesWrite, "Manage rate limits, moderation policies, webhooks and plugins"},
+	{PermissionModelsRead, "View model metadata, aliases, prompt templates and experiments"},
+	{PermissionModelsWrite, "Manage model metadata, aliases, prompt templates and experiments"},
+	{PermissionOrganizationsRead, "List and view organizations"},
+	{PermissionOrganizationsWrite, "Add, update and delete organizations"},
+	{PermissionSystemRead, "View groups, channel health, connection pools, cache stats, jobs and the self-check"},
+	{PermissionSystemWrite, "Change connection pools, clear and toggle the cache, run jobs"},
+	{PermissionSettingsRead, "View the options, settings and gateway config"},
+	{PermissionSettingsWrite, "Change the options, settings and gateway config"},
+}
+
+func isPermission(name string) bool {
+	for _, permission := range AllPermissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPermission tells whether a granted permission, possibly a wildcard such as
+// channels:* or *, covers a permission
+func matchPermission(granted string, permission string) bool {
+	if granted == "*" || granted == permission {
+		return true
+	}
+	resource, ok := strings.CutSuffix(granted, ":*")
+	return ok && strings.HasPrefix(permission, resource+":")
+}
+
+// BuiltinRolePermissions returns the permissions of a built-in role
+func BuiltinRolePermissions(role int) []string {
+	var permissions []string
+	if role < RoleAdminUser {
+		return permissions
+	}
+	for _, permission := range AllPermissions {
+		if role >= RoleRootUser || !strings.HasPrefix(permission.Name, "settings:") {
+			permissions = append(permissions, permission.Name)
+		}
+	}
+	return permissions
+}
+
+// CustomRole grants permissions to the users and organizations it is bound to
+type CustomRole struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Permissions string `json:"permissions" gorm:"type:text"` // comma separated, channels:* grants those of a resource
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+// RoleBinding binds a custom role to a user or to the members of an organization
+type RoleBinding struct {
+	Id             int   `json:"id"`
+	RoleId         int   `json:"role_id" gorm:"index"`
+	UserId         int   `json:"user_id" gorm:"index"`         // 0 when the role is bound to an organization
+	OrganizationId int   `json:"organization_id" gorm:"index"` // 0 when the role is bound to a user
+	CreatedTime    int64 `json:"created_time" gorm:"bigint"`
+}
+
+func (r *CustomRole) Validate() error {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return errors.New("role name is empty")
+	}
+	var permissions []string
+	for _, permission := range strings.Split(r.Permissions, ",") {
+		permission = strings.TrimSpace(permission)
+		if permission == "" {
+			continue
+		}
+		resource, wildcard := strings.CutSuffix(permission, ":*")
+		if permission != "*" && !isPermission(permission) && !(wildcard && isPermission(resource+":read")) {
+			return fmt.Errorf("unknown permission %s", permission)
+		}
+		permissions = append(permissions, permission)
+	}
+	if len(permissions) == 0 {
+		return errors.New("role has no permission")
+	}
+	r.Permissions = strings.Join(permissions, ",")
+	return nil
+}
+
+func (b *RoleBinding) Validate() error {
+	if (b.UserId == 0) == (b.OrganizationId == 0) {
+		return errors.New("a role is bound to either a user or an organization")
+	}
+	if _, err := GetCustomRoleById(b.RoleId); err != nil {
+		return fmt.Errorf("role #%d does not exist", b.RoleId)
+	}
+	if b.UserId != 0 {
+		if err := DB.First(&User{}, "id = ?", b.UserId).Error; err != nil {
+			return fmt.Errorf("user #%d does not exist", b.UserId)
+		}
+	} else if _, err := GetOrganizationById(b.OrganizationId); err != nil {
+		return fmt.Errorf("organization #%d does not exist", b.OrganizationId)
+	}
+	return nil
+}
+
+func GetAllCustomRoles() ([]*CustomRole, error) {
+	var roles []*CustomRole
+	err := DB.Order("id asc").Find(&roles).Error
+	return roles, err
+}
+
+func GetCustomRoleById(id int) (*CustomRole, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	role := CustomRole{Id: id}
+	err := DB.First(&role, "id = ?", id).Error
+	return &role, err
+}
+
+func (r *CustomRole) Insert() error {
+	r.CreatedTime = helper.GetTimestamp()
+	r.UpdatedTime = r.CreatedTime
+	return DB.Create(r).Error
+}
+
+func (r *CustomRole) Update() error {
+	r.UpdatedTime = helper.GetTimestamp()
+	return DB.Model(r).Select("name", "description", "permissions", "updated_time").Updates(r).Error
+}
+
+// Delete removes the role and its bindings
+func (r *CustomRole) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", r.Id).Delete(&RoleBinding{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(r).Error
+	})
+}
+
+func GetAllRoleBindings() ([]*RoleBinding, error) {
+	var bindings []*RoleBinding
+	err := DB.Order("id asc").Find(&bindings).Error
+	return bindings, err
+}
+
+func (b *RoleBinding) Insert() error {
+	var count int64
+	err := DB.Model(&RoleBinding{}).Where("role_id = ? AND user_id = ? AND organization_id = ?", b.RoleId, b.UserId, b.OrganizationId).Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errors.New("the role is already bound")
+	}
+	b.CreatedTime = helper.GetTimestamp()
+	return DB.Create(b).Error
+}
+
+func DeleteRoleBindingById(id int) error {
+	result := DB.Delete(&RoleBinding{}, "id = ?", id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return fmt.Errorf("binding #%d does not exist", id)
+	}
+	return result.Error
+}
+
+var customRolePermissions map[int][]string
+var userRoleIds map[int][]int
+var organizationRoleIds map[int][]int
+var userOrganizationIds map[int]int // members of the organizations with a role
+var rbacSyncLock sync.RWMutex
+
+// InitRBACCache loads the custom roles, their bindings and the members of the organizations
+// they are bound to, it is called on startup, after every change and periodically
+func InitRBACCache() {
+	roles, err := GetAllCustomRoles()
+	if err != nil {
+		logger.SysError("failed to load custom roles: " + err.Error())
+		return
+	}
+	bindings, err := GetAllRoleBindings()
+	if err != nil {
+		logger.SysError("failed to load role bindings: " + err.Error())
+		return
+	}
+	newCustomRolePermissions := make(map[int][]string)
+	for _, role := range roles {
+		newCustomRolePermissions[role.Id] = strings.Split(role.Permissions, ",")
+	}
+	newUserRoleIds := make(map[int][]int)
+	newOrganizationRoleIds := make(map[int][]int)
+	var organizationIds []int
+	for _, binding := range bindings {
+		if binding.UserId != 0 {
+			newUserRoleIds[binding.UserId] = append(newUserRoleIds[binding.UserId], binding.RoleId)
+		} else {
+			if _, ok := newOrganizationRoleIds[binding.OrganizationId]; !ok {
+				organizationIds = append(organizationIds, binding.OrganizationId)
+			}
+			newOrganizationRoleIds[binding.OrganizationId] = append(newOrganizationRoleIds[binding.OrganizationId], binding.RoleId)
+		}
+	}
+	newUserOrganizationIds := make(map[int]int)
+	if len(organizationIds) > 0 {
+		var members []*OrganizationMember
+		if err := DB.Where("organization_id IN ?", organizationIds).Find(&members).Error; err != nil {
+			logger.SysError("failed to load organization members: " + err.Error())
+			return
+		}
+		for _, member := range members {
+			newUserOrganizationIds[member.UserId] = member.OrganizationId
+		}
+	}
+	rbacSyncLock.Lock()
+	customRolePermissions = newCustomRolePermissions
+	userRoleIds = newUserRoleIds
+	organizationRoleIds = newOrganizationRoleIds
+	userOrganizationIds = newUserOrganizationIds
+	rbacSyncLock.Unlock()
+}
+
+func SyncRBACCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitRBACCache()
+	}
+}
+
+// grantedPermissions returns the permissions granted to a user by the built-in role and the
+// custom roles, wildcards included
+func grantedPermissions(userId int, role int) []string {
+	granted := BuiltinRolePermissions(role)
+	rbacSyncLock.RLock()
+	defer rbacSyncLock.RUnlock()
+	roleIds := userRoleIds[userId]
+	if organizationId, ok := userOrganizationIds[userId]; ok {
+		// the slice is shared by the cache, appending to it could write into its spare capacity
+		roleIds = append(append([]int(nil), roleIds...), organizationRoleIds[organizationId]...)
+	}
+	for _, roleId := range roleIds {
+		granted = append(granted, customRolePermissions[roleId]...)
+	}
+	return granted
+}
+
+// HasPermission tells whether a user has a permission
+func HasPermission(userId int, role int, permission string) bool {
+	if role >= RoleRootUser {
+		return true
+	}
+	for _, granted := range grantedPermissions(userId, role) {
+		if matchPermission(granted, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUserPermissions returns the permissions of a user, in the order of AllPermissions
+func GetUserPermissions(userId int, role int) []string {
+	granted := grantedPermissions(userId, role)
+	var permissions []string
+	for _, permission := range AllPermissions {
+		for _, g := range granted {
+			if role >= RoleRootUser || matchPermission(g, permission.Name) {
+				permissions = append(permissions, permission.Name)
+				break
+			}
+		}
+	}
+	return permissions
+}
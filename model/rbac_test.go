@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatchPermission(t *testing.T) {
+	tests := []struct {
+		granted    string
+		permission string
+		want       bool
+	}{
+		{"*", PermissionSettingsWrite, true},
+		{PermissionChannelsRead, PermissionChannelsRead, true},
+		{PermissionChannelsRead, PermissionChannelsWrite, false},
+		{"channels:*", PermissionChannelsWrite, true},
+		{"channels:*", PermissionUsersRead, false},
+		{"channel:*", PermissionChannelsRead, false},
+		{"channels", PermissionChannelsRead, false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchPermission(tt.granted, tt.permission), "%s covers %s", tt.granted, tt.permission)
+	}
+}
+
+func TestBuiltinRolePermissions(t *testing.T) {
+	assert.Empty(t, BuiltinRolePermissions(RoleCommonUser))
+
+	admin := BuiltinRolePermissions(RoleAdminUser)
+	assert.Contains(t, admin, PermissionChannelsWrite)
+	assert.Contains(t, admin, PermissionSystemWrite)
+	assert.NotContains(t, admin, PermissionSettingsRead)
+	assert.NotContains(t, admin, PermissionSettingsWrite)
+	assert.Len(t, admin, len(AllPermissions)-2)
+
+	root := BuiltinRolePermissions(RoleRootUser)
+	assert.Len(t, root, len(AllPermissions))
+	assert.Contains(t, root, PermissionSettingsWrite)
+}
+
+func TestHasPermission(t *testing.T) {
+	initTestDB(t, &CustomRole{}, &RoleBinding{}, &OrganizationMember{})
+	settings := &CustomRole{Name: "settings", Permissions: PermissionSettingsRead}
+	require.NoError(t, settings.Insert())
+	billing := &CustomRole{Name: "billing", Permissions: "billing:*"}
+	require.NoError(t, billing.Insert())
+	require.NoError(t, DB.Create(&RoleBinding{RoleId: settings.Id, UserId: 1}).Error)
+	require.NoError(t, DB.Create(&RoleBinding{RoleId: billing.Id, OrganizationId: 7}).Error)
+	require.NoError(t, DB.Create(&OrganizationMember{OrganizationId: 7, UserId: 1, Role: OrganizationRoleMember}).Error)
+	require.NoError(t, DB.Create(&OrganizationMember{OrganizationId: 8, UserId: 2, Role: OrganizationRoleMember}).Error)
+	InitRBACCache()
+	t.Cleanup(func() {
+		rbacSyncLock.Lock()
+		customRolePermissions, userRoleIds, organizationRoleIds, userOrganizationIds = nil, nil, nil, nil
+		rbacSyncLock.Unlock()
+	})
+
+	// an admin has every permission but those of the settings
+	assert.True(t, HasPermission(3, RoleAdminUser, PermissionChannelsWrite))
+	assert.False(t, HasPermission(3, RoleAdminUser, PermissionSettingsRead))
+	assert.False(t, HasPermission(3, RoleAdminUser, PermissionSettingsWrite))
+	assert.True(t, HasPermission(3, RoleRootUser, PermissionSettingsWrite))
+	assert.False(t, HasPermission(3, RoleCommonUser, PermissionChannelsRead))
+
+	// the roles are granted to the user and through the organization
+	assert.True(t, HasPermission(1, RoleCommonUser, PermissionSettingsRead))
+	assert.False(t, HasPermission(1, RoleCommonUser, PermissionSettingsWrite))
+	assert.True(t, HasPermission(1, RoleCommonUser, PermissionBillingWrite))
+	assert.False(t, HasPermission(2, RoleCommonUser, PermissionBillingRead))
+	assert.Equal(t, []string{PermissionBillingRead, PermissionBillingWrite, PermissionSettingsRead}, GetUserPermissions(1, RoleCommonUser))
+
+	// the role ids of the user in the cache are left as they are
+	rbacSyncLock.RLock()
+	assert.Equal(t, []int{settings.Id}, userRoleIds[1])
+	rbacSyncLock.RUnlock()
+}
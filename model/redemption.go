@@ -26,7 +26,19 @@ type Redemption struct {
 	Quota        int64  `json:"quota" gorm:"bigint;default:100"`
 	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
 	RedeemedTime int64  `json:"redeemed_time" gorm:"bigint"`
-	Count        int    `json:"count" gorm:"-:all"` // only for api request
+	ExpiredTime  int64  `json:"expired_time" gorm:"bigint;default:0"` // 0 never expires
+	MaxUses      int    `json:"max_uses" gorm:"default:0"`            // redeemed once per user by up to MaxUses users when more than 1
+	UsedCount    int    `json:"used_count" gorm:"default:0"`
+	Promotional  bool   `json:"promotional" gorm:"default:false"` // a coupon, its quota is promo quota
+	Count        int    `json:"count" gorm:"-:all"`               // only for api request
+}
+
+// RedemptionUse records a user redeeming a code which can be redeemed by several users
+type RedemptionUse struct {
+	Id           int   `json:"id"`
+	RedemptionId int   `json:"redemption_id" gorm:"uniqueIndex:idx_redemption_use,priority:1"`
+	UserId       int   `json:"user_id" gorm:"uniqueIndex:idx_redemption_use,priority:2"`
+	CreatedTime  int64 `json:"created_time" gorm:"bigint"`
 }
 
 func GetAllRedemptions(startIdx int, num int) ([]*Redemption, error) {
@@ -73,12 +85,36 @@ func Redeem(ctx context.Context, key string, userId int) (quota int64, err error
 		if redemption.Status != RedemptionCodeStatusEnabled {
 			return errors.New("该兑换码已被使用")
 		}
+		if redemption.ExpiredTime != 0 && redemption.ExpiredTime < helper.GetTimestamp() {
+			return errors.New("该兑换码已过期")
+		}
 		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
 		if err != nil {
 			return err
 		}
 		redemption.RedeemedTime = helper.GetTimestamp()
-		redemption.Status = RedemptionCodeStatusUsed
+		redemption.UsedCount++
+		if redemption.MaxUses > 1 {
+			var used int64
+			if err = tx.Model(&RedemptionUse{}).Where("redemption_id = ? AND user_id = ?", redemption.Id, userId).Count(&used).Error; err != nil {
+				return err
+			}
+			if used > 0 {
+				return errors.New("您已使用过该兑换码")
+			}
+			use := RedemptionUse{RedemptionId: redemption.Id, UserId: userId, CreatedTime: redemption.RedeemedTime}
+			if err = tx.Create(&use).Error; err != nil {
+				return err
+			}
+		}
+		if redemption.UsedCount >= redemption.MaxUses {
+			redemption.Status = RedemptionCodeStatusUsed
+		}
+		if redemption.Promotional {
+			if err = recordPromoGrant(tx, userId, PromoSourceCoupon, redemption.Quota, "", redemption.Name); err != nil {
+				return err
+			}
+		}
 		err = tx.Save(redemption).Error
 		return err
 	})
@@ -103,7 +139,7 @@ func (redemption *Redemption) SelectUpdate() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (redemption *Redemption) Update() error {
 	var err error
-	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time").Updates(redemption).Error
+	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time", "expired_time", "max_uses", "promotional").Updates(redemption).Error
 	return err
 }
 
@@ -0,0 +1,185 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// RequestDefaults are the parameters applied to the chat and completion requests of a user
+// which omit them, so the clients need not configure them. The defaults of a token, those with
+// its id, override the defaults of its user, those with token id 0, parameter by parameter.
+type RequestDefaults struct {
+	Id           int      `json:"id"`
+	UserId       int      `json:"user_id" gorm:"uniqueIndex:idx_request_defaults_owner"`
+	TokenId      int      `json:"token_id" gorm:"uniqueIndex:idx_request_defaults_owner;default:0"` // 0 for the defaults of the user
+	Model        string   `json:"model" gorm:"type:varchar(128);default:''"`
+	PinModel     bool     `json:"pin_model" gorm:"default:false"` // the model replaces the one requested
+	Temperature  *float64 `json:"temperature"`
+	TopP         *float64 `json:"top_p"`
+	MaxTokens    *int     `json:"max_tokens"`
+	SystemPrompt string   `json:"system_prompt" gorm:"type:text"` // for the chats without a system message
+	UpdatedTime  int64    `json:"updated_time" gorm:"bigint"`
+}
+
+const requestDefaultsSystemPromptMaxLength = 8192
+
+func (d *RequestDefaults) Validate() error {
+	d.Model = strings.TrimSpace(d.Model)
+	if IsModelPattern(d.Model) {
+		return errors.New("the default model must be a model, not a pattern")
+	}
+	if d.PinModel && d.Model == "" {
+		return errors.New("a model is needed to pin it")
+	}
+	if d.Temperature != nil && (*d.Temperature < 0 || *d.Temperature > 2) {
+		return errors.New("temperature must be between 0 and 2")
+	}
+	if d.TopP != nil && (*d.TopP < 0 || *d.TopP > 1) {
+		return errors.New("top_p must be between 0 and 1")
+	}
+	if d.MaxTokens != nil && *d.MaxTokens <= 0 {
+		return errors.New("max_tokens must be positive")
+	}
+	if len(d.SystemPrompt) > requestDefaultsSystemPromptMaxLength {
+		return fmt.Errorf("system prompt is longer than %d bytes", requestDefaultsSystemPromptMaxLength)
+	}
+	return nil
+}
+
+// IsEmpty tells whether the defaults set no parameter
+func (d *RequestDefaults) IsEmpty() bool {
+	return d.Model == "" && d.Temperature == nil && d.TopP == nil && d.MaxTokens == nil && d.SystemPrompt == ""
+}
+
+// merge returns the defaults overridden by those set in override
+func (d RequestDefaults) merge(override *RequestDefaults) RequestDefaults {
+	if override.Model != "" {
+		d.Model = override.Model
+		d.PinModel = override.PinModel
+	}
+	if override.Temperature != nil {
+		d.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		d.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		d.MaxTokens = override.MaxTokens
+	}
+	if override.SystemPrompt != "" {
+		d.SystemPrompt = override.SystemPrompt
+	}
+	return d
+}
+
+// GetRequestDefaults returns the defaults of a user, or of a token of the user when tokenId is
+// not 0, empty defaults when none were saved
+func GetRequestDefaults(userId int, tokenId int) (*RequestDefaults, error) {
+	defaults := RequestDefaults{UserId: userId, TokenId: tokenId}
+	err := DB.Where("user_id = ? AND token_id = ?", userId, tokenId).Limit(1).Find(&defaults).Error
+	return &defaults, err
+}
+
+// SaveRequestDefaults saves the defaults of a user or of one of its tokens, empty defaults are
+// deleted
+func SaveRequestDefaults(defaults *RequestDefaults) error {
+	if defaults.TokenId != 0 {
+		if _, err := GetTokenByIds(defaults.TokenId, defaults.UserId); err != nil {
+			return err
+		}
+	}
+	if defaults.IsEmpty() {
+		return DeleteRequestDefaults(defaults.UserId, defaults.TokenId)
+	}
+	existing, err := GetRequestDefaults(defaults.UserId, defaults.TokenId)
+	if err != nil {
+		return err
+	}
+	defaults.Id = existing.Id
+	defaults.UpdatedTime = helper.GetTimestamp()
+	if err = DB.Save(defaults).Error; err != nil {
+		return err
+	}
+	invalidateRequestDefaults(defaults.UserId)
+	return nil
+}
+
+func DeleteRequestDefaults(userId int, tokenId int) error {
+	err := DB.Where("user_id = ? AND token_id = ?", userId, tokenId).Delete(&RequestDefaults{}).Error
+	if err != nil {
+		return err
+	}
+	invalidateRequestDefaults(userId)
+	return nil
+}
+
+func getUserRequestDefaults(userId int) ([]*RequestDefaults, error) {
+	var defaults []*RequestDefaults
+	err := DB.Where("user_id = ?", userId).Find(&defaults).Error
+	return defaults, err
+}
+
+func requestDefaultsCacheKey(userId int) string {
+	return fmt.Sprintf("request_defaults:%d", userId)
+}
+
+func invalidateRequestDefaults(userId int) {
+	if !common.RedisEnabled {
+		return
+	}
+	if err := common.RedisDel(requestDefaultsCacheKey(userId)); err != nil {
+		logger.SysError("Redis delete request defaults error: " + err.Error())
+	}
+}
+
+// CacheGetRequestDefaults returns the defaults applied to the requests of a token, those of the
+// token over those of its user, nil when there are none
+func CacheGetRequestDefaults(userId int, tokenId int) (*RequestDefaults, error) {
+	var all []*RequestDefaults
+	cached := false
+	if common.RedisEnabled {
+		if value, err := common.RedisGet(requestDefaultsCacheKey(userId)); err == nil {
+			cached = json.Unmarshal([]byte(value), &all) == nil
+		}
+	}
+	if !cached {
+		var err error
+		if all, err = getUserRequestDefaults(userId); err != nil {
+			return nil, err
+		}
+		if common.RedisEnabled {
+			value, _ := json.Marshal(all)
+			err = common.RedisSet(requestDefaultsCacheKey(userId), string(value), time.Duration(TokenCacheSeconds)*time.Second)
+			if err != nil {
+				logger.SysError("Redis set request defaults error: " + err.Error())
+			}
+		}
+	}
+	var userDefaults, tokenDefaults *RequestDefaults
+	for _, defaults := range all {
+		switch defaults.TokenId {
+		case 0:
+			userDefaults = defaults
+		case tokenId:
+			tokenDefaults = defaults
+		}
+	}
+	if userDefaults == nil && tokenDefaults == nil {
+		return nil, nil
+	}
+	merged := RequestDefaults{UserId: userId, TokenId: tokenId}
+	if userDefaults != nil {
+		merged = merged.merge(userDefaults)
+	}
+	if tokenDefaults != nil {
+		merged = merged.merge(tokenDefaults)
+	}
+	return &merged, nil
+}
@@ -0,0 +1,69 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/trace"
+)
+
+// RequestTrace is the decision timeline of a relay request, saved once it ended
+type RequestTrace struct {
+	Id          int    `json:"id"`
+	RequestId   string `json:"request_id" gorm:"type:varchar(128);index"`
+	CreatedAt   int64  `json:"created_at" gorm:"bigint;index"`
+	UserId      int    `json:"user_id"`
+	TokenId     int    `json:"token_id"`
+	TenantId    int    `json:"tenant_id" gorm:"default:0;index"`
+	Method      string `json:"method" gorm:"type:varchar(16)"`
+	Path        string `json:"path" gorm:"type:varchar(255)"`
+	ModelName   string `json:"model_name" gorm:"type:varchar(255);default:''"`
+	StatusCode  int    `json:"status_code"`
+	ElapsedTime int64  `json:"elapsed_time"` // unit is ms
+	Events      string `json:"-" gorm:"type:text"`
+	Dropped     int    `json:"dropped" gorm:"default:0"` // events over the limit of a trace
+}
+
+// GetEvents returns the events of the trace in their order
+func (t *RequestTrace) GetEvents() []trace.Event {
+	var events []trace.Event
+	if t.Events != "" {
+		_ = json.Unmarshal([]byte(t.Events), &events)
+	}
+	return events
+}
+
+// RecordRequestTrace saves the events of a trace
+func RecordRequestTrace(requestTrace *RequestTrace, events []trace.Event) {
+	data, err := json.Marshal(events)
+	if err != nil {
+		logger.SysError("failed to marshal request trace: " + err.Error())
+		return
+	}
+	requestTrace.Events = string(data)
+	requestTrace.CreatedAt = helper.GetTimestamp()
+	if err = LOG_DB.Create(requestTrace).Error; err != nil {
+		logger.SysError("failed to record request trace: " + err.Error())
+	}
+}
+
+// GetRequestTraces returns the traces of a request id, several when a client reused the id
+func GetRequestTraces(requestId string, tenantId int) (traces []*RequestTrace, err error) {
+	err = LOG_DB.Scopes(tenantScope(tenantId)).Where("request_id = ?", requestId).Order("id").Find(&traces).Error
+	return traces, err
+}
+
+// GetLogsByRequestId returns the logs of a request id
+func GetLogsByRequestId(requestId string, tenantId int) (logs []*Log, err error) {
+	err = LOG_DB.Scopes(tenantScope(tenantId)).Where("request_id = ?", requestId).Order("id").Find(&logs).Error
+	return logs, err
+}
+
+// DeleteExpiredRequestTraces deletes the traces older than REQUEST_TRACE_RETENTION
+func DeleteExpiredRequestTraces(ctx context.Context) error {
+	cutoff := helper.GetTimestamp() - int64(config.RequestTraceRetention)*3600
+	return LOG_DB.WithContext(ctx).Where("created_at < ?", cutoff).Delete(&RequestTrace{}).Error
+}
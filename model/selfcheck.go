@@ -0,0 +1,299 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+const (
+	SelfCheckOk      = "ok"
+	SelfCheckWarning = "warning" // the gateway works, some requests may not go as configured
+	SelfCheckFailed  = "failed"  // some requests will fail
+)
+
+var selfCheckSeverity = map[string]int{SelfCheckOk: 0, SelfCheckWarning: 1, SelfCheckFailed: 2}
+
+// SelfCheckResult is the outcome of a check of the self-check
+type SelfCheckResult struct {
+	Name     string   `json:"name"`
+	Status   string   `json:"status"`
+	Message  string   `json:"message"`
+	Problems []string `json:"problems,omitempty"`
+	Duration int64    `json:"duration"` // unit is ms
+}
+
+func (r *SelfCheckResult) problem(status string, format string, a ...any) {
+	if selfCheckSeverity[status] > selfCheckSeverity[r.Status] {
+		r.Status = status
+	}
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+// SelfCheckReport is the configuration validation run at startup, see /api/selfcheck
+type SelfCheckReport struct {
+	CheckedAt int64             `json:"checked_at"`
+	Status    string            `json:"status"` // the worst status of the checks
+	Checks    []SelfCheckResult `json:"checks"`
+}
+
+var (
+	selfCheckReport     *SelfCheckReport
+	selfCheckReportLock sync.RWMutex
+)
+
+var selfChecks = []struct {
+	name  string
+	check func(ctx context.Context, result *SelfCheckResult)
+}{
+	{"database", checkDatabase},
+	{"redis_scripts", checkRedisScripts},
+	{"channel_base_urls", checkChannelBaseURLs},
+	{"model_mappings", checkModelMappings},
+	{"selection_strategies", checkSelectionStrategies},
+}
+
+// RunSelfCheck validates the database schema and the configuration, logs the report and keeps
+// it for /api/selfcheck
+func RunSelfCheck(ctx context.Context) *SelfCheckReport {
+	report := &SelfCheckReport{
+		CheckedAt: helper.GetTimestamp(),
+		Status:    SelfCheckOk,
+		Checks:    make([]SelfCheckResult, 0, len(selfChecks)),
+	}
+	for _, selfCheck := range selfChecks {
+		result := SelfCheckResult{Name: selfCheck.name, Status: SelfCheckOk}
+		start := time.Now()
+		selfCheck.check(ctx, &result)
+		result.Duration = time.Since(start).Milliseconds()
+		if selfCheckSeverity[result.Status] > selfCheckSeverity[report.Status] {
+			report.Status = result.Status
+		}
+		report.Checks = append(report.Checks, result)
+		logSelfCheckResult(&result)
+	}
+	logger.SysLogf("self-check status=%s", report.Status)
+
+	selfCheckReportLock.Lock()
+	selfCheckReport = report
+	selfCheckReportLock.Unlock()
+	return report
+}
+
+// GetSelfCheckReport returns the report of the last self-check, nil before the first one
+func GetSelfCheckReport() *SelfCheckReport {
+	selfCheckReportLock.RLock()
+	defer selfCheckReportLock.RUnlock()
+	return selfCheckReport
+}
+
+func logSelfCheckResult(result *SelfCheckResult) {
+	line := fmt.Sprintf("self-check check=%s status=%s duration=%dms message=%q", result.Name, result.Status, result.Duration, result.Message)
+	switch result.Status {
+	case SelfCheckOk:
+		logger.SysLog(line)
+		return
+	case SelfCheckWarning:
+		logger.SysWarn(line)
+	default:
+		logger.SysError(line)
+	}
+	for _, problem := range result.Problems {
+		logger.SysWarnf("self-check check=%s problem=%q", result.Name, problem)
+	}
+}
+
+// checkTables checks that the tables of the models and their columns exist, the replicas
+// which are not the master node do not migrate the database
+func checkTables(ctx context.Context, db *gorm.DB, name string, tables []any, result *SelfCheckResult) int {
+	db = db.WithContext(ctx)
+	migrator := db.Migrator()
+	for _, table := range tables {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(table); err != nil {
+			result.problem(SelfCheckFailed, "%s: %s", name, err.Error())
+			continue
+		}
+		tableName := stmt.Schema.Table
+		if !migrator.HasTable(table) {
+			result.problem(SelfCheckFailed, "%s: table %s is missing", name, tableName)
+			continue
+		}
+		columnTypes, err := migrator.ColumnTypes(table)
+		if err != nil {
+			result.problem(SelfCheckFailed, "%s: failed to read the columns of %s: %s", name, tableName, err.Error())
+			continue
+		}
+		columns := make(map[string]bool, len(columnTypes))
+		for _, columnType := range columnTypes {
+			columns[strings.ToLower(columnType.Name())] = true
+		}
+		for _, column := range stmt.Schema.DBNames {
+			if !columns[strings.ToLower(column)] {
+				result.problem(SelfCheckFailed, "%s: column %s.%s is missing", name, tableName, column)
+			}
+		}
+	}
+	return len(tables)
+}
+
+func checkDatabase(ctx context.Context, result *SelfCheckResult) {
+	if sqlDB, err := DB.DB(); err != nil || sqlDB.PingContext(ctx) != nil {
+		result.problem(SelfCheckFailed, "the database is unreachable")
+		result.Message = "database unreachable"
+		return
+	}
+	count := checkTables(ctx, DB, "database", append(dbModels, &UsageRollup{}), result)
+	if LOG_DB != DB {
+		count += checkTables(ctx, LOG_DB, "log database", append(logDBModels, &UsageRollup{}), result)
+	}
+	result.Message = fmt.Sprintf("%d tables checked", count)
+	if result.Status != SelfCheckOk && !config.IsMasterNode {
+		result.Message += ", the master node has not migrated the database to this version yet"
+	}
+}
+
+func checkRedisScripts(ctx context.Context, result *SelfCheckResult) {
+	if !common.RedisEnabled {
+		result.Message = "Redis is not enabled"
+		return
+	}
+	failed := common.GetScriptManager().CheckScripts(ctx)
+	names := make([]string, 0, len(failed))
+	for name := range failed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.problem(SelfCheckFailed, "script %s failed to load: %s", name, failed[name].Error())
+	}
+	result.Message = "scripts loaded"
+	if len(failed) > 0 {
+		result.Message = fmt.Sprintf("%d scripts failed to load", len(failed))
+	}
+}
+
+// selfCheckChannels returns the channels which are not archived, in order
+func selfCheckChannels(ctx context.Context, result *SelfCheckResult) []*Channel {
+	var channels []*Channel
+	err := DB.WithContext(ctx).Select("id", "name", "type", "base_url", "models", "model_mapping", "status").
+		Where("status <> ?", ChannelStatusArchived).Order("id").Find(&channels).Error
+	if err != nil {
+		result.problem(SelfCheckFailed, "failed to read the channels: %s", err.Error())
+		return nil
+	}
+	return channels
+}
+
+func checkChannelBaseURLs(ctx context.Context, result *SelfCheckResult) {
+	channels := selfCheckChannels(ctx, result)
+	for _, channel := range channels {
+		baseURL := channel.GetBaseURL()
+		if baseURL == "" {
+			continue
+		}
+		parsed, err := url.Parse(baseURL)
+		if err != nil {
+			result.problem(SelfCheckFailed, "channel #%d %s: invalid base URL: %s", channel.Id, channel.Name, err.Error())
+			continue
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			result.problem(SelfCheckFailed, "channel #%d %s: base URL %s is not http nor https", channel.Id, channel.Name, baseURL)
+			continue
+		}
+		if parsed.Host == "" {
+			result.problem(SelfCheckFailed, "channel #%d %s: base URL %s has no host", channel.Id, channel.Name, baseURL)
+		}
+	}
+	result.Message = fmt.Sprintf("%d channels checked", len(channels))
+}
+
+func checkModelMappings(ctx context.Context, result *SelfCheckResult) {
+	channels := selfCheckChannels(ctx, result)
+	checked := 0
+	for _, channel := range channels {
+		if channel.ModelMapping == nil || *channel.ModelMapping == "" || *channel.ModelMapping == "{}" {
+			continue
+		}
+		checked++
+		modelMapping := channel.GetModelMapping()
+		if modelMapping == nil {
+			result.problem(SelfCheckFailed, "channel #%d %s: the model mapping is not a JSON object of model names", channel.Id, channel.Name)
+			continue
+		}
+		models := make(map[string]bool)
+		for _, modelName := range strings.Split(channel.Models, ",") {
+			models[strings.TrimSpace(modelName)] = true
+		}
+		from := make([]string, 0, len(modelMapping))
+		for requested := range modelMapping {
+			from = append(from, requested)
+		}
+		sort.Strings(from)
+		for _, requested := range from {
+			upstream := modelMapping[requested]
+			if !models[requested] {
+				result.problem(SelfCheckWarning, "channel #%d %s: %s is mapped but is not a model of the channel, the mapping is never used", channel.Id, channel.Name, requested)
+			}
+			if upstream == "" {
+				result.problem(SelfCheckFailed, "channel #%d %s: %s is mapped to an empty model", channel.Id, channel.Name, requested)
+				continue
+			}
+			if _, ok := CacheGetModelMeta(upstream); ok {
+				continue
+			}
+			if !billingratio.HasModelRatio(upstream, channel.Type) {
+				result.problem(SelfCheckWarning, "channel #%d %s: %s is mapped to %s, a model without ratio nor catalog entry", channel.Id, channel.Name, requested, upstream)
+			}
+		}
+	}
+	result.Message = fmt.Sprintf("%d model mappings checked", checked)
+}
+
+func checkSelectionStrategies(ctx context.Context, result *SelfCheckResult) {
+	names := make([]string, 0, len(StrategyMap))
+	for name := range StrategyMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		strategy := StrategyMap[name]
+		if strategy.HealthWeight < 0 || strategy.SpeedWeight < 0 || strategy.CostWeight < 0 {
+			result.problem(SelfCheckFailed, "strategy %s has a negative weight", name)
+			continue
+		}
+		sum := strategy.HealthWeight + strategy.SpeedWeight + strategy.CostWeight
+		if math.Abs(sum-1) > 0.01 {
+			result.problem(SelfCheckWarning, "the weights of strategy %s sum to %.2f instead of 1", name, sum)
+		}
+	}
+	if _, ok := StrategyMap[config.SelectionStrategy]; config.SelectionStrategy != "" && !ok {
+		result.problem(SelfCheckWarning, "SelectionStrategy %s is unknown, balanced is used", config.SelectionStrategy)
+	}
+	experiments, err := GetAllExperiments()
+	if err != nil {
+		result.problem(SelfCheckFailed, "failed to read the experiments: %s", err.Error())
+	}
+	for _, experiment := range experiments {
+		if experiment.Status != ExperimentStatusEnabled {
+			continue
+		}
+		if err := experiment.Validate(); err != nil {
+			result.problem(SelfCheckFailed, "experiment %s: %s", experiment.Name, err.Error())
+		}
+	}
+	result.Message = fmt.Sprintf("%d strategies and %d experiments checked", len(names), len(experiments))
+}
@@ -0,0 +1,207 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/postprocess"
+)
+
+// Settings are the options changing the behavior of the relay at runtime. They are stored
+// with the options, so they persist, and every replica reloads them every
+// SETTINGS_SYNC_FREQUENCY seconds.
+
+const (
+	SettingTypeBool   = "bool"
+	SettingTypeInt    = "int"
+	SettingTypeFloat  = "float"
+	SettingTypeString = "string"
+)
+
+type Setting struct {
+	Key         string `json:"key"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+type settingDefinition struct {
+	Key         string
+	Type        string
+	Description string
+	// Validate checks a value of the right type, it is optional
+	Validate func(value string) error
+}
+
+var settingDefinitions = []settingDefinition{
+	{Key: "ResponseCacheEnabled", Type: SettingTypeBool, Description: "Cache the responses of identical chat completions"},
+	{Key: "ResponseCacheTTL", Type: SettingTypeInt, Description: "How long a cached response is kept (seconds)", Validate: positiveSetting},
+	{Key: "SemanticCacheEnabled", Type: SettingTypeBool, Description: "Answer chat completions similar to a cached one from the cache"},
+	{Key: "RequestCoalescingEnabled", Type: SettingTypeBool, Description: "Relay once the identical requests in flight at the same time and share the response"},
+	{Key: "RequestTraceEnabled", Type: SettingTypeBool, Description: "Save the decision timeline of every relay request, see /api/requests/:request_id/trace"},
+	{Key: "SemanticCacheThreshold", Type: SettingTypeFloat, Description: "Similarity from which a cached response is used, between 0 and 1", Validate: func(value string) error {
+		threshold, _ := strconv.ParseFloat(value, 64)
+		if threshold <= 0 || threshold > 1 {
+			return fmt.Errorf("must be between 0 and 1")
+		}
+		return nil
+	}},
+	{Key: "AutoModelEnabled", Type: SettingTypeBool, Description: "Resolve the virtual models, such as auto, to a model of the channels"},
+	{Key: "SelectionStrategy", Type: SettingTypeString, Description: "Default strategy of the smart channel selection: balanced, performance, cost or resilient", Validate: func(value string) error {
+		if _, ok := StrategyMap[value]; !ok {
+			return fmt.Errorf("unknown strategy %s", value)
+		}
+		return nil
+	}},
+	{Key: "RetryTimes", Type: SettingTypeInt, Description: "How many other channels a failed request is retried on", Validate: nonNegativeSetting},
+	{Key: "AutomaticDisableChannelEnabled", Type: SettingTypeBool, Description: "Disable the channels failing with a fatal error"},
+	{Key: "AutomaticEnableChannelEnabled", Type: SettingTypeBool, Description: "Enable again the disabled channels passing their test"},
+	{Key: "ChannelDisableThreshold", Type: SettingTypeFloat, Description: "Response time of a channel test above which the channel is disabled (seconds)", Validate: func(value string) error {
+		threshold, _ := strconv.ParseFloat(value, 64)
+		if threshold < 0 {
+			return fmt.Errorf("must not be negative")
+		}
+		return nil
+	}},
+	{Key: "PreConsumedQuota", Type: SettingTypeInt, Description: "Quota reserved by a request before its usage is known", Validate: nonNegativeSetting},
+	{Key: "GroupPreConsumeStrategy", Type: SettingTypeString, Description: "Pre-consumption strategy of each group as a JSON object: none, prompt-only, percentage or full-estimate (the default)", Validate: billingratio.ValidateGroupPreConsumeStrategy},
+	{Key: "GroupPostProcessing", Type: SettingTypeString, Description: "Response post-processing of each group as a JSON object: processors, footer and profanity_words", Validate: postprocess.ValidateGroupPostProcessing},
+	{Key: "WebhookSpendThreshold", Type: SettingTypeInt, Description: "Used quota step sending the spend threshold webhook event, 0 disables it", Validate: nonNegativeSetting},
+}
+
+func positiveSetting(value string) error {
+	if number, _ := strconv.ParseInt(value, 10, 64); number <= 0 {
+		return fmt.Errorf("must be positive")
+	}
+	return nil
+}
+
+func nonNegativeSetting(value string) error {
+	if number, _ := strconv.ParseInt(value, 10, 64); number < 0 {
+		return fmt.Errorf("must not be negative")
+	}
+	return nil
+}
+
+func getSettingDefinition(key string) (settingDefinition, bool) {
+	for _, definition := range settingDefinitions {
+		if definition.Key == key {
+			return definition, true
+		}
+	}
+	return settingDefinition{}, false
+}
+
+// ValidateSetting checks the value of a setting, and returns it in the form it is stored in
+func ValidateSetting(key string, value string) (string, error) {
+	definition, ok := getSettingDefinition(key)
+	if !ok {
+		return "", fmt.Errorf("unknown setting %s", key)
+	}
+	switch definition.Type {
+	case SettingTypeBool:
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be true or false", key)
+		}
+		value = strconv.FormatBool(boolValue)
+	case SettingTypeInt:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "", fmt.Errorf("%s must be an integer", key)
+		}
+	case SettingTypeFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return "", fmt.Errorf("%s must be a number", key)
+		}
+	}
+	if definition.Validate != nil {
+		if err := definition.Validate(value); err != nil {
+			return "", fmt.Errorf("%s %s", key, err.Error())
+		}
+	}
+	return value, nil
+}
+
+// GetSettings returns the current value of the settings
+func GetSettings() []*Setting {
+	config.OptionMapRWMutex.RLock()
+	defer config.OptionMapRWMutex.RUnlock()
+	settings := make([]*Setting, 0, len(settingDefinitions))
+	for _, definition := range settingDefinitions {
+		settings = append(settings, &Setting{
+			Key:         definition.Key,
+			Type:        definition.Type,
+			Value:       config.OptionMap[definition.Key],
+			Description: definition.Description,
+		})
+	}
+	return settings
+}
+
+// UpdateSettings validates all the values before saving them, so a request changes
+// either all its settings or none of them
+func UpdateSettings(values map[string]string) error {
+	validated := make(map[string]string, len(values))
+	for key, value := range values {
+		value, err := ValidateSetting(key, value)
+		if err != nil {
+			return err
+		}
+		validated[key] = value
+	}
+	for _, definition := range settingDefinitions {
+		value, ok := validated[definition.Key]
+		if !ok {
+			continue
+		}
+		if err := UpdateOption(definition.Key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var settingListeners = make(map[string][]func(value string))
+var settingListenersLock sync.RWMutex
+
+// OnSettingChange registers a function called with the new value of an option whenever it
+// changes, whether it was changed on this replica or synced from the database
+func OnSettingChange(key string, listener func(value string)) {
+	settingListenersLock.Lock()
+	defer settingListenersLock.Unlock()
+	settingListeners[key] = append(settingListeners[key], listener)
+}
+
+func notifySettingChange(key string, value string) {
+	settingListenersLock.RLock()
+	listeners := settingListeners[key]
+	settingListenersLock.RUnlock()
+	for _, listener := range listeners {
+		listener(value)
+	}
+}
+
+// SyncSettings reloads the settings changed by other replicas
+func SyncSettings(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		options, err := AllOption()
+		if err != nil {
+			logger.SysError("failed to sync settings: " + err.Error())
+			continue
+		}
+		for _, option := range options {
+			if _, ok := getSettingDefinition(option.Key); !ok {
+				continue
+			}
+			if err = updateOptionMap(option.Key, option.Value); err != nil {
+				logger.SysError("failed to update option map: " + err.Error())
+			}
+		}
+	}
+}
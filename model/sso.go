@@ -0,0 +1,176 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// The users signing in with OIDC get the role, group and organization their identity
+// provider groups are mapped to, see SSOMapping. An organization may enforce SSO, then its
+// members, except the root user, can only sign in with OIDC.
+
+// SSOMapping maps the groups of an identity provider, read from a claim of the user, to
+// the role, group and organization of the user
+type SSOMapping struct {
+	// Claim is the claim listing the groups of the user, "groups" by default
+	Claim string `json:"claim,omitempty"`
+	// Roles maps groups to admin or common. When it is set, the role of a user is
+	// reassigned at every sign-in, admin if a group of the user maps to admin
+	Roles map[string]string `json:"roles,omitempty"`
+	// Groups maps groups to a group of the gateway, the first group of the user mapped
+	// is assigned
+	Groups map[string]string `json:"groups,omitempty"`
+	// Organizations maps groups to the id of an organization the user joins as member,
+	// if they are in none
+	Organizations map[string]int `json:"organizations,omitempty"`
+}
+
+var ssoMapping SSOMapping
+var ssoMappingLock sync.RWMutex
+
+func (m *SSOMapping) Validate() error {
+	for group, role := range m.Roles {
+		if role != "admin" && role != "common" {
+			return fmt.Errorf("group %s: role must be admin or common", group)
+		}
+	}
+	for group, userGroup := range m.Groups {
+		if userGroup == "" || len(userGroup) > 32 {
+			return fmt.Errorf("group %s: invalid user group", group)
+		}
+	}
+	for group, organizationId := range m.Organizations {
+		if organizationId <= 0 {
+			return fmt.Errorf("group %s: invalid organization id", group)
+		}
+	}
+	return nil
+}
+
+func SSOMapping2JSONString() string {
+	ssoMappingLock.RLock()
+	defer ssoMappingLock.RUnlock()
+	jsonBytes, err := json.Marshal(ssoMapping)
+	if err != nil {
+		logger.SysError("error marshalling sso mapping: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateSSOMappingByJSONString(jsonStr string) error {
+	mapping := SSOMapping{}
+	if err := json.Unmarshal([]byte(jsonStr), &mapping); err != nil {
+		return err
+	}
+	if err := mapping.Validate(); err != nil {
+		return err
+	}
+	ssoMappingLock.Lock()
+	ssoMapping = mapping
+	ssoMappingLock.Unlock()
+	return nil
+}
+
+func getSSOMapping() SSOMapping {
+	ssoMappingLock.RLock()
+	defer ssoMappingLock.RUnlock()
+	return ssoMapping
+}
+
+// SSOGroups returns the identity provider groups listed by the claims of a user. The claim
+// is a list of strings or a string of groups separated by commas or spaces.
+func SSOGroups(claims map[string]any) []string {
+	claim := getSSOMapping().Claim
+	if claim == "" {
+		claim = "groups"
+	}
+	var groups []string
+	switch value := claims[claim].(type) {
+	case []any:
+		for _, item := range value {
+			if group, ok := item.(string); ok && group != "" {
+				groups = append(groups, group)
+			}
+		}
+	case string:
+		groups = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	return groups
+}
+
+// ApplySSOGroups updates the role, group and organization of a user signing in with the
+// identity provider groups of the user
+func (user *User) ApplySSOGroups(groups []string) error {
+	mapping := getSSOMapping()
+	var fields []string
+	if len(mapping.Roles) > 0 && user.Role != RoleRootUser {
+		role := RoleCommonUser
+		for _, group := range groups {
+			if mapping.Roles[group] == "admin" {
+				role = RoleAdminUser
+			}
+		}
+		if role != user.Role {
+			user.Role = role
+			fields = append(fields, "role")
+		}
+	}
+	for _, group := range groups {
+		if userGroup, ok := mapping.Groups[group]; ok {
+			if userGroup != user.Group {
+				user.Group = userGroup
+				fields = append(fields, "group")
+			}
+			break
+		}
+	}
+	if len(fields) > 0 {
+		if err := DB.Model(user).Select(fields).Updates(user).Error; err != nil {
+			return err
+		}
+	}
+	for _, group := range groups {
+		organizationId, ok := mapping.Organizations[group]
+		if !ok {
+			continue
+		}
+		if _, err := GetUserOrganizationMember(user.Id); err == nil {
+			break
+		}
+		// a stale mapping does not prevent the sign-in
+		if _, err := GetOrganizationById(organizationId); err != nil {
+			logger.SysError(fmt.Sprintf("sso group %s maps to organization #%d: %s", group, organizationId, err.Error()))
+		} else if err = AddOrganizationMember(organizationId, user.Id, OrganizationRoleMember); err != nil {
+			logger.SysError(fmt.Sprintf("failed to add user #%d to organization #%d: %s", user.Id, organizationId, err.Error()))
+		}
+		break
+	}
+	return nil
+}
+
+// ErrSSORequired is returned when a user signs in without SSO while their organization
+// enforces it
+var ErrSSORequired = errors.New("your organization requires signing in with SSO")
+
+// CheckSSOEnforced returns ErrSSORequired if the organization of the user enforces SSO
+func CheckSSOEnforced(user *User) error {
+	if user.Role == RoleRootUser {
+		return nil
+	}
+	member, err := GetUserOrganizationMember(user.Id)
+	if err != nil {
+		return nil
+	}
+	organization, err := GetOrganizationById(member.OrganizationId)
+	if err == nil && organization.SSOEnforced {
+		return ErrSSORequired
+	}
+	return nil
+}
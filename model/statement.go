@@ -0,0 +1,257 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/currency"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/pdf"
+)
+
+// A statement sums the usage of a user, or of the members of an organization, over a
+// calendar month in UTC, per model and token, from the usage rollups.
+
+const statementMonthLayout = "2006-01"
+
+// Statement is the usage of a month, Items are per model and token
+type Statement struct {
+	Month          string          `json:"month"`
+	Start          int64           `json:"start"` // the month is [Start, End)
+	End            int64           `json:"end"`
+	UserId         int             `json:"user_id,omitempty"`
+	OrganizationId int             `json:"organization_id,omitempty"`
+	Name           string          `json:"name"` // username or organization name
+	Currency       string          `json:"currency"`
+	Items          []*UsageSummary `json:"items"`
+	Total          *UsageSummary   `json:"total"`
+	GeneratedAt    int64           `json:"generated_at"`
+}
+
+// ParseStatementMonth returns the start and the end of a month formatted as 2006-01, the
+// current month when it is empty
+func ParseStatementMonth(month string) (start int64, end int64, err error) {
+	if month == "" {
+		month = time.Now().UTC().Format(statementMonthLayout)
+	}
+	t, err := time.Parse(statementMonthLayout, month)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid month %s, use the format 2006-01", month)
+	}
+	if t.After(time.Now()) {
+		return 0, 0, errors.New("the month is in the future")
+	}
+	return t.Unix(), t.AddDate(0, 1, 0).Unix(), nil
+}
+
+func newStatement(month string, code string, getSummaries func(UsageRollupFilter, []string) ([]*UsageSummary, error)) (*Statement, error) {
+	start, end, err := ParseStatementMonth(month)
+	if err != nil {
+		return nil, err
+	}
+	// the rollups are hourly, the last one of the month starts an hour before its end
+	filter := UsageRollupFilter{Start: start, End: end - 1}
+	items, err := getSummaries(filter, []string{UsageGroupModel, UsageGroupToken})
+	if err != nil {
+		return nil, err
+	}
+	totals, err := getSummaries(filter, nil)
+	if err != nil {
+		return nil, err
+	}
+	total := &UsageSummary{}
+	if len(totals) > 0 {
+		total = totals[0]
+	}
+	for _, summary := range append(items, total) {
+		summary.Cost = currency.FromQuota(summary.Quota, code)
+		summary.CacheSavedCost = currency.FromQuota(summary.CacheSavedQuota, code)
+		summary.Currency = code
+	}
+	return &Statement{
+		Month:       time.Unix(start, 0).UTC().Format(statementMonthLayout),
+		Start:       start,
+		End:         end,
+		Currency:    code,
+		Items:       items,
+		Total:       total,
+		GeneratedAt: time.Now().Unix(),
+	}, nil
+}
+
+// GetUserStatement returns the statement of a user for a month, in a currency
+func GetUserStatement(userId int, month string, code string) (*Statement, error) {
+	user, err := GetUserById(userId, false)
+	if err != nil {
+		return nil, err
+	}
+	statement, err := newStatement(month, code, func(filter UsageRollupFilter, groupBy []string) ([]*UsageSummary, error) {
+		filter.UserId = userId
+		return GetUsageSummaries(filter, groupBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	statement.UserId = user.Id
+	statement.Name = user.Username
+	return statement, nil
+}
+
+// GetOrganizationStatement returns the statement of the members of an organization for a
+// month, in a currency
+func GetOrganizationStatement(organizationId int, month string, code string) (*Statement, error) {
+	organization, err := GetOrganizationById(organizationId)
+	if err != nil {
+		return nil, err
+	}
+	statement, err := newStatement(month, code, func(filter UsageRollupFilter, groupBy []string) ([]*UsageSummary, error) {
+		return GetOrganizationUsage(organizationId, filter, groupBy)
+	})
+	if err != nil {
+		return nil, err
+	}
+	statement.OrganizationId = organization.Id
+	statement.Name = organization.Name
+	return statement, nil
+}
+
+func truncate(text string, length int) string {
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return string(runes[:length-1]) + "~"
+}
+
+// PDF renders the statement as a PDF document
+func (s *Statement) PDF() []byte {
+	doc := pdf.New()
+	doc.Line(pdf.Bold, 16, fmt.Sprintf("%s usage statement", config.SystemName))
+	doc.Space(6)
+	account := fmt.Sprintf("User: %s (#%d)", s.Name, s.UserId)
+	if s.OrganizationId != 0 {
+		account = fmt.Sprintf("Organization: %s (#%d)", s.Name, s.OrganizationId)
+	}
+	doc.Line(pdf.Regular, 10, account)
+	doc.Line(pdf.Regular, 10, fmt.Sprintf("Period: %s to %s (UTC)",
+		time.Unix(s.Start, 0).UTC().Format("2006-01-02"), time.Unix(s.End-1, 0).UTC().Format("2006-01-02")))
+	doc.Line(pdf.Regular, 10, fmt.Sprintf("Currency: %s", s.Currency))
+	doc.Line(pdf.Regular, 10, fmt.Sprintf("Generated: %s", time.Unix(s.GeneratedAt, 0).UTC().Format("2006-01-02 15:04 UTC")))
+	doc.Space(10)
+
+	// 117 columns of Courier fit the width of the page at 7 points
+	row := "%-26s %-16s %8s %11s %11s %11s %13s %13s"
+	rule := strings.Repeat("-", 117)
+	doc.Line(pdf.Mono, 7, fmt.Sprintf(row, "Model", "Token", "Requests", "Prompt", "Completion", "Cached", "Cost", "Cache saved"))
+	doc.Line(pdf.Mono, 7, rule)
+	line := func(modelName string, tokenName string, summary *UsageSummary) string {
+		return fmt.Sprintf(row, truncate(modelName, 26), truncate(tokenName, 16), fmt.Sprint(summary.Requests),
+			fmt.Sprint(summary.PromptTokens), fmt.Sprint(summary.CompletionTokens), fmt.Sprint(summary.CachedTokens),
+			currency.FormatAmount(summary.Cost, s.Currency), currency.FormatAmount(summary.CacheSavedCost, s.Currency))
+	}
+	for _, item := range s.Items {
+		doc.Line(pdf.Mono, 7, line(item.ModelName, item.TokenName, item))
+	}
+	doc.Line(pdf.Mono, 7, rule)
+	doc.Line(pdf.Mono, 7, line("Total", "", s.Total))
+	return doc.Bytes()
+}
+
+// Email sends the statement, with its PDF attached, to an email address
+func (s *Statement) Email(email string) error {
+	subject := fmt.Sprintf("%s %s 账单", config.SystemName, s.Month)
+	content := message.EmailTemplate(
+		subject,
+		fmt.Sprintf(`
+			<p>您好！</p>
+			<p>%s 在 %s 共请求 <strong>%d</strong> 次，消费 <strong>%s</strong>，缓存节省 <strong>%s</strong>。</p>
+			<p>各模型和令牌的明细见附件。</p>
+		`, s.Name, s.Month, s.Total.Requests, currency.Format(s.Total.Cost, s.Currency), currency.Format(s.Total.CacheSavedCost, s.Currency)),
+	)
+	return message.SendEmailWithAttachments(subject, email, content, []message.Attachment{{
+		Name:        s.FileName(),
+		ContentType: "application/pdf",
+		Data:        s.PDF(),
+	}})
+}
+
+// FileName is the name of the PDF of the statement
+func (s *Statement) FileName() string {
+	if s.OrganizationId != 0 {
+		return fmt.Sprintf("statement-organization-%d-%s.pdf", s.OrganizationId, s.Month)
+	}
+	return fmt.Sprintf("statement-%d-%s.pdf", s.UserId, s.Month)
+}
+
+// EmailStatements emails the statements of a month to the users who used the gateway and
+// have an email, and those of their organizations to the owners
+func EmailStatements(month string) {
+	start, end, err := ParseStatementMonth(month)
+	if err != nil {
+		logger.SysError("failed to email statements: " + err.Error())
+		return
+	}
+	var userIds []int
+	err = LOG_DB.Model(&UsageRollup{}).Where("hour >= ? AND hour < ? AND requests > 0", start, end).Distinct().Pluck("user_id", &userIds).Error
+	if err != nil || len(userIds) == 0 {
+		if err != nil {
+			logger.SysError("failed to email statements: " + err.Error())
+		}
+		return
+	}
+	var users []*User
+	if err = DB.Omit("password", "access_token").Where("id IN ? AND email <> ''", userIds).Find(&users).Error; err != nil {
+		logger.SysError("failed to email statements: " + err.Error())
+		return
+	}
+	for _, user := range users {
+		statement, err := GetUserStatement(user.Id, month, currency.OfGroup(user.Group))
+		if err == nil {
+			err = statement.Email(user.Email)
+		}
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to email the statement of user #%d: %s", user.Id, err.Error()))
+		}
+	}
+	var organizationIds []int
+	err = DB.Model(&OrganizationMember{}).Where("user_id IN ?", userIds).Distinct().Pluck("organization_id", &organizationIds).Error
+	if err != nil {
+		logger.SysError("failed to email statements: " + err.Error())
+		return
+	}
+	for _, organizationId := range organizationIds {
+		var owners []*OrganizationMember
+		if err = DB.Where("organization_id = ? AND role = ?", organizationId, OrganizationRoleOwner).Find(&owners).Error; err != nil {
+			logger.SysError(fmt.Sprintf("failed to email the statement of organization #%d: %s", organizationId, err.Error()))
+			continue
+		}
+		for _, owner := range owners {
+			user, err := GetUserById(owner.UserId, false)
+			if err != nil || user.Email == "" {
+				continue
+			}
+			statement, err := GetOrganizationStatement(organizationId, month, currency.OfGroup(user.Group))
+			if err == nil {
+				err = statement.Email(user.Email)
+			}
+			if err != nil {
+				logger.SysError(fmt.Sprintf("failed to email the statement of organization #%d: %s", organizationId, err.Error()))
+			}
+		}
+	}
+}
+
+// SyncStatementEmails emails the statements of the last month at the start of every month,
+// an hour late so the rollups of its last hour are flushed
+func SyncStatementEmails() {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month()+1, 1, 1, 0, 0, 0, time.UTC)
+		time.Sleep(next.Sub(now))
+		EmailStatements(next.AddDate(0, -1, 0).Format(statementMonthLayout))
+	}
+}
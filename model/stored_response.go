@@ -0,0 +1,101 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	StoredResponseStatusQueued     = "queued"
+	StoredResponseStatusInProgress = "in_progress"
+	StoredResponseStatusCompleted  = "completed"
+	StoredResponseStatusFailed     = "failed"
+	StoredResponseStatusCancelled  = "cancelled"
+)
+
+// a background response not finished within this time was lost with its node
+const storedResponseStaleTime = 3600 // unit is second
+
+// StoredResponse is a response of the responses API kept for retrieval, background
+// runs and previous_response_id
+type StoredResponse struct {
+	Id        string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	Status    string `json:"status" gorm:"type:varchar(16);index"`
+	Input     string `json:"-" gorm:"type:text"` // input items, those of the previous responses included
+	Response  string `json:"-" gorm:"type:text"` // response object
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	UpdatedAt int64  `json:"updated_at" gorm:"bigint"`
+}
+
+func (r *StoredResponse) Insert() error {
+	now := helper.GetTimestamp()
+	r.CreatedAt = now
+	r.UpdatedAt = now
+	return DB.Create(r).Error
+}
+
+// Finished tells whether the response won't change anymore
+func (r *StoredResponse) Finished() bool {
+	return r.Status != StoredResponseStatusQueued && r.Status != StoredResponseStatusInProgress
+}
+
+func GetStoredResponse(id string, userId int) (*StoredResponse, error) {
+	if id == "" {
+		return nil, errors.New("id is empty")
+	}
+	var response StoredResponse
+	err := DB.Where("id = ? AND user_id = ?", id, userId).First(&response).Error
+	return &response, err
+}
+
+// FinishStoredResponse saves the outcome of a background response, unless it was cancelled
+func FinishStoredResponse(id string, status string, response string) error {
+	return DB.Model(&StoredResponse{}).
+		Where("id = ? AND status IN ?", id, []string{StoredResponseStatusQueued, StoredResponseStatusInProgress}).
+		Updates(map[string]any{"status": status, "response": response, "updated_at": helper.GetTimestamp()}).Error
+}
+
+// CancelStoredResponse cancels a background response, false if it is finished already
+func CancelStoredResponse(id string, userId int) (bool, error) {
+	result := DB.Model(&StoredResponse{}).
+		Where("id = ? AND user_id = ? AND status IN ?", id, userId, []string{StoredResponseStatusQueued, StoredResponseStatusInProgress}).
+		Updates(map[string]any{"status": StoredResponseStatusCancelled, "updated_at": helper.GetTimestamp()})
+	return result.RowsAffected > 0, result.Error
+}
+
+func DeleteStoredResponse(id string, userId int) (bool, error) {
+	result := DB.Where("id = ? AND user_id = ?", id, userId).Delete(&StoredResponse{})
+	return result.RowsAffected > 0, result.Error
+}
+
+// InitResponseStore periodically deletes the expired responses on the master node
+func InitResponseStore() {
+	if !config.IsMasterNode {
+		return
+	}
+	go func() {
+		for {
+			cleanStoredResponses()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func cleanStoredResponses() {
+	now := helper.GetTimestamp()
+	err := DB.Model(&StoredResponse{}).
+		Where("status IN ? AND updated_at < ?", []string{StoredResponseStatusQueued, StoredResponseStatusInProgress}, now-storedResponseStaleTime).
+		Updates(map[string]any{"status": StoredResponseStatusFailed, "updated_at": now}).Error
+	if err != nil {
+		logger.SysError("failed to fail stale stored responses: " + err.Error())
+	}
+	cutoff := now - int64(config.ResponseStoreRetention)*3600
+	if err = DB.Where("created_at < ?", cutoff).Delete(&StoredResponse{}).Error; err != nil {
+		logger.SysError("failed to delete expired stored responses: " + err.Error())
+	}
+}
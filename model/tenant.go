@@ -0,0 +1,200 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// A tenant is a customer isolated on a shared installation: its users, their tokens and logs
+// and its channels carry its id, its requests are only relayed by its channels and its admins
+// only manage its channels, users and logs. The installation itself is the tenant 0, the
+// rows created before the tenants belong to it, and its admins manage every tenant. The
+// tenant of a user or a channel is set when it is created and doesn't change.
+
+const (
+	TenantStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	TenantStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	DefaultTenantId = 0
+	AllTenants      = -1 // the admins of the installation see the rows of every tenant
+)
+
+type Tenant struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Description string `json:"description" gorm:"type:varchar(255);default:''"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+func (t *Tenant) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("the tenant name is empty")
+	}
+	if len(t.Name) > 64 {
+		return errors.New("tenant names are at most 64 characters long")
+	}
+	if t.Status == 0 {
+		t.Status = TenantStatusEnabled
+	}
+	if t.Status != TenantStatusEnabled && t.Status != TenantStatusDisabled {
+		return fmt.Errorf("invalid status %d, use 1 (enabled) or 2 (disabled)", t.Status)
+	}
+	return nil
+}
+
+func GetAllTenants() ([]*Tenant, error) {
+	var tenants []*Tenant
+	err := DB.Order("id asc").Find(&tenants).Error
+	return tenants, err
+}
+
+func GetTenantById(id int) (*Tenant, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	tenant := Tenant{Id: id}
+	err := DB.First(&tenant, "id = ?", id).Error
+	return &tenant, err
+}
+
+func (t *Tenant) Insert() error {
+	t.CreatedTime = helper.GetTimestamp()
+	t.UpdatedTime = t.CreatedTime
+	if err := DB.Create(t).Error; err != nil {
+		return err
+	}
+	PublishInvalidation(InvalidationTenants, "")
+	return nil
+}
+
+func (t *Tenant) Update() error {
+	t.UpdatedTime = helper.GetTimestamp()
+	if err := DB.Model(t).Select("name", "description", "status", "updated_time").Updates(t).Error; err != nil {
+		return err
+	}
+	PublishInvalidation(InvalidationTenants, "")
+	return nil
+}
+
+// Delete deletes a tenant which has no users nor channels left
+func (t *Tenant) Delete() error {
+	var users, channels int64
+	if err := DB.Model(&User{}).Where("tenant_id = ? AND status <> ?", t.Id, UserStatusDeleted).Count(&users).Error; err != nil {
+		return err
+	}
+	if err := DB.Model(&Channel{}).Where("tenant_id = ?", t.Id).Count(&channels).Error; err != nil {
+		return err
+	}
+	if users > 0 || channels > 0 {
+		return fmt.Errorf("the tenant still has %d users and %d channels", users, channels)
+	}
+	if err := DB.Delete(t).Error; err != nil {
+		return err
+	}
+	PublishInvalidation(InvalidationTenants, "")
+	return nil
+}
+
+// tenantScope restricts a query to the rows of a tenant, it leaves it as is for AllTenants
+func tenantScope(tenantId int) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if tenantId == AllTenants {
+			return tx
+		}
+		return tx.Where("tenant_id = ?", tenantId)
+	}
+}
+
+var tenantStatuses map[int]int
+var tenantSyncLock sync.RWMutex
+
+// InitTenantCache loads the statuses of the tenants into memory
+func InitTenantCache() {
+	var tenants []*Tenant
+	if err := DB.Select("id", "status").Find(&tenants).Error; err != nil {
+		logger.SysError("failed to load tenants: " + err.Error())
+		return
+	}
+	statuses := make(map[int]int, len(tenants))
+	for _, tenant := range tenants {
+		statuses[tenant.Id] = tenant.Status
+	}
+	tenantSyncLock.Lock()
+	tenantStatuses = statuses
+	tenantSyncLock.Unlock()
+}
+
+func SyncTenantCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitTenantCache()
+	}
+}
+
+// CacheIsTenantEnabled tells whether the users of a tenant may sign in and use their tokens,
+// the installation always is
+func CacheIsTenantEnabled(tenantId int) bool {
+	if tenantId == DefaultTenantId {
+		return true
+	}
+	tenantSyncLock.RLock()
+	defer tenantSyncLock.RUnlock()
+	return tenantStatuses[tenantId] == TenantStatusEnabled
+}
+
+func GetChannelTenantId(id int) (tenantId int, err error) {
+	err = DB.Model(&Channel{}).Where("id = ?", id).Select("tenant_id").Find(&tenantId).Error
+	return tenantId, err
+}
+
+func GetUserTenantId(id int) (tenantId int, err error) {
+	err = DB.Model(&User{}).Where("id = ?", id).Select("tenant_id").Find(&tenantId).Error
+	return tenantId, err
+}
+
+// CacheGetUserTenantId returns the tenant of a user, which doesn't change
+func CacheGetUserTenantId(id int) (tenantId int, err error) {
+	if !common.RedisEnabled {
+		return GetUserTenantId(id)
+	}
+	tenant, err := common.RedisGet(fmt.Sprintf("user_tenant:%d", id))
+	if err == nil {
+		return helper.String2Int(tenant), nil
+	}
+	tenantId, err = GetUserTenantId(id)
+	if err != nil {
+		return 0, err
+	}
+	err = common.RedisSet(fmt.Sprintf("user_tenant:%d", id), fmt.Sprintf("%d", tenantId), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user tenant error: " + err.Error())
+	}
+	return tenantId, nil
+}
+
+// tenantOfUser returns the tenant of the user of a record, the installation when it is unknown
+func tenantOfUser(userId int) int {
+	if userId == 0 {
+		return DefaultTenantId
+	}
+	tenantId, err := CacheGetUserTenantId(userId)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to get the tenant of user #%d: %s", userId, err.Error()))
+		return DefaultTenantId
+	}
+	return tenantId
+}
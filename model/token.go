@@ -3,6 +3,9 @@ package model
 import (
 	"errors"
 	"fmt"
+	"path"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 
@@ -11,6 +14,7 @@ import (
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/message"
+	"github.com/songquanpeng/one-api/common/random"
 )
 
 const (
@@ -18,6 +22,7 @@ const (
 	TokenStatusDisabled  = 2 // also don't use 0
 	TokenStatusExpired   = 3
 	TokenStatusExhausted = 4
+	TokenStatusArchived  = 5 // deleted, restored or purged by the owner
 )
 
 type Token struct {
@@ -32,8 +37,29 @@ type Token struct {
 	RemainQuota    int64   `json:"remain_quota" gorm:"bigint;default:0"`
 	UnlimitedQuota bool    `json:"unlimited_quota" gorm:"default:false"`
 	UsedQuota      int64   `json:"used_quota" gorm:"bigint;default:0"` // used quota
-	Models         *string `json:"models" gorm:"type:text"`            // allowed models
+	Models         *string `json:"models" gorm:"type:text"`            // allowed models, names or patterns such as gpt-4o*
 	Subnet         *string `json:"subnet" gorm:"default:''"`           // allowed subnet
+	Endpoints      *string `json:"endpoints" gorm:"default:''"`        // allowed endpoints, see TokenEndpoints
+	Groups         *string `json:"groups" gorm:"default:''"`           // allowed user groups
+
+	StructuredOutput  bool   `json:"structured_output" gorm:"default:false"` // validate and repair json_schema and json_object outputs
+	MaxStreamDuration int64  `json:"max_stream_duration" gorm:"default:0"`   // unit is second, 0 is unlimited
+	PromptTemplateId  int    `json:"prompt_template_id" gorm:"default:0"`    // system prompt template, 0 for the template of the group
+	PostProcessors    string `json:"post_processors" gorm:"default:''"`      // comma separated response post-processors, empty for those of the group
+	Priority          string `json:"priority" gorm:"default:''"`             // interactive, standard or batch, empty for standard
+
+	OrganizationId int `json:"organization_id" gorm:"default:0;index"` // issued by the organization, which pays for it
+	TenantId       int `json:"tenant_id" gorm:"default:0;index"`       // the tenant of the user
+
+	LastUsedTime           int64  `json:"last_used_time" gorm:"bigint;default:0"`
+	LastUsedIp             string `json:"last_used_ip" gorm:"type:varchar(45);default:''"`
+	PreviousKey            string `json:"-" gorm:"type:varchar(48);index;default:''"`        // the key before the last rotation
+	PreviousKeyExpiredTime int64  `json:"previous_key_expired_time" gorm:"bigint;default:0"` // until when the previous key works
+
+	RequireSignature bool   `json:"require_signature" gorm:"default:false"`            // requests must be signed with the signing secret
+	SigningSecret    string `json:"signing_secret" gorm:"type:varchar(64);default:''"` // generated when the signature is required
+
+	ArchivedTime int64 `json:"archived_time" gorm:"bigint;default:0"`
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int, order string) ([]*Token, error) {
@@ -49,13 +75,22 @@ func GetAllUserTokens(userId int, startIdx int, num int, order string) ([]*Token
 	default:
 		query = query.Order("id desc")
 	}
+	query = query.Where("status <> ?", TokenStatusArchived)
 
 	err = query.Limit(num).Offset(startIdx).Find(&tokens).Error
 	return tokens, err
 }
 
+// GetArchivedUserTokens lists the archived tokens of a user, the latest archived first
+func GetArchivedUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
+	var tokens []*Token
+	err := DB.Where("user_id = ? AND status = ?", userId, TokenStatusArchived).Order("archived_time desc, id desc").
+		Limit(num).Offset(startIdx).Find(&tokens).Error
+	return tokens, err
+}
+
 func SearchUserTokens(userId int, keyword string) (tokens []*Token, err error) {
-	err = DB.Where("user_id = ?", userId).Where("name LIKE ?", keyword+"%").Find(&tokens).Error
+	err = DB.Where("user_id = ? AND status <> ?", userId, TokenStatusArchived).Where("name LIKE ?", keyword+"%").Find(&tokens).Error
 	return tokens, err
 }
 
@@ -63,6 +98,9 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	if key == "" {
 		return nil, errors.New("未提供令牌")
 	}
+	if random.IsPrefixedKey(key) && !random.CheckPrefixedKey(key) {
+		return nil, errors.New("无效的令牌")
+	}
 	token, err = CacheGetTokenByKey(key)
 	if err != nil {
 		logger.SysError("CacheGetTokenByKey failed: " + err.Error())
@@ -71,10 +109,16 @@ func ValidateUserToken(key string) (token *Token, err error) {
 		}
 		return nil, errors.New("令牌验证失败")
 	}
+	if token.Key != key && token.PreviousKeyExpiredTime < helper.GetTimestamp() {
+		return nil, errors.New("无效的令牌")
+	}
+	if token.Status == TokenStatusArchived {
+		return nil, errors.New("该令牌已删除")
+	}
 	if token.Status == TokenStatusExhausted {
 		return nil, fmt.Errorf("令牌 %s（#%d）额度已用尽", token.Name, token.Id)
 	} else if token.Status == TokenStatusExpired {
-		return nil, errors.New("该令牌已过期")
+		return nil, tokenExpiredError(token)
 	}
 	if token.Status != TokenStatusEnabled {
 		return nil, errors.New("该令牌状态不可用")
@@ -87,7 +131,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 				logger.SysError("failed to update token status" + err.Error())
 			}
 		}
-		return nil, errors.New("该令牌已过期")
+		return nil, tokenExpiredError(token)
 	}
 	if !token.UnlimitedQuota && token.RemainQuota <= 0 {
 		if !common.RedisEnabled {
@@ -103,6 +147,36 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	return token, nil
 }
 
+func tokenExpiredError(token *Token) error {
+	if token.ExpiredTime == -1 {
+		return fmt.Errorf("令牌 %s（#%d）已过期", token.Name, token.Id)
+	}
+	return fmt.Errorf("令牌 %s（#%d）已于 %s 过期", token.Name, token.Id, time.Unix(token.ExpiredTime, 0).Format("2006-01-02 15:04:05"))
+}
+
+// TokenEndpoints are the endpoints a token can be restricted to
+var TokenEndpoints = []string{"chat", "completions", "embeddings", "images", "audio", "moderations", "responses", "batches", "proxy"}
+
+// IsModelAllowed tells whether a model matches the comma separated names and patterns of
+// the allowed models of a token, patterns use the syntax of path.Match
+func IsModelAllowed(modelName string, models string) bool {
+	for _, pattern := range strings.Split(models, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == modelName {
+			return true
+		}
+		if matched, _ := path.Match(pattern, modelName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsModelPattern tells whether an allowed model of a token is a pattern
+func IsModelPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -125,6 +199,10 @@ func GetTokenById(id int) (*Token, error) {
 
 func (t *Token) Insert() error {
 	var err error
+	t.ensureSigningSecret()
+	if t.TenantId, err = GetUserTenantId(t.UserId); err != nil {
+		return err
+	}
 	err = DB.Create(t).Error
 	return err
 }
@@ -132,7 +210,11 @@ func (t *Token) Insert() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (t *Token) Update() error {
 	var err error
-	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet").Updates(t).Error
+	t.ensureSigningSecret()
+	err = DB.Model(t).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet", "endpoints", "groups", "structured_output", "max_stream_duration", "prompt_template_id", "post_processors", "priority", "require_signature", "signing_secret").Updates(t).Error
+	if err == nil {
+		PublishInvalidation(InvalidationToken, t.Key)
+	}
 	return err
 }
 
@@ -141,12 +223,43 @@ func (t *Token) SelectUpdate() error {
 	return DB.Model(t).Select("accessed_time", "status").Updates(t).Error
 }
 
+// Delete archives the token: its key stops working and it is no longer listed, until it is
+// restored or purged
 func (t *Token) Delete() error {
-	var err error
-	err = DB.Delete(t).Error
+	t.Status = TokenStatusArchived
+	t.ArchivedTime = helper.GetTimestamp()
+	err := DB.Model(t).Select("status", "archived_time").Updates(t).Error
+	if err == nil {
+		PublishInvalidation(InvalidationToken, t.Key)
+	}
+	return err
+}
+
+// Restore brings an archived token back, disabled until its owner enables it
+func (t *Token) Restore() error {
+	if t.Status != TokenStatusArchived {
+		return errors.New("令牌未被删除")
+	}
+	t.Status = TokenStatusDisabled
+	t.ArchivedTime = 0
+	err := DB.Model(t).Select("status", "archived_time").Updates(t).Error
+	if err == nil {
+		PublishInvalidation(InvalidationToken, t.Key)
+	}
 	return err
 }
 
+// Purge deletes an archived token for good, its logs keep its name
+func (t *Token) Purge() error {
+	if t.Status != TokenStatusArchived {
+		return errors.New("请先删除令牌")
+	}
+	if err := DeleteRequestDefaults(t.UserId, t.Id); err != nil {
+		return err
+	}
+	return DB.Delete(t).Error
+}
+
 func (t *Token) GetModels() string {
 	if t == nil {
 		return ""
@@ -167,6 +280,9 @@ func DeleteTokenById(id int, userId int) (err error) {
 	if err != nil {
 		return err
 	}
+	if token.Status == TokenStatusArchived {
+		return errors.New("令牌已被删除")
+	}
 	return token.Delete()
 }
 
@@ -225,6 +341,9 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
 		return errors.New("令牌额度不足")
 	}
+	if token.OrganizationId != 0 {
+		return preConsumeOrganizationQuota(token, quota)
+	}
 	userQuota, err := GetUserQuota(token.UserId)
 	if err != nil {
 		return err
@@ -234,6 +353,13 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	}
 	quotaTooLow := userQuota >= config.QuotaRemindThreshold && userQuota-quota < config.QuotaRemindThreshold
 	noMoreQuota := userQuota-quota <= 0
+	if noMoreQuota {
+		EmitWebhookEvent(WebhookEventQuotaExhausted, map[string]any{
+			"user_id":  token.UserId,
+			"token_id": tokenId,
+			"quota":    userQuota - quota,
+		})
+	}
 	if quotaTooLow || noMoreQuota {
 		go func() {
 			email, err := GetUserEmail(token.UserId)
@@ -287,7 +413,10 @@ func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	
 	// Handle user quota
 	var userQuotaErr error
-	if quota > 0 {
+	if token.OrganizationId != 0 {
+		// the quota of the organization is given back with a negative quota
+		userQuotaErr = DecreaseOrganizationQuota(token.OrganizationId, quota)
+	} else if quota > 0 {
 		userQuotaErr = DecreaseUserQuota(token.UserId, quota)
 	} else {
 		userQuotaErr = IncreaseUserQuota(token.UserId, -quota)
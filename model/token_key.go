@@ -0,0 +1,114 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// tokenUseRecordInterval is how often the last use of a token is saved, the uses in between
+// are only saved when the IP changes
+const tokenUseRecordInterval = 60
+
+// NewTokenKey returns the key of a new token, with the prefix of TOKEN_KEY_PREFIX
+func NewTokenKey() string {
+	if config.TokenKeyPrefix == "" {
+		return random.GenerateKey()
+	}
+	return random.GeneratePrefixedKey(config.TokenKeyPrefix)
+}
+
+func getTokenByPreviousKey(key string, token *Token) error {
+	return DB.Where("previous_key = ? AND previous_key_expired_time > ?", key, helper.GetTimestamp()).First(token).Error
+}
+
+// Rotate gives the token a new key, the current one keeps working for gracePeriod seconds
+func (t *Token) Rotate(gracePeriod int64) error {
+	oldKey, oldPreviousKey := t.Key, t.PreviousKey
+	t.Key = NewTokenKey()
+	t.PreviousKey = ""
+	t.PreviousKeyExpiredTime = 0
+	if gracePeriod > 0 {
+		t.PreviousKey = oldKey
+		t.PreviousKeyExpiredTime = helper.GetTimestamp() + gracePeriod
+	}
+	err := DB.Model(t).Select("key", "previous_key", "previous_key_expired_time").Updates(t).Error
+	if err != nil {
+		return err
+	}
+	PublishInvalidation(InvalidationToken, oldKey)
+	if oldPreviousKey != "" {
+		PublishInvalidation(InvalidationToken, oldPreviousKey)
+	}
+	return nil
+}
+
+// RevokeLeakedTokenKey revokes a leaked key: the token is disabled, or, for the previous key
+// of a rotated token, the previous key stops working. It returns the token of the key.
+func RevokeLeakedTokenKey(ctx context.Context, key string) (*Token, error) {
+	keyCol := "`key`"
+	if common.UsingPostgreSQL {
+		keyCol = `"key"`
+	}
+	token := Token{}
+	var err error
+	if err = DB.Where(keyCol+" = ?", key).First(&token).Error; err == nil {
+		if token.Status != TokenStatusArchived {
+			token.Status = TokenStatusDisabled
+			err = DB.Model(&token).Select("status").Updates(&token).Error
+		}
+	} else if err = DB.Where("previous_key = ?", key).First(&token).Error; err == nil {
+		token.PreviousKey = ""
+		token.PreviousKeyExpiredTime = 0
+		err = DB.Model(&token).Select("previous_key", "previous_key_expired_time").Updates(&token).Error
+	} else {
+		return nil, errors.New("无效的令牌")
+	}
+	if err != nil {
+		return nil, err
+	}
+	PublishInvalidation(InvalidationToken, key)
+	RecordLog(ctx, token.UserId, LogTypeSystem, fmt.Sprintf("令牌 %s（#%d）的密钥已泄露，已被吊销", token.Name, token.Id))
+	EmitWebhookEvent(WebhookEventTokenRevoked, map[string]any{
+		"user_id":  token.UserId,
+		"token_id": token.Id,
+		"name":     token.Name,
+	})
+	return &token, nil
+}
+
+type tokenUse struct {
+	time int64
+	ip   string
+}
+
+var lastTokenUses sync.Map // token id -> tokenUse
+
+// RecordTokenUse saves when and from which IP a token is used, at most once a minute unless
+// the IP changes
+func RecordTokenUse(token *Token, ip string) {
+	now := helper.GetTimestamp()
+	if last, ok := lastTokenUses.Load(token.Id); ok {
+		use := last.(tokenUse)
+		if use.ip == ip && now-use.time < tokenUseRecordInterval {
+			return
+		}
+	}
+	lastTokenUses.Store(token.Id, tokenUse{time: now, ip: ip})
+	go func() {
+		err := DB.Model(&Token{}).Where("id = ?", token.Id).Updates(map[string]any{
+			"last_used_time": now,
+			"last_used_ip":   ip,
+		}).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to record the use of token #%d: %s", token.Id, err.Error()))
+		}
+	}()
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// A token requiring signatures only accepts the requests with the header
+// X-Signature: t=<timestamp>,n=<nonce>,v1=<signature>, where the signature is the hex
+// HMAC-SHA256 of "<timestamp>.<nonce>.<method>.<path>.<body>" with the signing secret of the
+// token, the path including the query string. The timestamp must be within TOKEN_SIGNATURE_TOLERANCE of the time of the gateway,
+// and a nonce is accepted once.
+
+const TokenSignatureHeader = "X-Signature"
+
+type signatureVerifiedContextKey struct{}
+
+// WithSignatureVerified marks the requests replayed by the gateway itself, the batches and
+// the deferred requests, whose signature was checked when they were created
+func WithSignatureVerified(ctx context.Context) context.Context {
+	return context.WithValue(ctx, signatureVerifiedContextKey{}, true)
+}
+
+// IsSignatureVerified tells whether a request is replayed by the gateway, its signature is not
+// checked again
+func IsSignatureVerified(ctx context.Context) bool {
+	verified, _ := ctx.Value(signatureVerifiedContextKey{}).(bool)
+	return verified
+}
+
+// ensureSigningSecret generates the signing secret of a token requiring signatures, and
+// drops it when they are not required, so it is renewed by requiring them again
+func (t *Token) ensureSigningSecret() {
+	if !t.RequireSignature {
+		t.SigningSecret = ""
+		return
+	}
+	if t.SigningSecret == "" {
+		secret := make([]byte, 32)
+		_, _ = rand.Read(secret)
+		t.SigningSecret = hex.EncodeToString(secret)
+	}
+}
+
+// SignTokenRequest returns the signature of a request
+func SignTokenRequest(secret string, timestamp int64, nonce string, method string, path string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s.%s.%s.", timestamp, nonce, method, path)))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TokenSignature is a parsed X-Signature header
+type TokenSignature struct {
+	Timestamp int64
+	Nonce     string
+	Signature string
+}
+
+func ParseTokenSignature(header string) (*TokenSignature, error) {
+	signature := TokenSignature{}
+	for _, part := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch name {
+		case "t":
+			signature.Timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "n":
+			signature.Nonce = value
+		case "v1":
+			signature.Signature = value
+		}
+	}
+	if signature.Timestamp == 0 || signature.Nonce == "" || signature.Signature == "" {
+		return nil, errors.New("the signature header must be t=<timestamp>,n=<nonce>,v1=<signature>")
+	}
+	if len(signature.Nonce) > 64 {
+		return nil, errors.New("the nonce of the signature is too long")
+	}
+	return &signature, nil
+}
+
+// Verify checks the signature of a request of a token, the nonce is checked by the caller
+func (s *TokenSignature) Verify(token *Token, method string, path string, body []byte) error {
+	now := helper.GetTimestamp()
+	if s.Timestamp < now-int64(config.TokenSignatureTolerance) || s.Timestamp > now+int64(config.TokenSignatureTolerance) {
+		return errors.New("the timestamp of the signature is too old or in the future")
+	}
+	expected := SignTokenRequest(token.SigningSecret, s.Timestamp, s.Nonce, method, path, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(s.Signature))) {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
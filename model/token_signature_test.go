@@ -0,0 +1,72 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+func TestParseTokenSignature(t *testing.T) {
+	testCases := []struct {
+		header string
+		valid  bool
+	}{
+		{"t=1700000000,n=abc,v1=def", true},
+		{" t=1700000000 , n=abc , v1=def ", true},
+		{"t=1700000000,n=abc", false},
+		{"t=now,n=abc,v1=def", false},
+		{"n=abc,v1=def", false},
+		{fmt.Sprintf("t=1700000000,n=%065d,v1=def", 0), false},
+	}
+	for _, testCase := range testCases {
+		signature, err := ParseTokenSignature(testCase.header)
+		if !testCase.valid {
+			assert.Error(t, err, testCase.header)
+			continue
+		}
+		require.NoError(t, err, testCase.header)
+		assert.Equal(t, int64(1700000000), signature.Timestamp)
+		assert.Equal(t, "abc", signature.Nonce)
+		assert.Equal(t, "def", signature.Signature)
+	}
+}
+
+func TestTokenSignatureVerify(t *testing.T) {
+	token := &Token{SigningSecret: "secret"}
+	body := []byte(`{"model":"gpt-4o"}`)
+	now := helper.GetTimestamp()
+	tolerance := int64(config.TokenSignatureTolerance)
+	testCases := []struct {
+		name      string
+		timestamp int64
+		method    string
+		path      string
+		body      []byte
+		valid     bool
+	}{
+		{"valid", now, "POST", "/v1/chat/completions", body, true},
+		{"within the tolerance", now - tolerance + 5, "POST", "/v1/chat/completions", body, true},
+		{"tampered body", now, "POST", "/v1/chat/completions", []byte(`{"model":"o1"}`), false},
+		{"tampered path", now, "POST", "/v1/embeddings", body, false},
+		{"tampered query", now, "POST", "/v1/chat/completions?x=1", body, false},
+		{"tampered method", now, "PUT", "/v1/chat/completions", body, false},
+		{"stale timestamp", now - tolerance - 5, "POST", "/v1/chat/completions", body, false},
+		{"future timestamp", now + tolerance + 5, "POST", "/v1/chat/completions", body, false},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			// the client signs the request it sends, the gateway verifies the one it got
+			signed := SignTokenRequest(token.SigningSecret, testCase.timestamp, "nonce", "POST", "/v1/chat/completions", body)
+			signature := &TokenSignature{Timestamp: testCase.timestamp, Nonce: "nonce", Signature: signed}
+			err := signature.Verify(token, testCase.method, testCase.path, testCase.body)
+			assert.Equal(t, testCase.valid, err == nil, err)
+		})
+	}
+	signature := &TokenSignature{Timestamp: now, Nonce: "nonce", Signature: SignTokenRequest("other", now, "nonce", "POST", "/v1/chat/completions", body)}
+	assert.Error(t, signature.Verify(token, "POST", "/v1/chat/completions", body), "signed with another secret")
+}
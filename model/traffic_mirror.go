@@ -0,0 +1,319 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// A traffic mirror sends a share of the production requests of its groups and models to a
+// shadow channel as well, to evaluate a provider or a self-hosted model under real traffic.
+// The shadow responses are discarded and not billed, the mirrored requests are compared on
+// both sides by their error rate and latency.
+
+const (
+	TrafficMirrorStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	TrafficMirrorStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	TrafficMirrorProduction = "production"
+	TrafficMirrorShadow     = "shadow"
+)
+
+type TrafficMirror struct {
+	Id          int     `json:"id"`
+	Name        string  `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	ChannelId   int     `json:"channel_id" gorm:"index"`                    // the shadow channel, it may be disabled
+	Model       string  `json:"model" gorm:"type:varchar(255);default:''"`  // model relayed to the shadow channel, the requested one when empty
+	Groups      string  `json:"groups" gorm:"type:varchar(255);default:''"` // comma separated user groups, empty for all of them
+	Models      string  `json:"models" gorm:"type:text"`                    // comma separated requested models or patterns, empty for all of them
+	Percent     float64 `json:"percent"`                                    // share of the matching requests mirrored, up to 100
+	Status      int     `json:"status" gorm:"default:1"`
+	CreatedTime int64   `json:"created_time" gorm:"bigint"`
+	UpdatedTime int64   `json:"updated_time" gorm:"bigint"`
+
+	channel *Channel
+}
+
+func (m *TrafficMirror) Validate() error {
+	m.Name = strings.TrimSpace(m.Name)
+	if m.Name == "" {
+		return errors.New("mirror name is empty")
+	}
+	if m.Percent <= 0 || m.Percent > 100 {
+		return errors.New("the percent of the mirrored requests must be above 0 and at most 100")
+	}
+	channel, err := GetChannelById(m.ChannelId, false)
+	if err != nil {
+		return fmt.Errorf("shadow channel #%d not found", m.ChannelId)
+	}
+	if channel.Status == ChannelStatusArchived {
+		return fmt.Errorf("shadow channel #%d is archived", m.ChannelId)
+	}
+	return nil
+}
+
+func (m *TrafficMirror) matches(group string, modelName string) bool {
+	if m.Groups != "" && !isInCommaList(group, m.Groups) {
+		return false
+	}
+	return m.Models == "" || IsModelAllowed(modelName, m.Models)
+}
+
+// Channel returns the shadow channel loaded with the mirror, with its key
+func (m *TrafficMirror) Channel() *Channel {
+	return m.channel
+}
+
+func GetAllTrafficMirrors() ([]*TrafficMirror, error) {
+	var mirrors []*TrafficMirror
+	err := DB.Order("id asc").Find(&mirrors).Error
+	return mirrors, err
+}
+
+func GetTrafficMirrorById(id int) (*TrafficMirror, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	mirror := TrafficMirror{Id: id}
+	err := DB.First(&mirror, "id = ?", id).Error
+	return &mirror, err
+}
+
+func (m *TrafficMirror) Insert() error {
+	m.CreatedTime = helper.GetTimestamp()
+	m.UpdatedTime = m.CreatedTime
+	return DB.Create(m).Error
+}
+
+func (m *TrafficMirror) Update() error {
+	m.UpdatedTime = helper.GetTimestamp()
+	return DB.Model(m).Select("name", "channel_id", "model", "groups", "models", "percent", "status", "updated_time").Updates(m).Error
+}
+
+// Delete removes the mirror with its results
+func (m *TrafficMirror) Delete() error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("mirror_id = ?", m.Id).Delete(&TrafficMirrorStat{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(m).Error
+	})
+}
+
+var enabledTrafficMirrors []*TrafficMirror
+var trafficMirrorSyncLock sync.RWMutex
+
+// InitTrafficMirrorCache loads the enabled mirrors with their shadow channels into memory, it
+// is called on startup, after every admin change and periodically
+func InitTrafficMirrorCache() {
+	var mirrors []*TrafficMirror
+	err := DB.Where("status = ?", TrafficMirrorStatusEnabled).Order("id asc").Find(&mirrors).Error
+	if err != nil {
+		logger.SysError("failed to load traffic mirrors: " + err.Error())
+		return
+	}
+	loaded := make([]*TrafficMirror, 0, len(mirrors))
+	for _, mirror := range mirrors {
+		channel, err := GetChannelById(mirror.ChannelId, true)
+		if err != nil || channel.Status == ChannelStatusArchived {
+			logger.SysError(fmt.Sprintf("skipping traffic mirror #%d, its shadow channel #%d is not available", mirror.Id, mirror.ChannelId))
+			continue
+		}
+		mirror.channel = channel
+		loaded = append(loaded, mirror)
+	}
+	trafficMirrorSyncLock.Lock()
+	enabledTrafficMirrors = loaded
+	trafficMirrorSyncLock.Unlock()
+}
+
+// SyncTrafficMirrorCache saves the results recorded since the last sync and reloads the mirrors
+func SyncTrafficMirrorCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushTrafficMirrorStats()
+		InitTrafficMirrorCache()
+	}
+}
+
+// CacheSampleTrafficMirror returns the first mirror of a group and a requested model when the
+// request is drawn in its share, nil otherwise
+func CacheSampleTrafficMirror(group string, modelName string) *TrafficMirror {
+	trafficMirrorSyncLock.RLock()
+	defer trafficMirrorSyncLock.RUnlock()
+	for _, mirror := range enabledTrafficMirrors {
+		if mirror.matches(group, modelName) {
+			if rand.Float64()*100 < mirror.Percent {
+				return mirror
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// TrafficMirrorStat holds the outcomes of the mirrored requests on one side, production or
+// shadow, with the sums of squares giving the variances the sides are compared with
+type TrafficMirrorStat struct {
+	Id               int     `json:"-"`
+	MirrorId         int     `json:"mirror_id" gorm:"uniqueIndex:idx_mirror_side,priority:1"`
+	Side             string  `json:"side" gorm:"type:varchar(16);uniqueIndex:idx_mirror_side,priority:2"`
+	Requests         int64   `json:"requests" gorm:"default:0"`
+	Errors           int64   `json:"errors" gorm:"default:0"`
+	Dropped          int64   `json:"dropped" gorm:"default:0"`     // shadow requests not sent, too many were in flight
+	LatencySum       float64 `json:"latency_sum" gorm:"default:0"` // milliseconds
+	LatencySquareSum float64 `json:"latency_square_sum" gorm:"default:0"`
+}
+
+type trafficMirrorSideKey struct {
+	mirrorId int
+	side     string
+}
+
+var trafficMirrorStatsLock sync.Mutex
+var trafficMirrorStatsPending = make(map[trafficMirrorSideKey]*TrafficMirrorStat)
+
+func addTrafficMirrorStat(mirrorId int, side string, update func(stat *TrafficMirrorStat)) {
+	key := trafficMirrorSideKey{mirrorId: mirrorId, side: side}
+	trafficMirrorStatsLock.Lock()
+	defer trafficMirrorStatsLock.Unlock()
+	stat, ok := trafficMirrorStatsPending[key]
+	if !ok {
+		stat = &TrafficMirrorStat{MirrorId: mirrorId, Side: side}
+		trafficMirrorStatsPending[key] = stat
+	}
+	update(stat)
+}
+
+func (s *TrafficMirrorStat) add(other *TrafficMirrorStat) {
+	s.Requests += other.Requests
+	s.Errors += other.Errors
+	s.Dropped += other.Dropped
+	s.LatencySum += other.LatencySum
+	s.LatencySquareSum += other.LatencySquareSum
+}
+
+// RecordTrafficMirrorRequest records the outcome of a mirrored request on one side
+func RecordTrafficMirrorRequest(mirrorId int, side string, latency time.Duration, failed bool) {
+	ms := float64(latency.Milliseconds())
+	addTrafficMirrorStat(mirrorId, side, func(stat *TrafficMirrorStat) {
+		stat.Requests++
+		if failed {
+			stat.Errors++
+		}
+		stat.LatencySum += ms
+		stat.LatencySquareSum += ms * ms
+	})
+}
+
+// RecordTrafficMirrorDropped counts a shadow request which was not sent
+func RecordTrafficMirrorDropped(mirrorId int) {
+	addTrafficMirrorStat(mirrorId, TrafficMirrorShadow, func(stat *TrafficMirrorStat) {
+		stat.Dropped++
+	})
+}
+
+// FlushTrafficMirrorStats adds the outcomes recorded in memory to the stats table
+func FlushTrafficMirrorStats() {
+	trafficMirrorStatsLock.Lock()
+	pending := trafficMirrorStatsPending
+	trafficMirrorStatsPending = make(map[trafficMirrorSideKey]*TrafficMirrorStat)
+	trafficMirrorStatsLock.Unlock()
+
+	for key, stat := range pending {
+		err := DB.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "mirror_id"}, {Name: "side"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"requests":           gorm.Expr("traffic_mirror_stats.requests + ?", stat.Requests),
+				"errors":             gorm.Expr("traffic_mirror_stats.errors + ?", stat.Errors),
+				"dropped":            gorm.Expr("traffic_mirror_stats.dropped + ?", stat.Dropped),
+				"latency_sum":        gorm.Expr("traffic_mirror_stats.latency_sum + ?", stat.LatencySum),
+				"latency_square_sum": gorm.Expr("traffic_mirror_stats.latency_square_sum + ?", stat.LatencySquareSum),
+			}),
+		}).Create(stat).Error
+		if err != nil {
+			logger.SysError(fmt.Sprintf("failed to save the results of traffic mirror #%d: %s", key.mirrorId, err.Error()))
+			// keep the outcomes for the next flush
+			addTrafficMirrorStat(key.mirrorId, key.side, func(s *TrafficMirrorStat) {
+				s.add(stat)
+			})
+		}
+	}
+}
+
+// TrafficMirrorSideResult summarizes the outcomes of the mirrored requests on one side
+type TrafficMirrorSideResult struct {
+	Side            string  `json:"side"`
+	Requests        int64   `json:"requests"`
+	Dropped         int64   `json:"dropped,omitempty"`
+	ErrorRate       float64 `json:"error_rate"`
+	ErrorRateStdErr float64 `json:"error_rate_std_err"`
+	LatencyMean     float64 `json:"latency_mean"` // milliseconds
+	LatencyStdDev   float64 `json:"latency_std_dev"`
+	LatencyStdErr   float64 `json:"latency_std_err"`
+}
+
+// TrafficMirrorResults compares the shadow channel with production, the deltas are shadow
+// minus production and come with their standard errors
+type TrafficMirrorResults struct {
+	Production           *TrafficMirrorSideResult `json:"production"`
+	Shadow               *TrafficMirrorSideResult `json:"shadow"`
+	ErrorRateDelta       float64                  `json:"error_rate_delta"`
+	ErrorRateDeltaStdErr float64                  `json:"error_rate_delta_std_err"`
+	LatencyDelta         float64                  `json:"latency_delta"`
+	LatencyDeltaStdErr   float64                  `json:"latency_delta_std_err"`
+}
+
+func trafficMirrorSideResult(stat *TrafficMirrorStat) *TrafficMirrorSideResult {
+	result := &TrafficMirrorSideResult{Side: stat.Side, Requests: stat.Requests, Dropped: stat.Dropped}
+	result.ErrorRate, result.ErrorRateStdErr = proportion(stat.Errors, stat.Requests)
+	result.LatencyMean, result.LatencyStdDev, result.LatencyStdErr = meanStdDev(stat.LatencySum, stat.LatencySquareSum, stat.Requests)
+	return result
+}
+
+// GetTrafficMirrorResults returns the results of a mirror, the outcomes not saved yet by this
+// instance included
+func GetTrafficMirrorResults(mirrorId int) (*TrafficMirrorResults, error) {
+	var stats []*TrafficMirrorStat
+	if err := DB.Where("mirror_id = ?", mirrorId).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+	bySide := map[string]*TrafficMirrorStat{
+		TrafficMirrorProduction: {MirrorId: mirrorId, Side: TrafficMirrorProduction},
+		TrafficMirrorShadow:     {MirrorId: mirrorId, Side: TrafficMirrorShadow},
+	}
+	for _, stat := range stats {
+		if side, ok := bySide[stat.Side]; ok {
+			side.add(stat)
+		}
+	}
+	trafficMirrorStatsLock.Lock()
+	for key, pending := range trafficMirrorStatsPending {
+		if side, ok := bySide[key.side]; ok && key.mirrorId == mirrorId {
+			side.add(pending)
+		}
+	}
+	trafficMirrorStatsLock.Unlock()
+
+	results := &TrafficMirrorResults{
+		Production: trafficMirrorSideResult(bySide[TrafficMirrorProduction]),
+		Shadow:     trafficMirrorSideResult(bySide[TrafficMirrorShadow]),
+	}
+	results.ErrorRateDelta = results.Shadow.ErrorRate - results.Production.ErrorRate
+	results.ErrorRateDeltaStdErr = math.Hypot(results.Shadow.ErrorRateStdErr, results.Production.ErrorRateStdErr)
+	results.LatencyDelta = results.Shadow.LatencyMean - results.Production.LatencyMean
+	results.LatencyDeltaStdErr = math.Hypot(results.Shadow.LatencyStdErr, results.Production.LatencyStdErr)
+	return results, nil
+}
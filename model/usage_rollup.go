@@ -0,0 +1,353 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+const (
+	usageRollupFlushInterval = 10 * time.Second
+	usageRollupHour          = 3600
+)
+
+// UsageRollup aggregates the consume logs and relay errors per hour, user, model,
+// channel and token, so dashboards do not scan the logs table. Days are summed from hours.
+type UsageRollup struct {
+	Id               int    `json:"-"`
+	Hour             int64  `json:"hour" gorm:"uniqueIndex:idx_usage_rollup_token_key,priority:1"` // unix timestamp of the start of the hour
+	UserId           int    `json:"user_id" gorm:"uniqueIndex:idx_usage_rollup_token_key,priority:2;index"`
+	ModelName        string `json:"model_name" gorm:"type:varchar(255);uniqueIndex:idx_usage_rollup_token_key,priority:3;default:''"`
+	ChannelId        int    `json:"channel" gorm:"uniqueIndex:idx_usage_rollup_token_key,priority:4"`
+	TokenName        string `json:"token_name" gorm:"type:varchar(255);uniqueIndex:idx_usage_rollup_token_key,priority:5;default:''"`
+	Requests         int64  `json:"requests" gorm:"default:0"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"default:0"`
+	Quota            int64  `json:"quota" gorm:"default:0"`
+	CachedTokens     int64  `json:"cached_tokens" gorm:"default:0"`
+	CacheSavedQuota  int64  `json:"cache_saved_quota" gorm:"default:0"`
+	UpstreamQuota    int64  `json:"upstream_quota" gorm:"default:0"`
+	Errors           int64  `json:"errors" gorm:"default:0"`
+}
+
+type usageRollupKey struct {
+	hour      int64
+	userId    int
+	modelName string
+	channelId int
+	tokenName string
+}
+
+var usageRollupLock sync.Mutex
+var usageRollupPending = make(map[usageRollupKey]*UsageRollup)
+
+func addUsageRollup(key usageRollupKey, update func(rollup *UsageRollup)) {
+	if !config.UsageRollupEnabled {
+		return
+	}
+	key.hour -= key.hour % usageRollupHour
+	usageRollupLock.Lock()
+	defer usageRollupLock.Unlock()
+	rollup, ok := usageRollupPending[key]
+	if !ok {
+		rollup = &UsageRollup{Hour: key.hour, UserId: key.userId, ModelName: key.modelName, ChannelId: key.channelId, TokenName: key.tokenName}
+		usageRollupPending[key] = rollup
+	}
+	update(rollup)
+}
+
+// rollupLog counts a consume log, it is called by the log pipeline for every log
+func rollupLog(log *Log) {
+	if log.Type != LogTypeConsume {
+		return
+	}
+	addUsageRollup(usageRollupKey{hour: log.CreatedAt, userId: log.UserId, modelName: log.ModelName, channelId: log.ChannelId, tokenName: log.TokenName}, func(rollup *UsageRollup) {
+		rollup.Requests++
+		rollup.PromptTokens += int64(log.PromptTokens)
+		rollup.CompletionTokens += int64(log.CompletionTokens)
+		rollup.Quota += int64(log.Quota)
+		rollup.CachedTokens += int64(log.CachedTokens)
+		rollup.CacheSavedQuota += int64(log.CacheSavedQuota)
+		rollup.UpstreamQuota += int64(log.UpstreamQuota)
+	})
+}
+
+// RecordUsageError counts a relay request which failed on every channel
+func RecordUsageError(userId int, modelName string, channelId int, tokenName string) {
+	addUsageRollup(usageRollupKey{hour: time.Now().Unix(), userId: userId, modelName: modelName, channelId: channelId, tokenName: tokenName}, func(rollup *UsageRollup) {
+		rollup.Errors++
+	})
+}
+
+// FlushUsageRollups adds the pending counts to the rollup table
+func FlushUsageRollups() {
+	usageRollupLock.Lock()
+	pending := usageRollupPending
+	usageRollupPending = make(map[usageRollupKey]*UsageRollup)
+	usageRollupLock.Unlock()
+
+	for key, rollup := range pending {
+		if err := upsertUsageRollup(LOG_DB, rollup); err != nil {
+			logger.SysError("failed to flush usage rollup: " + err.Error())
+			// keep the counts for the next flush
+			addUsageRollup(key, func(r *UsageRollup) {
+				r.Requests += rollup.Requests
+				r.PromptTokens += rollup.PromptTokens
+				r.CompletionTokens += rollup.CompletionTokens
+				r.Quota += rollup.Quota
+				r.CachedTokens += rollup.CachedTokens
+				r.CacheSavedQuota += rollup.CacheSavedQuota
+				r.UpstreamQuota += rollup.UpstreamQuota
+				r.Errors += rollup.Errors
+			})
+		}
+	}
+}
+
+func upsertUsageRollup(db *gorm.DB, rollup *UsageRollup) error {
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "hour"}, {Name: "user_id"}, {Name: "model_name"}, {Name: "channel_id"}, {Name: "token_name"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":          gorm.Expr("usage_rollups.requests + ?", rollup.Requests),
+			"prompt_tokens":     gorm.Expr("usage_rollups.prompt_tokens + ?", rollup.PromptTokens),
+			"completion_tokens": gorm.Expr("usage_rollups.completion_tokens + ?", rollup.CompletionTokens),
+			"quota":             gorm.Expr("usage_rollups.quota + ?", rollup.Quota),
+			"cached_tokens":     gorm.Expr("usage_rollups.cached_tokens + ?", rollup.CachedTokens),
+			"cache_saved_quota": gorm.Expr("usage_rollups.cache_saved_quota + ?", rollup.CacheSavedQuota),
+			"upstream_quota":    gorm.Expr("usage_rollups.upstream_quota + ?", rollup.UpstreamQuota),
+			"errors":            gorm.Expr("usage_rollups.errors + ?", rollup.Errors),
+		}),
+	}).Create(rollup).Error
+}
+
+// migrateUsageRollup drops the unique key of the rollups made before they were counted
+// per token, which the rollups of different tokens would conflict on
+func migrateUsageRollup(db *gorm.DB) error {
+	if db.Migrator().HasIndex(&UsageRollup{}, "idx_usage_rollup_key") {
+		if err := db.Migrator().DropIndex(&UsageRollup{}, "idx_usage_rollup_key"); err != nil {
+			return err
+		}
+	}
+	return db.AutoMigrate(&UsageRollup{})
+}
+
+// InitUsageRollup periodically flushes the pending rollup counts
+func InitUsageRollup() {
+	if !config.UsageRollupEnabled {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(usageRollupFlushInterval)
+			FlushUsageRollups()
+		}
+	}()
+}
+
+// RebuildUsageRollups recomputes the rollups of the hours in [start, end) from the logs,
+// used to backfill the table or to fix it after the logs changed. Errors are not
+// logged so their counts are kept.
+func RebuildUsageRollups(start int64, end int64) (int64, error) {
+	start -= start % usageRollupHour
+	if end%usageRollupHour != 0 {
+		end += usageRollupHour - end%usageRollupHour
+	}
+	var rollups []*UsageRollup
+	err := LOG_DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&UsageRollup{}).Where("hour >= ? AND hour < ?", start, end).Updates(map[string]interface{}{
+			"requests":          0,
+			"prompt_tokens":     0,
+			"completion_tokens": 0,
+			"quota":             0,
+			"cached_tokens":     0,
+			"cache_saved_quota": 0,
+			"upstream_quota":    0,
+		}).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Raw(`
+			SELECT created_at - created_at % ? as hour, user_id, model_name, channel_id, token_name,
+			count(1) as requests, sum(prompt_tokens) as prompt_tokens,
+			sum(completion_tokens) as completion_tokens, sum(quota) as quota,
+			sum(cached_tokens) as cached_tokens, sum(cache_saved_quota) as cache_saved_quota,
+			sum(upstream_quota) as upstream_quota
+			FROM logs
+			WHERE type = ? AND created_at >= ? AND created_at < ?
+			GROUP BY created_at - created_at % ?, user_id, model_name, channel_id, token_name
+		`, usageRollupHour, LogTypeConsume, start, end, usageRollupHour).Scan(&rollups).Error
+		if err != nil {
+			return err
+		}
+		for _, rollup := range rollups {
+			if err = upsertUsageRollup(tx, rollup); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return int64(len(rollups)), err
+}
+
+// UsageRollupFilter selects the rollups summed by GetUsageRollups, zero values match everything
+type UsageRollupFilter struct {
+	Start     int64
+	End       int64
+	UserId    int
+	UserIds   []int // used by GetUsageSummaries instead of UserId when set
+	ModelName string
+	ChannelId int
+	Daily     bool
+}
+
+// UsageRollupStat is a row of summed rollups, Period is the hour or day timestamp
+type UsageRollupStat struct {
+	Period           int64  `json:"period"`
+	ModelName        string `json:"model_name"`
+	Requests         int64  `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Quota            int64  `json:"quota"`
+	CachedTokens     int64  `json:"cached_tokens"`
+	CacheSavedQuota  int64  `json:"cache_saved_quota"`
+	Errors           int64  `json:"errors"`
+}
+
+// GetUsageRollups sums the rollups per period and model
+func GetUsageRollups(filter UsageRollupFilter) (stats []*UsageRollupStat, err error) {
+	period := "hour"
+	if filter.Daily {
+		period = "hour - hour % 86400"
+	}
+	tx := LOG_DB.Model(&UsageRollup{}).Select(fmt.Sprintf(`%s as period, model_name,
+		sum(requests) as requests, sum(prompt_tokens) as prompt_tokens, sum(completion_tokens) as completion_tokens,
+		sum(quota) as quota, sum(cached_tokens) as cached_tokens, sum(cache_saved_quota) as cache_saved_quota,
+		sum(errors) as errors`, period))
+	if filter.Start != 0 {
+		tx = tx.Where("hour >= ?", filter.Start-filter.Start%usageRollupHour)
+	}
+	if filter.End != 0 {
+		tx = tx.Where("hour <= ?", filter.End)
+	}
+	if filter.UserId != 0 {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if filter.ChannelId != 0 {
+		tx = tx.Where("channel_id = ?", filter.ChannelId)
+	}
+	err = tx.Group(fmt.Sprintf("%s, model_name", period)).Order("period, model_name").Scan(&stats).Error
+	return stats, err
+}
+
+// searchRollupsByDayAndModel is SearchLogsByDayAndModel reading the rollups
+func searchRollupsByDayAndModel(userId, start, end int) ([]*LogStatistic, error) {
+	stats, err := GetUsageRollups(UsageRollupFilter{Start: int64(start), End: int64(end), UserId: userId, Daily: true})
+	if err != nil {
+		return nil, err
+	}
+	statistics := make([]*LogStatistic, 0, len(stats))
+	for _, stat := range stats {
+		if stat.Requests == 0 {
+			continue
+		}
+		statistics = append(statistics, &LogStatistic{
+			Day:              time.Unix(stat.Period, 0).UTC().Format("2006-01-02"),
+			ModelName:        stat.ModelName,
+			RequestCount:     int(stat.Requests),
+			Quota:            int(stat.Quota),
+			PromptTokens:     int(stat.PromptTokens),
+			CompletionTokens: int(stat.CompletionTokens),
+		})
+	}
+	return statistics, nil
+}
+
+// Dimensions the usage summaries are grouped by
+const (
+	UsageGroupDay   = "day"
+	UsageGroupModel = "model"
+	UsageGroupToken = "token"
+)
+
+var usageGroupColumns = map[string]string{
+	UsageGroupDay:   "hour - hour % 86400",
+	UsageGroupModel: "model_name",
+	UsageGroupToken: "token_name",
+}
+
+var usageGroupAliases = map[string]string{
+	UsageGroupDay:   "day",
+	UsageGroupModel: "model_name",
+	UsageGroupToken: "token_name",
+}
+
+// UsageSummary is the usage of a user summed by the dimensions of GetUsageSummaries, the
+// fields of the other dimensions are empty
+type UsageSummary struct {
+	Day              int64   `json:"day,omitempty"`
+	ModelName        string  `json:"model_name,omitempty"`
+	TokenName        string  `json:"token_name,omitempty"`
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Quota            int64   `json:"quota"`
+	CachedTokens     int64   `json:"cached_tokens"`
+	CacheSavedQuota  int64   `json:"cache_saved_quota"`
+	Errors           int64   `json:"errors"`
+	Cost             float64 `json:"cost" gorm:"-"`             // quota in Currency
+	CacheSavedCost   float64 `json:"cache_saved_cost" gorm:"-"` // cache saved quota in Currency
+	Currency         string  `json:"currency" gorm:"-"`
+}
+
+// IsUsageGroup tells whether usage can be grouped by a dimension
+func IsUsageGroup(group string) bool {
+	_, ok := usageGroupColumns[group]
+	return ok
+}
+
+// GetUsageSummaries sums the rollups of a user, or of users, by day, model and token name, or a part of
+// them, and totals them when groupBy is empty
+func GetUsageSummaries(filter UsageRollupFilter, groupBy []string) (summaries []*UsageSummary, err error) {
+	var groups, selects []string
+	for _, group := range groupBy {
+		column, ok := usageGroupColumns[group]
+		if !ok {
+			return nil, fmt.Errorf("cannot group usage by %s", group)
+		}
+		groups = append(groups, column)
+		selects = append(selects, fmt.Sprintf("%s as %s", column, usageGroupAliases[group]))
+	}
+	selects = append(selects, `sum(requests) as requests, sum(prompt_tokens) as prompt_tokens,
+		sum(completion_tokens) as completion_tokens, sum(quota) as quota, sum(cached_tokens) as cached_tokens,
+		sum(cache_saved_quota) as cache_saved_quota, sum(errors) as errors`)
+	tx := LOG_DB.Model(&UsageRollup{}).Select(strings.Join(selects, ", "))
+	if filter.UserIds != nil {
+		tx = tx.Where("user_id IN ?", filter.UserIds)
+	} else {
+		tx = tx.Where("user_id = ?", filter.UserId)
+	}
+	if filter.Start != 0 {
+		tx = tx.Where("hour >= ?", filter.Start-filter.Start%usageRollupHour)
+	}
+	if filter.End != 0 {
+		tx = tx.Where("hour <= ?", filter.End)
+	}
+	if filter.ModelName != "" {
+		tx = tx.Where("model_name = ?", filter.ModelName)
+	}
+	if len(groups) > 0 {
+		tx = tx.Group(strings.Join(groups, ", ")).Order(strings.Join(groups, ", "))
+	}
+	err = tx.Scan(&summaries).Error
+	return summaries, err
+}
@@ -51,6 +51,8 @@ type User struct {
 	Group            string `json:"group" gorm:"type:varchar(32);default:'default'"`
 	AffCode          string `json:"aff_code" gorm:"type:varchar(32);column:aff_code;uniqueIndex"`
 	InviterId        int    `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	TenantId         int    `json:"tenant_id" gorm:"default:0;index"` // see Tenant, set on creation
+	SignupIp         string `json:"-" gorm:"-:all"`                   // this field is only for the promotions on signup, don't save it to database!
 }
 
 func GetMaxUserId() int {
@@ -59,8 +61,8 @@ func GetMaxUserId() int {
 	return user.Id
 }
 
-func GetAllUsers(startIdx int, num int, order string) (users []*User, err error) {
-	query := DB.Limit(num).Offset(startIdx).Omit("password").Where("status != ?", UserStatusDeleted)
+func GetAllUsers(tenantId int, startIdx int, num int, order string) (users []*User, err error) {
+	query := DB.Scopes(tenantScope(tenantId)).Limit(num).Offset(startIdx).Omit("password").Where("status != ?", UserStatusDeleted)
 
 	switch order {
 	case "quota":
@@ -77,11 +79,11 @@ func GetAllUsers(startIdx int, num int, order string) (users []*User, err error)
 	return users, err
 }
 
-func SearchUsers(keyword string) (users []*User, err error) {
+func SearchUsers(tenantId int, keyword string) (users []*User, err error) {
 	if !common.UsingPostgreSQL {
-		err = DB.Omit("password").Where("id = ? or username LIKE ? or email LIKE ? or display_name LIKE ?", keyword, keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
+		err = DB.Scopes(tenantScope(tenantId)).Omit("password").Where("id = ? or username LIKE ? or email LIKE ? or display_name LIKE ?", keyword, keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
 	} else {
-		err = DB.Omit("password").Where("username LIKE ? or email LIKE ? or display_name LIKE ?", keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
+		err = DB.Scopes(tenantScope(tenantId)).Omit("password").Where("username LIKE ? or email LIKE ? or display_name LIKE ?", keyword+"%", keyword+"%", keyword+"%").Find(&users).Error
 	}
 	return users, err
 }
@@ -125,31 +127,27 @@ func (user *User) Insert(ctx context.Context, inviterId int) error {
 			return err
 		}
 	}
-	user.Quota = config.QuotaForNewUser
+	promoAllowed := promoSignupAllowed(user.SignupIp)
+	user.Quota = 0
+	if promoAllowed {
+		user.Quota = config.QuotaForNewUser
+	} else {
+		logger.Warnf(ctx, "too many signups from %s today, no signup promotions", user.SignupIp)
+	}
 	user.AccessToken = random.GetUUID()
 	user.AffCode = random.GetRandomString(4)
 	result := DB.Create(user)
 	if result.Error != nil {
 		return result.Error
 	}
-	if config.QuotaForNewUser > 0 {
-		RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(config.QuotaForNewUser)))
-	}
-	if inviterId != 0 {
-		if config.QuotaForInvitee > 0 {
-			_ = IncreaseUserQuota(user.Id, config.QuotaForInvitee)
-			RecordLog(ctx, user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(config.QuotaForInvitee)))
-		}
-		if config.QuotaForInviter > 0 {
-			_ = IncreaseUserQuota(inviterId, config.QuotaForInviter)
-			RecordLog(ctx, inviterId, LogTypeSystem, fmt.Sprintf("邀请用户赠送 %s", common.LogQuota(config.QuotaForInviter)))
-		}
+	if promoAllowed {
+		grantSignupPromotions(ctx, user, inviterId)
 	}
 	// create default token
 	cleanToken := Token{
 		UserId:         user.Id,
 		Name:           "default",
-		Key:            random.GenerateKey(),
+		Key:            NewTokenKey(),
 		CreatedTime:    helper.GetTimestamp(),
 		AccessedTime:   helper.GetTimestamp(),
 		ExpiredTime:    -1,
@@ -405,39 +403,26 @@ func UpdateUserUsedQuotaAndRequestCount(id int, quota int64) {
 	if config.BatchUpdateEnabled {
 		addNewRecord(BatchUpdateTypeUsedQuota, id, quota)
 		addNewRecord(BatchUpdateTypeRequestCount, id, 1)
+		spendPromoQuota(id, quota)
+		go checkSpendThreshold(id, quota, true)
 		return
 	}
-	updateUserUsedQuotaAndRequestCount(id, quota, 1)
-}
-
-func updateUserUsedQuotaAndRequestCount(id int, quota int64, count int) {
-	err := DB.Model(&User{}).Where("id = ?", id).Updates(
-		map[string]interface{}{
-			"used_quota":    gorm.Expr("used_quota + ?", quota),
-			"request_count": gorm.Expr("request_count + ?", count),
-		},
-	).Error
+	err := updateUserUsedQuotaAndRequestCount(id, quota, 1)
 	if err != nil {
 		logger.SysError("failed to update user used quota and request count: " + err.Error())
+		return
 	}
+	spendPromoQuota(id, quota)
+	go checkSpendThreshold(id, quota, false)
 }
 
-func updateUserUsedQuota(id int, quota int64) {
-	err := DB.Model(&User{}).Where("id = ?", id).Updates(
+func updateUserUsedQuotaAndRequestCount(id int, quota int64, count int) error {
+	return DB.Model(&User{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
-			"used_quota": gorm.Expr("used_quota + ?", quota),
+			"used_quota":    gorm.Expr("used_quota + ?", quota),
+			"request_count": gorm.Expr("request_count + ?", count),
 		},
 	).Error
-	if err != nil {
-		logger.SysError("failed to update user used quota: " + err.Error())
-	}
-}
-
-func updateUserRequestCount(id int, count int) {
-	err := DB.Model(&User{}).Where("id = ?", id).Update("request_count", gorm.Expr("request_count + ?", count)).Error
-	if err != nil {
-		logger.SysError("failed to update user request count: " + err.Error())
-	}
 }
 
 func GetUsernameById(id int) (username string) {
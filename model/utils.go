@@ -1,8 +1,12 @@
 package model
 
 import (
+	"context"
+	"fmt"
+	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -16,9 +20,15 @@ const (
 	BatchUpdateTypeCount // if you add a new type, you need to add a new map and a new lock
 )
 
+const batchUpdateRedisTimeout = 5 * time.Second
+
 var batchUpdateStores []map[int]int64
 var batchUpdateLocks []sync.Mutex
 
+// batchUpdateRedis keeps the deltas in Redis hashes shared by every node, so they
+// survive a crash, the master node flushes them
+var batchUpdateRedis bool
+
 func init() {
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateStores = append(batchUpdateStores, make(map[int]int64))
@@ -27,6 +37,13 @@ func init() {
 }
 
 func InitBatchUpdater() {
+	if config.BatchUpdateStore == "redis" {
+		if common.RedisEnabled {
+			batchUpdateRedis = true
+		} else {
+			logger.SysError("BATCH_UPDATE_STORE is redis but Redis is not enabled, batch updates are kept in memory")
+		}
+	}
 	go func() {
 		for {
 			time.Sleep(time.Duration(config.BatchUpdateInterval) * time.Second)
@@ -35,7 +52,29 @@ func InitBatchUpdater() {
 	}()
 }
 
+// FlushBatchUpdate writes the pending batch updates, used on shutdown
+func FlushBatchUpdate() {
+	if config.BatchUpdateEnabled {
+		batchUpdate()
+	}
+}
+
+// the hash tag keeps the pending and flushing hashes in the same Redis cluster slot
+func batchUpdateRedisKeys(type_ int) (pending string, flushing string) {
+	return fmt.Sprintf("{batch_update:%d}:pending", type_), fmt.Sprintf("{batch_update:%d}:flushing", type_)
+}
+
 func addNewRecord(type_ int, id int, value int64) {
+	if batchUpdateRedis {
+		ctx, cancel := context.WithTimeout(context.Background(), batchUpdateRedisTimeout)
+		defer cancel()
+		pending, _ := batchUpdateRedisKeys(type_)
+		err := common.RDB.HIncrBy(ctx, pending, strconv.Itoa(id), value).Err()
+		if err == nil {
+			return
+		}
+		logger.SysError("failed to add batch update to Redis, keeping it in memory: " + err.Error())
+	}
 	batchUpdateLocks[type_].Lock()
 	defer batchUpdateLocks[type_].Unlock()
 	if _, ok := batchUpdateStores[type_][id]; !ok {
@@ -47,32 +86,101 @@ func addNewRecord(type_ int, id int, value int64) {
 
 func batchUpdate() {
 	logger.SysLog("batch update started")
+	var stores [BatchUpdateTypeCount]map[int]int64
 	for i := 0; i < BatchUpdateTypeCount; i++ {
 		batchUpdateLocks[i].Lock()
-		store := batchUpdateStores[i]
+		stores[i] = batchUpdateStores[i]
 		batchUpdateStores[i] = make(map[int]int64)
 		batchUpdateLocks[i].Unlock()
-		// TODO: maybe we can combine updates with same key?
-		for key, value := range store {
+	}
+	applyBatchUpdates(stores, func(type_ int, id int, value int64, err error) {
+		if err != nil {
+			// keep the failed update for the next round
+			addNewRecord(type_, id, value)
+		}
+	})
+	if batchUpdateRedis && config.IsMasterNode {
+		batchUpdateFromRedis()
+	}
+	logger.SysLog("batch update finished")
+}
+
+// applyBatchUpdates writes one summed update per entity, the used quota and request
+// count of a user in a single statement, done is called after each update
+func applyBatchUpdates(stores [BatchUpdateTypeCount]map[int]int64, done func(type_ int, id int, value int64, err error)) {
+	for i := 0; i < BatchUpdateTypeCount; i++ {
+		for key, value := range stores[i] {
+			var err error
 			switch i {
 			case BatchUpdateTypeUserQuota:
-				err := increaseUserQuota(key, value)
+				err = increaseUserQuota(key, value)
 				if err != nil {
 					logger.SysError("failed to batch update user quota: " + err.Error())
 				}
 			case BatchUpdateTypeTokenQuota:
-				err := increaseTokenQuota(key, value)
+				err = increaseTokenQuota(key, value)
 				if err != nil {
 					logger.SysError("failed to batch update token quota: " + err.Error())
 				}
 			case BatchUpdateTypeUsedQuota:
-				updateUserUsedQuota(key, value)
+				count, merged := stores[BatchUpdateTypeRequestCount][key]
+				err = updateUserUsedQuotaAndRequestCount(key, value, int(count))
+				if err != nil {
+					logger.SysError("failed to batch update user used quota and request count: " + err.Error())
+				}
+				if merged {
+					done(BatchUpdateTypeRequestCount, key, count, err)
+				}
 			case BatchUpdateTypeRequestCount:
-				updateUserRequestCount(key, int(value))
+				if _, ok := stores[BatchUpdateTypeUsedQuota][key]; ok {
+					continue // updated along with the used quota
+				}
+				err = updateUserUsedQuotaAndRequestCount(key, 0, int(value))
+				if err != nil {
+					logger.SysError("failed to batch update user request count: " + err.Error())
+				}
 			case BatchUpdateTypeChannelUsedQuota:
-				updateChannelUsedQuota(key, value)
+				err = updateChannelUsedQuota(key, value)
+				if err != nil {
+					logger.SysError("failed to batch update channel used quota: " + err.Error())
+				}
 			}
+			done(i, key, value, err)
 		}
 	}
-	logger.SysLog("batch update finished")
+}
+
+// batchUpdateFromRedis applies the updates of the Redis hashes. An update is removed
+// from the flushing hash as soon as it is written, the ones left by a crash or a
+// database error are applied by the next round, before any new update.
+func batchUpdateFromRedis() {
+	ctx := context.Background()
+	var stores [BatchUpdateTypeCount]map[int]int64
+	for i := 0; i < BatchUpdateTypeCount; i++ {
+		stores[i] = make(map[int]int64)
+		pending, flushing := batchUpdateRedisKeys(i)
+		updates, err := common.TakeBatchUpdates(ctx, pending, flushing)
+		if err != nil {
+			logger.SysError("failed to take batch updates from Redis: " + err.Error())
+			continue
+		}
+		for field, value := range updates {
+			id, err := strconv.Atoi(field)
+			if err != nil {
+				continue
+			}
+			stores[i][id], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	applyBatchUpdates(stores, func(type_ int, id int, value int64, err error) {
+		if err != nil {
+			return
+		}
+		_, flushing := batchUpdateRedisKeys(type_)
+		if err = common.RDB.HDel(ctx, flushing, strconv.Itoa(id)).Err(); err != nil {
+			// the update will be applied twice
+			logger.SysError("failed to remove an applied batch update from Redis: " + err.Error())
+		}
+	})
 }
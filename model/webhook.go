@@ -0,0 +1,342 @@
+package model
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+const (
+	WebhookStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	WebhookStatusDisabled = 2 // also don't use 0
+)
+
+// Events sent to webhooks
+const (
+	WebhookEventChannelDisabled       = "channel.disabled"
+	WebhookEventChannelEnabled        = "channel.enabled"
+	WebhookEventBreakerTripped        = "channel.breaker_tripped"
+	WebhookEventCanaryRolledBack      = "channel.canary_rolled_back"
+	WebhookEventQuotaExhausted        = "user.quota_exhausted"
+	WebhookEventSpendThresholdCrossed = "user.spend_threshold_crossed"
+	WebhookEventBatchCompleted        = "batch.completed"
+	WebhookEventTokenRevoked          = "token.revoked"
+	WebhookEventPaymentSucceeded      = "payment.succeeded"
+	// WebhookEventTest is only sent by the test of a webhook
+	WebhookEventTest = "webhook.test"
+)
+
+var WebhookEvents = []string{
+	WebhookEventChannelDisabled,
+	WebhookEventChannelEnabled,
+	WebhookEventBreakerTripped,
+	WebhookEventCanaryRolledBack,
+	WebhookEventQuotaExhausted,
+	WebhookEventSpendThresholdCrossed,
+	WebhookEventBatchCompleted,
+	WebhookEventTokenRevoked,
+	WebhookEventPaymentSucceeded,
+}
+
+const (
+	webhookTimeout       = 10 * time.Second
+	maxWebhookRetryDelay = 5 * time.Minute
+)
+
+// Webhook receives the platform events it subscribes to, as JSON posted to its url and
+// signed with its secret
+type Webhook struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64)"`
+	URL         string `json:"url" gorm:"column:url;type:varchar(1024)"`
+	Secret      string `json:"secret" gorm:"type:text"` // encrypted when ENCRYPTION_KEY is set
+	Events      string `json:"events" gorm:"type:text"` // comma separated events, empty for all of them
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// WebhookPayload is the body posted to webhooks
+type WebhookPayload struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	CreatedAt int64  `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+func (w *Webhook) Validate() error {
+	parsed, err := url.Parse(w.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return errors.New("webhook url must be an http or https url")
+	}
+	if w.Secret == "" {
+		return errors.New("webhook needs a secret to sign its deliveries")
+	}
+	for _, event := range strings.Split(w.Events, ",") {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		known := false
+		for _, webhookEvent := range WebhookEvents {
+			if event == webhookEvent {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown webhook event: %s", event)
+		}
+	}
+	return nil
+}
+
+// subscribes tells whether the webhook receives an event
+func (w *Webhook) subscribes(event string) bool {
+	if strings.TrimSpace(w.Events) == "" {
+		return true
+	}
+	for _, subscribed := range strings.Split(w.Events, ",") {
+		if strings.TrimSpace(subscribed) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// BeforeSave encrypts the secret of the webhook when ENCRYPTION_KEY is set
+func (w *Webhook) BeforeSave(tx *gorm.DB) error {
+	if !common.EncryptionEnabled() || w.Secret == "" || common.IsEncrypted(w.Secret) {
+		return nil
+	}
+	secret, err := common.EncryptString(w.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+	}
+	w.Secret = secret
+	return nil
+}
+
+// AfterSave decrypts the secret encrypted by BeforeSave
+func (w *Webhook) AfterSave(tx *gorm.DB) error {
+	return w.openSecret()
+}
+
+func (w *Webhook) AfterFind(tx *gorm.DB) error {
+	return w.openSecret()
+}
+
+func (w *Webhook) openSecret() error {
+	secret, err := common.DecryptString(w.Secret)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the secret of webhook #%d: %w", w.Id, err)
+	}
+	w.Secret = secret
+	return nil
+}
+
+func GetAllWebhooks() ([]*Webhook, error) {
+	var webhooks []*Webhook
+	err := DB.Order("id desc").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func GetWebhookById(id int) (*Webhook, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	webhook := Webhook{Id: id}
+	err := DB.First(&webhook, "id = ?", id).Error
+	return &webhook, err
+}
+
+func (w *Webhook) Insert() error {
+	w.CreatedTime = helper.GetTimestamp()
+	return DB.Create(w).Error
+}
+
+func (w *Webhook) Update() error {
+	return DB.Model(w).Select("name", "url", "secret", "events", "status").Updates(w).Error
+}
+
+func (w *Webhook) Delete() error {
+	return DB.Delete(w).Error
+}
+
+var enabledWebhooks []*Webhook
+var webhookSyncLock sync.RWMutex
+
+// InitWebhookCache loads the enabled webhooks into memory, it is called on startup,
+// after every admin change and periodically
+func InitWebhookCache() {
+	var webhooks []*Webhook
+	err := DB.Where("status = ?", WebhookStatusEnabled).Order("id asc").Find(&webhooks).Error
+	if err != nil {
+		logger.SysError("failed to load webhooks: " + err.Error())
+		return
+	}
+	webhookSyncLock.Lock()
+	enabledWebhooks = webhooks
+	webhookSyncLock.Unlock()
+}
+
+func SyncWebhookCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitWebhookCache()
+	}
+}
+
+// cacheGetWebhooks returns the enabled webhooks subscribed to an event
+func cacheGetWebhooks(event string) []*Webhook {
+	webhookSyncLock.RLock()
+	defer webhookSyncLock.RUnlock()
+	var webhooks []*Webhook
+	for _, webhook := range enabledWebhooks {
+		if webhook.subscribes(event) {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks
+}
+
+// HasWebhooks tells whether an event is sent to a webhook, to skip preparing it otherwise
+func HasWebhooks(event string) bool {
+	return len(cacheGetWebhooks(event)) > 0
+}
+
+// EmitWebhookEvent sends an event to the webhooks subscribed to it, in the background,
+// retrying the failed deliveries with an exponential backoff
+func EmitWebhookEvent(event string, data any) {
+	webhooks := cacheGetWebhooks(event)
+	if len(webhooks) == 0 {
+		return
+	}
+	payload := WebhookPayload{
+		Id:        "evt_" + random.GetUUID(),
+		Type:      event,
+		CreatedAt: helper.GetTimestamp(),
+		Data:      data,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to marshal webhook event %s: %s", event, err.Error()))
+		return
+	}
+	for _, webhook := range webhooks {
+		go webhook.deliver(payload.Id, event, body)
+	}
+}
+
+func (w *Webhook) deliver(eventId string, event string, body []byte) {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := w.post(context.Background(), eventId, event, body)
+		if err == nil {
+			return
+		}
+		if attempt >= config.WebhookRetryTimes {
+			logger.SysError(fmt.Sprintf("failed to deliver event %s to webhook #%d after %d attempts: %s", eventId, w.Id, attempt+1, err.Error()))
+			return
+		}
+		time.Sleep(delay)
+		if delay *= 2; delay > maxWebhookRetryDelay {
+			delay = maxWebhookRetryDelay
+		}
+	}
+}
+
+// SignWebhookPayload returns the signature of a delivery, the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the secret of the webhook
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var webhookHTTPClient = &http.Client{Timeout: webhookTimeout}
+
+func (w *Webhook) post(ctx context.Context, eventId string, event string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := helper.GetTimestamp()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Id", eventId)
+	req.Header.Set("X-Webhook-Event", event)
+	req.Header.Set("X-Webhook-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, SignWebhookPayload(w.Secret, timestamp, body)))
+	resp, err := webhookHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("status code %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// SendTestEvent delivers a test event to the webhook once, and returns the error
+func (w *Webhook) SendTestEvent(ctx context.Context) error {
+	payload := WebhookPayload{
+		Id:        "evt_" + random.GetUUID(),
+		Type:      WebhookEventTest,
+		CreatedAt: helper.GetTimestamp(),
+		Data:      map[string]any{"webhook_id": w.Id},
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return w.post(ctx, payload.Id, WebhookEventTest, body)
+}
+
+// checkSpendThreshold sends the spend threshold event when the used quota of a user
+// passes a multiple of WebhookSpendThreshold
+func checkSpendThreshold(userId int, quota int64, pending bool) {
+	threshold := config.WebhookSpendThreshold
+	if threshold <= 0 || quota <= 0 || !HasWebhooks(WebhookEventSpendThresholdCrossed) {
+		return
+	}
+	var usedQuota int64
+	if err := DB.Model(&User{}).Where("id = ?", userId).Select("used_quota").Find(&usedQuota).Error; err != nil {
+		logger.SysError("failed to get user used quota: " + err.Error())
+		return
+	}
+	if pending {
+		// the quota is still in the batch updater
+		usedQuota += quota
+	}
+	if usedQuota/threshold == (usedQuota-quota)/threshold {
+		return
+	}
+	EmitWebhookEvent(WebhookEventSpendThresholdCrossed, map[string]any{
+		"user_id":    userId,
+		"username":   GetUsernameById(userId),
+		"used_quota": usedQuota,
+		"threshold":  usedQuota / threshold * threshold,
+	})
+}
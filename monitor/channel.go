@@ -3,6 +3,7 @@ package monitor
 import (
 	"fmt"
 
+	"github.com/songquanpeng/one-api/common/circuitbreaker"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/message"
@@ -42,6 +43,11 @@ func DisableChannel(channelId int, channelName string, reason string) {
 		`, channelName, channelId, reason),
 	)
 	notifyRootUser(subject, content)
+	model.EmitWebhookEvent(model.WebhookEventChannelDisabled, map[string]any{
+		"channel_id":   channelId,
+		"channel_name": channelName,
+		"reason":       reason,
+	})
 }
 
 func MetricDisableChannel(channelId int, successRate float64) {
@@ -58,6 +64,11 @@ func MetricDisableChannel(channelId int, successRate float64) {
 		`, channelId, config.MetricQueueSize, successRate*100, config.MetricSuccessRateThreshold*100),
 	)
 	notifyRootUser(subject, content)
+	model.EmitWebhookEvent(model.WebhookEventChannelDisabled, map[string]any{
+		"channel_id":   channelId,
+		"reason":       fmt.Sprintf("success rate %.2f%% in the last %d requests", successRate*100, config.MetricQueueSize),
+		"success_rate": successRate,
+	})
 }
 
 // EnableChannel enable & notify
@@ -74,4 +85,42 @@ func EnableChannel(channelId int, channelName string) {
 		`, channelName, channelId),
 	)
 	notifyRootUser(subject, content)
+	model.EmitWebhookEvent(model.WebhookEventChannelEnabled, map[string]any{
+		"channel_id":   channelId,
+		"channel_name": channelName,
+	})
+}
+
+// ChannelBreakerStateChanged sends the breaker tripped event when the circuit breaker of a channel opens
+func ChannelBreakerStateChanged(name string, from circuitbreaker.State, to circuitbreaker.State) {
+	if to != circuitbreaker.StateOpen {
+		return
+	}
+	logger.SysLog(fmt.Sprintf("circuit breaker %s tripped", name))
+	model.EmitWebhookEvent(model.WebhookEventBreakerTripped, map[string]any{
+		"breaker": name,
+		"from":    from.String(),
+		"to":      to.String(),
+	})
+}
+
+// CanaryRolledBack alerts the admins that the canary of a channel was rolled back
+func CanaryRolledBack(canary *model.ChannelCanary, channelName string) {
+	subject := fmt.Sprintf("渠道灰度回滚提醒")
+	content := message.EmailTemplate(
+		subject,
+		fmt.Sprintf(`
+			<p>您好！</p>
+			<p>渠道「<strong>%s</strong>」（#%d）的灰度发布已自动回滚，该渠道不再分配流量。</p>
+			<p>回滚原因：</p>
+			<p style="background-color: #f8f8f8; padding: 10px; border-radius: 4px;">%s</p>
+		`, channelName, canary.ChannelId, canary.Reason),
+	)
+	notifyRootUser(subject, content)
+	model.EmitWebhookEvent(model.WebhookEventCanaryRolledBack, map[string]any{
+		"canary_id":    canary.Id,
+		"channel_id":   canary.ChannelId,
+		"channel_name": channelName,
+		"reason":       canary.Reason,
+	})
 }
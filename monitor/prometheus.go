@@ -7,7 +7,11 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/loadshed"
+	"github.com/songquanpeng/one-api/common/priority"
+	"github.com/songquanpeng/one-api/model"
 )
 
 // MetricsCollector collects and exposes Prometheus-compatible metrics
@@ -27,6 +31,23 @@ type MetricsCollector struct {
 	tokensUsed        *CounterVec
 	quotaUsed         *CounterVec
 	
+	// Moderation metrics
+	moderationOutcomes *CounterVec
+	
+	// Image metrics
+	imagesGenerated   *CounterVec
+	
+	// Model alias metrics
+	modelAliasHits    *CounterVec
+	
+	// Tenant metrics
+	tenantRequests    *CounterVec
+
+	// Priority metrics
+	priorityRequests  *CounterVec
+	priorityLatency   *HistogramVec
+	queueWait         *HistogramVec
+	
 	// System metrics
 	activeConnections *Gauge
 	
@@ -241,23 +262,23 @@ func GetMetricsCollector() *MetricsCollector {
 			channelRequests: NewCounterVec(
 				"oneapi_channel_requests_total",
 				"Total number of requests per channel",
-				[]string{"channel_id", "channel_name", "model"},
+				[]string{"channel_id", "channel_name", "tags", "model"},
 			),
 			channelErrors: NewCounterVec(
 				"oneapi_channel_errors_total",
 				"Total number of errors per channel",
-				[]string{"channel_id", "channel_name", "model", "error_type"},
+				[]string{"channel_id", "channel_name", "tags", "model", "error_type"},
 			),
 			channelLatency: NewHistogramVec(
 				"oneapi_channel_latency_seconds",
 				"Channel response latency in seconds",
-				[]string{"channel_id", "channel_name", "model"},
+				[]string{"channel_id", "channel_name", "tags", "model"},
 				[]float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120},
 			),
 			channelStatus: NewGaugeVec(
 				"oneapi_channel_status",
 				"Channel status (1=enabled, 0=disabled)",
-				[]string{"channel_id", "channel_name"},
+				[]string{"channel_id", "channel_name", "tags"},
 			),
 			tokensUsed: NewCounterVec(
 				"oneapi_tokens_used_total",
@@ -269,6 +290,43 @@ func GetMetricsCollector() *MetricsCollector {
 				"Total quota used",
 				[]string{"user_id", "model"},
 			),
+			moderationOutcomes: NewCounterVec(
+				"oneapi_moderation_outcomes_total",
+				"Total number of moderation outcomes",
+				[]string{"group", "stage", "action"}, // stage: request, response
+			),
+			imagesGenerated: NewCounterVec(
+				"oneapi_images_total",
+				"Total number of images generated or edited",
+				[]string{"model", "operation"}, // operation: generations, edits
+			),
+			modelAliasHits: NewCounterVec(
+				"oneapi_model_alias_hits_total",
+				"Total number of requests redirected by a model alias",
+				[]string{"alias", "target"},
+			),
+			tenantRequests: NewCounterVec(
+				"oneapi_tenant_requests_total",
+				"Total number of relay requests per tenant",
+				[]string{"tenant_id", "model", "result"}, // result: success, error
+			),
+			priorityRequests: NewCounterVec(
+				"oneapi_priority_requests_total",
+				"Total number of relay requests per priority of their token",
+				[]string{"priority", "result"}, // result: success, error
+			),
+			priorityLatency: NewHistogramVec(
+				"oneapi_priority_latency_seconds",
+				"Relay request latency per priority of their token in seconds, retries and queueing included",
+				[]string{"priority"},
+				[]float64{0.1, 0.5, 1, 2, 5, 10, 30, 60, 120},
+			),
+			queueWait: NewHistogramVec(
+				"oneapi_relay_queue_wait_seconds",
+				"Time the relay requests waited for a slot of RELAY_MAX_CONCURRENCY in seconds",
+				[]string{"priority"},
+				[]float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+			),
 			activeConnections: NewGauge(
 				"oneapi_active_connections",
 				"Number of active connections",
@@ -285,31 +343,32 @@ func (m *MetricsCollector) RecordRequest(method, path string, status int, durati
 	m.requestDuration.Observe(duration.Seconds(), method, path)
 }
 
-// RecordChannelRequest records a channel request
-func (m *MetricsCollector) RecordChannelRequest(channelID int, channelName, model string, duration time.Duration, success bool) {
+// RecordChannelRequest records a channel request, tags are the comma separated tags of the
+// channel
+func (m *MetricsCollector) RecordChannelRequest(channelID int, channelName, tags, model string, duration time.Duration, success bool) {
 	idStr := strconv.Itoa(channelID)
-	m.channelRequests.Inc(idStr, channelName, model)
-	m.channelLatency.Observe(duration.Seconds(), idStr, channelName, model)
+	m.channelRequests.Inc(idStr, channelName, tags, model)
+	m.channelLatency.Observe(duration.Seconds(), idStr, channelName, tags, model)
 	
 	if !success {
-		m.channelErrors.Inc(idStr, channelName, model, "request_failed")
+		m.channelErrors.Inc(idStr, channelName, tags, model, "request_failed")
 	}
 }
 
 // RecordChannelError records a channel error
-func (m *MetricsCollector) RecordChannelError(channelID int, channelName, model, errorType string) {
+func (m *MetricsCollector) RecordChannelError(channelID int, channelName, tags, model, errorType string) {
 	idStr := strconv.Itoa(channelID)
-	m.channelErrors.Inc(idStr, channelName, model, errorType)
+	m.channelErrors.Inc(idStr, channelName, tags, model, errorType)
 }
 
 // SetChannelStatus sets the channel status
-func (m *MetricsCollector) SetChannelStatus(channelID int, channelName string, enabled bool) {
+func (m *MetricsCollector) SetChannelStatus(channelID int, channelName, tags string, enabled bool) {
 	idStr := strconv.Itoa(channelID)
 	value := 0.0
 	if enabled {
 		value = 1.0
 	}
-	m.channelStatus.Set(value, idStr, channelName)
+	m.channelStatus.Set(value, idStr, channelName, tags)
 }
 
 // RecordTokens records token usage
@@ -323,6 +382,46 @@ func (m *MetricsCollector) RecordQuota(userID int, model string, quota int) {
 	m.quotaUsed.Add(float64(quota), strconv.Itoa(userID), model)
 }
 
+// RecordModeration records the outcome of a moderation policy
+func (m *MetricsCollector) RecordModeration(group, stage, action string) {
+	m.moderationOutcomes.Inc(group, stage, action)
+}
+
+// RecordImages records the images of a generation or an edit
+func (m *MetricsCollector) RecordImages(model, operation string, count int) {
+	m.imagesGenerated.Add(float64(count), model, operation)
+}
+
+// RecordModelAliasHit records a request redirected by a model alias
+func (m *MetricsCollector) RecordModelAliasHit(alias, target string) {
+	m.modelAliasHits.Inc(alias, target)
+}
+
+// RecordTenantRequest records a relay request of a tenant
+func (m *MetricsCollector) RecordTenantRequest(tenantID int, model string, success bool) {
+	result := "success"
+	if !success {
+		result = "error"
+	}
+	m.tenantRequests.Inc(strconv.Itoa(tenantID), model, result)
+}
+
+// RecordPriorityRequest records a relay request with its latency, from its admission, by the
+// priority of its token
+func (m *MetricsCollector) RecordPriorityRequest(priority string, duration time.Duration, success bool) {
+	result := "success"
+	if !success {
+		result = "error"
+	}
+	m.priorityRequests.Inc(priority, result)
+	m.priorityLatency.Observe(duration.Seconds(), priority)
+}
+
+// RecordQueueWait records the time a relay request waited for a slot
+func (m *MetricsCollector) RecordQueueWait(priority string, wait time.Duration) {
+	m.queueWait.Observe(wait.Seconds(), priority)
+}
+
 // IncrementInFlight increments the in-flight request count
 func (m *MetricsCollector) IncrementInFlight(path string) {
 	m.requestsInFlight.Inc(path)
@@ -367,16 +466,152 @@ func (m *MetricsCollector) generatePrometheusOutput() string {
 	output += formatCounter(m.channelErrors)
 	output += formatCounter(m.tokensUsed)
 	output += formatCounter(m.quotaUsed)
+	output += formatCounter(m.moderationOutcomes)
+	output += formatCounter(m.imagesGenerated)
+	output += formatCounter(m.modelAliasHits)
+	output += formatCounter(m.tenantRequests)
+	output += formatCounter(m.priorityRequests)
 	
 	// Histograms
 	output += formatHistogram(m.requestDuration)
 	output += formatHistogram(m.channelLatency)
+	output += formatHistogram(m.priorityLatency)
+	output += formatHistogram(m.queueWait)
 	
 	// Gauges
 	output += formatGaugeVec(m.requestsInFlight)
 	output += formatGaugeVec(m.channelStatus)
 	output += formatGauge(m.activeConnections)
 	
+	// Connection pools
+	output += formatPoolStats(client.GetPoolManager().GetTraceStats())
+	output += formatDNSCacheStats(client.GetDNSCacheStats())
+	
+	// Log batcher
+	output += formatLogBatcherStats(model.GetLogBatcher().Durability())
+	output += formatLogSinkStats(model.GetLogSinkStats())
+	
+	// Load shedding
+	output += formatLoadSheddingStats(loadshed.GetStats())
+	if queue := priority.RelayQueue(); queue != nil {
+		output += formatRelayQueueStats(queue)
+	}
+	
+	return output
+}
+
+// formatLoadSheddingStats exposes the resources the load shedding watches and the requests it shed
+func formatLoadSheddingStats(stats loadshed.Stats) string {
+	shed := NewCounterVec("oneapi_load_shed_requests_total", "Relay requests rejected because the process was overloaded", []string{"priority"})
+	shed.Add(float64(stats.ShedBatch), "batch")
+	shed.Add(float64(stats.ShedLow), "low")
+	shed.Add(float64(stats.ShedHigh), "high")
+	level := NewGaugeVec("oneapi_load_shedding_level", "Load shedding level (0=normal, 1=overloaded, 2=critical)", nil)
+	level.Set(float64(stats.Level))
+	pressure := NewGaugeVec("oneapi_load_pressure", "Highest ratio of a resource of the process to its threshold", nil)
+	pressure.Set(stats.Pressure)
+	resources := NewGaugeVec("oneapi_process_resources", "Resources used by the process", []string{"resource"})
+	resources.Set(stats.CPUPercent, "cpu_percent")
+	resources.Set(float64(stats.MemoryBytes), "memory_bytes")
+	resources.Set(float64(stats.Goroutines), "goroutines")
+	return formatCounter(shed) + formatGaugeVec(level) + formatGaugeVec(pressure) + formatGaugeVec(resources)
+}
+
+// formatRelayQueueStats exposes the relay requests holding a slot and waiting for one
+func formatRelayQueueStats(queue *priority.Queue) string {
+	running := NewGaugeVec("oneapi_relay_queue_running", "Relay requests holding a slot of RELAY_MAX_CONCURRENCY", nil)
+	running.Set(float64(queue.Running()))
+	waiting := NewGaugeVec("oneapi_relay_queue_waiting", "Relay requests waiting for a slot by priority", []string{"priority"})
+	for _, class := range priority.Classes {
+		waiting.Set(float64(queue.Waiting(class)), class)
+	}
+	return formatGaugeVec(running) + formatGaugeVec(waiting)
+}
+
+// formatDNSCacheStats exposes the upstream DNS cache efficiency and resolution latency
+func formatDNSCacheStats(stats client.DNSCacheStats) string {
+	cache := NewCounterVec("oneapi_dns_cache_total", "Upstream DNS cache lookups", []string{"result"})
+	cache.Add(float64(stats.Hits), "hit")
+	cache.Add(float64(stats.Misses), "miss")
+	cache.Add(float64(stats.NegativeHits), "negative_hit")
+	lookupSum := NewCounterVec("oneapi_dns_lookup_duration_seconds_sum", "Total duration of upstream DNS resolutions", nil)
+	lookupSum.Add(stats.Lookups.TotalSeconds)
+	lookupCount := NewCounterVec("oneapi_dns_lookup_duration_seconds_count", "Number of upstream DNS resolutions", nil)
+	lookupCount.Add(float64(stats.Lookups.Count))
+	return formatCounter(cache) + formatCounter(lookupSum) + formatCounter(lookupCount)
+}
+
+// formatLogBatcherStats exposes what happened to the logs of failed batch inserts
+func formatLogBatcherStats(stats model.LogBatcherDurability) string {
+	entries := NewCounterVec("oneapi_log_batcher_entries_total", "Logs of failed batch inserts", []string{"result"})
+	entries.Add(float64(stats.Spilled), "spilled")
+	entries.Add(float64(stats.Replayed), "replayed")
+	entries.Add(float64(stats.Dropped), "dropped")
+	retries := NewCounterVec("oneapi_log_batcher_retries_total", "Retried log batch inserts", nil)
+	retries.Add(float64(stats.Retried))
+	spill := NewGaugeVec("oneapi_log_spill", "Logs waiting on disk to be replayed", []string{"unit"})
+	spill.Set(float64(stats.SpillBytes), "bytes")
+	spill.Set(float64(stats.SpillSegments), "segments")
+	return formatCounter(entries) + formatCounter(retries) + formatGaugeVec(spill)
+}
+
+// formatLogSinkStats exposes the delivery of the logs to each log sink
+func formatLogSinkStats(sinks []model.LogSinkStats) string {
+	if len(sinks) == 0 {
+		return ""
+	}
+	entries := NewCounterVec("oneapi_log_sink_entries_total", "Logs handled by the log sinks", []string{"sink", "result"})
+	queued := NewGaugeVec("oneapi_log_sink_queued", "Logs waiting to be written to a log sink", []string{"sink"})
+	for _, sink := range sinks {
+		entries.Add(float64(sink.Written), sink.Name, "written")
+		entries.Add(float64(sink.Failed), sink.Name, "failed")
+		entries.Add(float64(sink.Dropped), sink.Name, "dropped")
+		queued.Set(float64(sink.Queued), sink.Name)
+	}
+	return formatCounter(entries) + formatGaugeVec(queued)
+}
+
+// formatPoolStats exposes the httptrace statistics of the connection pools
+func formatPoolStats(pools map[string]client.PoolTraceStats) string {
+	if len(pools) == 0 {
+		return ""
+	}
+	connections := NewCounterVec("oneapi_pool_connections_total", "Connections obtained by upstream pools", []string{"pool", "type"})
+	hostConnections := NewGaugeVec("oneapi_pool_host_connections", "Upstream connections per host", []string{"pool", "host", "state"})
+	warmRequests := NewCounterVec("oneapi_pool_warm_requests_total", "Pre-warming requests of upstream pools", []string{"pool", "result"})
+	warmConnections := NewCounterVec("oneapi_pool_warm_connections_total", "Connections opened by pre-warming and later used by relay requests", []string{"pool", "type"})
+	warmSaved := NewCounterVec("oneapi_pool_warm_saved_seconds_total", "Estimated connect and TLS handshake time saved by pre-warming", []string{"pool"})
+	phases := map[string]*CounterVec{}
+	for _, phase := range []string{"dns", "connect", "tls"} {
+		phases[phase+"_sum"] = NewCounterVec("oneapi_pool_"+phase+"_duration_seconds_sum", "Total "+phase+" duration of new upstream connections", []string{"pool"})
+		phases[phase+"_count"] = NewCounterVec("oneapi_pool_"+phase+"_duration_seconds_count", "Number of "+phase+" phases of new upstream connections", []string{"pool"})
+	}
+	for pool, stats := range pools {
+		connections.Add(float64(stats.NewConnections), pool, "new")
+		connections.Add(float64(stats.ReusedConnections), pool, "reused")
+		for phase, phaseStats := range map[string]client.PhaseStats{"dns": stats.DNS, "connect": stats.Connect, "tls": stats.TLS} {
+			phases[phase+"_sum"].Add(phaseStats.TotalSeconds, pool)
+			phases[phase+"_count"].Add(float64(phaseStats.Count), pool)
+		}
+		if stats.Warm.Requests > 0 {
+			warmRequests.Add(float64(stats.Warm.Requests-stats.Warm.Failures), pool, "success")
+			warmRequests.Add(float64(stats.Warm.Failures), pool, "failure")
+			warmConnections.Add(float64(stats.Warm.Connections), pool, "opened")
+			warmConnections.Add(float64(stats.Warm.Hits), pool, "used")
+			warmSaved.Add(stats.Warm.SavedSeconds, pool)
+		}
+		for host, hostStats := range stats.Hosts {
+			hostConnections.Set(float64(hostStats.Active), pool, host, "active")
+			hostConnections.Set(float64(hostStats.Idle), pool, host, "idle")
+		}
+	}
+	output := formatCounter(connections)
+	for _, phase := range []string{"dns", "connect", "tls"} {
+		output += formatCounter(phases[phase+"_sum"])
+		output += formatCounter(phases[phase+"_count"])
+	}
+	output += formatGaugeVec(hostConnections)
+	output += formatCounter(warmRequests) + formatCounter(warmConnections) + formatCounter(warmSaved)
 	return output
 }
 
@@ -0,0 +1,283 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Inbound requests use the Anthropic format, they are converted to the OpenAI format
+// before the relay and the responses converted back
+
+type InboundContent struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Source    *InboundSource  `json:"source,omitempty"`
+	Id        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseId string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"` // string or content blocks
+	IsError   bool            `json:"is_error,omitempty"`
+	// passed to the channels with prompt caching
+	CacheControl any `json:"cache_control,omitempty"`
+}
+
+type InboundSource struct {
+	Type      string `json:"type"` // base64 or url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+type InboundMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // string or content blocks
+}
+
+type InboundTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type InboundToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type InboundRequest struct {
+	Model         string             `json:"model"`
+	Messages      []InboundMessage   `json:"messages"`
+	System        json.RawMessage    `json:"system,omitempty"` // string or text blocks
+	MaxTokens     int                `json:"max_tokens"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	TopK          int                `json:"top_k,omitempty"`
+	Tools         []InboundTool      `json:"tools,omitempty"`
+	ToolChoice    *InboundToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *Metadata          `json:"metadata,omitempty"`
+}
+
+// parseInboundContent accepts both the string and the content blocks forms
+func parseInboundContent(raw json.RawMessage) ([]InboundContent, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []InboundContent{{Type: "text", Text: text}}, nil
+	}
+	var contents []InboundContent
+	if err := json.Unmarshal(raw, &contents); err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+func inboundText(contents []InboundContent) string {
+	var texts []string
+	for _, content := range contents {
+		if content.Type == "text" {
+			texts = append(texts, content.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func inboundImageURL(source *InboundSource) string {
+	if source.Type == "url" {
+		return source.Url
+	}
+	return model.ImageDataURL(source.MediaType, source.Data)
+}
+
+func inboundImages(contents []InboundContent) []model.MessageContent {
+	var images []model.MessageContent
+	for _, content := range contents {
+		if content.Type == "image" && content.Source != nil {
+			images = append(images, model.MessageContent{
+				Type:     model.ContentTypeImageURL,
+				ImageURL: &model.ImageURL{Url: inboundImageURL(content.Source)},
+			})
+		}
+	}
+	return images
+}
+
+func convertInboundToolChoice(choice *InboundToolChoice) any {
+	switch choice.Type {
+	case "any":
+		return "required"
+	case "none":
+		return "none"
+	case "tool":
+		return map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": choice.Name},
+		}
+	}
+	return "auto"
+}
+
+// convertInboundMessage maps a message to OpenAI messages, tool results become
+// tool messages which must precede the rest of the user content
+func convertInboundMessage(message InboundMessage) ([]model.Message, error) {
+	contents, err := parseInboundContent(message.Content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content of a %s message: %w", message.Role, err)
+	}
+	var messages []model.Message
+	var parts []model.MessageContent
+	var toolCalls []model.Tool
+	for _, content := range contents {
+		switch content.Type {
+		case "text":
+			parts = append(parts, model.MessageContent{Type: model.ContentTypeText, Text: content.Text, CacheControl: content.CacheControl})
+		case "image":
+			if content.Source != nil {
+				parts = append(parts, model.MessageContent{
+					Type:         model.ContentTypeImageURL,
+					ImageURL:     &model.ImageURL{Url: inboundImageURL(content.Source)},
+					CacheControl: content.CacheControl,
+				})
+			}
+		case "tool_use":
+			arguments := string(content.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   content.Id,
+				Type: "function",
+				Function: model.Function{
+					Name:      content.Name,
+					Arguments: arguments,
+				},
+			})
+		case "tool_result":
+			results, err := parseInboundContent(content.Content)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tool result content: %w", err)
+			}
+			text := inboundText(results)
+			if content.IsError {
+				text = "Error: " + text
+			}
+			var result any = text
+			// the images of a result are kept, the adaptors of the providers which accept
+			// only text move them to a user message
+			if images := inboundImages(results); len(images) > 0 {
+				result = append([]model.MessageContent{{Type: model.ContentTypeText, Text: text}}, images...)
+			}
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    result,
+				ToolCallId: content.ToolUseId,
+			})
+		}
+		// thinking and other blocks are not sent upstream
+	}
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages, nil
+	}
+	converted := model.Message{Role: message.Role, ToolCalls: toolCalls}
+	if message.Role == "assistant" || !(hasImage(parts) || hasCacheControl(parts)) {
+		var texts []string
+		for _, part := range parts {
+			texts = append(texts, part.Text)
+		}
+		converted.Content = strings.Join(texts, "\n")
+	} else {
+		converted.Content = parts
+	}
+	return append(messages, converted), nil
+}
+
+func hasImage(parts []model.MessageContent) bool {
+	for _, part := range parts {
+		if part.Type == model.ContentTypeImageURL {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCacheControl(parts []model.MessageContent) bool {
+	for _, part := range parts {
+		if part.CacheControl != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// systemContent keeps the blocks of a system prompt which has cache breakpoints
+func systemContent(system []InboundContent) any {
+	var parts []model.MessageContent
+	for _, content := range system {
+		if content.Type == "text" {
+			parts = append(parts, model.MessageContent{Type: model.ContentTypeText, Text: content.Text, CacheControl: content.CacheControl})
+		}
+	}
+	if !hasCacheControl(parts) {
+		return inboundText(system)
+	}
+	return parts
+}
+
+// ConvertInboundRequest converts an Anthropic messages request to the OpenAI chat format
+func ConvertInboundRequest(request *InboundRequest) (*model.GeneralOpenAIRequest, error) {
+	if request.Model == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:       request.Model,
+		MaxTokens:   request.MaxTokens,
+		Stream:      request.Stream,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		TopK:        request.TopK,
+	}
+	if request.Stream {
+		openaiRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if len(request.StopSequences) > 0 {
+		openaiRequest.Stop = request.StopSequences
+	}
+	if request.Metadata != nil {
+		openaiRequest.User = request.Metadata.UserId
+	}
+	system, err := parseInboundContent(request.System)
+	if err != nil {
+		return nil, fmt.Errorf("invalid system: %w", err)
+	}
+	if len(system) > 0 {
+		openaiRequest.Messages = append(openaiRequest.Messages, model.Message{Role: "system", Content: systemContent(system)})
+	}
+	for _, message := range request.Messages {
+		messages, err := convertInboundMessage(message)
+		if err != nil {
+			return nil, err
+		}
+		openaiRequest.Messages = append(openaiRequest.Messages, messages...)
+	}
+	for _, tool := range request.Tools {
+		openaiRequest.Tools = append(openaiRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+	if request.ToolChoice != nil && len(request.Tools) > 0 {
+		openaiRequest.ToolChoice = convertInboundToolChoice(request.ToolChoice)
+	}
+	return &openaiRequest, nil
+}
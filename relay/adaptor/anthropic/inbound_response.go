@@ -0,0 +1,217 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func stopReasonOpenAI2Claude(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+func errorTypeByStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+// InboundError is the Anthropic error body
+func InboundError(statusCode int, message string) gin.H {
+	return gin.H{
+		"type": "error",
+		"error": Error{
+			Type:    errorTypeByStatus(statusCode),
+			Message: message,
+		},
+	}
+}
+
+func toolArgumentsInput(arguments any) json.RawMessage {
+	text, _ := arguments.(string)
+	if text == "" || !json.Valid([]byte(text)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(text)
+}
+
+// ConvertInboundResponse converts an OpenAI chat completion to an Anthropic message
+func ConvertInboundResponse(response *openai.TextResponse) map[string]any {
+	content := make([]map[string]any, 0)
+	stopReason := "end_turn"
+	if len(response.Choices) > 0 {
+		choice := response.Choices[0]
+		if reasoning, ok := choice.ReasoningContent.(string); ok && reasoning != "" {
+			content = append(content, map[string]any{"type": "thinking", "thinking": reasoning, "signature": ""})
+		}
+		if text := choice.StringContent(); text != "" {
+			content = append(content, map[string]any{"type": "text", "text": text})
+		}
+		for _, toolCall := range choice.ToolCalls {
+			content = append(content, map[string]any{
+				"type":  "tool_use",
+				"id":    toolCall.Id,
+				"name":  toolCall.Function.Name,
+				"input": toolArgumentsInput(toolCall.Function.Arguments),
+			})
+		}
+		stopReason = stopReasonOpenAI2Claude(choice.FinishReason)
+	}
+	return map[string]any{
+		"id":            response.Id,
+		"type":          "message",
+		"role":          "assistant",
+		"model":         response.Model,
+		"content":       content,
+		"stop_reason":   stopReason,
+		"stop_sequence": nil,
+		"usage":         inboundUsage(response.Usage),
+	}
+}
+
+// inboundUsage converts a usage, the input tokens of Anthropic do not include the cache reads
+func inboundUsage(usage model.Usage) Usage {
+	cachedTokens := usage.GetCachedTokens()
+	return Usage{
+		InputTokens:          usage.PromptTokens - cachedTokens,
+		OutputTokens:         usage.CompletionTokens,
+		CacheReadInputTokens: cachedTokens,
+	}
+}
+
+// InboundResponseWriter converts the OpenAI responses written by the relay to the
+// Anthropic format, a JSON body is buffered until Finish, a stream is converted event by
+// event by an InboundStreamTranscoder
+type InboundResponseWriter struct {
+	gin.ResponseWriter
+	body       bytes.Buffer
+	streaming  bool
+	pending    []byte // partial SSE line
+	model      string
+	transcoder *InboundStreamTranscoder
+}
+
+func NewInboundResponseWriter(w gin.ResponseWriter, model string) *InboundResponseWriter {
+	return &InboundResponseWriter{ResponseWriter: w, model: model, transcoder: NewInboundStreamTranscoder(model)}
+}
+
+func (w *InboundResponseWriter) Write(data []byte) (int, error) {
+	if !w.streaming && w.body.Len() == 0 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+	}
+	if !w.streaming {
+		return w.body.Write(data)
+	}
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:end]))
+		w.pending = w.pending[end+1:]
+		if err := w.handleStreamLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *InboundResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *InboundResponseWriter) render(events []StreamEvent) error {
+	for _, event := range events {
+		if err := event.Render(w.ResponseWriter); err != nil {
+			return err
+		}
+	}
+	if len(events) > 0 {
+		w.Flush()
+	}
+	return nil
+}
+
+func (w *InboundResponseWriter) handleStreamLine(line string) error {
+	if !strings.HasPrefix(line, "data:") {
+		return nil
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return w.render(w.transcoder.Finish())
+	}
+	var chunk openai.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil // not a chat completion chunk
+	}
+	return w.render(w.transcoder.Transcode(&chunk))
+}
+
+// Finish writes the converted JSON body, or ends a stream whose [DONE] was missing
+func (w *InboundResponseWriter) Finish() {
+	if w.streaming {
+		_ = w.render(w.transcoder.Finish())
+		return
+	}
+	if w.body.Len() == 0 {
+		return
+	}
+	statusCode := w.Status()
+	body := w.body.Bytes()
+	var converted any
+	if statusCode >= http.StatusBadRequest {
+		var errorResponse struct {
+			Error model.Error `json:"error"`
+		}
+		_ = json.Unmarshal(body, &errorResponse)
+		message := errorResponse.Error.Message
+		if message == "" {
+			message = strings.TrimSpace(string(body))
+		}
+		converted = InboundError(statusCode, message)
+	} else {
+		var response openai.TextResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			converted = InboundError(http.StatusBadGateway, "invalid upstream response: "+err.Error())
+		} else {
+			if response.Model == "" {
+				response.Model = w.model
+			}
+			converted = ConvertInboundResponse(&response)
+		}
+	}
+	payload, _ := json.Marshal(converted)
+	w.Header().Del("Content-Length") // copied from the upstream response
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.ResponseWriter.Write(payload)
+}
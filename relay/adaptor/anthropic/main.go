@@ -11,6 +11,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -41,11 +43,15 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 
 	for _, tool := range textRequest.Tools {
 		if params, ok := tool.Function.Parameters.(map[string]any); ok {
+			schemaType, _ := params["type"].(string)
+			if schemaType == "" {
+				schemaType = "object"
+			}
 			claudeTools = append(claudeTools, Tool{
 				Name:        tool.Function.Name,
 				Description: tool.Function.Description,
 				InputSchema: InputSchema{
-					Type:       params["type"].(string),
+					Type:       schemaType,
 					Properties: params["properties"],
 					Required:   params["required"],
 				},
@@ -73,8 +79,11 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 				claudeToolChoice.Name = function["name"].(string)
 			}
 		} else if toolChoiceType, ok := textRequest.ToolChoice.(string); ok {
-			if toolChoiceType == "any" {
-				claudeToolChoice.Type = toolChoiceType
+			switch toolChoiceType {
+			case "any", "required":
+				claudeToolChoice.Type = "any"
+			case "none":
+				claudeToolChoice.Type = "none"
 			}
 		}
 		claudeRequest.ToolChoice = claudeToolChoice
@@ -82,6 +91,21 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 	if claudeRequest.MaxTokens == 0 {
 		claudeRequest.MaxTokens = 4096
 	}
+	if textRequest.ReasoningEffort != nil {
+		if budgetTokens, ok := thinkingBudgets[*textRequest.ReasoningEffort]; ok {
+			claudeRequest.Thinking = &Thinking{
+				Type:         "enabled",
+				BudgetTokens: budgetTokens,
+			}
+			// the budget is part of max_tokens, and thinking does not allow sampling parameters
+			if claudeRequest.MaxTokens <= budgetTokens {
+				claudeRequest.MaxTokens += budgetTokens
+			}
+			claudeRequest.Temperature = nil
+			claudeRequest.TopP = nil
+			claudeRequest.TopK = 0
+		}
+	}
 	// legacy model name mapping
 	if claudeRequest.Model == "claude-instant-1" {
 		claudeRequest.Model = "claude-instant-1.1"
@@ -89,28 +113,44 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 		claudeRequest.Model = "claude-2.1"
 	}
 	for _, message := range textRequest.Messages {
-		if message.Role == "system" && claudeRequest.System == "" {
-			claudeRequest.System = message.StringContent()
+		if message.Role == "system" && claudeRequest.System == nil {
+			claudeRequest.System = convertSystem(message)
+			continue
+		}
+		if message.Role == "tool" {
+			toolResult := Content{
+				Type:      "tool_result",
+				Content:   toolResultContent(message),
+				ToolUseId: message.ToolCallId,
+			}
+			// the results of parallel tool calls must all be in the next user message
+			if last := len(claudeRequest.Messages) - 1; last >= 0 && isToolResultMessage(claudeRequest.Messages[last]) {
+				claudeRequest.Messages[last].Content = append(claudeRequest.Messages[last].Content, toolResult)
+			} else {
+				claudeRequest.Messages = append(claudeRequest.Messages, Message{
+					Role:    "user",
+					Content: []Content{toolResult},
+				})
+			}
 			continue
 		}
 		claudeMessage := Message{
 			Role: message.Role,
 		}
 		var content Content
-		if message.IsStringContent() {
+		// an assistant message with tool calls may have a null content
+		if message.IsStringContent() || message.Content == nil {
 			content.Type = "text"
 			content.Text = message.StringContent()
-			if message.Role == "tool" {
-				claudeMessage.Role = "user"
-				content.Type = "tool_result"
-				content.Content = content.Text
-				content.Text = ""
-				content.ToolUseId = message.ToolCallId
+			// an assistant message with tool calls often has no text, which Claude rejects
+			if content.Text != "" || len(message.ToolCalls) == 0 {
+				claudeMessage.Content = append(claudeMessage.Content, content)
 			}
-			claudeMessage.Content = append(claudeMessage.Content, content)
 			for i := range message.ToolCalls {
 				inputParam := make(map[string]any)
-				_ = json.Unmarshal([]byte(message.ToolCalls[i].Function.Arguments.(string)), &inputParam)
+				if arguments, ok := message.ToolCalls[i].Function.Arguments.(string); ok {
+					_ = json.Unmarshal([]byte(arguments), &inputParam)
+				}
 				claudeMessage.Content = append(claudeMessage.Content, Content{
 					Type:  "tool_use",
 					Id:    message.ToolCalls[i].Id,
@@ -121,34 +161,127 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *Request {
 			claudeRequest.Messages = append(claudeRequest.Messages, claudeMessage)
 			continue
 		}
-		var contents []Content
-		openaiContent := message.ParseContent()
-		for _, part := range openaiContent {
-			var content Content
-			if part.Type == model.ContentTypeText {
-				content.Type = "text"
-				content.Text = part.Text
-			} else if part.Type == model.ContentTypeImageURL {
-				content.Type = "image"
-				content.Source = &ImageSource{
-					Type: "base64",
-				}
-				mimeType, data, _ := image.GetImageFromUrl(part.ImageURL.Url)
-				content.Source.MediaType = mimeType
-				content.Source.Data = data
-			}
-			contents = append(contents, content)
-		}
-		claudeMessage.Content = contents
+		claudeMessage.Content = convertContentParts(message.ParseContent())
 		claudeRequest.Messages = append(claudeRequest.Messages, claudeMessage)
 	}
 	return &claudeRequest
 }
 
+// convertContentParts converts the parts of a message to content blocks
+func convertContentParts(parts []model.MessageContent) []Content {
+	var contents []Content
+	for _, part := range parts {
+		content := Content{CacheControl: part.CacheControl}
+		switch part.Type {
+		case model.ContentTypeText:
+			content.Type = "text"
+			content.Text = part.Text
+		case model.ContentTypeImageURL:
+			content.Type = "image"
+			content.Source = convertImage(part.ImageURL)
+		default:
+			continue
+		}
+		contents = append(contents, content)
+	}
+	return contents
+}
+
+// convertImage inlines an image, an image which cannot be fetched is passed by its url for
+// Claude to fetch
+func convertImage(imageURL *model.ImageURL) *ImageSource {
+	if mimeType, data, ok := imageURL.ParseDataURL(); ok {
+		return &ImageSource{Type: "base64", MediaType: mimeType, Data: data}
+	}
+	mimeType, data, err := image.GetImageFromUrl(imageURL.Url)
+	if err != nil || data == "" {
+		return &ImageSource{Type: "url", Url: imageURL.Url}
+	}
+	return &ImageSource{Type: "base64", MediaType: mimeType, Data: data}
+}
+
+// toolResultContent returns the result of a tool call as a string, or as content blocks when
+// it has images
+func toolResultContent(message model.Message) any {
+	if !message.HasImage() {
+		return message.StringContent()
+	}
+	return convertContentParts(message.ParseContent())
+}
+
+// thinkingBudgets maps the reasoning_effort of OpenAI to a thinking budget
+var thinkingBudgets = map[string]int{
+	"low":    1024,
+	"medium": 4096,
+	"high":   16384,
+}
+
+// SetReasoningTokens sets the reasoning tokens of a usage from the thinking text, Claude
+// counts them in its output tokens without reporting them apart
+func SetReasoningTokens(usage *model.Usage, thinking string, modelName string) {
+	reasoningTokens := openai.CountTokenText(thinking, modelName)
+	if reasoningTokens > usage.CompletionTokens {
+		reasoningTokens = usage.CompletionTokens
+	}
+	if reasoningTokens > 0 {
+		usage.CompletionTokensDetails = &model.CompletionTokensDetails{ReasoningTokens: reasoningTokens}
+	}
+}
+
+// convertSystem returns the system prompt as a string, or as text blocks when it has cache
+// breakpoints
+func convertSystem(message model.Message) any {
+	if message.IsStringContent() {
+		return message.StringContent()
+	}
+	parts := message.ParseContent()
+	var blocks []Content
+	cached := false
+	for _, part := range parts {
+		if part.Type != model.ContentTypeText {
+			continue
+		}
+		blocks = append(blocks, Content{Type: "text", Text: part.Text, CacheControl: part.CacheControl})
+		cached = cached || part.CacheControl != nil
+	}
+	if !cached {
+		return message.StringContent()
+	}
+	return blocks
+}
+
+// AddUsage adds the usage of a response or a stream event, the tokens written to and read
+// from the prompt cache are prompt tokens too
+func AddUsage(usage *model.Usage, claudeUsage Usage) {
+	usage.PromptTokens += claudeUsage.InputTokens + claudeUsage.CacheCreationInputTokens + claudeUsage.CacheReadInputTokens
+	usage.CompletionTokens += claudeUsage.OutputTokens
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	usage.AddCachedTokens(claudeUsage.CacheReadInputTokens)
+}
+
+// AddStreamUsage adds the usage of a stream event: message_start has the prompt tokens, the
+// output tokens of message_delta are cumulative and replace those of message_start
+func AddStreamUsage(usage *model.Usage, claudeResponse *Response) {
+	if len(claudeResponse.Id) > 0 {
+		AddUsage(usage, claudeResponse.Usage)
+		return
+	}
+	usage.CompletionTokens = claudeResponse.Usage.OutputTokens
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+}
+
+func isToolResultMessage(message Message) bool {
+	if message.Role != "user" || len(message.Content) == 0 {
+		return false
+	}
+	return message.Content[len(message.Content)-1].Type == "tool_result"
+}
+
 // https://docs.anthropic.com/claude/reference/messages-streaming
 func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCompletionsStreamResponse, *Response) {
 	var response *Response
 	var responseText string
+	var reasoningText string
 	var stopReason string
 	tools := make([]model.Tool, 0)
 
@@ -160,8 +293,9 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 			responseText = claudeResponse.ContentBlock.Text
 			if claudeResponse.ContentBlock.Type == "tool_use" {
 				tools = append(tools, model.Tool{
-					Id:   claudeResponse.ContentBlock.Id,
-					Type: "function",
+					Id:    claudeResponse.ContentBlock.Id,
+					Type:  "function",
+					Index: &claudeResponse.Index,
 					Function: model.Function{
 						Name:      claudeResponse.ContentBlock.Name,
 						Arguments: "",
@@ -172,8 +306,10 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	case "content_block_delta":
 		if claudeResponse.Delta != nil {
 			responseText = claudeResponse.Delta.Text
+			reasoningText = claudeResponse.Delta.Thinking
 			if claudeResponse.Delta.Type == "input_json_delta" {
 				tools = append(tools, model.Tool{
+					Index: &claudeResponse.Index,
 					Function: model.Function{
 						Arguments: claudeResponse.Delta.PartialJson,
 					},
@@ -192,6 +328,9 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 	}
 	var choice openai.ChatCompletionsStreamResponseChoice
 	choice.Delta.Content = responseText
+	if reasoningText != "" {
+		choice.Delta.ReasoningContent = reasoningText
+	}
 	if len(tools) > 0 {
 		choice.Delta.Content = nil // compatible with other OpenAI derivative applications, like LobeOpenAICompatibleFactory ...
 		choice.Delta.ToolCalls = tools
@@ -209,11 +348,15 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 
 func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 	var responseText string
-	if len(claudeResponse.Content) > 0 {
-		responseText = claudeResponse.Content[0].Text
-	}
+	var reasoningText string
 	tools := make([]model.Tool, 0)
 	for _, v := range claudeResponse.Content {
+		if v.Type == "text" {
+			responseText += v.Text
+		}
+		if v.Type == "thinking" {
+			reasoningText += v.Thinking
+		}
 		if v.Type == "tool_use" {
 			args, _ := json.Marshal(v.Input)
 			tools = append(tools, model.Tool{
@@ -236,6 +379,9 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 		},
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
+	if reasoningText != "" {
+		choice.Message.ReasoningContent = reasoningText
+	}
 	fullTextResponse := openai.TextResponse{
 		Id:      fmt.Sprintf("chatcmpl-%s", claudeResponse.Id),
 		Model:   claudeResponse.Model,
@@ -243,11 +389,11 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 	}
+	openai.NormalizeTextResponse(&fullTextResponse)
 	return &fullTextResponse
 }
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
-	createdTime := helper.GetTimestamp()
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
@@ -265,9 +411,10 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	common.SetEventStreamHeaders(c)
 
 	var usage model.Usage
-	var modelName string
-	var id string
-	var lastToolCallChoice openai.ChatCompletionsStreamResponseChoice
+	var reasoningText string
+	var streamedText string // output sent to the client, billed when the stream breaks
+	finished := false
+	transcoder := NewStreamTranscoder("")
 
 	for scanner.Scan() {
 		data := scanner.Text()
@@ -284,36 +431,21 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			continue
 		}
 
-		response, meta := StreamResponseClaude2OpenAI(&claudeResponse)
+		response, meta := transcoder.Transcode(&claudeResponse)
 		if meta != nil {
-			usage.PromptTokens += meta.Usage.InputTokens
-			usage.CompletionTokens += meta.Usage.OutputTokens
-			if len(meta.Id) > 0 { // only message_start has an id, otherwise it's a finish_reason event.
-				modelName = meta.Model
-				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
-				continue
-			} else { // finish_reason case
-				if len(lastToolCallChoice.Delta.ToolCalls) > 0 {
-					lastArgs := &lastToolCallChoice.Delta.ToolCalls[len(lastToolCallChoice.Delta.ToolCalls)-1].Function
-					if len(lastArgs.Arguments.(string)) == 0 { // compatible with OpenAI sending an empty object `{}` when no arguments.
-						lastArgs.Arguments = "{}"
-						response.Choices[len(response.Choices)-1].Delta.Content = nil
-						response.Choices[len(response.Choices)-1].Delta.ToolCalls = lastToolCallChoice.Delta.ToolCalls
-					}
-				}
-			}
+			AddStreamUsage(&usage, meta)
+			// only message_start has an id, otherwise it's the finish_reason event
+			finished = finished || len(meta.Id) == 0
 		}
 		if response == nil {
 			continue
 		}
 
-		response.Id = id
-		response.Model = modelName
-		response.Created = createdTime
-
 		for _, choice := range response.Choices {
-			if len(choice.Delta.ToolCalls) > 0 {
-				lastToolCallChoice = choice
+			reasoningText += conv.AsString(choice.Delta.ReasoningContent)
+			streamedText += conv.AsString(choice.Delta.Content)
+			for _, toolCall := range choice.Delta.ToolCalls {
+				streamedText += conv.AsString(toolCall.Function.Arguments)
 			}
 		}
 		err = render.ObjectData(c, response)
@@ -325,6 +457,13 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	if err := scanner.Err(); err != nil {
 		logger.SysError("error reading stream: " + err.Error())
 	}
+	if !finished {
+		// the output tokens come with the stop reason, count those streamed instead
+		logger.Warnf(c.Request.Context(), "stream ended before the completion, billing the output streamed")
+		c.Set(ctxkey.PartialCompletion, true)
+		usage.CompletionTokens = openai.CountTokenText(streamedText+reasoningText, transcoder.Model())
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
 
 	render.Done(c)
 
@@ -332,6 +471,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	if err != nil {
 		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
+	SetReasoningTokens(&usage, reasoningText, transcoder.Model())
 	return nil, &usage
 }
 
@@ -362,11 +502,9 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := ResponseClaude2OpenAI(&claudeResponse)
 	fullTextResponse.Model = modelName
-	usage := model.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
+	var usage model.Usage
+	AddUsage(&usage, claudeResponse.Usage)
+	SetReasoningTokens(&usage, conv.AsString(fullTextResponse.Choices[0].ReasoningContent), modelName)
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
@@ -0,0 +1,69 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestConvertRequestContentParts(t *testing.T) {
+	request := ConvertRequest(model.GeneralOpenAIRequest{
+		Model: "claude-3-5-sonnet-20241022",
+		Messages: []model.Message{
+			{Role: "user", Content: []any{
+				map[string]any{"type": "input_text", "text": "describe"},
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "data:image/png;base64,AAAA"}},
+			}},
+			{Role: "assistant", ToolCalls: []model.Tool{{Id: "call_1", Type: "function", Function: model.Function{Name: "screenshot", Arguments: "{}"}}}},
+			{Role: "tool", ToolCallId: "call_1", Content: []model.MessageContent{
+				{Type: model.ContentTypeText, Text: "taken"},
+				{Type: model.ContentTypeImageURL, ImageURL: &model.ImageURL{Url: "data:image/jpeg;base64,BBBB"}},
+			}},
+		},
+	})
+	assert.Len(t, request.Messages, 3)
+	assert.Equal(t, []Content{
+		{Type: "text", Text: "describe"},
+		{Type: "image", Source: &ImageSource{Type: "base64", MediaType: "image/png", Data: "AAAA"}},
+	}, request.Messages[0].Content)
+	assert.Equal(t, []Content{
+		{Type: "text", Text: "taken"},
+		{Type: "image", Source: &ImageSource{Type: "base64", MediaType: "image/jpeg", Data: "BBBB"}},
+	}, request.Messages[2].Content[0].Content)
+}
+
+func TestConvertInboundToolResultImages(t *testing.T) {
+	var request InboundRequest
+	assert.Nil(t, json.Unmarshal([]byte(`{
+		"model": "claude-3-5-sonnet-20241022",
+		"max_tokens": 100,
+		"messages": [
+			{"role": "user", "content": "take a screenshot"},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "toolu_1", "name": "screenshot", "input": {}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "toolu_1", "content": [
+				{"type": "text", "text": "taken"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]}]}
+		]
+	}`), &request))
+	openaiRequest, err := ConvertInboundRequest(&request)
+	assert.Nil(t, err)
+	toolMessage := openaiRequest.Messages[2]
+	assert.Equal(t, "tool", toolMessage.Role)
+	assert.Equal(t, "taken", toolMessage.StringContent())
+	assert.True(t, toolMessage.HasImage())
+
+	// the images round trip to Claude, and move to a user message for OpenAI
+	claudeRequest := ConvertRequest(*openaiRequest)
+	assert.Equal(t, []Content{
+		{Type: "text", Text: "taken"},
+		{Type: "image", Source: &ImageSource{Type: "base64", MediaType: "image/png", Data: "AAAA"}},
+	}, claudeRequest.Messages[2].Content[0].Content)
+	messages := model.MoveToolImages(openaiRequest.Messages)
+	assert.Len(t, messages, 4)
+	assert.Equal(t, "taken", messages[2].Content)
+	assert.Equal(t, "user", messages[3].Role)
+}
@@ -7,9 +7,10 @@ type Metadata struct {
 }
 
 type ImageSource struct {
-	Type      string `json:"type"`
-	MediaType string `json:"media_type"`
-	Data      string `json:"data"`
+	Type      string `json:"type"` // base64 or url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
 }
 
 type Content struct {
@@ -20,8 +21,13 @@ type Content struct {
 	Id        string `json:"id,omitempty"`
 	Name      string `json:"name,omitempty"`
 	Input     any    `json:"input,omitempty"`
-	Content   string `json:"content,omitempty"`
+	Content   any    `json:"content,omitempty"` // string or content blocks of a tool_result
 	ToolUseId string `json:"tool_use_id,omitempty"`
+	// thinking
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// prompt caching breakpoint, e.g. {"type": "ephemeral"}
+	CacheControl any `json:"cache_control,omitempty"`
 }
 
 type Message struct {
@@ -44,7 +50,7 @@ type InputSchema struct {
 type Request struct {
 	Model         string    `json:"model"`
 	Messages      []Message `json:"messages"`
-	System        string    `json:"system,omitempty"`
+	System        any       `json:"system,omitempty"` // string or text blocks
 	MaxTokens     int       `json:"max_tokens,omitempty"`
 	StopSequences []string  `json:"stop_sequences,omitempty"`
 	Stream        bool      `json:"stream,omitempty"`
@@ -53,12 +59,23 @@ type Request struct {
 	TopK          int       `json:"top_k,omitempty"`
 	Tools         []Tool    `json:"tools,omitempty"`
 	ToolChoice    any       `json:"tool_choice,omitempty"`
+	Thinking      *Thinking `json:"thinking,omitempty"`
 	//Metadata    `json:"metadata,omitempty"`
 }
 
+// Thinking enables extended thinking, whose tokens are billed as output tokens
+type Thinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
+// Usage of a response, the input tokens do not include the tokens written to or read from
+// the prompt cache
 type Usage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 type Error struct {
@@ -82,6 +99,7 @@ type Delta struct {
 	Type         string  `json:"type"`
 	Text         string  `json:"text"`
 	PartialJson  string  `json:"partial_json,omitempty"`
+	Thinking     string  `json:"thinking,omitempty"`
 	StopReason   *string `json:"stop_reason"`
 	StopSequence *string `json:"stop_sequence"`
 }
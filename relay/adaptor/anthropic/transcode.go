@@ -0,0 +1,303 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// The stream transcoders map the events of a Claude stream to chat completion chunks and
+// back, event by event. StreamTranscoder serves the OpenAI clients from the Claude channels,
+// InboundStreamTranscoder the Anthropic clients from any channel, whose streams the relay
+// turns into chat completion chunks.
+
+// StreamTranscoder converts the events of a Claude stream to chat completion chunks. The tool
+// calls are indexed from 0 in their order instead of by content block, and a call streamed
+// without arguments gets {} as OpenAI does.
+type StreamTranscoder struct {
+	id            string
+	model         string
+	created       int64
+	toolCalls     map[int]int // content block index to tool call index
+	toolArguments []bool      // whether arguments were streamed, by tool call index
+	normalizer    *openai.ToolCallStreamNormalizer
+}
+
+// NewStreamTranscoder returns a transcoder naming the chunks after the model, or after the
+// model of the stream when empty
+func NewStreamTranscoder(modelName string) *StreamTranscoder {
+	return &StreamTranscoder{
+		model:      modelName,
+		created:    helper.GetTimestamp(),
+		toolCalls:  make(map[int]int),
+		normalizer: openai.NewToolCallStreamNormalizer(),
+	}
+}
+
+// Model returns the model of the chunks
+func (t *StreamTranscoder) Model() string {
+	return t.model
+}
+
+// Transcode converts an event, it returns the chunk to send if any and the message of
+// message_start or the usage of message_delta, see StreamResponseClaude2OpenAI
+func (t *StreamTranscoder) Transcode(event *StreamResponse) (*openai.ChatCompletionsStreamResponse, *Response) {
+	response, meta := StreamResponseClaude2OpenAI(event)
+	if event.Type == "message_start" && event.Message != nil {
+		t.id = fmt.Sprintf("chatcmpl-%s", event.Message.Id)
+		if t.model == "" {
+			t.model = event.Message.Model
+		}
+	}
+	if response == nil {
+		return nil, meta
+	}
+	choice := &response.Choices[0]
+	for i := range choice.Delta.ToolCalls {
+		toolCall := &choice.Delta.ToolCalls[i]
+		index, ok := t.toolCalls[*toolCall.Index]
+		if !ok {
+			index = len(t.toolArguments)
+			t.toolCalls[*toolCall.Index] = index
+			t.toolArguments = append(t.toolArguments, false)
+		}
+		toolCall.Index = &index
+		if arguments, _ := toolCall.Function.Arguments.(string); arguments != "" {
+			t.toolArguments[index] = true
+		}
+	}
+	if event.Type == "message_delta" {
+		for i, streamed := range t.toolArguments {
+			if streamed {
+				continue
+			}
+			index := i
+			choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, model.Tool{
+				Index:    &index,
+				Function: model.Function{Arguments: "{}"},
+			})
+			t.toolArguments[i] = true
+		}
+		if len(choice.Delta.ToolCalls) > 0 {
+			choice.Delta.Content = nil
+		}
+	}
+	response.Id = t.id
+	response.Model = t.model
+	response.Created = t.created
+	t.normalizer.NormalizeResponse(response)
+	return response, meta
+}
+
+// StreamEvent is an event of a Claude stream
+type StreamEvent struct {
+	Type string
+	Data gin.H
+}
+
+// Render writes the event as a server sent event
+func (e StreamEvent) Render(w io.Writer) error {
+	payload, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, payload)
+	return err
+}
+
+// inboundToolCall is a tool call of a chat completion stream sent to an Anthropic client
+type inboundToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// InboundStreamTranscoder converts the chunks of a chat completion stream to the events of a
+// Claude stream. Claude streams one content block at a time while the parallel tool calls of
+// OpenAI may interleave: the first tool call is streamed as it comes, the next one too once
+// the arguments of the previous one are complete, the others are buffered and sent whole
+// at the end of the stream. Only the first choice is converted.
+type InboundStreamTranscoder struct {
+	model      string
+	started    bool
+	finished   bool
+	blockIndex int
+	blockType  string // type of the open content block, empty if none
+	liveTool   int    // index of the tool call of the open tool_use block, -1 if none
+	toolCalls  map[int]*inboundToolCall
+	buffered   []int // indexes of the tool calls to send at the end, in their order
+	stopReason string
+	usage      Usage
+	events     []StreamEvent
+}
+
+func NewInboundStreamTranscoder(modelName string) *InboundStreamTranscoder {
+	return &InboundStreamTranscoder{
+		model:      modelName,
+		blockIndex: -1,
+		liveTool:   -1,
+		toolCalls:  make(map[int]*inboundToolCall),
+	}
+}
+
+// Transcode converts a chunk to the events to send
+func (t *InboundStreamTranscoder) Transcode(chunk *openai.ChatCompletionsStreamResponse) []StreamEvent {
+	t.events = nil
+	if t.finished {
+		return nil
+	}
+	if !t.started {
+		t.started = true
+		if chunk.Model != "" {
+			t.model = chunk.Model
+		}
+		t.event("message_start", gin.H{
+			"type": "message_start",
+			"message": gin.H{
+				"id":            chunk.Id,
+				"type":          "message",
+				"role":          "assistant",
+				"model":         t.model,
+				"content":       []any{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         Usage{},
+			},
+		})
+	}
+	if chunk.Usage != nil {
+		t.usage = inboundUsage(*chunk.Usage)
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		t.handleDelta(choice.Delta)
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.stopReason = stopReasonOpenAI2Claude(*choice.FinishReason)
+		}
+	}
+	return t.events
+}
+
+// Finish returns the events ending the stream, none if it was ended already or never started
+func (t *InboundStreamTranscoder) Finish() []StreamEvent {
+	t.events = nil
+	if t.finished || !t.started {
+		return nil
+	}
+	t.finished = true
+	t.stopBlock()
+	for _, index := range t.buffered {
+		toolCall := t.toolCalls[index]
+		t.startToolBlock(toolCall)
+		if toolCall.arguments.Len() > 0 {
+			t.delta(gin.H{"type": "input_json_delta", "partial_json": toolCall.arguments.String()})
+		}
+		t.stopBlock()
+	}
+	if t.stopReason == "" {
+		t.stopReason = "end_turn"
+	}
+	t.event("message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": t.stopReason, "stop_sequence": nil},
+		"usage": t.usage,
+	})
+	t.event("message_stop", gin.H{"type": "message_stop"})
+	return t.events
+}
+
+func (t *InboundStreamTranscoder) event(eventType string, data gin.H) {
+	t.events = append(t.events, StreamEvent{Type: eventType, Data: data})
+}
+
+func (t *InboundStreamTranscoder) startBlock(blockType string, block gin.H) {
+	t.stopBlock()
+	t.blockIndex++
+	t.blockType = blockType
+	t.event("content_block_start", gin.H{"type": "content_block_start", "index": t.blockIndex, "content_block": block})
+}
+
+func (t *InboundStreamTranscoder) startToolBlock(toolCall *inboundToolCall) {
+	if toolCall.id == "" {
+		toolCall.id = openai.NewToolCallId()
+	}
+	t.startBlock("tool_use", gin.H{"type": "tool_use", "id": toolCall.id, "name": toolCall.name, "input": gin.H{}})
+}
+
+func (t *InboundStreamTranscoder) stopBlock() {
+	if t.blockType == "" {
+		return
+	}
+	t.liveTool = -1
+	t.blockType = ""
+	t.event("content_block_stop", gin.H{"type": "content_block_stop", "index": t.blockIndex})
+}
+
+func (t *InboundStreamTranscoder) delta(delta gin.H) {
+	t.event("content_block_delta", gin.H{"type": "content_block_delta", "index": t.blockIndex, "delta": delta})
+}
+
+func (t *InboundStreamTranscoder) handleDelta(delta model.Message) {
+	if reasoning, ok := delta.ReasoningContent.(string); ok && reasoning != "" {
+		if t.blockType != "thinking" {
+			t.startBlock("thinking", gin.H{"type": "thinking", "thinking": ""})
+		}
+		t.delta(gin.H{"type": "thinking_delta", "thinking": reasoning})
+	}
+	if text := delta.StringContent(); text != "" {
+		if t.blockType != "text" {
+			t.startBlock("text", gin.H{"type": "text", "text": ""})
+		}
+		t.delta(gin.H{"type": "text_delta", "text": text})
+	}
+	for i, toolCall := range delta.ToolCalls {
+		// OpenAI identifies the tool calls of a stream by index, the id only comes first
+		index := i
+		if toolCall.Index != nil {
+			index = *toolCall.Index
+		}
+		arguments, _ := toolCall.Function.Arguments.(string)
+		call, ok := t.toolCalls[index]
+		if !ok {
+			call = &inboundToolCall{id: toolCall.Id, name: toolCall.Function.Name}
+			t.toolCalls[index] = call
+			if t.canStream() {
+				t.startToolBlock(call)
+				t.liveTool = index
+			} else {
+				t.buffered = append(t.buffered, index)
+			}
+		} else if call.name == "" {
+			call.name = toolCall.Function.Name
+		}
+		if arguments == "" {
+			continue
+		}
+		call.arguments.WriteString(arguments)
+		if index == t.liveTool {
+			t.delta(gin.H{"type": "input_json_delta", "partial_json": arguments})
+		}
+		// the arguments of a call whose block is closed are lost, Claude cannot reopen it
+	}
+}
+
+// canStream tells whether a new tool call may be streamed as it comes: no call is buffered
+// and the arguments of the streamed one, if any, are complete
+func (t *InboundStreamTranscoder) canStream() bool {
+	if len(t.buffered) > 0 {
+		return false
+	}
+	if t.liveTool < 0 {
+		return true
+	}
+	return json.Valid([]byte(t.toolCalls[t.liveTool].arguments.String()))
+}
@@ -0,0 +1,150 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+)
+
+func claudeEvents(t *testing.T, events ...string) []*StreamResponse {
+	var parsed []*StreamResponse
+	for _, event := range events {
+		var response StreamResponse
+		assert.Nil(t, json.Unmarshal([]byte(event), &response))
+		parsed = append(parsed, &response)
+	}
+	return parsed
+}
+
+func openaiChunks(t *testing.T, chunks ...string) []*openai.ChatCompletionsStreamResponse {
+	var parsed []*openai.ChatCompletionsStreamResponse
+	for _, chunk := range chunks {
+		var response openai.ChatCompletionsStreamResponse
+		assert.Nil(t, json.Unmarshal([]byte(chunk), &response))
+		parsed = append(parsed, &response)
+	}
+	return parsed
+}
+
+// toolInputs returns the tool_use blocks of the events by id, with their input as streamed
+func toolInputs(events []StreamEvent) (ids []string, inputs map[string]string) {
+	inputs = make(map[string]string)
+	blocks := make(map[int]string)
+	for _, event := range events {
+		switch event.Type {
+		case "content_block_start":
+			block := event.Data["content_block"].(gin.H)
+			if block["type"] == "tool_use" {
+				id := block["id"].(string)
+				ids = append(ids, id)
+				blocks[event.Data["index"].(int)] = id
+			}
+		case "content_block_delta":
+			delta := event.Data["delta"].(gin.H)
+			if delta["type"] == "input_json_delta" {
+				inputs[blocks[event.Data["index"].(int)]] += delta["partial_json"].(string)
+			}
+		}
+	}
+	return ids, inputs
+}
+
+func TestStreamTranscoderToolCalls(t *testing.T) {
+	transcoder := NewStreamTranscoder("")
+	var chunks []*openai.ChatCompletionsStreamResponse
+	for _, event := range claudeEvents(t,
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-3-5-sonnet-20241022","usage":{"input_tokens":10}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Let me check."}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"time","input":{}}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+	) {
+		chunk, _ := transcoder.Transcode(event)
+		if chunk != nil {
+			chunks = append(chunks, chunk)
+		}
+	}
+	assert.Equal(t, "claude-3-5-sonnet-20241022", transcoder.Model())
+	arguments := make(map[int]string)
+	ids := make(map[int]string)
+	for _, chunk := range chunks {
+		assert.Equal(t, "chatcmpl-msg_1", chunk.Id)
+		for _, toolCall := range chunk.Choices[0].Delta.ToolCalls {
+			arguments[*toolCall.Index] += toolCall.Function.Arguments.(string)
+			if toolCall.Id != "" {
+				ids[*toolCall.Index] = toolCall.Id
+			}
+		}
+	}
+	assert.Equal(t, map[int]string{0: "toolu_1", 1: "toolu_2"}, ids)
+	assert.Equal(t, map[int]string{0: `{"city":"Paris"}`, 1: "{}"}, arguments)
+	last := chunks[len(chunks)-1].Choices[0]
+	assert.Equal(t, "tool_calls", *last.FinishReason)
+}
+
+func TestInboundStreamTranscoderInterleavedToolCalls(t *testing.T) {
+	transcoder := NewInboundStreamTranscoder("gpt-4o")
+	var events []StreamEvent
+	for _, chunk := range openaiChunks(t,
+		`{"id":"chatcmpl-1","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"weather","arguments":""}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_b","type":"function","function":{"name":"time","arguments":""}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{\"zone\":\"UTC\"}"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+		`{"id":"chatcmpl-1","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":10,"completion_tokens":20,"total_tokens":30}}`,
+	) {
+		events = append(events, transcoder.Transcode(chunk)...)
+	}
+	events = append(events, transcoder.Finish()...)
+	assert.Nil(t, transcoder.Finish())
+
+	ids, inputs := toolInputs(events)
+	assert.Equal(t, []string{"call_a", "call_b"}, ids)
+	assert.Equal(t, map[string]string{"call_a": `{"city":"Paris"}`, "call_b": `{"zone":"UTC"}`}, inputs)
+	assert.Equal(t, "message_start", events[0].Type)
+	assert.Equal(t, "message_stop", events[len(events)-1].Type)
+	messageDelta := events[len(events)-2]
+	assert.Equal(t, "tool_use", messageDelta.Data["delta"].(gin.H)["stop_reason"])
+	assert.Equal(t, 20, messageDelta.Data["usage"].(Usage).OutputTokens)
+}
+
+func TestStreamTranscodersRoundTrip(t *testing.T) {
+	claude := NewStreamTranscoder("")
+	inbound := NewInboundStreamTranscoder("")
+	var events []StreamEvent
+	for _, event := range claudeEvents(t,
+		`{"type":"message_start","message":{"id":"msg_1","model":"claude-3-5-sonnet-20241022"}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"Two lookups."}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"weather","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":\"Paris\"}"}}`,
+		`{"type":"content_block_start","index":2,"content_block":{"type":"tool_use","id":"toolu_2","name":"weather","input":{}}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+		`{"type":"content_block_delta","index":2,"delta":{"type":"input_json_delta","partial_json":"\"Rome\"}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":20}}`,
+	) {
+		if chunk, _ := claude.Transcode(event); chunk != nil {
+			events = append(events, inbound.Transcode(chunk)...)
+		}
+	}
+	events = append(events, inbound.Finish()...)
+
+	var types []string
+	for _, event := range events {
+		if event.Type == "content_block_start" {
+			types = append(types, event.Data["content_block"].(gin.H)["type"].(string))
+		}
+	}
+	// the complete calls are streamed one after the other
+	assert.Equal(t, []string{"thinking", "tool_use", "tool_use"}, types)
+	ids, inputs := toolInputs(events)
+	assert.Equal(t, []string{"toolu_1", "toolu_2"}, ids)
+	assert.Equal(t, map[string]string{"toolu_1": `{"city":"Paris"}`, "toolu_2": `{"city":"Rome"}`}, inputs)
+}
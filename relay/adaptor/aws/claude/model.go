@@ -9,7 +9,7 @@ type Request struct {
 	// AnthropicVersion should be "bedrock-2023-05-31"
 	AnthropicVersion string              `json:"anthropic_version"`
 	Messages         []anthropic.Message `json:"messages"`
-	System           string              `json:"system,omitempty"`
+	System           any                 `json:"system,omitempty"`
 	MaxTokens        int                 `json:"max_tokens,omitempty"`
 	Temperature      *float64            `json:"temperature,omitempty"`
 	TopP             *float64            `json:"top_p,omitempty"`
@@ -17,4 +17,5 @@ type Request struct {
 	StopSequences    []string            `json:"stop_sequences,omitempty"`
 	Tools            []anthropic.Tool    `json:"tools,omitempty"`
 	ToolChoice       any                 `json:"tool_choice,omitempty"`
+	Thinking         *anthropic.Thinking `json:"thinking,omitempty"`
 }
@@ -5,9 +5,15 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"io"
 	"net/http"
+	"time"
 )
 
 func SetupCommonRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) {
@@ -23,7 +29,8 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.
 	if err != nil {
 		return nil, fmt.Errorf("get request url failed: %w", err)
 	}
-	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+	// canceled with the request, e.g. on its timeout
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
 		return nil, fmt.Errorf("new request failed: %w", err)
 	}
@@ -38,8 +45,51 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.
 	return resp, nil
 }
 
+// GetHTTPClient returns the client used to reach the upstream of the selected channel.
+// Channels with their own proxy, TLS settings or source address and providers with
+// pre-warmed connections get a pooled client, extra headers from the channel config
+// are injected into every request
+func GetHTTPClient(c *gin.Context) *http.Client {
+	httpClient := client.HTTPClient
+	channelType := c.GetInt(ctxkey.Channel)
+	requestIdHeaders := client.WithHeaders(upstreamRequestIdHeaders(channelType, c.GetString(helper.RequestIdKey)))
+	cfg, ok := c.Get(ctxkey.Config)
+	if !ok {
+		return client.WithMiddlewares(httpClient, requestIdHeaders, client.WithRetry(config.RelayConnectionRetryTimes, 100*time.Millisecond))
+	}
+	channelConfig, _ := cfg.(model.ChannelConfig)
+	if channelConfig.Proxy != "" || channelConfig.TLS != nil || channelConfig.LocalAddress != "" || client.IsWarmedChannelType(channelType) {
+		httpClient = client.GetClientForChannel(channelType, c.GetInt(ctxkey.ChannelId), client.ChannelOptions{
+			Proxy:        channelConfig.Proxy,
+			TLS:          channelConfig.TLS,
+			LocalAddress: channelConfig.LocalAddress,
+		})
+	}
+	return client.WithMiddlewares(httpClient,
+		requestIdHeaders,
+		client.WithHeaders(channelConfig.Headers),
+		client.WithRetry(config.RelayConnectionRetryTimes, 100*time.Millisecond),
+	)
+}
+
+// upstreamRequestIdHeaders forwards the request id, also under the header a provider
+// records in its own logs, so support tickets can be correlated end to end
+func upstreamRequestIdHeaders(channelType int, requestId string) map[string]string {
+	if requestId == "" {
+		return nil
+	}
+	headers := map[string]string{helper.InboundRequestIdHeader: requestId}
+	switch channelType {
+	case channeltype.OpenAI:
+		headers["X-Client-Request-Id"] = requestId
+	case channeltype.Azure:
+		headers["x-ms-client-request-id"] = requestId
+	}
+	return headers
+}
+
 func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
-	resp, err := client.HTTPClient.Do(req)
+	resp, err := GetHTTPClient(c).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,252 @@
+package adaptor_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// The conformance tests relay the chat completions of testdata/conformance through the
+// adaptor of their channel, against a server replaying the recorded response of the
+// provider. A new fixture is a JSON file, the expected values only list the fields checked:
+//
+//	channel   the channel type, a key of fixtureChannels
+//	model     the model, when the request has none
+//	request   the OpenAI request of the client
+//	upstream  path, the path and query the request is sent to
+//	          request, the fields of the request sent to the provider
+//	          status, content_type and body, the recorded response, a JSON string for streams
+//	response  the fields of the response to the client
+//	chunks    the fields of stream chunks to the client, in order, others may be between them
+//	error     the fields of the error returned, with its status_code
+//	usage     the fields of the usage billed
+
+var fixtureChannels = map[string]int{
+	"openai":    channeltype.OpenAI,
+	"anthropic": channeltype.Anthropic,
+	"gemini":    channeltype.Gemini,
+	"ollama":    channeltype.Ollama,
+}
+
+type fixture struct {
+	Channel  string          `json:"channel"`
+	Model    string          `json:"model"`
+	Request  json.RawMessage `json:"request"`
+	Upstream struct {
+		Path        string          `json:"path"`
+		Request     json.RawMessage `json:"request"`
+		Status      int             `json:"status"`
+		ContentType string          `json:"content_type"`
+		Body        json.RawMessage `json:"body"`
+	} `json:"upstream"`
+	Response json.RawMessage   `json:"response"`
+	Chunks   []json.RawMessage `json:"chunks"`
+	Error    json.RawMessage   `json:"error"`
+	Usage    json.RawMessage   `json:"usage"`
+}
+
+func TestAdaptorConformance(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "conformance", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	// counts the tokens without the tokenizer files
+	config.ApproximateTokenEnabled = true
+	client.Init()
+	gin.SetMode(gin.TestMode)
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".json"), func(t *testing.T) {
+			runFixture(t, file)
+		})
+	}
+}
+
+func runFixture(t *testing.T, file string) {
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	var f fixture
+	require.NoError(t, json.Unmarshal(data, &f))
+	channelType, ok := fixtureChannels[f.Channel]
+	require.True(t, ok, "unknown channel %q", f.Channel)
+
+	var upstreamPath string
+	var upstreamRequest []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamPath = r.URL.RequestURI()
+		upstreamRequest, _ = io.ReadAll(r.Body)
+		contentType := f.Upstream.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		w.Header().Set("Content-Type", contentType)
+		status := f.Upstream.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		var text string
+		if json.Unmarshal(f.Upstream.Body, &text) == nil {
+			_, _ = w.Write([]byte(text))
+		} else {
+			_, _ = w.Write(f.Upstream.Body)
+		}
+	}))
+	defer server.Close()
+
+	var textRequest model.GeneralOpenAIRequest
+	require.NoError(t, json.Unmarshal(f.Request, &textRequest))
+	if textRequest.Model == "" {
+		textRequest.Model = f.Model
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(f.Request))
+	c.Request.Header.Set("Content-Type", "application/json")
+	meta := &meta.Meta{
+		Mode:            relaymode.ChatCompletions,
+		ChannelType:     channelType,
+		APIType:         channeltype.ToAPIType(channelType),
+		BaseURL:         server.URL,
+		APIKey:          "sk-conformance",
+		IsStream:        textRequest.Stream,
+		OriginModelName: textRequest.Model,
+		ActualModelName: textRequest.Model,
+		RequestURLPath:  c.Request.URL.String(),
+		PromptTokens:    openai.CountTokenMessages(textRequest.Messages, textRequest.Model),
+	}
+	adaptor := relay.GetAdaptor(meta.APIType)
+	require.NotNil(t, adaptor)
+	adaptor.Init(meta)
+
+	convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, &textRequest)
+	require.NoError(t, err)
+	requestBody, err := json.Marshal(convertedRequest)
+	require.NoError(t, err)
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewReader(requestBody))
+	require.NoError(t, err)
+	if f.Upstream.Path != "" {
+		assert.Equal(t, f.Upstream.Path, upstreamPath)
+	}
+	assertContains(t, "upstream request", f.Upstream.Request, upstreamRequest)
+
+	if resp.StatusCode != http.StatusOK {
+		require.NotNil(t, f.Error, "unexpected upstream status %d", resp.StatusCode)
+		assertContains(t, "error", f.Error, marshal(t, controller.RelayErrorHandler(resp)))
+		return
+	}
+	usage, bizErr := adaptor.DoResponse(c, resp, meta)
+	if f.Error != nil {
+		require.NotNil(t, bizErr, "expected an error")
+		assertContains(t, "error", f.Error, marshal(t, bizErr))
+		return
+	}
+	require.Nil(t, bizErr)
+
+	if meta.IsStream {
+		chunks, done := streamChunks(t, w.Body.Bytes())
+		assert.True(t, done, "the stream doesn't end with [DONE]")
+		next := 0
+		for _, expected := range f.Chunks {
+			found := false
+			for next < len(chunks) && !found {
+				found = contains(unmarshal(t, expected), unmarshal(t, chunks[next]))
+				next++
+			}
+			assert.True(t, found, "no chunk with %s in order in\n%s", expected, w.Body.String())
+		}
+	} else {
+		assertContains(t, "response", f.Response, w.Body.Bytes())
+	}
+	assertContains(t, "usage", f.Usage, marshal(t, usage))
+}
+
+// streamChunks returns the data of the SSE events of a stream, and whether it ends with [DONE]
+func streamChunks(t *testing.T, stream []byte) (chunks [][]byte, done bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			continue
+		}
+		require.False(t, done, "chunk after [DONE]")
+		chunks = append(chunks, []byte(data))
+	}
+	return chunks, done
+}
+
+func assertContains(t *testing.T, what string, expected json.RawMessage, actual []byte) {
+	if expected == nil {
+		return
+	}
+	assert.True(t, contains(unmarshal(t, expected), unmarshal(t, actual)), "the %s\n%s\nhas not\n%s", what, actual, expected)
+}
+
+// contains tells whether actual has every field of expected, arrays element by element,
+// a null expected field is missing or null
+func contains(expected, actual any) bool {
+	switch expected := expected.(type) {
+	case map[string]any:
+		actual, ok := actual.(map[string]any)
+		if !ok {
+			return false
+		}
+		for key, value := range expected {
+			if !contains(value, actual[key]) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		actual, ok := actual.([]any)
+		if !ok || len(actual) != len(expected) {
+			return false
+		}
+		for i := range expected {
+			if !contains(expected[i], actual[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(expected, actual)
+	}
+}
+
+func unmarshal(t *testing.T, data []byte) any {
+	var value any
+	require.NoError(t, json.Unmarshal(data, &value), "invalid JSON %s", data)
+	return value
+}
+
+func marshal(t *testing.T, value any) []byte {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return data
+}
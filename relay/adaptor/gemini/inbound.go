@@ -0,0 +1,272 @@
+package gemini
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Inbound requests use the Gemini format, they are converted to the OpenAI format
+// before the relay and the responses converted back
+
+type InboundFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileUri  string `json:"fileUri"`
+}
+
+type InboundPart struct {
+	Text             string            `json:"text,omitempty"`
+	Thought          bool              `json:"thought,omitempty"`
+	InlineData       *InlineData       `json:"inlineData,omitempty"`
+	FileData         *InboundFileData  `json:"fileData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type InboundContent struct {
+	Role  string        `json:"role,omitempty"`
+	Parts []InboundPart `json:"parts"`
+}
+
+type InboundFunctionDeclaration struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type InboundTool struct {
+	FunctionDeclarations []InboundFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type InboundToolConfig struct {
+	FunctionCallingConfig *struct {
+		Mode                 string   `json:"mode,omitempty"` // AUTO, ANY or NONE
+		AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+	} `json:"functionCallingConfig,omitempty"`
+}
+
+type InboundGenerationConfig struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	TopK             float64  `json:"topK,omitempty"`
+	MaxOutputTokens  int      `json:"maxOutputTokens,omitempty"`
+	CandidateCount   int      `json:"candidateCount,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+	Seed             float64  `json:"seed,omitempty"`
+	ResponseMimeType string   `json:"responseMimeType,omitempty"`
+	ResponseSchema   any      `json:"responseSchema,omitempty"`
+}
+
+type InboundRequest struct {
+	Contents          []InboundContent         `json:"contents"`
+	SystemInstruction *InboundContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *InboundGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []InboundTool            `json:"tools,omitempty"`
+	ToolConfig        *InboundToolConfig       `json:"toolConfig,omitempty"`
+}
+
+// lowerSchemaTypes converts the upper case types of Gemini schemas (OBJECT, STRING...)
+// to the JSON schema ones
+func lowerSchemaTypes(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if typ, ok := value.(string); ok && key == "type" {
+				v[key] = strings.ToLower(typ)
+			} else {
+				v[key] = lowerSchemaTypes(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = lowerSchemaTypes(value)
+		}
+	}
+	return schema
+}
+
+func inboundPartsText(parts []InboundPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" && !part.Thought {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func convertInboundToolConfig(config *InboundToolConfig) any {
+	if config.FunctionCallingConfig == nil {
+		return nil
+	}
+	switch strings.ToUpper(config.FunctionCallingConfig.Mode) {
+	case "NONE":
+		return "none"
+	case "ANY":
+		if names := config.FunctionCallingConfig.AllowedFunctionNames; len(names) == 1 {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": names[0]},
+			}
+		}
+		return "required"
+	}
+	return "auto"
+}
+
+// inboundCallIds gives ids to the function calls, Gemini matches the responses by name
+type inboundCallIds struct {
+	count   int
+	pending map[string][]string
+}
+
+func (ids *inboundCallIds) call(name string) string {
+	ids.count++
+	id := fmt.Sprintf("call_%d", ids.count)
+	ids.pending[name] = append(ids.pending[name], id)
+	return id
+}
+
+func (ids *inboundCallIds) response(name string) string {
+	if pending := ids.pending[name]; len(pending) > 0 {
+		ids.pending[name] = pending[1:]
+		return pending[0]
+	}
+	ids.count++
+	return fmt.Sprintf("call_%d", ids.count)
+}
+
+func convertInboundContent(content InboundContent, ids *inboundCallIds) ([]model.Message, error) {
+	role := "user"
+	if content.Role == "model" {
+		role = "assistant"
+	}
+	var messages []model.Message
+	var parts []model.MessageContent
+	var toolCalls []model.Tool
+	hasImage := false
+	for _, part := range content.Parts {
+		switch {
+		case part.Thought:
+			// thoughts are not sent upstream
+		case part.Text != "":
+			parts = append(parts, model.MessageContent{Type: model.ContentTypeText, Text: part.Text})
+		case part.InlineData != nil:
+			hasImage = true
+			parts = append(parts, model.MessageContent{
+				Type:     model.ContentTypeImageURL,
+				ImageURL: &model.ImageURL{Url: model.ImageDataURL(part.InlineData.MimeType, part.InlineData.Data)},
+			})
+		case part.FileData != nil:
+			hasImage = true
+			parts = append(parts, model.MessageContent{
+				Type:     model.ContentTypeImageURL,
+				ImageURL: &model.ImageURL{Url: part.FileData.FileUri},
+			})
+		case part.FunctionCall != nil:
+			arguments, err := json.Marshal(part.FunctionCall.Arguments)
+			if err != nil {
+				return nil, fmt.Errorf("invalid function call args: %w", err)
+			}
+			toolCalls = append(toolCalls, model.Tool{
+				Id:   ids.call(part.FunctionCall.FunctionName),
+				Type: "function",
+				Function: model.Function{
+					Name:      part.FunctionCall.FunctionName,
+					Arguments: string(arguments),
+				},
+			})
+		case part.FunctionResponse != nil:
+			result, err := json.Marshal(part.FunctionResponse.Response)
+			if err != nil {
+				return nil, fmt.Errorf("invalid function response: %w", err)
+			}
+			messages = append(messages, model.Message{
+				Role:       "tool",
+				Content:    string(result),
+				ToolCallId: ids.response(part.FunctionResponse.Name),
+			})
+		}
+	}
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages, nil
+	}
+	converted := model.Message{Role: role, ToolCalls: toolCalls}
+	if role == "assistant" || !hasImage {
+		converted.Content = inboundPartsText(content.Parts)
+	} else {
+		converted.Content = parts
+	}
+	return append(messages, converted), nil
+}
+
+// ConvertInboundRequest converts a Gemini generateContent request to the OpenAI chat format
+func ConvertInboundRequest(request *InboundRequest, modelName string, stream bool) (*model.GeneralOpenAIRequest, error) {
+	if modelName == "" {
+		return nil, fmt.Errorf("model is required")
+	}
+	openaiRequest := model.GeneralOpenAIRequest{
+		Model:  modelName,
+		Stream: stream,
+	}
+	if stream {
+		openaiRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if config := request.GenerationConfig; config != nil {
+		openaiRequest.Temperature = config.Temperature
+		openaiRequest.TopP = config.TopP
+		openaiRequest.TopK = int(config.TopK)
+		openaiRequest.MaxTokens = config.MaxOutputTokens
+		openaiRequest.PresencePenalty = config.PresencePenalty
+		openaiRequest.FrequencyPenalty = config.FrequencyPenalty
+		openaiRequest.Seed = config.Seed
+		if config.CandidateCount > 1 {
+			openaiRequest.N = config.CandidateCount
+		}
+		if len(config.StopSequences) > 0 {
+			openaiRequest.Stop = config.StopSequences
+		}
+		if config.ResponseMimeType == "application/json" {
+			openaiRequest.ResponseFormat = &model.ResponseFormat{Type: "json_object"}
+			if schema, ok := lowerSchemaTypes(config.ResponseSchema).(map[string]any); ok {
+				openaiRequest.ResponseFormat = &model.ResponseFormat{
+					Type:       "json_schema",
+					JsonSchema: &model.JSONSchema{Name: "response", Schema: schema},
+				}
+			}
+		}
+	}
+	if request.SystemInstruction != nil {
+		if system := inboundPartsText(request.SystemInstruction.Parts); system != "" {
+			openaiRequest.Messages = append(openaiRequest.Messages, model.Message{Role: "system", Content: system})
+		}
+	}
+	ids := &inboundCallIds{pending: make(map[string][]string)}
+	for _, content := range request.Contents {
+		messages, err := convertInboundContent(content, ids)
+		if err != nil {
+			return nil, err
+		}
+		openaiRequest.Messages = append(openaiRequest.Messages, messages...)
+	}
+	for _, tool := range request.Tools {
+		for _, declaration := range tool.FunctionDeclarations {
+			openaiRequest.Tools = append(openaiRequest.Tools, model.Tool{
+				Type: "function",
+				Function: model.Function{
+					Name:        declaration.Name,
+					Description: declaration.Description,
+					Parameters:  lowerSchemaTypes(declaration.Parameters),
+				},
+			})
+		}
+	}
+	if request.ToolConfig != nil && len(openaiRequest.Tools) > 0 {
+		openaiRequest.ToolChoice = convertInboundToolConfig(request.ToolConfig)
+	}
+	return &openaiRequest, nil
+}
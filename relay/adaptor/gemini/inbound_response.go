@@ -0,0 +1,349 @@
+package gemini
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+type InboundCandidate struct {
+	Content      ChatContent `json:"content"`
+	FinishReason string      `json:"finishReason,omitempty"`
+	Index        int         `json:"index"`
+}
+
+type InboundUsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+}
+
+type InboundResponse struct {
+	Candidates    []InboundCandidate    `json:"candidates"`
+	UsageMetadata *InboundUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string                `json:"modelVersion,omitempty"`
+	ResponseId    string                `json:"responseId,omitempty"`
+}
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+func errorStatusByCode(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	return "INTERNAL"
+}
+
+// InboundError is the Gemini error body
+func InboundError(statusCode int, message string) gin.H {
+	return gin.H{
+		"error": Error{
+			Code:    statusCode,
+			Message: message,
+			Status:  errorStatusByCode(statusCode),
+		},
+	}
+}
+
+func functionCallPart(toolCall model.Tool) Part {
+	var args any = map[string]any{}
+	if arguments, ok := toolCall.Function.Arguments.(string); ok && arguments != "" {
+		_ = json.Unmarshal([]byte(arguments), &args)
+	}
+	return Part{FunctionCall: &FunctionCall{FunctionName: toolCall.Function.Name, Arguments: args}}
+}
+
+func inboundUsage(usage model.Usage) *InboundUsageMetadata {
+	reasoningTokens := usage.GetReasoningTokens()
+	return &InboundUsageMetadata{
+		PromptTokenCount:        usage.PromptTokens,
+		CandidatesTokenCount:    usage.CompletionTokens - reasoningTokens,
+		ThoughtsTokenCount:      reasoningTokens,
+		CachedContentTokenCount: usage.GetCachedTokens(),
+		TotalTokenCount:         usage.PromptTokens + usage.CompletionTokens,
+	}
+}
+
+// ConvertInboundResponse converts an OpenAI chat completion to a Gemini response
+func ConvertInboundResponse(response *openai.TextResponse) *InboundResponse {
+	inboundResponse := InboundResponse{
+		Candidates:    make([]InboundCandidate, 0, len(response.Choices)),
+		UsageMetadata: inboundUsage(response.Usage),
+		ModelVersion:  response.Model,
+		ResponseId:    response.Id,
+	}
+	for _, choice := range response.Choices {
+		candidate := InboundCandidate{
+			Content:      ChatContent{Role: "model", Parts: []Part{}},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        choice.Index,
+		}
+		if text := choice.StringContent(); text != "" {
+			candidate.Content.Parts = append(candidate.Content.Parts, Part{Text: text})
+		}
+		for _, toolCall := range choice.ToolCalls {
+			candidate.Content.Parts = append(candidate.Content.Parts, functionCallPart(toolCall))
+		}
+		inboundResponse.Candidates = append(inboundResponse.Candidates, candidate)
+	}
+	return &inboundResponse
+}
+
+// InboundResponseWriter converts the OpenAI responses written by the relay to the
+// Gemini format. A JSON body is buffered until Finish, a stream is sent as server
+// sent events with alt=sse and as a JSON array otherwise.
+type InboundResponseWriter struct {
+	gin.ResponseWriter
+	model     string
+	sse       bool
+	body      bytes.Buffer
+	streaming bool
+	pending   []byte // partial SSE line
+
+	chunks       int
+	finished     bool
+	id           string
+	toolCalls    map[int]map[int]*model.Tool // candidate index, tool call index
+	finishReason map[int]string
+	usage        *model.Usage
+}
+
+func NewInboundResponseWriter(w gin.ResponseWriter, modelName string, sse bool) *InboundResponseWriter {
+	return &InboundResponseWriter{
+		ResponseWriter: w,
+		model:          modelName,
+		sse:            sse,
+		toolCalls:      make(map[int]map[int]*model.Tool),
+		finishReason:   make(map[int]string),
+	}
+}
+
+func (w *InboundResponseWriter) Write(data []byte) (int, error) {
+	if !w.streaming && w.body.Len() == 0 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+		if !w.sse {
+			w.Header().Set("Content-Type", "application/json")
+		}
+	}
+	if !w.streaming {
+		return w.body.Write(data)
+	}
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:end]))
+		w.pending = w.pending[end+1:]
+		if err := w.handleStreamLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *InboundResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *InboundResponseWriter) chunk(response *InboundResponse) error {
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	switch {
+	case w.sse:
+		_, err = fmt.Fprintf(w.ResponseWriter, "data: %s\r\n\r\n", payload)
+	case w.chunks == 0:
+		_, err = fmt.Fprintf(w.ResponseWriter, "[%s", payload)
+	default:
+		_, err = fmt.Fprintf(w.ResponseWriter, ",\r\n%s", payload)
+	}
+	w.chunks++
+	w.Flush()
+	return err
+}
+
+func (w *InboundResponseWriter) handleStreamLine(line string) error {
+	if !strings.HasPrefix(line, "data:") || w.finished {
+		return nil
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return w.finishStream()
+	}
+	var chunk openai.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil // not a chat completion chunk
+	}
+	w.id = chunk.Id
+	if chunk.Model != "" {
+		w.model = chunk.Model
+	}
+	if chunk.Usage != nil {
+		w.usage = chunk.Usage
+	}
+	response := InboundResponse{ModelVersion: w.model, ResponseId: w.id}
+	for _, choice := range chunk.Choices {
+		// the arguments of a tool call come in pieces, Gemini sends whole calls at the end
+		for i, toolCall := range choice.Delta.ToolCalls {
+			index := i
+			if toolCall.Index != nil {
+				index = *toolCall.Index
+			}
+			calls := w.toolCalls[choice.Index]
+			if calls == nil {
+				calls = make(map[int]*model.Tool)
+				w.toolCalls[choice.Index] = calls
+			}
+			call, ok := calls[index]
+			if !ok {
+				call = &model.Tool{Id: toolCall.Id, Function: model.Function{Arguments: ""}}
+				calls[index] = call
+			}
+			if toolCall.Function.Name != "" {
+				call.Function.Name = toolCall.Function.Name
+			}
+			if arguments, ok := toolCall.Function.Arguments.(string); ok {
+				call.Function.Arguments = call.Function.Arguments.(string) + arguments
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReason[choice.Index] = finishReasonOpenAI2Gemini(*choice.FinishReason)
+		}
+		if text := choice.Delta.StringContent(); text != "" {
+			response.Candidates = append(response.Candidates, InboundCandidate{
+				Content: ChatContent{Role: "model", Parts: []Part{{Text: text}}},
+				Index:   choice.Index,
+			})
+		}
+	}
+	if len(response.Candidates) == 0 {
+		return nil
+	}
+	return w.chunk(&response)
+}
+
+func (w *InboundResponseWriter) finishStream() error {
+	if w.finished {
+		return nil
+	}
+	w.finished = true
+	response := InboundResponse{ModelVersion: w.model, ResponseId: w.id}
+	indexes := make(map[int]bool)
+	for index := range w.finishReason {
+		indexes[index] = true
+	}
+	for index := range w.toolCalls {
+		indexes[index] = true
+	}
+	if len(indexes) == 0 {
+		indexes[0] = true
+	}
+	for index := range indexes {
+		candidate := InboundCandidate{
+			Content:      ChatContent{Role: "model", Parts: []Part{}},
+			FinishReason: w.finishReason[index],
+			Index:        index,
+		}
+		if candidate.FinishReason == "" {
+			candidate.FinishReason = "STOP"
+		}
+		calls := w.toolCalls[index]
+		callIndexes := make([]int, 0, len(calls))
+		for callIndex := range calls {
+			callIndexes = append(callIndexes, callIndex)
+		}
+		sort.Ints(callIndexes)
+		for _, callIndex := range callIndexes {
+			candidate.Content.Parts = append(candidate.Content.Parts, functionCallPart(*calls[callIndex]))
+		}
+		response.Candidates = append(response.Candidates, candidate)
+	}
+	sort.Slice(response.Candidates, func(i, j int) bool {
+		return response.Candidates[i].Index < response.Candidates[j].Index
+	})
+	if w.usage != nil {
+		response.UsageMetadata = inboundUsage(*w.usage)
+	}
+	if err := w.chunk(&response); err != nil {
+		return err
+	}
+	if !w.sse {
+		_, err := w.ResponseWriter.Write([]byte("]"))
+		return err
+	}
+	return nil
+}
+
+// Finish writes the converted JSON body, or ends a stream whose [DONE] was missing
+func (w *InboundResponseWriter) Finish() {
+	if w.streaming {
+		_ = w.finishStream()
+		return
+	}
+	if w.body.Len() == 0 {
+		return
+	}
+	statusCode := w.Status()
+	body := w.body.Bytes()
+	var converted any
+	if statusCode >= http.StatusBadRequest {
+		var errorResponse struct {
+			Error model.Error `json:"error"`
+		}
+		_ = json.Unmarshal(body, &errorResponse)
+		message := errorResponse.Error.Message
+		if message == "" {
+			message = strings.TrimSpace(string(body))
+		}
+		converted = InboundError(statusCode, message)
+	} else {
+		var response openai.TextResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			converted = InboundError(http.StatusBadGateway, "invalid upstream response: "+err.Error())
+		} else {
+			if response.Model == "" {
+				response.Model = w.model
+			}
+			converted = ConvertInboundResponse(&response)
+		}
+	}
+	payload, _ := json.Marshal(converted)
+	w.Header().Del("Content-Length") // copied from the upstream response
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.ResponseWriter.Write(payload)
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
@@ -66,6 +67,21 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 			MaxOutputTokens: textRequest.MaxTokens,
 		},
 	}
+	if textRequest.N > 1 {
+		geminiRequest.GenerationConfig.CandidateCount = textRequest.N
+	}
+	if textRequest.Logprobs != nil && *textRequest.Logprobs {
+		geminiRequest.GenerationConfig.ResponseLogprobs = true
+		geminiRequest.GenerationConfig.Logprobs = textRequest.TopLogprobs
+	}
+	if textRequest.ReasoningEffort != nil {
+		if thinkingBudget, ok := thinkingBudgets[*textRequest.ReasoningEffort]; ok {
+			geminiRequest.GenerationConfig.ThinkingConfig = &ThinkingConfig{
+				IncludeThoughts: true,
+				ThinkingBudget:  &thinkingBudget,
+			}
+		}
+	}
 	if textRequest.ResponseFormat != nil {
 		if mimeType, ok := mimeTypeMap[textRequest.ResponseFormat.Type]; ok {
 			geminiRequest.GenerationConfig.ResponseMimeType = mimeType
@@ -78,13 +94,16 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 	if textRequest.Tools != nil {
 		functions := make([]model.Function, 0, len(textRequest.Tools))
 		for _, tool := range textRequest.Tools {
-			functions = append(functions, tool.Function)
+			function := tool.Function
+			function.Parameters = cleanFunctionParameters(function.Parameters)
+			functions = append(functions, function)
 		}
 		geminiRequest.Tools = []ChatTools{
 			{
 				FunctionDeclarations: functions,
 			},
 		}
+		geminiRequest.ToolConfig = convertToolChoice(textRequest.ToolChoice)
 	} else if textRequest.Functions != nil {
 		geminiRequest.Tools = []ChatTools{
 			{
@@ -93,7 +112,41 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 		}
 	}
 	shouldAddDummyModelMessage := false
+	// Gemini matches the function responses to the calls by name
+	toolCallNames := make(map[string]string)
 	for _, message := range textRequest.Messages {
+		if message.Role == "tool" {
+			name := toolCallNames[message.ToolCallId]
+			if name == "" && message.Name != nil {
+				name = *message.Name
+			}
+			part := Part{
+				FunctionResponse: &FunctionResponse{
+					Name:     name,
+					Response: functionResponse(message.StringContent()),
+				},
+			}
+			parts := []Part{part}
+			// Gemini takes no image in a function response, they follow it in the turn
+			for _, content := range message.ParseContent() {
+				if content.Type != model.ContentTypeImageURL {
+					continue
+				}
+				if imagePart, ok := convertImage(content.ImageURL); ok {
+					parts = append(parts, imagePart)
+				}
+			}
+			// the responses to parallel calls go together in one turn
+			if last := len(geminiRequest.Contents) - 1; last >= 0 && isFunctionResponseContent(geminiRequest.Contents[last]) {
+				geminiRequest.Contents[last].Parts = append(geminiRequest.Contents[last].Parts, parts...)
+			} else {
+				geminiRequest.Contents = append(geminiRequest.Contents, ChatContent{
+					Role:  "user",
+					Parts: parts,
+				})
+			}
+			continue
+		}
 		content := ChatContent{
 			Role: message.Role,
 			Parts: []Part{
@@ -107,6 +160,9 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 		imageNum := 0
 		for _, part := range openaiContent {
 			if part.Type == model.ContentTypeText {
+				if part.Text == "" && len(message.ToolCalls) > 0 {
+					continue
+				}
 				parts = append(parts, Part{
 					Text: part.Text,
 				})
@@ -115,15 +171,15 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 				if imageNum > VisionMaxImageNum {
 					continue
 				}
-				mimeType, data, _ := image.GetImageFromUrl(part.ImageURL.Url)
-				parts = append(parts, Part{
-					InlineData: &InlineData{
-						MimeType: mimeType,
-						Data:     data,
-					},
-				})
+				if imagePart, ok := convertImage(part.ImageURL); ok {
+					parts = append(parts, imagePart)
+				}
 			}
 		}
+		for _, toolCall := range message.ToolCalls {
+			toolCallNames[toolCall.Id] = toolCall.Function.Name
+			parts = append(parts, functionCallPart(toolCall))
+		}
 		content.Parts = parts
 
 		// there's no assistant role in gemini and API shall vomit if Role is not user or model
@@ -132,12 +188,12 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 		}
 		// Converting system prompt to prompt from user for the same reason
 		if content.Role == "system" {
-			shouldAddDummyModelMessage = true
 			if IsModelSupportSystemInstruction(textRequest.Model) {
 				geminiRequest.SystemInstruction = &content
 				geminiRequest.SystemInstruction.Role = ""
 				continue
 			} else {
+				shouldAddDummyModelMessage = true
 				content.Role = "user"
 			}
 		}
@@ -161,6 +217,117 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 	return &geminiRequest
 }
 
+// schemaKeysUnsupported are the JSON schema keywords Gemini rejects in function declarations
+var schemaKeysUnsupported = map[string]bool{
+	"$schema":              true,
+	"additionalProperties": true,
+	"strict":               true,
+}
+
+// cleanFunctionParameters removes from the parameters of a function what Gemini rejects,
+// including an object schema without properties
+// thinkingBudgets maps the reasoning_effort of OpenAI to a thinking budget
+var thinkingBudgets = map[string]int{
+	"low":    1024,
+	"medium": 8192,
+	"high":   24576,
+}
+
+func cleanFunctionParameters(parameters any) any {
+	params, ok := parameters.(map[string]any)
+	if !ok {
+		return parameters
+	}
+	if properties, _ := params["properties"].(map[string]any); params["type"] == "object" && len(properties) == 0 {
+		return nil
+	}
+	return cleanSchema(params)
+}
+
+func cleanSchema(schema any) any {
+	switch v := schema.(type) {
+	case map[string]any:
+		cleaned := make(map[string]any, len(v))
+		for key, value := range v {
+			if schemaKeysUnsupported[key] {
+				continue
+			}
+			if properties, ok := value.(map[string]any); ok && key == "properties" {
+				// the names of the properties are kept whatever they are
+				cleanedProperties := make(map[string]any, len(properties))
+				for name, property := range properties {
+					cleanedProperties[name] = cleanSchema(property)
+				}
+				cleaned[key] = cleanedProperties
+				continue
+			}
+			cleaned[key] = cleanSchema(value)
+		}
+		return cleaned
+	case []any:
+		cleaned := make([]any, len(v))
+		for i, value := range v {
+			cleaned[i] = cleanSchema(value)
+		}
+		return cleaned
+	}
+	return schema
+}
+
+func convertToolChoice(toolChoice any) *ChatToolConfig {
+	switch v := toolChoice.(type) {
+	case string:
+		switch v {
+		case "none":
+			return &ChatToolConfig{FunctionCallingConfig: FunctionCallingConfig{Mode: "NONE"}}
+		case "required":
+			return &ChatToolConfig{FunctionCallingConfig: FunctionCallingConfig{Mode: "ANY"}}
+		}
+	case map[string]any:
+		if function, ok := v["function"].(map[string]any); ok {
+			if name, _ := function["name"].(string); name != "" {
+				return &ChatToolConfig{FunctionCallingConfig: FunctionCallingConfig{
+					Mode:                 "ANY",
+					AllowedFunctionNames: []string{name},
+				}}
+			}
+		}
+	}
+	return nil
+}
+
+// functionResponse returns the response of a function, which must be an object for Gemini
+func functionResponse(content string) any {
+	var response map[string]any
+	if err := json.Unmarshal([]byte(content), &response); err == nil && response != nil {
+		return response
+	}
+	return map[string]any{"content": content}
+}
+
+func isFunctionResponseContent(content ChatContent) bool {
+	if content.Role != "user" || len(content.Parts) == 0 {
+		return false
+	}
+	return content.Parts[0].FunctionResponse != nil
+}
+
+// convertImage converts an image part to inline data, or to file data for the files uploaded
+// to Gemini or Cloud Storage, false when the image cannot be fetched
+func convertImage(imageURL *model.ImageURL) (Part, bool) {
+	if mimeType, data, ok := imageURL.ParseDataURL(); ok {
+		return Part{InlineData: &InlineData{MimeType: mimeType, Data: data}}, true
+	}
+	if strings.HasPrefix(imageURL.Url, "gs://") || strings.HasPrefix(imageURL.Url, "https://generativelanguage.googleapis.com/") {
+		return Part{FileData: &FileData{FileUri: imageURL.Url}}, true
+	}
+	mimeType, data, err := image.GetImageFromUrl(imageURL.Url)
+	if err != nil || data == "" {
+		return Part{}, false
+	}
+	return Part{InlineData: &InlineData{MimeType: mimeType, Data: data}}, true
+}
+
 func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *BatchEmbeddingRequest {
 	inputs := request.ParseInput()
 	requests := make([]EmbeddingRequest, len(inputs))
@@ -187,23 +354,68 @@ func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *BatchEmbedding
 type ChatResponse struct {
 	Candidates     []ChatCandidate    `json:"candidates"`
 	PromptFeedback ChatPromptFeedback `json:"promptFeedback"`
+	UsageMetadata  *UsageMetadata     `json:"usageMetadata,omitempty"`
 }
 
 func (g *ChatResponse) GetResponseText() string {
-	if g == nil {
+	if g == nil || len(g.Candidates) == 0 {
 		return ""
 	}
-	if len(g.Candidates) > 0 && len(g.Candidates[0].Content.Parts) > 0 {
-		return g.Candidates[0].Content.Parts[0].Text
+	return getPartsText(g.Candidates[0].Content.Parts, false)
+}
+
+// GetReasoningText returns the thoughts of the first candidate
+func (g *ChatResponse) GetReasoningText() string {
+	if g == nil || len(g.Candidates) == 0 {
+		return ""
 	}
-	return ""
+	return getPartsText(g.Candidates[0].Content.Parts, true)
+}
+
+func getPartsText(parts []Part, thought bool) string {
+	var builder strings.Builder
+	for _, part := range parts {
+		if part.Thought == thought {
+			builder.WriteString(part.Text)
+		}
+	}
+	return builder.String()
+}
+
+// usage converts the usage metadata, the thoughts are counted as reasoning completion tokens
+// and the cached content as cached prompt tokens
+func (u *UsageMetadata) usage() *model.Usage {
+	usage := &model.Usage{
+		PromptTokens:     u.PromptTokenCount,
+		CompletionTokens: u.CandidatesTokenCount,
+		TotalTokens:      u.PromptTokenCount + u.CandidatesTokenCount,
+	}
+	usage.AddReasoningTokens(u.ThoughtsTokenCount)
+	usage.AddCachedTokens(u.CachedContentTokenCount)
+	return usage
 }
 
 type ChatCandidate struct {
-	Content       ChatContent        `json:"content"`
-	FinishReason  string             `json:"finishReason"`
-	Index         int64              `json:"index"`
-	SafetyRatings []ChatSafetyRating `json:"safetyRatings"`
+	Content        ChatContent        `json:"content"`
+	FinishReason   string             `json:"finishReason"`
+	Index          int64              `json:"index"`
+	SafetyRatings  []ChatSafetyRating `json:"safetyRatings"`
+	LogprobsResult *LogprobsResult    `json:"logprobsResult,omitempty"`
+}
+
+// LogprobsResult has the chosen token of each step and its top candidates
+type LogprobsResult struct {
+	TopCandidates    []TopCandidates     `json:"topCandidates"`
+	ChosenCandidates []LogprobsCandidate `json:"chosenCandidates"`
+}
+
+type TopCandidates struct {
+	Candidates []LogprobsCandidate `json:"candidates"`
+}
+
+type LogprobsCandidate struct {
+	Token          string  `json:"token"`
+	LogProbability float64 `json:"logProbability"`
 }
 
 type ChatSafetyRating struct {
@@ -215,30 +427,42 @@ type ChatPromptFeedback struct {
 	SafetyRatings []ChatSafetyRating `json:"safetyRatings"`
 }
 
+// getToolCalls returns the function calls of a candidate, Gemini makes parallel calls as
+// several parts
 func getToolCalls(candidate *ChatCandidate) []model.Tool {
 	var toolCalls []model.Tool
-
-	item := candidate.Content.Parts[0]
-	if item.FunctionCall == nil {
-		return toolCalls
-	}
-	argsBytes, err := json.Marshal(item.FunctionCall.Arguments)
-	if err != nil {
-		logger.FatalLog("getToolCalls failed: " + err.Error())
-		return toolCalls
-	}
-	toolCall := model.Tool{
-		Id:   fmt.Sprintf("call_%s", random.GetUUID()),
-		Type: "function",
-		Function: model.Function{
-			Arguments: string(argsBytes),
-			Name:      item.FunctionCall.FunctionName,
-		},
+	for _, part := range candidate.Content.Parts {
+		if part.FunctionCall == nil {
+			continue
+		}
+		argsBytes, err := json.Marshal(part.FunctionCall.Arguments)
+		if err != nil {
+			logger.SysError("error marshalling function call arguments: " + err.Error())
+			continue
+		}
+		toolCalls = append(toolCalls, model.Tool{
+			Id:   openai.NewToolCallId(),
+			Type: "function",
+			Function: model.Function{
+				Arguments: string(argsBytes),
+				Name:      part.FunctionCall.FunctionName,
+			},
+		})
 	}
-	toolCalls = append(toolCalls, toolCall)
 	return toolCalls
 }
 
+func finishReasonGemini2OpenAI(reason string) string {
+	switch reason {
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "content_filter"
+	default:
+		return constant.StopFinishReason
+	}
+}
+
 func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 	fullTextResponse := openai.TextResponse{
 		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
@@ -255,37 +479,87 @@ func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 			FinishReason: constant.StopFinishReason,
 		}
 		if len(candidate.Content.Parts) > 0 {
-			if candidate.Content.Parts[0].FunctionCall != nil {
-				choice.Message.ToolCalls = getToolCalls(&candidate)
-			} else {
-				var builder strings.Builder
-				for _, part := range candidate.Content.Parts {
-					if i > 0 {
-						builder.WriteString("\n")
-					}
-					builder.WriteString(part.Text)
-				}
-				choice.Message.Content = builder.String()
+			choice.Message.ToolCalls = getToolCalls(&candidate)
+			text := getPartsText(candidate.Content.Parts, false)
+			if text != "" || len(choice.Message.ToolCalls) == 0 {
+				choice.Message.Content = text
+			}
+			if reasoning := getPartsText(candidate.Content.Parts, true); reasoning != "" {
+				choice.Message.ReasoningContent = reasoning
+			}
+			if candidate.FinishReason != "" {
+				choice.FinishReason = finishReasonGemini2OpenAI(candidate.FinishReason)
 			}
 		} else {
 			choice.Message.Content = ""
 			choice.FinishReason = candidate.FinishReason
 		}
+		choice.Logprobs = logprobsGemini2OpenAI(candidate.LogprobsResult)
 		fullTextResponse.Choices = append(fullTextResponse.Choices, choice)
 	}
+	openai.NormalizeTextResponse(&fullTextResponse)
 	return &fullTextResponse
 }
 
+// logprobsGemini2OpenAI converts the logprobs of a candidate, nil when it has none
+func logprobsGemini2OpenAI(result *LogprobsResult) *openai.Logprobs {
+	if result == nil || len(result.ChosenCandidates) == 0 {
+		return nil
+	}
+	logprobs := openai.Logprobs{Content: make([]openai.TokenLogprob, 0, len(result.ChosenCandidates))}
+	for i, chosen := range result.ChosenCandidates {
+		tokenLogprob := openai.TokenLogprob{
+			Token:       chosen.Token,
+			Logprob:     chosen.LogProbability,
+			Bytes:       openai.TokenBytes(chosen.Token),
+			TopLogprobs: []openai.TopLogprob{},
+		}
+		if i < len(result.TopCandidates) {
+			for _, top := range result.TopCandidates[i].Candidates {
+				tokenLogprob.TopLogprobs = append(tokenLogprob.TopLogprobs, openai.TopLogprob{
+					Token:   top.Token,
+					Logprob: top.LogProbability,
+					Bytes:   openai.TokenBytes(top.Token),
+				})
+			}
+		}
+		logprobs.Content = append(logprobs.Content, tokenLogprob)
+	}
+	return &logprobs
+}
+
+// streamResponseGeminiChat2OpenAI converts a chunk, with a choice per candidate
 func streamResponseGeminiChat2OpenAI(geminiResponse *ChatResponse) *openai.ChatCompletionsStreamResponse {
-	var choice openai.ChatCompletionsStreamResponseChoice
-	choice.Delta.Content = geminiResponse.GetResponseText()
-	//choice.FinishReason = &constant.StopFinishReason
 	var response openai.ChatCompletionsStreamResponse
 	response.Id = fmt.Sprintf("chatcmpl-%s", random.GetUUID())
 	response.Created = helper.GetTimestamp()
 	response.Object = "chat.completion.chunk"
 	response.Model = "gemini"
-	response.Choices = []openai.ChatCompletionsStreamResponseChoice{choice}
+	if len(geminiResponse.Candidates) == 0 {
+		response.Choices = []openai.ChatCompletionsStreamResponseChoice{{Delta: model.Message{Content: ""}}}
+		return &response
+	}
+	response.Choices = make([]openai.ChatCompletionsStreamResponseChoice, 0, len(geminiResponse.Candidates))
+	for i := range geminiResponse.Candidates {
+		candidate := &geminiResponse.Candidates[i]
+		choice := openai.ChatCompletionsStreamResponseChoice{Index: int(candidate.Index)}
+		choice.Delta.Content = getPartsText(candidate.Content.Parts, false)
+		if reasoning := getPartsText(candidate.Content.Parts, true); reasoning != "" {
+			choice.Delta.ReasoningContent = reasoning
+		}
+		if toolCalls := getToolCalls(candidate); len(toolCalls) > 0 {
+			if choice.Delta.Content == "" {
+				choice.Delta.Content = nil
+			}
+			choice.Delta.ToolCalls = toolCalls
+		}
+		if candidate.FinishReason != "" {
+			finishReason := finishReasonGemini2OpenAI(candidate.FinishReason)
+			choice.FinishReason = &finishReason
+		}
+		choice.Logprobs = logprobsGemini2OpenAI(candidate.LogprobsResult)
+		response.Choices = append(response.Choices, choice)
+	}
 	return &response
 }
 
@@ -306,13 +580,16 @@ func embeddingResponseGemini2OpenAI(response *EmbeddingResponse) *openai.Embeddi
 	return &openAIEmbeddingResponse
 }
 
-func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string) {
+// StreamHandler relays a stream, the usage is nil when the channel did not send it
+func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string, *model.Usage) {
 	responseText := ""
+	var usageMetadata *UsageMetadata
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(bufio.ScanLines)
 
 	common.SetEventStreamHeaders(c)
 
+	toolCallNormalizer := openai.NewToolCallStreamNormalizer()
 	for scanner.Scan() {
 		data := scanner.Text()
 		data = strings.TrimSpace(data)
@@ -329,13 +606,20 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			continue
 		}
 
+		if geminiResponse.UsageMetadata != nil {
+			// each chunk has the usage so far
+			usageMetadata = geminiResponse.UsageMetadata
+		}
 		response := streamResponseGeminiChat2OpenAI(&geminiResponse)
 		if response == nil {
 			continue
 		}
 
-		responseText += response.Choices[0].Delta.StringContent()
+		for _, choice := range response.Choices {
+			responseText += choice.Delta.StringContent()
+		}
 
+		toolCallNormalizer.NormalizeResponse(response)
 		err = render.ObjectData(c, response)
 		if err != nil {
 			logger.SysError(err.Error())
@@ -350,10 +634,13 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 
 	err := resp.Body.Close()
 	if err != nil {
-		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), ""
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
 
-	return nil, responseText
+	if usageMetadata == nil {
+		return nil, responseText, nil
+	}
+	return nil, responseText, usageMetadata.usage()
 }
 
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
@@ -383,11 +670,22 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	fullTextResponse := responseGeminiChat2OpenAI(&geminiResponse)
 	fullTextResponse.Model = modelName
-	completionTokens := openai.CountTokenText(geminiResponse.GetResponseText(), modelName)
-	usage := model.Usage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
+	var usage model.Usage
+	if geminiResponse.UsageMetadata != nil {
+		usage = *geminiResponse.UsageMetadata.usage()
+	} else {
+		// every candidate is billed
+		var completionTokens, reasoningTokens int
+		for _, choice := range fullTextResponse.Choices {
+			completionTokens += openai.CountTokenText(choice.Message.StringContent(), modelName)
+			reasoningTokens += openai.CountTokenText(conv.AsString(choice.Message.ReasoningContent), modelName)
+		}
+		usage = model.Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
+		usage.AddReasoningTokens(reasoningTokens)
 	}
 	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
@@ -0,0 +1,133 @@
+package gemini
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func TestConvertRequestChoicesAndLogprobs(t *testing.T) {
+	logprobs, topLogprobs := true, 2
+	request := ConvertRequest(model.GeneralOpenAIRequest{
+		Messages:    []model.Message{{Role: "user", Content: "hi"}},
+		N:           3,
+		Logprobs:    &logprobs,
+		TopLogprobs: &topLogprobs,
+	})
+	assert.Equal(t, 3, request.GenerationConfig.CandidateCount)
+	assert.True(t, request.GenerationConfig.ResponseLogprobs)
+	assert.Equal(t, 2, *request.GenerationConfig.Logprobs)
+
+	request = ConvertRequest(model.GeneralOpenAIRequest{
+		Messages: []model.Message{{Role: "user", Content: "hi"}},
+		N:        1,
+	})
+	data, err := json.Marshal(request.GenerationConfig)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(data), "candidateCount")
+	assert.NotContains(t, string(data), "logprobs")
+	assert.NotContains(t, string(data), "responseLogprobs")
+}
+
+const logprobsCandidates = `{"candidates":[
+	{"index":0,"content":{"role":"model","parts":[{"text":"Hi"}]},"finishReason":"STOP",
+	 "logprobsResult":{"chosenCandidates":[{"token":"Hi","logProbability":-0.1}],
+	 "topCandidates":[{"candidates":[{"token":"Hi","logProbability":-0.1},{"token":"Hey","logProbability":-2.5}]}]}},
+	{"index":1,"content":{"role":"model","parts":[{"text":"Hello"}]},"finishReason":"STOP"}
+]}`
+
+func TestResponseChoicesAndLogprobs(t *testing.T) {
+	var response ChatResponse
+	assert.Nil(t, json.Unmarshal([]byte(logprobsCandidates), &response))
+	textResponse := responseGeminiChat2OpenAI(&response)
+	assert.Len(t, textResponse.Choices, 2)
+	assert.Equal(t, 0, textResponse.Choices[0].Index)
+	assert.Equal(t, 1, textResponse.Choices[1].Index)
+	assert.Equal(t, "Hello", textResponse.Choices[1].Message.StringContent())
+	assert.Nil(t, textResponse.Choices[1].Logprobs)
+
+	assert.Equal(t, &openai.Logprobs{Content: []openai.TokenLogprob{{
+		Token:   "Hi",
+		Logprob: -0.1,
+		Bytes:   []int{72, 105},
+		TopLogprobs: []openai.TopLogprob{
+			{Token: "Hi", Logprob: -0.1, Bytes: []int{72, 105}},
+			{Token: "Hey", Logprob: -2.5, Bytes: []int{72, 101, 121}},
+		},
+	}}}, textResponse.Choices[0].Logprobs)
+
+	data, err := json.Marshal(textResponse.Choices[0])
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"logprobs":{"content":[{"token":"Hi","logprob":-0.1,"bytes":[72,105],"top_logprobs":[`)
+}
+
+func TestStreamResponseChoices(t *testing.T) {
+	var response ChatResponse
+	assert.Nil(t, json.Unmarshal([]byte(logprobsCandidates), &response))
+	chunk := streamResponseGeminiChat2OpenAI(&response)
+	assert.Len(t, chunk.Choices, 2)
+	assert.Equal(t, 0, chunk.Choices[0].Index)
+	assert.Equal(t, "Hi", chunk.Choices[0].Delta.StringContent())
+	assert.NotNil(t, chunk.Choices[0].Logprobs)
+	assert.Equal(t, 1, chunk.Choices[1].Index)
+	assert.Equal(t, "Hello", chunk.Choices[1].Delta.StringContent())
+	assert.Equal(t, "stop", *chunk.Choices[1].FinishReason)
+
+	chunk = streamResponseGeminiChat2OpenAI(&ChatResponse{})
+	assert.Len(t, chunk.Choices, 1)
+	assert.Equal(t, "", chunk.Choices[0].Delta.Content)
+}
+
+func TestHandlerBillsEveryCandidate(t *testing.T) {
+	// counts the tokens without the tokenizer files
+	config.ApproximateTokenEnabled = true
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(logprobsCandidates))}
+	err, usage := Handler(c, resp, 5, "gemini-2.0-flash")
+	assert.Nil(t, err)
+	single := openai.CountTokenText("Hi", "gemini-2.0-flash")
+	assert.Equal(t, single+openai.CountTokenText("Hello", "gemini-2.0-flash"), usage.CompletionTokens)
+
+	var textResponse openai.TextResponse
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), &textResponse))
+	assert.Len(t, textResponse.Choices, 2)
+	assert.NotNil(t, textResponse.Choices[0].Logprobs)
+}
+
+func TestConvertRequestToolResultImages(t *testing.T) {
+	request := ConvertRequest(model.GeneralOpenAIRequest{
+		Messages: []model.Message{
+			{Role: "user", Content: "take screenshots"},
+			{Role: "assistant", ToolCalls: []model.Tool{
+				{Id: "call_1", Type: "function", Function: model.Function{Name: "screenshot", Arguments: "{}"}},
+				{Id: "call_2", Type: "function", Function: model.Function{Name: "screenshot", Arguments: "{}"}},
+			}},
+			{Role: "tool", ToolCallId: "call_1", Content: []any{
+				map[string]any{"type": "text", "text": "taken"},
+				map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": "image/png", "data": "AAAA"}},
+			}},
+			{Role: "tool", ToolCallId: "call_2", Content: []any{
+				map[string]any{"type": "image_url", "image_url": map[string]any{"url": "gs://bucket/b.png"}},
+			}},
+		},
+	})
+	assert.Len(t, request.Contents, 3)
+	parts := request.Contents[2].Parts
+	assert.Len(t, parts, 4)
+	assert.Equal(t, "screenshot", parts[0].FunctionResponse.Name)
+	assert.Equal(t, &InlineData{MimeType: "image/png", Data: "AAAA"}, parts[1].InlineData)
+	assert.NotNil(t, parts[2].FunctionResponse)
+	assert.Equal(t, "gs://bucket/b.png", parts[3].FileData.FileUri)
+}
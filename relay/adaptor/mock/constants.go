@@ -0,0 +1,8 @@
+package mock
+
+// the mock channels serve any model, these are listed by default
+
+var ModelList = []string{
+	"mock-chat",
+	"mock-embedding",
+}
@@ -0,0 +1,264 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+const (
+	defaultCompletionTokens = 16
+	defaultDimensions       = 1536
+)
+
+// words of the synthetic completions, a word is a token
+var words = strings.Fields("the gateway relays this synthetic completion of a mock channel to measure " +
+	"routing caching rate limiting and billing under load without calling any provider")
+
+func options(meta *meta.Meta) dbmodel.MockOptions {
+	if meta.Config.Mock == nil {
+		return dbmodel.MockOptions{}
+	}
+	return *meta.Config.Mock
+}
+
+// latency draws the time to the first byte of a response
+func latency(options dbmodel.MockOptions) time.Duration {
+	mean, jitter := float64(options.LatencyMs), float64(options.LatencyJitterMs)
+	var ms float64
+	switch options.LatencyDistribution {
+	case "normal":
+		ms = mean + rand.NormFloat64()*jitter
+	case "exponential":
+		ms = rand.ExpFloat64() * mean
+	default:
+		ms = mean + (rand.Float64()*2-1)*jitter
+	}
+	return time.Duration(math.Max(ms, 0) * float64(time.Millisecond))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// respond returns the response of the mock channel to a request, after its latency
+func respond(ctx context.Context, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
+	var request model.GeneralOpenAIRequest
+	if err := json.NewDecoder(requestBody).Decode(&request); err != nil {
+		return nil, err
+	}
+	options := options(meta)
+	if err := sleep(ctx, latency(options)); err != nil {
+		return nil, err
+	}
+	if options.ErrorRate > 0 && rand.Float64() < options.ErrorRate {
+		status := options.ErrorStatus
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		return jsonResponse(status, map[string]any{
+			"error": model.Error{
+				Message: fmt.Sprintf("mock error with status %d", status),
+				Type:    "mock_error",
+				Code:    "mock_error",
+			},
+		}), nil
+	}
+	if meta.Mode == relaymode.Embeddings {
+		return jsonResponse(http.StatusOK, embeddings(meta, &request)), nil
+	}
+	tokens, finishReason := completion(options, &request)
+	usage := model.Usage{
+		PromptTokens:     meta.PromptTokens,
+		CompletionTokens: len(tokens),
+		TotalTokens:      meta.PromptTokens + len(tokens),
+	}
+	id := "chatcmpl-mock-" + random.GetUUID()
+	if request.Stream {
+		return streamResponse(ctx, meta, options, id, tokens, finishReason, usage), nil
+	}
+	text := strings.Join(tokens, "")
+	choice := map[string]any{"index": 0, "finish_reason": finishReason}
+	object := "text_completion"
+	if meta.Mode == relaymode.ChatCompletions {
+		choice["message"] = model.Message{Role: "assistant", Content: text}
+		object = "chat.completion"
+	} else {
+		choice["text"] = text
+	}
+	return jsonResponse(http.StatusOK, map[string]any{
+		"id":      id,
+		"object":  object,
+		"created": helper.GetTimestamp(),
+		"model":   meta.ActualModelName,
+		"choices": []any{choice},
+		"usage":   usage,
+	}), nil
+}
+
+// completion returns the tokens of the completion of a request, the words with the space
+// following them, and its finish reason
+func completion(options dbmodel.MockOptions, request *model.GeneralOpenAIRequest) ([]string, string) {
+	var text []string
+	if options.Echo {
+		for i := len(request.Messages) - 1; i >= 0 && len(text) == 0; i-- {
+			if request.Messages[i].Role == "user" {
+				text = strings.Fields(request.Messages[i].StringContent())
+			}
+		}
+		if len(text) == 0 {
+			for _, prompt := range request.ParseInput() {
+				text = append(text, strings.Fields(prompt)...)
+			}
+		}
+	}
+	if len(text) == 0 {
+		n := options.CompletionTokens
+		if n <= 0 {
+			n = defaultCompletionTokens
+		}
+		text = make([]string, n)
+		for i := range text {
+			text[i] = words[i%len(words)]
+		}
+	}
+	maxTokens := request.MaxTokens
+	if request.MaxCompletionTokens != nil {
+		maxTokens = *request.MaxCompletionTokens
+	}
+	finishReason := "stop"
+	if maxTokens > 0 && len(text) > maxTokens {
+		text = text[:maxTokens]
+		finishReason = "length"
+	}
+	tokens := make([]string, len(text))
+	for i, word := range text {
+		tokens[i] = word
+		if i < len(text)-1 {
+			tokens[i] += " "
+		}
+	}
+	return tokens, finishReason
+}
+
+// streamResponse streams the tokens of a completion at the speed of the channel
+func streamResponse(ctx context.Context, meta *meta.Meta, options dbmodel.MockOptions, id string, tokens []string, finishReason string, usage model.Usage) *http.Response {
+	reader, writer := io.Pipe()
+	go func() {
+		created := helper.GetTimestamp()
+		chunk := func(choice map[string]any) map[string]any {
+			object := "text_completion"
+			if meta.Mode == relaymode.ChatCompletions {
+				object = "chat.completion.chunk"
+			}
+			choices := []any{}
+			if choice != nil {
+				choice["index"] = 0
+				choices = append(choices, choice)
+			}
+			return map[string]any{"id": id, "object": object, "created": created, "model": meta.ActualModelName, "choices": choices}
+		}
+		delta := func(text string, first bool) map[string]any {
+			if meta.Mode != relaymode.ChatCompletions {
+				return map[string]any{"text": text}
+			}
+			message := map[string]any{"content": text}
+			if first {
+				message["role"] = "assistant"
+			}
+			return map[string]any{"delta": message}
+		}
+		var interval time.Duration
+		if options.TokensPerSecond > 0 {
+			interval = time.Duration(float64(time.Second) / options.TokensPerSecond)
+		}
+		for i, token := range tokens {
+			if i > 0 {
+				if err := sleep(ctx, interval); err != nil {
+					_ = writer.CloseWithError(err)
+					return
+				}
+			}
+			writeEvent(writer, chunk(delta(token, i == 0)))
+		}
+		last := delta("", len(tokens) == 0)
+		last["finish_reason"] = finishReason
+		writeEvent(writer, chunk(last))
+		usageChunk := chunk(nil)
+		usageChunk["usage"] = usage
+		writeEvent(writer, usageChunk)
+		_, _ = writer.Write([]byte("data: [DONE]\n\n"))
+		_ = writer.Close()
+	}()
+	header := make(http.Header)
+	header.Set("Content-Type", "text/event-stream")
+	return &http.Response{StatusCode: http.StatusOK, Header: header, Body: reader}
+}
+
+func writeEvent(w io.Writer, event any) {
+	data, _ := json.Marshal(event)
+	_, _ = w.Write([]byte("data: " + string(data) + "\n\n"))
+}
+
+// embeddings returns an embedding of each input, the same for the same text
+func embeddings(meta *meta.Meta, request *model.GeneralOpenAIRequest) map[string]any {
+	dimensions := request.Dimensions
+	if dimensions <= 0 {
+		dimensions = defaultDimensions
+	}
+	data := make([]map[string]any, 0)
+	for i, input := range request.ParseInput() {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(input))
+		generator := rand.New(rand.NewSource(int64(hash.Sum64())))
+		vector := make([]float64, dimensions)
+		var norm float64
+		for j := range vector {
+			vector[j] = generator.NormFloat64()
+			norm += vector[j] * vector[j]
+		}
+		norm = math.Sqrt(norm)
+		for j := range vector {
+			vector[j] /= norm
+		}
+		data = append(data, map[string]any{"object": "embedding", "index": i, "embedding": vector})
+	}
+	return map[string]any{
+		"object": "list",
+		"data":   data,
+		"model":  meta.ActualModelName,
+		"usage":  model.Usage{PromptTokens: meta.PromptTokens, TotalTokens: meta.PromptTokens},
+	}
+}
+
+func jsonResponse(status int, body any) *http.Response {
+	data, _ := json.Marshal(body)
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(bytes.NewReader(data))}
+}
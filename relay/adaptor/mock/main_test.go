@@ -0,0 +1,99 @@
+package mock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func relayMock(t *testing.T, mode int, options *dbmodel.MockOptions, requestBody string) (*httptest.ResponseRecorder, *http.Response) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(requestBody))
+	m := &meta.Meta{
+		Mode:            mode,
+		ActualModelName: "mock-chat",
+		PromptTokens:    7,
+		IsStream:        strings.Contains(requestBody, `"stream":true`),
+		Config:          dbmodel.ChannelConfig{Mock: options},
+	}
+	a := &Adaptor{}
+	resp, err := a.DoRequest(c, m, strings.NewReader(requestBody))
+	require.NoError(t, err)
+	if resp.StatusCode != http.StatusOK {
+		return w, resp
+	}
+	usage, bizErr := a.DoResponse(c, resp, m)
+	require.Nil(t, bizErr)
+	assert.Equal(t, 7, usage.PromptTokens)
+	return w, resp
+}
+
+func TestChatCompletion(t *testing.T) {
+	w, _ := relayMock(t, relaymode.ChatCompletions, &dbmodel.MockOptions{CompletionTokens: 5},
+		`{"model":"mock-chat","messages":[{"role":"user","content":"hi"}],"max_tokens":3}`)
+	assert.Contains(t, w.Body.String(), `"content":"the gateway relays"`)
+	assert.Contains(t, w.Body.String(), `"finish_reason":"length"`)
+	assert.Contains(t, w.Body.String(), `"completion_tokens":3`)
+}
+
+func TestEchoStream(t *testing.T) {
+	start := time.Now()
+	w, _ := relayMock(t, relaymode.ChatCompletions, &dbmodel.MockOptions{Echo: true, TokensPerSecond: 100},
+		`{"model":"mock-chat","messages":[{"role":"user","content":"one two three"}],"stream":true}`)
+	assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+	body := w.Body.String()
+	assert.Contains(t, body, `"content":"one "`)
+	assert.Contains(t, body, `"content":"three"`)
+	assert.Contains(t, body, `"finish_reason":"stop"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestInjectedErrors(t *testing.T) {
+	_, resp := relayMock(t, relaymode.ChatCompletions, &dbmodel.MockOptions{ErrorRate: 1, ErrorStatus: http.StatusTooManyRequests},
+		`{"model":"mock-chat","messages":[{"role":"user","content":"hi"}]}`)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `"type":"mock_error"`)
+}
+
+func TestEmbeddingsAreStable(t *testing.T) {
+	m := &meta.Meta{Mode: relaymode.Embeddings, ActualModelName: "mock-embedding"}
+	request := `{"model":"mock-embedding","input":["a","b","a"],"dimensions":8}`
+	resp, err := respond(context.Background(), m, strings.NewReader(request))
+	require.NoError(t, err)
+	var response struct {
+		Data []struct {
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	body, _ := io.ReadAll(resp.Body)
+	require.NoError(t, json.Unmarshal(body, &response))
+	require.Len(t, response.Data, 3)
+	assert.Len(t, response.Data[0].Embedding, 8)
+	assert.Equal(t, response.Data[0].Embedding, response.Data[2].Embedding)
+	assert.NotEqual(t, response.Data[0].Embedding, response.Data[1].Embedding)
+}
+
+func TestLatencyIsCanceled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	m := &meta.Meta{Mode: relaymode.ChatCompletions, Config: dbmodel.ChannelConfig{Mock: &dbmodel.MockOptions{LatencyMs: 5000}}}
+	_, err := respond(ctx, m, bytes.NewReader([]byte(`{"messages":[]}`)))
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
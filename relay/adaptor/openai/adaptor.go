@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/alibailian"
 	"github.com/songquanpeng/one-api/relay/adaptor/baiduv2"
@@ -24,6 +25,8 @@ import (
 
 type Adaptor struct {
 	ChannelType int
+	// api-version of the Azure request, chosen from its features
+	azureAPIVersion string
 }
 
 func (a *Adaptor) Init(meta *meta.Meta) {
@@ -33,22 +36,28 @@ func (a *Adaptor) Init(meta *meta.Meta) {
 func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 	switch meta.ChannelType {
 	case channeltype.Azure:
-		if meta.Mode == relaymode.ImagesGenerations {
+		apiVersion := a.azureAPIVersion
+		if apiVersion == "" {
+			apiVersion = AzureAPIVersion(meta.Config, meta.Mode, meta.ActualModelName, nil)
+		}
+		if meta.Mode == relaymode.ImagesGenerations || meta.Mode == relaymode.ImagesEdits {
 			// https://learn.microsoft.com/en-us/azure/ai-services/openai/dall-e-quickstart?tabs=dalle3%2Ccommand-line&pivots=rest-api
 			// https://{resource_name}.openai.azure.com/openai/deployments/dall-e-3/images/generations?api-version=2024-03-01-preview
-			fullRequestURL := fmt.Sprintf("%s/openai/deployments/%s/images/generations?api-version=%s", meta.BaseURL, meta.ActualModelName, meta.Config.APIVersion)
+			task := "generations"
+			if meta.Mode == relaymode.ImagesEdits {
+				task = "edits"
+			}
+			fullRequestURL := fmt.Sprintf("%s/openai/deployments/%s/images/%s?api-version=%s", meta.BaseURL, AzureDeployment(meta.Config, meta.ActualModelName), task, apiVersion)
 			return fullRequestURL, nil
 		}
 
 		// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/chatgpt-quickstart?pivots=rest-api&tabs=command-line#rest-api
 		requestURL := strings.Split(meta.RequestURLPath, "?")[0]
-		requestURL = fmt.Sprintf("%s?api-version=%s", requestURL, meta.Config.APIVersion)
+		requestURL = fmt.Sprintf("%s?api-version=%s", requestURL, apiVersion)
 		task := strings.TrimPrefix(requestURL, "/v1/")
-		model_ := meta.ActualModelName
-		model_ = strings.Replace(model_, ".", "", -1)
 		//https://github.com/songquanpeng/one-api/issues/1191
 		// {your endpoint}/openai/deployments/{your azure_model}/chat/completions?api-version={api_version}
-		requestURL = fmt.Sprintf("/openai/deployments/%s/%s", model_, task)
+		requestURL = fmt.Sprintf("/openai/deployments/%s/%s", AzureDeployment(meta.Config, meta.ActualModelName), task)
 		return GetFullRequestURL(meta.BaseURL, requestURL, meta.ChannelType), nil
 	case channeltype.Minimax:
 		return minimax.GetRequestURL(meta)
@@ -92,6 +101,9 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, relayMode int, request *model.G
 		}
 		request.StreamOptions.IncludeUsage = true
 	}
+	if relayMode == relaymode.ChatCompletions {
+		request.Messages = model.MoveToolImages(request.Messages)
+	}
 	return request, nil
 }
 
@@ -103,13 +115,19 @@ func (a *Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error)
 }
 
 func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
+	if meta.ChannelType == channeltype.Azure {
+		// the features are read from the request of the client, when it was read
+		clientRequestBody, _ := c.Get(ctxkey.KeyRequestBody)
+		body, _ := clientRequestBody.([]byte)
+		a.azureAPIVersion = AzureAPIVersion(meta.Config, meta.Mode, meta.ActualModelName, body)
+	}
 	return adaptor.DoRequestHelper(a, c, meta, requestBody)
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
 	if meta.IsStream {
 		var responseText string
-		err, responseText, usage = StreamHandler(c, resp, meta.Mode)
+		err, responseText, usage = StreamHandler(c, resp, meta.Mode, meta.PromptTokens, meta.ActualModelName)
 		if usage == nil || usage.TotalTokens == 0 {
 			usage = ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
 		}
@@ -119,7 +137,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 		}
 	} else {
 		switch meta.Mode {
-		case relaymode.ImagesGenerations:
+		case relaymode.ImagesGenerations, relaymode.ImagesEdits:
 			err, _ = ImageHandler(c, resp)
 		default:
 			err, usage = Handler(c, resp, meta.PromptTokens, meta.ActualModelName)
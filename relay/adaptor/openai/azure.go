@@ -0,0 +1,145 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// Azure serves a model through a deployment of the resource, whose name is set in the
+// deployments of the channel config, and needs an api-version recent enough for the
+// features of the request.
+
+// AzureDefaultAPIVersion is used when the channel does not pin an api-version
+const AzureDefaultAPIVersion = "2024-10-21"
+
+const (
+	AzureFeatureVision            = "vision"
+	AzureFeatureTools             = "tools"
+	AzureFeatureStructuredOutputs = "structured_outputs"
+	AzureFeatureReasoning         = "reasoning"
+	AzureFeatureImages            = "images"
+	AzureFeatureAudio             = "audio"
+	AzureFeatureResponses         = "responses"
+)
+
+// azureMinAPIVersions are the first api-versions supporting a feature
+var azureMinAPIVersions = map[string]string{
+	AzureFeatureVision:            "2023-12-01-preview",
+	AzureFeatureTools:             "2023-12-01-preview",
+	AzureFeatureStructuredOutputs: "2024-08-01-preview",
+	AzureFeatureReasoning:         "2024-12-01-preview",
+	AzureFeatureImages:            "2025-04-01-preview",
+	AzureFeatureAudio:             "2024-06-01",
+	AzureFeatureResponses:         "2025-03-01-preview",
+}
+
+// AzureDeployment returns the deployment serving a model, by default the model name
+// without dots, which Azure does not allow in deployment names
+func AzureDeployment(config model.ChannelConfig, modelName string) string {
+	if deployment, ok := config.Deployments[modelName]; ok && deployment != "" {
+		return deployment
+	}
+	return strings.Replace(modelName, ".", "", -1)
+}
+
+// AzureAPIVersion returns the api-version of a request: the version pinned on the channel,
+// or the default one, raised to the version the features of the request need. The
+// api_versions of the channel config set the version used for a feature.
+func AzureAPIVersion(config model.ChannelConfig, relayMode int, modelName string, requestBody []byte) string {
+	apiVersion := config.APIVersion
+	if apiVersion == "" {
+		apiVersion = AzureDefaultAPIVersion
+	}
+	for _, feature := range azureFeatures(relayMode, modelName, requestBody) {
+		required, ok := config.APIVersions[feature]
+		if !ok {
+			required = azureMinAPIVersions[feature]
+		}
+		if laterAPIVersion(required, apiVersion) {
+			apiVersion = required
+		}
+	}
+	return apiVersion
+}
+
+// laterAPIVersion tells whether an api-version is after another, a GA version is after
+// the previews of its date
+func laterAPIVersion(a string, b string) bool {
+	if len(a) < 10 || len(b) < 10 {
+		return false
+	}
+	if a[:10] != b[:10] {
+		return a[:10] > b[:10]
+	}
+	return !strings.HasSuffix(a, "-preview") && strings.HasSuffix(b, "-preview")
+}
+
+func isReasoningModel(modelName string) bool {
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5", "codex-"} {
+		if strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// azureFeatures returns the features a request uses, the body is optional
+func azureFeatures(relayMode int, modelName string, requestBody []byte) []string {
+	var features []string
+	switch relayMode {
+	case relaymode.ImagesGenerations, relaymode.ImagesEdits:
+		if relayMode == relaymode.ImagesEdits || strings.HasPrefix(modelName, "gpt-image") {
+			features = append(features, AzureFeatureImages)
+		}
+		return features
+	case relaymode.AudioSpeech, relaymode.AudioTranscription, relaymode.AudioTranslation:
+		return append(features, AzureFeatureAudio)
+	case relaymode.Responses:
+		return append(features, AzureFeatureResponses)
+	}
+	if isReasoningModel(modelName) {
+		features = append(features, AzureFeatureReasoning)
+	}
+	if len(requestBody) == 0 || requestBody[0] != '{' {
+		return features
+	}
+	var request struct {
+		Messages []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+		Tools          json.RawMessage `json:"tools"`
+		Functions      json.RawMessage `json:"functions"`
+		ResponseFormat *struct {
+			Type string `json:"type"`
+		} `json:"response_format"`
+		ReasoningEffort     *string `json:"reasoning_effort"`
+		MaxCompletionTokens *int    `json:"max_completion_tokens"`
+	}
+	if err := json.Unmarshal(requestBody, &request); err != nil {
+		return features
+	}
+	for _, message := range request.Messages {
+		if bytes.Contains(message.Content, []byte(`"image_url"`)) {
+			features = append(features, AzureFeatureVision)
+			break
+		}
+	}
+	if isJSONSet(request.Tools) || isJSONSet(request.Functions) {
+		features = append(features, AzureFeatureTools)
+	}
+	if request.ResponseFormat != nil && request.ResponseFormat.Type == "json_schema" {
+		features = append(features, AzureFeatureStructuredOutputs)
+	}
+	if request.ReasoningEffort != nil || request.MaxCompletionTokens != nil {
+		features = append(features, AzureFeatureReasoning)
+	}
+	return features
+}
+
+func isJSONSet(value json.RawMessage) bool {
+	return len(value) > 0 && string(value) != "null"
+}
@@ -0,0 +1,31 @@
+package openai_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func TestAzureDeployment(t *testing.T) {
+	config := model.ChannelConfig{Deployments: map[string]string{"gpt-4o": "prod-gpt4o"}}
+	assert.Equal(t, "prod-gpt4o", openai.AzureDeployment(config, "gpt-4o"))
+	assert.Equal(t, "gpt-35-turbo", openai.AzureDeployment(config, "gpt-3.5-turbo"))
+}
+
+func TestAzureAPIVersion(t *testing.T) {
+	var config model.ChannelConfig
+	assert.Equal(t, openai.AzureDefaultAPIVersion, openai.AzureAPIVersion(config, relaymode.ChatCompletions, "gpt-4o", []byte(`{"messages":[{"role":"user","content":"hi"}],"tools":null}`)))
+	assert.Equal(t, "2024-12-01-preview", openai.AzureAPIVersion(config, relaymode.ChatCompletions, "o3-mini", nil))
+	assert.Equal(t, "2025-04-01-preview", openai.AzureAPIVersion(config, relaymode.ImagesEdits, "gpt-image-1", nil))
+
+	config.APIVersion = "2023-05-15"
+	assert.Equal(t, "2023-12-01-preview", openai.AzureAPIVersion(config, relaymode.ChatCompletions, "gpt-4o", []byte(`{"messages":[{"role":"user","content":[{"type":"image_url","image_url":{"url":"x"}}]}]}`)))
+	assert.Equal(t, "2023-05-15", openai.AzureAPIVersion(config, relaymode.ChatCompletions, "gpt-4o", []byte(`{"messages":[]}`)))
+
+	config.APIVersions = map[string]string{openai.AzureFeatureTools: "2024-02-15-preview"}
+	assert.Equal(t, "2024-02-15-preview", openai.AzureAPIVersion(config, relaymode.ChatCompletions, "gpt-4o", []byte(`{"messages":[],"tools":[{"type":"function"}]}`)))
+}
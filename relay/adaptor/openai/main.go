@@ -1,18 +1,17 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"io"
 	"net/http"
-	"strings"
 
 	"github.com/songquanpeng/one-api/common/render"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
@@ -24,69 +23,111 @@ const (
 	dataPrefixLength = len(dataPrefix)
 )
 
-func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.ErrorWithStatusCode, string, *model.Usage) {
+// StreamHandler relays a stream re-framed into strict SSE. When the channel did not send the
+// usage, it is counted from the text, the tool calls and the reasoning, and sent in a final usage chunk if the
+// client asked for it. A stream ending before the completion, because the channel failed or
+// the client left, is flagged with ctxkey.PartialCompletion and billed by the output streamed.
+func StreamHandler(c *gin.Context, resp *http.Response, relayMode int, promptTokens int, modelName string) (*model.ErrorWithStatusCode, string, *model.Usage) {
 	responseText := ""
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Split(bufio.ScanLines)
+	reasoningText := ""
+	toolCallText := "" // the names and arguments of the tool calls streamed
+	reader := newStreamReader(resp.Body)
 	var usage *model.Usage
+	var lastChunk ChatCompletionsStreamResponse
+	finished := false // the stream got to [DONE] or a finish reason, some channels send the usage in every chunk
 
 	common.SetEventStreamHeaders(c)
 
-	doneRendered := false
-	for scanner.Scan() {
-		data := scanner.Text()
-		if len(data) < dataPrefixLength { // ignore blank line or wrong format
-			continue
+	toolCallNormalizer := NewToolCallStreamNormalizer()
+	for {
+		event, ok := reader.Next()
+		if !ok {
+			break
 		}
-		if data[:dataPrefixLength] != dataPrefix && data[:dataPrefixLength] != done {
+		if event.comment != "" {
+			render.Comment(c, event.comment)
 			continue
 		}
-		if strings.HasPrefix(data[dataPrefixLength:], done) {
-			render.StringData(c, data)
-			doneRendered = true
-			continue
+		if event.done {
+			finished = true
+			break
 		}
+		data := event.data
 		switch relayMode {
 		case relaymode.ChatCompletions:
 			var streamResponse ChatCompletionsStreamResponse
-			err := json.Unmarshal([]byte(data[dataPrefixLength:]), &streamResponse)
+			err := json.Unmarshal([]byte(data), &streamResponse)
 			if err != nil {
 				logger.SysError("error unmarshalling stream response: " + err.Error())
 				render.StringData(c, data) // if error happened, pass the data to client
 				continue                   // just ignore the error
 			}
+			if streamResponse.Object == "chat.completion" {
+				// a whole chat completion sent instead of a stream
+				data = string(completionAsChunk([]byte(data)))
+				streamResponse = ChatCompletionsStreamResponse{}
+				if err = json.Unmarshal([]byte(data), &streamResponse); err != nil {
+					continue
+				}
+			}
 			if len(streamResponse.Choices) == 0 && streamResponse.Usage == nil {
 				// but for empty choice and no usage, we should not pass it to client, this is for azure
 				continue // just ignore empty choice
 			}
-			render.StringData(c, data)
+			render.StringData(c, string(toolCallNormalizer.normalizeStreamData([]byte(data))))
 			for _, choice := range streamResponse.Choices {
 				responseText += conv.AsString(choice.Delta.Content)
+				reasoningText += conv.AsString(choice.Delta.ReasoningContent)
+				for _, toolCall := range choice.Delta.ToolCalls {
+					toolCallText += toolCall.Function.Name + conv.AsString(toolCall.Function.Arguments)
+				}
+				if choice.FinishReason != nil && *choice.FinishReason != "" {
+					finished = true
+				}
 			}
 			if streamResponse.Usage != nil {
 				usage = streamResponse.Usage
 			}
+			lastChunk = streamResponse
 		case relaymode.Completions:
 			render.StringData(c, data)
 			var streamResponse CompletionsStreamResponse
-			err := json.Unmarshal([]byte(data[dataPrefixLength:]), &streamResponse)
+			err := json.Unmarshal([]byte(data), &streamResponse)
 			if err != nil {
 				logger.SysError("error unmarshalling stream response: " + err.Error())
 				continue
 			}
 			for _, choice := range streamResponse.Choices {
 				responseText += choice.Text
+				if choice.FinishReason != "" {
+					finished = true
+				}
 			}
+			if streamResponse.Usage != nil {
+				usage = streamResponse.Usage
+			}
+			lastChunk.Id, lastChunk.Object, lastChunk.Created, lastChunk.Model = streamResponse.Id, streamResponse.Object, streamResponse.Created, streamResponse.Model
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := reader.Err(); err != nil {
 		logger.SysError("error reading stream: " + err.Error())
 	}
+	if !finished {
+		// the upstream failed or the client left mid-stream, only the output streamed is billed
+		logger.Warnf(c.Request.Context(), "stream ended before the completion, billing the output streamed")
+		c.Set(ctxkey.PartialCompletion, true)
+		usage = nil
+	}
 
-	if !doneRendered {
-		render.Done(c)
+	if usage == nil {
+		usage = ResponseText2Usage(responseText+toolCallText, modelName, promptTokens)
+		usage.AddReasoningTokens(CountTokenText(reasoningText, modelName))
+		if ClientIncludesUsage(c) {
+			renderUsageChunk(c, &lastChunk, usage)
+		}
 	}
+	render.Done(c)
 
 	err := resp.Body.Close()
 	if err != nil {
@@ -116,8 +157,12 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 			StatusCode: resp.StatusCode,
 		}, nil
 	}
+	normalizedBody := normalizeResponseBody(responseBody)
+	if len(normalizedBody) != len(responseBody) {
+		resp.Header.Del("Content-Length")
+	}
 	// Reset response body
-	resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
+	resp.Body = io.NopCloser(bytes.NewBuffer(normalizedBody))
 
 	// We shouldn't set the header before we parse the response body, because the parse part may fail.
 	// And then we will have to send an error response, but in this case, the header has already been set.
@@ -138,14 +183,17 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 
 	if textResponse.Usage.TotalTokens == 0 || (textResponse.Usage.PromptTokens == 0 && textResponse.Usage.CompletionTokens == 0) {
 		completionTokens := 0
+		reasoningTokens := 0
 		for _, choice := range textResponse.Choices {
 			completionTokens += CountTokenText(choice.Message.StringContent(), modelName)
+			reasoningTokens += CountTokenText(conv.AsString(choice.Message.ReasoningContent), modelName)
 		}
 		textResponse.Usage = model.Usage{
 			PromptTokens:     promptTokens,
 			CompletionTokens: completionTokens,
 			TotalTokens:      promptTokens + completionTokens,
 		}
+		textResponse.Usage.AddReasoningTokens(reasoningTokens)
 	}
 	return nil, &textResponse.Usage
 }
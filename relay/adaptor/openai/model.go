@@ -39,15 +39,25 @@ type ImageRequest struct {
 }
 
 type WhisperJSONResponse struct {
-	Text string `json:"text,omitempty"`
+	Text  string      `json:"text,omitempty"`
+	Usage *AudioUsage `json:"usage,omitempty"`
+}
+
+// AudioUsage is the usage of a transcription, in seconds of audio or in tokens
+type AudioUsage struct {
+	Type         string  `json:"type"`
+	Seconds      float64 `json:"seconds,omitempty"`
+	InputTokens  int     `json:"input_tokens,omitempty"`
+	OutputTokens int     `json:"output_tokens,omitempty"`
 }
 
 type WhisperVerboseJSONResponse struct {
-	Task     string    `json:"task,omitempty"`
-	Language string    `json:"language,omitempty"`
-	Duration float64   `json:"duration,omitempty"`
-	Text     string    `json:"text,omitempty"`
-	Segments []Segment `json:"segments,omitempty"`
+	Task     string      `json:"task,omitempty"`
+	Language string      `json:"language,omitempty"`
+	Duration float64     `json:"duration,omitempty"`
+	Text     string      `json:"text,omitempty"`
+	Segments []Segment   `json:"segments,omitempty"`
+	Usage    *AudioUsage `json:"usage,omitempty"`
 }
 
 type Segment struct {
@@ -85,7 +95,36 @@ type SlimTextResponse struct {
 type TextResponseChoice struct {
 	Index         int `json:"index"`
 	model.Message `json:"message"`
-	FinishReason  string `json:"finish_reason"`
+	FinishReason  string    `json:"finish_reason"`
+	Logprobs      *Logprobs `json:"logprobs,omitempty"`
+}
+
+// Logprobs are the log probabilities of the tokens of a choice, asked for with logprobs and
+// top_logprobs
+type Logprobs struct {
+	Content []TokenLogprob `json:"content"`
+}
+
+type TokenLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+// TokenBytes returns the UTF-8 bytes of a token, as the logprobs give them
+func TokenBytes(token string) []int {
+	bytes := make([]int, len(token))
+	for i := 0; i < len(token); i++ {
+		bytes[i] = int(token[i])
+	}
+	return bytes
 }
 
 type TextResponse struct {
@@ -126,6 +165,7 @@ type ChatCompletionsStreamResponseChoice struct {
 	Index        int           `json:"index"`
 	Delta        model.Message `json:"delta"`
 	FinishReason *string       `json:"finish_reason,omitempty"`
+	Logprobs     *Logprobs     `json:"logprobs,omitempty"`
 }
 
 type ChatCompletionsStreamResponse struct {
@@ -138,8 +178,13 @@ type ChatCompletionsStreamResponse struct {
 }
 
 type CompletionsStreamResponse struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
 	Choices []struct {
 		Text         string `json:"text"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *model.Usage `json:"usage,omitempty"`
 }
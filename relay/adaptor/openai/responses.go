@@ -0,0 +1,357 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// https://platform.openai.com/docs/api-reference/responses
+// The responses API is relayed natively to OpenAI channels, the other channels get an
+// equivalent chat completion request whose response is converted back.
+
+type ResponsesContentPart struct {
+	Type     string `json:"type"` // input_text, output_text, input_image, input_file
+	Text     string `json:"text,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	FileId   string `json:"file_id,omitempty"` // an image uploaded with the files API
+	Detail   string `json:"detail,omitempty"`
+}
+
+type ResponsesInputItem struct {
+	Type      string          `json:"type,omitempty"` // message (default), function_call, function_call_output, reasoning
+	Role      string          `json:"role,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"` // string or content parts
+	CallId    string          `json:"call_id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Arguments string          `json:"arguments,omitempty"`
+	Output    json.RawMessage `json:"output,omitempty"` // string or content parts
+}
+
+type ResponsesTool struct {
+	Type        string `json:"type"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+	Strict      *bool  `json:"strict,omitempty"`
+}
+
+type ResponsesReasoning struct {
+	Effort  *string `json:"effort,omitempty"`
+	Summary *string `json:"summary,omitempty"`
+}
+
+type ResponsesTextFormat struct {
+	Type        string         `json:"type"` // text, json_object or json_schema
+	Name        string         `json:"name,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema,omitempty"`
+	Strict      *bool          `json:"strict,omitempty"`
+}
+
+type ResponsesText struct {
+	Format *ResponsesTextFormat `json:"format,omitempty"`
+}
+
+type ResponsesRequest struct {
+	Model              string              `json:"model"`
+	Input              json.RawMessage     `json:"input"` // string or items
+	Instructions       string              `json:"instructions,omitempty"`
+	MaxOutputTokens    int                 `json:"max_output_tokens,omitempty"`
+	Temperature        *float64            `json:"temperature,omitempty"`
+	TopP               *float64            `json:"top_p,omitempty"`
+	Tools              []ResponsesTool     `json:"tools,omitempty"`
+	ToolChoice         any                 `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool               `json:"parallel_tool_calls,omitempty"`
+	PreviousResponseId string              `json:"previous_response_id,omitempty"`
+	Store              *bool               `json:"store,omitempty"`
+	Stream             bool                `json:"stream,omitempty"`
+	Background         bool                `json:"background,omitempty"`
+	Reasoning          *ResponsesReasoning `json:"reasoning,omitempty"`
+	Text               *ResponsesText      `json:"text,omitempty"`
+	Metadata           map[string]string   `json:"metadata,omitempty"`
+	User               string              `json:"user,omitempty"`
+}
+
+// Stored tells whether the response is kept for retrieval, the default as upstream
+func (r *ResponsesRequest) Stored() bool {
+	return r.Store == nil || *r.Store
+}
+
+// InputItems returns the input as items, a string input is a user message
+func (r *ResponsesRequest) InputItems() ([]ResponsesInputItem, error) {
+	if len(r.Input) == 0 || string(r.Input) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(r.Input, &text); err == nil {
+		content, _ := json.Marshal(text)
+		return []ResponsesInputItem{{Type: "message", Role: "user", Content: content}}, nil
+	}
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(r.Input, &items); err != nil {
+		return nil, fmt.Errorf("invalid input: %w", err)
+	}
+	return items, nil
+}
+
+func parseResponsesContent(raw json.RawMessage) ([]ResponsesContentPart, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []ResponsesContentPart{{Type: "input_text", Text: text}}, nil
+	}
+	var parts []ResponsesContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+func responsesContentText(parts []ResponsesContentPart) string {
+	var texts []string
+	for _, part := range parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func convertResponsesMessage(item ResponsesInputItem) (model.Message, error) {
+	parts, err := parseResponsesContent(item.Content)
+	if err != nil {
+		return model.Message{}, fmt.Errorf("invalid content of a %s message: %w", item.Role, err)
+	}
+	role := item.Role
+	if role == "developer" {
+		role = "system"
+	}
+	message := model.Message{Role: role}
+	var contents []model.MessageContent
+	hasImage := false
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text", "refusal":
+			contents = append(contents, model.MessageContent{Type: model.ContentTypeText, Text: part.Text})
+		case "input_image":
+			hasImage = true
+			url := part.ImageURL
+			if url == "" {
+				// resolved by the chat completion relay
+				url = part.FileId
+			}
+			contents = append(contents, model.MessageContent{
+				Type:     model.ContentTypeImageURL,
+				ImageURL: &model.ImageURL{Url: url, Detail: part.Detail},
+			})
+		default:
+			return model.Message{}, fmt.Errorf("content type %s is not supported by this channel", part.Type)
+		}
+	}
+	if hasImage && role == "user" {
+		message.Content = contents
+	} else {
+		message.Content = responsesContentText(parts)
+	}
+	return message, nil
+}
+
+func convertResponsesToolChoice(choice any) any {
+	switch v := choice.(type) {
+	case string:
+		return v
+	case map[string]any:
+		if v["type"] == "function" {
+			return map[string]any{
+				"type":     "function",
+				"function": map[string]any{"name": v["name"]},
+			}
+		}
+	}
+	return "auto"
+}
+
+// ConvertResponsesRequest converts a responses request with the given input items,
+// the ones of the previous responses included, to the chat format
+func ConvertResponsesRequest(request *ResponsesRequest, items []ResponsesInputItem) (*model.GeneralOpenAIRequest, error) {
+	chatRequest := model.GeneralOpenAIRequest{
+		Model:            request.Model,
+		MaxTokens:        request.MaxOutputTokens,
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		Stream:           request.Stream,
+		ParallelTooCalls: request.ParallelToolCalls,
+		User:             request.User,
+	}
+	if request.Stream {
+		chatRequest.StreamOptions = &model.StreamOptions{IncludeUsage: true}
+	}
+	if request.Reasoning != nil {
+		chatRequest.ReasoningEffort = request.Reasoning.Effort
+	}
+	if request.Text != nil && request.Text.Format != nil {
+		switch format := request.Text.Format; format.Type {
+		case "json_object":
+			chatRequest.ResponseFormat = &model.ResponseFormat{Type: "json_object"}
+		case "json_schema":
+			chatRequest.ResponseFormat = &model.ResponseFormat{
+				Type: "json_schema",
+				JsonSchema: &model.JSONSchema{
+					Name:        format.Name,
+					Description: format.Description,
+					Schema:      format.Schema,
+					Strict:      format.Strict,
+				},
+			}
+		}
+	}
+	if request.Instructions != "" {
+		chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "system", Content: request.Instructions})
+	}
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			message, err := convertResponsesMessage(item)
+			if err != nil {
+				return nil, err
+			}
+			chatRequest.Messages = append(chatRequest.Messages, message)
+		case "function_call":
+			toolCall := model.Tool{
+				Id:       item.CallId,
+				Type:     "function",
+				Function: model.Function{Name: item.Name, Arguments: item.Arguments},
+			}
+			// parallel calls are items in a row, they make one assistant message
+			last := len(chatRequest.Messages) - 1
+			if last >= 0 && chatRequest.Messages[last].Role == "assistant" {
+				chatRequest.Messages[last].ToolCalls = append(chatRequest.Messages[last].ToolCalls, toolCall)
+			} else {
+				chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "assistant", Content: "", ToolCalls: []model.Tool{toolCall}})
+			}
+		case "function_call_output":
+			var output string
+			if err := json.Unmarshal(item.Output, &output); err != nil {
+				parts, err := parseResponsesContent(item.Output)
+				if err != nil {
+					return nil, fmt.Errorf("invalid function call output: %w", err)
+				}
+				output = responsesContentText(parts)
+			}
+			chatRequest.Messages = append(chatRequest.Messages, model.Message{Role: "tool", Content: output, ToolCallId: item.CallId})
+		case "reasoning":
+			// reasoning items are only understood by the provider which made them
+		default:
+			return nil, fmt.Errorf("input item type %s is not supported by this channel", item.Type)
+		}
+	}
+	for _, tool := range request.Tools {
+		if tool.Type != "function" {
+			return nil, fmt.Errorf("tool type %s is not supported by this channel", tool.Type)
+		}
+		chatRequest.Tools = append(chatRequest.Tools, model.Tool{
+			Type: "function",
+			Function: model.Function{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	if request.ToolChoice != nil && len(chatRequest.Tools) > 0 {
+		chatRequest.ToolChoice = convertResponsesToolChoice(request.ToolChoice)
+	}
+	return &chatRequest, nil
+}
+
+func (u ResponsesUsage) toUsage() *model.Usage {
+	return &model.Usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
+
+// ResponsesHandler passes a native response through, it returns the response object
+func ResponsesHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, []byte, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil, nil
+	}
+	_ = resp.Body.Close()
+	var response struct {
+		Usage *ResponsesUsage `json:"usage"`
+	}
+	if err = json.Unmarshal(responseBody, &response); err != nil {
+		return ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil, nil
+	}
+	for k, v := range resp.Header {
+		c.Writer.Header().Set(k, v[0])
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	if _, err = c.Writer.Write(responseBody); err != nil {
+		return ErrorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError), nil, nil
+	}
+	if response.Usage == nil {
+		return nil, responseBody, nil
+	}
+	return nil, responseBody, response.Usage.toUsage()
+}
+
+// ResponsesStreamHandler passes the events of a native response through, it returns
+// the response object of the final event
+func ResponsesStreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, []byte, *model.Usage) {
+	scanner := bufio.NewScanner(resp.Body)
+	// the final event holds the whole response
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	common.SetEventStreamHeaders(c)
+	var response []byte
+	var usage *model.Usage
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, err := io.WriteString(c.Writer, line+"\n"); err != nil {
+			break // the client is gone
+		}
+		if line == "" {
+			c.Writer.Flush()
+			continue
+		}
+		if !strings.HasPrefix(line, dataPrefix) {
+			continue
+		}
+		var event struct {
+			Type     string          `json:"type"`
+			Response json.RawMessage `json:"response"`
+		}
+		if err := json.Unmarshal([]byte(line[dataPrefixLength:]), &event); err != nil {
+			continue
+		}
+		switch event.Type {
+		case "response.completed", "response.incomplete", "response.failed":
+			response = event.Response
+			var object struct {
+				Usage *ResponsesUsage `json:"usage"`
+			}
+			if err := json.Unmarshal(event.Response, &object); err == nil && object.Usage != nil {
+				usage = object.Usage.toUsage()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logger.SysError("error reading responses stream: " + err.Error())
+	}
+	_ = resp.Body.Close()
+	return nil, response, usage
+}
@@ -0,0 +1,484 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokens        int `json:"output_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+	TotalTokens int `json:"total_tokens"`
+}
+
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+type ResponsesResponse struct {
+	Id                 string                      `json:"id"`
+	Object             string                      `json:"object"`
+	CreatedAt          int64                       `json:"created_at"`
+	Status             string                      `json:"status"` // queued, in_progress, completed, incomplete, failed or cancelled
+	Background         bool                        `json:"background"`
+	Error              *ResponsesError             `json:"error"`
+	IncompleteDetails  *ResponsesIncompleteDetails `json:"incomplete_details"`
+	Instructions       string                      `json:"instructions,omitempty"`
+	MaxOutputTokens    int                         `json:"max_output_tokens,omitempty"`
+	Model              string                      `json:"model"`
+	Output             []map[string]any            `json:"output"`
+	ParallelToolCalls  bool                        `json:"parallel_tool_calls"`
+	PreviousResponseId string                      `json:"previous_response_id,omitempty"`
+	Store              bool                        `json:"store"`
+	Temperature        *float64                    `json:"temperature,omitempty"`
+	TopP               *float64                    `json:"top_p,omitempty"`
+	ToolChoice         any                         `json:"tool_choice"`
+	Tools              []ResponsesTool             `json:"tools"`
+	Usage              *ResponsesUsage             `json:"usage"`
+	Metadata           map[string]string           `json:"metadata"`
+}
+
+func NewResponsesId() string {
+	return "resp_" + random.GetUUID()
+}
+
+// NewResponsesResponse returns the in progress response of a request
+func NewResponsesResponse(id string, request *ResponsesRequest) *ResponsesResponse {
+	response := ResponsesResponse{
+		Id:                 id,
+		Object:             "response",
+		CreatedAt:          time.Now().Unix(),
+		Status:             "in_progress",
+		Background:         request.Background,
+		Instructions:       request.Instructions,
+		MaxOutputTokens:    request.MaxOutputTokens,
+		Model:              request.Model,
+		Output:             []map[string]any{},
+		ParallelToolCalls:  request.ParallelToolCalls == nil || *request.ParallelToolCalls,
+		PreviousResponseId: request.PreviousResponseId,
+		Store:              request.Stored(),
+		Temperature:        request.Temperature,
+		TopP:               request.TopP,
+		ToolChoice:         request.ToolChoice,
+		Tools:              request.Tools,
+		Metadata:           request.Metadata,
+	}
+	if response.ToolChoice == nil {
+		response.ToolChoice = "auto"
+	}
+	if response.Tools == nil {
+		response.Tools = []ResponsesTool{}
+	}
+	if response.Metadata == nil {
+		response.Metadata = map[string]string{}
+	}
+	return &response
+}
+
+func responsesUsage(usage model.Usage) *ResponsesUsage {
+	responsesUsage := ResponsesUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+		TotalTokens:  usage.PromptTokens + usage.CompletionTokens,
+	}
+	responsesUsage.InputTokensDetails.CachedTokens = usage.GetCachedTokens()
+	responsesUsage.OutputTokensDetails.ReasoningTokens = usage.GetReasoningTokens()
+	return &responsesUsage
+}
+
+func (r *ResponsesResponse) finish(finishReason string) {
+	r.Status = "completed"
+	if finishReason == "length" {
+		r.Status = "incomplete"
+		r.IncompleteDetails = &ResponsesIncompleteDetails{Reason: "max_output_tokens"}
+	} else if finishReason == "content_filter" {
+		r.Status = "incomplete"
+		r.IncompleteDetails = &ResponsesIncompleteDetails{Reason: "content_filter"}
+	}
+}
+
+func reasoningItem(id string, text string) map[string]any {
+	summary := []map[string]any{}
+	if text != "" {
+		summary = append(summary, map[string]any{"type": "summary_text", "text": text})
+	}
+	return map[string]any{"type": "reasoning", "id": id, "summary": summary}
+}
+
+func outputTextPart(text string) map[string]any {
+	return map[string]any{"type": "output_text", "text": text, "annotations": []any{}}
+}
+
+func messageItem(id string, status string, text string) map[string]any {
+	content := []map[string]any{}
+	if status == "completed" {
+		content = append(content, outputTextPart(text))
+	}
+	return map[string]any{"type": "message", "id": id, "status": status, "role": "assistant", "content": content}
+}
+
+func functionCallItem(id string, status string, toolCall model.Tool) map[string]any {
+	arguments, _ := toolCall.Function.Arguments.(string)
+	return map[string]any{
+		"type":      "function_call",
+		"id":        id,
+		"status":    status,
+		"call_id":   toolCall.Id,
+		"name":      toolCall.Function.Name,
+		"arguments": arguments,
+	}
+}
+
+// ApplyChatCompletion completes the response with the output of a chat completion
+func (r *ResponsesResponse) ApplyChatCompletion(completion *TextResponse) {
+	r.Usage = responsesUsage(completion.Usage)
+	if len(completion.Choices) == 0 {
+		r.finish("")
+		return
+	}
+	choice := completion.Choices[0]
+	if reasoning, ok := choice.ReasoningContent.(string); ok && reasoning != "" {
+		r.Output = append(r.Output, reasoningItem("rs_"+random.GetUUID(), reasoning))
+	}
+	if text := choice.StringContent(); text != "" {
+		r.Output = append(r.Output, messageItem("msg_"+random.GetUUID(), "completed", text))
+	}
+	for _, toolCall := range choice.ToolCalls {
+		r.Output = append(r.Output, functionCallItem("fc_"+random.GetUUID(), "completed", toolCall))
+	}
+	r.finish(choice.FinishReason)
+}
+
+// responsesOutputItem is the output item being streamed
+type responsesOutputItem struct {
+	kind     string // reasoning, message or function_call
+	index    int    // output index
+	id       string
+	text     strings.Builder
+	toolCall model.Tool
+}
+
+// ResponsesWriter converts the chat completions written by the relay to a response.
+// A JSON body is buffered until Finish, a stream is converted to response events.
+type ResponsesWriter struct {
+	gin.ResponseWriter
+	response  *ResponsesResponse
+	body      bytes.Buffer
+	streaming bool
+	pending   []byte // partial SSE line
+
+	started      bool
+	finished     bool
+	sequence     int
+	item         *responsesOutputItem
+	toolItems    map[int]*responsesOutputItem
+	finishReason string
+	failed       bool
+}
+
+func NewResponsesWriter(w gin.ResponseWriter, response *ResponsesResponse) *ResponsesWriter {
+	return &ResponsesWriter{ResponseWriter: w, response: response, toolItems: make(map[int]*responsesOutputItem)}
+}
+
+// Response returns the final response, nil if the relay failed
+func (w *ResponsesWriter) Response() *ResponsesResponse {
+	if w.failed || !w.finished {
+		return nil
+	}
+	return w.response
+}
+
+func (w *ResponsesWriter) Write(data []byte) (int, error) {
+	if !w.streaming && w.body.Len() == 0 && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		w.streaming = true
+	}
+	if !w.streaming {
+		return w.body.Write(data)
+	}
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.IndexByte(w.pending, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:end]))
+		w.pending = w.pending[end+1:]
+		if err := w.handleStreamLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *ResponsesWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *ResponsesWriter) event(data map[string]any) error {
+	data["sequence_number"] = w.sequence
+	w.sequence++
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w.ResponseWriter, "event: %s\ndata: %s\n\n", data["type"], payload)
+	return err
+}
+
+func (w *ResponsesWriter) handleStreamLine(line string) error {
+	if !strings.HasPrefix(line, "data:") || w.finished {
+		return nil
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if data == "[DONE]" {
+		return w.finishStream()
+	}
+	var chunk ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return nil // not a chat completion chunk
+	}
+	if !w.started {
+		w.started = true
+		if err := w.event(map[string]any{"type": "response.created", "response": w.response}); err != nil {
+			return err
+		}
+		if err := w.event(map[string]any{"type": "response.in_progress", "response": w.response}); err != nil {
+			return err
+		}
+	}
+	if chunk.Usage != nil {
+		w.response.Usage = responsesUsage(*chunk.Usage)
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue // a response has a single output
+		}
+		if err := w.handleDelta(choice.Delta); err != nil {
+			return err
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			w.finishReason = *choice.FinishReason
+		}
+	}
+	w.Flush()
+	return nil
+}
+
+func (w *ResponsesWriter) openItem(kind string) (*responsesOutputItem, error) {
+	if err := w.closeItem(); err != nil {
+		return nil, err
+	}
+	prefix := map[string]string{"reasoning": "rs_", "message": "msg_", "function_call": "fc_"}[kind]
+	item := &responsesOutputItem{kind: kind, index: len(w.response.Output), id: prefix + random.GetUUID()}
+	var added map[string]any
+	switch kind {
+	case "reasoning":
+		added = reasoningItem(item.id, "")
+	case "message":
+		added = messageItem(item.id, "in_progress", "")
+	}
+	w.response.Output = append(w.response.Output, added)
+	w.item = item
+	if kind == "function_call" {
+		return item, nil // added once the name is known
+	}
+	if err := w.event(map[string]any{"type": "response.output_item.added", "output_index": item.index, "item": added}); err != nil {
+		return nil, err
+	}
+	switch kind {
+	case "reasoning":
+		return item, w.event(map[string]any{
+			"type": "response.reasoning_summary_part.added", "item_id": item.id, "output_index": item.index,
+			"summary_index": 0, "part": map[string]any{"type": "summary_text", "text": ""},
+		})
+	default:
+		return item, w.event(map[string]any{
+			"type": "response.content_part.added", "item_id": item.id, "output_index": item.index,
+			"content_index": 0, "part": outputTextPart(""),
+		})
+	}
+}
+
+func (w *ResponsesWriter) closeItem() error {
+	item := w.item
+	if item == nil {
+		return nil
+	}
+	w.item = nil
+	text := item.text.String()
+	var done map[string]any
+	switch item.kind {
+	case "reasoning":
+		done = reasoningItem(item.id, text)
+		if err := w.event(map[string]any{
+			"type": "response.reasoning_summary_text.done", "item_id": item.id, "output_index": item.index,
+			"summary_index": 0, "text": text,
+		}); err != nil {
+			return err
+		}
+		if err := w.event(map[string]any{
+			"type": "response.reasoning_summary_part.done", "item_id": item.id, "output_index": item.index,
+			"summary_index": 0, "part": map[string]any{"type": "summary_text", "text": text},
+		}); err != nil {
+			return err
+		}
+	case "message":
+		done = messageItem(item.id, "completed", text)
+		if err := w.event(map[string]any{
+			"type": "response.output_text.done", "item_id": item.id, "output_index": item.index,
+			"content_index": 0, "text": text,
+		}); err != nil {
+			return err
+		}
+		if err := w.event(map[string]any{
+			"type": "response.content_part.done", "item_id": item.id, "output_index": item.index,
+			"content_index": 0, "part": outputTextPart(text),
+		}); err != nil {
+			return err
+		}
+	case "function_call":
+		item.toolCall.Function.Arguments = text
+		done = functionCallItem(item.id, "completed", item.toolCall)
+		if err := w.event(map[string]any{
+			"type": "response.function_call_arguments.done", "item_id": item.id, "output_index": item.index,
+			"arguments": text,
+		}); err != nil {
+			return err
+		}
+	}
+	w.response.Output[item.index] = done
+	return w.event(map[string]any{"type": "response.output_item.done", "output_index": item.index, "item": done})
+}
+
+func (w *ResponsesWriter) handleDelta(delta model.Message) error {
+	if reasoning, ok := delta.ReasoningContent.(string); ok && reasoning != "" {
+		if w.item == nil || w.item.kind != "reasoning" {
+			if _, err := w.openItem("reasoning"); err != nil {
+				return err
+			}
+		}
+		w.item.text.WriteString(reasoning)
+		if err := w.event(map[string]any{
+			"type": "response.reasoning_summary_text.delta", "item_id": w.item.id, "output_index": w.item.index,
+			"summary_index": 0, "delta": reasoning,
+		}); err != nil {
+			return err
+		}
+	}
+	if text := delta.StringContent(); text != "" {
+		if w.item == nil || w.item.kind != "message" {
+			if _, err := w.openItem("message"); err != nil {
+				return err
+			}
+		}
+		w.item.text.WriteString(text)
+		if err := w.event(map[string]any{
+			"type": "response.output_text.delta", "item_id": w.item.id, "output_index": w.item.index,
+			"content_index": 0, "delta": text,
+		}); err != nil {
+			return err
+		}
+	}
+	for i, toolCall := range delta.ToolCalls {
+		index := i
+		if toolCall.Index != nil {
+			index = *toolCall.Index
+		}
+		item, ok := w.toolItems[index]
+		if !ok {
+			var err error
+			if item, err = w.openItem("function_call"); err != nil {
+				return err
+			}
+			item.toolCall = model.Tool{Id: toolCall.Id, Function: model.Function{Name: toolCall.Function.Name}}
+			w.toolItems[index] = item
+			added := functionCallItem(item.id, "in_progress", item.toolCall)
+			w.response.Output[item.index] = added
+			if err = w.event(map[string]any{"type": "response.output_item.added", "output_index": item.index, "item": added}); err != nil {
+				return err
+			}
+		}
+		arguments, _ := toolCall.Function.Arguments.(string)
+		if arguments == "" || item != w.item {
+			continue // the item of this call is closed already
+		}
+		item.text.WriteString(arguments)
+		if err := w.event(map[string]any{
+			"type": "response.function_call_arguments.delta", "item_id": item.id, "output_index": item.index,
+			"delta": arguments,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ResponsesWriter) finishStream() error {
+	if w.finished || !w.started {
+		return nil
+	}
+	w.finished = true
+	if err := w.closeItem(); err != nil {
+		return err
+	}
+	w.response.finish(w.finishReason)
+	eventType := "response.completed"
+	if w.response.Status == "incomplete" {
+		eventType = "response.incomplete"
+	}
+	err := w.event(map[string]any{"type": eventType, "response": w.response})
+	w.Flush()
+	return err
+}
+
+// Finish writes the converted JSON body, or ends a stream whose [DONE] was missing.
+// Errors are passed through, the responses API has the chat completions error format.
+func (w *ResponsesWriter) Finish() {
+	if w.streaming {
+		_ = w.finishStream()
+		return
+	}
+	if w.body.Len() == 0 {
+		return
+	}
+	w.finished = true
+	body := w.body.Bytes()
+	if w.Status() >= http.StatusBadRequest {
+		w.failed = true
+		_, _ = w.ResponseWriter.Write(body)
+		return
+	}
+	var completion TextResponse
+	if err := json.Unmarshal(body, &completion); err != nil {
+		w.failed = true
+		w.ResponseWriter.WriteHeader(http.StatusBadGateway)
+		payload, _ := json.Marshal(gin.H{"error": model.Error{Message: "invalid upstream response: " + err.Error(), Type: "one_api_error"}})
+		_, _ = w.ResponseWriter.Write(payload)
+		return
+	}
+	w.response.ApplyChatCompletion(&completion)
+	payload, _ := json.Marshal(w.response)
+	w.Header().Del("Content-Length") // copied from the upstream response
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.ResponseWriter.Write(payload)
+}
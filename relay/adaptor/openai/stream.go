@@ -0,0 +1,215 @@
+package openai
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/render"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Some OpenAI compatible channels do not stream strict SSE: data fields without the space
+// after the colon, several chunks on a line, a chunk spread over several lines, JSON lines
+// without data field, a whole completion sent as JSON instead of a stream, or no [DONE].
+// The streamReader re-frames their output into one event per chunk.
+
+const (
+	maxStreamLineSize    = 16 * 1024 * 1024
+	maxStreamPendingSize = 16 * 1024 * 1024
+)
+
+// streamEvent is an event of an upstream stream: a compact JSON chunk, the end of the
+// stream, or a comment, which channels send to keep the connection alive
+type streamEvent struct {
+	data    string
+	done    bool
+	comment string
+}
+
+type streamReader struct {
+	scanner *bufio.Scanner
+	pending string // beginning of a JSON chunk spread over several lines
+	events  []streamEvent
+}
+
+func newStreamReader(r io.Reader) *streamReader {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxStreamLineSize)
+	scanner.Split(bufio.ScanLines)
+	return &streamReader{scanner: scanner}
+}
+
+// Next returns the next event, false at the end of the stream
+func (r *streamReader) Next() (streamEvent, bool) {
+	for len(r.events) == 0 {
+		if !r.scanner.Scan() {
+			if r.pending != "" {
+				logger.SysError("stream ended inside a chunk, dropping: " + r.pending)
+				r.pending = ""
+			}
+			return streamEvent{}, false
+		}
+		r.readLine(r.scanner.Text())
+	}
+	event := r.events[0]
+	r.events = r.events[1:]
+	return event, true
+}
+
+func (r *streamReader) Err() error {
+	return r.scanner.Err()
+}
+
+func (r *streamReader) readLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	if r.pending == "" {
+		switch {
+		case strings.HasPrefix(line, ":"):
+			r.events = append(r.events, streamEvent{comment: line})
+			return
+		case strings.HasPrefix(line, "event:"), strings.HasPrefix(line, "id:"), strings.HasPrefix(line, "retry:"):
+			return
+		}
+	} else if strings.HasPrefix(line, ":") {
+		return
+	}
+	r.parse(line)
+}
+
+// parse reads the chunks of a line, the chunk it ends with is kept pending when incomplete
+func (r *streamReader) parse(text string) {
+	if r.pending != "" {
+		text = r.pending + "\n" + strings.TrimPrefix(strings.TrimPrefix(text, "data:"), " ")
+		r.pending = ""
+	}
+	for {
+		text = strings.TrimSpace(text)
+		switch {
+		case text == "":
+			return
+		case strings.HasPrefix(text, "data:"):
+			text = text[len("data:"):]
+			continue
+		case strings.HasPrefix(text, done):
+			r.events = append(r.events, streamEvent{done: true})
+			text = text[len(done):]
+			continue
+		case text[0] != '{' && text[0] != '[':
+			logger.SysError("ignoring stream data which is not JSON: " + text)
+			return
+		}
+		decoder := json.NewDecoder(strings.NewReader(text))
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) && len(text) < maxStreamPendingSize {
+				r.pending = text
+				return
+			}
+			// passed to the client as is
+			r.events = append(r.events, streamEvent{data: text})
+			return
+		}
+		r.appendChunks(raw)
+		text = text[decoder.InputOffset():]
+	}
+}
+
+func (r *streamReader) appendChunks(raw json.RawMessage) {
+	if raw[0] == '[' {
+		var chunks []json.RawMessage
+		if err := json.Unmarshal(raw, &chunks); err == nil {
+			for _, chunk := range chunks {
+				r.appendChunks(chunk)
+			}
+			return
+		}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, raw); err != nil {
+		r.events = append(r.events, streamEvent{data: string(raw)})
+		return
+	}
+	r.events = append(r.events, streamEvent{data: compact.String()})
+}
+
+// completionAsChunk turns a chat completion a channel sent instead of a stream into a chunk
+func completionAsChunk(data []byte) []byte {
+	if !bytes.Contains(data, []byte(`"message"`)) {
+		return data
+	}
+	var response map[string]any
+	if err := json.Unmarshal(data, &response); err != nil {
+		return data
+	}
+	choices, _ := response["choices"].([]any)
+	changed := false
+	for _, choice := range choices {
+		choice, ok := choice.(map[string]any)
+		if !ok {
+			continue
+		}
+		if message, ok := choice["message"]; ok {
+			choice["delta"] = message
+			delete(choice, "message")
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+	response["object"] = "chat.completion.chunk"
+	chunk, err := json.Marshal(response)
+	if err != nil {
+		return data
+	}
+	return chunk
+}
+
+// ClientIncludesUsage tells whether the client asked for the usage chunk, which the channel
+// is always asked for
+func ClientIncludesUsage(c *gin.Context) bool {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil || !bytes.Contains(requestBody, []byte(`"include_usage"`)) {
+		return false
+	}
+	var request model.GeneralOpenAIRequest
+	if err = json.Unmarshal(requestBody, &request); err != nil {
+		return false
+	}
+	return request.StreamOptions != nil && request.StreamOptions.IncludeUsage
+}
+
+// UsageChunk is the final chunk of stream_options.include_usage, without choices, for a
+// stream ending with lastChunk
+func UsageChunk(lastChunk *ChatCompletionsStreamResponse, usage *model.Usage) map[string]any {
+	object := lastChunk.Object
+	if object == "" {
+		object = "chat.completion.chunk"
+	}
+	return map[string]any{
+		"id":      lastChunk.Id,
+		"object":  object,
+		"created": lastChunk.Created,
+		"model":   lastChunk.Model,
+		"choices": []any{},
+		"usage":   usage,
+	}
+}
+
+// renderUsageChunk sends the usage chunk of a stream whose channel did not send it
+func renderUsageChunk(c *gin.Context, lastChunk *ChatCompletionsStreamResponse, usage *model.Usage) {
+	if err := render.ObjectData(c, UsageChunk(lastChunk, usage)); err != nil {
+		logger.SysError("error rendering usage chunk: " + err.Error())
+	}
+}
@@ -0,0 +1,138 @@
+package openai_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func relayStream(t *testing.T, requestBody string, upstream string) (string, int) {
+	// counts the tokens without the tokenizer files
+	config.ApproximateTokenEnabled = true
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(requestBody))
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(upstream))}
+	err, _, usage := openai.StreamHandler(c, resp, relaymode.ChatCompletions, 7, "gpt-4o")
+	assert.Nil(t, err)
+	completionTokens := 0
+	if usage != nil {
+		completionTokens = usage.CompletionTokens
+	}
+	return w.Body.String(), completionTokens
+}
+
+func TestStreamHandlerRepairsStream(t *testing.T) {
+	upstream := ": keep-alive\n" +
+		`data:{"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"Hel"}}]}` +
+		`data: {"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"lo"}}]}` + "\n" +
+		"data: {\"id\":\"1\",\"object\":\"chat.completion.chunk\",\n" +
+		"data: \"created\":1,\"model\":\"m\",\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n"
+	body, completionTokens := relayStream(t, `{"model":"gpt-4o","stream":true,"stream_options":{"include_usage":true}}`, upstream)
+	events := strings.Split(strings.TrimSpace(body), "\n\n")
+	assert.Len(t, events, 6)
+	assert.Equal(t, ": keep-alive", events[0])
+	assert.Contains(t, events[1], `"content":"Hel"`)
+	assert.Contains(t, events[2], `"content":"lo"`)
+	assert.Contains(t, events[3], `"finish_reason":"stop"`)
+	assert.Contains(t, events[4], `"choices":[]`)
+	assert.Contains(t, events[4], `"prompt_tokens":7`)
+	assert.Equal(t, "data: [DONE]", events[5])
+	assert.NotZero(t, completionTokens)
+}
+
+func TestStreamHandlerConvertsCompletion(t *testing.T) {
+	upstream := "{\n  \"id\": \"1\",\n  \"object\": \"chat.completion\",\n  \"choices\": [{\"index\": 0, \"message\": {\"role\": \"assistant\", \"content\": \"Hi\"}, \"finish_reason\": \"stop\"}],\n  \"usage\": {\"prompt_tokens\": 3, \"completion_tokens\": 1, \"total_tokens\": 4}\n}\n"
+	body, completionTokens := relayStream(t, `{"model":"gpt-4o","stream":true}`, upstream)
+	events := strings.Split(strings.TrimSpace(body), "\n\n")
+	assert.Len(t, events, 2)
+	assert.Contains(t, events[0], `"object":"chat.completion.chunk"`)
+	assert.Contains(t, events[0], `"delta":{"content":"Hi","role":"assistant"}`)
+	assert.Equal(t, "data: [DONE]", events[1])
+	assert.Equal(t, 1, completionTokens)
+}
+
+type brokenReader struct {
+	io.Reader
+}
+
+func (r brokenReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func TestStreamHandlerBillsPartialStream(t *testing.T) {
+	testCases := []struct {
+		name             string
+		upstream         string
+		completionTokens int
+	}{
+		{
+			// the channel sends the usage of the whole completion up front, then fails
+			name:             "text",
+			upstream:         `data: {"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"Hi"}}],"usage":{"prompt_tokens":7,"completion_tokens":500,"total_tokens":507}}` + "\n",
+			completionTokens: 1,
+		},
+		{
+			// the channel fails in the middle of the arguments of a tool call
+			name: "tool call",
+			upstream: `data: {"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}` + "\n" +
+				`data: {"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\": \"Paris\", \"unit\": \"celsius\""}}]}}]}` + "\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			config.ApproximateTokenEnabled = true
+			gin.SetMode(gin.TestMode)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","stream":true}`))
+			resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(brokenReader{strings.NewReader(testCase.upstream)})}
+			err, _, usage := openai.StreamHandler(c, resp, relaymode.ChatCompletions, 7, "gpt-4o")
+			assert.Nil(t, err)
+			assert.True(t, c.GetBool(ctxkey.PartialCompletion))
+			if !assert.NotNil(t, usage) {
+				return
+			}
+			if testCase.completionTokens != 0 {
+				assert.Equal(t, testCase.completionTokens, usage.CompletionTokens)
+			} else {
+				assert.NotZero(t, usage.CompletionTokens)
+			}
+			assert.Equal(t, 7+usage.CompletionTokens, usage.TotalTokens)
+		})
+	}
+}
+
+func TestStreamHandlerCountsReasoning(t *testing.T) {
+	config.ApproximateTokenEnabled = true
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"deepseek-reasoner","stream":true}`))
+	upstream := `data: {"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"reasoning_content":"Let me think about it"}}]}` + "\n" +
+		`data: {"id":"1","object":"chat.completion.chunk","created":1,"model":"m","choices":[{"index":0,"delta":{"content":"Hi"}}]}` + "\n" +
+		"data: [DONE]\n"
+	resp := &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(upstream))}
+	err, responseText, usage := openai.StreamHandler(c, resp, relaymode.ChatCompletions, 7, "deepseek-reasoner")
+	assert.Nil(t, err)
+	assert.Equal(t, "Hi", responseText)
+	if assert.NotNil(t, usage) && assert.NotNil(t, usage.CompletionTokensDetails) {
+		reasoningTokens := usage.GetReasoningTokens()
+		assert.NotZero(t, reasoningTokens)
+		assert.Equal(t, reasoningTokens+1, usage.CompletionTokens)
+		assert.Equal(t, 7+usage.CompletionTokens, usage.TotalTokens)
+	}
+}
@@ -0,0 +1,232 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/constant"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Tool calls are normalized to the OpenAI shape whatever the channel: each call has an id,
+// the function type and its arguments as a JSON string, the calls of stream chunks carry
+// their index, and a choice with tool calls finishes with tool_calls.
+
+const ToolCallsFinishReason = "tool_calls"
+
+func NewToolCallId() string {
+	return "call_" + random.GetUUID()
+}
+
+// toolCallArguments returns the arguments of a tool call as a JSON string, an object given
+// by the channel is marshalled
+func toolCallArguments(arguments any) string {
+	switch v := arguments.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, err := json.Marshal(arguments)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// NormalizeToolCalls normalizes the tool calls of a complete message
+func NormalizeToolCalls(toolCalls []model.Tool) []model.Tool {
+	normalized := make([]model.Tool, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		if toolCall.Id == "" {
+			toolCall.Id = NewToolCallId()
+		}
+		toolCall.Type = "function"
+		toolCall.Index = nil
+		arguments := toolCallArguments(toolCall.Function.Arguments)
+		if arguments == "" {
+			arguments = "{}"
+		}
+		toolCall.Function.Arguments = arguments
+		normalized[i] = toolCall
+	}
+	return normalized
+}
+
+// NormalizeTextResponse normalizes the tool calls of the choices of a chat completion
+func NormalizeTextResponse(response *TextResponse) {
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		if len(choice.ToolCalls) == 0 {
+			continue
+		}
+		choice.ToolCalls = NormalizeToolCalls(choice.ToolCalls)
+		if choice.FinishReason == "" || choice.FinishReason == constant.StopFinishReason {
+			choice.FinishReason = ToolCallsFinishReason
+		}
+	}
+}
+
+type toolCallStream struct {
+	count   int // calls seen so far
+	last    int // index of the last call
+	ids     map[string]int
+	indexes map[int]int // index given by the channel to the index of the call
+}
+
+// ToolCallStreamNormalizer normalizes the tool calls of the chunks of a stream. A chunk
+// continues the call with the same id, or else with the same index given by the channel, or
+// else the last call, so that channels giving every call the same index, or no index at all,
+// still stream parallel calls apart.
+type ToolCallStreamNormalizer struct {
+	choices map[int]*toolCallStream
+}
+
+func NewToolCallStreamNormalizer() *ToolCallStreamNormalizer {
+	return &ToolCallStreamNormalizer{choices: make(map[int]*toolCallStream)}
+}
+
+func (n *ToolCallStreamNormalizer) stream(choiceIndex int) *toolCallStream {
+	stream, ok := n.choices[choiceIndex]
+	if !ok {
+		stream = &toolCallStream{last: -1, ids: make(map[string]int), indexes: make(map[int]int)}
+		n.choices[choiceIndex] = stream
+	}
+	return stream
+}
+
+// ToolCalls normalizes the tool calls of a chunk of the given choice
+func (n *ToolCallStreamNormalizer) ToolCalls(choiceIndex int, toolCalls []model.Tool) []model.Tool {
+	stream := n.stream(choiceIndex)
+	normalized := make([]model.Tool, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		index, ok := -1, false
+		if toolCall.Id != "" {
+			index, ok = stream.ids[toolCall.Id]
+		} else if toolCall.Index != nil {
+			index, ok = stream.indexes[*toolCall.Index]
+		} else if stream.last >= 0 {
+			index, ok = stream.last, true
+		}
+		if !ok {
+			index = stream.count
+			stream.count++
+			if toolCall.Id == "" {
+				toolCall.Id = NewToolCallId()
+			}
+			stream.ids[toolCall.Id] = index
+			toolCall.Type = "function"
+		}
+		if toolCall.Index != nil {
+			stream.indexes[*toolCall.Index] = index
+		}
+		stream.last = index
+		toolCall.Index = &index
+		toolCall.Function.Arguments = toolCallArguments(toolCall.Function.Arguments)
+		normalized[i] = toolCall
+	}
+	return normalized
+}
+
+// FinishReason returns the finish reason of the given choice, which is tool_calls when the
+// choice streamed tool calls and the channel says it stopped
+func (n *ToolCallStreamNormalizer) FinishReason(choiceIndex int, reason string) string {
+	if stream, ok := n.choices[choiceIndex]; ok && stream.count > 0 && reason == constant.StopFinishReason {
+		return ToolCallsFinishReason
+	}
+	return reason
+}
+
+// NormalizeResponse normalizes the tool calls of the choices of a chunk
+func (n *ToolCallStreamNormalizer) NormalizeResponse(response *ChatCompletionsStreamResponse) {
+	for i := range response.Choices {
+		choice := &response.Choices[i]
+		if len(choice.Delta.ToolCalls) > 0 {
+			choice.Delta.ToolCalls = n.ToolCalls(choice.Index, choice.Delta.ToolCalls)
+		}
+		if choice.FinishReason != nil {
+			finishReason := n.FinishReason(choice.Index, *choice.FinishReason)
+			choice.FinishReason = &finishReason
+		}
+	}
+}
+
+// normalizeChoices rewrites the tool calls and the finish reason of the choices of a chat
+// completion or a chunk given by an OpenAI compatible channel, keeping the fields it does
+// not know. It returns the data as is if there is nothing to rewrite.
+func normalizeChoices(data []byte, messageField string, normalize func(index int, toolCalls []model.Tool, finishReason string) ([]model.Tool, string)) []byte {
+	var response map[string]any
+	if err := json.Unmarshal(data, &response); err != nil {
+		return data
+	}
+	choices, _ := response["choices"].([]any)
+	changed := false
+	for _, choice := range choices {
+		choiceMap, ok := choice.(map[string]any)
+		if !ok {
+			continue
+		}
+		index, _ := choiceMap["index"].(float64)
+		message, _ := choiceMap[messageField].(map[string]any)
+		var toolCalls []model.Tool
+		if rawToolCalls, ok := message["tool_calls"]; ok && rawToolCalls != nil {
+			rawData, _ := json.Marshal(rawToolCalls)
+			if err := json.Unmarshal(rawData, &toolCalls); err != nil {
+				continue
+			}
+		}
+		finishReason, _ := choiceMap["finish_reason"].(string)
+		if len(toolCalls) == 0 && finishReason == "" {
+			continue
+		}
+		toolCalls, newFinishReason := normalize(int(index), toolCalls, finishReason)
+		if len(toolCalls) > 0 {
+			message["tool_calls"] = toolCalls
+			changed = true
+		}
+		if newFinishReason != finishReason {
+			choiceMap["finish_reason"] = newFinishReason
+			changed = true
+		}
+	}
+	if !changed {
+		return data
+	}
+	normalized, err := json.Marshal(response)
+	if err != nil {
+		return data
+	}
+	return normalized
+}
+
+// normalizeResponseBody normalizes the tool calls of a chat completion given by an OpenAI
+// compatible channel
+func normalizeResponseBody(body []byte) []byte {
+	if !bytes.Contains(body, []byte(`"tool_calls"`)) {
+		return body
+	}
+	return normalizeChoices(body, "message", func(index int, toolCalls []model.Tool, finishReason string) ([]model.Tool, string) {
+		if len(toolCalls) == 0 {
+			return nil, finishReason
+		}
+		if finishReason == "" || finishReason == constant.StopFinishReason {
+			finishReason = ToolCallsFinishReason
+		}
+		return NormalizeToolCalls(toolCalls), finishReason
+	})
+}
+
+// normalizeStreamData normalizes the tool calls of a chunk given by an OpenAI compatible
+// channel
+func (n *ToolCallStreamNormalizer) normalizeStreamData(data []byte) []byte {
+	if !bytes.Contains(data, []byte(`"tool_calls"`)) && (len(n.choices) == 0 || !bytes.Contains(data, []byte(`"finish_reason"`))) {
+		return data
+	}
+	return normalizeChoices(data, "delta", func(index int, toolCalls []model.Tool, finishReason string) ([]model.Tool, string) {
+		if len(toolCalls) > 0 {
+			toolCalls = n.ToolCalls(index, toolCalls)
+		}
+		return toolCalls, n.FinishReason(index, finishReason)
+	})
+}
@@ -0,0 +1,55 @@
+package openai_test
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestNormalizeToolCalls(t *testing.T) {
+	toolCalls := openai.NormalizeToolCalls([]relaymodel.Tool{
+		{Function: relaymodel.Function{Name: "get_weather", Arguments: map[string]any{"city": "Paris"}}},
+		{Id: "call_2", Function: relaymodel.Function{Name: "get_time"}, Index: intPtr(1)},
+	})
+	assert.Len(t, toolCalls, 2)
+	assert.NotEmpty(t, toolCalls[0].Id)
+	assert.Equal(t, "function", toolCalls[0].Type)
+	assert.Equal(t, `{"city":"Paris"}`, toolCalls[0].Function.Arguments)
+	assert.Equal(t, "call_2", toolCalls[1].Id)
+	assert.Equal(t, "{}", toolCalls[1].Function.Arguments)
+	assert.Nil(t, toolCalls[1].Index)
+}
+
+func TestToolCallStreamNormalizer(t *testing.T) {
+	normalizer := openai.NewToolCallStreamNormalizer()
+	// a channel giving both parallel calls the index 0
+	chunks := [][]relaymodel.Tool{
+		{{Id: "call_1", Index: intPtr(0), Function: relaymodel.Function{Name: "get_weather", Arguments: ""}}},
+		{{Index: intPtr(0), Function: relaymodel.Function{Arguments: `{"city":`}}},
+		{{Id: "call_2", Index: intPtr(0), Function: relaymodel.Function{Name: "get_time", Arguments: ""}}},
+		{{Index: intPtr(0), Function: relaymodel.Function{Arguments: `{}`}}},
+		{{Function: relaymodel.Function{Arguments: ``}}},
+	}
+	var indexes []int
+	for _, chunk := range chunks {
+		toolCalls := normalizer.ToolCalls(0, chunk)
+		indexes = append(indexes, *toolCalls[0].Index)
+	}
+	assert.Equal(t, []int{0, 0, 1, 1, 1}, indexes)
+	assert.Equal(t, openai.ToolCallsFinishReason, normalizer.FinishReason(0, "stop"))
+	assert.Equal(t, "length", normalizer.FinishReason(0, "length"))
+	assert.Equal(t, "stop", normalizer.FinishReason(1, "stop"))
+
+	// a channel giving no id nor index makes a single call
+	toolCalls := normalizer.ToolCalls(1, []relaymodel.Tool{{Function: relaymodel.Function{Name: "get_weather", Arguments: map[string]any{}}}})
+	assert.NotEmpty(t, toolCalls[0].Id)
+	assert.Equal(t, "function", toolCalls[0].Type)
+	assert.Equal(t, 0, *toolCalls[0].Index)
+	assert.Equal(t, "{}", toolCalls[0].Function.Arguments)
+}
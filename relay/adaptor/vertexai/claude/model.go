@@ -7,7 +7,7 @@ type Request struct {
 	AnthropicVersion string `json:"anthropic_version"`
 	// Model            string              `json:"model"`
 	Messages      []anthropic.Message `json:"messages"`
-	System        string              `json:"system,omitempty"`
+	System        any                 `json:"system,omitempty"`
 	MaxTokens     int                 `json:"max_tokens,omitempty"`
 	StopSequences []string            `json:"stop_sequences,omitempty"`
 	Stream        bool                `json:"stream,omitempty"`
@@ -16,4 +16,5 @@ type Request struct {
 	TopK          int                 `json:"top_k,omitempty"`
 	Tools         []anthropic.Tool    `json:"tools,omitempty"`
 	ToolChoice    any                 `json:"tool_choice,omitempty"`
+	Thinking      *anthropic.Thinking `json:"thinking,omitempty"`
 }
@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/songquanpeng/one-api/common/tokenizer"
 	"github.com/songquanpeng/one-api/relay/model"
 )
 
@@ -19,8 +20,6 @@ var (
 	japanesePattern = regexp.MustCompile(`[\x{3040}-\x{309f}\x{30a0}-\x{30ff}]`)
 	// Korean hangul
 	koreanPattern = regexp.MustCompile(`[\x{ac00}-\x{d7af}]`)
-	// CJK pattern for token estimation
-	cjkPattern = regexp.MustCompile(`[\x{4e00}-\x{9fff}\x{3040}-\x{30ff}\x{ac00}-\x{d7af}]`)
 )
 
 // RequestFeatures contains analyzed features of the request
@@ -63,8 +62,8 @@ func AnalyzeRequest(messages []model.Message) *RequestFeatures {
 	// Detect language
 	features.Language = detectLanguage(text)
 
-	// Estimate token count (rough: 4 chars per token for English, 2 for CJK)
-	features.TokenCount = estimateTokens(text)
+	// Count tokens with the default encoding, the model is not resolved yet
+	features.TokenCount = tokenizer.Count(text, "")
 
 	// Check if long context needed
 	features.IsLongContext = features.TokenCount > 30000
@@ -170,23 +169,6 @@ func hasCodeContent(text string) bool {
 	return false
 }
 
-// estimateTokens estimates token count from text
-func estimateTokens(text string) int {
-	// Rough estimation: ~4 chars per token for English
-	// ~2 chars per token for CJK languages
-	charCount := len(text)
-
-	// Check for CJK characters (use pre-compiled pattern)
-	cjkMatches := cjkPattern.FindAllString(text, -1)
-
-	if len(cjkMatches) > charCount/4 {
-		// Mostly CJK
-		return charCount / 2
-	}
-
-	return charCount / 4
-}
-
 // estimateComplexity estimates request complexity
 func estimateComplexity(text string, features *RequestFeatures) float64 {
 	complexity := 0.5
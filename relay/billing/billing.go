@@ -3,14 +3,34 @@ package billing
 import (
 	"context"
 	"fmt"
+	"math"
 
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/meta"
 )
 
-func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId int) {
+// UpstreamQuota estimates what the provider of the channel charges for a request, from the
+// tokens, or units, it is billed for before the model and group ratios: the list price of
+// the model discounted by the cost ratio of the channel
+func UpstreamQuota(meta *meta.Meta, modelName string, billedTokens float64) int64 {
+	costRatio := meta.Config.CostRatio
+	if costRatio == 0 {
+		costRatio = 1
+	}
+	return int64(math.Ceil(billedTokens * billingratio.GetUpstreamModelRatio(modelName, meta.ChannelType) * costRatio))
+}
+
+func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, meta *meta.Meta) {
+	SettleTPM(meta, 0)
 	if preConsumedQuota != 0 {
+		tokenId, journalId := meta.TokenId, meta.PreConsumeJournalId
 		go func(ctx context.Context) {
+			if !model.SettlePreConsumedQuota(journalId) {
+				// already returned by the reconciliation
+				return
+			}
 			// return pre-consumed quota
 			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
 			if err != nil {
@@ -20,7 +40,7 @@ func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId
 	}
 }
 
-func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
+func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, upstreamQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
 	// quotaDelta is remaining quota to be consumed
 	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
 	if err != nil {
@@ -41,6 +61,7 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 			ModelName:        modelName,
 			TokenName:        tokenName,
 			Quota:            int(totalQuota),
+			UpstreamQuota:    int(upstreamQuota),
 			Content:          logContent,
 		})
 		model.UpdateUserUsedQuotaAndRequestCount(userId, totalQuota)
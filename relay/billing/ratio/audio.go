@@ -0,0 +1,5 @@
+package ratio
+
+// AudioTokensPerMinute is what a minute of transcribed audio is billed as, the ratio of
+// whisper-1 is set so that 200 tokens cost its $0.006 per minute
+const AudioTokensPerMinute = 200
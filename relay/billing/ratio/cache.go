@@ -0,0 +1,66 @@
+package ratio
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var cacheRatioLock sync.RWMutex
+
+// CacheRatio prices the cached prompt tokens of a model relative to its prompt tokens
+var CacheRatio = map[string]float64{}
+
+// defaultCacheRatios are the cached input prices of the providers, by model prefix
+var defaultCacheRatios = []struct {
+	prefix string
+	ratio  float64
+}{
+	{"gpt-5", 0.1},
+	{"gpt-4.1", 0.25},
+	{"o3", 0.25},
+	{"o4", 0.25},
+	{"gpt-4o", 0.5},
+	{"o1", 0.5},
+	{"claude", 0.1},
+	{"gemini", 0.25},
+	{"deepseek", 0.1},
+}
+
+// DefaultCacheRatio is the ratio of the models which are neither set nor known, the
+// discount of OpenAI
+const DefaultCacheRatio = 0.5
+
+func CacheRatio2JSONString() string {
+	cacheRatioLock.RLock()
+	defer cacheRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(CacheRatio)
+	if err != nil {
+		logger.SysError("error marshalling cache ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCacheRatioByJSONString(jsonStr string) error {
+	cacheRatioLock.Lock()
+	defer cacheRatioLock.Unlock()
+	CacheRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &CacheRatio)
+}
+
+func GetCacheRatio(name string) float64 {
+	cacheRatioLock.RLock()
+	ratio, ok := CacheRatio[name]
+	cacheRatioLock.RUnlock()
+	if ok {
+		return ratio
+	}
+	for _, defaultRatio := range defaultCacheRatios {
+		if strings.HasPrefix(name, defaultRatio.prefix) {
+			return defaultRatio.ratio
+		}
+	}
+	return DefaultCacheRatio
+}
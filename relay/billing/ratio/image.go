@@ -11,6 +11,12 @@ var ImageSizeRatios = map[string]map[string]float64{
 		"1024x1792": 2,
 		"1792x1024": 2,
 	},
+	"gpt-image-1": {
+		"1024x1024": 1,
+		"1024x1536": 1,
+		"1536x1024": 1,
+		"auto":      1,
+	},
 	"ali-stable-diffusion-xl": {
 		"512x1024":  1,
 		"1024x768":  1,
@@ -40,6 +46,58 @@ var ImageSizeRatios = map[string]map[string]float64{
 	},
 }
 
+// ImageQualityRatios are the ratios of the models priced by quality and size, they replace
+// ImageSizeRatios for these models
+var ImageQualityRatios = map[string]map[string]map[string]float64{
+	"dall-e-3": {
+		"standard": {
+			"1024x1024": 1,
+			"1024x1792": 2,
+			"1792x1024": 2,
+		},
+		"hd": {
+			"1024x1024": 2,
+			"1024x1792": 3,
+			"1792x1024": 3,
+		},
+	},
+	"gpt-image-1": { // the auto quality and size are billed as the highest
+		"low": {
+			"1024x1024": 1.1,
+			"1024x1536": 1.6,
+			"1536x1024": 1.6,
+			"auto":      1.6,
+		},
+		"medium": {
+			"1024x1024": 4.2,
+			"1024x1536": 6.3,
+			"1536x1024": 6.3,
+			"auto":      6.3,
+		},
+		"high": {
+			"1024x1024": 16.7,
+			"1024x1536": 25,
+			"1536x1024": 25,
+			"auto":      25,
+		},
+		"auto": {
+			"1024x1024": 16.7,
+			"1024x1536": 25,
+			"1536x1024": 25,
+			"auto":      25,
+		},
+	},
+}
+
+var ImageDefaultQualities = map[string]string{
+	"dall-e-3":    "standard",
+	"gpt-image-1": "auto",
+}
+
+var ImageDefaultSizes = map[string]string{
+	"gpt-image-1": "auto",
+}
+
 var ImageGenerationAmounts = map[string][2]int{
 	"dall-e-2":                  {1, 10},
 	"dall-e-3":                  {1, 1}, // OpenAI allows n=1 currently.
@@ -48,6 +106,7 @@ var ImageGenerationAmounts = map[string][2]int{
 	"wanx-v1":                   {1, 4}, // Ali
 	"cogview-3":                 {1, 1},
 	"step-1x-medium":            {1, 1},
+	"gpt-image-1":               {1, 10},
 }
 
 var ImagePromptLengthLimitations = map[string]int{
@@ -58,6 +117,7 @@ var ImagePromptLengthLimitations = map[string]int{
 	"wanx-v1":                   4000,
 	"cogview-3":                 833,
 	"step-1x-medium":            4000,
+	"gpt-image-1":               32000,
 }
 
 var ImageOriginModelName = map[string]string{
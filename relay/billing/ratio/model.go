@@ -87,6 +87,7 @@ var ModelRatio = map[string]float64{
 	"text-moderation-latest":  0.1,
 	"dall-e-2":                0.02 * USD, // $0.016 - $0.020 / image
 	"dall-e-3":                0.04 * USD, // $0.040 - $0.120 / image
+	"gpt-image-1":             0.01 * USD, // $0.011 - $0.250 / image, see ImageQualityRatios
 	// https://docs.anthropic.com/en/docs/about-claude/models
 	"claude-instant-1.2":         0.8 / 1000 * USD,
 	"claude-2.0":                 8.0 / 1000 * USD,
@@ -341,6 +342,9 @@ var ModelRatio = map[string]float64{
 	"deepl-zh": 25.0 / 1000 * USD,
 	"deepl-en": 25.0 / 1000 * USD,
 	"deepl-ja": 25.0 / 1000 * USD,
+	// the mock channels, priced as small models so the load tests exercise the billing
+	"mock-chat":      0.5 * MILLI_USD,
+	"mock-embedding": 0.02 * MILLI_USD,
 	// https://console.x.ai/
 	"grok-beta": 5.0 / 1000 * USD,
 	// replicate charges based on the number of generated images
@@ -709,6 +713,22 @@ func GetModelRatio(name string, channelType int) float64 {
 	return 30
 }
 
+// HasModelRatio tells whether a model has a ratio, the others are billed at the default one
+func HasModelRatio(name string, channelType int) bool {
+	modelRatioLock.RLock()
+	defer modelRatioLock.RUnlock()
+	model := fmt.Sprintf("%s(%d)", name, channelType)
+	for _, key := range []string{model, name} {
+		if _, ok := ModelRatio[key]; ok {
+			return true
+		}
+		if _, ok := DefaultModelRatio[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
 func CompletionRatio2JSONString() string {
 	jsonBytes, err := json.Marshal(CompletionRatio)
 	if err != nil {
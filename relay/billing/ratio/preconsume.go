@@ -0,0 +1,93 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Strategies of the quota charged before a request is relayed, the difference with the quota
+// of its usage is charged or returned once the request is done
+const (
+	PreConsumeNone         = "none"          // nothing, a positive balance is enough
+	PreConsumePromptOnly   = "prompt-only"   // the prompt tokens
+	PreConsumePercentage   = "percentage"    // a percentage of the full estimate
+	PreConsumeFullEstimate = "full-estimate" // the prompt tokens, max_tokens and PreConsumedQuota
+)
+
+// PreConsumeStrategy is how the quota of the requests of a group is pre-consumed
+type PreConsumeStrategy struct {
+	Strategy   string  `json:"strategy"`
+	Percentage float64 `json:"percentage,omitempty"` // of the full estimate, for the percentage strategy
+}
+
+func (s PreConsumeStrategy) Validate() error {
+	switch s.Strategy {
+	case PreConsumeNone, PreConsumePromptOnly, PreConsumeFullEstimate:
+		return nil
+	case PreConsumePercentage:
+		if s.Percentage <= 0 || s.Percentage > 100 {
+			return fmt.Errorf("percentage must be between 0 and 100")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown pre-consume strategy %s", s.Strategy)
+	}
+}
+
+var groupPreConsumeStrategyLock sync.RWMutex
+
+// GroupPreConsumeStrategy is the strategy of each group, the groups not in it use full-estimate
+var GroupPreConsumeStrategy = map[string]PreConsumeStrategy{}
+
+func GroupPreConsumeStrategy2JSONString() string {
+	groupPreConsumeStrategyLock.RLock()
+	defer groupPreConsumeStrategyLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupPreConsumeStrategy)
+	if err != nil {
+		logger.SysError("error marshalling group pre-consume strategy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func parseGroupPreConsumeStrategy(jsonStr string) (map[string]PreConsumeStrategy, error) {
+	strategies := make(map[string]PreConsumeStrategy)
+	if err := json.Unmarshal([]byte(jsonStr), &strategies); err != nil {
+		return nil, err
+	}
+	for group, strategy := range strategies {
+		if err := strategy.Validate(); err != nil {
+			return nil, fmt.Errorf("group %s: %s", group, err.Error())
+		}
+	}
+	return strategies, nil
+}
+
+// ValidateGroupPreConsumeStrategy checks the JSON of GroupPreConsumeStrategy
+func ValidateGroupPreConsumeStrategy(jsonStr string) error {
+	_, err := parseGroupPreConsumeStrategy(jsonStr)
+	return err
+}
+
+func UpdateGroupPreConsumeStrategyByJSONString(jsonStr string) error {
+	strategies, err := parseGroupPreConsumeStrategy(jsonStr)
+	if err != nil {
+		return err
+	}
+	groupPreConsumeStrategyLock.Lock()
+	GroupPreConsumeStrategy = strategies
+	groupPreConsumeStrategyLock.Unlock()
+	return nil
+}
+
+func GetPreConsumeStrategy(group string) PreConsumeStrategy {
+	groupPreConsumeStrategyLock.RLock()
+	defer groupPreConsumeStrategyLock.RUnlock()
+	strategy, ok := GroupPreConsumeStrategy[group]
+	if !ok {
+		return PreConsumeStrategy{Strategy: PreConsumeFullEstimate}
+	}
+	return strategy
+}
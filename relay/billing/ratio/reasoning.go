@@ -0,0 +1,40 @@
+package ratio
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var reasoningRatioLock sync.RWMutex
+
+// ReasoningRatio prices the reasoning tokens of a model relative to its completion tokens,
+// models which are not listed bill them as completion tokens
+var ReasoningRatio = map[string]float64{}
+
+func ReasoningRatio2JSONString() string {
+	reasoningRatioLock.RLock()
+	defer reasoningRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(ReasoningRatio)
+	if err != nil {
+		logger.SysError("error marshalling reasoning ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateReasoningRatioByJSONString(jsonStr string) error {
+	reasoningRatioLock.Lock()
+	defer reasoningRatioLock.Unlock()
+	ReasoningRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &ReasoningRatio)
+}
+
+func GetReasoningRatio(name string) float64 {
+	reasoningRatioLock.RLock()
+	defer reasoningRatioLock.RUnlock()
+	if ratio, ok := ReasoningRatio[name]; ok {
+		return ratio
+	}
+	return 1
+}
@@ -0,0 +1,53 @@
+package ratio
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+var upstreamModelRatioLock sync.RWMutex
+
+// UpstreamModelRatio is the list price of the providers for the models, as model ratios, to
+// estimate what the channels cost. The models which are not listed cost their default model
+// ratio, the list price One API ships with, then their model ratio.
+var UpstreamModelRatio = map[string]float64{}
+
+func UpstreamModelRatio2JSONString() string {
+	upstreamModelRatioLock.RLock()
+	defer upstreamModelRatioLock.RUnlock()
+	jsonBytes, err := json.Marshal(UpstreamModelRatio)
+	if err != nil {
+		logger.SysError("error marshalling upstream model ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateUpstreamModelRatioByJSONString(jsonStr string) error {
+	upstreamModelRatioLock.Lock()
+	defer upstreamModelRatioLock.Unlock()
+	UpstreamModelRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &UpstreamModelRatio)
+}
+
+func GetUpstreamModelRatio(name string, channelType int) float64 {
+	model := fmt.Sprintf("%s(%d)", name, channelType)
+	upstreamModelRatioLock.RLock()
+	ratio, ok := UpstreamModelRatio[model]
+	if !ok {
+		ratio, ok = UpstreamModelRatio[name]
+	}
+	upstreamModelRatioLock.RUnlock()
+	if ok {
+		return ratio
+	}
+	if ratio, ok := DefaultModelRatio[model]; ok {
+		return ratio
+	}
+	if ratio, ok := DefaultModelRatio[name]; ok {
+		return ratio
+	}
+	return GetModelRatio(name, channelType)
+}
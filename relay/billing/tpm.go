@@ -0,0 +1,64 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+type tpmBucket struct {
+	key   string
+	limit int
+}
+
+func tpmBuckets(meta *meta.Meta) []tpmBucket {
+	var buckets []tpmBucket
+	if config.RelayTokenTPMLimit > 0 && meta.TokenId != 0 {
+		buckets = append(buckets, tpmBucket{key: fmt.Sprintf("token:%d", meta.TokenId), limit: config.RelayTokenTPMLimit})
+	}
+	if config.RelayUserTPMLimit > 0 && meta.UserId != 0 {
+		buckets = append(buckets, tpmBucket{key: fmt.Sprintf("user:%d", meta.UserId), limit: config.RelayUserTPMLimit})
+	}
+	return buckets
+}
+
+// DebitTPM admits a request under the TPM limits of its token and user and debits its prompt
+// tokens, it returns false and the wait when the balance of one of them is used up. The bucket
+// with the lowest balance is kept in meta for the rate limit headers.
+func DebitTPM(ctx context.Context, meta *meta.Meta, promptTokens int) (bool, time.Duration) {
+	buckets := tpmBuckets(meta)
+	meta.TPMLimit = 0
+	for i, bucket := range buckets {
+		debited, balance, retryAfter := common.TPMDebit(ctx, bucket.key, bucket.limit, promptTokens, true)
+		if meta.TPMLimit == 0 || balance < meta.TPMBalance {
+			meta.TPMLimit, meta.TPMBalance = bucket.limit, balance
+		}
+		if !debited {
+			// give back the prompt tokens debited from the other buckets
+			for _, debitedBucket := range buckets[:i] {
+				common.TPMDebit(ctx, debitedBucket.key, debitedBucket.limit, -promptTokens, false)
+			}
+			return false, retryAfter
+		}
+	}
+	meta.TPMEstimate = promptTokens
+	return true, 0
+}
+
+// SettleTPM debits the difference between the tokens used by a request and its prompt tokens
+// debited on admission, the balance goes negative when the usage is over it
+func SettleTPM(meta *meta.Meta, usedTokens int) {
+	delta := usedTokens - meta.TPMEstimate
+	if delta == 0 {
+		return
+	}
+	// the request context is done once the response is sent
+	ctx := context.Background()
+	for _, bucket := range tpmBuckets(meta) {
+		common.TPMDebit(ctx, bucket.key, bucket.limit, delta, false)
+	}
+}
@@ -0,0 +1,225 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// Identical requests relayed at the same time on a node are coalesced: the first one goes
+// upstream and the others, which wait for it, are sent what it writes, as it is written. The
+// requests are identical when their cache keys are, every parameter included.
+
+// Flight is a request relayed upstream whose response is shared with the identical requests
+// arriving before it is done
+type Flight struct {
+	key     string
+	started time.Time
+
+	lock    sync.Mutex
+	header  http.Header // of the first write
+	status  int
+	data    []byte
+	done    bool
+	failed  bool
+	updated chan struct{} // closed on every write and when done
+	waiters int
+}
+
+var (
+	flights     = make(map[string]*Flight)
+	flightsLock sync.Mutex
+)
+
+// flightMetrics counts the requests coalesced since the start of the node
+var flightMetrics struct {
+	leaders   int64
+	coalesced int64
+	fallbacks int64 // followers relayed on their own after their leader failed
+}
+
+// FlightKey is the cache key of a request of a relay mode, with every parameter instead of the
+// model and messages alone
+func FlightKey(tenantId int, mode int, request *relaymodel.GeneralOpenAIRequest) string {
+	data, _ := json.Marshal(request)
+	hash := sha256.Sum256(data)
+	return common.TenantKey(tenantId, fmt.Sprintf("llm:flight:%d:%x", mode, hash))
+}
+
+// JoinFlight returns the flight of the request with this key, true when there was none and
+// the caller is to relay the request and Finish the flight
+func JoinFlight(key string) (*Flight, bool) {
+	flightsLock.Lock()
+	defer flightsLock.Unlock()
+	if flight, ok := flights[key]; ok {
+		flight.lock.Lock()
+		flight.waiters++
+		flight.lock.Unlock()
+		return flight, false
+	}
+	flight := &Flight{key: key, started: time.Now(), updated: make(chan struct{})}
+	flights[key] = flight
+	atomic.AddInt64(&flightMetrics.leaders, 1)
+	return flight, true
+}
+
+// Finish ends the flight, the requests arriving from then on are relayed anew. A failed flight
+// which wrote nothing lets its followers relay their request on their own.
+func (f *Flight) Finish(succeeded bool) {
+	flightsLock.Lock()
+	if flights[f.key] == f {
+		delete(flights, f.key)
+	}
+	flightsLock.Unlock()
+	f.lock.Lock()
+	f.done = true
+	f.failed = !succeeded
+	close(f.updated)
+	f.lock.Unlock()
+}
+
+func (f *Flight) write(header http.Header, status int, data []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.header == nil {
+		f.header = header.Clone()
+		f.status = status
+	}
+	f.data = append(f.data, data...)
+	close(f.updated)
+	f.updated = make(chan struct{})
+}
+
+// next waits for the data written after offset, it returns false once the flight is done and
+// every byte was read
+func (f *Flight) next(ctx context.Context, offset int) ([]byte, bool, error) {
+	for {
+		f.lock.Lock()
+		if len(f.data) > offset {
+			data := f.data[offset:]
+			f.lock.Unlock()
+			return data, true, nil
+		}
+		if f.done {
+			f.lock.Unlock()
+			return nil, false, nil
+		}
+		updated := f.updated
+		f.lock.Unlock()
+		select {
+		case <-updated:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// Follow sends the response of the flight to a waiting request as it is written. It returns
+// false without writing anything when the flight failed before writing, the request is then
+// to be relayed on its own.
+func (f *Flight) Follow(c *gin.Context) (bool, error) {
+	defer func() {
+		f.lock.Lock()
+		f.waiters--
+		f.lock.Unlock()
+	}()
+	ctx := c.Request.Context()
+	data, ok, err := f.next(ctx, 0)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		atomic.AddInt64(&flightMetrics.fallbacks, 1)
+		return false, nil
+	}
+	f.lock.Lock()
+	header, status := f.header, f.status
+	f.lock.Unlock()
+	for _, name := range []string{"Content-Type", "Cache-Control"} {
+		if value := header.Get(name); value != "" {
+			c.Writer.Header().Set(name, value)
+		}
+	}
+	c.Header("X-Coalesced", "true")
+	c.Status(status)
+	atomic.AddInt64(&flightMetrics.coalesced, 1)
+	offset := 0
+	for ok {
+		if _, err = c.Writer.Write(data); err != nil {
+			return true, err
+		}
+		c.Writer.Flush()
+		offset += len(data)
+		if data, ok, err = f.next(ctx, offset); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// Writer returns a writer sending the response of the request relayed by the flight to its
+// client and to the followers
+func (f *Flight) Writer(w gin.ResponseWriter) *FlightWriter {
+	return &FlightWriter{ResponseWriter: w, flight: f}
+}
+
+// FlightWriter shares with the followers of a flight what the relay writes to its client
+type FlightWriter struct {
+	gin.ResponseWriter
+	flight *Flight
+}
+
+func (w *FlightWriter) Write(data []byte) (int, error) {
+	w.flight.write(w.Header(), w.Status(), data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *FlightWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// FlightStats is a flight in progress
+type FlightStats struct {
+	Key     string `json:"key"`
+	Waiters int    `json:"waiters"`
+	Age     int64  `json:"age"` // unit is millisecond
+	Bytes   int    `json:"bytes"`
+}
+
+// GetFlightStats returns the counts of the coalesced requests and the flights in progress, the
+// most waited for first
+func GetFlightStats() map[string]any {
+	flightsLock.Lock()
+	inProgress := make([]FlightStats, 0, len(flights))
+	for key, flight := range flights {
+		flight.lock.Lock()
+		inProgress = append(inProgress, FlightStats{
+			Key:     key,
+			Waiters: flight.waiters,
+			Age:     time.Since(flight.started).Milliseconds(),
+			Bytes:   len(flight.data),
+		})
+		flight.lock.Unlock()
+	}
+	flightsLock.Unlock()
+	sort.Slice(inProgress, func(i, j int) bool {
+		return inProgress[i].Waiters > inProgress[j].Waiters
+	})
+	return map[string]any{
+		"leaders":   atomic.LoadInt64(&flightMetrics.leaders),
+		"coalesced": atomic.LoadInt64(&flightMetrics.coalesced),
+		"fallbacks": atomic.LoadInt64(&flightMetrics.fallbacks),
+		"flights":   inProgress,
+	}
+}
@@ -13,11 +13,9 @@ import (
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 )
 
-// ResponseCache manages LLM response caching
-type ResponseCache struct {
-	enabled bool
-	ttl     time.Duration
-}
+// ResponseCache manages LLM response caching, it follows the ResponseCacheEnabled and
+// ResponseCacheTTL settings
+type ResponseCache struct{}
 
 // CachedResponse represents a cached LLM response
 type CachedResponse struct {
@@ -33,10 +31,7 @@ var cacheOnce sync.Once
 // InitResponseCache initializes the global response cache
 func InitResponseCache() {
 	cacheOnce.Do(func() {
-		globalCache = &ResponseCache{
-			enabled: config.ResponseCacheEnabled,
-			ttl:     time.Duration(config.ResponseCacheTTL) * time.Second,
-		}
+		globalCache = &ResponseCache{}
 		logger.SysLog("Response cache initialized")
 	})
 }
@@ -49,19 +44,19 @@ func GetCache() *ResponseCache {
 	return globalCache
 }
 
-// CheckCache looks for exact match in cache
+// CheckCache looks for exact match in the cache of the tenant
 // Returns cached content and true if found, empty string and false otherwise
 func (rc *ResponseCache) CheckCache(
+	tenantId int,
 	model string,
 	messages []relaymodel.Message,
 ) (string, bool) {
 	// Nil check for safety
-	if rc == nil || !rc.enabled || !common.RedisEnabled {
+	if rc == nil || !config.ResponseCacheEnabled || !common.RedisEnabled {
 		return "", false
 	}
 
-	key := rc.generateKey(model, messages)
-	data, err := common.RedisGet("llm:cache:exact:" + key)
+	data, err := common.RedisGet(rc.exactKey(tenantId, model, messages))
 
 	if err != nil {
 		// Redis error - don't record as miss (transient issue)
@@ -87,20 +82,19 @@ func (rc *ResponseCache) CheckCache(
 	return cached.Content, true
 }
 
-// StoreCache stores successful response in cache
+// StoreCache stores successful response in the cache of the tenant
 func (rc *ResponseCache) StoreCache(
+	tenantId int,
 	model string,
 	messages []relaymodel.Message,
 	responseContent string,
 	tokensUsed int,
 ) error {
 	// Nil check for safety
-	if rc == nil || !rc.enabled || !common.RedisEnabled {
+	if rc == nil || !config.ResponseCacheEnabled || !common.RedisEnabled {
 		return nil
 	}
 
-	key := rc.generateKey(model, messages)
-
 	cached := CachedResponse{
 		Content:    responseContent,
 		Model:      model,
@@ -114,14 +108,15 @@ func (rc *ResponseCache) StoreCache(
 	}
 
 	return common.RedisSet(
-		"llm:cache:exact:"+key,
+		rc.exactKey(tenantId, model, messages),
 		string(data),
-		rc.ttl,
+		time.Duration(config.ResponseCacheTTL)*time.Second,
 	)
 }
 
 // InvalidateCache removes a specific cache entry
 func (rc *ResponseCache) InvalidateCache(
+	tenantId int,
 	model string,
 	messages []relaymodel.Message,
 ) error {
@@ -129,8 +124,16 @@ func (rc *ResponseCache) InvalidateCache(
 		return nil
 	}
 
-	key := rc.generateKey(model, messages)
-	return common.RedisDel("llm:cache:exact:" + key)
+	return common.RedisDel(rc.exactKey(tenantId, model, messages))
+}
+
+// exactKey is the Redis key of a request, the requests of the tenants are cached apart
+func (rc *ResponseCache) exactKey(
+	tenantId int,
+	model string,
+	messages []relaymodel.Message,
+) string {
+	return common.TenantKey(tenantId, "llm:cache:exact:"+rc.generateKey(model, messages))
 }
 
 // generateKey creates a unique hash for the request
@@ -150,5 +153,5 @@ func (rc *ResponseCache) generateKey(
 
 // IsEnabled returns whether caching is enabled
 func (rc *ResponseCache) IsEnabled() bool {
-	return rc.enabled
+	return config.ResponseCacheEnabled
 }
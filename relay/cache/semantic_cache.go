@@ -18,9 +18,8 @@ import (
 
 // SemanticCache implements vector-based similarity caching
 // Uses local text hashing for embeddings (no external API needed)
+// It follows the SemanticCacheEnabled and SemanticCacheThreshold settings
 type SemanticCache struct {
-	enabled   bool
-	threshold float64 // Similarity threshold (0.0-1.0)
 	maxSize   int     // Maximum cache entries
 	
 	// In-memory vector store
@@ -37,6 +36,7 @@ type VectorEntry struct {
 	Tokens    int       `json:"tokens"`
 	Created   int64     `json:"created"`
 	HitCount  int       `json:"hit_count"`
+	Tenant    int       `json:"tenant,omitempty"` // only matched by the requests of its tenant
 }
 
 var globalSemanticCache *SemanticCache
@@ -46,8 +46,6 @@ var semanticOnce sync.Once
 func InitSemanticCache() {
 	semanticOnce.Do(func() {
 		globalSemanticCache = &SemanticCache{
-			enabled:   config.SemanticCacheEnabled,
-			threshold: config.SemanticCacheThreshold,
 			maxSize:   config.SemanticCacheMaxSize,
 			vectors:   make(map[string]*VectorEntry),
 		}
@@ -58,7 +56,7 @@ func InitSemanticCache() {
 		}
 		
 		logger.SysLog(fmt.Sprintf("Semantic cache initialized (threshold: %.2f, max_size: %d)", 
-			globalSemanticCache.threshold, globalSemanticCache.maxSize))
+			config.SemanticCacheThreshold, globalSemanticCache.maxSize))
 	})
 }
 
@@ -70,13 +68,14 @@ func GetSemanticCache() *SemanticCache {
 	return globalSemanticCache
 }
 
-// CheckSemantic looks for semantically similar cached responses
+// CheckSemantic looks for semantically similar cached responses of the tenant
 // Returns (cached_response, similarity_score, found)
 func (sc *SemanticCache) CheckSemantic(
+	tenantId int,
 	model string,
 	messages []relaymodel.Message,
 ) (string, float64, bool) {
-	if sc == nil || !sc.enabled {
+	if sc == nil || !config.SemanticCacheEnabled {
 		return "", 0, false
 	}
 	
@@ -97,6 +96,9 @@ func (sc *SemanticCache) CheckSemantic(
 	var bestScore float64
 	
 	for _, entry := range sc.vectors {
+		if entry.Tenant != tenantId {
+			continue
+		}
 		// Only match same model family (gpt-4 can use gpt-4o cache, etc)
 		if !isSameModelFamily(model, entry.Model) {
 			continue
@@ -110,7 +112,7 @@ func (sc *SemanticCache) CheckSemantic(
 	}
 	
 	// Check if similarity exceeds threshold
-	if bestScore >= sc.threshold && bestMatch != nil {
+	if bestScore >= config.SemanticCacheThreshold && bestMatch != nil {
 		// Record metrics (thread-safe)
 		CacheMetrics.RecordHit()
 		CacheMetrics.AddTokensSaved(bestMatch.Tokens)
@@ -122,7 +124,7 @@ func (sc *SemanticCache) CheckSemantic(
 				entry.HitCount++
 			}
 			sc.mu.Unlock()
-		}(sc.findKeyByVector(bestMatch.Vector))
+		}(common.TenantKey(tenantId, sc.findKeyByVector(bestMatch.Vector)))
 		
 		logger.SysLog(fmt.Sprintf("[SEMANTIC HIT] score=%.3f query='%s'", 
 			bestScore, truncateUnicode(query, 50)))
@@ -135,12 +137,13 @@ func (sc *SemanticCache) CheckSemantic(
 
 // StoreSemantic stores a response with its semantic embedding
 func (sc *SemanticCache) StoreSemantic(
+	tenantId int,
 	model string,
 	messages []relaymodel.Message,
 	response string,
 	tokens int,
 ) error {
-	if sc == nil || !sc.enabled {
+	if sc == nil || !config.SemanticCacheEnabled {
 		return nil
 	}
 	
@@ -152,8 +155,8 @@ func (sc *SemanticCache) StoreSemantic(
 	// Generate embedding
 	vector := sc.generateEmbedding(query)
 	
-	// Create cache key from vector hash
-	key := sc.vectorKey(vector)
+	// Create cache key from vector hash, the same query of another tenant has its own entry
+	key := common.TenantKey(tenantId, sc.vectorKey(vector))
 	
 	sc.mu.Lock()
 	defer sc.mu.Unlock()
@@ -172,6 +175,7 @@ func (sc *SemanticCache) StoreSemantic(
 		Tokens:   tokens,
 		Created:  time.Now().Unix(),
 		HitCount: 0,
+		Tenant:   tenantId,
 	}
 	
 	// Persist to Redis asynchronously (copy entry to avoid race)
@@ -343,8 +347,8 @@ func (sc *SemanticCache) GetStats() map[string]interface{} {
 	}
 	
 	return map[string]interface{}{
-		"enabled":   sc.enabled,
-		"threshold": sc.threshold,
+		"enabled":   config.SemanticCacheEnabled,
+		"threshold": config.SemanticCacheThreshold,
 		"entries":   len(sc.vectors),
 		"max_size":  sc.maxSize,
 		"total_hits": totalHits,
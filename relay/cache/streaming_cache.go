@@ -9,6 +9,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/tokenizer"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 )
 
@@ -23,6 +24,7 @@ type StreamingCache struct {
 func CaptureAndCacheStream(
 	c *gin.Context,
 	resp *http.Response,
+	tenantId int,
 	model string,
 	messages []relaymodel.Message,
 ) (string, int, error) {
@@ -79,15 +81,18 @@ func CaptureAndCacheStream(
 	// Store complete stream in cache
 	fullStream := buffer.String()
 	
-	// Estimate tokens if not provided (approximate)
+	// Count tokens if not provided
 	if totalTokens == 0 {
-		totalTokens = len(strings.Split(fullStream, " ")) / 2
+		totalTokens = tokenizer.Count(ExtractContentFromStream(fullStream), model)
+		for _, message := range messages {
+			totalTokens += tokenizer.Count(message.StringContent(), model)
+		}
 	}
 	
 	// Cache asynchronously to avoid blocking
 	go func() {
 		cache := GetCache()
-		if err := cache.StoreCache(model, messages, fullStream, totalTokens); err != nil {
+		if err := cache.StoreCache(tenantId, model, messages, fullStream, totalTokens); err != nil {
 			logger.SysError("Failed to cache streaming response: " + err.Error())
 		}
 	}()
@@ -1,6 +1,9 @@
 package channeltype
 
-import "github.com/songquanpeng/one-api/relay/apitype"
+import (
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
 
 func ToAPIType(channelType int) int {
 	apiType := apitype.OpenAI
@@ -41,7 +44,41 @@ func ToAPIType(channelType int) int {
 		apiType = apitype.Replicate
 	case Proxy:
 		apiType = apitype.Proxy
+	case Mock:
+		apiType = apitype.Mock
 	}
 
 	return apiType
 }
+
+// SupportsImageEdits tells whether the channels of the type serve the OpenAI image edits endpoint
+func SupportsImageEdits(channelType int) bool {
+	switch channelType {
+	case OpenAI, API2D, Azure, CloseAI, OpenAISB, OpenAIMax, OhMyGPT, Custom, AIProxy, API2GPT,
+		AIGC2D, OpenAICompatible:
+		return true
+	}
+	return false
+}
+
+// ModeFilter returns what channel types serve the relay mode, nil when all the channels
+// serving the model do
+func ModeFilter(relayMode int) func(channelType int) bool {
+	switch {
+	case relaymode.IsAudio(relayMode):
+		return SupportsAudio
+	case relayMode == relaymode.ImagesEdits:
+		return SupportsImageEdits
+	}
+	return nil
+}
+
+// SupportsAudio tells whether the channels of the type serve the OpenAI audio endpoints
+func SupportsAudio(channelType int) bool {
+	switch channelType {
+	case OpenAI, API2D, Azure, CloseAI, OpenAISB, OpenAIMax, OhMyGPT, Custom, AIProxy, API2GPT,
+		AIGC2D, Groq, SiliconFlow, OpenAICompatible:
+		return true
+	}
+	return false
+}
@@ -2,5 +2,7 @@ package role
 
 const (
 	System    = "system"
+	Developer = "developer"
+	User      = "user"
 	Assistant = "assistant"
 )
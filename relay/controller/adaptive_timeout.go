@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/trace"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+// doRequest sends a request upstream and records how long the channel took to answer for the
+// model. With the adaptive timeouts, a channel which does not answer within the timeout of the
+// model is given up on, and the request is retried on another channel like on any other error.
+func doRequest(c *gin.Context, meta *meta.Meta, adaptor adaptor.Adaptor, requestBody io.Reader) (*http.Response, error) {
+	tracker := dbmodel.GetHealthTracker()
+	timeout := dbmodel.AdaptiveResponseTimeout(meta.ChannelId, meta.ActualModelName)
+	start := time.Now()
+	if timeout <= 0 {
+		resp, err := sendWithChaos(c, meta, adaptor, requestBody)
+		traceUpstream(c, meta, resp, err, time.Since(start), 0)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			tracker.RecordResponseLatency(meta.ChannelId, meta.ActualModelName, time.Since(start))
+		}
+		return resp, err
+	}
+	request := c.Request
+	ctx, cancel := context.WithCancel(request.Context())
+	timer := time.AfterFunc(timeout, cancel)
+	c.Request = request.WithContext(ctx)
+	resp, err := sendWithChaos(c, meta, adaptor, requestBody)
+	c.Request = request
+	if !timer.Stop() {
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		cancel()
+		// the timeout is recorded as the latency, so the timeout grows when the model slows down
+		tracker.RecordResponseLatency(meta.ChannelId, meta.ActualModelName, timeout)
+		logger.Warnf(request.Context(), "channel #%d did not answer within the adaptive timeout of %s for model %s", meta.ChannelId, timeout, meta.ActualModelName)
+		err = fmt.Errorf("no response within the adaptive timeout of %s", timeout)
+		traceUpstream(c, meta, nil, err, time.Since(start), timeout)
+		return nil, err
+	}
+	traceUpstream(c, meta, resp, err, time.Since(start), timeout)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode < http.StatusInternalServerError {
+		tracker.RecordResponseLatency(meta.ChannelId, meta.ActualModelName, time.Since(start))
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// traceUpstream records an upstream call in the trace of the request: the time until the
+// response headers and the status, or the error
+func traceUpstream(c *gin.Context, meta *meta.Meta, resp *http.Response, err error, latency time.Duration, timeout time.Duration) {
+	ctx := c.Request.Context()
+	if trace.FromContext(ctx) == nil {
+		return
+	}
+	data := map[string]any{
+		"model":   meta.ActualModelName,
+		"latency": latency.Milliseconds(),
+	}
+	if timeout > 0 {
+		data["adaptive_timeout"] = timeout.Milliseconds()
+	}
+	if err != nil {
+		data["error"] = err.Error()
+		trace.Record(ctx, trace.StageUpstream, meta.ChannelId, fmt.Sprintf("channel #%d failed after %dms", meta.ChannelId, latency.Milliseconds()), data)
+		return
+	}
+	data["status"] = resp.StatusCode
+	trace.Record(ctx, trace.StageUpstream, meta.ChannelId, fmt.Sprintf("channel #%d answered %d in %dms", meta.ChannelId, resp.StatusCode, latency.Milliseconds()), data)
+}
+
+// cancelOnClose releases the context of an upstream request once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
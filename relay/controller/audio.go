@@ -4,20 +4,25 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
-	"github.com/songquanpeng/one-api/common/client"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
@@ -30,16 +35,26 @@ import (
 func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatusCode {
 	ctx := c.Request.Context()
 	meta := meta.GetByContext(c)
-	audioModel := "whisper-1"
+	audioModel := meta.OriginModelName
+	if audioModel == "" {
+		audioModel = "whisper-1"
+	}
 
 	tokenId := c.GetInt(ctxkey.TokenId)
 	channelType := c.GetInt(ctxkey.Channel)
 	channelId := c.GetInt(ctxkey.ChannelId)
 	userId := c.GetInt(ctxkey.Id)
+	organizationId := c.GetInt(ctxkey.OrganizationId)
 	group := c.GetString(ctxkey.Group)
 	tokenName := c.GetString(ctxkey.TokenName)
 
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	contentType := c.Request.Header.Get("Content-Type")
 	var ttsRequest openai.TextToSpeechRequest
+	var form *audioForm
 	if relayMode == relaymode.AudioSpeech {
 		// Read JSON
 		err := common.UnmarshalBodyReusable(c, &ttsRequest)
@@ -52,6 +67,10 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		if len(ttsRequest.Input) > 4096 {
 			return openai.ErrorWrapper(errors.New("input is too long (over 4096 characters)"), "text_too_long", http.StatusBadRequest)
 		}
+	} else {
+		if form, err = readAudioForm(requestBody, contentType); err != nil {
+			return openai.ErrorWrapper(err, "invalid_multipart_form", http.StatusBadRequest)
+		}
 	}
 
 	modelRatio := billingratio.GetModelRatio(audioModel, channelType)
@@ -66,7 +85,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	default:
 		preConsumedQuota = int64(float64(config.PreConsumedQuota) * ratio)
 	}
-	userQuota, err := model.CacheGetUserQuota(ctx, userId)
+	userQuota, err := model.CacheGetAccountQuota(ctx, userId, organizationId)
 	if err != nil {
 		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -75,7 +94,7 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if userQuota-preConsumedQuota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(userId, preConsumedQuota)
+	err = model.CacheDecreaseAccountQuota(userId, organizationId, preConsumedQuota)
 	if err != nil {
 		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -109,10 +128,18 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		}
 	}()
 
-	// map model name
+	// map model name, the model of the body relayed is replaced too
 	modelMapping := c.GetStringMapString(ctxkey.ModelMapping)
 	if modelMapping != nil && modelMapping[audioModel] != "" {
 		audioModel = modelMapping[audioModel]
+		if relayMode == relaymode.AudioSpeech {
+			requestBody, err = setJSONField(requestBody, "model", audioModel)
+		} else {
+			requestBody, err = setMultipartField(requestBody, contentType, "model", audioModel)
+		}
+		if err != nil {
+			return openai.ErrorWrapper(err, "map_model_failed", http.StatusInternalServerError)
+		}
 	}
 
 	baseURL := channeltype.ChannelBaseURLs[channelType]
@@ -123,53 +150,40 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 
 	fullRequestURL := openai.GetFullRequestURL(baseURL, requestURL, channelType)
 	if channelType == channeltype.Azure {
-		apiVersion := meta.Config.APIVersion
-		if relayMode == relaymode.AudioTranscription {
-			// https://learn.microsoft.com/en-us/azure/ai-services/openai/whisper-quickstart?tabs=command-line#rest-api
-			fullRequestURL = fmt.Sprintf("%s/openai/deployments/%s/audio/transcriptions?api-version=%s", baseURL, audioModel, apiVersion)
-		} else if relayMode == relaymode.AudioSpeech {
-			// https://learn.microsoft.com/en-us/azure/ai-services/openai/text-to-speech-quickstart?tabs=command-line#rest-api
-			fullRequestURL = fmt.Sprintf("%s/openai/deployments/%s/audio/speech?api-version=%s", baseURL, audioModel, apiVersion)
-		}
-	}
-
-	requestBody := &bytes.Buffer{}
-	_, err = io.Copy(requestBody, c.Request.Body)
-	if err != nil {
-		return openai.ErrorWrapper(err, "new_request_body_failed", http.StatusInternalServerError)
+		apiVersion := openai.AzureAPIVersion(meta.Config, relayMode, audioModel, nil)
+		// https://learn.microsoft.com/en-us/azure/ai-services/openai/whisper-quickstart?tabs=command-line#rest-api
+		// https://learn.microsoft.com/en-us/azure/ai-services/openai/text-to-speech-quickstart?tabs=command-line#rest-api
+		endpoint := map[int]string{
+			relaymode.AudioTranscription: "transcriptions",
+			relaymode.AudioTranslation:   "translations",
+			relaymode.AudioSpeech:        "speech",
+		}[relayMode]
+		fullRequestURL = fmt.Sprintf("%s/openai/deployments/%s/audio/%s?api-version=%s", baseURL, openai.AzureDeployment(meta.Config, audioModel), endpoint, apiVersion)
 	}
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody.Bytes()))
-	responseFormat := c.DefaultPostForm("response_format", "json")
 
-	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, fullRequestURL, bytes.NewReader(requestBody))
 	if err != nil {
 		return openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
 	}
 
-	if (relayMode == relaymode.AudioTranscription || relayMode == relaymode.AudioSpeech) && channelType == channeltype.Azure {
+	if channelType == channeltype.Azure {
 		// https://learn.microsoft.com/en-us/azure/ai-services/openai/whisper-quickstart?tabs=command-line#rest-api
 		apiKey := c.Request.Header.Get("Authorization")
 		apiKey = strings.TrimPrefix(apiKey, "Bearer ")
 		req.Header.Set("api-key", apiKey)
-		req.ContentLength = c.Request.ContentLength
 	} else {
 		req.Header.Set("Authorization", c.Request.Header.Get("Authorization"))
 	}
-	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
 
-	resp, err := client.HTTPClient.Do(req)
+	resp, err := adaptor.GetHTTPClient(c).Do(req)
 	if err != nil {
-		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		return doRequestError(c, err)
 	}
-
-	err = req.Body.Close()
-	if err != nil {
-		return openai.ErrorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
-	}
-	err = c.Request.Body.Close()
-	if err != nil {
-		return openai.ErrorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
+	if resp.StatusCode != http.StatusOK {
+		throttleChannel(c.Request.Context(), meta, resp)
+		return RelayErrorHandler(resp)
 	}
 
 	if relayMode != relaymode.AudioSpeech {
@@ -189,34 +203,30 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			}
 		}
 
-		var text string
-		switch responseFormat {
-		case "json":
-			text, err = getTextFromJSON(responseBody)
-		case "text":
-			text, err = getTextFromText(responseBody)
-		case "srt":
-			text, err = getTextFromSRT(responseBody)
-		case "verbose_json":
-			text, err = getTextFromVerboseJSON(responseBody)
-		case "vtt":
-			text, err = getTextFromVTT(responseBody)
-		default:
-			return openai.ErrorWrapper(errors.New("unexpected_response_format"), "unexpected_response_format", http.StatusInternalServerError)
+		responseFormat := form.fields["response_format"]
+		if responseFormat == "" {
+			responseFormat = "json"
 		}
+		text, usage, err := getTranscription(responseFormat, responseBody)
 		if err != nil {
 			return openai.ErrorWrapper(err, "get_text_from_body_err", http.StatusInternalServerError)
 		}
-		quota = int64(openai.CountTokenText(text, audioModel))
+		if usage == nil {
+			if seconds := getWAVDuration(form.audio); seconds > 0 {
+				usage = &openai.AudioUsage{Type: "duration", Seconds: seconds}
+			}
+		}
+		quota = getTranscriptionQuota(text, usage, audioModel, channelType, ratio)
 		resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
 	}
-	if resp.StatusCode != http.StatusOK {
-		return RelayErrorHandler(resp)
-	}
 	succeed = true
 	quotaDelta := quota - preConsumedQuota
+	var upstreamQuota int64
+	if ratio != 0 {
+		upstreamQuota = billing.UpstreamQuota(meta, audioModel, float64(quota)/ratio)
+	}
 	defer func(ctx context.Context) {
-		go billing.PostConsumeQuota(ctx, tokenId, quotaDelta, quota, userId, channelId, modelRatio, groupRatio, audioModel, tokenName)
+		go billing.PostConsumeQuota(ctx, tokenId, quotaDelta, quota, upstreamQuota, userId, channelId, modelRatio, groupRatio, audioModel, tokenName)
 	}(c.Request.Context())
 
 	for k, v := range resp.Header {
@@ -224,7 +234,8 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	}
 	c.Writer.WriteHeader(resp.StatusCode)
 
-	_, err = io.Copy(c.Writer, resp.Body)
+	// speech is streamed as the channel produces it
+	err = copyFlushing(c.Writer, resp.Body)
 	if err != nil {
 		return openai.ErrorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
 	}
@@ -235,18 +246,6 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	return nil
 }
 
-func getTextFromVTT(body []byte) (string, error) {
-	return getTextFromSRT(body)
-}
-
-func getTextFromVerboseJSON(body []byte) (string, error) {
-	var whisperResponse openai.WhisperVerboseJSONResponse
-	if err := json.Unmarshal(body, &whisperResponse); err != nil {
-		return "", fmt.Errorf("unmarshal_response_body_failed err :%w", err)
-	}
-	return whisperResponse.Text, nil
-}
-
 func getTextFromSRT(body []byte) (string, error) {
 	scanner := bufio.NewScanner(strings.NewReader(string(body)))
 	var builder strings.Builder
@@ -272,10 +271,226 @@ func getTextFromText(body []byte) (string, error) {
 	return strings.TrimSuffix(string(body), "\n"), nil
 }
 
-func getTextFromJSON(body []byte) (string, error) {
-	var whisperResponse openai.WhisperJSONResponse
-	if err := json.Unmarshal(body, &whisperResponse); err != nil {
-		return "", fmt.Errorf("unmarshal_response_body_failed err :%w", err)
+// audioForm is the multipart form of a transcription or a translation
+type audioForm struct {
+	fields map[string]string
+	audio  []byte
+}
+
+func multipartBoundary(contentType string) (string, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return "", errors.New("the request must be multipart/form-data")
+	}
+	return params["boundary"], nil
+}
+
+func readAudioForm(body []byte, contentType string) (*audioForm, error) {
+	boundary, err := multipartBoundary(contentType)
+	if err != nil {
+		return nil, err
+	}
+	form := &audioForm{fields: make(map[string]string)}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		value, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() != "" {
+			form.audio = value
+		} else {
+			form.fields[part.FormName()] = string(value)
+		}
+	}
+}
+
+// setMultipartField replaces the value of a field of a multipart body, keeping its boundary
+func setMultipartField(body []byte, contentType string, name string, value string) ([]byte, error) {
+	boundary, err := multipartBoundary(contentType)
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	writer := multipart.NewWriter(&buffer)
+	if err = writer.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		partWriter, err := writer.CreatePart(part.Header)
+		if err != nil {
+			return nil, err
+		}
+		if part.FileName() == "" && part.FormName() == name {
+			_, err = partWriter.Write([]byte(value))
+		} else {
+			_, err = io.Copy(partWriter, part)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func setJSONField(body []byte, name string, value any) ([]byte, error) {
+	var request map[string]json.RawMessage
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, err
+	}
+	field, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	request[name] = field
+	return json.Marshal(request)
+}
+
+// getTranscription returns the text of a transcription and its usage, the channels which
+// do not report it give the duration of the audio in the verbose_json, srt and vtt formats
+func getTranscription(responseFormat string, body []byte) (string, *openai.AudioUsage, error) {
+	var text string
+	var err error
+	switch responseFormat {
+	case "json":
+		var whisperResponse openai.WhisperJSONResponse
+		if err = json.Unmarshal(body, &whisperResponse); err != nil {
+			return "", nil, fmt.Errorf("unmarshal_response_body_failed err :%w", err)
+		}
+		return whisperResponse.Text, whisperResponse.Usage, nil
+	case "text":
+		text, err = getTextFromText(body)
+		return text, nil, err
+	case "srt", "vtt":
+		if text, err = getTextFromSRT(body); err != nil {
+			return "", nil, err
+		}
+		if seconds := getDurationFromSRT(body); seconds > 0 {
+			return text, &openai.AudioUsage{Type: "duration", Seconds: seconds}, nil
+		}
+		return text, nil, nil
+	case "verbose_json":
+		var whisperResponse openai.WhisperVerboseJSONResponse
+		if err = json.Unmarshal(body, &whisperResponse); err != nil {
+			return "", nil, fmt.Errorf("unmarshal_response_body_failed err :%w", err)
+		}
+		usage := whisperResponse.Usage
+		if usage == nil && whisperResponse.Duration > 0 {
+			usage = &openai.AudioUsage{Type: "duration", Seconds: whisperResponse.Duration}
+		}
+		return whisperResponse.Text, usage, nil
+	}
+	return "", nil, errors.New("unexpected_response_format")
+}
+
+// getDurationFromSRT returns the end of the last cue of a srt or vtt transcription
+func getDurationFromSRT(body []byte) float64 {
+	var seconds float64
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		_, end, found := strings.Cut(scanner.Text(), "-->")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(end)
+		if len(fields) == 0 {
+			continue
+		}
+		// 00:01:02,500 in srt, 01:02.500 or 00:01:02.500 in vtt
+		var timestamp float64
+		for _, unit := range strings.Split(strings.Replace(fields[0], ",", ".", 1), ":") {
+			value, err := strconv.ParseFloat(unit, 64)
+			if err != nil {
+				timestamp = 0
+				break
+			}
+			timestamp = timestamp*60 + value
+		}
+		if timestamp > seconds {
+			seconds = timestamp
+		}
+	}
+	return seconds
+}
+
+// getWAVDuration returns the duration of a WAV file, zero for the other formats
+func getWAVDuration(audio []byte) float64 {
+	if len(audio) < 12 || string(audio[0:4]) != "RIFF" || string(audio[8:12]) != "WAVE" {
+		return 0
+	}
+	var byteRate, dataSize uint32
+	for offset := 12; offset+8 <= len(audio); {
+		chunkId := string(audio[offset : offset+4])
+		chunkSize := binary.LittleEndian.Uint32(audio[offset+4 : offset+8])
+		switch chunkId {
+		case "fmt ":
+			if offset+20 <= len(audio) {
+				byteRate = binary.LittleEndian.Uint32(audio[offset+16 : offset+20])
+			}
+		case "data":
+			dataSize = chunkSize
+			if available := uint32(len(audio) - offset - 8); dataSize > available {
+				dataSize = available
+			}
+		}
+		if byteRate > 0 && dataSize > 0 {
+			return float64(dataSize) / float64(byteRate)
+		}
+		offset += 8 + int(chunkSize) + int(chunkSize%2)
+	}
+	return 0
+}
+
+// getTranscriptionQuota bills by tokens when the channel reports them, by minute of audio
+// when the duration is known, else by the tokens of the text
+func getTranscriptionQuota(text string, usage *openai.AudioUsage, audioModel string, channelType int, ratio float64) int64 {
+	if usage != nil && usage.Type == "tokens" {
+		completionRatio := billingratio.GetCompletionRatio(audioModel, channelType)
+		return int64(math.Ceil((float64(usage.InputTokens) + float64(usage.OutputTokens)*completionRatio) * ratio))
+	}
+	if usage != nil && usage.Seconds > 0 {
+		return int64(math.Ceil(usage.Seconds / 60 * billingratio.AudioTokensPerMinute * ratio))
+	}
+	return int64(math.Ceil(float64(openai.CountTokenText(text, audioModel)) * ratio))
+}
+
+// copyFlushing copies the response, flushing every write
+func copyFlushing(w gin.ResponseWriter, body io.Reader) error {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+			w.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
-	return whisperResponse.Text, nil
 }
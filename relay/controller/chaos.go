@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// sendWithChaos sends a request upstream with the adaptor of its channel, with the fault of the
+// chaos rule drawn for it if any. The faults look like the provider's own to the relay, so
+// they go through the same retries, throttling, circuit breakers and adaptive timeouts.
+func sendWithChaos(c *gin.Context, meta *meta.Meta, adaptor adaptor.Adaptor, requestBody io.Reader) (*http.Response, error) {
+	rule := dbmodel.CacheSampleChaosRule(meta.ChannelId, meta.OriginModelName, meta.IsStream)
+	if rule == nil {
+		return adaptor.DoRequest(c, meta, requestBody)
+	}
+	ctx := c.Request.Context()
+	logger.Warnf(ctx, "chaos rule %s: injecting %s into channel #%d", rule.Name, rule.Fault, meta.ChannelId)
+	switch rule.Fault {
+	case dbmodel.ChaosFaultDelay:
+		timer := time.NewTimer(time.Duration(rule.DelayMs) * time.Millisecond)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	case dbmodel.ChaosFaultError:
+		return chaosErrorResponse(rule), nil
+	case dbmodel.ChaosFaultDropStream:
+		resp, err := adaptor.DoRequest(c, meta, requestBody)
+		if err == nil && resp != nil && resp.StatusCode == http.StatusOK {
+			resp.Body = &droppedStream{ReadCloser: resp.Body, remaining: rule.DropAfterBytes}
+		}
+		return resp, err
+	}
+	return adaptor.DoRequest(c, meta, requestBody)
+}
+
+// chaosErrorResponse is the synthetic error of a rule, in the format of the OpenAI errors
+func chaosErrorResponse(rule *dbmodel.ChaosRule) *http.Response {
+	data, _ := json.Marshal(map[string]any{
+		"error": model.Error{
+			Message: fmt.Sprintf("fault injected by chaos rule %s", rule.Name),
+			Type:    "chaos_error",
+			Code:    "chaos_error",
+		},
+	})
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	if rule.ErrorStatus == http.StatusTooManyRequests && rule.RetryAfter > 0 {
+		header.Set("Retry-After", strconv.Itoa(rule.RetryAfter))
+	}
+	return &http.Response{
+		StatusCode: rule.ErrorStatus,
+		Status:     fmt.Sprintf("%d %s", rule.ErrorStatus, http.StatusText(rule.ErrorStatus)),
+		Header:     header,
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}
+}
+
+// droppedStream is an upstream stream whose connection is lost after its first bytes
+type droppedStream struct {
+	io.ReadCloser
+	remaining int
+}
+
+func (s *droppedStream) Read(p []byte) (int, error) {
+	if s.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > s.remaining {
+		p = p[:s.remaining]
+	}
+	n, err := s.ReadCloser.Read(p)
+	s.remaining -= n
+	return n, err
+}
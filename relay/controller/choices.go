@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// Most vendors generate a single choice per request. The n choices of a chat completion are
+// emulated on their channels by n requests of a single choice sent in parallel, whose
+// responses are merged; each of them is billed, its prompt included. The streams of several
+// choices are refused on them.
+
+// nativeChoices are the API types generating the n choices of a request
+var nativeChoices = map[int]bool{
+	apitype.OpenAI: true,
+	apitype.Gemini: true,
+	apitype.PaLM:   true,
+}
+
+// emulatesChoices tells whether the choices of a request are emulated
+func emulatesChoices(meta *meta.Meta, request *model.GeneralOpenAIRequest) bool {
+	return meta.Mode == relaymode.ChatCompletions && request.N > 1 && !nativeChoices[meta.APIType]
+}
+
+// checkChoices refuses the requests of several choices the channel can't emulate
+func checkChoices(meta *meta.Meta, request *model.GeneralOpenAIRequest) *model.ErrorWithStatusCode {
+	if !emulatesChoices(meta, request) {
+		return nil
+	}
+	var message string
+	if request.Stream {
+		message = "n greater than 1 is not supported for streams on this model"
+	} else if request.N > config.MaxEmulatedChoices {
+		message = fmt.Sprintf("n must be at most %d on this model", config.MaxEmulatedChoices)
+	} else {
+		return nil
+	}
+	return &model.ErrorWithStatusCode{
+		Error: model.Error{
+			Message: message,
+			Type:    "invalid_request_error",
+			Param:   "n",
+			Code:    "unsupported_value",
+		},
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// choiceRecorder keeps the response of a request of a single choice
+type choiceRecorder struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newChoiceRecorder(w gin.ResponseWriter) *choiceRecorder {
+	return &choiceRecorder{ResponseWriter: w, header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *choiceRecorder) Header() http.Header               { return w.header }
+func (w *choiceRecorder) WriteHeader(status int)            { w.status = status }
+func (w *choiceRecorder) WriteHeaderNow()                   {}
+func (w *choiceRecorder) Write(data []byte) (int, error)    { return w.body.Write(data) }
+func (w *choiceRecorder) WriteString(s string) (int, error) { return w.body.WriteString(s) }
+func (w *choiceRecorder) Status() int                       { return w.status }
+func (w *choiceRecorder) Size() int                         { return w.body.Len() }
+func (w *choiceRecorder) Written() bool                     { return w.body.Len() > 0 }
+func (w *choiceRecorder) Flush()                            {}
+
+type choiceResult struct {
+	response *openai.TextResponse
+	usage    *model.Usage
+	err      *model.ErrorWithStatusCode
+}
+
+// relayChoices relays the n choices of a chat completion as n requests, writes their merged
+// response and returns their summed usage
+func relayChoices(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, adaptor adaptor.Adaptor) (*model.Usage, *model.ErrorWithStatusCode) {
+	n := textRequest.N
+	textRequest.N = 1
+	requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
+	textRequest.N = n
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+
+	results := make([]choiceResult, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(result *choiceResult) {
+			defer wg.Done()
+			*result = relayChoice(c, *meta, adaptor, body)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	usage := &model.Usage{}
+	choices := make([]openai.TextResponseChoice, 0, n)
+	for i, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+		if result.usage != nil {
+			usage.PromptTokens += result.usage.PromptTokens
+			usage.CompletionTokens += result.usage.CompletionTokens
+			usage.TotalTokens += result.usage.TotalTokens
+			usage.AddCachedTokens(result.usage.GetCachedTokens())
+			usage.AddReasoningTokens(result.usage.GetReasoningTokens())
+		}
+		for _, choice := range result.response.Choices {
+			choice.Index = i
+			choices = append(choices, choice)
+		}
+	}
+	merged := results[0].response
+	merged.Choices = choices
+	merged.Usage = *usage
+	meta.EmulatedChoices = n
+	c.Header("X-Emulated-Choices", strconv.Itoa(n))
+	c.JSON(http.StatusOK, merged)
+	return usage, nil
+}
+
+// relayChoice relays one of the requests of relayChoices with a copy of its context and meta
+func relayChoice(c *gin.Context, meta meta.Meta, adaptor adaptor.Adaptor, body []byte) choiceResult {
+	cc := c.Copy()
+	recorder := newChoiceRecorder(c.Writer)
+	cc.Writer = recorder
+	resp, err := doRequest(cc, &meta, adaptor, bytes.NewReader(body))
+	if err != nil {
+		return choiceResult{err: doRequestError(cc, err)}
+	}
+	if isErrorHappened(&meta, resp) {
+		throttleChannel(c.Request.Context(), &meta, resp)
+		return choiceResult{err: RelayErrorHandler(resp)}
+	}
+	usage, respErr := adaptor.DoResponse(cc, resp, &meta)
+	if respErr != nil {
+		return choiceResult{err: respErr}
+	}
+	var response openai.TextResponse
+	if err = json.Unmarshal(recorder.body.Bytes(), &response); err != nil {
+		return choiceResult{err: openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)}
+	}
+	return choiceResult{response: &response, usage: usage}
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/client"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/constant/role"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// Policies of CONTEXT_TRIM_POLICY
+const (
+	contextPolicyTrim      = "trim"
+	contextPolicySummarize = "summarize"
+)
+
+const contextSummaryPrompt = "Summarize the following conversation in a few sentences, keeping the facts, names, decisions and open questions needed to continue it. Reply with the summary only."
+
+// contextWindow returns the context window of the model of the request, 0 when it is unknown
+func contextWindow(meta *meta.Meta) int {
+	for _, name := range []string{meta.OriginModelName, meta.ActualModelName} {
+		if modelMeta, ok := model.CacheGetModelMeta(name); ok && modelMeta.ContextWindow > 0 {
+			return modelMeta.ContextWindow
+		}
+	}
+	return 0
+}
+
+// maxCompletionTokens returns the tokens a request asks for its completion
+func maxCompletionTokens(request *relaymodel.GeneralOpenAIRequest) int {
+	if request.MaxCompletionTokens != nil {
+		return *request.MaxCompletionTokens
+	}
+	return request.MaxTokens
+}
+
+// checkContextLength rejects a request whose prompt and completion are over the context window
+// of its model, before any quota is consumed. The prompt is counted by the gateway, with the
+// closest encoding for the models of other vendors.
+func checkContextLength(meta *meta.Meta, promptTokens int, completionTokens int) *relaymodel.ErrorWithStatusCode {
+	if !config.ContextLengthCheckEnabled {
+		return nil
+	}
+	window := contextWindow(meta)
+	if window == 0 || promptTokens+completionTokens <= window {
+		return nil
+	}
+	return &relaymodel.ErrorWithStatusCode{
+		Error: relaymodel.Error{
+			Message: fmt.Sprintf("This model's maximum context length is %d tokens. However, you requested %d tokens (%d in the messages, %d in the completion). Please reduce the length of the messages or completion.",
+				window, promptTokens+completionTokens, promptTokens, completionTokens),
+			Type:  "invalid_request_error",
+			Param: "messages",
+			Code:  ErrorCodeContextLengthExceeded,
+		},
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+func countMessageTokens(message relaymodel.Message, modelName string) int {
+	// CountTokenMessages adds the 3 tokens priming the reply to the tokens of the messages
+	return openai.CountTokenMessages([]relaymodel.Message{message}, modelName) - 3
+}
+
+// trimContext removes, or replaces with a summary, the oldest turns of a conversation whose
+// prompt and max_tokens are over the context window of its model. The leading system messages
+// and the last user turn are kept. It returns the number of messages removed.
+func trimContext(c *gin.Context, meta *meta.Meta, request *relaymodel.GeneralOpenAIRequest) int {
+	policy := config.ContextTrimPolicy
+	if policy != contextPolicyTrim && policy != contextPolicySummarize {
+		return 0
+	}
+	window := contextWindow(meta)
+	if window == 0 || len(request.Messages) < 2 {
+		return 0
+	}
+	budget := window - maxCompletionTokens(request)
+	if budget <= 0 {
+		return 0
+	}
+	messages := request.Messages
+	counts := make([]int, len(messages))
+	total := 3
+	for i, message := range messages {
+		counts[i] = countMessageTokens(message, request.Model)
+		total += counts[i]
+	}
+	if total <= budget {
+		return 0
+	}
+
+	start := 0
+	for start < len(messages) && (messages[start].Role == role.System || messages[start].Role == role.Developer) {
+		start++
+	}
+	lastUser := len(messages) - 1
+	for lastUser > start && messages[lastUser].Role != role.User {
+		lastUser--
+	}
+	end := start
+	for end < lastUser && total > budget {
+		total -= counts[end]
+		end++
+	}
+	// a turn starts with a user message, so tool results are not cut from their calls
+	for end < lastUser && messages[end].Role != role.User {
+		total -= counts[end]
+		end++
+	}
+	if end == start {
+		return 0
+	}
+
+	trimmed := make([]relaymodel.Message, 0, len(messages)-(end-start)+1)
+	trimmed = append(trimmed, messages[:start]...)
+	action := contextPolicyTrim
+	if policy == contextPolicySummarize {
+		summary, err := summarizeMessages(c, messages[start:end])
+		if err != nil {
+			logger.Errorf(c.Request.Context(), "context summary failed, trimming the conversation: %s", err.Error())
+		} else {
+			summaryMessage := relaymodel.Message{
+				Role:    role.System,
+				Content: "Summary of the earlier conversation: " + summary,
+			}
+			if summaryTokens := countMessageTokens(summaryMessage, request.Model); total+summaryTokens <= budget {
+				trimmed = append(trimmed, summaryMessage)
+				action = contextPolicySummarize
+			}
+		}
+	}
+	trimmed = append(trimmed, messages[end:]...)
+	request.Messages = trimmed
+	logger.Infof(c.Request.Context(), "context over the window of %d tokens, %s %d messages", window, action, end-start)
+	c.Header("X-Context-Trimmed", fmt.Sprintf("%s; messages=%d", action, end-start))
+	return end - start
+}
+
+type contextSummaryResponse struct {
+	Choices []struct {
+		Message relaymodel.Message `json:"message"`
+	} `json:"choices"`
+}
+
+// summarizeMessages asks CONTEXT_SUMMARY_MODEL, on an OpenAI compatible channel of the group
+// serving it, to summarize messages
+func summarizeMessages(c *gin.Context, messages []relaymodel.Message) (string, error) {
+	var transcript strings.Builder
+	for _, message := range messages {
+		if content := message.StringContent(); content != "" {
+			transcript.WriteString(message.Role + ": " + content + "\n")
+		}
+	}
+	if transcript.Len() == 0 {
+		return "", errors.New("nothing to summarize")
+	}
+	channel, err := model.CacheGetRandomSatisfiedChannel(c.GetInt(ctxkey.TenantId), c.GetString(ctxkey.Group), config.ContextSummaryModel, false)
+	if err != nil {
+		return "", err
+	}
+	baseURL := channel.GetBaseURL()
+	if baseURL == "" {
+		baseURL = channeltype.ChannelBaseURLs[channel.Type]
+	}
+	modelName := config.ContextSummaryModel
+	if mappedModel, ok := channel.GetModelMapping()[modelName]; ok && mappedModel != "" {
+		modelName = mappedModel
+	}
+	requestBody, err := json.Marshal(map[string]any{
+		"model": modelName,
+		"messages": []relaymodel.Message{
+			{Role: role.System, Content: contextSummaryPrompt},
+			{Role: role.User, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(config.ContextSummaryTimeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/v1/chat/completions", bytes.NewReader(requestBody))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+channel.Key)
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	var summary contextSummaryResponse
+	if err = json.NewDecoder(resp.Body).Decode(&summary); err != nil {
+		return "", err
+	}
+	if len(summary.Choices) == 0 || strings.TrimSpace(summary.Choices[0].Message.StringContent()) == "" {
+		return "", errors.New("empty summary")
+	}
+	return strings.TrimSpace(summary.Choices[0].Message.StringContent()), nil
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	dbmodel "github.com/songquanpeng/one-api/model"
+)
+
+// fileReference returns the id of the uploaded file a content part refers to, either a file
+// part or an image_url part whose url is a file id
+func fileReference(part map[string]any) string {
+	switch part["type"] {
+	case "file":
+		if file, ok := part["file"].(map[string]any); ok {
+			fileId, _ := file["file_id"].(string)
+			return fileId
+		}
+	case "image_url":
+		if imageURL, ok := part["image_url"].(map[string]any); ok {
+			if url, _ := imageURL["url"].(string); strings.HasPrefix(url, "file-") {
+				return url
+			}
+		}
+	}
+	return ""
+}
+
+func fileDataURL(c *gin.Context, fileId string) (string, error) {
+	file, err := dbmodel.GetFile(fileId, c.GetInt(ctxkey.Id))
+	if err != nil {
+		return "", fmt.Errorf("file %s not found", fileId)
+	}
+	content, err := file.Content(c.Request.Context())
+	if err != nil {
+		return "", fmt.Errorf("failed to read file %s: %w", fileId, err)
+	}
+	contentType := file.ContentType
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(content)
+	}
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", fmt.Errorf("file %s is not an image, only images can be used in messages", fileId)
+	}
+	return "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(content), nil
+}
+
+// resolveFileReferences inlines the images uploaded with the files API and referenced by
+// the messages as data urls, so that any channel can read them
+func resolveFileReferences(c *gin.Context) error {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(requestBody, []byte(`"file-`)) {
+		return nil
+	}
+	var request map[string]any
+	if err = json.Unmarshal(requestBody, &request); err != nil {
+		// left to the validation of the request
+		return nil
+	}
+	messages, _ := request["messages"].([]any)
+	resolved := false
+	for _, message := range messages {
+		messageMap, _ := message.(map[string]any)
+		parts, _ := messageMap["content"].([]any)
+		for i, part := range parts {
+			partMap, _ := part.(map[string]any)
+			fileId := fileReference(partMap)
+			if fileId == "" {
+				continue
+			}
+			url, err := fileDataURL(c, fileId)
+			if err != nil {
+				return err
+			}
+			imageURL := map[string]any{"url": url}
+			if original, ok := partMap["image_url"].(map[string]any); ok && original["detail"] != nil {
+				imageURL["detail"] = original["detail"]
+			}
+			parts[i] = map[string]any{"type": "image_url", "image_url": imageURL}
+			resolved = true
+		}
+	}
+	if !resolved {
+		return nil
+	}
+	requestBody, err = json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	c.Set(ctxkey.KeyRequestBody, requestBody)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	c.Request.ContentLength = int64(len(requestBody))
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,17 +19,30 @@ import (
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/trace"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/controller/validator"
 	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/paramtranslate"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 func getAndValidateTextRequest(c *gin.Context, relayMode int) (*relaymodel.GeneralOpenAIRequest, error) {
+	if relayMode == relaymode.ChatCompletions {
+		if err := resolveFileReferences(c); err != nil {
+			return nil, err
+		}
+		if config.VisionPreprocessEnabled {
+			if err := preprocessImages(c); err != nil {
+				return nil, err
+			}
+		}
+	}
 	textRequest := &relaymodel.GeneralOpenAIRequest{}
 	err := common.UnmarshalBodyReusable(c, textRequest)
 	if err != nil {
@@ -59,25 +73,36 @@ func getPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int
 	return 0
 }
 
-func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64) int64 {
+func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, strategy billingratio.PreConsumeStrategy) int64 {
+	switch strategy.Strategy {
+	case billingratio.PreConsumeNone:
+		return 0
+	case billingratio.PreConsumePromptOnly:
+		return int64(float64(promptTokens) * ratio)
+	}
 	preConsumedTokens := config.PreConsumedQuota + int64(promptTokens)
 	if textRequest.MaxTokens != 0 {
 		preConsumedTokens += int64(textRequest.MaxTokens)
 	}
-	return int64(float64(preConsumedTokens) * ratio)
+	preConsumedQuota := int64(float64(preConsumedTokens) * ratio)
+	if strategy.Strategy == billingratio.PreConsumePercentage {
+		preConsumedQuota = int64(float64(preConsumedQuota) * strategy.Percentage / 100)
+	}
+	return preConsumedQuota
 }
 
 func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
-	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
+	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio, billingratio.GetPreConsumeStrategy(meta.Group))
 
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	userQuota, err := model.CacheGetAccountQuota(ctx, meta.UserId, meta.OrganizationId)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
-	if userQuota-preConsumedQuota < 0 {
+	// a request pre-consuming nothing still needs a positive balance, unless it is free
+	if userQuota-preConsumedQuota < 0 || (preConsumedQuota == 0 && ratio != 0 && userQuota <= 0) {
 		return preConsumedQuota, openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
-	err = model.CacheDecreaseUserQuota(meta.UserId, preConsumedQuota)
+	err = model.CacheDecreaseAccountQuota(meta.UserId, meta.OrganizationId, preConsumedQuota)
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -92,10 +117,106 @@ func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIR
 		if err != nil {
 			return preConsumedQuota, openai.ErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
+		meta.PreConsumeJournalId = model.JournalPreConsumedQuota(meta.TokenId, preConsumedQuota)
 	}
 	return preConsumedQuota, nil
 }
 
+// ErrorCodeTPMLimitExceeded rejects a request over the TPM limit of its token or user, it is
+// not retried on another channel
+const ErrorCodeTPMLimitExceeded = "tpm_limit_exceeded"
+
+// ErrorCodeContextLengthExceeded rejects a request over the context window of its model, the
+// code of the OpenAI API for the same error
+const ErrorCodeContextLengthExceeded = "context_length_exceeded"
+
+// RejectedBeforeRelay tells whether the gateway rejected a request before relaying it, no
+// channel is at fault; the context length errors of the providers are alike, another channel
+// would not take the request either
+func RejectedBeforeRelay(bizErr *relaymodel.ErrorWithStatusCode) bool {
+	return bizErr != nil && (bizErr.Code == ErrorCodeTPMLimitExceeded || bizErr.Code == ErrorCodeContextLengthExceeded)
+}
+
+// admitTPM debits the prompt tokens of a request from the TPM buckets of its token and user
+func admitTPM(c *gin.Context, meta *meta.Meta, promptTokens int) *relaymodel.ErrorWithStatusCode {
+	admitted, retryAfter := billing.DebitTPM(c.Request.Context(), meta, promptTokens)
+	setTokenLimitHeaders(c, meta)
+	if admitted {
+		return nil
+	}
+	c.Header("Retry-After", strconv.FormatInt(int64(math.Ceil(retryAfter.Seconds())), 10))
+	return openai.ErrorWrapper(errors.New("tokens per minute limit exceeded, retry later"), ErrorCodeTPMLimitExceeded, http.StatusTooManyRequests)
+}
+
+// timedOut tells whether the request ran out of its time, see X-Request-Timeout
+func timedOut(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// setTokenLimitHeaders sets the x-ratelimit-*-tokens headers of the OpenAI API from the TPM
+// bucket of a request with the lowest balance, it resets once the bucket is full again
+func setTokenLimitHeaders(c *gin.Context, meta *meta.Meta) {
+	if meta.TPMLimit == 0 {
+		return
+	}
+	remaining := meta.TPMBalance
+	if remaining < 0 {
+		remaining = 0
+	}
+	reset := time.Duration(float64(int64(meta.TPMLimit)-meta.TPMBalance) / float64(meta.TPMLimit) * float64(time.Minute))
+	c.Header("x-ratelimit-limit-tokens", strconv.Itoa(meta.TPMLimit))
+	c.Header("x-ratelimit-remaining-tokens", strconv.FormatInt(remaining, 10))
+	c.Header("x-ratelimit-reset-tokens", helper.FormatRateLimitReset(reset))
+}
+
+// doRequestError wraps an error of the upstream call, which is aborted when the request
+// runs out of its time
+func doRequestError(c *gin.Context, err error) *relaymodel.ErrorWithStatusCode {
+	if timedOut(c) {
+		return openai.ErrorWrapper(err, "request_timeout", http.StatusGatewayTimeout)
+	}
+	return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+}
+
+// recordCacheHitLog records a request served from the response cache, it costs no quota
+func recordCacheHitLog(ctx context.Context, meta *meta.Meta, cacheHit string) {
+	model.RecordConsumeLog(ctx, &model.Log{
+		UserId:      meta.UserId,
+		ChannelId:   meta.ChannelId,
+		ModelName:   meta.ActualModelName,
+		TokenName:   meta.TokenName,
+		Content:     "缓存命中：" + cacheHit,
+		IsStream:    meta.IsStream,
+		ElapsedTime: helper.CalcElapsedTime(meta.StartTime),
+		CacheHit:    cacheHit,
+	})
+}
+
+// translateParameters translates the parameters of a chat completion or completion for the
+// provider of the channel, see paramtranslate. The parameters dropped are listed in the
+// X-Dropped-Parameters header, which is reset for every channel tried.
+func translateParameters(c *gin.Context, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest) bool {
+	if !config.ParamTranslationEnabled || (meta.Mode != relaymode.ChatCompletions && meta.Mode != relaymode.Completions) {
+		return false
+	}
+	changed, dropped := paramtranslate.Apply(meta.ChannelType, meta.ActualModelName, textRequest)
+	if len(dropped) > 0 {
+		c.Header("X-Dropped-Parameters", strings.Join(dropped, ", "))
+		logger.Warnf(c.Request.Context(), "parameters not supported by channel #%d dropped: %s", meta.ChannelId, strings.Join(dropped, ", "))
+	} else {
+		c.Writer.Header().Del("X-Dropped-Parameters")
+	}
+	return changed
+}
+
+// deferredRatio is the discount of the relay of a deferred request, 1 for the others
+func deferredRatio(meta *meta.Meta) float64 {
+	if meta.Deferred {
+		return config.DeferredPriceRatio
+	}
+	return 1
+}
+
 func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest, ratio float64, preConsumedQuota int64, modelRatio float64, groupRatio float64, systemPromptReset bool) {
 	if usage == nil {
 		logger.Error(ctx, "usage is nil, which is unexpected")
@@ -105,7 +226,23 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 	completionRatio := billingratio.GetCompletionRatio(textRequest.Model, meta.ChannelType)
 	promptTokens := usage.PromptTokens
 	completionTokens := usage.CompletionTokens
-	quota = int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
+	// cached tokens are part of the prompt tokens, at the discounted price of the provider
+	cachedTokens := usage.GetCachedTokens()
+	if cachedTokens > promptTokens {
+		cachedTokens = promptTokens
+	}
+	cacheRatio := billingratio.GetCacheRatio(textRequest.Model)
+	billedPromptTokens := float64(promptTokens-cachedTokens) + float64(cachedTokens)*cacheRatio
+	// reasoning tokens are part of the completion tokens, priced by their own ratio on top
+	reasoningTokens := usage.GetReasoningTokens()
+	if reasoningTokens > completionTokens {
+		reasoningTokens = completionTokens
+	}
+	reasoningRatio := billingratio.GetReasoningRatio(textRequest.Model)
+	billedCompletionTokens := float64(completionTokens-reasoningTokens) + float64(reasoningTokens)*reasoningRatio
+	quota = int64(math.Ceil((billedPromptTokens + billedCompletionTokens*completionRatio) * ratio))
+	cacheSavedQuota := int(float64(cachedTokens) * (1 - cacheRatio) * ratio)
+	upstreamQuota := billing.UpstreamQuota(meta, textRequest.Model, billedPromptTokens+billedCompletionTokens*completionRatio)
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
@@ -114,8 +251,21 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 		// in this case, must be some error happened
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
+		upstreamQuota = 0
+	}
+	// the free allowances of the models are for the personal tokens of the users
+	var freeTokens int64
+	if meta.OrganizationId == 0 && quota > 0 {
+		freeTokens = model.UseFreeAllowance(meta.UserId, meta.Group, textRequest.Model, int64(totalTokens))
+		quota -= quota * freeTokens / int64(totalTokens)
 	}
+	billing.SettleTPM(meta, totalTokens)
+	model.RecordExperimentQuota(meta.ExperimentId, meta.ExperimentArm, quota)
 	quotaDelta := quota - preConsumedQuota
+	if !model.SettlePreConsumedQuota(meta.PreConsumeJournalId) {
+		// the reconciliation has already returned the pre-consumed quota
+		quotaDelta = quota
+	}
 	err := model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
 	if err != nil {
 		logger.Error(ctx, "error consuming token remain quota: "+err.Error())
@@ -125,11 +275,33 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
 	logContent := fmt.Sprintf("倍率：%.2f × %.2f × %.2f", modelRatio, groupRatio, completionRatio)
+	if cachedTokens > 0 {
+		logContent += fmt.Sprintf("，缓存 %d tokens × %.2f", cachedTokens, cacheRatio)
+	}
+	if reasoningTokens > 0 {
+		logContent += fmt.Sprintf("，推理 %d tokens × %.2f", reasoningTokens, reasoningRatio)
+	}
+	if freeTokens > 0 {
+		logContent += fmt.Sprintf("，免费额度 %d tokens", freeTokens)
+	}
+	if meta.PartialCompletion {
+		logContent += "，流中断，按已输出计费"
+	}
+	if meta.EmulatedChoices > 0 {
+		logContent += fmt.Sprintf("，%d 个选择分别请求", meta.EmulatedChoices)
+	}
+	if meta.Deferred {
+		logContent += fmt.Sprintf("，延迟执行 × %.2f", config.DeferredPriceRatio)
+	}
 	model.RecordConsumeLog(ctx, &model.Log{
 		UserId:            meta.UserId,
 		ChannelId:         meta.ChannelId,
 		PromptTokens:      promptTokens,
 		CompletionTokens:  completionTokens,
+		ReasoningTokens:   reasoningTokens,
+		CachedTokens:      cachedTokens,
+		CacheSavedQuota:   cacheSavedQuota,
+		UpstreamQuota:     int(upstreamQuota),
 		ModelName:         textRequest.Model,
 		TokenName:         meta.TokenName,
 		Quota:             int(quota),
@@ -137,6 +309,8 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 		IsStream:          meta.IsStream,
 		ElapsedTime:       helper.CalcElapsedTime(meta.StartTime),
 		SystemPromptReset: systemPromptReset,
+		Partial:           meta.PartialCompletion,
+		Moderation:        meta.Moderation,
 		// Model mapping transparency
 		VirtualModel:       meta.OriginModelName,
 		ResolvedModel:      meta.ActualModelName,
@@ -232,6 +406,18 @@ func isErrorHappened(meta *meta.Meta, resp *http.Response) bool {
 	return false
 }
 
+// throttleChannel makes the selectors skip the channel while its provider asks, with the
+// Retry-After header of a 429, to retry later
+func throttleChannel(ctx context.Context, meta *meta.Meta, resp *http.Response) {
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		return
+	}
+	if wait, ok := helper.ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		model.ThrottleChannel(meta.ChannelId, wait)
+		trace.Record(ctx, trace.StageBreaker, meta.ChannelId, fmt.Sprintf("throttled channel #%d for %s after a 429", meta.ChannelId, wait), nil)
+	}
+}
+
 func setSystemPrompt(ctx context.Context, request *relaymodel.GeneralOpenAIRequest, prompt string) (reset bool) {
 	if prompt == "" {
 		return false
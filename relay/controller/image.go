@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -15,12 +16,15 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/monitor"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 func getImageRequest(c *gin.Context, _ int) (*relaymodel.ImageRequest, error) {
@@ -32,9 +36,6 @@ func getImageRequest(c *gin.Context, _ int) (*relaymodel.ImageRequest, error) {
 	if imageRequest.N == 0 {
 		imageRequest.N = 1
 	}
-	if imageRequest.Size == "" {
-		imageRequest.Size = "1024x1024"
-	}
 	if imageRequest.Model == "" {
 		imageRequest.Model = "dall-e-2"
 	}
@@ -49,6 +50,15 @@ func isValidImageSize(model string, size string) bool {
 	return ok
 }
 
+func isValidImageQuality(model string, quality string) bool {
+	qualityRatios, ok := billingratio.ImageQualityRatios[model]
+	if !ok {
+		return true
+	}
+	_, ok = qualityRatios[quality]
+	return ok
+}
+
 func isValidImagePromptLength(model string, promptLength int) bool {
 	maxPromptLength, ok := billingratio.ImagePromptLengthLimitations[model]
 	return !ok || promptLength <= maxPromptLength
@@ -77,6 +87,10 @@ func validateImageRequest(imageRequest *relaymodel.ImageRequest, _ *meta.Meta) *
 		return openai.ErrorWrapper(errors.New("size not supported for this image model"), "size_not_supported", http.StatusBadRequest)
 	}
 
+	if !isValidImageQuality(imageRequest.Model, imageRequest.Quality) {
+		return openai.ErrorWrapper(errors.New("quality not supported for this image model"), "quality_not_supported", http.StatusBadRequest)
+	}
+
 	if !isValidImagePromptLength(imageRequest.Model, len(imageRequest.Prompt)) {
 		return openai.ErrorWrapper(errors.New("prompt is too long"), "prompt_too_long", http.StatusBadRequest)
 	}
@@ -92,15 +106,26 @@ func getImageCostRatio(imageRequest *relaymodel.ImageRequest) (float64, error) {
 	if imageRequest == nil {
 		return 0, errors.New("imageRequest is nil")
 	}
-	imageCostRatio := getImageSizeRatio(imageRequest.Model, imageRequest.Size)
-	if imageRequest.Quality == "hd" && imageRequest.Model == "dall-e-3" {
-		if imageRequest.Size == "1024x1024" {
-			imageCostRatio *= 2
-		} else {
-			imageCostRatio *= 1.5
+	if qualityRatios, ok := billingratio.ImageQualityRatios[imageRequest.Model]; ok {
+		if ratio, ok := qualityRatios[imageRequest.Quality][imageRequest.Size]; ok {
+			return ratio, nil
 		}
 	}
-	return imageCostRatio, nil
+	return getImageSizeRatio(imageRequest.Model, imageRequest.Size), nil
+}
+
+// setImageDefaults sets the size and quality the model uses when the request has none,
+// for the billing
+func setImageDefaults(imageRequest *relaymodel.ImageRequest) {
+	if imageRequest.Size == "" {
+		imageRequest.Size = "1024x1024"
+		if size, ok := billingratio.ImageDefaultSizes[imageRequest.Model]; ok {
+			imageRequest.Size = size
+		}
+	}
+	if imageRequest.Quality == "" {
+		imageRequest.Quality = billingratio.ImageDefaultQualities[imageRequest.Model]
+	}
 }
 
 func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatusCode {
@@ -117,6 +142,7 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	meta.OriginModelName = imageRequest.Model
 	imageRequest.Model, isModelMapped = getMappedModelName(imageRequest.Model, meta.ModelMapping)
 	meta.ActualModelName = imageRequest.Model
+	setImageDefaults(imageRequest)
 
 	// model validation
 	bizErr := validateImageRequest(imageRequest, meta)
@@ -135,7 +161,25 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	c.Set("response_format", imageRequest.ResponseFormat)
 
 	var requestBody io.Reader
-	if isModelMapped || meta.ChannelType == channeltype.Azure { // make Azure channel request body
+	if relayMode == relaymode.ImagesEdits {
+		// the images to edit are relayed as sent, only the model is replaced
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+		}
+		if isModelMapped {
+			contentType := c.Request.Header.Get("Content-Type")
+			if strings.HasPrefix(contentType, "application/json") {
+				body, err = setJSONField(body, "model", imageRequest.Model)
+			} else {
+				body, err = setMultipartField(body, contentType, "model", imageRequest.Model)
+			}
+			if err != nil {
+				return openai.ErrorWrapper(err, "map_model_failed", http.StatusInternalServerError)
+			}
+		}
+		requestBody = bytes.NewReader(body)
+	} else if isModelMapped || meta.ChannelType == channeltype.Azure { // make Azure channel request body
 		jsonStr, err := json.Marshal(imageRequest)
 		if err != nil {
 			return openai.ErrorWrapper(err, "marshal_image_request_failed", http.StatusInternalServerError)
@@ -171,27 +215,26 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	modelRatio := billingratio.GetModelRatio(imageModel, meta.ChannelType)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
 	ratio := modelRatio * groupRatio
-	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	userQuota, err := model.CacheGetAccountQuota(ctx, meta.UserId, meta.OrganizationId)
 
-	var quota int64
-	switch meta.ChannelType {
-	case channeltype.Replicate:
+	imageCount := imageRequest.N
+	if meta.ChannelType == channeltype.Replicate {
 		// replicate always return 1 image
-		quota = int64(ratio * imageCostRatio * 1000)
-	default:
-		quota = int64(ratio*imageCostRatio*1000) * int64(imageRequest.N)
+		imageCount = 1
 	}
+	quota := int64(ratio*imageCostRatio*1000) * int64(imageCount)
 
 	if userQuota-quota < 0 {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
 
 	// do request
-	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	resp, err := doRequest(c, meta, adaptor, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
-		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		return doRequestError(c, err)
 	}
+	throttleChannel(c.Request.Context(), meta, resp)
 
 	defer func(ctx context.Context) {
 		if resp != nil &&
@@ -200,6 +243,12 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 			return
 		}
 
+		operation := "generations"
+		if relayMode == relaymode.ImagesEdits {
+			operation = "edits"
+		}
+		monitor.GetMetricsCollector().RecordImages(imageRequest.Model, operation, imageCount)
+
 		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
 		if err != nil {
 			logger.SysError("error consuming token remain quota: " + err.Error())
@@ -219,6 +268,8 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 				ModelName:        imageRequest.Model,
 				TokenName:        tokenName,
 				Quota:            int(quota),
+				UpstreamQuota:    int(billing.UpstreamQuota(meta, imageRequest.Model, imageCostRatio*1000*float64(imageCount))),
+				ImageCount:       imageCount,
 				Content:          logContent,
 				// Model mapping transparency
 				VirtualModel:     meta.OriginModelName,
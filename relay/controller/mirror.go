@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// RelayMirrorText relays a copy of a text request to the channel of the context like
+// RelayTextHelper, without the caches, the TPM limits nor the billing. modelName replaces
+// the requested model when it is not empty. The response is written to the context, to be
+// discarded.
+func RelayMirrorText(c *gin.Context, modelName string) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	textRequest, err := getAndValidateTextRequest(c, meta.Mode)
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
+	}
+	meta.IsStream = textRequest.Stream
+	if modelName != "" {
+		textRequest.Model = modelName
+	}
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	setSystemPrompt(ctx, textRequest, meta.ForcedSystemPrompt)
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+	requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
+	if err != nil {
+		return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	if err != nil {
+		return doRequestError(c, err)
+	}
+	if isErrorHappened(meta, resp) {
+		return RelayErrorHandler(resp)
+	}
+	_, respErr := adaptor.DoResponse(c, resp, meta)
+	return respErr
+}
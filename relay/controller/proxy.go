@@ -24,10 +24,10 @@ func RelayProxyHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	}
 	adaptor.Init(meta)
 
-	resp, err := adaptor.DoRequest(c, meta, c.Request.Body)
+	resp, err := doRequest(c, meta, adaptor, c.Request.Body)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
-		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		return doRequestError(c, err)
 	}
 
 	// do response
@@ -0,0 +1,387 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// responsesRequestContext is a responses request with its input, the items of the
+// previous responses included
+type responsesRequestContext struct {
+	request  *openai.ResponsesRequest
+	items    []openai.ResponsesInputItem
+	expanded bool // previous_response_id was replaced by the stored input and output
+	id       string
+}
+
+// RelayResponsesHelper relays a request of the responses API, natively to the OpenAI
+// channels and as a chat completion to the others
+func RelayResponsesHelper(c *gin.Context) *model.ErrorWithStatusCode {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	var request openai.ResponsesRequest
+	if err = json.Unmarshal(requestBody, &request); err != nil {
+		return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	if request.Model == "" {
+		return openai.ErrorWrapper(errors.New("model is required"), "invalid_responses_request", http.StatusBadRequest)
+	}
+	if request.Background && !request.Stored() {
+		return openai.ErrorWrapper(errors.New("background responses must be stored"), "invalid_responses_request", http.StatusBadRequest)
+	}
+	if request.Background && request.Stream {
+		return openai.ErrorWrapper(errors.New("background responses can not be streamed"), "invalid_responses_request", http.StatusBadRequest)
+	}
+	items, err := request.InputItems()
+	if err != nil {
+		return openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	requestContext := &responsesRequestContext{request: &request, items: items, id: openai.NewResponsesId()}
+	native := c.GetInt(ctxkey.Channel) == channeltype.OpenAI
+	if request.PreviousResponseId != "" {
+		previous, err := dbmodel.GetStoredResponse(request.PreviousResponseId, c.GetInt(ctxkey.Id))
+		if err == nil {
+			history, err := previousResponseItems(previous)
+			if err != nil {
+				return openai.ErrorWrapper(err, "invalid_previous_response", http.StatusBadRequest)
+			}
+			requestContext.items = append(history, items...)
+			requestContext.expanded = true
+		} else if !native {
+			// an OpenAI channel may know the responses made without the gateway
+			return openai.ErrorWrapper(fmt.Errorf("previous response %s not found", request.PreviousResponseId), "previous_response_not_found", http.StatusNotFound)
+		}
+	}
+	if request.Background {
+		return relayResponsesInBackground(c, requestContext)
+	}
+	response, bizErr := relayResponses(c, requestContext)
+	if bizErr != nil || response == nil || !request.Stored() {
+		return bizErr
+	}
+	storeResponse(c, requestContext, response)
+	return nil
+}
+
+// previousResponseItems returns the input and output of a stored response as input items
+func previousResponseItems(previous *dbmodel.StoredResponse) ([]openai.ResponsesInputItem, error) {
+	if !previous.Finished() {
+		return nil, fmt.Errorf("previous response %s is %s", previous.Id, previous.Status)
+	}
+	var items []openai.ResponsesInputItem
+	if err := json.Unmarshal([]byte(previous.Input), &items); err != nil {
+		return nil, err
+	}
+	var response struct {
+		Output []openai.ResponsesInputItem `json:"output"`
+	}
+	if err := json.Unmarshal([]byte(previous.Response), &response); err != nil {
+		return nil, err
+	}
+	for _, item := range response.Output {
+		// reasoning items can only be resent to the provider which made them
+		if item.Type != "reasoning" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// relayResponses returns the response object, nil when an error was already sent
+func relayResponses(c *gin.Context, requestContext *responsesRequestContext) ([]byte, *model.ErrorWithStatusCode) {
+	if c.GetInt(ctxkey.Channel) == channeltype.OpenAI {
+		return relayResponsesNatively(c, requestContext)
+	}
+	response, bizErr := relayResponsesByChat(c, requestContext)
+	if bizErr != nil || response == nil {
+		return nil, bizErr
+	}
+	payload, err := json.Marshal(response)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "marshal_response_failed", http.StatusInternalServerError)
+	}
+	return payload, nil
+}
+
+func storeResponse(c *gin.Context, requestContext *responsesRequestContext, response []byte) {
+	ctx := c.Request.Context()
+	var object struct {
+		Id     string `json:"id"`
+		Status string `json:"status"`
+	}
+	_ = json.Unmarshal(response, &object)
+	input, err := json.Marshal(requestContext.items)
+	if err != nil {
+		logger.Errorf(ctx, "failed to marshal the input of response %s: %s", object.Id, err.Error())
+		return
+	}
+	stored := dbmodel.StoredResponse{
+		Id:       object.Id,
+		UserId:   c.GetInt(ctxkey.Id),
+		Status:   object.Status,
+		Input:    string(input),
+		Response: string(response),
+	}
+	if err = stored.Insert(); err != nil {
+		logger.Errorf(ctx, "failed to store response %s: %s", object.Id, err.Error())
+	}
+}
+
+// relayResponsesByChat relays the request as a chat completion, the text relay writes
+// the chat completion to a writer converting it to a response
+func relayResponsesByChat(c *gin.Context, requestContext *responsesRequestContext) (*openai.ResponsesResponse, *model.ErrorWithStatusCode) {
+	chatRequest, err := openai.ConvertResponsesRequest(requestContext.request, requestContext.items)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusBadRequest)
+	}
+	chatBody, err := json.Marshal(chatRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	requestBody, _ := common.GetRequestBody(c)
+	path := c.Request.URL.Path
+	c.Set(ctxkey.KeyRequestBody, chatBody)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(chatBody))
+	// the relay mode and the upstream urls are derived from the path
+	c.Request.URL.Path = "/v1/chat/completions"
+	writer := openai.NewResponsesWriter(c.Writer, openai.NewResponsesResponse(requestContext.id, requestContext.request))
+	c.Writer = writer
+	defer func() {
+		// restored for the retries
+		c.Writer = writer.ResponseWriter
+		c.Set(ctxkey.KeyRequestBody, requestBody)
+		c.Request.URL.Path = path
+	}()
+	if bizErr := RelayTextHelper(c); bizErr != nil {
+		return nil, bizErr
+	}
+	writer.Finish()
+	return writer.Response(), nil
+}
+
+// relayResponsesNatively passes the request to an OpenAI channel, the model mapped and
+// the previous response expanded if it is stored by the gateway
+func relayResponsesNatively(c *gin.Context, requestContext *responsesRequestContext) ([]byte, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	request := requestContext.request
+	meta.IsStream = request.Stream
+	meta.OriginModelName = request.Model
+	meta.ActualModelName, _ = getMappedModelName(request.Model, meta.ModelMapping)
+
+	requestBody, _ := common.GetRequestBody(c)
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(requestBody, &body); err != nil {
+		return nil, openai.ErrorWrapper(err, "invalid_responses_request", http.StatusBadRequest)
+	}
+	body["model"], _ = json.Marshal(meta.ActualModelName)
+	delete(body, "background") // run by the gateway
+	if requestContext.expanded {
+		body["input"], _ = json.Marshal(requestContext.items)
+		delete(body, "previous_response_id")
+	}
+	upstreamBody, err := json.Marshal(body)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "marshal_request_failed", http.StatusInternalServerError)
+	}
+
+	// the text request carries the billed model and the pre-consumed completion
+	textRequest := &model.GeneralOpenAIRequest{Model: meta.ActualModelName, MaxTokens: request.MaxOutputTokens}
+	modelRatio := billingratio.GetModelRatio(meta.ActualModelName, meta.ChannelType)
+	groupRatio := billingratio.GetGroupRatio(meta.Group)
+	ratio := modelRatio * groupRatio * deferredRatio(meta)
+	input, _ := json.Marshal(requestContext.items)
+	meta.PromptTokens = openai.CountTokenText(request.Instructions+string(input), meta.ActualModelName)
+	if bizErr := checkContextLength(meta, meta.PromptTokens, request.MaxOutputTokens); bizErr != nil {
+		return nil, bizErr
+	}
+	if bizErr := admitTPM(c, meta, meta.PromptTokens); bizErr != nil {
+		return nil, bizErr
+	}
+	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, meta.PromptTokens, ratio, meta)
+	if bizErr != nil {
+		billing.SettleTPM(meta, 0)
+		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
+		return nil, bizErr
+	}
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+	resp, err := doRequest(c, meta, adaptor, bytes.NewBuffer(upstreamBody))
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return nil, doRequestError(c, err)
+	}
+	if isErrorHappened(meta, resp) {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		throttleChannel(c.Request.Context(), meta, resp)
+		return nil, RelayErrorHandler(resp)
+	}
+	var response []byte
+	var usage *model.Usage
+	if meta.IsStream {
+		bizErr, response, usage = openai.ResponsesStreamHandler(c, resp)
+	} else {
+		bizErr, response, usage = openai.ResponsesHandler(c, resp)
+	}
+	if bizErr != nil {
+		logger.Errorf(ctx, "respErr is not nil: %+v", bizErr)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return nil, bizErr
+	}
+	if usage == nil {
+		usage = &model.Usage{PromptTokens: meta.PromptTokens}
+	}
+	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, false)
+	return response, nil
+}
+
+// relayResponsesInBackground answers with the queued response and relays the request
+// with a copy of the context, the outcome is saved to the stored response
+func relayResponsesInBackground(c *gin.Context, requestContext *responsesRequestContext) *model.ErrorWithStatusCode {
+	queued := openai.NewResponsesResponse(requestContext.id, requestContext.request)
+	queued.Status = dbmodel.StoredResponseStatusQueued
+	payload, err := json.Marshal(queued)
+	if err != nil {
+		return openai.ErrorWrapper(err, "marshal_response_failed", http.StatusInternalServerError)
+	}
+	input, err := json.Marshal(requestContext.items)
+	if err != nil {
+		return openai.ErrorWrapper(err, "marshal_input_failed", http.StatusInternalServerError)
+	}
+	stored := dbmodel.StoredResponse{
+		Id:       requestContext.id,
+		UserId:   c.GetInt(ctxkey.Id),
+		Status:   dbmodel.StoredResponseStatusQueued,
+		Input:    string(input),
+		Response: string(payload),
+	}
+	if err = stored.Insert(); err != nil {
+		return openai.ErrorWrapper(err, "store_response_failed", http.StatusInternalServerError)
+	}
+
+	requestBody, _ := common.GetRequestBody(c)
+	background := c.Copy()
+	ctx := helper.SetRequestID(context.Background(), c.GetString(helper.RequestIdKey))
+	background.Request = c.Request.Clone(ctx)
+	background.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	background.Writer = &backgroundWriter{header: http.Header{}, status: http.StatusOK}
+	go func() {
+		status := dbmodel.StoredResponseStatusFailed
+		result := payload
+		response, bizErr := relayResponses(background, requestContext)
+		if bizErr != nil {
+			logger.Errorf(ctx, "background response %s failed: %s", requestContext.id, bizErr.Message)
+			queued.Status = status
+			queued.Error = &openai.ResponsesError{Code: "server_error", Message: bizErr.Message}
+			result, _ = json.Marshal(queued)
+		} else if response != nil {
+			result = backgroundResult(response, requestContext.id)
+			var object struct {
+				Status string `json:"status"`
+			}
+			_ = json.Unmarshal(result, &object)
+			status = object.Status
+		}
+		if err := dbmodel.FinishStoredResponse(requestContext.id, status, string(result)); err != nil {
+			logger.Errorf(ctx, "failed to save background response %s: %s", requestContext.id, err.Error())
+		}
+	}()
+	c.JSON(http.StatusOK, queued)
+	return nil
+}
+
+// backgroundResult gives the upstream response the id returned to the client
+func backgroundResult(response []byte, id string) []byte {
+	var object map[string]any
+	if err := json.Unmarshal(response, &object); err != nil {
+		return response
+	}
+	object["id"] = id
+	object["background"] = true
+	result, err := json.Marshal(object)
+	if err != nil {
+		return response
+	}
+	return result
+}
+
+// backgroundWriter discards the output of a background relay
+type backgroundWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *backgroundWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *backgroundWriter) Write(data []byte) (int, error) {
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *backgroundWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *backgroundWriter) WriteHeader(statusCode int) {
+	w.status = statusCode
+}
+
+func (w *backgroundWriter) WriteHeaderNow() {}
+
+func (w *backgroundWriter) Status() int {
+	return w.status
+}
+
+func (w *backgroundWriter) Size() int {
+	return w.size
+}
+
+func (w *backgroundWriter) Written() bool {
+	return w.size > 0
+}
+
+func (w *backgroundWriter) Flush() {}
+
+func (w *backgroundWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *backgroundWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("a background response can not be hijacked")
+}
+
+func (w *backgroundWriter) Pusher() http.Pusher {
+	return nil
+}
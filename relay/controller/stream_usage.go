@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// The adaptors of the other vendors mostly stream no usage chunk, even when the client asks
+// for it with stream_options.include_usage. The usageChunkWriter holds back the end of their
+// stream, the relay then sends the usage it bills in a last chunk before it, as OpenAI does.
+// The OpenAI adaptor sends the chunk itself.
+
+// injectsUsageChunk tells whether the relay sends the usage chunk of a stream
+func injectsUsageChunk(c *gin.Context, meta *meta.Meta) bool {
+	return meta.IsStream && meta.Mode == relaymode.ChatCompletions && meta.APIType != apitype.OpenAI && openai.ClientIncludesUsage(c)
+}
+
+type usageChunkWriter struct {
+	gin.ResponseWriter
+	pending   []byte // partial SSE event
+	lastChunk openai.ChatCompletionsStreamResponse
+	text      strings.Builder // the content streamed, counted when the adaptor returns no usage
+	hasUsage  bool            // the adaptor sent a usage chunk
+	done      bool
+}
+
+func newUsageChunkWriter(w gin.ResponseWriter) *usageChunkWriter {
+	return &usageChunkWriter{ResponseWriter: w}
+}
+
+func (w *usageChunkWriter) Write(data []byte) (int, error) {
+	w.pending = append(w.pending, data...)
+	for {
+		end := bytes.Index(w.pending, []byte("\n\n"))
+		if end < 0 {
+			break
+		}
+		event := w.pending[:end+2]
+		w.pending = w.pending[end+2:]
+		if err := w.handleEvent(event); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (w *usageChunkWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *usageChunkWriter) handleEvent(event []byte) error {
+	data := strings.TrimSpace(string(event))
+	if strings.HasPrefix(data, "data:") {
+		data = strings.TrimSpace(strings.TrimPrefix(data, "data:"))
+		if data == "[DONE]" {
+			w.done = true
+			return nil
+		}
+		var chunk openai.ChatCompletionsStreamResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err == nil {
+			if chunk.Usage != nil {
+				w.hasUsage = true
+			}
+			if chunk.Id != "" {
+				w.lastChunk.Id, w.lastChunk.Object, w.lastChunk.Created, w.lastChunk.Model = chunk.Id, chunk.Object, chunk.Created, chunk.Model
+			}
+			for _, choice := range chunk.Choices {
+				w.text.WriteString(conv.AsString(choice.Delta.Content))
+			}
+		}
+	}
+	_, err := w.ResponseWriter.Write(event)
+	return err
+}
+
+// streamedUsage counts the usage of the content streamed
+func (w *usageChunkWriter) streamedUsage(meta *meta.Meta) *model.Usage {
+	return openai.ResponseText2Usage(w.text.String(), meta.ActualModelName, meta.PromptTokens)
+}
+
+// finish sends the usage chunk, unless the adaptor sent one or usage is nil, then the end of
+// the stream held back
+func (w *usageChunkWriter) finish(usage *model.Usage) {
+	if len(w.pending) > 0 {
+		_, _ = w.ResponseWriter.Write(w.pending)
+		w.pending = nil
+	}
+	if !w.hasUsage && usage != nil {
+		chunk, err := json.Marshal(openai.UsageChunk(&w.lastChunk, usage))
+		if err == nil {
+			_, err = w.ResponseWriter.WriteString("data: " + string(chunk) + "\n\n")
+		}
+		if err != nil {
+			logger.SysError("error rendering usage chunk: " + err.Error())
+		}
+	}
+	if w.done {
+		_, _ = w.ResponseWriter.WriteString("data: [DONE]\n\n")
+	}
+	w.ResponseWriter.Flush()
+}
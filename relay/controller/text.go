@@ -3,6 +3,7 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,7 +12,9 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/trace"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
@@ -44,11 +47,13 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	
 	// 1. Check exact match cache first (fastest)
 	if config.ResponseCacheEnabled {
-		if cached, found := cache.GetCache().CheckCache(meta.OriginModelName, textRequest.Messages); found {
+		if cached, found := cache.GetCache().CheckCache(meta.TenantId, meta.OriginModelName, textRequest.Messages); found {
 			logger.Infof(ctx, "[EXACT CACHE HIT] model=%s stream=%v", meta.OriginModelName, meta.IsStream)
+			trace.Record(ctx, trace.StageCache, 0, "exact cache hit", nil)
 			
 			if meta.IsStream {
 				if err := cache.ReplayCachedStream(c, cached); err == nil {
+					recordCacheHitLog(ctx, meta, "exact")
 					return nil
 				}
 				// Fall through on error
@@ -75,6 +80,7 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 							"total_tokens":      0,
 						},
 					})
+					recordCacheHitLog(ctx, meta, "exact")
 					return nil
 				}
 				// Empty content - fall through
@@ -84,11 +90,13 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	
 	// 2. Check semantic cache (similarity-based)
 	if config.SemanticCacheEnabled {
-		if cached, score, found := cache.GetSemanticCache().CheckSemantic(meta.OriginModelName, textRequest.Messages); found {
+		if cached, score, found := cache.GetSemanticCache().CheckSemantic(meta.TenantId, meta.OriginModelName, textRequest.Messages); found {
 			logger.Infof(ctx, "[SEMANTIC CACHE HIT] model=%s score=%.3f stream=%v", meta.OriginModelName, score, meta.IsStream)
+			trace.Record(ctx, trace.StageCache, 0, "semantic cache hit", map[string]any{"score": score})
 			
 			if meta.IsStream {
 				if err := cache.ReplayCachedStream(c, cached); err == nil {
+					recordCacheHitLog(ctx, meta, "semantic")
 					return nil
 				}
 				// Fall through on error
@@ -116,6 +124,7 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 							"total_tokens":      0,
 						},
 					})
+					recordCacheHitLog(ctx, meta, "semantic")
 					return nil
 				}
 				// Empty content - fall through
@@ -123,17 +132,70 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		}
 	}
 
+	if config.ResponseCacheEnabled || config.SemanticCacheEnabled {
+		trace.Record(ctx, trace.StageCache, 0, "no cached response", map[string]any{
+			"exact":    config.ResponseCacheEnabled,
+			"semantic": config.SemanticCacheEnabled,
+		})
+	}
+
+	// 3. Coalesce with an identical request in flight
+	if config.RequestCoalescingEnabled {
+		flight, leader := cache.JoinFlight(cache.FlightKey(meta.TenantId, meta.Mode, textRequest))
+		if !leader {
+			trace.Record(ctx, trace.StageCache, 0, "waiting for an identical request in flight", nil)
+			followed, err := flight.Follow(c)
+			if followed {
+				if err != nil {
+					logger.Warnf(ctx, "failed to send the coalesced response: %s", err.Error())
+				}
+				recordCacheHitLog(ctx, meta, "coalesced")
+				return nil
+			}
+			if err != nil {
+				return openai.ErrorWrapper(err, "request_cancelled", http.StatusRequestTimeout)
+			}
+			// the leader failed, the request is relayed on its own
+			trace.Record(ctx, trace.StageCache, 0, "the identical request failed, relaying on its own", nil)
+		} else {
+			writer := flight.Writer(c.Writer)
+			c.Writer = writer
+			bizErr := relayText(c, meta, textRequest)
+			if c.Writer == writer {
+				c.Writer = writer.ResponseWriter
+			}
+			flight.Finish(bizErr == nil)
+			return bizErr
+		}
+	}
+	return relayText(c, meta, textRequest)
+}
+
+// relayText relays a text request which was not served from the caches
+func relayText(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) *model.ErrorWithStatusCode {
+	ctx := c.Request.Context()
 	// set system prompt if not empty
 	systemPromptReset := setSystemPrompt(ctx, textRequest, meta.ForcedSystemPrompt)
+	meta.ContextTrimmed = trimContext(c, meta, textRequest)
 	// get model ratio & group ratio
 	modelRatio := billingratio.GetModelRatio(textRequest.Model, meta.ChannelType)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
-	ratio := modelRatio * groupRatio
+	ratio := modelRatio * groupRatio * deferredRatio(meta)
 	// pre-consume quota
 	promptTokens := getPromptTokens(textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
+	if bizErr := checkContextLength(meta, promptTokens, maxCompletionTokens(textRequest)); bizErr != nil {
+		return bizErr
+	}
+	if bizErr := checkChoices(meta, textRequest); bizErr != nil {
+		return bizErr
+	}
+	if bizErr := admitTPM(c, meta, promptTokens); bizErr != nil {
+		return bizErr
+	}
 	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
 	if bizErr != nil {
+		billing.SettleTPM(meta, 0)
 		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
 		return bizErr
 	}
@@ -144,6 +206,17 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	}
 	adaptor.Init(meta)
 
+	if emulatesChoices(meta, textRequest) {
+		usage, respErr := relayChoices(c, meta, textRequest, adaptor)
+		if respErr != nil {
+			logger.Errorf(ctx, "relayChoices failed: %+v", respErr)
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			return respErr
+		}
+		go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio, systemPromptReset)
+		return nil
+	}
+
 	// get request body
 	requestBody, err := getRequestBody(c, meta, textRequest, adaptor)
 	if err != nil {
@@ -151,13 +224,15 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	}
 
 	// do request
-	resp, err := adaptor.DoRequest(c, meta, requestBody)
+	resp, err := doRequest(c, meta, adaptor, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
-		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		return doRequestError(c, err)
 	}
 	if isErrorHappened(meta, resp) {
-		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+		throttleChannel(c.Request.Context(), meta, resp)
 		return RelayErrorHandler(resp)
 	}
 
@@ -167,10 +242,10 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	
 	if config.ResponseCacheEnabled && meta.IsStream {
 		// Capture streaming response for caching
-		cachedStream, tokens, err := cache.CaptureAndCacheStream(c, resp, meta.ActualModelName, textRequest.Messages)
+		cachedStream, tokens, err := cache.CaptureAndCacheStream(c, resp, meta.TenantId, meta.ActualModelName, textRequest.Messages)
 		if err != nil {
 			logger.Errorf(ctx, "Failed to capture stream: %s", err.Error())
-			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
 			return openai.ErrorWrapper(err, "stream_capture_failed", http.StatusInternalServerError)
 		}
 		
@@ -182,6 +257,7 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		// Also store in semantic cache for similarity matching
 		if config.SemanticCacheEnabled {
 			go cache.GetSemanticCache().StoreSemantic(
+				meta.TenantId,
 				meta.OriginModelName, 
 				textRequest.Messages,
 				cachedStream,
@@ -192,12 +268,28 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 		logger.Infof(ctx, "[CACHE STORE] model=%s stream=true cached=%d bytes", meta.ActualModelName, len(cachedStream))
 	} else {
 		// Normal non-streaming response
+		var usageWriter *usageChunkWriter
+		if injectsUsageChunk(c, meta) {
+			usageWriter = newUsageChunkWriter(c.Writer)
+			c.Writer = usageWriter
+		}
 		usage, respErr = adaptor.DoResponse(c, resp, meta)
+		if usageWriter != nil {
+			c.Writer = usageWriter.ResponseWriter
+			if respErr == nil && usage == nil {
+				usage = usageWriter.streamedUsage(meta)
+			}
+			usageWriter.finish(usage)
+		}
 		if respErr != nil {
 			logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
-			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta)
+			if timedOut(c) {
+				return openai.ErrorWrapper(errors.New("the request exceeded its timeout"), "request_timeout", http.StatusGatewayTimeout)
+			}
 			return respErr
 		}
+		meta.PartialCompletion = c.GetBool(ctxkey.PartialCompletion)
 		
 		// Cache non-streaming response
 		if config.ResponseCacheEnabled && usage != nil {
@@ -213,11 +305,14 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 }
 
 func getRequestBody(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest, adaptor adaptor.Adaptor) (io.Reader, error) {
-	if !config.EnforceIncludeUsage &&
+	translated := translateParameters(c, meta, textRequest)
+	if !translated &&
+		!config.EnforceIncludeUsage &&
 		meta.APIType == apitype.OpenAI &&
 		meta.OriginModelName == meta.ActualModelName &&
 		meta.ChannelType != channeltype.Baichuan &&
-		meta.ForcedSystemPrompt == "" {
+		meta.ForcedSystemPrompt == "" &&
+		meta.ContextTrimmed == 0 {
 		// no need to convert request for openai
 		return c.Request.Body, nil
 	}
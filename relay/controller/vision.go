@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	imageutil "github.com/songquanpeng/one-api/common/image"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+)
+
+// visionLimit is how a provider takes the images of the messages
+type visionLimit struct {
+	maxDimension int  // of the longest side, larger images are downscaled
+	inline       bool // the images are sent as data urls, the provider does not fetch them
+}
+
+var defaultVisionLimit = visionLimit{maxDimension: 2048}
+
+// maxImagePixels is the size of the largest image decoded to be downscaled, a small file may
+// claim a huge size and take gigabytes once decoded
+const maxImagePixels = 50_000_000
+
+var visionLimits = map[int]visionLimit{
+	channeltype.Anthropic: {maxDimension: 1568, inline: true},
+	channeltype.AwsClaude: {maxDimension: 1568, inline: true},
+	channeltype.VertextAI: {maxDimension: 1568, inline: true},
+	channeltype.Gemini:    {maxDimension: 3072, inline: true},
+	channeltype.Ollama:    {maxDimension: 2048, inline: true},
+}
+
+func visionLimitOf(channelType int) visionLimit {
+	if limit, ok := visionLimits[channelType]; ok {
+		return limit
+	}
+	return defaultVisionLimit
+}
+
+// preprocessImages fetches the images of the messages of a chat completion, rejects the
+// requests over VisionMaxImages or VisionMaxImageSize, downscales the images larger than the
+// provider of the channel takes and inlines them as data urls where the provider needs it. The
+// prompt tokens of the images are then counted from the images sent.
+func preprocessImages(c *gin.Context) error {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(requestBody, []byte(`"image_url"`)) {
+		return nil
+	}
+	var request map[string]any
+	if err = json.Unmarshal(requestBody, &request); err != nil {
+		// left to the validation of the request
+		return nil
+	}
+	limit := visionLimitOf(c.GetInt(ctxkey.Channel))
+	maxBytes := int64(config.VisionMaxImageSize) << 20
+	count := 0
+	changed := false
+	messages, _ := request["messages"].([]any)
+	for _, message := range messages {
+		messageMap, _ := message.(map[string]any)
+		parts, _ := messageMap["content"].([]any)
+		for _, part := range parts {
+			partMap, _ := part.(map[string]any)
+			if partMap["type"] != "image_url" {
+				continue
+			}
+			imageURL, _ := partMap["image_url"].(map[string]any)
+			url, _ := imageURL["url"].(string)
+			if url == "" {
+				continue
+			}
+			if count++; config.VisionMaxImages > 0 && count > config.VisionMaxImages {
+				return fmt.Errorf("too many images, at most %d images are accepted", config.VisionMaxImages)
+			}
+			processed, err := preprocessImage(url, limit, maxBytes)
+			if err != nil {
+				return fmt.Errorf("image %d: %w", count, err)
+			}
+			if processed != url {
+				imageURL["url"] = processed
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	requestBody, err = json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	c.Set(ctxkey.KeyRequestBody, requestBody)
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	c.Request.ContentLength = int64(len(requestBody))
+	return nil
+}
+
+// preprocessImage returns the url of an image to send to the provider
+func preprocessImage(url string, limit visionLimit, maxBytes int64) (string, error) {
+	data, mimeType, err := imageutil.Fetch(url, maxBytes)
+	if err != nil {
+		return "", err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("unsupported image: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return "", fmt.Errorf("image of %dx%d pixels is too large, at most %d pixels are accepted", cfg.Width, cfg.Height, maxImagePixels)
+	}
+	if cfg.Width <= limit.maxDimension && cfg.Height <= limit.maxDimension {
+		if limit.inline && !strings.HasPrefix(url, "data:") {
+			return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+		}
+		return url, nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("unsupported image: %w", err)
+	}
+	img, _ = imageutil.Downscale(img, limit.maxDimension)
+	data, mimeType, err = imageutil.Encode(img, format)
+	if err != nil {
+		return "", err
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngDataURL returns a tiny PNG whose header claims width x height pixels
+func pngDataURL(t *testing.T, width uint32, height uint32) string {
+	var buffer bytes.Buffer
+	require.NoError(t, png.Encode(&buffer, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buffer.Bytes()
+	// the IHDR chunk follows the 8 bytes of the signature: length, type, width, height...
+	ihdr := data[8+4 : 8+4+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:8], width)
+	binary.BigEndian.PutUint32(ihdr[8:12], height)
+	binary.BigEndian.PutUint32(data[8+4+4+13:], crc32.ChecksumIEEE(ihdr))
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestPreprocessImageRejectsDecompressionBombs(t *testing.T) {
+	limit := visionLimit{maxDimension: 1568, inline: true}
+	_, err := preprocessImage(pngDataURL(t, 100000, 100000), limit, 1<<20)
+	assert.ErrorContains(t, err, "too large")
+
+	url := pngDataURL(t, 1, 1)
+	processed, err := preprocessImage(url, limit, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, url, processed)
+}
@@ -20,6 +20,7 @@ type Meta struct {
 	TokenName    string
 	UserId       int
 	Group        string
+	TenantId     int // the tenant of the user, see model.Tenant
 	ModelMapping map[string]string
 	// BaseURL is the proxy url set in the channel config
 	BaseURL  string
@@ -35,6 +36,28 @@ type Meta struct {
 	PromptTokens       int // only for DoResponse
 	ForcedSystemPrompt string
 	StartTime          time.Time
+	// Moderation is the outcome of the moderation of the request, see model.Log
+	Moderation string
+	// OrganizationId is the organization paying for the token, 0 for a personal token
+	OrganizationId int
+	// PreConsumeJournalId is the entry of the pre-consumed quota in the journal, see model.JournalPreConsumedQuota
+	PreConsumeJournalId string
+	// TPMEstimate is the prompt tokens debited from the TPM buckets, see billing.DebitTPM
+	TPMEstimate int
+	// TPMLimit and TPMBalance are the TPM bucket of the request with the lowest balance, 0 without any
+	TPMLimit   int
+	TPMBalance int64
+	// ExperimentId and ExperimentArm are the experiment arm of the request, see model.Experiment
+	ExperimentId  int
+	ExperimentArm string
+	// ContextTrimmed is the number of the oldest messages removed to fit the context window
+	ContextTrimmed int
+	// PartialCompletion is set when the stream ended before the completion, see ctxkey.PartialCompletion
+	PartialCompletion bool
+	// EmulatedChoices is the n of a chat completion emulated with a request per choice
+	EmulatedChoices int
+	// Deferred is set for the relay of a deferred request, billed at config.DeferredPriceRatio
+	Deferred bool
 }
 
 func GetByContext(c *gin.Context) *Meta {
@@ -46,6 +69,7 @@ func GetByContext(c *gin.Context) *Meta {
 		TokenName:          c.GetString(ctxkey.TokenName),
 		UserId:             c.GetInt(ctxkey.Id),
 		Group:              c.GetString(ctxkey.Group),
+		TenantId:           c.GetInt(ctxkey.TenantId),
 		ModelMapping:       c.GetStringMapString(ctxkey.ModelMapping),
 		OriginModelName:    c.GetString(ctxkey.RequestModel),
 		BaseURL:            c.GetString(ctxkey.BaseURL),
@@ -53,6 +77,11 @@ func GetByContext(c *gin.Context) *Meta {
 		RequestURLPath:     c.Request.URL.String(),
 		ForcedSystemPrompt: c.GetString(ctxkey.SystemPrompt),
 		StartTime:          time.Now(),
+		Moderation:         c.GetString(ctxkey.Moderation),
+		OrganizationId:     c.GetInt(ctxkey.OrganizationId),
+		ExperimentId:       c.GetInt(ctxkey.ExperimentId),
+		ExperimentArm:      c.GetString(ctxkey.ExperimentArm),
+		Deferred:           model.IsDeferred(c.Request.Context()),
 	}
 	cfg, ok := c.Get(ctxkey.Config)
 	if ok {
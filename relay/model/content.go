@@ -0,0 +1,151 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The content parts of the messages are normalized to the parts of the OpenAI API, whatever
+// the format the client used: the parts of the Responses API, the blocks of Anthropic and the
+// parts of Gemini are accepted in the messages too. The adaptors convert the normalized parts
+// to the format of their provider, and the inbound APIs convert theirs to the normalized ones,
+// so a request can go to any channel whatever its format.
+
+// NormalizeContentPart converts a content part in any of the formats known to an OpenAI
+// content part, false when it is not one
+func NormalizeContentPart(part map[string]any) (MessageContent, bool) {
+	cacheControl := part["cache_control"]
+	switch part["type"] {
+	case ContentTypeText, "input_text", "output_text":
+		text, ok := part["text"].(string)
+		return MessageContent{Type: ContentTypeText, Text: text, CacheControl: cacheControl}, ok
+	case ContentTypeImageURL, "input_image":
+		imageURL := &ImageURL{}
+		switch value := part["image_url"].(type) {
+		case string:
+			imageURL.Url = value
+		case map[string]any:
+			imageURL.Url, _ = value["url"].(string)
+			imageURL.Detail, _ = value["detail"].(string)
+		}
+		if detail, ok := part["detail"].(string); ok && imageURL.Detail == "" {
+			imageURL.Detail = detail
+		}
+		if imageURL.Url == "" {
+			return MessageContent{}, false
+		}
+		return MessageContent{Type: ContentTypeImageURL, ImageURL: imageURL, CacheControl: cacheControl}, true
+	case "image":
+		// Anthropic
+		source, _ := part["source"].(map[string]any)
+		var url string
+		switch source["type"] {
+		case "base64":
+			mediaType, _ := source["media_type"].(string)
+			data, _ := source["data"].(string)
+			url = ImageDataURL(mediaType, data)
+		case "url":
+			url, _ = source["url"].(string)
+		}
+		if url == "" {
+			return MessageContent{}, false
+		}
+		return MessageContent{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: url}, CacheControl: cacheControl}, true
+	case nil:
+		// Gemini, whose parts have no type
+		if text, ok := part["text"].(string); ok {
+			return MessageContent{Type: ContentTypeText, Text: text}, true
+		}
+		if inlineData, ok := part["inlineData"].(map[string]any); ok {
+			mimeType, _ := inlineData["mimeType"].(string)
+			data, _ := inlineData["data"].(string)
+			if strings.HasPrefix(mimeType, "image/") && data != "" {
+				return MessageContent{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: ImageDataURL(mimeType, data)}}, true
+			}
+		}
+		if fileData, ok := part["fileData"].(map[string]any); ok {
+			if uri, _ := fileData["fileUri"].(string); uri != "" {
+				return MessageContent{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: uri}}, true
+			}
+		}
+	}
+	return MessageContent{}, false
+}
+
+// normalizeContent returns the normalized parts of the content of a message, which is a
+// string, a list of parts decoded from JSON, or parts built by an inbound API
+func normalizeContent(content any) []MessageContent {
+	switch content := content.(type) {
+	case string:
+		return []MessageContent{{Type: ContentTypeText, Text: content}}
+	case []MessageContent:
+		return content
+	case []any:
+		parts := make([]MessageContent, 0, len(content))
+		for _, item := range content {
+			part, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if normalized, ok := NormalizeContentPart(part); ok {
+				parts = append(parts, normalized)
+			}
+		}
+		return parts
+	case json.RawMessage:
+		var decoded any
+		if json.Unmarshal(content, &decoded) != nil {
+			return nil
+		}
+		return normalizeContent(decoded)
+	}
+	return nil
+}
+
+// ImageDataURL returns the data url of a base64 encoded image
+func ImageDataURL(mimeType string, data string) string {
+	return fmt.Sprintf("data:%s;base64,%s", mimeType, data)
+}
+
+// ParseDataURL returns the mime type and the base64 data of the image of a data url, false
+// when the url is not a data url
+func (i *ImageURL) ParseDataURL() (mimeType string, data string, ok bool) {
+	rest, found := strings.CutPrefix(i.Url, "data:")
+	if !found {
+		return "", "", false
+	}
+	header, data, found := strings.Cut(rest, ",")
+	if !found {
+		return "", "", false
+	}
+	mimeType, found = strings.CutSuffix(header, ";base64")
+	if !found {
+		return "", "", false
+	}
+	return mimeType, data, true
+}
+
+// MoveToolImages moves the images of the tool results to a user message after them, for the
+// providers which accept only text in the tool results, as OpenAI does
+func MoveToolImages(messages []Message) []Message {
+	var moved []Message
+	var images []MessageContent
+	for i, message := range messages {
+		if message.Role == "tool" && message.HasImage() {
+			for _, part := range message.ParseContent() {
+				if part.Type == ContentTypeImageURL {
+					images = append(images, part)
+				}
+			}
+			message.Content = message.StringContent()
+		}
+		moved = append(moved, message)
+		lastTool := i+1 == len(messages) || messages[i+1].Role != "tool"
+		if message.Role == "tool" && lastTool && len(images) > 0 {
+			moved = append(moved, Message{Role: "user", Content: images})
+			images = nil
+		}
+	}
+	return moved
+}
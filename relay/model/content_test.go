@@ -0,0 +1,78 @@
+package model
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parseMessage(t *testing.T, content string) Message {
+	var message Message
+	assert.Nil(t, json.Unmarshal([]byte(`{"role":"user","content":`+content+`}`), &message))
+	return message
+}
+
+func TestParseContentFormats(t *testing.T) {
+	message := parseMessage(t, `[
+		{"type":"text","text":"openai"},
+		{"type":"image_url","image_url":{"url":"https://example.com/a.png","detail":"low"}},
+		{"type":"input_text","text":"responses"},
+		{"type":"input_image","image_url":"https://example.com/b.png","detail":"high"},
+		{"type":"image","source":{"type":"base64","media_type":"image/png","data":"AAAA"}},
+		{"type":"image","source":{"type":"url","url":"https://example.com/c.png"}},
+		{"text":"gemini"},
+		{"inlineData":{"mimeType":"image/jpeg","data":"BBBB"}},
+		{"fileData":{"mimeType":"image/png","fileUri":"gs://bucket/d.png"}},
+		{"type":"image_url","image_url":{}},
+		{"type":"input_audio","input_audio":{"data":"CCCC","format":"wav"}}
+	]`)
+	parts := message.ParseContent()
+	assert.Equal(t, []MessageContent{
+		{Type: ContentTypeText, Text: "openai"},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "https://example.com/a.png", Detail: "low"}},
+		{Type: ContentTypeText, Text: "responses"},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "https://example.com/b.png", Detail: "high"}},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "data:image/png;base64,AAAA"}},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "https://example.com/c.png"}},
+		{Type: ContentTypeText, Text: "gemini"},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "data:image/jpeg;base64,BBBB"}},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "gs://bucket/d.png"}},
+	}, parts)
+	assert.Equal(t, "openairesponsesgemini", message.StringContent())
+	assert.True(t, message.HasImage())
+
+	mimeType, data, ok := parts[4].ImageURL.ParseDataURL()
+	assert.True(t, ok)
+	assert.Equal(t, "image/png", mimeType)
+	assert.Equal(t, "AAAA", data)
+	_, _, ok = parts[1].ImageURL.ParseDataURL()
+	assert.False(t, ok)
+}
+
+func TestParseContentTyped(t *testing.T) {
+	message := Message{Role: "user", Content: []MessageContent{
+		{Type: ContentTypeText, Text: "look"},
+		{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "https://example.com/a.png"}},
+	}}
+	assert.Len(t, message.ParseContent(), 2)
+	assert.Equal(t, "look", message.StringContent())
+	assert.True(t, message.HasImage())
+	assert.False(t, Message{Role: "user", Content: "look"}.HasImage())
+}
+
+func TestMoveToolImages(t *testing.T) {
+	image := MessageContent{Type: ContentTypeImageURL, ImageURL: &ImageURL{Url: "data:image/png;base64,AAAA"}}
+	messages := MoveToolImages([]Message{
+		{Role: "user", Content: "take screenshots"},
+		{Role: "assistant", ToolCalls: []Tool{{Id: "call_1"}, {Id: "call_2"}}},
+		{Role: "tool", ToolCallId: "call_1", Content: []MessageContent{{Type: ContentTypeText, Text: "first"}, image}},
+		{Role: "tool", ToolCallId: "call_2", Content: "second"},
+		{Role: "assistant", Content: "done"},
+	})
+	assert.Len(t, messages, 6)
+	assert.Equal(t, "first", messages[2].Content)
+	assert.Equal(t, "second", messages[3].Content)
+	assert.Equal(t, Message{Role: "user", Content: []MessageContent{image}}, messages[4])
+	assert.Equal(t, "done", messages[5].Content)
+}
@@ -1,12 +1,16 @@
 package model
 
 type ImageRequest struct {
-	Model          string `json:"model"`
-	Prompt         string `json:"prompt" binding:"required"`
-	N              int    `json:"n,omitempty"`
-	Size           string `json:"size,omitempty"`
-	Quality        string `json:"quality,omitempty"`
-	ResponseFormat string `json:"response_format,omitempty"`
-	Style          string `json:"style,omitempty"`
-	User           string `json:"user,omitempty"`
+	Model             string `json:"model" form:"model"`
+	Prompt            string `json:"prompt" form:"prompt" binding:"required"`
+	N                 int    `json:"n,omitempty" form:"n"`
+	Size              string `json:"size,omitempty" form:"size"`
+	Quality           string `json:"quality,omitempty" form:"quality"`
+	ResponseFormat    string `json:"response_format,omitempty" form:"response_format"`
+	Style             string `json:"style,omitempty" form:"style"`
+	Background        string `json:"background,omitempty" form:"background"`
+	Moderation        string `json:"moderation,omitempty" form:"moderation"`
+	OutputFormat      string `json:"output_format,omitempty" form:"output_format"`
+	OutputCompression *int   `json:"output_compression,omitempty" form:"output_compression"`
+	User              string `json:"user,omitempty" form:"user"`
 }
@@ -19,64 +19,32 @@ func (m Message) StringContent() string {
 	if ok {
 		return content
 	}
-	contentList, ok := m.Content.([]any)
-	if ok {
-		var contentStr string
-		for _, contentItem := range contentList {
-			contentMap, ok := contentItem.(map[string]any)
-			if !ok {
-				continue
-			}
-			if contentMap["type"] == ContentTypeText {
-				if subStr, ok := contentMap["text"].(string); ok {
-					contentStr += subStr
-				}
-			}
+	var contentStr string
+	for _, part := range normalizeContent(m.Content) {
+		if part.Type == ContentTypeText {
+			contentStr += part.Text
 		}
-		return contentStr
 	}
-	return ""
+	return contentStr
 }
 
+// ParseContent returns the parts of the content, in the format of OpenAI whatever the format
+// of the client, see NormalizeContentPart
 func (m Message) ParseContent() []MessageContent {
-	var contentList []MessageContent
-	content, ok := m.Content.(string)
-	if ok {
-		contentList = append(contentList, MessageContent{
-			Type: ContentTypeText,
-			Text: content,
-		})
-		return contentList
+	return normalizeContent(m.Content)
+}
+
+// HasImage tells whether the content has an image part
+func (m Message) HasImage() bool {
+	if m.IsStringContent() {
+		return false
 	}
-	anyList, ok := m.Content.([]any)
-	if ok {
-		for _, contentItem := range anyList {
-			contentMap, ok := contentItem.(map[string]any)
-			if !ok {
-				continue
-			}
-			switch contentMap["type"] {
-			case ContentTypeText:
-				if subStr, ok := contentMap["text"].(string); ok {
-					contentList = append(contentList, MessageContent{
-						Type: ContentTypeText,
-						Text: subStr,
-					})
-				}
-			case ContentTypeImageURL:
-				if subObj, ok := contentMap["image_url"].(map[string]any); ok {
-					contentList = append(contentList, MessageContent{
-						Type: ContentTypeImageURL,
-						ImageURL: &ImageURL{
-							Url: subObj["url"].(string),
-						},
-					})
-				}
-			}
+	for _, part := range m.ParseContent() {
+		if part.Type == ContentTypeImageURL {
+			return true
 		}
-		return contentList
 	}
-	return nil
+	return false
 }
 
 type ImageURL struct {
@@ -88,4 +56,6 @@ type MessageContent struct {
 	Type     string    `json:"type,omitempty"`
 	Text     string    `json:"text"`
 	ImageURL *ImageURL `json:"image_url,omitempty"`
+	// cache breakpoint of providers with prompt caching, e.g. {"type": "ephemeral"}
+	CacheControl any `json:"cache_control,omitempty"`
 }
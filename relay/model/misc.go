@@ -5,15 +5,64 @@ type Usage struct {
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
 
+	PromptTokensDetails     *PromptTokensDetails     `json:"prompt_tokens_details,omitempty"`
 	CompletionTokensDetails *CompletionTokensDetails `json:"completion_tokens_details,omitempty"`
 }
 
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+	AudioTokens  int `json:"audio_tokens"`
+}
+
 type CompletionTokensDetails struct {
 	ReasoningTokens          int `json:"reasoning_tokens"`
 	AcceptedPredictionTokens int `json:"accepted_prediction_tokens"`
 	RejectedPredictionTokens int `json:"rejected_prediction_tokens"`
 }
 
+// GetCachedTokens returns the prompt tokens read from the cache of the provider, which are
+// part of the prompt tokens
+func (u *Usage) GetCachedTokens() int {
+	if u.PromptTokensDetails == nil {
+		return 0
+	}
+	return u.PromptTokensDetails.CachedTokens
+}
+
+// AddCachedTokens counts cached tokens in the details of the prompt tokens, which already
+// include them
+func (u *Usage) AddCachedTokens(tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	if u.PromptTokensDetails == nil {
+		u.PromptTokensDetails = &PromptTokensDetails{}
+	}
+	u.PromptTokensDetails.CachedTokens += tokens
+}
+
+// GetReasoningTokens returns the reasoning tokens of the completion, which are part of its
+// completion tokens
+func (u *Usage) GetReasoningTokens() int {
+	if u.CompletionTokensDetails == nil {
+		return 0
+	}
+	return u.CompletionTokensDetails.ReasoningTokens
+}
+
+// AddReasoningTokens counts reasoning tokens in the completion tokens and their details
+func (u *Usage) AddReasoningTokens(tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	if u.CompletionTokensDetails == nil {
+		u.CompletionTokensDetails = &CompletionTokensDetails{}
+	}
+	u.CompletionTokensDetails.ReasoningTokens += tokens
+	u.CompletionTokens += tokens
+	u.TotalTokens += tokens
+}
+
 type Error struct {
 	Message string `json:"message"`
 	Type    string `json:"type"`
@@ -0,0 +1,183 @@
+package paramtranslate
+
+import (
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// The providers disagree on the parameters of a completion: the field of the output limit,
+// the range of the temperature and the sampling parameters they accept. The clients send the
+// parameters of the OpenAI API, they are translated here for the provider of the channel before
+// the adaptor converts the request, and the ones the provider has no equivalent for are dropped.
+
+// Names of the output limit fields
+const (
+	MaxTokens           = "max_tokens"
+	MaxCompletionTokens = "max_completion_tokens"
+)
+
+// Rule is a quirk of the providers of some channel types, or of some of their models
+type Rule struct {
+	ChannelTypes  []int    // empty for every channel type
+	ModelPrefixes []string // empty for every model
+	// MaxTokensField is the output limit field the provider reads, empty to keep the one sent
+	MaxTokensField string
+	// TemperatureMax is the top of the temperature range of the provider, the temperature sent,
+	// from 0 to 2, is scaled to it. 0 keeps it.
+	TemperatureMax float64
+	// Drop are the parameters the provider rejects or ignores
+	Drop []string
+}
+
+var samplingParameters = []string{"temperature", "top_p", "presence_penalty", "frequency_penalty", "logprobs", "top_logprobs", "logit_bias"}
+
+// Rules are applied in order, every rule matching a request applies
+var Rules = []Rule{
+	{
+		// the reasoning models of OpenAI only take the default sampling
+		ModelPrefixes:  []string{"o1", "o3", "o4", "gpt-5"},
+		MaxTokensField: MaxCompletionTokens,
+		Drop:           samplingParameters,
+	},
+	{
+		ChannelTypes:   []int{channeltype.Anthropic, channeltype.AwsClaude},
+		MaxTokensField: MaxTokens,
+		TemperatureMax: 1,
+		Drop:           []string{"presence_penalty", "frequency_penalty", "logprobs", "top_logprobs", "logit_bias", "seed"},
+	},
+	{
+		ChannelTypes:   []int{channeltype.VertextAI},
+		ModelPrefixes:  []string{"claude"},
+		MaxTokensField: MaxTokens,
+		TemperatureMax: 1,
+		Drop:           []string{"presence_penalty", "frequency_penalty", "logprobs", "top_logprobs", "logit_bias", "seed"},
+	},
+	{
+		ChannelTypes:   []int{channeltype.Gemini, channeltype.VertextAI},
+		MaxTokensField: MaxTokens,
+		Drop:           []string{"logit_bias"},
+	},
+	{
+		ChannelTypes:   []int{channeltype.Zhipu, channeltype.Baidu, channeltype.BaiduV2},
+		MaxTokensField: MaxTokens,
+		TemperatureMax: 1,
+		Drop:           []string{"presence_penalty", "logprobs", "top_logprobs", "logit_bias"},
+	},
+	{
+		ChannelTypes: []int{
+			channeltype.Ali, channeltype.AliBailian, channeltype.Xunfei, channeltype.XunfeiV2, channeltype.Tencent,
+			channeltype.Moonshot, channeltype.Baichuan, channeltype.Minimax, channeltype.Mistral, channeltype.Ollama,
+			channeltype.Cohere, channeltype.DeepSeek, channeltype.Cloudflare, channeltype.Doubao, channeltype.StepFun,
+			channeltype.LingYiWanWu, channeltype.Replicate, channeltype.Coze,
+		},
+		MaxTokensField: MaxTokens,
+		Drop:           []string{"logit_bias", "top_logprobs"},
+	},
+}
+
+func (r *Rule) matches(channelType int, modelName string) bool {
+	if len(r.ChannelTypes) > 0 {
+		found := false
+		for _, t := range r.ChannelTypes {
+			if t == channelType {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.ModelPrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range r.ModelPrefixes {
+		if strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply translates the parameters of a request for a channel type and model. It tells whether
+// the request was changed and returns the parameters dropped.
+func Apply(channelType int, modelName string, request *model.GeneralOpenAIRequest) (changed bool, dropped []string) {
+	for i := range Rules {
+		rule := &Rules[i]
+		if !rule.matches(channelType, modelName) {
+			continue
+		}
+		switch rule.MaxTokensField {
+		case MaxTokens:
+			if request.MaxCompletionTokens != nil {
+				if request.MaxTokens == 0 {
+					request.MaxTokens = *request.MaxCompletionTokens
+				}
+				request.MaxCompletionTokens = nil
+				changed = true
+			}
+		case MaxCompletionTokens:
+			if request.MaxTokens != 0 {
+				if request.MaxCompletionTokens == nil {
+					maxTokens := request.MaxTokens
+					request.MaxCompletionTokens = &maxTokens
+				}
+				request.MaxTokens = 0
+				changed = true
+			}
+		}
+		if rule.TemperatureMax > 0 && request.Temperature != nil {
+			temperature := *request.Temperature * rule.TemperatureMax / 2
+			request.Temperature = &temperature
+			changed = true
+		}
+		for _, name := range rule.Drop {
+			if drop(request, name) {
+				dropped = append(dropped, name)
+				changed = true
+			}
+		}
+	}
+	return changed, dropped
+}
+
+// drop unsets a parameter of a request, and tells whether it was set
+func drop(request *model.GeneralOpenAIRequest, name string) bool {
+	switch name {
+	case "temperature":
+		set := request.Temperature != nil
+		request.Temperature = nil
+		return set
+	case "top_p":
+		set := request.TopP != nil
+		request.TopP = nil
+		return set
+	case "presence_penalty":
+		set := request.PresencePenalty != nil
+		request.PresencePenalty = nil
+		return set
+	case "frequency_penalty":
+		set := request.FrequencyPenalty != nil
+		request.FrequencyPenalty = nil
+		return set
+	case "logprobs":
+		set := request.Logprobs != nil
+		request.Logprobs = nil
+		return set
+	case "top_logprobs":
+		set := request.TopLogprobs != nil
+		request.TopLogprobs = nil
+		return set
+	case "logit_bias":
+		set := request.LogitBias != nil
+		request.LogitBias = nil
+		return set
+	case "seed":
+		set := request.Seed != 0
+		request.Seed = 0
+		return set
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package paramtranslate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestApplyReasoningModel(t *testing.T) {
+	request := &model.GeneralOpenAIRequest{MaxTokens: 100, Temperature: float(0.7), TopP: float(0.9)}
+	changed, dropped := Apply(channeltype.OpenAI, "o3-mini", request)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"temperature", "top_p"}, dropped)
+	assert.Equal(t, 0, request.MaxTokens)
+	assert.Equal(t, 100, *request.MaxCompletionTokens)
+	assert.Nil(t, request.Temperature)
+}
+
+func TestApplyAnthropic(t *testing.T) {
+	maxCompletionTokens := 200
+	request := &model.GeneralOpenAIRequest{MaxCompletionTokens: &maxCompletionTokens, Temperature: float(1.6), FrequencyPenalty: float(0.5)}
+	changed, dropped := Apply(channeltype.Anthropic, "claude-sonnet-4", request)
+	assert.True(t, changed)
+	assert.Equal(t, []string{"frequency_penalty"}, dropped)
+	assert.Equal(t, 200, request.MaxTokens)
+	assert.Nil(t, request.MaxCompletionTokens)
+	assert.InDelta(t, 0.8, *request.Temperature, 1e-9)
+}
+
+func TestApplyUnchanged(t *testing.T) {
+	request := &model.GeneralOpenAIRequest{MaxTokens: 100, Temperature: float(0.7), FrequencyPenalty: float(0.5)}
+	changed, dropped := Apply(channeltype.OpenAI, "gpt-4o-mini", request)
+	assert.False(t, changed)
+	assert.Empty(t, dropped)
+	assert.Equal(t, 100, request.MaxTokens)
+}
@@ -0,0 +1,264 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// Scripts run in a Lua state with the base, string, table and math libraries only, without
+// access to the files, the processes or the network. The JSON values given to a script are
+// Lua tables, and the tables it leaves are JSON values again.
+
+// Rejection is the error of a script calling reject(status, message), the request is answered
+// with them instead of being relayed
+type Rejection struct {
+	StatusCode int
+	Message    string
+}
+
+func (r *Rejection) Error() string {
+	return r.Message
+}
+
+// Compile parses and compiles a script
+func Compile(name string, source string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(source), name)
+	if err != nil {
+		return nil, err
+	}
+	return lua.Compile(chunk, name)
+}
+
+// unsafeBaseFunctions load code from files or strings
+var unsafeBaseFunctions = []string{"dofile", "loadfile", "load", "loadstring", "require", "module", "collectgarbage"}
+
+const (
+	callStackSize   = 128
+	registrySize    = 8 * 1024
+	maxStringLength = 16 << 20 // the longest string string.rep builds
+	maxTableDepth   = 64       // the deepest nesting of the tables converted to JSON
+)
+
+var errTableCycle = errors.New("the script left a table containing itself")
+var errTableDepth = fmt.Errorf("the script left tables nested deeper than %d", maxTableDepth)
+
+func newState(ctx context.Context) *lua.LState {
+	L := lua.NewState(lua.Options{
+		SkipOpenLibs:    true,
+		CallStackSize:   callStackSize,
+		RegistrySize:    registrySize,
+		RegistryMaxSize: registrySize,
+	})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range unsafeBaseFunctions {
+		L.SetGlobal(name, lua.LNil)
+	}
+	if stringLib, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		stringLib.RawSetString("rep", L.NewFunction(stringRep))
+	}
+	L.SetGlobal("reject", L.NewFunction(func(L *lua.LState) int {
+		status := L.OptInt(1, 400)
+		message := L.OptString(2, "rejected by a plugin")
+		L.RaiseError("%s", encodeRejection(status, message))
+		return 0
+	}))
+	L.SetContext(ctx)
+	return L
+}
+
+// stringRep is string.rep refusing to build strings longer than maxStringLength
+func stringRep(L *lua.LState) int {
+	str := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 || str == "" {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if n > maxStringLength/len(str) {
+		L.RaiseError("string.rep: the string would be longer than %d bytes", maxStringLength)
+		return 0
+	}
+	L.Push(lua.LString(strings.Repeat(str, n)))
+	return 1
+}
+
+const rejectionPrefix = "plugin rejection:"
+
+func encodeRejection(status int, message string) string {
+	return fmt.Sprintf("%s%d:%s", rejectionPrefix, status, message)
+}
+
+// asRejection finds the rejection raised by reject in the error of a script
+func asRejection(err error) *Rejection {
+	text := err.Error()
+	i := strings.Index(text, rejectionPrefix)
+	if i < 0 {
+		return nil
+	}
+	var status int
+	rest := text[i+len(rejectionPrefix):]
+	if _, scanErr := fmt.Sscanf(rest, "%d:", &status); scanErr != nil {
+		return nil
+	}
+	message := rest[strings.Index(rest, ":")+1:]
+	// the error of gopher-lua ends with the stack traceback
+	if end := strings.Index(message, "\nstack traceback:"); end >= 0 {
+		message = message[:end]
+	}
+	return &Rejection{StatusCode: status, Message: message}
+}
+
+// Run runs a script with globals and returns the value it returns and the globals named by
+// outputs as they are after it. A script calling reject returns a *Rejection error.
+func Run(ctx context.Context, proto *lua.FunctionProto, globals map[string]any, outputs ...string) (any, map[string]any, error) {
+	L := newState(ctx)
+	defer L.Close()
+	for name, value := range globals {
+		L.SetGlobal(name, toLua(L, value))
+	}
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, 1, nil); err != nil {
+		if rejection := asRejection(err); rejection != nil {
+			return nil, nil, rejection
+		}
+		return nil, nil, err
+	}
+	result, err := fromLua(L.Get(-1), nil, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	L.Pop(1)
+	values := make(map[string]any, len(outputs))
+	for _, name := range outputs {
+		if values[name], err = fromLua(L.GetGlobal(name), nil, 0); err != nil {
+			return nil, nil, err
+		}
+	}
+	return result, values, nil
+}
+
+// toLua converts a JSON value, as decoded by encoding/json, to a Lua value
+func toLua(L *lua.LState, value any) lua.LValue {
+	switch value := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(value)
+	case string:
+		return lua.LString(value)
+	case float64:
+		return lua.LNumber(value)
+	case int:
+		return lua.LNumber(value)
+	case int64:
+		return lua.LNumber(value)
+	case json.Number:
+		number, _ := value.Float64()
+		return lua.LNumber(number)
+	case []any:
+		table := L.CreateTable(len(value), 0)
+		for _, item := range value {
+			table.Append(toLua(L, item))
+		}
+		// keeps the arrays left empty arrays
+		metatable := L.CreateTable(0, 1)
+		metatable.RawSetString(arrayMarker, lua.LTrue)
+		L.SetMetatable(table, metatable)
+		return table
+	case map[string]any:
+		table := L.CreateTable(0, len(value))
+		for key, item := range value {
+			table.RawSetString(key, toLua(L, item))
+		}
+		return table
+	default:
+		return lua.LString(fmt.Sprint(value))
+	}
+}
+
+// fromLua converts a Lua value to a JSON value, the tables whose keys are 1 to n are arrays.
+// parents are the tables value is nested in, a table nested in itself is an error.
+func fromLua(value lua.LValue, parents map[*lua.LTable]bool, depth int) (any, error) {
+	switch value := value.(type) {
+	case lua.LBool:
+		return bool(value), nil
+	case lua.LString:
+		return string(value), nil
+	case lua.LNumber:
+		number := float64(value)
+		if number == math.Trunc(number) && math.Abs(number) < 1<<53 {
+			return int64(number), nil
+		}
+		return number, nil
+	case *lua.LTable:
+		if parents[value] {
+			return nil, errTableCycle
+		}
+		if depth >= maxTableDepth {
+			return nil, errTableDepth
+		}
+		if parents == nil {
+			parents = make(map[*lua.LTable]bool)
+		}
+		parents[value] = true
+		defer delete(parents, value)
+		if n := value.Len(); countKeys(value) == n && (n > 0 || isArray(value)) {
+			array := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				item, err := fromLua(value.RawGetInt(i), parents, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, item)
+			}
+			return array, nil
+		}
+		object := make(map[string]any)
+		var err error
+		value.ForEach(func(key lua.LValue, item lua.LValue) {
+			if err == nil {
+				object[key.String()], err = fromLua(item, parents, depth+1)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		return object, nil
+	default:
+		return nil, nil
+	}
+}
+
+const arrayMarker = "__array"
+
+func isArray(table *lua.LTable) bool {
+	metatable, ok := table.Metatable.(*lua.LTable)
+	return ok && metatable.RawGetString(arrayMarker) == lua.LTrue
+}
+
+func countKeys(table *lua.LTable) int {
+	count := 0
+	table.ForEach(func(lua.LValue, lua.LValue) {
+		count++
+	})
+	return count
+}
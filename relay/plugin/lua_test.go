@@ -0,0 +1,91 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func run(t *testing.T, source string, globals map[string]any, outputs ...string) (any, map[string]any, error) {
+	proto, err := Compile("test", source)
+	require.NoError(t, err)
+	return Run(context.Background(), proto, globals, outputs...)
+}
+
+func TestRunChangesGlobals(t *testing.T) {
+	request := map[string]any{
+		"model":       "gpt-4o",
+		"max_tokens":  4096.0,
+		"temperature": 0.5,
+		"tools":       []any{},
+		"messages":    []any{map[string]any{"role": "user", "content": "hi"}},
+	}
+	source := `
+request.max_tokens = math.min(request.max_tokens, 1024)
+table.insert(request.messages, 1, {role = "system", content = "be brief"})
+return #request.messages
+`
+	result, values, err := run(t, source, map[string]any{"request": request}, "request")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), result)
+	changed := values["request"].(map[string]any)
+	assert.Equal(t, int64(1024), changed["max_tokens"])
+	assert.Equal(t, 0.5, changed["temperature"])
+	assert.Equal(t, []any{}, changed["tools"])
+	messages := changed["messages"].([]any)
+	require.Len(t, messages, 2)
+	assert.Equal(t, map[string]any{"role": "system", "content": "be brief"}, messages[0])
+}
+
+func TestRunReject(t *testing.T) {
+	_, _, err := run(t, `reject(403, "not allowed: " .. context.group)`, map[string]any{"context": map[string]any{"group": "free"}})
+	var rejection *Rejection
+	require.True(t, errors.As(err, &rejection))
+	assert.Equal(t, 403, rejection.StatusCode)
+	assert.Equal(t, "not allowed: free", rejection.Message)
+}
+
+func TestRunSandbox(t *testing.T) {
+	for _, source := range []string{
+		`os.exit(1)`,
+		`io.open("/etc/passwd")`,
+		`require("os")`,
+		`loadstring("return 1")()`,
+		// the tables are converted to JSON, a cycle or a deep nesting must fail instead of
+		// overflowing the Go stack
+		`local t = {}; t.self = t; return t`,
+		`local t = {}; t[1] = {t}; return t`,
+		`local t = {}; local c = t; for i = 1, 100 do c.next = {}; c = c.next end; return t`,
+		// the memory and the stacks of a script are bounded
+		`return string.rep("x", 1e10)`,
+		`return ("x"):rep(1e10)`,
+		`local function f() return 1 + f() end; return f()`,
+	} {
+		_, _, err := run(t, source, nil)
+		var rejection *Rejection
+		assert.Error(t, err, source)
+		assert.False(t, errors.As(err, &rejection), source)
+	}
+}
+
+func TestRunSharedTable(t *testing.T) {
+	// a table referenced twice is not a cycle
+	result, _, err := run(t, `local shared = {a = 1}; return {x = shared, y = shared}`, nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]any{"x": map[string]any{"a": int64(1)}, "y": map[string]any{"a": int64(1)}}, result)
+}
+
+func TestRunTimeout(t *testing.T) {
+	proto, err := Compile("loop", `while true do end`)
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = Run(ctx, proto, nil)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+}
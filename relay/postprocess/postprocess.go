@@ -0,0 +1,163 @@
+package postprocess
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// Names of the built-in processors
+const (
+	StripWatermarks = "strip_watermarks" // removes the invisible characters providers mark their outputs with
+	Markdown        = "markdown"         // closes the code fences left open
+	JSON            = "json"             // keeps the JSON value of the output only, held back until it is complete
+	ProfanityMask   = "profanity_mask"   // masks the profane words with asterisks
+	Footer          = "footer"           // appends the footer of the group
+)
+
+var names = []string{StripWatermarks, Markdown, JSON, ProfanityMask, Footer}
+
+// Processor rewrites the content of a choice of a completion, piece by piece as it is streamed
+type Processor interface {
+	// Process returns the text to send for a piece of the content, it may hold part of it back
+	Process(text string) string
+	// Flush returns the text held back and the text appended once the content is complete
+	Flush() string
+}
+
+// Config is how the responses of the requests of a group are post-processed
+type Config struct {
+	Processors     []string `json:"processors"`
+	Footer         string   `json:"footer,omitempty"`          // text appended by the footer processor
+	ProfanityWords []string `json:"profanity_words,omitempty"` // words masked by profanity_mask, a built-in list by default
+}
+
+func (cfg Config) Validate() error {
+	return ValidateNames(cfg.Processors)
+}
+
+// ValidateNames checks the names of processors
+func ValidateNames(processors []string) error {
+	for _, name := range processors {
+		known := false
+		for _, n := range names {
+			if name == n {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown post-processor %s", name)
+		}
+	}
+	return nil
+}
+
+// SplitNames splits comma separated names of processors
+func SplitNames(value string) []string {
+	var processors []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			processors = append(processors, name)
+		}
+	}
+	return processors
+}
+
+var groupPostProcessingLock sync.RWMutex
+
+// GroupPostProcessing is the post-processing of each group, the groups not in it have none
+var GroupPostProcessing = map[string]Config{}
+
+func GroupPostProcessing2JSONString() string {
+	groupPostProcessingLock.RLock()
+	defer groupPostProcessingLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupPostProcessing)
+	if err != nil {
+		logger.SysError("error marshalling group post-processing: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func parseGroupPostProcessing(jsonStr string) (map[string]Config, error) {
+	configs := make(map[string]Config)
+	if err := json.Unmarshal([]byte(jsonStr), &configs); err != nil {
+		return nil, err
+	}
+	for group, cfg := range configs {
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("group %s: %s", group, err.Error())
+		}
+	}
+	return configs, nil
+}
+
+// ValidateGroupPostProcessing checks the JSON of GroupPostProcessing
+func ValidateGroupPostProcessing(jsonStr string) error {
+	_, err := parseGroupPostProcessing(jsonStr)
+	return err
+}
+
+func UpdateGroupPostProcessingByJSONString(jsonStr string) error {
+	configs, err := parseGroupPostProcessing(jsonStr)
+	if err != nil {
+		return err
+	}
+	groupPostProcessingLock.Lock()
+	GroupPostProcessing = configs
+	groupPostProcessingLock.Unlock()
+	return nil
+}
+
+// Resolve returns the names of the processors of a request with the config of its group. The
+// processors of the token, comma separated, replace those of the group.
+func Resolve(tokenProcessors string, group string) ([]string, Config) {
+	groupPostProcessingLock.RLock()
+	cfg := GroupPostProcessing[group]
+	groupPostProcessingLock.RUnlock()
+	if processors := SplitNames(tokenProcessors); len(processors) > 0 {
+		return processors, cfg
+	}
+	return cfg.Processors, cfg
+}
+
+// New returns a new chain of processors, for the content of one choice
+func New(processors []string, cfg Config) Processor {
+	processorChain := make(chain, 0, len(processors))
+	for _, name := range processors {
+		switch name {
+		case StripWatermarks:
+			processorChain = append(processorChain, watermarkStripper{})
+		case Markdown:
+			processorChain = append(processorChain, &fenceCloser{})
+		case JSON:
+			processorChain = append(processorChain, &jsonExtractor{})
+		case ProfanityMask:
+			processorChain = append(processorChain, newProfanityMasker(cfg.ProfanityWords))
+		case Footer:
+			processorChain = append(processorChain, footer(cfg.Footer))
+		}
+	}
+	return processorChain
+}
+
+// chain runs processors one after the other
+type chain []Processor
+
+func (c chain) Process(text string) string {
+	for _, p := range c {
+		text = p.Process(text)
+	}
+	return text
+}
+
+func (c chain) Flush() string {
+	tail := ""
+	for _, p := range c {
+		tail = p.Process(tail) + p.Flush()
+	}
+	return tail
+}
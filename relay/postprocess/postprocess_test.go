@@ -0,0 +1,51 @@
+package postprocess
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// run processes the pieces of a streamed content and returns the text sent
+func run(p Processor, pieces ...string) string {
+	output := ""
+	for _, piece := range pieces {
+		output += p.Process(piece)
+	}
+	return output + p.Flush()
+}
+
+func TestProfanityMaskAcrossPieces(t *testing.T) {
+	p := New([]string{ProfanityMask}, Config{})
+	assert.Equal(t, "well **** it, Shitake", run(p, "well sh", "it it, Shi", "take"))
+}
+
+func TestStripWatermarks(t *testing.T) {
+	p := New([]string{StripWatermarks}, Config{})
+	assert.Equal(t, "hello world", run(p, "hel\u200blo", " wor\U000E0041ld\ufeff"))
+}
+
+func TestMarkdownClosesFence(t *testing.T) {
+	p := New([]string{Markdown}, Config{})
+	assert.Equal(t, "```go\nfmt.Println()\n```", run(p, "```go\nfmt.", "Println()"))
+	p = New([]string{Markdown}, Config{})
+	assert.Equal(t, "```\ncode\n```\n", run(p, "```\ncode\n", "```\n"))
+}
+
+func TestJSONAndFooterChain(t *testing.T) {
+	p := New([]string{JSON, Footer}, Config{Footer: "\n-- Acme"})
+	assert.Equal(t, "", p.Process("Sure:\n```json\n{\"a\": "))
+	assert.Equal(t, "", p.Process("1}\n```"))
+	assert.Equal(t, "{\"a\": 1}\n-- Acme", p.Flush())
+	assert.Equal(t, "no json here", ExtractJSON("no json here"))
+}
+
+func TestResolve(t *testing.T) {
+	assert.Nil(t, UpdateGroupPostProcessingByJSONString(`{"vip": {"processors": ["footer"], "footer": "x"}}`))
+	processors, cfg := Resolve("", "vip")
+	assert.Equal(t, []string{Footer}, processors)
+	assert.Equal(t, "x", cfg.Footer)
+	processors, _ = Resolve("markdown, json", "vip")
+	assert.Equal(t, []string{Markdown, JSON}, processors)
+	assert.Error(t, ValidateGroupPostProcessing(`{"vip": {"processors": ["nope"]}}`))
+}
@@ -0,0 +1,202 @@
+package postprocess
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// watermarkStripper removes the zero-width and tag characters hidden in the text
+type watermarkStripper struct{}
+
+func isWatermark(r rune) bool {
+	switch {
+	case r >= 0x200B && r <= 0x200F, // zero-width spaces, joiners and marks
+		r >= 0x202A && r <= 0x202E,   // bidirectional embeddings
+		r >= 0x2060 && r <= 0x2064,   // word joiner and invisible operators
+		r == 0xFEFF,                  // zero-width no-break space
+		r >= 0xE0000 && r <= 0xE007F: // tag characters
+		return true
+	}
+	return false
+}
+
+func (watermarkStripper) Process(text string) string {
+	return strings.Map(func(r rune) rune {
+		if isWatermark(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+func (watermarkStripper) Flush() string {
+	return ""
+}
+
+// fenceCloser closes the markdown code fence a truncated output leaves open
+type fenceCloser struct {
+	line    strings.Builder // the line being streamed
+	inFence bool
+}
+
+func (f *fenceCloser) Process(text string) string {
+	for _, r := range text {
+		if r != '\n' {
+			f.line.WriteRune(r)
+			continue
+		}
+		f.endLine()
+	}
+	return text
+}
+
+func (f *fenceCloser) endLine() {
+	if strings.HasPrefix(strings.TrimSpace(f.line.String()), "```") {
+		f.inFence = !f.inFence
+	}
+	f.line.Reset()
+}
+
+func (f *fenceCloser) Flush() string {
+	partial := f.line.Len() > 0
+	f.endLine()
+	if !f.inFence {
+		return ""
+	}
+	f.inFence = false
+	if partial {
+		return "\n```"
+	}
+	return "```"
+}
+
+// jsonExtractor holds the output back and keeps its JSON value, without the code fence or
+// the text around it, outputs without a JSON value are kept as they are
+type jsonExtractor struct {
+	output strings.Builder
+}
+
+func (j *jsonExtractor) Process(text string) string {
+	j.output.WriteString(text)
+	return ""
+}
+
+func (j *jsonExtractor) Flush() string {
+	output := j.output.String()
+	j.output.Reset()
+	return ExtractJSON(output)
+}
+
+// ExtractJSON returns the JSON value in a text, the text when it has none
+func ExtractJSON(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if json.Valid([]byte(trimmed)) {
+		return trimmed
+	}
+	start := strings.IndexAny(trimmed, "{[")
+	if start < 0 {
+		return text
+	}
+	closing := "}"
+	if trimmed[start] == '[' {
+		closing = "]"
+	}
+	end := strings.LastIndex(trimmed, closing)
+	if end < start {
+		return text
+	}
+	if candidate := trimmed[start : end+1]; json.Valid([]byte(candidate)) {
+		return candidate
+	}
+	return text
+}
+
+var defaultProfanityWords = []string{
+	"fuck", "fucking", "fucker", "motherfucker", "shit", "bullshit", "bitch", "bastard",
+	"asshole", "dick", "cunt", "damn", "crap", "piss", "slut", "whore",
+}
+
+// profanityMasker masks the profane words, the letters at the end of a piece are held back
+// until the word they start is complete
+type profanityMasker struct {
+	words   map[string]bool
+	pending string
+}
+
+func newProfanityMasker(words []string) *profanityMasker {
+	if len(words) == 0 {
+		words = defaultProfanityWords
+	}
+	m := &profanityMasker{words: make(map[string]bool, len(words))}
+	for _, word := range words {
+		m.words[strings.ToLower(strings.TrimSpace(word))] = true
+	}
+	return m
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '\''
+}
+
+func (m *profanityMasker) Process(text string) string {
+	text = m.pending + text
+	// holds back the word which may go on in the next piece
+	cut := len(text)
+	for cut > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:cut])
+		if !isWordRune(r) {
+			break
+		}
+		cut -= size
+	}
+	m.pending = text[cut:]
+	return m.mask(text[:cut])
+}
+
+func (m *profanityMasker) Flush() string {
+	text := m.pending
+	m.pending = ""
+	return m.mask(text)
+}
+
+func (m *profanityMasker) mask(text string) string {
+	var masked strings.Builder
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			masked.WriteString(m.maskWord(text[start:i]))
+			start = -1
+		}
+		masked.WriteRune(r)
+	}
+	if start >= 0 {
+		masked.WriteString(m.maskWord(text[start:]))
+	}
+	return masked.String()
+}
+
+func (m *profanityMasker) maskWord(word string) string {
+	if !m.words[strings.ToLower(word)] {
+		return word
+	}
+	return strings.Repeat("*", utf8.RuneCountInString(word))
+}
+
+// footer appends a text to the output
+type footer string
+
+func (f footer) Process(text string) string {
+	return text
+}
+
+func (f footer) Flush() string {
+	return string(f)
+}
@@ -11,6 +11,8 @@ const (
 	AudioSpeech
 	AudioTranscription
 	AudioTranslation
+	Responses
 	// Proxy is a special relay mode for proxying requests to custom upstream
 	Proxy
+	ImagesEdits
 )
@@ -4,6 +4,7 @@ import (
 	"github.com/songquanpeng/one-api/controller"
 	"github.com/songquanpeng/one-api/controller/auth"
 	"github.com/songquanpeng/one-api/middleware"
+	"github.com/songquanpeng/one-api/model"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
@@ -13,9 +14,11 @@ func SetApiRouter(router *gin.Engine) {
 	apiRouter := router.Group("/api")
 	apiRouter.Use(gzip.Gzip(gzip.DefaultCompression))
 	apiRouter.Use(middleware.GlobalAPIRateLimit())
+	apiRouter.Use(middleware.EndpointRateLimit())
 	{
 		apiRouter.GET("/status", controller.GetStatus)
 		apiRouter.GET("/models", middleware.UserAuth(), controller.DashboardListModels)
+		apiRouter.GET("/models/catalog", middleware.UserAuth(), controller.ListModelCatalog)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/about", controller.GetAbout)
 		apiRouter.GET("/home_page_content", controller.GetHomePageContent)
@@ -29,7 +32,7 @@ func SetApiRouter(router *gin.Engine) {
 		apiRouter.GET("/oauth/wechat", middleware.CriticalRateLimit(), auth.WeChatAuth)
 		apiRouter.GET("/oauth/wechat/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), auth.WeChatBind)
 		apiRouter.GET("/oauth/email/bind", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.EmailBind)
-		apiRouter.POST("/topup", middleware.AdminAuth(), controller.AdminTopUp)
+		apiRouter.POST("/topup", middleware.RequirePermission(model.PermissionBillingWrite), controller.AdminTopUp)
 
 		userRoute := apiRouter.Group("/user")
 		{
@@ -48,10 +51,12 @@ func SetApiRouter(router *gin.Engine) {
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
 				selfRoute.GET("/available_models", controller.GetUserAvailableModels)
+				selfRoute.GET("/defaults", controller.GetSelfRequestDefaults)
+				selfRoute.PUT("/defaults", controller.UpdateSelfRequestDefaults)
 			}
 
 			adminRoute := userRoute.Group("/")
-			adminRoute.Use(middleware.AdminAuth())
+			adminRoute.Use(middleware.PermissionAuth("users"))
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/search", controller.SearchUsers)
@@ -63,26 +68,59 @@ func SetApiRouter(router *gin.Engine) {
 			}
 		}
 		optionRoute := apiRouter.Group("/option")
-		optionRoute.Use(middleware.RootAuth())
+		optionRoute.Use(middleware.PermissionAuth("settings"))
 		{
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
 		}
+		settingRoute := apiRouter.Group("/settings")
+		settingRoute.Use(middleware.PermissionAuth("settings"))
+		{
+			settingRoute.GET("/", controller.GetSettings)
+			settingRoute.PUT("/", controller.UpdateSettings)
+		}
+		configRoute := apiRouter.Group("/config")
+		configRoute.Use(middleware.PermissionAuth("settings"))
+		{
+			configRoute.GET("/export", controller.ExportGatewayConfig)
+			configRoute.POST("/apply", controller.ApplyGatewayConfig)
+		}
 		channelRoute := apiRouter.Group("/channel")
-		channelRoute.Use(middleware.AdminAuth())
+		channelRoute.Use(middleware.PermissionAuth("channels"))
 		{
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ListAllModels)
+			channelRoute.GET("/margin", controller.GetChannelMargins)
+			channelRoute.GET("/invoice", controller.GetChannelInvoices)
+			channelRoute.POST("/invoice", controller.ImportChannelInvoices)
+			channelRoute.DELETE("/invoice/:id", controller.DeleteChannelInvoice)
+			channelRoute.GET("/invoice/reconciliation", controller.GetChannelReconciliation)
+			channelRoute.GET("/export", middleware.RequirePermission(model.PermissionChannelsWrite), controller.ExportChannels)
+			channelRoute.POST("/import", controller.ImportChannels)
+			channelRoute.GET("/tag", controller.GetChannelTags)
+			channelRoute.PUT("/tag", controller.SaveChannelTag)
+			channelRoute.DELETE("/tag/:tag", controller.DeleteChannelTag)
+			channelRoute.POST("/tag/:tag/:action", controller.UpdateChannelsByTag)
+			channelRoute.GET("/canary", controller.GetAllChannelCanaries)
+			channelRoute.POST("/canary", controller.AddChannelCanary)
+			channelRoute.POST("/canary/:id/restart", controller.RestartChannelCanary)
+			channelRoute.DELETE("/canary/:id", controller.DeleteChannelCanary)
 			channelRoute.GET("/:id", controller.GetChannel)
-			channelRoute.GET("/test", controller.TestChannels)
-			channelRoute.GET("/test/:id", controller.TestChannel)
-			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
-			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+			channelRoute.GET("/test", middleware.RequirePermission(model.PermissionChannelsWrite), controller.TestChannels)
+			channelRoute.GET("/test/:id", middleware.RequirePermission(model.PermissionChannelsWrite), controller.TestChannel)
+			channelRoute.GET("/test/:id/deployments", middleware.RequirePermission(model.PermissionChannelsWrite), controller.TestChannelDeployments)
+			channelRoute.POST("/:id/test", controller.TestChannelModel)
+			channelRoute.GET("/:id/fetch_models", middleware.RequirePermission(model.PermissionChannelsWrite), controller.FetchChannelModels)
+			channelRoute.POST("/:id/fetch_models", controller.FetchChannelModels)
+			channelRoute.GET("/update_balance", middleware.RequirePermission(model.PermissionChannelsWrite), controller.UpdateAllChannelsBalance)
+			channelRoute.GET("/update_balance/:id", middleware.RequirePermission(model.PermissionChannelsWrite), controller.UpdateChannelBalance)
 			channelRoute.POST("/", controller.AddChannel)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.DELETE("/disabled", controller.DeleteDisabledChannel)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
+			channelRoute.POST("/:id/restore", controller.RestoreChannel)
+			channelRoute.DELETE("/:id/purge", controller.PurgeChannel)
 		}
 		tokenRoute := apiRouter.Group("/token")
 		tokenRoute.Use(middleware.UserAuth())
@@ -93,9 +131,15 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
+			tokenRoute.POST("/:id/restore", controller.RestoreToken)
+			tokenRoute.DELETE("/:id/purge", controller.PurgeToken)
+			tokenRoute.POST("/:id/rotate", controller.RotateToken)
+			tokenRoute.GET("/:id/defaults", controller.GetTokenRequestDefaults)
+			tokenRoute.PUT("/:id/defaults", controller.UpdateTokenRequestDefaults)
 		}
+		apiRouter.POST("/token/revoke", middleware.CriticalRateLimit(), controller.RevokeLeakedToken)
 		redemptionRoute := apiRouter.Group("/redemption")
-		redemptionRoute.Use(middleware.AdminAuth())
+		redemptionRoute.Use(middleware.PermissionAuth("billing"))
 		{
 			redemptionRoute.GET("/", controller.GetAllRedemptions)
 			redemptionRoute.GET("/search", controller.SearchRedemptions)
@@ -104,22 +148,186 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		paymentRoute := apiRouter.Group("/payment")
+		{
+			paymentRoute.POST("/callback/:provider", controller.PaymentCallback)
+			paymentRoute.GET("/", middleware.RequirePermission(model.PermissionBillingRead), controller.GetAllPayments)
+			paymentRoute.GET("/self", middleware.UserAuth(), controller.GetSelfPayments)
+			paymentRoute.POST("/self", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.CreatePayment)
+			paymentRoute.GET("/self/:trade_no/receipt", middleware.UserAuth(), controller.GetPaymentReceipt)
+		}
+		statementRoute := apiRouter.Group("/statement")
+		{
+			statementRoute.GET("/", middleware.RequirePermission(model.PermissionBillingRead), controller.GetStatement)
+			statementRoute.GET("/self", middleware.UserAuth(), controller.GetSelfStatement)
+			statementRoute.POST("/self/email", middleware.CriticalRateLimit(), middleware.UserAuth(), controller.EmailSelfStatement)
+		}
+		promotionRoute := apiRouter.Group("/promotion")
+		{
+			promotionRoute.GET("/grant", middleware.RequirePermission(model.PermissionBillingRead), controller.GetPromoGrants)
+			promotionRoute.GET("/self", middleware.UserAuth(), controller.GetSelfPromotions)
+		}
 		logRoute := apiRouter.Group("/log")
-		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
-		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
-		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/", middleware.RequirePermission(model.PermissionLogsRead), controller.GetAllLogs)
+		logRoute.DELETE("/", middleware.RequirePermission(model.PermissionLogsWrite), controller.DeleteHistoryLogs)
+		logRoute.GET("/stat", middleware.RequirePermission(model.PermissionLogsRead), controller.GetLogsStat)
+		logRoute.GET("/retention", middleware.RequirePermission(model.PermissionLogsRead), controller.GetLogRetention)
+		logRoute.POST("/retention", middleware.RequirePermission(model.PermissionLogsWrite), controller.RunLogRetention)
+		logRoute.POST("/retention/partitions", middleware.RequirePermission(model.PermissionLogsWrite), controller.EnsureLogPartitions)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
-		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
+		logRoute.GET("/search", middleware.RequirePermission(model.PermissionLogsRead), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		apiRouter.GET("/logs/search", middleware.RequirePermission(model.PermissionLogsRead), controller.SearchLogsByCursor)
+		apiRouter.GET("/requests/:request_id/trace", middleware.RequirePermission(model.PermissionLogsRead), controller.GetRequestTrace)
+		apiRouter.GET("/selfcheck", middleware.RequirePermission(model.PermissionSystemRead), controller.GetSelfCheck)
+		apiRouter.GET("/overview", middleware.RequirePermission(model.PermissionLogsRead), controller.GetOverview)
+		debugRoute := apiRouter.Group("/debug")
+		debugRoute.Use(middleware.PermissionAuth("logs"))
+		{
+			debugRoute.GET("/capture/rules", controller.GetAllDebugCaptureRules)
+			debugRoute.POST("/capture/rules", controller.AddDebugCaptureRule)
+			debugRoute.DELETE("/capture/rules/:id", controller.DeleteDebugCaptureRule)
+			debugRoute.GET("/captures", controller.GetDebugCaptures)
+			debugRoute.GET("/captures/:request_id", controller.GetDebugCapture)
+		}
+		usageRoute := apiRouter.Group("/usage")
+		usageRoute.GET("/", middleware.UserAuth(), controller.GetUserUsage)
+		usageRoute.GET("/rollup", middleware.RequirePermission(model.PermissionLogsRead), controller.GetUsageRollups)
+		usageRoute.GET("/rollup/self", middleware.UserAuth(), controller.GetUserUsageRollups)
+		usageRoute.POST("/rollup/rebuild", middleware.RequirePermission(model.PermissionLogsWrite), controller.RebuildUsageRollups)
+		rateLimitRoute := apiRouter.Group("/ratelimits")
+		rateLimitRoute.Use(middleware.PermissionAuth("policies"))
+		{
+			rateLimitRoute.GET("/ip_policy", controller.GetRateLimitIPPolicy)
+			rateLimitRoute.PUT("/ip_policy", controller.UpdateRateLimitIPPolicy)
+			rateLimitRoute.GET("/", controller.GetAllRateLimits)
+			rateLimitRoute.GET("/:id", controller.GetRateLimit)
+			rateLimitRoute.POST("/", controller.AddRateLimit)
+			rateLimitRoute.PUT("/", controller.UpdateRateLimit)
+			rateLimitRoute.DELETE("/:id", controller.DeleteRateLimit)
+		}
+		moderationRoute := apiRouter.Group("/moderation")
+		moderationRoute.Use(middleware.PermissionAuth("policies"))
+		{
+			moderationRoute.GET("/", controller.GetAllModerationPolicies)
+			moderationRoute.GET("/:id", controller.GetModerationPolicy)
+			moderationRoute.POST("/", controller.AddModerationPolicy)
+			moderationRoute.PUT("/", controller.UpdateModerationPolicy)
+			moderationRoute.DELETE("/:id", controller.DeleteModerationPolicy)
+		}
+		webhookRoute := apiRouter.Group("/webhook")
+		webhookRoute.Use(middleware.PermissionAuth("policies"))
+		{
+			webhookRoute.GET("/", controller.GetAllWebhooks)
+			webhookRoute.GET("/:id", controller.GetWebhook)
+			webhookRoute.POST("/", controller.AddWebhook)
+			webhookRoute.PUT("/", controller.UpdateWebhook)
+			webhookRoute.DELETE("/:id", controller.DeleteWebhook)
+			webhookRoute.POST("/:id/test", controller.TestWebhook)
+		}
+		modelMetaRoute := apiRouter.Group("/model_meta")
+		modelMetaRoute.Use(middleware.PermissionAuth("models"))
+		{
+			modelMetaRoute.GET("/", controller.GetAllModelMetas)
+			modelMetaRoute.GET("/:id", controller.GetModelMeta)
+			modelMetaRoute.POST("/", controller.AddModelMeta)
+			modelMetaRoute.PUT("/", controller.UpdateModelMeta)
+			modelMetaRoute.DELETE("/:id", controller.DeleteModelMeta)
+		}
+		modelAliasRoute := apiRouter.Group("/model_alias")
+		modelAliasRoute.Use(middleware.PermissionAuth("models"))
+		{
+			modelAliasRoute.GET("/", controller.GetAllModelAliases)
+			modelAliasRoute.GET("/:id", controller.GetModelAlias)
+			modelAliasRoute.POST("/", controller.AddModelAlias)
+			modelAliasRoute.PUT("/", controller.UpdateModelAlias)
+			modelAliasRoute.DELETE("/:id", controller.DeleteModelAlias)
+		}
+		promptTemplateRoute := apiRouter.Group("/prompt_template")
+		promptTemplateRoute.Use(middleware.PermissionAuth("models"))
+		{
+			promptTemplateRoute.GET("/", controller.GetAllPromptTemplates)
+			promptTemplateRoute.GET("/:id", controller.GetPromptTemplate)
+			promptTemplateRoute.GET("/:id/versions", controller.GetPromptTemplateVersions)
+			promptTemplateRoute.POST("/", controller.AddPromptTemplate)
+			promptTemplateRoute.POST("/:id/rollback", controller.RollbackPromptTemplate)
+			promptTemplateRoute.PUT("/", controller.UpdatePromptTemplate)
+			promptTemplateRoute.DELETE("/:id", controller.DeletePromptTemplate)
+		}
+		experimentRoute := apiRouter.Group("/experiment")
+		experimentRoute.Use(middleware.PermissionAuth("models"))
+		{
+			experimentRoute.GET("/", controller.GetAllExperiments)
+			experimentRoute.GET("/:id", controller.GetExperiment)
+			experimentRoute.GET("/:id/results", controller.GetExperimentResults)
+			experimentRoute.POST("/", controller.AddExperiment)
+			experimentRoute.PUT("/", controller.UpdateExperiment)
+			experimentRoute.DELETE("/:id", controller.DeleteExperiment)
+		}
+		trafficMirrorRoute := apiRouter.Group("/mirror")
+		trafficMirrorRoute.Use(middleware.PermissionAuth("channels"))
+		{
+			trafficMirrorRoute.GET("/", controller.GetAllTrafficMirrors)
+			trafficMirrorRoute.GET("/:id", controller.GetTrafficMirror)
+			trafficMirrorRoute.GET("/:id/results", controller.GetTrafficMirrorResults)
+			trafficMirrorRoute.POST("/", controller.AddTrafficMirror)
+			trafficMirrorRoute.PUT("/", controller.UpdateTrafficMirror)
+			trafficMirrorRoute.DELETE("/:id", controller.DeleteTrafficMirror)
+		}
+		chaosRoute := apiRouter.Group("/chaos")
+		chaosRoute.Use(middleware.PermissionAuth("channels"))
+		{
+			chaosRoute.GET("/", controller.GetAllChaosRules)
+			chaosRoute.GET("/:id", controller.GetChaosRule)
+			chaosRoute.POST("/", controller.AddChaosRule)
+			chaosRoute.PUT("/", controller.UpdateChaosRule)
+			chaosRoute.DELETE("/:id", controller.DeleteChaosRule)
+		}
+		pluginRoute := apiRouter.Group("/plugin")
+		pluginRoute.Use(middleware.PermissionAuth("policies"))
+		{
+			pluginRoute.GET("/", controller.GetAllPlugins)
+			pluginRoute.GET("/:id", controller.GetPlugin)
+			pluginRoute.POST("/", controller.AddPlugin)
+			pluginRoute.PUT("/", controller.UpdatePlugin)
+			pluginRoute.DELETE("/:id", controller.DeletePlugin)
+		}
+		organizationRoute := apiRouter.Group("/organization")
+		{
+			selfRoute := organizationRoute.Group("/self")
+			selfRoute.Use(middleware.UserAuth())
+			{
+				selfRoute.GET("/", controller.GetSelfOrganization)
+				selfRoute.GET("/member", controller.GetSelfOrganizationMembers)
+				selfRoute.POST("/member", controller.AddSelfOrganizationMember)
+				selfRoute.PUT("/member", controller.UpdateSelfOrganizationMember)
+				selfRoute.DELETE("/member/:user_id", controller.RemoveSelfOrganizationMember)
+				selfRoute.GET("/token", controller.GetSelfOrganizationTokens)
+				selfRoute.POST("/token", controller.AddSelfOrganizationToken)
+				selfRoute.DELETE("/token/:id", controller.DeleteSelfOrganizationToken)
+				selfRoute.GET("/usage", controller.GetSelfOrganizationUsage)
+				selfRoute.GET("/statement", controller.GetSelfOrganizationStatement)
+			}
+
+			adminRoute := organizationRoute.Group("/")
+			adminRoute.Use(middleware.PermissionAuth("organizations"))
+			{
+				adminRoute.GET("/", controller.GetAllOrganizations)
+				adminRoute.GET("/:id", controller.GetOrganization)
+				adminRoute.POST("/", controller.AddOrganization)
+				adminRoute.PUT("/", controller.UpdateOrganization)
+				adminRoute.DELETE("/:id", controller.DeleteOrganization)
+			}
+		}
 		groupRoute := apiRouter.Group("/group")
-		groupRoute.Use(middleware.AdminAuth())
+		groupRoute.Use(middleware.PermissionAuth("system"))
 		{
 			groupRoute.GET("/", controller.GetGroups)
 		}
 		// Intelligence routes for AI-powered features dashboard
 		intelligenceRoute := apiRouter.Group("/intelligence")
-		intelligenceRoute.Use(middleware.AdminAuth())
+		intelligenceRoute.Use(middleware.PermissionAuth("system"))
 		{
 			intelligenceRoute.GET("/health", controller.GetIntelligenceHealth)
 			intelligenceRoute.GET("/channels", controller.GetChannelHealthDetails)
@@ -127,13 +335,54 @@ func SetApiRouter(router *gin.Engine) {
 			intelligenceRoute.GET("/strategies", controller.GetStrategies)
 		}
 		
+		poolRoute := apiRouter.Group("/pools")
+		poolRoute.Use(middleware.PermissionAuth("system"))
+		{
+			poolRoute.GET("/config", controller.GetPoolConfig)
+			poolRoute.PUT("/config", controller.UpdatePoolConfig)
+			poolRoute.GET("/stats", controller.GetPoolStats)
+			poolRoute.GET("/dns", controller.GetDNSCacheStats)
+		}
+
 		// Cache management routes
 		cacheRoute := apiRouter.Group("/cache")
-		cacheRoute.Use(middleware.AdminAuth())
+		cacheRoute.Use(middleware.PermissionAuth("system"))
 		{
 			cacheRoute.GET("/stats", controller.GetCacheStats)
+			cacheRoute.GET("/flights", controller.GetCacheFlights)
 			cacheRoute.POST("/clear", controller.ClearCache)
 			cacheRoute.POST("/toggle", controller.ToggleCache)
 		}
+
+		jobRoute := apiRouter.Group("/job")
+		jobRoute.Use(middleware.PermissionAuth("system"))
+		{
+			jobRoute.GET("/", controller.GetAllJobs)
+			jobRoute.POST("/:name/run", controller.RunJob)
+		}
+
+		rbacRoute := apiRouter.Group("/rbac")
+		{
+			rbacRoute.GET("/permissions", middleware.RequirePermission(model.PermissionUsersRead), controller.GetPermissionMatrix)
+			rbacRoute.GET("/self", middleware.UserAuth(), controller.GetSelfPermissions)
+			rbacRoute.GET("/role", middleware.RootAuth(), controller.GetAllCustomRoles)
+			rbacRoute.GET("/role/:id", middleware.RootAuth(), controller.GetCustomRole)
+			rbacRoute.POST("/role", middleware.RootAuth(), controller.AddCustomRole)
+			rbacRoute.PUT("/role", middleware.RootAuth(), controller.UpdateCustomRole)
+			rbacRoute.DELETE("/role/:id", middleware.RootAuth(), controller.DeleteCustomRole)
+			rbacRoute.GET("/binding", middleware.RootAuth(), controller.GetAllRoleBindings)
+			rbacRoute.POST("/binding", middleware.RootAuth(), controller.AddRoleBinding)
+			rbacRoute.DELETE("/binding/:id", middleware.RootAuth(), controller.DeleteRoleBinding)
+		}
+
+		tenantRoute := apiRouter.Group("/tenant")
+		tenantRoute.Use(middleware.RootAuth())
+		{
+			tenantRoute.GET("/", controller.GetAllTenants)
+			tenantRoute.GET("/:id", controller.GetTenant)
+			tenantRoute.POST("/", controller.AddTenant)
+			tenantRoute.PUT("/", controller.UpdateTenant)
+			tenantRoute.DELETE("/:id", controller.DeleteTenant)
+		}
 	}
 }
@@ -26,28 +26,62 @@ func SetRelayRouter(router *gin.Engine) {
 	modelsRouter.Use(middleware.TokenAuth())
 	{
 		modelsRouter.GET("", controller.ListModels)
+		modelsRouter.GET("/catalog", controller.ListModelCatalog)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	// https://docs.anthropic.com/en/api/messages
+	router.POST("/v1/messages", middleware.RelayPanicRecover(), middleware.RequestTrace(), middleware.AnthropicMessages(), middleware.TokenAuth(), middleware.LoadShedding(), middleware.RelayRateLimit(), middleware.EndpointRateLimit(), middleware.RelayQueue(), middleware.Distribute(), middleware.DebugCapture(), controller.Relay)
+	// https://ai.google.dev/api/generate-content
+	router.POST("/v1beta/models/*action", middleware.RelayPanicRecover(), middleware.RequestTrace(), middleware.GeminiGenerateContent(), middleware.TokenAuth(), middleware.LoadShedding(), middleware.RelayRateLimit(), middleware.EndpointRateLimit(), middleware.RelayQueue(), middleware.Distribute(), middleware.DebugCapture(), controller.Relay)
+	responsesRouter := router.Group("/v1/responses")
+	responsesRouter.Use(middleware.TokenAuth())
+	{
+		responsesRouter.GET("/:id", controller.GetResponse)
+		responsesRouter.DELETE("/:id", controller.DeleteResponse)
+		responsesRouter.POST("/:id/cancel", controller.CancelResponse)
+	}
+	// counts the tokens of a request without relaying it
+	router.POST("/v1/tokenize", middleware.TokenAuth(), controller.Tokenize)
+	// https://platform.openai.com/docs/api-reference/batch
+	filesRouter := router.Group("/v1/files")
+	filesRouter.Use(middleware.TokenAuth())
+	{
+		filesRouter.GET("", controller.ListFiles)
+		filesRouter.POST("", controller.UploadFile)
+		filesRouter.GET("/:id", controller.GetFile)
+		filesRouter.DELETE("/:id", controller.DeleteFile)
+		filesRouter.GET("/:id/content", controller.GetFileContent)
+	}
+	batchesRouter := router.Group("/v1/batches")
+	batchesRouter.Use(middleware.TokenAuth())
+	{
+		batchesRouter.GET("", controller.ListBatches)
+		batchesRouter.POST("", controller.CreateBatch)
+		batchesRouter.GET("/:id", controller.GetBatch)
+		batchesRouter.POST("/:id/cancel", controller.CancelBatch)
+	}
+	deferredRouter := router.Group("/v1/deferred")
+	deferredRouter.Use(middleware.TokenAuth())
+	{
+		deferredRouter.POST("", controller.CreateDeferredRequest)
+		deferredRouter.GET("/:id", controller.GetDeferredRequest)
+		deferredRouter.POST("/:id/cancel", controller.CancelDeferredRequest)
+	}
 	relayV1Router := router.Group("/v1")
-	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.RequestTrace(), middleware.TokenAuth(), middleware.LoadShedding(), middleware.RelayRateLimit(), middleware.EndpointRateLimit(), middleware.RelayQueue(), middleware.Distribute(), middleware.DebugCapture())
 	{
 		relayV1Router.Any("/oneapi/proxy/:channelid/*target", controller.Relay)
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
 		relayV1Router.POST("/edits", controller.Relay)
 		relayV1Router.POST("/images/generations", controller.Relay)
-		relayV1Router.POST("/images/edits", controller.RelayNotImplemented)
+		relayV1Router.POST("/images/edits", controller.Relay)
 		relayV1Router.POST("/images/variations", controller.RelayNotImplemented)
 		relayV1Router.POST("/embeddings", controller.Relay)
 		relayV1Router.POST("/engines/:model/embeddings", controller.Relay)
 		relayV1Router.POST("/audio/transcriptions", controller.Relay)
 		relayV1Router.POST("/audio/translations", controller.Relay)
 		relayV1Router.POST("/audio/speech", controller.Relay)
-		relayV1Router.GET("/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/files", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id/content", controller.RelayNotImplemented)
 		relayV1Router.POST("/fine_tuning/jobs", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine_tuning/jobs", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine_tuning/jobs/:id", controller.RelayNotImplemented)
@@ -55,6 +89,7 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.GET("/fine_tuning/jobs/:id/events", controller.RelayNotImplemented)
 		relayV1Router.DELETE("/models/:model", controller.RelayNotImplemented)
 		relayV1Router.POST("/moderations", controller.Relay)
+		relayV1Router.POST("/responses", controller.Relay)
 		relayV1Router.POST("/assistants", controller.RelayNotImplemented)
 		relayV1Router.GET("/assistants/:id", controller.RelayNotImplemented)
 		relayV1Router.POST("/assistants/:id", controller.RelayNotImplemented)
@@ -87,7 +122,7 @@ func SetRelayRouter(router *gin.Engine) {
 	// This allows clients to configure base URL as "http://your-server/v1" (like api.openai.com/v1)
 	// without creating duplicate /v1/v1 paths
 	relayRootRouter := router.Group("")
-	relayRootRouter.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	relayRootRouter.Use(middleware.RelayPanicRecover(), middleware.RequestTrace(), middleware.TokenAuth(), middleware.LoadShedding(), middleware.RelayRateLimit(), middleware.EndpointRateLimit(), middleware.RelayQueue(), middleware.Distribute(), middleware.DebugCapture())
 	{
 		// Models endpoints
 		relayRootRouter.GET("/models", controller.ListModels)
@@ -101,6 +136,7 @@ func SetRelayRouter(router *gin.Engine) {
 		
 		// Image generation
 		relayRootRouter.POST("/images/generations", controller.Relay)
+		relayRootRouter.POST("/images/edits", controller.Relay)
 		
 		// Audio endpoints
 		relayRootRouter.POST("/audio/transcriptions", controller.Relay)